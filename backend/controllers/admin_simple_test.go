@@ -1,8 +1,12 @@
 package controllers
 
 import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 
+	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
 	"golang.org/x/crypto/bcrypt"
 )
@@ -149,3 +153,24 @@ func TestBcryptConfiguration(t *testing.T) {
 	assert.True(t, len(hashedPassword) >= 7, "ハッシュは十分な長さが必要です")
 	assert.Equal(t, "$2", hashedPassword[:2], "bcryptハッシュは$2で始まる必要があります")
 }
+
+// TestSetUserSuspensionRequiresFlag は suspended を指定しないリクエストで停止が解除されないことをテストする
+func TestSetUserSuspensionRequiresFlag(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.PUT("/api/admin/users/:id/suspension", SetUserSuspension)
+
+	for name, body := range map[string]string{
+		"空のオブジェクト": "{}",
+		"null":     `{"suspended": null}`,
+		"空のボディ":    "",
+	} {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPut, "/api/admin/users/64b7f0c2a1b2c3d4e5f60718/suspension", strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+		})
+	}
+}
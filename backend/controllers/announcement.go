@@ -1,10 +1,18 @@
 package controllers
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
+	"strings"
 	"time"
+	"unicode/utf8"
+
+	"juice_academy_backend/middleware"
+	"juice_academy_backend/utils"
 
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson"
@@ -14,272 +22,559 @@ import (
 	// 必要なモデルやデータベースのインポート
 )
 
+// お知らせの公開状態
+const (
+	AnnouncementStatusDraft     = "draft"
+	AnnouncementStatusScheduled = "scheduled"
+	AnnouncementStatusPublished = "published"
+	AnnouncementStatusExpired   = "expired"
+)
+
+// お知らせの入力サイズの上限
+const (
+	maxAnnouncementTitleLength   = 200
+	maxAnnouncementContentLength = 20000
+	// maxAnnouncementHTMLBytes は変換後の HTML の上限（画像・リンクの多い本文でも十分な大きさ）
+	maxAnnouncementHTMLBytes = 512 << 10
+	// maxAnnouncementRequestBytes は作成・更新リクエストの本文の上限
+	maxAnnouncementRequestBytes = 256 << 10
+)
+
 // Announcement はお知らせのモデル構造体です
+// 公開中とみなされるのは is_published=true かつ publish_at を過ぎ、expires_at に達していないもの
 type Announcement struct {
-	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
-	Title     string             `json:"title" bson:"title"`
-	Content   string             `json:"content" bson:"content"`
-	CreatedAt time.Time          `json:"createdAt" bson:"created_at"`
-	UpdatedAt time.Time          `json:"updatedAt" bson:"updated_at"`
+	ID      primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Title   string             `json:"title" bson:"title"`
+	Content string             `json:"content" bson:"content"`
+	// ContentHTML は Content（Markdown）から生成した無害化済みの HTML
+	ContentHTML string                `json:"contentHtml" bson:"content_html"`
+	Category    string                `json:"category" bson:"category"`
+	Pinned      bool                  `json:"pinned" bson:"pinned"`
+	Important   bool                  `json:"important" bson:"important"`
+	Audience    *AnnouncementAudience `json:"audience,omitempty" bson:"audience,omitempty"`
+	// MembersOnly は premium_content の利用権を持つ契約者だけが本文・添付ファイルを閲覧できるお知らせか
+	// 利用権のない閲覧者の一覧にはタイトルなどだけを Locked として表示する
+	MembersOnly bool       `json:"membersOnly" bson:"members_only"`
+	IsPublished bool       `json:"isPublished" bson:"is_published"`
+	PublishAt   *time.Time `json:"publishAt,omitempty" bson:"publish_at,omitempty"`
+	ExpiresAt   *time.Time `json:"expiresAt,omitempty" bson:"expires_at,omitempty"`
+	// NotifyEmail はメール通知を希望したユーザーにダイジェストで送信するか
+	// 送信は公開中になった後にワーカーが行い、EmailSentAt を記録して二重送信を防ぐ
+	NotifyEmail bool       `json:"notifyEmail" bson:"notify_email"`
+	EmailSentAt *time.Time `json:"emailSentAt,omitempty" bson:"email_sent_at,omitempty"`
+	// Attachments は添付ファイル（アップロード・削除は専用のエンドポイントで行う）
+	Attachments []AnnouncementAttachment `json:"attachments,omitempty" bson:"attachments,omitempty"`
+	CreatedAt   time.Time                `json:"createdAt" bson:"created_at"`
+	UpdatedAt   time.Time                `json:"updatedAt" bson:"updated_at"`
+	// Version は編集のたびに増える版番号（announcement_revisions に各版の内容を保存する）
+	Version int `json:"version" bson:"version"`
+	// DeletedAt はゴミ箱に移動した日時（削除済みのお知らせは公開・管理一覧に現れない）
+	DeletedAt *time.Time          `json:"deletedAt,omitempty" bson:"deleted_at,omitempty"`
+	DeletedBy *primitive.ObjectID `json:"deletedBy,omitempty" bson:"deleted_by,omitempty"`
+
+	// Status はレスポンス時に算出する公開状態（保存しない）
+	Status string `json:"status,omitempty" bson:"-"`
+	// IsRead はログイン中の閲覧者が既読にしたか（公開一覧でのみ設定し、保存しない）
+	IsRead *bool `json:"isRead,omitempty" bson:"-"`
+	// Locked は会員限定のお知らせの本文を閲覧者に返していないか（公開一覧でのみ設定し、保存しない）
+	Locked bool `json:"locked,omitempty" bson:"-"`
+}
+
+// StatusAt は指定時刻における公開状態を返す
+func (a *Announcement) StatusAt(now time.Time) string {
+	switch {
+	case !a.IsPublished:
+		return AnnouncementStatusDraft
+	case a.PublishAt != nil && a.PublishAt.After(now):
+		return AnnouncementStatusScheduled
+	case a.ExpiresAt != nil && !a.ExpiresAt.After(now):
+		return AnnouncementStatusExpired
+	default:
+		return AnnouncementStatusPublished
+	}
+}
+
+// liveAnnouncementFilter は指定時刻に公開中のお知らせを絞り込む条件を返す
+// 予約公開は publish_at との比較で判定するため、公開時刻になれば操作なしで一覧に現れる
+func liveAnnouncementFilter(now time.Time) bson.M {
+	return bson.M{
+		"is_published": true,
+		"publish_at":   bson.M{"$lte": now},
+		"deleted_at":   nil,
+		"$or": []bson.M{
+			{"expires_at": nil},
+			{"expires_at": bson.M{"$gt": now}},
+		},
+	}
+}
+
+// announcementStatusFilter は管理画面の状態フィルタを検索条件に変換する
+// ゴミ箱に移動したお知らせはどの状態にも含めない
+func announcementStatusFilter(status string, now time.Time) (bson.M, bool) {
+	switch status {
+	case "":
+		return bson.M{"deleted_at": nil}, true
+	case AnnouncementStatusDraft:
+		return bson.M{"is_published": false, "deleted_at": nil}, true
+	case AnnouncementStatusScheduled:
+		return bson.M{"is_published": true, "publish_at": bson.M{"$gt": now}, "deleted_at": nil}, true
+	case AnnouncementStatusExpired:
+		return bson.M{"is_published": true, "expires_at": bson.M{"$lte": now}, "deleted_at": nil}, true
+	case AnnouncementStatusPublished:
+		return liveAnnouncementFilter(now), true
+	}
+	return nil, false
+}
+
+// optionalTime はJSONでキーが指定されたかどうかを区別する日時（null で解除できる）
+type optionalTime struct {
+	Set   bool
+	Value *time.Time
+}
+
+func (o *optionalTime) UnmarshalJSON(data []byte) error {
+	o.Set = true
+	if string(data) == "null" {
+		o.Value = nil
+		return nil
+	}
+	var t time.Time
+	if err := json.Unmarshal(data, &t); err != nil {
+		return err
+	}
+	o.Value = &t
+	return nil
+}
+
+// announcementInput はお知らせの作成・更新リクエスト
+// 更新時は指定された項目だけを変更する
+type announcementInput struct {
+	Title     *string `json:"title"`
+	Content   *string `json:"content"`
+	Category  *string `json:"category"`
+	Pinned    *bool   `json:"pinned"`
+	Important *bool   `json:"important"`
+	// Audience は空のオブジェクトを指定すると全員向けに戻る
+	Audience    *AnnouncementAudience `json:"audience"`
+	MembersOnly *bool                 `json:"membersOnly"`
+	IsPublished *bool                 `json:"isPublished"`
+	PublishAt   optionalTime          `json:"publishAt"`
+	ExpiresAt   optionalTime          `json:"expiresAt"`
+	NotifyEmail *bool                 `json:"notifyEmail"`
+}
+
+// apply は入力をお知らせに反映し、検証エラーがあればメッセージを返す
+func (in *announcementInput) apply(a *Announcement, now time.Time) string {
+	if in.Title != nil {
+		a.Title = strings.TrimSpace(*in.Title)
+	}
+	if in.Content != nil {
+		a.Content = *in.Content
+	}
+	if in.Category != nil {
+		a.Category = *in.Category
+	}
+	if in.Pinned != nil {
+		a.Pinned = *in.Pinned
+	}
+	if in.Important != nil {
+		a.Important = *in.Important
+	}
+	if in.Audience != nil {
+		audience, msg := normalizeAudience(in.Audience)
+		if msg != "" {
+			return msg
+		}
+		a.Audience = audience
+	}
+	if in.MembersOnly != nil {
+		a.MembersOnly = *in.MembersOnly
+	}
+	if in.IsPublished != nil {
+		a.IsPublished = *in.IsPublished
+	}
+	if in.PublishAt.Set {
+		a.PublishAt = in.PublishAt.Value
+	}
+	if in.ExpiresAt.Set {
+		a.ExpiresAt = in.ExpiresAt.Value
+	}
+	if in.NotifyEmail != nil {
+		a.NotifyEmail = *in.NotifyEmail
+	}
+
+	if a.Title == "" || strings.TrimSpace(a.Content) == "" {
+		return "タイトルと内容は必須です"
+	}
+	if utf8.RuneCountInString(a.Title) > maxAnnouncementTitleLength {
+		return fmt.Sprintf("タイトルは%d文字以内で入力してください", maxAnnouncementTitleLength)
+	}
+	if utf8.RuneCountInString(a.Content) > maxAnnouncementContentLength {
+		return fmt.Sprintf("内容は%d文字以内で入力してください", maxAnnouncementContentLength)
+	}
+	a.ContentHTML = utils.RenderMarkdown(a.Content)
+	if len(a.ContentHTML) > maxAnnouncementHTMLBytes {
+		return "内容が長すぎます"
+	}
+	if a.Category == "" {
+		a.Category = AnnouncementCategoryGeneral
+	}
+	if !announcementCategories[a.Category] {
+		return "無効なカテゴリです"
+	}
+	// 公開時刻の指定がなければ公開した時点を公開日時とする
+	if a.IsPublished && a.PublishAt == nil {
+		a.PublishAt = &now
+	}
+	if a.ExpiresAt != nil && a.PublishAt != nil && !a.ExpiresAt.After(*a.PublishAt) {
+		return "掲載終了日時は公開日時より後にしてください"
+	}
+	return ""
 }
 
 // announcementCollection はお知らせコレクションへの参照
 var announcementCollection *mongo.Collection
 
 // InitAnnouncementCollection はお知らせのコレクションを初期化します
+// 公開状態を持たない既存のお知らせは作成日時で公開済みとして扱うよう補完する
 func InitAnnouncementCollection(db *mongo.Database) {
 	announcementCollection = db.Collection("announcements")
-}
 
-// GetAnnouncementsHandler はお知らせ一覧を取得するハンドラ
-func GetAnnouncementsHandler(c *gin.Context) {
-	ctx := c.Request.Context()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := announcementCollection.UpdateMany(ctx,
+		bson.M{"is_published": bson.M{"$exists": false}},
+		mongo.Pipeline{{{Key: "$set", Value: bson.M{
+			"is_published": true,
+			"publish_at":   "$created_at",
+		}}}},
+	)
+	if err != nil {
+		utils.LogError("InitAnnouncementCollection", err, "failed to backfill publish state")
+	}
+	if err := backfillAnnouncementFlags(ctx); err != nil {
+		utils.LogError("InitAnnouncementCollection", err, "failed to backfill pinned/category fields")
+	}
+	if err := backfillAnnouncementHTML(ctx); err != nil {
+		utils.LogError("InitAnnouncementCollection", err, "failed to render content_html")
+	}
 
-	// 最新のお知らせから順に取得するためのオプション
-	findOptions := options.Find()
-	findOptions.SetSort(bson.M{"created_at": -1})
+	_, _ = announcementCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "is_published", Value: 1}, {Key: "publish_at", Value: -1}},
+			Options: options.Index().SetName("published_publish_at_idx"),
+		},
+		{
+			// ピン留め・重要を先頭にした公開中一覧の並び順
+			Keys: bson.D{
+				{Key: "is_published", Value: 1},
+				{Key: "pinned", Value: -1},
+				{Key: "important", Value: -1},
+				{Key: "publish_at", Value: -1},
+			},
+			Options: options.Index().SetName("published_pinned_publish_at_idx"),
+		},
+		{
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetName("expires_at_idx").SetSparse(true),
+		},
+		{
+			Keys:    bson.D{{Key: "deleted_at", Value: -1}},
+			Options: options.Index().SetName("deleted_at_idx").SetSparse(true),
+		},
+		{
+			Keys:    bson.D{{Key: "notify_email", Value: 1}, {Key: "email_sent_at", Value: 1}},
+			Options: options.Index().SetName("notify_email_pending_idx"),
+		},
+		{
+			// mongo-init/init.js と同じ定義（既定の名前）で作成し、q による全文検索に使用する
+			Keys: bson.D{{Key: "title", Value: "text"}, {Key: "content", Value: "text"}},
+		},
+	})
+}
 
-	// データベースからお知らせを取得
-	cursor, err := announcementCollection.Find(ctx, bson.M{}, findOptions)
+// backfillAnnouncementHTML は content_html を持たない既存のお知らせの本文を Markdown として変換する
+// 従来のプレーンテキストの本文も段落・改行を保ったまま表示される
+func backfillAnnouncementHTML(ctx context.Context) error {
+	cursor, err := announcementCollection.Find(ctx,
+		bson.M{"content_html": bson.M{"$exists": false}},
+		options.Find().SetProjection(bson.M{"content": 1}),
+	)
 	if err != nil {
-		// セキュリティ: 本番環境ではエラーの詳細をログに出力しない
-		if os.Getenv("APP_ENV") != "production" {
-			fmt.Printf("GetAnnouncementsHandler: お知らせ取得エラー: %v\n", err)
-		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "お知らせの取得に失敗しました"})
-		return
+		return err
 	}
 	defer cursor.Close(ctx)
 
-	// 結果を格納するスライス
-	var announcements []Announcement
-
-	// カーソルから結果を取得
-	if err := cursor.All(ctx, &announcements); err != nil {
-		// セキュリティ: 本番環境ではエラーの詳細をログに出力しない
-		if os.Getenv("APP_ENV") != "production" {
-			fmt.Printf("GetAnnouncementsHandler: お知らせデータ処理エラー: %v\n", err)
+	for cursor.Next(ctx) {
+		var doc struct {
+			ID      primitive.ObjectID `bson:"_id"`
+			Content string             `bson:"content"`
+		}
+		if err := cursor.Decode(&doc); err != nil {
+			return err
+		}
+		if _, err := announcementCollection.UpdateOne(ctx,
+			bson.M{"_id": doc.ID, "content_html": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"content_html": utils.RenderMarkdown(doc.Content)}},
+		); err != nil {
+			return err
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "お知らせデータの処理に失敗しました"})
-		return
 	}
+	return cursor.Err()
+}
 
-	// 空の配列の場合は空配列を返す（nullは返さない）
-	if announcements == nil {
-		announcements = []Announcement{}
+// withStatus は各お知らせに現在の公開状態を設定する
+func withStatus(announcements []Announcement, now time.Time) []Announcement {
+	for i := range announcements {
+		announcements[i].Status = announcements[i].StatusAt(now)
 	}
-
-	// フロントエンドに整合する形式でレスポンスを返す
-	// データをラップするか、直接配列を返すかは、フロントエンドの期待に合わせる
-	c.JSON(http.StatusOK, gin.H{
-		"announcements": announcements,
-		"count":         len(announcements),
-	})
+	return announcements
 }
 
-// CreateAnnouncementHandler は新規お知らせ作成を行うハンドラ（管理者専用）
-func CreateAnnouncementHandler(c *gin.Context) {
-	// リクエストボディをパース
-	var announcement Announcement
-	if err := c.ShouldBindJSON(&announcement); err != nil {
-		// セキュリティ: 本番環境ではエラーの詳細をログに出力しない
-		if os.Getenv("APP_ENV") != "production" {
-			fmt.Printf("CreateAnnouncementHandler: リクエスト解析エラー: %v\n", err)
-		}
-		c.JSON(http.StatusBadRequest, gin.H{"error": "無効なリクエスト形式です"})
+// GetAnnouncementsHandler は公開中のお知らせ一覧を取得するハンドラ
+// 下書き・公開予定・掲載終了のお知らせは含めない
+// limit / cursor でページングし、q でタイトル・本文を全文検索、from / to で公開日時、category でカテゴリを絞り込む
+// ログイン中（OptionalJWTAuth でトークンを検証済み）の場合は配信対象に含まれるお知らせも返す
+// 会員限定のお知らせは、premium_content の利用権がなければ本文を除いた Locked の状態で返す
+func GetAnnouncementsHandler(c *gin.Context) {
+	params, msg := parseAnnouncementListParams(c)
+	if msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
 		return
 	}
 
-	// 現在時刻をセット
-	now := time.Now()
-	announcement.CreatedAt = now
-	announcement.UpdatedAt = now
+	viewer, err := resolveAnnouncementViewer(c)
+	if err != nil {
+		utils.LogErrorCtx(c.Request.Context(), "GetAnnouncements", err, "Failed to resolve viewer")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "お知らせの取得に失敗しました"})
+		return
+	}
 
-	// データベースに保存
 	ctx := c.Request.Context()
-	result, err := announcementCollection.InsertOne(ctx, announcement)
+	now := time.Now()
+
+	// ピン留め・重要なお知らせを先頭に表示する
+	page, err := announcementSvc.List(ctx, viewer, params, now)
 	if err != nil {
-		// セキュリティ: 本番環境ではエラーの詳細をログに出力しない
-		if os.Getenv("APP_ENV") != "production" {
-			fmt.Printf("CreateAnnouncementHandler: お知らせ作成エラー: %v\n", err)
-		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "お知らせの作成に失敗しました"})
+		respondAnnouncementError(c, "GetAnnouncements", err, "お知らせの取得に失敗しました")
+		return
+	}
+
+	if err := annotateReadState(ctx, viewer, page.Announcements); err != nil {
+		utils.LogErrorCtx(ctx, "GetAnnouncements", err, "Failed to load read receipts")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "お知らせの取得に失敗しました"})
 		return
 	}
 
-	// IDをセット
-	announcement.ID = result.InsertedID.(primitive.ObjectID)
+	if hasMembersOnly(page.Announcements) {
+		members, err := canViewMembersOnly(c, viewer)
+		if err != nil {
+			utils.LogErrorCtx(ctx, "GetAnnouncements", err, "Failed to load entitlements")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "お知らせの取得に失敗しました"})
+			return
+		}
+		if !members {
+			lockMembersOnly(page.Announcements)
+		}
+	}
 
-	c.JSON(http.StatusCreated, announcement)
+	respondAnnouncementPage(c, page, now)
 }
 
-// UpdateAnnouncementHandler は既存のお知らせ更新を行うハンドラ（管理者専用）
-func UpdateAnnouncementHandler(c *gin.Context) {
-	// URLからIDを取得
-	idStr := c.Param("id")
-	id, err := primitive.ObjectIDFromHex(idStr)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "無効なお知らせIDです"})
+// AdminListAnnouncementsHandler は下書き・公開予定・掲載終了を含むお知らせ一覧を取得するハンドラ（管理者専用）
+// status クエリで公開状態を絞り込める（ページング・検索は公開一覧と同じ。日付は作成日時に適用）
+func AdminListAnnouncementsHandler(c *gin.Context) {
+	params, msg := parseAnnouncementListParams(c)
+	if msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
 		return
 	}
 
-	// リクエストボディをパース
-	var updateData map[string]interface{}
-	if err := c.ShouldBindJSON(&updateData); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "無効なリクエスト形式です"})
+	now := time.Now()
+	page, err := announcementSvc.AdminList(c.Request.Context(), c.Query("status"), params, now)
+	if err != nil {
+		respondAnnouncementError(c, "AdminListAnnouncements", err, "お知らせの取得に失敗しました")
 		return
 	}
 
-	// 更新日時を追加
-	updateData["updated_at"] = time.Now()
+	respondAnnouncementPage(c, page, now)
+}
 
-	// データベースを更新
-	ctx := c.Request.Context()
-	result, err := announcementCollection.UpdateOne(
-		ctx,
-		bson.M{"_id": id},
-		bson.M{"$set": updateData},
-	)
+// respondAnnouncementPage は一覧のレスポンスを返す
+// count はこのページの件数、total は条件に一致する全件数
+func respondAnnouncementPage(c *gin.Context, page *announcementPage, now time.Time) {
+	c.JSON(http.StatusOK, gin.H{
+		"announcements": withStatus(page.Announcements, now),
+		"count":         len(page.Announcements),
+		"total":         page.Total,
+		"next_cursor":   page.NextCursor,
+		"has_more":      page.NextCursor != "",
+	})
+}
 
+// findAnnouncements は条件に一致するお知らせを取得する（該当なしの場合は空配列）
+func findAnnouncements(ctx context.Context, filter bson.M, findOptions *options.FindOptions) ([]Announcement, error) {
+	cursor, err := announcementCollection.Find(ctx, filter, findOptions)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "お知らせの更新に失敗しました"})
-		return
+		return nil, err
 	}
+	defer cursor.Close(ctx)
 
-	if result.MatchedCount == 0 {
+	announcements := []Announcement{}
+	if err := cursor.All(ctx, &announcements); err != nil {
+		return nil, err
+	}
+	return announcements, nil
+}
+
+// respondAnnouncementError はお知らせのサービスが返したエラーをレスポンスに変換する
+// 入力エラーは 400、存在しない場合は 404、同時編集は 409、それ以外は message を 500 で返す
+func respondAnnouncementError(c *gin.Context, handler string, err error, message string) {
+	if msg, ok := isAnnouncementInputError(err); ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+	switch {
+	case errors.Is(err, errAnnouncementNotFound):
 		c.JSON(http.StatusNotFound, gin.H{"error": "お知らせが見つかりません"})
+	case errors.Is(err, errAnnouncementConflict):
+		c.JSON(http.StatusConflict, gin.H{"error": "他の管理者がお知らせを更新しました。再読み込みしてからやり直してください"})
+	default:
+		utils.LogErrorCtx(c.Request.Context(), handler, err, "Announcement operation failed")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
+
+// bindAnnouncementInput はリクエストボディを読み取る（失敗時はレスポンスを返して false）
+// 本文は maxAnnouncementRequestBytes までに制限する
+func bindAnnouncementInput(c *gin.Context, handler string) (announcementInput, bool) {
+	var input announcementInput
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxAnnouncementRequestBytes)
+	if err := c.ShouldBindJSON(&input); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "リクエストが大きすぎます"})
+			return input, false
+		}
+		// セキュリティ: 本番環境ではエラーの詳細をログに出力しない
+		if os.Getenv("APP_ENV") != "production" {
+			fmt.Printf("%s: リクエスト解析エラー: %v\n", handler, err)
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "無効なリクエスト形式です"})
+		return input, false
+	}
+	return input, true
+}
+
+// CreateAnnouncementHandler は新規お知らせ作成を行うハンドラ（管理者専用）
+// isPublished を省略した場合は従来どおり即時公開する
+func CreateAnnouncementHandler(c *gin.Context) {
+	// リクエストボディをパース
+	input, ok := bindAnnouncementInput(c, "CreateAnnouncementHandler")
+	if !ok {
 		return
 	}
 
-	// 更新後のお知らせを取得
-	var updatedAnnouncement Announcement
-	err = announcementCollection.FindOne(ctx, bson.M{"_id": id}).Decode(&updatedAnnouncement)
+	adminID, _ := middleware.CurrentUserID(c)
+	announcement, err := announcementSvc.Create(c.Request.Context(), input, adminID, time.Now())
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新されたお知らせの取得に失敗しました"})
+		respondAnnouncementError(c, "CreateAnnouncement", err, "お知らせの作成に失敗しました")
 		return
 	}
 
-	c.JSON(http.StatusOK, updatedAnnouncement)
+	c.JSON(http.StatusCreated, announcement)
 }
 
-// DeleteAnnouncementHandler はお知らせ削除を行うハンドラ（管理者専用）
-func DeleteAnnouncementHandler(c *gin.Context) {
+// UpdateAnnouncementHandler は既存のお知らせ更新を行うハンドラ（管理者専用）
+// 指定された項目のみ変更する（publishAt / expiresAt は null で解除）
+// 更新のたびに版番号を上げ、変更後の内容を編集履歴に記録する
+func UpdateAnnouncementHandler(c *gin.Context) {
 	// URLからIDを取得
-	idStr := c.Param("id")
-	id, err := primitive.ObjectIDFromHex(idStr)
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "無効なお知らせIDです"})
 		return
 	}
 
-	// データベースから削除
-	ctx := c.Request.Context()
-	result, err := announcementCollection.DeleteOne(ctx, bson.M{"_id": id})
-
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "お知らせの削除に失敗しました"})
+	// リクエストボディをパース
+	input, ok := bindAnnouncementInput(c, "UpdateAnnouncementHandler")
+	if !ok {
 		return
 	}
 
-	if result.DeletedCount == 0 {
-		c.JSON(http.StatusNotFound, gin.H{"error": "お知らせが見つかりません"})
+	adminID, _ := middleware.CurrentUserID(c)
+	announcement, err := announcementSvc.Update(c.Request.Context(), id, input, adminID, time.Now())
+	if err != nil {
+		respondAnnouncementError(c, "UpdateAnnouncement", err, "お知らせの更新に失敗しました")
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "お知らせを削除しました"})
+	c.JSON(http.StatusOK, announcement)
 }
 
-// CreateAnnouncement は新しいお知らせを作成します
-// 注: このエンドポイントは AdminRequired ミドルウェアで保護されます
-func CreateAnnouncement(c *gin.Context) {
-	var announcement Announcement
-	if err := c.ShouldBindJSON(&announcement); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+// DeleteAnnouncementHandler はお知らせをゴミ箱に移動するハンドラ（管理者専用）
+// 添付ファイル・既読記録・編集履歴は残し、ゴミ箱から元に戻せるようにする
+func DeleteAnnouncementHandler(c *gin.Context) {
+	// URLからIDを取得
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "無効なお知らせIDです"})
 		return
 	}
 
-	// 現在時刻を設定
-	now := time.Now()
-	announcement.CreatedAt = now
-	announcement.UpdatedAt = now
-
-	// データベースに保存
-	// 例: db.Create(&announcement)
-
-	c.JSON(http.StatusCreated, announcement)
-}
-
-// GetAnnouncements はすべてのお知らせを取得します
-func GetAnnouncements(c *gin.Context) {
-	var announcements []Announcement
-
-	// データベースからお知らせを取得
-	// 例: db.Find(&announcements)
+	ctx := c.Request.Context()
+	adminID, _ := middleware.CurrentUserID(c)
+	if err := announcementSvc.Trash(ctx, id, adminID, time.Now()); err != nil {
+		respondAnnouncementError(c, "DeleteAnnouncement", err, "お知らせの削除に失敗しました")
+		return
+	}
 
-	c.JSON(http.StatusOK, announcements)
+	utils.LogInfoCtx(ctx, "DeleteAnnouncement", fmt.Sprintf("Announcement %s moved to trash by %s", id.Hex(), adminID.Hex()))
+	c.JSON(http.StatusOK, gin.H{"message": "お知らせをゴミ箱に移動しました"})
 }
 
 // GetAnnouncementByIdHandler は特定のお知らせを取得するハンドラ
+// 公開中でない、または配信対象外のお知らせは存在しないものとして扱う
+// 会員限定のお知らせは premium_content の利用権が必要（requireMembersOnlyAccess）
 func GetAnnouncementByIdHandler(c *gin.Context) {
-	idStr := c.Param("id")
-	id, err := primitive.ObjectIDFromHex(idStr)
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "無効なお知らせIDです"})
 		return
 	}
 
-	var announcement Announcement
-	ctx := c.Request.Context()
-	err = announcementCollection.FindOne(ctx, bson.M{"_id": id}).Decode(&announcement)
+	viewer, err := resolveAnnouncementViewer(c)
 	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			c.JSON(http.StatusNotFound, gin.H{"error": "お知らせが見つかりません"})
-			return
-		}
-		// セキュリティ: 本番環境ではエラーの詳細をログに出力しない
-		if os.Getenv("APP_ENV") != "production" {
-			fmt.Printf("GetAnnouncementByIdHandler: データベースエラー: %v\n", err)
-		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "お知らせの取得に失敗しました"})
 		return
 	}
 
-	c.JSON(http.StatusOK, announcement)
-}
-
-// UpdateAnnouncement はお知らせを更新します
-// 注: このエンドポイントは AdminRequired ミドルウェアで保護されます
-func UpdateAnnouncement(c *gin.Context) {
-	id := c.Param("id")
-	var announcement Announcement
-
-	// データベースから特定のお知らせを取得
-	// 例: result := db.First(&announcement, id)
-	_ = id // 一時的に変数を使用したことにする（実際の実装時には削除）
-
-	// リクエストボディからデータを取得
-	if err := c.ShouldBindJSON(&announcement); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	announcement, err := announcementSvc.Get(c.Request.Context(), id, viewer, time.Now())
+	if err != nil {
+		respondAnnouncementError(c, "GetAnnouncementById", err, "お知らせの取得に失敗しました")
+		return
+	}
+	if announcement.MembersOnly && !requireMembersOnlyAccess(c, viewer, "GetAnnouncementById", "お知らせの取得に失敗しました") {
 		return
 	}
-
-	announcement.UpdatedAt = time.Now()
-
-	// データベースを更新
-	// 例: db.Save(&announcement)
 
 	c.JSON(http.StatusOK, announcement)
 }
 
-// DeleteAnnouncement はお知らせを削除します
-// 注: このエンドポイントは AdminRequired ミドルウェアで保護されます
-func DeleteAnnouncement(c *gin.Context) {
-	id := c.Param("id")
-	// var announcement Announcement  // 未使用なので削除
+// AdminGetAnnouncementByIdHandler は公開状態にかかわらずお知らせを取得するハンドラ（管理者専用）
+func AdminGetAnnouncementByIdHandler(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "無効なお知らせIDです"})
+		return
+	}
 
-	// データベースから特定のお知らせを削除
-	// 例: db.Delete(&Announcement{}, id)
-	_ = id // 一時的に変数を使用したことにする（実際の実装時には削除）
+	announcement, err := announcementSvc.AdminGet(c.Request.Context(), id, time.Now())
+	if err != nil {
+		respondAnnouncementError(c, "AdminGetAnnouncementById", err, "お知らせの取得に失敗しました")
+		return
+	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "お知らせが削除されました"})
+	c.JSON(http.StatusOK, announcement)
 }
@@ -0,0 +1,350 @@
+package controllers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"juice_academy_backend/middleware"
+	"juice_academy_backend/services"
+	"juice_academy_backend/utils"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	maxAttachmentSize             = 10 << 20
+	maxAttachmentsPerAnnouncement = 10
+	maxAttachmentFilenameLength   = 200
+)
+
+// attachmentStorage は添付ファイルの保存先
+var attachmentStorage services.ObjectStorage
+
+// attachmentExtensions は添付できるファイル形式（内容から判定した MIME タイプ）と拡張子
+// 先頭の拡張子はファイル名に拡張子がない場合に付与する
+var attachmentExtensions = map[string][]string{
+	"application/pdf": {".pdf"},
+	"image/png":       {".png"},
+	"image/jpeg":      {".jpg", ".jpeg"},
+	"image/gif":       {".gif"},
+	"image/webp":      {".webp"},
+}
+
+// AnnouncementAttachment はお知らせの添付ファイル
+type AnnouncementAttachment struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id"`
+	Filename    string             `json:"filename" bson:"filename"`
+	ContentType string             `json:"contentType" bson:"content_type"`
+	Size        int64              `json:"size" bson:"size"`
+	// SHA256 は内容のハッシュ（16進数）。ダウンロード時の ETag にも使用する
+	SHA256     string             `json:"sha256" bson:"sha256"`
+	StorageKey string             `json:"-" bson:"storage_key"`
+	UploadedBy primitive.ObjectID `json:"uploadedBy" bson:"uploaded_by"`
+	UploadedAt time.Time          `json:"uploadedAt" bson:"uploaded_at"`
+}
+
+// InitAttachmentStorage は添付ファイルの保存先を設定する
+func InitAttachmentStorage(storage services.ObjectStorage) {
+	attachmentStorage = storage
+}
+
+// sniffAttachmentType は内容から MIME タイプを判定し、添付できない形式の場合は空文字を返す
+// クライアントが送信した Content-Type やファイル名の拡張子は信用しない
+func sniffAttachmentType(data []byte) string {
+	contentType, _, _ := mime.ParseMediaType(http.DetectContentType(data))
+	if _, ok := attachmentExtensions[contentType]; !ok {
+		return ""
+	}
+	return contentType
+}
+
+// sanitizeAttachmentFilename はファイル名からパスと制御文字を取り除き、拡張子を内容の形式に合わせる
+func sanitizeAttachmentFilename(name, contentType string) string {
+	name = path.Base(strings.ReplaceAll(name, "\\", "/"))
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || r == '"' || r == utf8.RuneError {
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.TrimSpace(name)
+	if name == "." || name == "/" || name == "" {
+		name = "attachment"
+	}
+	if runes := []rune(name); len(runes) > maxAttachmentFilenameLength {
+		name = string(runes[:maxAttachmentFilenameLength])
+	}
+
+	extensions := attachmentExtensions[contentType]
+	ext := strings.ToLower(path.Ext(name))
+	for _, allowed := range extensions {
+		if ext == allowed {
+			return name
+		}
+	}
+	return name + extensions[0]
+}
+
+// contentDisposition は日本語のファイル名にも対応した Content-Disposition ヘッダーの値を返す
+func contentDisposition(disposition, filename string) string {
+	fallback := strings.Map(func(r rune) rune {
+		if r > unicode.MaxASCII || r == '\\' {
+			return '_'
+		}
+		return r
+	}, filename)
+	return mime.FormatMediaType(disposition, map[string]string{"filename": fallback}) +
+		"; filename*=UTF-8''" + rfc5987Escape(filename)
+}
+
+// rfc5987Escape は RFC 5987 の attr-char 以外をパーセントエンコードする
+func rfc5987Escape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || strings.IndexByte("!#$&+-.^_`|~", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// UploadAnnouncementAttachmentHandler はお知らせにファイルを添付するハンドラ（管理者専用）
+// multipart/form-data の file に PDF または画像（PNG / JPEG / GIF / WebP）を指定する
+func UploadAnnouncementAttachmentHandler(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "無効なお知らせIDです"})
+		return
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxAttachmentSize+1<<20)
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "ファイルサイズが大きすぎます"})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ファイルを指定してください"})
+		return
+	}
+	if fileHeader.Size > maxAttachmentSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("ファイルサイズは%dMBまでです", maxAttachmentSize>>20)})
+		return
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ファイルを読み込めません"})
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, maxAttachmentSize+1))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ファイルを読み込めません"})
+		return
+	}
+	if len(data) > maxAttachmentSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("ファイルサイズは%dMBまでです", maxAttachmentSize>>20)})
+		return
+	}
+	if len(data) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "空のファイルは添付できません"})
+		return
+	}
+
+	contentType := sniffAttachmentType(data)
+	if contentType == "" {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "添付できるのはPDFと画像（PNG・JPEG・GIF・WebP）のみです"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	count, err := announcementCollection.CountDocuments(ctx, bson.M{"_id": id, "deleted_at": nil})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "ファイルの添付に失敗しました"})
+		return
+	}
+	if count == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "お知らせが見つかりません"})
+		return
+	}
+
+	sum := sha256.Sum256(data)
+	adminID, _ := middleware.CurrentUserID(c)
+	attachment := AnnouncementAttachment{
+		ID:          primitive.NewObjectID(),
+		Filename:    sanitizeAttachmentFilename(fileHeader.Filename, contentType),
+		ContentType: contentType,
+		Size:        int64(len(data)),
+		SHA256:      hex.EncodeToString(sum[:]),
+		UploadedBy:  adminID,
+		UploadedAt:  time.Now(),
+	}
+	attachment.StorageKey = "announcements/" + id.Hex() + "/" + attachment.ID.Hex()
+
+	if err := attachmentStorage.Put(ctx, attachment.StorageKey, data, contentType); err != nil {
+		utils.LogErrorCtx(ctx, "AnnouncementAttachment", err, "Failed to store attachment")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "ファイルの保存に失敗しました"})
+		return
+	}
+
+	// 添付数の上限は更新条件で判定する（同時にアップロードされても上限を超えない）
+	result, err := announcementCollection.UpdateOne(ctx,
+		bson.M{"_id": id, "deleted_at": nil, "attachments." + strconv.Itoa(maxAttachmentsPerAnnouncement-1): bson.M{"$exists": false}},
+		bson.M{"$push": bson.M{"attachments": attachment}},
+	)
+	if err != nil || result.MatchedCount == 0 {
+		if deleteErr := attachmentStorage.Delete(ctx, attachment.StorageKey); deleteErr != nil {
+			utils.LogErrorCtx(ctx, "AnnouncementAttachment", deleteErr, "Failed to clean up attachment "+attachment.StorageKey)
+		}
+		if err != nil {
+			utils.LogErrorCtx(ctx, "AnnouncementAttachment", err, "Failed to save attachment metadata")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "ファイルの添付に失敗しました"})
+			return
+		}
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("添付できるファイルは%d件までです", maxAttachmentsPerAnnouncement)})
+		return
+	}
+
+	utils.LogInfoCtx(ctx, "AnnouncementAttachment", fmt.Sprintf("Attachment %s added to announcement %s by %s", attachment.ID.Hex(), id.Hex(), adminID.Hex()))
+	c.JSON(http.StatusCreated, attachment)
+}
+
+// DeleteAnnouncementAttachmentHandler は添付ファイルを削除するハンドラ（管理者専用）
+func DeleteAnnouncementAttachmentHandler(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "無効なお知らせIDです"})
+		return
+	}
+	attachmentID, err := primitive.ObjectIDFromHex(c.Param("attachmentId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "無効な添付ファイルIDです"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	var before Announcement
+	err = announcementCollection.FindOneAndUpdate(ctx,
+		bson.M{"_id": id, "deleted_at": nil, "attachments._id": attachmentID},
+		bson.M{"$pull": bson.M{"attachments": bson.M{"_id": attachmentID}}},
+		options.FindOneAndUpdate().SetProjection(bson.M{"attachments": bson.M{"$elemMatch": bson.M{"_id": attachmentID}}}),
+	).Decode(&before)
+	if err == mongo.ErrNoDocuments {
+		c.JSON(http.StatusNotFound, gin.H{"error": "添付ファイルが見つかりません"})
+		return
+	}
+	if err != nil {
+		utils.LogErrorCtx(ctx, "AnnouncementAttachment", err, "Failed to remove attachment")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "添付ファイルの削除に失敗しました"})
+		return
+	}
+
+	deleteAttachmentObjects(c, before.Attachments)
+	c.JSON(http.StatusOK, gin.H{"message": "添付ファイルを削除しました"})
+}
+
+// deleteAttachmentObjects はストレージから添付ファイルを削除する（失敗してもリクエストは成功として扱い、ログに残す）
+func deleteAttachmentObjects(c *gin.Context, attachments []AnnouncementAttachment) {
+	ctx := c.Request.Context()
+	for _, attachment := range attachments {
+		if err := attachmentStorage.Delete(ctx, attachment.StorageKey); err != nil {
+			utils.LogErrorCtx(ctx, "AnnouncementAttachment", err, "Failed to delete attachment object "+attachment.StorageKey)
+		}
+	}
+}
+
+// DownloadAnnouncementAttachmentHandler は添付ファイルを返すハンドラ
+// お知らせと同じく、公開中かつ配信対象の閲覧者のみ取得できる（管理者は下書きの添付ファイルも取得できる）
+// 会員限定のお知らせの添付ファイルは premium_content の利用権が必要
+// 画像はページに埋め込めるよう inline、それ以外はダウンロードとして返す
+func DownloadAnnouncementAttachmentHandler(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "無効なお知らせIDです"})
+		return
+	}
+	attachmentID, err := primitive.ObjectIDFromHex(c.Param("attachmentId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "無効な添付ファイルIDです"})
+		return
+	}
+
+	viewer, err := resolveAnnouncementViewer(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "添付ファイルの取得に失敗しました"})
+		return
+	}
+	filter := bson.M{"_id": id, "attachments._id": attachmentID}
+	if !viewer.Admin {
+		filter["$and"] = []bson.M{liveAnnouncementFilter(time.Now()), audienceFilter(viewer)}
+	}
+
+	ctx := c.Request.Context()
+	var announcement Announcement
+	err = announcementCollection.FindOne(ctx, filter,
+		options.FindOne().SetProjection(bson.M{"members_only": 1, "attachments": bson.M{"$elemMatch": bson.M{"_id": attachmentID}}}),
+	).Decode(&announcement)
+	if err == mongo.ErrNoDocuments || (err == nil && len(announcement.Attachments) == 0) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "添付ファイルが見つかりません"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "添付ファイルの取得に失敗しました"})
+		return
+	}
+	if announcement.MembersOnly && !requireMembersOnlyAccess(c, viewer, "AnnouncementAttachment", "添付ファイルの取得に失敗しました") {
+		return
+	}
+	attachment := announcement.Attachments[0]
+
+	// 配信対象によって取得できるかが変わるため、共有キャッシュには保存させない
+	etag := `"` + attachment.SHA256 + `"`
+	c.Header("ETag", etag)
+	c.Header("Cache-Control", "private, max-age=300")
+	if c.GetHeader("If-None-Match") == etag {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	reader, err := attachmentStorage.Get(ctx, attachment.StorageKey)
+	if err != nil {
+		utils.LogErrorCtx(ctx, "AnnouncementAttachment", err, "Failed to read attachment "+attachment.StorageKey)
+		if errors.Is(err, services.ErrObjectNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "添付ファイルが見つかりません"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "添付ファイルの取得に失敗しました"})
+		return
+	}
+	defer reader.Close()
+
+	disposition := "attachment"
+	if strings.HasPrefix(attachment.ContentType, "image/") {
+		disposition = "inline"
+		// 画像はお知らせ本文に埋め込まれるため、同一サイトのフロントエンドからの読み込みを許可する
+		c.Header("Cross-Origin-Resource-Policy", "same-site")
+	}
+	c.Header("Content-Disposition", contentDisposition(disposition, attachment.Filename))
+	c.Header("Content-Security-Policy", "default-src 'none'; sandbox")
+	c.DataFromReader(http.StatusOK, attachment.Size, attachment.ContentType, reader, nil)
+}
@@ -0,0 +1,85 @@
+package controllers
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TestSniffAttachmentType は内容による形式判定をテストする
+func TestSniffAttachmentType(t *testing.T) {
+	assert.Equal(t, "application/pdf", sniffAttachmentType([]byte("%PDF-1.7\n...")))
+	assert.Equal(t, "image/png", sniffAttachmentType([]byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")))
+	assert.Equal(t, "image/jpeg", sniffAttachmentType([]byte("\xff\xd8\xff\xe0\x00\x10JFIF")))
+	assert.Equal(t, "image/gif", sniffAttachmentType([]byte("GIF89a\x01\x00")))
+
+	assert.Empty(t, sniffAttachmentType([]byte("<html><script>alert(1)</script>")), "HTML は添付できないこと")
+	assert.Empty(t, sniffAttachmentType([]byte(`<svg xmlns="http://www.w3.org/2000/svg" onload="alert(1)"/>`)), "SVG は添付できないこと")
+	assert.Empty(t, sniffAttachmentType([]byte("MZ\x90\x00")), "実行ファイルは添付できないこと")
+}
+
+// TestSanitizeAttachmentFilename はファイル名の正規化をテストする
+func TestSanitizeAttachmentFilename(t *testing.T) {
+	assert.Equal(t, "時間割.pdf", sanitizeAttachmentFilename("時間割.pdf", "application/pdf"))
+	assert.Equal(t, "photo.JPG", sanitizeAttachmentFilename("photo.JPG", "image/jpeg"))
+	assert.Equal(t, "passwd.pdf", sanitizeAttachmentFilename("../../etc/passwd", "application/pdf"), "パスは取り除かれること")
+	assert.Equal(t, "evil.pdf", sanitizeAttachmentFilename(`C:\Users\evil.pdf`, "application/pdf"))
+	assert.Equal(t, "flyer.html.png", sanitizeAttachmentFilename("flyer.html", "image/png"), "拡張子は内容の形式に合わせること")
+	assert.Equal(t, "ab.pdf", sanitizeAttachmentFilename("a\r\n\"b.pdf", "application/pdf"))
+	assert.Equal(t, "attachment.pdf", sanitizeAttachmentFilename("", "application/pdf"))
+}
+
+// TestContentDisposition は日本語ファイル名の Content-Disposition をテストする
+func TestContentDisposition(t *testing.T) {
+	assert.Equal(t, `attachment; filename=schedule.pdf; filename*=UTF-8''schedule.pdf`, contentDisposition("attachment", "schedule.pdf"))
+	assert.Equal(t,
+		`inline; filename="__ 1.png"; filename*=UTF-8''%E5%9B%B3%E8%A1%A8%201.png`,
+		contentDisposition("inline", "図表 1.png"))
+}
+
+func newAttachmentUploadContext(t *testing.T, filename string, content []byte) (*gin.Context, *httptest.ResponseRecorder) {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, err := writer.CreateFormFile("file", filename)
+	require.NoError(t, err)
+	_, _ = part.Write(content)
+	require.NoError(t, writer.Close())
+
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/api/admin/announcements/x/attachments", &body)
+	c.Request.Header.Set("Content-Type", writer.FormDataContentType())
+	c.Params = gin.Params{{Key: "id", Value: primitive.NewObjectID().Hex()}}
+	return c, w
+}
+
+// TestUploadAnnouncementAttachmentValidation は保存前に形式とサイズが検証されることをテストする
+func TestUploadAnnouncementAttachmentValidation(t *testing.T) {
+	t.Run("対応していない形式", func(t *testing.T) {
+		// 拡張子を偽装しても内容で判定される
+		c, w := newAttachmentUploadContext(t, "flyer.png", []byte("<html><body>flyer</body></html>"))
+		UploadAnnouncementAttachmentHandler(c)
+		assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+	})
+
+	t.Run("サイズ超過", func(t *testing.T) {
+		content := append([]byte("%PDF-1.7\n"), make([]byte, maxAttachmentSize)...)
+		c, w := newAttachmentUploadContext(t, "large.pdf", content)
+		UploadAnnouncementAttachmentHandler(c)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	})
+
+	t.Run("空のファイル", func(t *testing.T) {
+		c, w := newAttachmentUploadContext(t, "empty.pdf", nil)
+		UploadAnnouncementAttachmentHandler(c)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
@@ -0,0 +1,218 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	"juice_academy_backend/middleware"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// お知らせのカテゴリ
+const (
+	AnnouncementCategoryGeneral     = "general"
+	AnnouncementCategoryAcademic    = "academic"
+	AnnouncementCategoryEvent       = "event"
+	AnnouncementCategoryMaintenance = "maintenance"
+	AnnouncementCategoryBilling     = "billing"
+)
+
+var announcementCategories = map[string]bool{
+	AnnouncementCategoryGeneral:     true,
+	AnnouncementCategoryAcademic:    true,
+	AnnouncementCategoryEvent:       true,
+	AnnouncementCategoryMaintenance: true,
+	AnnouncementCategoryBilling:     true,
+}
+
+// subscriptionStatusNone はサブスクリプションを持たないユーザーを対象にする場合の値
+const subscriptionStatusNone = "none"
+
+// audienceSubscriptionStatuses は配信対象に指定できるサブスクリプション状態（Stripe の status と none）
+var audienceSubscriptionStatuses = map[string]bool{
+	"active":               true,
+	"trialing":             true,
+	"past_due":             true,
+	"unpaid":               true,
+	"canceled":             true,
+	"incomplete":           true,
+	"incomplete_expired":   true,
+	"paused":               true,
+	subscriptionStatusNone: true,
+}
+
+// AnnouncementAudience はお知らせの配信対象
+// 各項目は OR、項目間は AND で評価する（空の項目は制限しない）
+type AnnouncementAudience struct {
+	Roles                []string `json:"roles,omitempty" bson:"roles,omitempty"`
+	SubscriptionStatuses []string `json:"subscriptionStatuses,omitempty" bson:"subscription_statuses,omitempty"`
+	// Cohorts は対象とする入学年度（User.EntryYear）
+	Cohorts []int `json:"cohorts,omitempty" bson:"cohorts,omitempty"`
+}
+
+// normalizeAudience は配信対象を検証し、制限がない場合は nil（全員向け）を返す
+func normalizeAudience(audience *AnnouncementAudience) (*AnnouncementAudience, string) {
+	if audience == nil || (len(audience.Roles) == 0 && len(audience.SubscriptionStatuses) == 0 && len(audience.Cohorts) == 0) {
+		return nil, ""
+	}
+	for _, role := range audience.Roles {
+		if !assignableRoles[role] {
+			return nil, "配信対象のロールが不正です: " + role
+		}
+	}
+	for _, status := range audience.SubscriptionStatuses {
+		if !audienceSubscriptionStatuses[status] {
+			return nil, "配信対象のサブスクリプション状態が不正です: " + status
+		}
+	}
+	for _, cohort := range audience.Cohorts {
+		if cohort < 2000 || cohort > 2100 {
+			return nil, "配信対象の入学年度が不正です"
+		}
+	}
+	return audience, ""
+}
+
+// audienceSubscriptionStatus は配信対象の判定に使うサブスクリプションの状態を返す
+// 利用できない有効・トライアル中の契約（subscriptionGrantsAccess が false のもの）は、休止中なら paused、
+// 解約予約の期間が終了していれば canceled として扱う
+func audienceSubscriptionStatus(sub Subscription, now time.Time) string {
+	if sub.Status == "" {
+		return subscriptionStatusNone
+	}
+	if (sub.Status == "active" || sub.Status == "trialing") && !subscriptionGrantsAccess(sub, now) {
+		if sub.PausedAt != nil {
+			return "paused"
+		}
+		return "canceled"
+	}
+	return sub.Status
+}
+
+// audienceSubscriptionStatusExpr は $lookup した subscription 配列から audienceSubscriptionStatus と同じ状態を求める集計式
+func audienceSubscriptionStatusExpr() bson.M {
+	usable := bson.M{"$in": bson.A{"$$sub.status", bson.A{"active", "trialing"}}}
+	return bson.M{"$let": bson.M{
+		"vars": bson.M{"sub": bson.M{"$arrayElemAt": bson.A{"$subscription", 0}}},
+		"in": bson.M{"$switch": bson.M{
+			"branches": bson.A{
+				bson.M{
+					"case": bson.M{"$eq": bson.A{bson.M{"$ifNull": bson.A{"$$sub.status", ""}}, ""}},
+					"then": subscriptionStatusNone,
+				},
+				bson.M{
+					"case": bson.M{"$and": bson.A{usable, bson.M{"$ne": bson.A{bson.M{"$ifNull": bson.A{"$$sub.paused_at", nil}}, nil}}}},
+					"then": "paused",
+				},
+				bson.M{
+					"case": bson.M{"$and": bson.A{
+						usable,
+						bson.M{"$eq": bson.A{"$$sub.cancel_at_period_end", true}},
+						bson.M{"$lt": bson.A{"$$sub.current_period_end", "$$NOW"}},
+					}},
+					"then": "canceled",
+				},
+			},
+			"default": "$$sub.status",
+		}},
+	}}
+}
+
+// announcementViewer はお知らせを閲覧するユーザーの属性
+type announcementViewer struct {
+	UserID             primitive.ObjectID
+	Anonymous          bool
+	Admin              bool
+	Role               string
+	SubscriptionStatus string
+	Cohort             int
+}
+
+// audienceFilter は閲覧者に表示できるお知らせの条件を返す
+// 未ログインの閲覧者には全員向けのお知らせのみを表示し、管理者には配信対象にかかわらずすべて表示する
+func audienceFilter(viewer announcementViewer) bson.M {
+	if viewer.Admin {
+		return bson.M{}
+	}
+	everyone := bson.M{"audience": nil}
+	if viewer.Anonymous {
+		return everyone
+	}
+
+	cohortMatch := []bson.M{{"audience.cohorts": nil}}
+	if viewer.Cohort != 0 {
+		cohortMatch = append(cohortMatch, bson.M{"audience.cohorts": viewer.Cohort})
+	}
+
+	return bson.M{"$or": []bson.M{
+		everyone,
+		{"$and": []bson.M{
+			{"$or": []bson.M{{"audience.roles": nil}, {"audience.roles": viewer.Role}}},
+			{"$or": []bson.M{{"audience.subscription_statuses": nil}, {"audience.subscription_statuses": viewer.SubscriptionStatus}}},
+			{"$or": cohortMatch},
+		}},
+	}}
+}
+
+// resolveAnnouncementViewer はリクエストの認証情報から閲覧者の属性を読み込む
+// OptionalJWTAuth の後に使用し、トークンがなければ匿名の閲覧者を返す
+func resolveAnnouncementViewer(c *gin.Context) (announcementViewer, error) {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		return announcementViewer{Anonymous: true}, nil
+	}
+
+	snapshot, err := middleware.AuthzSnapshotFromContext(c)
+	if err != nil {
+		return announcementViewer{}, err
+	}
+	if snapshot.IsAdmin {
+		return announcementViewer{UserID: userID, Admin: true, Role: snapshot.Role}, nil
+	}
+
+	viewer := announcementViewer{UserID: userID, Role: snapshot.Role, SubscriptionStatus: subscriptionStatusNone}
+	ctx := c.Request.Context()
+
+	// 休止中の契約者を active として扱わないよう、利用権と同じ判定で状態を決める
+	var sub Subscription
+	err = subscriptionCollection.FindOne(ctx, bson.M{"user_id": userID}, options.FindOne().SetProjection(bson.M{
+		"status": 1, "paused_at": 1, "cancel_at_period_end": 1, "current_period_end": 1,
+	})).Decode(&sub)
+	if err == nil {
+		viewer.SubscriptionStatus = audienceSubscriptionStatus(sub, time.Now())
+	} else if err != mongo.ErrNoDocuments {
+		return announcementViewer{}, err
+	}
+
+	var user struct {
+		EntryYear int `bson:"entry_year"`
+	}
+	err = userCollection.FindOne(ctx, bson.M{"_id": userID}, options.FindOne().SetProjection(bson.M{"entry_year": 1})).Decode(&user)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return announcementViewer{}, err
+	}
+	viewer.Cohort = user.EntryYear
+
+	return viewer, nil
+}
+
+// backfillAnnouncementFlags は並び順・カテゴリ絞り込みに使う項目を持たない既存のお知らせを補完する
+// pinned / important が欠けていると等価比較によるページングで取りこぼすため、明示的に false を設定する
+func backfillAnnouncementFlags(ctx context.Context) error {
+	if _, err := announcementCollection.UpdateMany(ctx,
+		bson.M{"pinned": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"pinned": false, "important": false}},
+	); err != nil {
+		return err
+	}
+	_, err := announcementCollection.UpdateMany(ctx,
+		bson.M{"category": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"category": AnnouncementCategoryGeneral}},
+	)
+	return err
+}
@@ -0,0 +1,426 @@
+package controllers
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"juice_academy_backend/middleware"
+	"juice_academy_backend/services"
+	"juice_academy_backend/utils"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// AnnouncementDigestConfig はお知らせのメール通知（ダイジェスト）の送信設定
+type AnnouncementDigestConfig struct {
+	// Interval は送信待ちのお知らせを確認する間隔（この間に公開されたお知らせは1通にまとめる）
+	Interval time.Duration
+	// BatchSize / BatchPause はSMTPサーバーの送信制限に合わせて、BatchSize 通ごとに BatchPause だけ待つ
+	BatchSize  int
+	BatchPause time.Duration
+}
+
+// DefaultAnnouncementDigestConfig はデフォルトのダイジェスト送信設定
+var DefaultAnnouncementDigestConfig = AnnouncementDigestConfig{
+	Interval:   10 * time.Minute,
+	BatchSize:  50,
+	BatchPause: 5 * time.Second,
+}
+
+// maxDigestAnnouncements は1回のダイジェストに含めるお知らせの上限
+const maxDigestAnnouncements = 20
+
+// digestExcerptLength はダイジェストに載せる本文の抜粋の文字数
+const digestExcerptLength = 120
+
+var (
+	digestStopChan chan struct{}
+	digestWg       sync.WaitGroup
+	digestOnce     sync.Once
+
+	// sendAnnouncementDigest はダイジェストメールの送信処理（テストで差し替える）
+	sendAnnouncementDigest = services.SendAnnouncementDigestEmail
+)
+
+// digestRecipient はダイジェストの送信先ユーザー
+type digestRecipient struct {
+	ID                 primitive.ObjectID `bson:"_id"`
+	Email              string             `bson:"email"`
+	NameKana           string             `bson:"name_kana"`
+	Role               string             `bson:"role"`
+	EntryYear          int                `bson:"entry_year"`
+	SubscriptionStatus string             `bson:"subscription_status"`
+}
+
+func (r digestRecipient) viewer() announcementViewer {
+	return announcementViewer{UserID: r.ID, Role: r.Role, SubscriptionStatus: r.SubscriptionStatus, Cohort: r.EntryYear}
+}
+
+// StartAnnouncementDigestWorker はお知らせのメール通知を定期的に送信するワーカーを起動する
+// SMTP設定がない環境では起動しない
+func StartAnnouncementDigestWorker(config AnnouncementDigestConfig) {
+	if !services.EmailConfigured() {
+		utils.LogWarning("AnnouncementDigest", "SMTP is not configured, announcement email notifications are disabled")
+		return
+	}
+
+	digestOnce.Do(func() {
+		digestStopChan = make(chan struct{})
+		digestWg.Add(1)
+		go func() {
+			defer digestWg.Done()
+			ticker := time.NewTicker(config.Interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-digestStopChan:
+					return
+				case <-ticker.C:
+					if err := runAnnouncementDigest(context.Background(), config, time.Now()); err != nil {
+						utils.LogError("AnnouncementDigest", err, "digest run failed")
+					}
+				}
+			}
+		}()
+		utils.LogInfo("AnnouncementDigest", fmt.Sprintf("Started announcement digest worker (interval=%s)", config.Interval))
+	})
+}
+
+// ShutdownAnnouncementDigestWorker はダイジェストのワーカーを停止する
+func ShutdownAnnouncementDigestWorker() {
+	if digestStopChan != nil {
+		close(digestStopChan)
+		digestWg.Wait()
+	}
+}
+
+// runAnnouncementDigest は公開中で未送信のお知らせをまとめて、メール通知を希望したユーザーに送信する
+// 送信前に email_sent_at を記録してお知らせを確保するため、複数のサーバーで実行しても二重送信しない
+func runAnnouncementDigest(ctx context.Context, config AnnouncementDigestConfig, now time.Time) error {
+	announcements, err := claimDigestAnnouncements(ctx, now)
+	if err != nil || len(announcements) == 0 {
+		return err
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"announcement_email_opt_in": true, "email": bson.M{"$nin": bson.A{nil, ""}}}}},
+	}
+	pipeline = append(pipeline, audienceUserStages(nil)...)
+	pipeline = append(pipeline,
+		bson.D{{Key: "$lookup", Value: bson.M{
+			"from":         "subscriptions",
+			"localField":   "_id",
+			"foreignField": "user_id",
+			"as":           "subscription",
+		}}},
+		bson.D{{Key: "$project", Value: bson.M{
+			"email":               1,
+			"name_kana":           1,
+			"role":                1,
+			"entry_year":          1,
+			"subscription_status": audienceSubscriptionStatusExpr(),
+		}}},
+	)
+
+	cursor, err := userCollection.Aggregate(ctx, pipeline, options.Aggregate().SetBatchSize(int32(config.BatchSize)))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	sent, failed, inBatch := 0, 0, 0
+	for cursor.Next(ctx) {
+		var recipient digestRecipient
+		if err := cursor.Decode(&recipient); err != nil {
+			return err
+		}
+		items := digestItemsFor(recipient.viewer(), announcements)
+		if len(items) == 0 {
+			continue
+		}
+
+		if inBatch == config.BatchSize {
+			time.Sleep(config.BatchPause)
+			inBatch = 0
+		}
+		inBatch++
+
+		token := announcementUnsubscribeToken(recipient.ID)
+		data := services.AnnouncementDigestEmailData{
+			UserName:         recipient.NameKana,
+			Items:            items,
+			AnnouncementsURL: publicSiteURL() + "/announcements",
+			UnsubscribeURL:   publicSiteURL() + "/announcements/unsubscribe?token=" + url.QueryEscape(token),
+		}
+		oneClickURL := publicAPIURL() + "/announcements/unsubscribe?token=" + url.QueryEscape(token)
+		if err := sendAnnouncementDigest(recipient.Email, data, oneClickURL); err != nil {
+			failed++
+			utils.LogError("AnnouncementDigest", err, "user_id="+recipient.ID.Hex())
+			continue
+		}
+		sent++
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+
+	utils.LogInfo("AnnouncementDigest", fmt.Sprintf("Sent digest of %d announcements: sent=%d failed=%d", len(announcements), sent, failed))
+	return nil
+}
+
+// claimDigestAnnouncements は送信待ちのお知らせを取得し、送信済みとして記録する
+func claimDigestAnnouncements(ctx context.Context, now time.Time) ([]Announcement, error) {
+	filter := liveAnnouncementFilter(now)
+	filter["notify_email"] = true
+	filter["email_sent_at"] = nil
+
+	cursor, err := announcementCollection.Find(ctx, filter, options.Find().
+		SetSort(bson.D{{Key: "publish_at", Value: 1}, {Key: "_id", Value: 1}}).
+		SetLimit(maxDigestAnnouncements).
+		SetProjection(bson.M{"attachments": 0}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	var pending []Announcement
+	if err := cursor.All(ctx, &pending); err != nil {
+		return nil, err
+	}
+
+	claimed := make([]Announcement, 0, len(pending))
+	for _, announcement := range pending {
+		result, err := announcementCollection.UpdateOne(ctx,
+			bson.M{"_id": announcement.ID, "email_sent_at": nil},
+			bson.M{"$set": bson.M{"email_sent_at": now}},
+		)
+		if err != nil {
+			return nil, err
+		}
+		// 他のサーバーが先に確保したお知らせは送信しない
+		if result.ModifiedCount == 1 {
+			claimed = append(claimed, announcement)
+		}
+	}
+	return claimed, nil
+}
+
+// digestItemsFor は閲覧者が配信対象に含まれるお知らせをダイジェストの項目にする
+// 重要なお知らせを先に並べる
+func digestItemsFor(viewer announcementViewer, announcements []Announcement) []services.AnnouncementDigestItem {
+	var important, others []services.AnnouncementDigestItem
+	for _, announcement := range announcements {
+		if !audienceMatches(announcement.Audience, viewer) {
+			continue
+		}
+		item := services.AnnouncementDigestItem{
+			Title:     announcement.Title,
+			Category:  announcement.Category,
+			Important: announcement.Important,
+			Excerpt:   utils.PlainTextExcerpt(announcement.ContentHTML, digestExcerptLength),
+			URL:       publicSiteURL() + "/announcements/" + announcement.ID.Hex(),
+		}
+		// 会員限定のお知らせは本文を載せず、リンク先で利用権を確認する
+		if announcement.MembersOnly {
+			item.Excerpt = ""
+		}
+		if announcement.Important {
+			important = append(important, item)
+		} else {
+			others = append(others, item)
+		}
+	}
+	return append(important, others...)
+}
+
+// audienceMatches は閲覧者がお知らせの配信対象に含まれるかを返す（audienceFilter と同じ判定）
+func audienceMatches(audience *AnnouncementAudience, viewer announcementViewer) bool {
+	if viewer.Admin || audience == nil {
+		return true
+	}
+	if viewer.Anonymous {
+		return false
+	}
+	containsString := func(values []string, value string) bool {
+		for _, v := range values {
+			if v == value {
+				return true
+			}
+		}
+		return len(values) == 0
+	}
+	cohortMatch := len(audience.Cohorts) == 0
+	for _, cohort := range audience.Cohorts {
+		if viewer.Cohort != 0 && cohort == viewer.Cohort {
+			cohortMatch = true
+		}
+	}
+	return containsString(audience.Roles, viewer.Role) &&
+		containsString(audience.SubscriptionStatuses, viewer.SubscriptionStatus) &&
+		cohortMatch
+}
+
+// publicSiteURL はメールやフィードに載せるフロントエンドのURL
+func publicSiteURL() string {
+	return strings.TrimRight(getEnvDefault("PUBLIC_SITE_URL", "http://localhost:3000"), "/")
+}
+
+// publicAPIURL は外部から到達できるAPIのURL（/api まで含む）
+func publicAPIURL() string {
+	return strings.TrimRight(getEnvDefault("PUBLIC_API_URL", "http://localhost:8080/api"), "/")
+}
+
+func getEnvDefault(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+// unsubscribeTokenPurpose は配信停止トークンの署名を他の用途の署名と区別する
+const unsubscribeTokenPurpose = "announcement-email-unsubscribe:"
+
+// unsubscribeSecret は配信停止トークンの署名鍵（未設定の場合は JWT_SECRET を使用する）
+func unsubscribeSecret() []byte {
+	if secret := os.Getenv("EMAIL_UNSUBSCRIBE_SECRET"); secret != "" {
+		return []byte(secret)
+	}
+	return []byte(os.Getenv("JWT_SECRET"))
+}
+
+func unsubscribeSignature(userID primitive.ObjectID) []byte {
+	mac := hmac.New(sha256.New, unsubscribeSecret())
+	mac.Write([]byte(unsubscribeTokenPurpose + userID.Hex()))
+	return mac.Sum(nil)[:16]
+}
+
+// announcementUnsubscribeToken はメール内の配信停止リンクに付けるトークンを返す
+// ログインせずに配信停止できるよう、ユーザーIDと署名のみで構成し有効期限は設けない
+func announcementUnsubscribeToken(userID primitive.ObjectID) string {
+	return base64.RawURLEncoding.EncodeToString(userID[:]) + "." + base64.RawURLEncoding.EncodeToString(unsubscribeSignature(userID))
+}
+
+// parseAnnouncementUnsubscribeToken は配信停止トークンを検証し、ユーザーIDを返す
+func parseAnnouncementUnsubscribeToken(token string) (primitive.ObjectID, bool) {
+	idPart, sigPart, ok := strings.Cut(token, ".")
+	if !ok || len(unsubscribeSecret()) == 0 {
+		return primitive.NilObjectID, false
+	}
+	rawID, err := base64.RawURLEncoding.DecodeString(idPart)
+	if err != nil || len(rawID) != len(primitive.ObjectID{}) {
+		return primitive.NilObjectID, false
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(sigPart)
+	if err != nil {
+		return primitive.NilObjectID, false
+	}
+
+	var userID primitive.ObjectID
+	copy(userID[:], rawID)
+	if !hmac.Equal(signature, unsubscribeSignature(userID)) {
+		return primitive.NilObjectID, false
+	}
+	return userID, true
+}
+
+// UnsubscribeAnnouncementEmailHandler はメールの配信停止リンクからメール通知を停止するハンドラ
+// RFC 8058 のワンクリック配信停止（List-Unsubscribe-Post）にも対応するため、ログインを必要としない
+func UnsubscribeAnnouncementEmailHandler(c *gin.Context) {
+	token := c.Query("token")
+	if token == "" {
+		token = c.PostForm("token")
+	}
+	userID, ok := parseAnnouncementUnsubscribeToken(token)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "無効な配信停止リンクです"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	if _, err := userCollection.UpdateOne(ctx,
+		bson.M{"_id": userID},
+		bson.M{"$set": bson.M{"announcement_email_opt_in": false, "updated_at": time.Now()}},
+	); err != nil {
+		utils.LogErrorCtx(ctx, "UnsubscribeAnnouncementEmail", err, "Failed to update opt-in")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "配信停止に失敗しました"})
+		return
+	}
+
+	// 退会済みのユーザーでも結果は同じにする（ユーザーの存在を推測させない）
+	c.JSON(http.StatusOK, gin.H{"message": "お知らせのメール通知を停止しました"})
+}
+
+// notificationSettings はログイン中のユーザーの通知設定
+type notificationSettings struct {
+	AnnouncementEmail bool `json:"announcement_email"`
+}
+
+// GetNotificationSettingsHandler はログイン中のユーザーの通知設定を返すハンドラ
+func GetNotificationSettingsHandler(c *gin.Context) {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "認証が必要です"})
+		return
+	}
+
+	var user User
+	err := userCollection.FindOne(c.Request.Context(), bson.M{"_id": userID},
+		options.FindOne().SetProjection(bson.M{"announcement_email_opt_in": 1}),
+	).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		c.JSON(http.StatusNotFound, gin.H{"error": "ユーザーが見つかりません"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "通知設定の取得に失敗しました"})
+		return
+	}
+
+	c.JSON(http.StatusOK, notificationSettings{AnnouncementEmail: user.AnnouncementEmailOptIn})
+}
+
+// UpdateNotificationSettingsHandler はログイン中のユーザーの通知設定を変更するハンドラ
+func UpdateNotificationSettingsHandler(c *gin.Context) {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "認証が必要です"})
+		return
+	}
+
+	var input struct {
+		AnnouncementEmail *bool `json:"announcement_email"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil || input.AnnouncementEmail == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "無効なリクエスト形式です"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	result, err := userCollection.UpdateOne(ctx,
+		bson.M{"_id": userID},
+		bson.M{"$set": bson.M{"announcement_email_opt_in": *input.AnnouncementEmail, "updated_at": time.Now()}},
+	)
+	if err != nil {
+		utils.LogErrorCtx(ctx, "UpdateNotificationSettings", err, "Failed to update opt-in")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "通知設定の更新に失敗しました"})
+		return
+	}
+	if result.MatchedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "ユーザーが見つかりません"})
+		return
+	}
+
+	c.JSON(http.StatusOK, notificationSettings{AnnouncementEmail: *input.AnnouncementEmail})
+}
@@ -0,0 +1,86 @@
+package controllers
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TestAnnouncementUnsubscribeToken は配信停止トークンの検証をテストする
+func TestAnnouncementUnsubscribeToken(t *testing.T) {
+	t.Setenv("EMAIL_UNSUBSCRIBE_SECRET", "unsubscribe-secret-for-tests")
+	userID := primitive.NewObjectID()
+	token := announcementUnsubscribeToken(userID)
+
+	parsed, ok := parseAnnouncementUnsubscribeToken(token)
+	assert.True(t, ok)
+	assert.Equal(t, userID, parsed)
+
+	t.Run("他のユーザーのIDに差し替えたトークン", func(t *testing.T) {
+		other := announcementUnsubscribeToken(primitive.NewObjectID())
+		idPart, _, _ := strings.Cut(other, ".")
+		_, sigPart, _ := strings.Cut(token, ".")
+		_, ok := parseAnnouncementUnsubscribeToken(idPart + "." + sigPart)
+		assert.False(t, ok)
+	})
+
+	t.Run("署名鍵が変わったトークン", func(t *testing.T) {
+		t.Setenv("EMAIL_UNSUBSCRIBE_SECRET", "rotated-secret")
+		_, ok := parseAnnouncementUnsubscribeToken(token)
+		assert.False(t, ok)
+	})
+
+	for _, invalid := range []string{"", "abc", token + "x", "." + strings.SplitN(token, ".", 2)[1]} {
+		_, ok := parseAnnouncementUnsubscribeToken(invalid)
+		assert.False(t, ok, invalid)
+	}
+}
+
+// TestAudienceMatches は配信対象の判定をテストする
+func TestAudienceMatches(t *testing.T) {
+	student := announcementViewer{Role: "student", SubscriptionStatus: "active", Cohort: 2025}
+
+	cases := []struct {
+		name     string
+		audience *AnnouncementAudience
+		viewer   announcementViewer
+		expected bool
+	}{
+		{"全員向け", nil, student, true},
+		{"ロールが一致", &AnnouncementAudience{Roles: []string{"student"}}, student, true},
+		{"ロールが不一致", &AnnouncementAudience{Roles: []string{"teacher"}}, student, false},
+		{"項目間はAND", &AnnouncementAudience{Roles: []string{"student"}, SubscriptionStatuses: []string{"none"}}, student, false},
+		{"入学年度が一致", &AnnouncementAudience{Cohorts: []int{2024, 2025}}, student, true},
+		{"入学年度が未設定", &AnnouncementAudience{Cohorts: []int{2025}}, announcementViewer{Role: "student", SubscriptionStatus: "active"}, false},
+		{"未ログイン", &AnnouncementAudience{Roles: []string{"student"}}, announcementViewer{Anonymous: true}, false},
+		{"管理者", &AnnouncementAudience{Roles: []string{"teacher"}}, announcementViewer{Admin: true}, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, audienceMatches(tc.audience, tc.viewer))
+		})
+	}
+}
+
+// TestDigestItemsFor はダイジェストの項目が配信対象で絞り込まれ、重要なお知らせが先に並ぶことをテストする
+func TestDigestItemsFor(t *testing.T) {
+	t.Setenv("PUBLIC_SITE_URL", "https://academy.example.com/")
+	announcements := []Announcement{
+		{ID: primitive.NewObjectID(), Title: "通常", ContentHTML: "<p>本文</p>"},
+		{ID: primitive.NewObjectID(), Title: "教員向け", Audience: &AnnouncementAudience{Roles: []string{"teacher"}}},
+		{ID: primitive.NewObjectID(), Title: "重要", Important: true},
+		{ID: primitive.NewObjectID(), Title: "会員限定", ContentHTML: "<p>契約者向けの本文</p>", MembersOnly: true},
+	}
+
+	items := digestItemsFor(announcementViewer{Role: "student", SubscriptionStatus: "none"}, announcements)
+	if assert.Len(t, items, 3) {
+		assert.Equal(t, "重要", items[0].Title)
+		assert.Equal(t, "通常", items[1].Title)
+		assert.Equal(t, "本文", items[1].Excerpt)
+		assert.Equal(t, "https://academy.example.com/announcements/"+announcements[0].ID.Hex(), items[1].URL)
+		assert.Equal(t, "会員限定", items[2].Title)
+		assert.Empty(t, items[2].Excerpt, "会員限定のお知らせの本文はメールに載せないこと")
+	}
+}
@@ -0,0 +1,283 @@
+package controllers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"juice_academy_backend/utils"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// announcementFeedSize はフィードに含めるお知らせの件数
+const announcementFeedSize = 20
+
+// announcementFeedMaxAge はフィードをキャッシュしてよい秒数
+const announcementFeedMaxAge = 300
+
+const announcementFeedTitle = "Juice Academy お知らせ"
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	AtomNS  string     `xml:"xmlns:atom,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	Language      string    `xml:"language"`
+	LastBuildDate string    `xml:"lastBuildDate,omitempty"`
+	SelfLink      atomLink  `xml:"atom:link"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string  `xml:"title"`
+	Link        string  `xml:"link"`
+	GUID        rssGUID `xml:"guid"`
+	PubDate     string  `xml:"pubDate"`
+	Category    string  `xml:"category,omitempty"`
+	Description string  `xml:"description"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Author  atomAuthor  `xml:"author"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomEntry struct {
+	ID        string        `xml:"id"`
+	Title     string        `xml:"title"`
+	Updated   string        `xml:"updated"`
+	Published string        `xml:"published"`
+	Link      atomLink      `xml:"link"`
+	Category  *atomCategory `xml:"category,omitempty"`
+	Content   atomContent   `xml:"content"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+type atomContent struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+// AnnouncementRSSFeedHandler は公開中のお知らせの RSS 2.0 フィードを返すハンドラ
+func AnnouncementRSSFeedHandler(c *gin.Context) {
+	serveAnnouncementFeed(c, "rss")
+}
+
+// AnnouncementAtomFeedHandler は公開中のお知らせの Atom フィードを返すハンドラ
+func AnnouncementAtomFeedHandler(c *gin.Context) {
+	serveAnnouncementFeed(c, "atom")
+}
+
+// serveAnnouncementFeed は未ログインの閲覧者に表示できるお知らせ（全員向け）からフィードを生成する
+// ETag / Last-Modified による条件付きリクエストに対応し、変更がなければ 304 を返す
+func serveAnnouncementFeed(c *gin.Context, format string) {
+	ctx := c.Request.Context()
+	now := time.Now()
+
+	// 会員限定のお知らせは本文を公開できないためフィードに含めない
+	filter := visibleAnnouncementFilter(announcementViewer{Anonymous: true}, now)
+	filter["members_only"] = bson.M{"$ne": true}
+
+	announcements, err := findAnnouncements(ctx, filter,
+		options.Find().
+			SetSort(bson.D{{Key: "publish_at", Value: -1}, {Key: "_id", Value: -1}}).
+			SetLimit(announcementFeedSize).
+			SetProjection(bson.M{"attachments": 0}),
+	)
+	if err != nil {
+		utils.LogErrorCtx(ctx, "AnnouncementFeed", err, "Failed to load announcements")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "お知らせの取得に失敗しました"})
+		return
+	}
+
+	etag := announcementFeedETag(format, announcements)
+	lastModified := announcementFeedLastModified(announcements)
+
+	c.Header("Cache-Control", "public, max-age="+strconv.Itoa(announcementFeedMaxAge))
+	c.Header("ETag", etag)
+	if !lastModified.IsZero() {
+		c.Header("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+	if feedNotModified(c.Request, etag, lastModified) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	var body interface{}
+	contentType := "application/rss+xml; charset=utf-8"
+	if format == "atom" {
+		body = buildAtomFeed(announcements, lastModified)
+		contentType = "application/atom+xml; charset=utf-8"
+	} else {
+		body = buildRSSFeed(announcements, lastModified)
+	}
+
+	output, err := xml.MarshalIndent(body, "", "  ")
+	if err != nil {
+		utils.LogErrorCtx(ctx, "AnnouncementFeed", err, "Failed to encode feed")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "フィードの生成に失敗しました"})
+		return
+	}
+	c.Data(http.StatusOK, contentType, append([]byte(xml.Header), output...))
+}
+
+// announcementFeedETag はフィードに含まれるお知らせと更新日時から ETag を求める
+func announcementFeedETag(format string, announcements []Announcement) string {
+	h := sha256.New()
+	h.Write([]byte(format))
+	for _, a := range announcements {
+		h.Write([]byte(a.ID.Hex()))
+		h.Write([]byte(strconv.FormatInt(a.UpdatedAt.UnixMilli(), 10)))
+	}
+	return `"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+}
+
+// announcementFeedLastModified はフィードの最終更新日時（公開日時・更新日時の最大値）を返す
+func announcementFeedLastModified(announcements []Announcement) time.Time {
+	var latest time.Time
+	for _, a := range announcements {
+		if a.UpdatedAt.After(latest) {
+			latest = a.UpdatedAt
+		}
+		if a.PublishAt != nil && a.PublishAt.After(latest) {
+			latest = *a.PublishAt
+		}
+	}
+	return latest
+}
+
+// feedNotModified は条件付きリクエストに対して 304 を返せるかを判定する
+// If-None-Match がある場合は If-Modified-Since より優先する（RFC 9110）
+func feedNotModified(r *http.Request, etag string, lastModified time.Time) bool {
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		return etagMatches(match, etag)
+	}
+	if since := r.Header.Get("If-Modified-Since"); since != "" && !lastModified.IsZero() {
+		t, err := http.ParseTime(since)
+		return err == nil && !lastModified.Truncate(time.Second).After(t)
+	}
+	return false
+}
+
+func announcementURL(a Announcement) string {
+	return publicSiteURL() + "/announcements/" + a.ID.Hex()
+}
+
+func announcementPublishedAt(a Announcement) time.Time {
+	if a.PublishAt != nil {
+		return *a.PublishAt
+	}
+	return a.CreatedAt
+}
+
+func buildRSSFeed(announcements []Announcement, lastModified time.Time) rssFeed {
+	channel := rssChannel{
+		Title:       announcementFeedTitle,
+		Link:        publicSiteURL() + "/announcements",
+		Description: "Juice Academy からのお知らせ",
+		Language:    "ja",
+		SelfLink:    atomLink{Href: publicAPIURL() + "/announcements/feed.xml", Rel: "self", Type: "application/rss+xml"},
+		Items:       make([]rssItem, 0, len(announcements)),
+	}
+	if !lastModified.IsZero() {
+		channel.LastBuildDate = lastModified.UTC().Format(time.RFC1123Z)
+	}
+	for _, a := range announcements {
+		link := announcementURL(a)
+		channel.Items = append(channel.Items, rssItem{
+			Title:       a.Title,
+			Link:        link,
+			GUID:        rssGUID{IsPermaLink: true, Value: link},
+			PubDate:     announcementPublishedAt(a).UTC().Format(time.RFC1123Z),
+			Category:    a.Category,
+			Description: a.ContentHTML,
+		})
+	}
+	return rssFeed{Version: "2.0", AtomNS: "http://www.w3.org/2005/Atom", Channel: channel}
+}
+
+func buildAtomFeed(announcements []Announcement, lastModified time.Time) atomFeed {
+	feedURL := publicAPIURL() + "/announcements/feed.atom"
+	if lastModified.IsZero() {
+		lastModified = time.Unix(0, 0)
+	}
+	feed := atomFeed{
+		ID:      feedURL,
+		Title:   announcementFeedTitle,
+		Updated: lastModified.UTC().Format(time.RFC3339),
+		Links: []atomLink{
+			{Href: publicSiteURL() + "/announcements", Rel: "alternate", Type: "text/html"},
+			{Href: feedURL, Rel: "self", Type: "application/atom+xml"},
+		},
+		Author:  atomAuthor{Name: "Juice Academy"},
+		Entries: make([]atomEntry, 0, len(announcements)),
+	}
+	for _, a := range announcements {
+		link := announcementURL(a)
+		published := announcementPublishedAt(a)
+		updated := a.UpdatedAt
+		if published.After(updated) {
+			updated = published
+		}
+		entry := atomEntry{
+			ID:        link,
+			Title:     a.Title,
+			Updated:   updated.UTC().Format(time.RFC3339),
+			Published: published.UTC().Format(time.RFC3339),
+			Link:      atomLink{Href: link, Rel: "alternate", Type: "text/html"},
+			Content:   atomContent{Type: "html", Value: a.ContentHTML},
+		}
+		if a.Category != "" {
+			entry.Category = &atomCategory{Term: a.Category}
+		}
+		feed.Entries = append(feed.Entries, entry)
+	}
+	return feed
+}
+
+// etagMatches は If-None-Match の値（カンマ区切り・弱い比較）に etag が含まれるかを返す
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,108 @@
+package controllers
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func feedTestAnnouncements() []Announcement {
+	publishAt := time.Date(2026, 4, 1, 9, 0, 0, 0, time.UTC)
+	return []Announcement{{
+		ID:          primitive.NewObjectID(),
+		Title:       "春期講習 <申込受付中>",
+		ContentHTML: `<p><strong>申込期限</strong>は3月末です &amp; お早めに</p>`,
+		Category:    AnnouncementCategoryEvent,
+		PublishAt:   &publishAt,
+		CreatedAt:   publishAt.Add(-time.Hour),
+		UpdatedAt:   publishAt.Add(-time.Hour),
+	}}
+}
+
+// TestBuildAnnouncementFeeds は RSS / Atom が解析可能な XML として出力されることをテストする
+func TestBuildAnnouncementFeeds(t *testing.T) {
+	t.Setenv("PUBLIC_SITE_URL", "https://academy.example.com")
+	announcements := feedTestAnnouncements()
+	lastModified := announcementFeedLastModified(announcements)
+	assert.Equal(t, *announcements[0].PublishAt, lastModified)
+
+	t.Run("RSS", func(t *testing.T) {
+		output, err := xml.Marshal(buildRSSFeed(announcements, lastModified))
+		require.NoError(t, err)
+
+		var parsed struct {
+			Items []struct {
+				Title       string `xml:"title"`
+				Link        string `xml:"link"`
+				PubDate     string `xml:"pubDate"`
+				Description string `xml:"description"`
+			} `xml:"channel>item"`
+		}
+		require.NoError(t, xml.Unmarshal(output, &parsed))
+		require.Len(t, parsed.Items, 1)
+		assert.Equal(t, "春期講習 <申込受付中>", parsed.Items[0].Title)
+		assert.Equal(t, "https://academy.example.com/announcements/"+announcements[0].ID.Hex(), parsed.Items[0].Link)
+		assert.Equal(t, "Wed, 01 Apr 2026 09:00:00 +0000", parsed.Items[0].PubDate)
+		assert.Equal(t, announcements[0].ContentHTML, parsed.Items[0].Description)
+	})
+
+	t.Run("Atom", func(t *testing.T) {
+		output, err := xml.Marshal(buildAtomFeed(announcements, lastModified))
+		require.NoError(t, err)
+
+		var parsed struct {
+			XMLName xml.Name `xml:"http://www.w3.org/2005/Atom feed"`
+			Updated string   `xml:"updated"`
+			Entries []struct {
+				Updated string `xml:"updated"`
+				Content struct {
+					Type  string `xml:"type,attr"`
+					Value string `xml:",chardata"`
+				} `xml:"content"`
+			} `xml:"entry"`
+		}
+		require.NoError(t, xml.Unmarshal(output, &parsed))
+		assert.Equal(t, "2026-04-01T09:00:00Z", parsed.Updated)
+		require.Len(t, parsed.Entries, 1)
+		assert.Equal(t, "2026-04-01T09:00:00Z", parsed.Entries[0].Updated, "予約公開は公開日時を更新日時とすること")
+		assert.Equal(t, "html", parsed.Entries[0].Content.Type)
+		assert.Equal(t, announcements[0].ContentHTML, parsed.Entries[0].Content.Value)
+	})
+}
+
+// TestFeedNotModified は条件付きリクエストの判定をテストする
+func TestFeedNotModified(t *testing.T) {
+	announcements := feedTestAnnouncements()
+	etag := announcementFeedETag("rss", announcements)
+	lastModified := announcementFeedLastModified(announcements)
+
+	assert.NotEqual(t, etag, announcementFeedETag("atom", announcements), "形式ごとに異なる ETag にすること")
+	edited := feedTestAnnouncements()
+	edited[0].ID = announcements[0].ID
+	edited[0].UpdatedAt = edited[0].UpdatedAt.Add(time.Minute)
+	assert.NotEqual(t, etag, announcementFeedETag("rss", edited), "更新されたら ETag が変わること")
+
+	request := func(headers map[string]string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/api/announcements/feed.xml", nil)
+		for k, v := range headers {
+			r.Header.Set(k, v)
+		}
+		return r
+	}
+
+	assert.False(t, feedNotModified(request(nil), etag, lastModified))
+	assert.True(t, feedNotModified(request(map[string]string{"If-None-Match": etag}), etag, lastModified))
+	assert.True(t, feedNotModified(request(map[string]string{"If-None-Match": `"other", W/` + etag}), etag, lastModified))
+	assert.True(t, feedNotModified(request(map[string]string{"If-Modified-Since": lastModified.Format(http.TimeFormat)}), etag, lastModified))
+	assert.False(t, feedNotModified(request(map[string]string{"If-Modified-Since": lastModified.Add(-time.Second).Format(http.TimeFormat)}), etag, lastModified))
+	assert.False(t, feedNotModified(request(map[string]string{
+		"If-None-Match":     `"stale"`,
+		"If-Modified-Since": lastModified.Format(http.TimeFormat),
+	}), etag, lastModified), "If-None-Match を優先すること")
+}
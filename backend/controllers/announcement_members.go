@@ -0,0 +1,68 @@
+package controllers
+
+import (
+	"net/http"
+
+	"juice_academy_backend/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+// canViewMembersOnly は閲覧者が会員限定のお知らせの本文を閲覧できるかを返す
+// 管理者は内容の確認のため常に閲覧でき、未ログインの閲覧者は閲覧できない
+func canViewMembersOnly(c *gin.Context, viewer announcementViewer) (bool, error) {
+	if viewer.Admin {
+		return true, nil
+	}
+	if viewer.Anonymous {
+		return false, nil
+	}
+	snapshot, err := middleware.EntitlementsFromContext(c)
+	if err != nil {
+		return false, err
+	}
+	return snapshot.Has(middleware.EntitlementPremiumContent), nil
+}
+
+// hasMembersOnly は会員限定のお知らせが含まれるかを返す（含まれない場合は利用権の確認を省く）
+func hasMembersOnly(announcements []Announcement) bool {
+	for _, announcement := range announcements {
+		if announcement.MembersOnly {
+			return true
+		}
+	}
+	return false
+}
+
+// lockMembersOnly は会員限定のお知らせから本文と添付ファイルを取り除き、Locked にする
+// タイトル・カテゴリ・公開日時は残し、利用権のない閲覧者にも会員限定のお知らせがあることを示す
+func lockMembersOnly(announcements []Announcement) {
+	for i := range announcements {
+		if !announcements[i].MembersOnly {
+			continue
+		}
+		announcements[i].Content = ""
+		announcements[i].ContentHTML = ""
+		announcements[i].Attachments = nil
+		announcements[i].Locked = true
+	}
+}
+
+// requireMembersOnlyAccess は会員限定のお知らせを閲覧できない場合にエラーを返して false を返す
+// 未ログインは 401、利用権がない場合は code=subscription_required の 403（フロントエンドはプラン選択画面へ誘導する）
+func requireMembersOnlyAccess(c *gin.Context, viewer announcementViewer, handler, message string) bool {
+	allowed, err := canViewMembersOnly(c, viewer)
+	if err != nil {
+		respondAnnouncementError(c, handler, err, message)
+		return false
+	}
+	if allowed {
+		return true
+	}
+	if viewer.Anonymous {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "会員限定のお知らせを閲覧するにはログインが必要です"})
+		return false
+	}
+	middleware.AbortEntitlementRequired(c, middleware.EntitlementPremiumContent)
+	return false
+}
@@ -0,0 +1,215 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// memoryAnnouncementRepository はテスト用のメモリ上の announcementRepository
+// 公開状態・配信対象・ページングの判定は MongoDB の検索条件と同じ結果になるように実装する
+type memoryAnnouncementRepository struct {
+	mu            sync.Mutex
+	announcements map[primitive.ObjectID]Announcement
+	revisions     []AnnouncementRevision
+}
+
+func newMemoryAnnouncementRepository(initial ...Announcement) *memoryAnnouncementRepository {
+	repo := &memoryAnnouncementRepository{announcements: make(map[primitive.ObjectID]Announcement)}
+	for _, a := range initial {
+		if a.ID.IsZero() {
+			a.ID = primitive.NewObjectID()
+		}
+		repo.announcements[a.ID] = a
+	}
+	return repo
+}
+
+// useMemoryAnnouncements はテストの間だけお知らせのサービスをメモリ上の保存先に差し替える
+func useMemoryAnnouncements(t *testing.T, initial ...Announcement) *memoryAnnouncementRepository {
+	t.Helper()
+	repo := newMemoryAnnouncementRepository(initial...)
+	original := announcementSvc
+	announcementSvc = &announcementService{repo: repo}
+	t.Cleanup(func() { announcementSvc = original })
+	return repo
+}
+
+func (r *memoryAnnouncementRepository) Insert(_ context.Context, a *Announcement) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if a.ID.IsZero() {
+		a.ID = primitive.NewObjectID()
+	}
+	r.announcements[a.ID] = *a
+	return nil
+}
+
+func (r *memoryAnnouncementRepository) FindActive(_ context.Context, id primitive.ObjectID) (*Announcement, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	a, ok := r.announcements[id]
+	if !ok || a.DeletedAt != nil {
+		return nil, errAnnouncementNotFound
+	}
+	return &a, nil
+}
+
+func (r *memoryAnnouncementRepository) FindVisible(_ context.Context, id primitive.ObjectID, viewer announcementViewer, now time.Time) (*Announcement, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	a, ok := r.announcements[id]
+	if !ok || !memoryAnnouncementLive(a, now) || !audienceMatches(a.Audience, viewer) {
+		return nil, errAnnouncementNotFound
+	}
+	return &a, nil
+}
+
+func (r *memoryAnnouncementRepository) ListVisible(_ context.Context, viewer announcementViewer, now time.Time, params announcementListParams) (*announcementPage, error) {
+	return r.page(func(a Announcement) bool {
+		return memoryAnnouncementLive(a, now) && audienceMatches(a.Audience, viewer)
+	}, "publish_at", true, params), nil
+}
+
+func (r *memoryAnnouncementRepository) ListByStatus(_ context.Context, status string, now time.Time, params announcementListParams) (*announcementPage, error) {
+	if !validAnnouncementStatus(status) {
+		return nil, errors.New("unknown announcement status: " + status)
+	}
+	return r.page(func(a Announcement) bool {
+		if a.DeletedAt != nil {
+			return false
+		}
+		switch status {
+		case AnnouncementStatusDraft:
+			return !a.IsPublished
+		case AnnouncementStatusScheduled:
+			return a.IsPublished && a.PublishAt != nil && a.PublishAt.After(now)
+		case AnnouncementStatusExpired:
+			return a.IsPublished && a.ExpiresAt != nil && !a.ExpiresAt.After(now)
+		case AnnouncementStatusPublished:
+			return memoryAnnouncementLive(a, now)
+		}
+		return true
+	}, "created_at", false, params), nil
+}
+
+func (r *memoryAnnouncementRepository) Update(_ context.Context, a *Announcement) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stored, ok := r.announcements[a.ID]
+	if !ok || stored.DeletedAt != nil || stored.Version != a.Version {
+		return errAnnouncementConflict
+	}
+	a.Version++
+	updated := *a
+	// 添付ファイルなど編集対象外の項目は保存済みの値を残す
+	updated.Attachments = stored.Attachments
+	updated.EmailSentAt = stored.EmailSentAt
+	updated.CreatedAt = stored.CreatedAt
+	updated.Status = ""
+	r.announcements[a.ID] = updated
+	return nil
+}
+
+func (r *memoryAnnouncementRepository) Trash(_ context.Context, id, deletedBy primitive.ObjectID, now time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	a, ok := r.announcements[id]
+	if !ok || a.DeletedAt != nil {
+		return errAnnouncementNotFound
+	}
+	a.DeletedAt = &now
+	if !deletedBy.IsZero() {
+		a.DeletedBy = &deletedBy
+	}
+	r.announcements[id] = a
+	return nil
+}
+
+func (r *memoryAnnouncementRepository) RecordRevision(_ context.Context, revision AnnouncementRevision) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, existing := range r.revisions {
+		if existing.AnnouncementID == revision.AnnouncementID && existing.Version == revision.Version {
+			return errors.New("duplicate revision")
+		}
+	}
+	r.revisions = append(r.revisions, revision)
+	return nil
+}
+
+// memoryAnnouncementLive は liveAnnouncementFilter と同じ条件で公開中かを判定する
+func memoryAnnouncementLive(a Announcement, now time.Time) bool {
+	return a.DeletedAt == nil && a.IsPublished &&
+		a.PublishAt != nil && !a.PublishAt.After(now) &&
+		(a.ExpiresAt == nil || a.ExpiresAt.After(now))
+}
+
+// page は listAnnouncementsPage と同じ並び順・絞り込み・カーソルで1ページ分を返す
+// 全文検索はタイトル・本文の部分一致で代用する
+func (r *memoryAnnouncementRepository) page(match func(Announcement) bool, sortField string, pinnedFirst bool, params announcementListParams) *announcementPage {
+	r.mu.Lock()
+	var matched []Announcement
+	for _, a := range r.announcements {
+		if !match(a) {
+			continue
+		}
+		if params.Category != "" && a.Category != params.Category {
+			continue
+		}
+		if params.Query != "" && !strings.Contains(a.Title, params.Query) && !strings.Contains(a.Content, params.Query) {
+			continue
+		}
+		t := announcementSortTime(a, sortField)
+		if (params.From != nil && t.Before(*params.From)) || (params.To != nil && !t.Before(*params.To)) {
+			continue
+		}
+		matched = append(matched, a)
+	}
+	r.mu.Unlock()
+
+	key := func(a Announcement) announcementCursor {
+		k := announcementCursor{Time: announcementSortTime(a, sortField), ID: a.ID}
+		if pinnedFirst {
+			k.Pinned, k.Important = a.Pinned, a.Important
+		}
+		return k
+	}
+	sort.Slice(matched, func(i, j int) bool {
+		return memoryCursorBefore(key(matched[i]), key(matched[j]))
+	})
+
+	page := &announcementPage{Announcements: []Announcement{}, Total: int64(len(matched))}
+	for _, a := range matched {
+		if params.Cursor != nil && !memoryCursorBefore(*params.Cursor, key(a)) {
+			continue
+		}
+		if len(page.Announcements) == params.Limit {
+			last := page.Announcements[len(page.Announcements)-1]
+			page.NextCursor = key(last).encode()
+			break
+		}
+		page.Announcements = append(page.Announcements, a)
+	}
+	return page
+}
+
+// memoryCursorBefore は並び順 (pinned, important, 日時, ID) の降順で a が b より前かを返す
+func memoryCursorBefore(a, b announcementCursor) bool {
+	if a.Pinned != b.Pinned {
+		return a.Pinned
+	}
+	if a.Important != b.Important {
+		return a.Important
+	}
+	if !a.Time.Equal(b.Time) {
+		return a.Time.After(b.Time)
+	}
+	return a.ID.Hex() > b.ID.Hex()
+}
@@ -0,0 +1,235 @@
+package controllers
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	defaultAnnouncementPageSize = 20
+	maxAnnouncementPageSize     = 100
+	maxAnnouncementQueryLength  = 100
+)
+
+// 日付のみ指定された場合は日本時間の日付として解釈する
+var announcementDateLocation = time.FixedZone("JST", 9*60*60)
+
+// announcementListParams はお知らせ一覧のクエリパラメータ
+type announcementListParams struct {
+	Limit    int
+	Cursor   *announcementCursor
+	Query    string
+	Category string
+	From     *time.Time
+	To       *time.Time
+}
+
+// announcementCursor は並び順のキー（ピン留め・重要・日時・ID）を保持するキーセットページング用のカーソル
+type announcementCursor struct {
+	Pinned    bool               `json:"p,omitempty"`
+	Important bool               `json:"i,omitempty"`
+	Time      time.Time          `json:"t"`
+	ID        primitive.ObjectID `json:"id"`
+}
+
+// announcementPage は一覧の1ページ分の結果
+type announcementPage struct {
+	Announcements []Announcement
+	Total         int64
+	NextCursor    string
+}
+
+func (c announcementCursor) encode() string {
+	raw, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeAnnouncementCursor(value string) (*announcementCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	var cursor announcementCursor
+	if err := json.Unmarshal(raw, &cursor); err != nil {
+		return nil, err
+	}
+	if cursor.ID.IsZero() || cursor.Time.IsZero() {
+		return nil, errors.New("incomplete cursor")
+	}
+	return &cursor, nil
+}
+
+// parseAnnouncementDate は RFC3339 または YYYY-MM-DD 形式の日時を解釈する
+// endOfDay が true の場合、日付のみの指定はその日の終わり（翌日0時）として扱う
+func parseAnnouncementDate(value string, endOfDay bool) (*time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return &t, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", value, announcementDateLocation)
+	if err != nil {
+		return nil, err
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1)
+	}
+	return &t, nil
+}
+
+// parseAnnouncementListParams は limit / cursor / q / category / from / to を読み取る
+func parseAnnouncementListParams(c *gin.Context) (announcementListParams, string) {
+	params := announcementListParams{Limit: defaultAnnouncementPageSize}
+
+	if limit := c.Query("limit"); limit != "" {
+		parsed, err := strconv.Atoi(limit)
+		if err != nil || parsed < 1 {
+			return params, "limit は1以上の整数で指定してください"
+		}
+		if parsed > maxAnnouncementPageSize {
+			parsed = maxAnnouncementPageSize
+		}
+		params.Limit = parsed
+	}
+
+	if cursor := c.Query("cursor"); cursor != "" {
+		decoded, err := decodeAnnouncementCursor(cursor)
+		if err != nil {
+			return params, "無効なカーソルです"
+		}
+		params.Cursor = decoded
+	}
+
+	params.Query = strings.TrimSpace(c.Query("q"))
+	if len([]rune(params.Query)) > maxAnnouncementQueryLength {
+		return params, "検索キーワードが長すぎます"
+	}
+
+	if category := c.Query("category"); category != "" {
+		if !announcementCategories[category] {
+			return params, "無効なカテゴリです"
+		}
+		params.Category = category
+	}
+
+	if from := c.Query("from"); from != "" {
+		t, err := parseAnnouncementDate(from, false)
+		if err != nil {
+			return params, "from の日付形式が正しくありません"
+		}
+		params.From = t
+	}
+	if to := c.Query("to"); to != "" {
+		t, err := parseAnnouncementDate(to, true)
+		if err != nil {
+			return params, "to の日付形式が正しくありません"
+		}
+		params.To = t
+	}
+	if params.From != nil && params.To != nil && !params.From.Before(*params.To) {
+		return params, "from は to より前の日付を指定してください"
+	}
+
+	return params, ""
+}
+
+// listAnnouncementsPage は sortField（降順）と _id によるキーセットページングで一覧を取得する
+// pinnedFirst の場合はピン留め・重要なお知らせを先に並べる
+// q は announcements の text インデックス（title, content）で検索し、from/to は sortField に適用する
+func listAnnouncementsPage(ctx context.Context, baseFilter bson.M, sortField string, pinnedFirst bool, params announcementListParams) (*announcementPage, error) {
+	conditions := []bson.M{baseFilter}
+	if params.Category != "" {
+		conditions = append(conditions, bson.M{"category": params.Category})
+	}
+	if params.Query != "" {
+		conditions = append(conditions, bson.M{"$text": bson.M{"$search": params.Query}})
+	}
+	if params.From != nil || params.To != nil {
+		dateRange := bson.M{}
+		if params.From != nil {
+			dateRange["$gte"] = *params.From
+		}
+		if params.To != nil {
+			dateRange["$lt"] = *params.To
+		}
+		conditions = append(conditions, bson.M{sortField: dateRange})
+	}
+	filter := bson.M{"$and": conditions}
+
+	total, err := announcementCollection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	if params.Cursor != nil {
+		filter = bson.M{"$and": append(conditions, announcementCursorCondition(sortField, pinnedFirst, params.Cursor))}
+	}
+
+	sort := bson.D{{Key: sortField, Value: -1}, {Key: "_id", Value: -1}}
+	if pinnedFirst {
+		sort = append(bson.D{{Key: "pinned", Value: -1}, {Key: "important", Value: -1}}, sort...)
+	}
+
+	// 次ページの有無を判定するため1件多く取得する
+	findOptions := options.Find().
+		SetSort(sort).
+		SetLimit(int64(params.Limit + 1))
+
+	announcements, err := findAnnouncements(ctx, filter, findOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &announcementPage{Announcements: announcements, Total: total}
+	if len(announcements) > params.Limit {
+		page.Announcements = announcements[:params.Limit]
+		last := page.Announcements[params.Limit-1]
+		next := announcementCursor{Time: announcementSortTime(last, sortField), ID: last.ID}
+		if pinnedFirst {
+			next.Pinned, next.Important = last.Pinned, last.Important
+		}
+		page.NextCursor = next.encode()
+	}
+	return page, nil
+}
+
+// announcementSortTime は並び順に使用している日時を返す
+func announcementSortTime(a Announcement, sortField string) time.Time {
+	if sortField == "publish_at" && a.PublishAt != nil {
+		return *a.PublishAt
+	}
+	if sortField == "deleted_at" && a.DeletedAt != nil {
+		return *a.DeletedAt
+	}
+	return a.CreatedAt
+}
+
+// announcementCursorCondition はカーソルより後ろ（並び順で後）のお知らせを表す条件を返す
+// 並び順 (pinned, important, sortField, _id) の降順に対する辞書式比較を $or で表現する
+func announcementCursorCondition(sortField string, pinnedFirst bool, cursor *announcementCursor) bson.M {
+	after := []bson.M{
+		{sortField: bson.M{"$lt": cursor.Time}},
+		{sortField: cursor.Time, "_id": bson.M{"$lt": cursor.ID}},
+	}
+	if !pinnedFirst {
+		return bson.M{"$or": after}
+	}
+
+	var conditions []bson.M
+	if cursor.Pinned {
+		conditions = append(conditions, bson.M{"pinned": false})
+	}
+	if cursor.Important {
+		conditions = append(conditions, bson.M{"pinned": cursor.Pinned, "important": false})
+	}
+	conditions = append(conditions, bson.M{"pinned": cursor.Pinned, "important": cursor.Important, "$or": after})
+	return bson.M{"$or": conditions}
+}
@@ -0,0 +1,175 @@
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func parseListParamsFromURL(t *testing.T, url string) (announcementListParams, string) {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	req, err := http.NewRequest("GET", url, nil)
+	require.NoError(t, err)
+	c.Request = req
+	return parseAnnouncementListParams(c)
+}
+
+// TestAnnouncementCursorRoundTrip はカーソルのエンコードとデコードをテストする
+func TestAnnouncementCursorRoundTrip(t *testing.T) {
+	cursor := announcementCursor{Time: time.Date(2026, 4, 1, 9, 0, 0, 123000000, time.UTC), ID: primitive.NewObjectID()}
+
+	decoded, err := decodeAnnouncementCursor(cursor.encode())
+	require.NoError(t, err)
+	assert.True(t, cursor.Time.Equal(decoded.Time))
+	assert.Equal(t, cursor.ID, decoded.ID)
+
+	_, err = decodeAnnouncementCursor("not-a-cursor")
+	assert.Error(t, err)
+	_, err = decodeAnnouncementCursor(announcementCursor{}.encode())
+	assert.Error(t, err, "空のカーソルは拒否されること")
+}
+
+// TestParseAnnouncementListParams は一覧のクエリパラメータの解釈をテストする
+func TestParseAnnouncementListParams(t *testing.T) {
+	t.Run("既定値", func(t *testing.T) {
+		params, msg := parseListParamsFromURL(t, "/api/announcements")
+		assert.Empty(t, msg)
+		assert.Equal(t, defaultAnnouncementPageSize, params.Limit)
+		assert.Nil(t, params.Cursor)
+	})
+
+	t.Run("上限を超えるlimit", func(t *testing.T) {
+		params, msg := parseListParamsFromURL(t, "/api/announcements?limit=1000")
+		assert.Empty(t, msg)
+		assert.Equal(t, maxAnnouncementPageSize, params.Limit)
+	})
+
+	t.Run("日付のみの範囲指定", func(t *testing.T) {
+		params, msg := parseListParamsFromURL(t, "/api/announcements?from=2026-04-01&to=2026-04-30&q=%20休講%20")
+		require.Empty(t, msg)
+		assert.Equal(t, "休講", params.Query)
+		assert.Equal(t, time.Date(2026, 3, 31, 15, 0, 0, 0, time.UTC), params.From.UTC(), "日本時間の0時として解釈されること")
+		assert.Equal(t, time.Date(2026, 4, 30, 15, 0, 0, 0, time.UTC), params.To.UTC(), "to は指定日を含むよう翌日0時になること")
+	})
+
+	invalid := []struct {
+		name string
+		url  string
+	}{
+		{name: "数値でないlimit", url: "/api/announcements?limit=abc"},
+		{name: "0件のlimit", url: "/api/announcements?limit=0"},
+		{name: "不正なカーソル", url: "/api/announcements?cursor=abc!"},
+		{name: "不正な日付", url: "/api/announcements?from=2026/04/01"},
+		{name: "範囲の逆転", url: "/api/announcements?from=2026-05-01&to=2026-04-01"},
+	}
+	for _, tt := range invalid {
+		t.Run(tt.name, func(t *testing.T) {
+			_, msg := parseListParamsFromURL(t, tt.url)
+			assert.NotEmpty(t, msg)
+		})
+	}
+}
+
+// TestAnnouncementCursorCondition はピン留めを先頭にした並び順でのカーソル条件をテストする
+func TestAnnouncementCursorCondition(t *testing.T) {
+	cursor := &announcementCursor{Time: time.Date(2026, 4, 1, 9, 0, 0, 0, time.UTC), ID: primitive.NewObjectID()}
+
+	t.Run("日時順のみ", func(t *testing.T) {
+		condition := announcementCursorCondition("created_at", false, cursor)
+		assert.Len(t, condition["$or"], 2)
+	})
+
+	t.Run("ピン留め済みのカーソル", func(t *testing.T) {
+		pinned := *cursor
+		pinned.Pinned, pinned.Important = true, true
+		clauses := announcementCursorCondition("publish_at", true, &pinned)["$or"].([]bson.M)
+
+		require.Len(t, clauses, 3)
+		assert.Equal(t, bson.M{"pinned": false}, clauses[0], "ピン留めの後にはピン留めされていないお知らせが続くこと")
+		assert.Equal(t, bson.M{"pinned": true, "important": false}, clauses[1], "ピン留め内では重要でないお知らせが続くこと")
+		assert.Equal(t, true, clauses[2]["pinned"])
+		assert.Equal(t, true, clauses[2]["important"])
+	})
+
+	t.Run("通常のお知らせのカーソル", func(t *testing.T) {
+		clauses := announcementCursorCondition("publish_at", true, cursor)["$or"].([]bson.M)
+		require.Len(t, clauses, 1, "ピン留め・重要でないカーソルの後には同じ区分のお知らせだけが続くこと")
+		assert.Equal(t, false, clauses[0]["pinned"])
+		assert.Equal(t, false, clauses[0]["important"])
+	})
+}
+
+// TestAudienceFilter は閲覧者ごとの配信対象の条件をテストする
+func TestAudienceFilter(t *testing.T) {
+	assert.Equal(t, bson.M{"audience": nil}, audienceFilter(announcementViewer{Anonymous: true}),
+		"未ログインの閲覧者には全員向けのお知らせのみ表示されること")
+	assert.Equal(t, bson.M{}, audienceFilter(announcementViewer{Admin: true}),
+		"管理者には配信対象にかかわらず表示されること")
+
+	filter := audienceFilter(announcementViewer{Role: "student", SubscriptionStatus: "active", Cohort: 2025})
+	clauses := filter["$or"].([]bson.M)
+	require.Len(t, clauses, 2)
+	assert.Equal(t, bson.M{"audience": nil}, clauses[0])
+
+	targeted := clauses[1]["$and"].([]bson.M)
+	require.Len(t, targeted, 3)
+	assert.Contains(t, targeted[0]["$or"], bson.M{"audience.roles": "student"})
+	assert.Contains(t, targeted[1]["$or"], bson.M{"audience.subscription_statuses": "active"})
+	assert.Contains(t, targeted[2]["$or"], bson.M{"audience.cohorts": 2025})
+
+	noCohort := audienceFilter(announcementViewer{Role: "student", SubscriptionStatus: subscriptionStatusNone})
+	cohortClause := noCohort["$or"].([]bson.M)[1]["$and"].([]bson.M)[2]
+	assert.Equal(t, bson.M{"$or": []bson.M{{"audience.cohorts": nil}}}, cohortClause,
+		"入学年度が不明なユーザーには入学年度指定のお知らせを表示しないこと")
+}
+
+// TestAudienceSubscriptionStatus は配信対象の判定に使う契約状態が利用権の判定と一致することをテストする
+func TestAudienceSubscriptionStatus(t *testing.T) {
+	now := time.Now()
+	pausedAt := now.AddDate(0, 0, -3)
+
+	tests := []struct {
+		name     string
+		sub      Subscription
+		expected string
+	}{
+		{name: "契約なし", sub: Subscription{}, expected: subscriptionStatusNone},
+		{name: "契約中", sub: Subscription{Status: "active", CurrentPeriodEnd: now.AddDate(0, 1, 0)}, expected: "active"},
+		{name: "トライアル中", sub: Subscription{Status: "trialing", CurrentPeriodEnd: now.AddDate(0, 0, 7)}, expected: "trialing"},
+		{name: "休止中", sub: Subscription{Status: "active", CurrentPeriodEnd: now.AddDate(0, 1, 0), PausedAt: &pausedAt}, expected: "paused"},
+		{name: "解約予約中で期間内", sub: Subscription{Status: "active", CurrentPeriodEnd: now.AddDate(0, 0, 1), CancelAtPeriodEnd: true}, expected: "active"},
+		{name: "解約予約中で期間終了後", sub: Subscription{Status: "active", CurrentPeriodEnd: now.AddDate(0, 0, -1), CancelAtPeriodEnd: true}, expected: "canceled"},
+		{name: "支払い遅延", sub: Subscription{Status: "past_due", CurrentPeriodEnd: now.AddDate(0, 1, 0)}, expected: "past_due"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, audienceSubscriptionStatus(tt.sub, now))
+		})
+	}
+}
+
+// TestNormalizeAudience は配信対象の検証をテストする
+func TestNormalizeAudience(t *testing.T) {
+	audience, msg := normalizeAudience(&AnnouncementAudience{})
+	assert.Empty(t, msg)
+	assert.Nil(t, audience, "空の配信対象は全員向けとして扱うこと")
+
+	audience, msg = normalizeAudience(&AnnouncementAudience{Roles: []string{"student"}, SubscriptionStatuses: []string{"active", "trialing"}, Cohorts: []int{2025}})
+	assert.Empty(t, msg)
+	assert.NotNil(t, audience)
+
+	_, msg = normalizeAudience(&AnnouncementAudience{Roles: []string{"guest"}})
+	assert.NotEmpty(t, msg)
+	_, msg = normalizeAudience(&AnnouncementAudience{SubscriptionStatuses: []string{"gold"}})
+	assert.NotEmpty(t, msg)
+	_, msg = normalizeAudience(&AnnouncementAudience{Cohorts: []int{25}})
+	assert.NotEmpty(t, msg)
+}
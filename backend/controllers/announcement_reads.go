@@ -0,0 +1,426 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"time"
+
+	"juice_academy_backend/utils"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// announcementReadCollection はお知らせの既読記録（ユーザーごと）への参照
+var announcementReadCollection *mongo.Collection
+
+// AnnouncementRead はユーザーがお知らせを既読にした記録
+type AnnouncementRead struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	AnnouncementID primitive.ObjectID `bson:"announcement_id" json:"announcement_id"`
+	UserID         primitive.ObjectID `bson:"user_id" json:"user_id"`
+	ReadAt         time.Time          `bson:"read_at" json:"read_at"`
+}
+
+// InitAnnouncementReadCollection は既読記録のコレクションを初期化する
+func InitAnnouncementReadCollection(db *mongo.Database) {
+	announcementReadCollection = db.Collection("announcement_reads")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, _ = announcementReadCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			// 同じお知らせを二重に既読登録しない
+			Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "announcement_id", Value: 1}},
+			Options: options.Index().SetUnique(true).SetName("user_announcement_unique"),
+		},
+		{
+			// お知らせごとの既読数の集計に使用する
+			Keys:    bson.D{{Key: "announcement_id", Value: 1}},
+			Options: options.Index().SetName("announcement_id_idx"),
+		},
+	})
+}
+
+// markAnnouncementsRead はお知らせを既読として記録する（既読済みの場合は最初の既読日時を維持する）
+func markAnnouncementsRead(ctx context.Context, userID primitive.ObjectID, announcementIDs []primitive.ObjectID, now time.Time) error {
+	if len(announcementIDs) == 0 {
+		return nil
+	}
+
+	models := make([]mongo.WriteModel, 0, len(announcementIDs))
+	for _, id := range announcementIDs {
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"user_id": userID, "announcement_id": id}).
+			SetUpdate(bson.M{"$setOnInsert": bson.M{"read_at": now}}).
+			SetUpsert(true))
+	}
+
+	_, err := announcementReadCollection.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+	if err != nil && !isOnlyDuplicateKeyError(err) {
+		return err
+	}
+	return nil
+}
+
+// isOnlyDuplicateKeyError は同時実行された upsert の競合のみによるエラーかを返す
+// 競合した側もすでに既読が記録されているため成功として扱える
+func isOnlyDuplicateKeyError(err error) bool {
+	var bulkErr mongo.BulkWriteException
+	if !errors.As(err, &bulkErr) || bulkErr.WriteConcernError != nil || len(bulkErr.WriteErrors) == 0 {
+		return mongo.IsDuplicateKeyError(err)
+	}
+	for _, writeErr := range bulkErr.WriteErrors {
+		if writeErr.Code != 11000 {
+			return false
+		}
+	}
+	return true
+}
+
+// readAnnouncementIDs は指定したお知らせのうちユーザーが既読にしたものを返す
+func readAnnouncementIDs(ctx context.Context, userID primitive.ObjectID, announcementIDs []primitive.ObjectID) (map[primitive.ObjectID]bool, error) {
+	read := make(map[primitive.ObjectID]bool)
+	if len(announcementIDs) == 0 {
+		return read, nil
+	}
+
+	cursor, err := announcementReadCollection.Find(ctx,
+		bson.M{"user_id": userID, "announcement_id": bson.M{"$in": announcementIDs}},
+		options.Find().SetProjection(bson.M{"announcement_id": 1}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var records []AnnouncementRead
+	if err := cursor.All(ctx, &records); err != nil {
+		return nil, err
+	}
+	for _, record := range records {
+		read[record.AnnouncementID] = true
+	}
+	return read, nil
+}
+
+// annotateReadState はログイン中の閲覧者について各お知らせの既読状態（isRead）を設定する
+// 未ログインの閲覧者には設定しない
+func annotateReadState(ctx context.Context, viewer announcementViewer, announcements []Announcement) error {
+	if viewer.Anonymous || viewer.UserID.IsZero() {
+		return nil
+	}
+
+	ids := make([]primitive.ObjectID, len(announcements))
+	for i := range announcements {
+		ids[i] = announcements[i].ID
+	}
+	read, err := readAnnouncementIDs(ctx, viewer.UserID, ids)
+	if err != nil {
+		return err
+	}
+	for i := range announcements {
+		isRead := read[announcements[i].ID]
+		announcements[i].IsRead = &isRead
+	}
+	return nil
+}
+
+// resolveReadingViewer は既読管理 API の閲覧者を読み込む（失敗時はレスポンスを返して false）
+func resolveReadingViewer(c *gin.Context) (announcementViewer, bool) {
+	viewer, err := resolveAnnouncementViewer(c)
+	if err != nil {
+		utils.LogErrorCtx(c.Request.Context(), "AnnouncementReads", err, "Failed to resolve viewer")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "ユーザー情報の取得に失敗しました"})
+		return announcementViewer{}, false
+	}
+	if viewer.Anonymous {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "認証が必要です"})
+		return announcementViewer{}, false
+	}
+	return viewer, true
+}
+
+// visibleAnnouncementFilter は閲覧者に現在表示されるお知らせの条件を返す
+func visibleAnnouncementFilter(viewer announcementViewer, now time.Time) bson.M {
+	return bson.M{"$and": []bson.M{liveAnnouncementFilter(now), audienceFilter(viewer)}}
+}
+
+// unreadCountPipeline は表示中のお知らせのうち未読の件数（うち重要なもの）を集計するパイプラインを返す
+func unreadCountPipeline(userID primitive.ObjectID, visible bson.M) mongo.Pipeline {
+	return mongo.Pipeline{
+		{{Key: "$match", Value: visible}},
+		{{Key: "$project", Value: bson.M{"important": 1}}},
+		{{Key: "$lookup", Value: bson.M{
+			"from": "announcement_reads",
+			"let":  bson.M{"announcement_id": "$_id"},
+			"pipeline": mongo.Pipeline{
+				{{Key: "$match", Value: bson.M{"$expr": bson.M{"$and": bson.A{
+					bson.M{"$eq": bson.A{"$user_id", userID}},
+					bson.M{"$eq": bson.A{"$announcement_id", "$$announcement_id"}},
+				}}}}},
+				{{Key: "$limit", Value: 1}},
+			},
+			"as": "reads",
+		}}},
+		{{Key: "$match", Value: bson.M{"reads": bson.M{"$size": 0}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":              nil,
+			"unread":           bson.M{"$sum": 1},
+			"unread_important": bson.M{"$sum": bson.M{"$cond": bson.A{"$important", 1, 0}}},
+		}}},
+	}
+}
+
+// GetUnreadAnnouncementCountHandler はログイン中のユーザーの未読お知らせ件数を返すハンドラ
+func GetUnreadAnnouncementCountHandler(c *gin.Context) {
+	ctx := c.Request.Context()
+	viewer, ok := resolveReadingViewer(c)
+	if !ok {
+		return
+	}
+
+	cursor, err := announcementCollection.Aggregate(ctx, unreadCountPipeline(viewer.UserID, visibleAnnouncementFilter(viewer, time.Now())))
+	if err != nil {
+		utils.LogErrorCtx(ctx, "UnreadAnnouncementCount", err, "Failed to aggregate unread announcements")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "未読件数の取得に失敗しました"})
+		return
+	}
+	defer cursor.Close(ctx)
+
+	var result struct {
+		Unread          int64 `bson:"unread"`
+		UnreadImportant int64 `bson:"unread_important"`
+	}
+	if cursor.Next(ctx) {
+		if err := cursor.Decode(&result); err != nil {
+			utils.LogErrorCtx(ctx, "UnreadAnnouncementCount", err, "Failed to decode unread count")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "未読件数の取得に失敗しました"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"unread":           result.Unread,
+		"unread_important": result.UnreadImportant,
+	})
+}
+
+// MarkAnnouncementReadHandler はお知らせを既読にするハンドラ
+// 閲覧者に表示されていない（公開中でない・配信対象外の）お知らせは存在しないものとして扱う
+func MarkAnnouncementReadHandler(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "無効なお知らせIDです"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	viewer, ok := resolveReadingViewer(c)
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	filter := visibleAnnouncementFilter(viewer, now)
+	filter["_id"] = id
+	err = announcementCollection.FindOne(ctx, filter, options.FindOne().SetProjection(bson.M{"_id": 1})).Err()
+	if err == mongo.ErrNoDocuments {
+		c.JSON(http.StatusNotFound, gin.H{"error": "お知らせが見つかりません"})
+		return
+	}
+	if err != nil {
+		utils.LogErrorCtx(ctx, "MarkAnnouncementRead", err, "Failed to find announcement")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "既読の記録に失敗しました"})
+		return
+	}
+
+	if err := markAnnouncementsRead(ctx, viewer.UserID, []primitive.ObjectID{id}, now); err != nil {
+		utils.LogErrorCtx(ctx, "MarkAnnouncementRead", err, "Failed to record read receipt")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "既読の記録に失敗しました"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "お知らせを既読にしました"})
+}
+
+// MarkAllAnnouncementsReadHandler は表示中のお知らせをすべて既読にするハンドラ
+func MarkAllAnnouncementsReadHandler(c *gin.Context) {
+	ctx := c.Request.Context()
+	viewer, ok := resolveReadingViewer(c)
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	cursor, err := announcementCollection.Find(ctx, visibleAnnouncementFilter(viewer, now), options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		utils.LogErrorCtx(ctx, "MarkAllAnnouncementsRead", err, "Failed to list announcements")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "既読の記録に失敗しました"})
+		return
+	}
+	var visible []struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	err = cursor.All(ctx, &visible)
+	if err != nil {
+		utils.LogErrorCtx(ctx, "MarkAllAnnouncementsRead", err, "Failed to decode announcements")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "既読の記録に失敗しました"})
+		return
+	}
+
+	ids := make([]primitive.ObjectID, len(visible))
+	for i, v := range visible {
+		ids[i] = v.ID
+	}
+	if err := markAnnouncementsRead(ctx, viewer.UserID, ids, now); err != nil {
+		utils.LogErrorCtx(ctx, "MarkAllAnnouncementsRead", err, "Failed to record read receipts")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "既読の記録に失敗しました"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "すべてのお知らせを既読にしました", "count": len(ids)})
+}
+
+// audienceUserStages は配信対象となるユーザーに絞り込む集計ステージを返す
+// 管理者・利用停止中・パスワード未設定（一括登録後に未承諾）のユーザーは対象に含めない
+func audienceUserStages(audience *AnnouncementAudience) mongo.Pipeline {
+	match := bson.M{
+		"is_admin":  bson.M{"$ne": true},
+		"role":      bson.M{"$ne": "admin"},
+		"suspended": bson.M{"$ne": true},
+		"status":    bson.M{"$ne": "pending"},
+	}
+	if audience != nil && len(audience.Roles) > 0 {
+		match["role"] = bson.M{"$in": audience.Roles, "$ne": "admin"}
+	}
+	if audience != nil && len(audience.Cohorts) > 0 {
+		match["entry_year"] = bson.M{"$in": audience.Cohorts}
+	}
+	stages := mongo.Pipeline{{{Key: "$match", Value: match}}}
+
+	if audience != nil && len(audience.SubscriptionStatuses) > 0 {
+		// 状態は resolveAnnouncementViewer と同じ判定で求める（サブスクリプションを持たないユーザーは "none"）
+		stages = append(stages,
+			bson.D{{Key: "$lookup", Value: bson.M{
+				"from":         "subscriptions",
+				"localField":   "_id",
+				"foreignField": "user_id",
+				"as":           "subscription",
+			}}},
+			bson.D{{Key: "$addFields", Value: bson.M{
+				"subscription_status": audienceSubscriptionStatusExpr(),
+			}}},
+			bson.D{{Key: "$match", Value: bson.M{"subscription_status": bson.M{"$in": audience.SubscriptionStatuses}}}},
+		)
+	}
+	return stages
+}
+
+// announcementReadCountPipeline は配信対象のユーザーによる既読数を集計するパイプラインを返す
+func announcementReadCountPipeline(announcementID primitive.ObjectID, audience *AnnouncementAudience) mongo.Pipeline {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"announcement_id": announcementID}}},
+		{{Key: "$lookup", Value: bson.M{
+			"from":         "users",
+			"localField":   "user_id",
+			"foreignField": "_id",
+			"as":           "user",
+		}}},
+		{{Key: "$unwind", Value: "$user"}},
+		{{Key: "$replaceRoot", Value: bson.M{"newRoot": "$user"}}},
+	}
+	pipeline = append(pipeline, audienceUserStages(audience)...)
+	return append(pipeline, bson.D{{Key: "$count", Value: "count"}})
+}
+
+// countAggregate は $count ステージで終わるパイプラインの結果を返す
+func countAggregate(ctx context.Context, collection *mongo.Collection, pipeline mongo.Pipeline) (int64, error) {
+	cursor, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var result struct {
+		Count int64 `bson:"count"`
+	}
+	if cursor.Next(ctx) {
+		if err := cursor.Decode(&result); err != nil {
+			return 0, err
+		}
+	}
+	return result.Count, cursor.Err()
+}
+
+// readRate は既読率（0〜1、小数第4位まで）を返す
+func readRate(readCount, audienceSize int64) float64 {
+	if audienceSize == 0 {
+		return 0
+	}
+	rate := float64(readCount) / float64(audienceSize)
+	if rate > 1 {
+		rate = 1
+	}
+	return math.Round(rate*10000) / 10000
+}
+
+// GetAnnouncementStatsHandler はお知らせの既読数・配信対象数・既読率を返すハンドラ（管理者専用）
+// 既読数は現在の配信対象に含まれるユーザーの既読のみを数える
+func GetAnnouncementStatsHandler(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "無効なお知らせIDです"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	var announcement Announcement
+	err = announcementCollection.FindOne(ctx, bson.M{"_id": id}).Decode(&announcement)
+	if err == mongo.ErrNoDocuments {
+		c.JSON(http.StatusNotFound, gin.H{"error": "お知らせが見つかりません"})
+		return
+	}
+	if err != nil {
+		utils.LogErrorCtx(ctx, "AnnouncementStats", err, "Failed to find announcement")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "統計の取得に失敗しました"})
+		return
+	}
+
+	audienceSize, err := countAggregate(ctx, userCollection,
+		append(audienceUserStages(announcement.Audience), bson.D{{Key: "$count", Value: "count"}}))
+	if err != nil {
+		utils.LogErrorCtx(ctx, "AnnouncementStats", err, "Failed to count audience")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "統計の取得に失敗しました"})
+		return
+	}
+
+	readCount, err := countAggregate(ctx, announcementReadCollection, announcementReadCountPipeline(id, announcement.Audience))
+	if err != nil {
+		utils.LogErrorCtx(ctx, "AnnouncementStats", err, "Failed to count reads")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "統計の取得に失敗しました"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"announcement_id": id.Hex(),
+		"read_count":      readCount,
+		"audience_size":   audienceSize,
+		"read_rate":       readRate(readCount, audienceSize),
+	})
+}
+
+// deleteAnnouncementReads はお知らせの既読記録を削除する
+func deleteAnnouncementReads(ctx context.Context, announcementID primitive.ObjectID) error {
+	if announcementReadCollection == nil {
+		return nil
+	}
+	_, err := announcementReadCollection.DeleteMany(ctx, bson.M{"announcement_id": announcementID})
+	return err
+}
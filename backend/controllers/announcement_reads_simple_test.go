@@ -0,0 +1,77 @@
+package controllers
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// TestReadRate は既読率の計算をテストする
+func TestReadRate(t *testing.T) {
+	assert.Equal(t, 0.0, readRate(0, 0), "配信対象がいない場合は0")
+	assert.Equal(t, 0.0, readRate(0, 10))
+	assert.Equal(t, 0.3333, readRate(1, 3))
+	assert.Equal(t, 1.0, readRate(10, 10))
+	assert.Equal(t, 1.0, readRate(12, 10), "1を超えないこと")
+}
+
+// TestAudienceUserStages は配信対象ユーザーの絞り込み条件をテストする
+func TestAudienceUserStages(t *testing.T) {
+	t.Run("全員向け", func(t *testing.T) {
+		stages := audienceUserStages(nil)
+		assert.Len(t, stages, 1)
+		match := stages[0][0].Value.(bson.M)
+		assert.Equal(t, bson.M{"$ne": true}, match["is_admin"])
+		assert.Equal(t, bson.M{"$ne": true}, match["suspended"])
+		assert.Equal(t, bson.M{"$ne": "pending"}, match["status"])
+		assert.NotContains(t, match, "entry_year")
+	})
+
+	t.Run("ロール・入学年度指定", func(t *testing.T) {
+		stages := audienceUserStages(&AnnouncementAudience{Roles: []string{"teacher"}, Cohorts: []int{2025}})
+		assert.Len(t, stages, 1)
+		match := stages[0][0].Value.(bson.M)
+		assert.Equal(t, bson.M{"$in": []string{"teacher"}, "$ne": "admin"}, match["role"])
+		assert.Equal(t, bson.M{"$in": []int{2025}}, match["entry_year"])
+	})
+
+	t.Run("サブスクリプション状態指定", func(t *testing.T) {
+		stages := audienceUserStages(&AnnouncementAudience{SubscriptionStatuses: []string{"active", subscriptionStatusNone}})
+		assert.Len(t, stages, 4)
+		assert.Equal(t, "$lookup", stages[1][0].Key)
+		last := stages[3][0].Value.(bson.M)
+		assert.Equal(t, bson.M{"$in": []string{"active", subscriptionStatusNone}}, last["subscription_status"])
+	})
+}
+
+// TestAnnouncementReadCountPipeline は既読数の集計が配信対象の条件を共有することをテストする
+func TestAnnouncementReadCountPipeline(t *testing.T) {
+	id := primitive.NewObjectID()
+	pipeline := announcementReadCountPipeline(id, &AnnouncementAudience{Cohorts: []int{2024}})
+
+	assert.Equal(t, bson.M{"announcement_id": id}, pipeline[0][0].Value)
+	assert.Equal(t, "$replaceRoot", pipeline[3][0].Key)
+	assert.Equal(t, "$match", pipeline[4][0].Key)
+	assert.Equal(t, bson.M{"$in": []int{2024}}, pipeline[4][0].Value.(bson.M)["entry_year"])
+	assert.Equal(t, "$count", pipeline[len(pipeline)-1][0].Key)
+}
+
+// TestIsOnlyDuplicateKeyError は upsert の競合のみを成功として扱うことをテストする
+func TestIsOnlyDuplicateKeyError(t *testing.T) {
+	duplicate := mongo.BulkWriteException{WriteErrors: []mongo.BulkWriteError{
+		{WriteError: mongo.WriteError{Code: 11000}},
+	}}
+	assert.True(t, isOnlyDuplicateKeyError(duplicate))
+
+	mixed := mongo.BulkWriteException{WriteErrors: []mongo.BulkWriteError{
+		{WriteError: mongo.WriteError{Code: 11000}},
+		{WriteError: mongo.WriteError{Code: 121}},
+	}}
+	assert.False(t, isOnlyDuplicateKeyError(mixed))
+
+	assert.False(t, isOnlyDuplicateKeyError(errors.New("connection reset")))
+}
@@ -0,0 +1,141 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// errAnnouncementNotFound はお知らせが存在しない（または閲覧者に表示できない）ことを表す
+var errAnnouncementNotFound = errors.New("announcement not found")
+
+// announcementRepository はお知らせと編集履歴の保存先
+// 本番では MongoDB を使い、テストではメモリ上の実装に差し替える
+type announcementRepository interface {
+	// Insert はお知らせを保存し、採番した ID を a.ID に設定する
+	Insert(ctx context.Context, a *Announcement) error
+	// FindActive はゴミ箱にないお知らせを公開状態にかかわらず取得する
+	FindActive(ctx context.Context, id primitive.ObjectID) (*Announcement, error)
+	// FindVisible は閲覧者に表示できる公開中のお知らせを取得する
+	FindVisible(ctx context.Context, id primitive.ObjectID, viewer announcementViewer, now time.Time) (*Announcement, error)
+	// ListVisible は閲覧者に表示できる公開中のお知らせを、ピン留め・重要・公開日時の順に取得する
+	ListVisible(ctx context.Context, viewer announcementViewer, now time.Time, params announcementListParams) (*announcementPage, error)
+	// ListByStatus はゴミ箱にないお知らせを公開状態で絞り込み、作成日時の新しい順に取得する
+	ListByStatus(ctx context.Context, status string, now time.Time, params announcementListParams) (*announcementPage, error)
+	// Update は編集可能な項目を保存して版番号を1つ進める
+	// a.Version が保存済みの版と異なる場合は errAnnouncementConflict を返す
+	Update(ctx context.Context, a *Announcement) error
+	// Trash はお知らせをゴミ箱に移動する
+	Trash(ctx context.Context, id, deletedBy primitive.ObjectID, now time.Time) error
+	// RecordRevision は編集履歴に版を追加する
+	RecordRevision(ctx context.Context, revision AnnouncementRevision) error
+}
+
+// validAnnouncementStatus は管理画面の状態フィルタとして指定できる値かを返す
+func validAnnouncementStatus(status string) bool {
+	_, ok := announcementStatusFilter(status, time.Time{})
+	return ok
+}
+
+// mongoAnnouncementRepository は announcements / announcement_revisions コレクションを使う実装
+type mongoAnnouncementRepository struct{}
+
+func (mongoAnnouncementRepository) Insert(ctx context.Context, a *Announcement) error {
+	result, err := announcementCollection.InsertOne(ctx, a)
+	if err != nil {
+		return err
+	}
+	a.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+func (mongoAnnouncementRepository) findOne(ctx context.Context, filter bson.M) (*Announcement, error) {
+	var announcement Announcement
+	if err := announcementCollection.FindOne(ctx, filter).Decode(&announcement); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errAnnouncementNotFound
+		}
+		return nil, err
+	}
+	return &announcement, nil
+}
+
+func (r mongoAnnouncementRepository) FindActive(ctx context.Context, id primitive.ObjectID) (*Announcement, error) {
+	return r.findOne(ctx, bson.M{"_id": id, "deleted_at": nil})
+}
+
+func (r mongoAnnouncementRepository) FindVisible(ctx context.Context, id primitive.ObjectID, viewer announcementViewer, now time.Time) (*Announcement, error) {
+	filter := visibleAnnouncementFilter(viewer, now)
+	filter["_id"] = id
+	return r.findOne(ctx, filter)
+}
+
+func (mongoAnnouncementRepository) ListVisible(ctx context.Context, viewer announcementViewer, now time.Time, params announcementListParams) (*announcementPage, error) {
+	return listAnnouncementsPage(ctx, visibleAnnouncementFilter(viewer, now), "publish_at", true, params)
+}
+
+func (mongoAnnouncementRepository) ListByStatus(ctx context.Context, status string, now time.Time, params announcementListParams) (*announcementPage, error) {
+	filter, ok := announcementStatusFilter(status, now)
+	if !ok {
+		return nil, errors.New("unknown announcement status: " + status)
+	}
+	return listAnnouncementsPage(ctx, filter, "created_at", false, params)
+}
+
+func (mongoAnnouncementRepository) Update(ctx context.Context, a *Announcement) error {
+	filter := bson.M{"_id": a.ID, "deleted_at": nil, "version": a.Version}
+	if a.Version == 0 {
+		filter["version"] = bson.M{"$in": bson.A{nil, 0}}
+	}
+
+	result, err := announcementCollection.UpdateOne(ctx, filter, bson.M{
+		"$set": bson.M{
+			"title":        a.Title,
+			"content":      a.Content,
+			"content_html": a.ContentHTML,
+			"category":     a.Category,
+			"pinned":       a.Pinned,
+			"important":    a.Important,
+			"audience":     a.Audience,
+			"members_only": a.MembersOnly,
+			"is_published": a.IsPublished,
+			"publish_at":   a.PublishAt,
+			"expires_at":   a.ExpiresAt,
+			"notify_email": a.NotifyEmail,
+			"updated_at":   a.UpdatedAt,
+		},
+		"$inc": bson.M{"version": 1},
+	})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return errAnnouncementConflict
+	}
+	a.Version++
+	return nil
+}
+
+func (mongoAnnouncementRepository) Trash(ctx context.Context, id, deletedBy primitive.ObjectID, now time.Time) error {
+	set := bson.M{"deleted_at": now}
+	if !deletedBy.IsZero() {
+		set["deleted_by"] = deletedBy
+	}
+	result, err := announcementCollection.UpdateOne(ctx, bson.M{"_id": id, "deleted_at": nil}, bson.M{"$set": set})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return errAnnouncementNotFound
+	}
+	return nil
+}
+
+func (mongoAnnouncementRepository) RecordRevision(ctx context.Context, revision AnnouncementRevision) error {
+	_, err := announcementRevisionCollection.InsertOne(ctx, revision)
+	return err
+}
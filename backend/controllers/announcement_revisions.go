@@ -0,0 +1,366 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"time"
+
+	"juice_academy_backend/middleware"
+	"juice_academy_backend/utils"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// announcementRevisionCollection はお知らせの編集履歴への参照
+var announcementRevisionCollection *mongo.Collection
+
+// 編集履歴の種類
+const (
+	RevisionActionCreated  = "created"
+	RevisionActionUpdated  = "updated"
+	RevisionActionRestored = "restored"
+	// RevisionActionLegacy は履歴の記録を始める前から存在したお知らせの状態
+	RevisionActionLegacy = "legacy"
+)
+
+// errAnnouncementConflict は編集中に他の管理者がお知らせを更新したことを表す
+var errAnnouncementConflict = errors.New("announcement was modified concurrently")
+
+// AnnouncementSnapshot は編集履歴として保存するお知らせの内容（添付ファイルは含まない）
+type AnnouncementSnapshot struct {
+	Title       string                `json:"title" bson:"title"`
+	Content     string                `json:"content" bson:"content"`
+	ContentHTML string                `json:"contentHtml" bson:"content_html"`
+	Category    string                `json:"category" bson:"category"`
+	Pinned      bool                  `json:"pinned" bson:"pinned"`
+	Important   bool                  `json:"important" bson:"important"`
+	Audience    *AnnouncementAudience `json:"audience,omitempty" bson:"audience,omitempty"`
+	MembersOnly bool                  `json:"membersOnly" bson:"members_only"`
+	IsPublished bool                  `json:"isPublished" bson:"is_published"`
+	PublishAt   *time.Time            `json:"publishAt,omitempty" bson:"publish_at,omitempty"`
+	ExpiresAt   *time.Time            `json:"expiresAt,omitempty" bson:"expires_at,omitempty"`
+}
+
+// AnnouncementRevision はお知らせの編集履歴（版）
+type AnnouncementRevision struct {
+	ID             primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	AnnouncementID primitive.ObjectID `json:"announcementId" bson:"announcement_id"`
+	Version        int                `json:"version" bson:"version"`
+	Action         string             `json:"action" bson:"action"`
+	// RestoredFrom は過去の版を復元した場合の復元元の版
+	RestoredFrom int                  `json:"restoredFrom,omitempty" bson:"restored_from,omitempty"`
+	AuthorID     primitive.ObjectID   `json:"authorId,omitempty" bson:"author_id,omitempty"`
+	CreatedAt    time.Time            `json:"createdAt" bson:"created_at"`
+	Snapshot     AnnouncementSnapshot `json:"snapshot" bson:"snapshot"`
+}
+
+// InitAnnouncementRevisionCollection は編集履歴のコレクションを初期化する
+// 履歴を持たない既存のお知らせは現在の内容を第1版として記録する
+func InitAnnouncementRevisionCollection(db *mongo.Database) {
+	announcementRevisionCollection = db.Collection("announcement_revisions")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, _ = announcementRevisionCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "announcement_id", Value: 1}, {Key: "version", Value: -1}},
+			Options: options.Index().SetUnique(true).SetName("announcement_version_unique"),
+		},
+	})
+
+	if err := backfillAnnouncementRevisions(ctx); err != nil {
+		utils.LogError("InitAnnouncementRevisionCollection", err, "failed to record initial revisions")
+	}
+}
+
+// snapshot はお知らせの編集可能な項目を取り出す
+func (a *Announcement) snapshot() AnnouncementSnapshot {
+	return AnnouncementSnapshot{
+		Title:       a.Title,
+		Content:     a.Content,
+		ContentHTML: a.ContentHTML,
+		Category:    a.Category,
+		Pinned:      a.Pinned,
+		Important:   a.Important,
+		Audience:    a.Audience,
+		MembersOnly: a.MembersOnly,
+		IsPublished: a.IsPublished,
+		PublishAt:   a.PublishAt,
+		ExpiresAt:   a.ExpiresAt,
+	}
+}
+
+// restore は編集履歴の内容をお知らせに戻す
+func (a *Announcement) restore(s AnnouncementSnapshot) {
+	a.Title = s.Title
+	a.Content = s.Content
+	a.ContentHTML = s.ContentHTML
+	a.Category = s.Category
+	a.Pinned = s.Pinned
+	a.Important = s.Important
+	a.Audience = s.Audience
+	a.MembersOnly = s.MembersOnly
+	a.IsPublished = s.IsPublished
+	a.PublishAt = s.PublishAt
+	a.ExpiresAt = s.ExpiresAt
+}
+
+// backfillAnnouncementRevisions は version を持たない既存のお知らせに第1版を記録する
+func backfillAnnouncementRevisions(ctx context.Context) error {
+	cursor, err := announcementCollection.Find(ctx, bson.M{"version": bson.M{"$exists": false}})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var announcement Announcement
+		if err := cursor.Decode(&announcement); err != nil {
+			return err
+		}
+		announcement.Version = 1
+		revision := newAnnouncementRevision(&announcement, RevisionActionLegacy, primitive.NilObjectID, 0, announcement.UpdatedAt)
+		if _, err := announcementRevisionCollection.InsertOne(ctx, revision); err != nil && !mongo.IsDuplicateKeyError(err) {
+			return err
+		}
+		if _, err := announcementCollection.UpdateOne(ctx,
+			bson.M{"_id": announcement.ID, "version": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"version": 1}},
+		); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+// newAnnouncementRevision はお知らせの現在の内容から版を作成する
+func newAnnouncementRevision(a *Announcement, action string, authorID primitive.ObjectID, restoredFrom int, now time.Time) AnnouncementRevision {
+	return AnnouncementRevision{
+		AnnouncementID: a.ID,
+		Version:        a.Version,
+		Action:         action,
+		RestoredFrom:   restoredFrom,
+		AuthorID:       authorID,
+		CreatedAt:      now,
+		Snapshot:       a.snapshot(),
+	}
+}
+
+// findAnnouncementRevision は指定した版を取得する
+func findAnnouncementRevision(ctx context.Context, announcementID primitive.ObjectID, version int) (*AnnouncementRevision, error) {
+	var revision AnnouncementRevision
+	err := announcementRevisionCollection.FindOne(ctx, bson.M{"announcement_id": announcementID, "version": version}).Decode(&revision)
+	if err != nil {
+		return nil, err
+	}
+	return &revision, nil
+}
+
+// parseRevisionVersion は版番号のパラメータを解釈する
+func parseRevisionVersion(value string) (int, bool) {
+	version, err := strconv.Atoi(value)
+	return version, err == nil && version >= 1
+}
+
+// ListAnnouncementRevisionsHandler はお知らせの編集履歴を新しい順に返すハンドラ（管理者専用）
+// 一覧では本文を省略し、タイトルと版の情報のみを返す
+func ListAnnouncementRevisionsHandler(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "無効なお知らせIDです"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	cursor, err := announcementRevisionCollection.Find(ctx,
+		bson.M{"announcement_id": id},
+		options.Find().
+			SetSort(bson.D{{Key: "version", Value: -1}}).
+			SetProjection(bson.M{"snapshot.content": 0, "snapshot.content_html": 0}),
+	)
+	if err != nil {
+		utils.LogErrorCtx(ctx, "AnnouncementRevision", err, "Failed to list revisions")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "編集履歴の取得に失敗しました"})
+		return
+	}
+	revisions := []AnnouncementRevision{}
+	if err := cursor.All(ctx, &revisions); err != nil {
+		utils.LogErrorCtx(ctx, "AnnouncementRevision", err, "Failed to decode revisions")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "編集履歴の取得に失敗しました"})
+		return
+	}
+	if len(revisions) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "お知らせが見つかりません"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"revisions": revisions, "count": len(revisions)})
+}
+
+// GetAnnouncementRevisionHandler は指定した版の内容を返すハンドラ（管理者専用）
+func GetAnnouncementRevisionHandler(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "無効なお知らせIDです"})
+		return
+	}
+	version, ok := parseRevisionVersion(c.Param("version"))
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "無効な版番号です"})
+		return
+	}
+
+	revision, err := findAnnouncementRevision(c.Request.Context(), id, version)
+	if err == mongo.ErrNoDocuments {
+		c.JSON(http.StatusNotFound, gin.H{"error": "指定した版が見つかりません"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "編集履歴の取得に失敗しました"})
+		return
+	}
+	c.JSON(http.StatusOK, revision)
+}
+
+// revisionFieldChange は項目の変更前後の値
+type revisionFieldChange struct {
+	From interface{} `json:"from"`
+	To   interface{} `json:"to"`
+}
+
+// diffSnapshots は本文以外の項目の変更を返す（本文は行単位の差分で別に返す）
+func diffSnapshots(from, to AnnouncementSnapshot) map[string]revisionFieldChange {
+	fields := []struct {
+		name     string
+		from, to interface{}
+	}{
+		{"title", from.Title, to.Title},
+		{"category", from.Category, to.Category},
+		{"pinned", from.Pinned, to.Pinned},
+		{"important", from.Important, to.Important},
+		{"audience", from.Audience, to.Audience},
+		{"membersOnly", from.MembersOnly, to.MembersOnly},
+		{"isPublished", from.IsPublished, to.IsPublished},
+		{"publishAt", from.PublishAt, to.PublishAt},
+		{"expiresAt", from.ExpiresAt, to.ExpiresAt},
+	}
+
+	changes := map[string]revisionFieldChange{}
+	for _, field := range fields {
+		if !snapshotValueEqual(field.from, field.to) {
+			changes[field.name] = revisionFieldChange{From: field.from, To: field.to}
+		}
+	}
+	return changes
+}
+
+// snapshotValueEqual は版の項目を比較する（日時は保存時の精度の違いを無視して比較する）
+func snapshotValueEqual(a, b interface{}) bool {
+	ta, aIsTime := a.(*time.Time)
+	tb, bIsTime := b.(*time.Time)
+	if aIsTime && bIsTime {
+		if ta == nil || tb == nil {
+			return ta == nil && tb == nil
+		}
+		return ta.Truncate(time.Millisecond).Equal(tb.Truncate(time.Millisecond))
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// DiffAnnouncementRevisionsHandler は2つの版の差分を返すハンドラ（管理者専用）
+// from / to に版番号を指定する（to を省略した場合は最新の版と比較する）
+func DiffAnnouncementRevisionsHandler(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "無効なお知らせIDです"})
+		return
+	}
+	fromVersion, ok := parseRevisionVersion(c.Query("from"))
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from に比較元の版番号を指定してください"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	var to *AnnouncementRevision
+	if c.Query("to") == "" {
+		var latest AnnouncementRevision
+		err = announcementRevisionCollection.FindOne(ctx,
+			bson.M{"announcement_id": id},
+			options.FindOne().SetSort(bson.D{{Key: "version", Value: -1}}),
+		).Decode(&latest)
+		to = &latest
+	} else {
+		toVersion, ok := parseRevisionVersion(c.Query("to"))
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "無効な版番号です"})
+			return
+		}
+		to, err = findAnnouncementRevision(ctx, id, toVersion)
+	}
+	if err == nil {
+		var from *AnnouncementRevision
+		from, err = findAnnouncementRevision(ctx, id, fromVersion)
+		if err == nil {
+			c.JSON(http.StatusOK, gin.H{
+				"from":    from.Version,
+				"to":      to.Version,
+				"changes": diffSnapshots(from.Snapshot, to.Snapshot),
+				"content": utils.DiffLines(from.Snapshot.Content, to.Snapshot.Content),
+			})
+			return
+		}
+	}
+
+	if err == mongo.ErrNoDocuments {
+		c.JSON(http.StatusNotFound, gin.H{"error": "指定した版が見つかりません"})
+		return
+	}
+	utils.LogErrorCtx(ctx, "AnnouncementRevision", err, "Failed to load revisions for diff")
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "編集履歴の取得に失敗しました"})
+}
+
+// RestoreAnnouncementRevisionHandler は過去の版の内容に戻すハンドラ（管理者専用）
+// 復元も1回の編集として新しい版を記録するため、復元前の内容も履歴に残る
+func RestoreAnnouncementRevisionHandler(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "無効なお知らせIDです"})
+		return
+	}
+	version, ok := parseRevisionVersion(c.Param("version"))
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "無効な版番号です"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	revision, err := findAnnouncementRevision(ctx, id, version)
+	if err == mongo.ErrNoDocuments {
+		c.JSON(http.StatusNotFound, gin.H{"error": "指定した版が見つかりません"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "お知らせの復元に失敗しました"})
+		return
+	}
+
+	adminID, _ := middleware.CurrentUserID(c)
+	announcement, err := announcementSvc.RestoreRevision(ctx, id, revision, adminID, time.Now())
+	if err != nil {
+		respondAnnouncementError(c, "AnnouncementRevision", err, "お知らせの復元に失敗しました")
+		return
+	}
+
+	utils.LogInfoCtx(ctx, "AnnouncementRevision", fmt.Sprintf("Announcement %s restored to version %d by %s", id.Hex(), version, adminID.Hex()))
+	c.JSON(http.StatusOK, announcement)
+}
@@ -0,0 +1,89 @@
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestAnnouncementSnapshotRoundTrip は版の内容をお知らせに戻せることをテストする
+func TestAnnouncementSnapshotRoundTrip(t *testing.T) {
+	publishAt := time.Date(2026, 4, 1, 9, 0, 0, 0, time.UTC)
+	original := Announcement{
+		Title:       "春期講習のお知らせ",
+		Content:     "**申込期限**は3月末です",
+		ContentHTML: "<p><strong>申込期限</strong>は3月末です</p>",
+		Category:    "event",
+		Pinned:      true,
+		Audience:    &AnnouncementAudience{Roles: []string{"student"}},
+		IsPublished: true,
+		PublishAt:   &publishAt,
+		Version:     3,
+	}
+
+	edited := original
+	edited.Title = "春期講習（追加日程）のお知らせ"
+	edited.Pinned = false
+	edited.restore(original.snapshot())
+
+	assert.Equal(t, original, edited)
+}
+
+// TestDiffSnapshots は本文以外の変更項目の検出をテストする
+func TestDiffSnapshots(t *testing.T) {
+	publishAt := time.Date(2026, 4, 1, 9, 0, 0, 0, time.UTC)
+	from := AnnouncementSnapshot{Title: "旧タイトル", Content: "本文", Category: "general", PublishAt: &publishAt}
+
+	t.Run("変更なし", func(t *testing.T) {
+		// 保存時に丸められた日時は同じ値として扱う
+		roundTripped := publishAt.Add(300 * time.Microsecond)
+		to := from
+		to.PublishAt = &roundTripped
+		assert.Empty(t, diffSnapshots(from, to))
+	})
+
+	t.Run("本文以外の変更", func(t *testing.T) {
+		to := from
+		to.Title = "新タイトル"
+		to.Content = "本文（修正）"
+		to.Important = true
+		to.PublishAt = nil
+
+		changes := diffSnapshots(from, to)
+		assert.Len(t, changes, 3, "本文の変更は項目の差分に含めないこと")
+		assert.Equal(t, revisionFieldChange{From: "旧タイトル", To: "新タイトル"}, changes["title"])
+		assert.Equal(t, revisionFieldChange{From: false, To: true}, changes["important"])
+		assert.Contains(t, changes, "publishAt")
+	})
+
+	t.Run("配信対象の変更", func(t *testing.T) {
+		to := from
+		to.Audience = &AnnouncementAudience{Cohorts: []int{2026}}
+		assert.Contains(t, diffSnapshots(from, to), "audience")
+	})
+}
+
+// TestParseRevisionVersion は版番号の解釈をテストする
+func TestParseRevisionVersion(t *testing.T) {
+	version, ok := parseRevisionVersion("12")
+	assert.True(t, ok)
+	assert.Equal(t, 12, version)
+
+	for _, value := range []string{"", "0", "-1", "1.5", "abc"} {
+		_, ok := parseRevisionVersion(value)
+		assert.False(t, ok, value)
+	}
+}
+
+// TestAnnouncementFiltersExcludeTrash はゴミ箱のお知らせが公開・管理一覧から除かれることをテストする
+func TestAnnouncementFiltersExcludeTrash(t *testing.T) {
+	now := time.Now()
+	assert.Contains(t, liveAnnouncementFilter(now), "deleted_at")
+	for _, status := range []string{"", AnnouncementStatusDraft, AnnouncementStatusScheduled, AnnouncementStatusExpired, AnnouncementStatusPublished} {
+		filter, ok := announcementStatusFilter(status, now)
+		assert.True(t, ok)
+		assert.Nil(t, filter["deleted_at"], status)
+		assert.Contains(t, filter, "deleted_at", status)
+	}
+}
@@ -0,0 +1,149 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"juice_academy_backend/services"
+	"juice_academy_backend/utils"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// announcementInputError は入力内容の検証エラー（メッセージはそのまま利用者に返す）
+type announcementInputError struct {
+	message string
+}
+
+func (e *announcementInputError) Error() string {
+	return e.message
+}
+
+// announcementService はお知らせの作成・取得・更新・削除をまとめる
+// 保存先は announcementRepository を通して扱い、編集履歴の記録とリアルタイム配信もここで行う
+type announcementService struct {
+	repo announcementRepository
+}
+
+// announcementSvc はハンドラが使用するお知らせのサービス
+var announcementSvc = &announcementService{repo: mongoAnnouncementRepository{}}
+
+// Create は入力を検証してお知らせを作成し、第1版を記録する
+// isPublished を省略した場合は即時公開する
+func (s *announcementService) Create(ctx context.Context, input announcementInput, authorID primitive.ObjectID, now time.Time) (*Announcement, error) {
+	announcement := &Announcement{IsPublished: true, CreatedAt: now, UpdatedAt: now, Version: 1}
+	if msg := input.apply(announcement, now); msg != "" {
+		return nil, &announcementInputError{message: msg}
+	}
+
+	if err := s.repo.Insert(ctx, announcement); err != nil {
+		return nil, err
+	}
+	if err := s.repo.RecordRevision(ctx, newAnnouncementRevision(announcement, RevisionActionCreated, authorID, 0, now)); err != nil {
+		utils.LogErrorCtx(ctx, "CreateAnnouncement", err, "Failed to record initial revision")
+	}
+
+	notifyAnnouncementChanged(ctx, announcement, now)
+	announcement.Status = announcement.StatusAt(now)
+	return announcement, nil
+}
+
+// Get は閲覧者に表示できる公開中のお知らせを取得する
+// 公開中でない、または配信対象外のお知らせは errAnnouncementNotFound を返す
+func (s *announcementService) Get(ctx context.Context, id primitive.ObjectID, viewer announcementViewer, now time.Time) (*Announcement, error) {
+	announcement, err := s.repo.FindVisible(ctx, id, viewer, now)
+	if err != nil {
+		return nil, err
+	}
+	announcement.Status = announcement.StatusAt(now)
+	return announcement, nil
+}
+
+// AdminGet は公開状態にかかわらずお知らせを取得する（ゴミ箱のお知らせは除く）
+func (s *announcementService) AdminGet(ctx context.Context, id primitive.ObjectID, now time.Time) (*Announcement, error) {
+	announcement, err := s.repo.FindActive(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	announcement.Status = announcement.StatusAt(now)
+	return announcement, nil
+}
+
+// List は閲覧者に表示できる公開中のお知らせの一覧を取得する
+func (s *announcementService) List(ctx context.Context, viewer announcementViewer, params announcementListParams, now time.Time) (*announcementPage, error) {
+	return s.repo.ListVisible(ctx, viewer, now, params)
+}
+
+// AdminList は下書き・公開予定・掲載終了を含むお知らせの一覧を取得する
+func (s *announcementService) AdminList(ctx context.Context, status string, params announcementListParams, now time.Time) (*announcementPage, error) {
+	if !validAnnouncementStatus(status) {
+		return nil, &announcementInputError{message: "無効な公開状態です"}
+	}
+	return s.repo.ListByStatus(ctx, status, now, params)
+}
+
+// Update は指定された項目のみ変更して新しい版を記録する
+// 読み込み後に他の管理者が更新していた場合は errAnnouncementConflict を返す
+func (s *announcementService) Update(ctx context.Context, id primitive.ObjectID, input announcementInput, authorID primitive.ObjectID, now time.Time) (*Announcement, error) {
+	announcement, err := s.repo.FindActive(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if msg := input.apply(announcement, now); msg != "" {
+		return nil, &announcementInputError{message: msg}
+	}
+	if err := s.saveRevision(ctx, announcement, RevisionActionUpdated, authorID, 0, now); err != nil {
+		return nil, err
+	}
+	return announcement, nil
+}
+
+// RestoreRevision は過去の版の内容に戻し、復元も1回の編集として新しい版を記録する
+func (s *announcementService) RestoreRevision(ctx context.Context, id primitive.ObjectID, revision *AnnouncementRevision, authorID primitive.ObjectID, now time.Time) (*Announcement, error) {
+	announcement, err := s.repo.FindActive(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	announcement.restore(revision.Snapshot)
+	if err := s.saveRevision(ctx, announcement, RevisionActionRestored, authorID, revision.Version, now); err != nil {
+		return nil, err
+	}
+	return announcement, nil
+}
+
+// saveRevision は編集後のお知らせを保存し、新しい版の記録と変更の配信を行う
+func (s *announcementService) saveRevision(ctx context.Context, a *Announcement, action string, authorID primitive.ObjectID, restoredFrom int, now time.Time) error {
+	a.UpdatedAt = now
+	if err := s.repo.Update(ctx, a); err != nil {
+		return err
+	}
+	if err := s.repo.RecordRevision(ctx, newAnnouncementRevision(a, action, authorID, restoredFrom, now)); err != nil {
+		// お知らせ自体は更新済みのため、履歴の記録に失敗してもエラーにはしない
+		utils.LogErrorCtx(ctx, "AnnouncementRevision", err, fmt.Sprintf("Failed to record revision %d of %s", a.Version, a.ID.Hex()))
+	}
+
+	notifyAnnouncementChanged(ctx, a, now)
+	a.Status = a.StatusAt(now)
+	return nil
+}
+
+// Trash はお知らせをゴミ箱に移動する
+// 添付ファイル・既読記録・編集履歴は残し、ゴミ箱から元に戻せるようにする
+func (s *announcementService) Trash(ctx context.Context, id, deletedBy primitive.ObjectID, now time.Time) error {
+	if err := s.repo.Trash(ctx, id, deletedBy, now); err != nil {
+		return err
+	}
+	publishAnnouncementEvent(ctx, services.EventAnnouncementDeleted, &Announcement{ID: id})
+	return nil
+}
+
+// isAnnouncementInputError は入力内容の検証エラーであればそのメッセージを返す
+func isAnnouncementInputError(err error) (string, bool) {
+	var inputErr *announcementInputError
+	if errors.As(err, &inputErr) {
+		return inputErr.message, true
+	}
+	return "", false
+}
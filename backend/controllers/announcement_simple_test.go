@@ -2,26 +2,39 @@ package controllers
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 // setupAnnouncementTestRouter はお知らせテスト用のGinルーターを作成する
+// 本番と同じハンドラを使い、保存先はメモリ上のリポジトリに差し替える
 func setupAnnouncementTestRouter() *gin.Engine {
 	gin.SetMode(gin.TestMode)
 	router := gin.New()
 
-	// テスト用のルートを設定
 	api := router.Group("/api")
 	{
-		api.GET("/announcements", TestBasicAnnouncementsHandler)
-		api.GET("/announcements/:id", TestBasicAnnouncementByIdHandler)
+		api.GET("/announcements", GetAnnouncementsHandler)
+		api.GET("/announcements/:id", GetAnnouncementByIdHandler)
+	}
+
+	admin := api.Group("/admin")
+	{
+		admin.GET("/announcements", AdminListAnnouncementsHandler)
+		admin.GET("/announcements/:id", AdminGetAnnouncementByIdHandler)
+		admin.POST("/announcements", CreateAnnouncementHandler)
+		admin.PUT("/announcements/:id", UpdateAnnouncementHandler)
+		admin.DELETE("/announcements/:id", DeleteAnnouncementHandler)
 	}
 
 	return router
@@ -44,53 +57,82 @@ func makeAnnouncementRequest(method, url string, body interface{}) *httptest.Res
 	return w
 }
 
+// testAnnouncementsFixture は公開状態の異なるお知らせを用意する
+func testAnnouncementsFixture(now time.Time) (published, pinned, draft, teachers Announcement) {
+	past := now.Add(-time.Hour)
+	earlier := now.Add(-2 * time.Hour)
+	published = Announcement{ID: primitive.NewObjectID(), Title: "テストお知らせ1", Content: "これは最初のテストお知らせです。", IsPublished: true, PublishAt: &past, CreatedAt: past, Version: 1}
+	pinned = Announcement{ID: primitive.NewObjectID(), Title: "テストお知らせ2", Content: "ピン留めしたお知らせです。", Pinned: true, IsPublished: true, PublishAt: &earlier, CreatedAt: earlier, Version: 1}
+	draft = Announcement{ID: primitive.NewObjectID(), Title: "下書き", Content: "まだ公開しないお知らせです。", IsPublished: false, CreatedAt: now, Version: 1}
+	teachers = Announcement{ID: primitive.NewObjectID(), Title: "教員向け", Content: "教員のみのお知らせです。", Audience: &AnnouncementAudience{Roles: []string{"teacher"}}, IsPublished: true, PublishAt: &past, CreatedAt: past, Version: 1}
+	return
+}
+
 // TestGetAnnouncementsHandler はお知らせ一覧取得のテストを行う
 func TestGetAnnouncementsHandler(t *testing.T) {
-	tests := []struct {
-		name               string
-		expectedStatusCode int
-		description        string
-	}{
-		{
-			name:               "お知らせ一覧取得",
-			expectedStatusCode: http.StatusOK,
-			description:        "お知らせ一覧が正常に取得されること",
-		},
-	}
+	published, pinned, draft, teachers := testAnnouncementsFixture(time.Now())
+	useMemoryAnnouncements(t, published, pinned, draft, teachers)
 
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			response := makeAnnouncementRequest("GET", "/api/announcements", nil)
+	response := makeAnnouncementRequest("GET", "/api/announcements", nil)
+	assert.Equal(t, http.StatusOK, response.Code, "お知らせ一覧が正常に取得されること")
 
-			// ステータスコードの検証
-			assert.Equal(t, tt.expectedStatusCode, response.Code, tt.description)
+	// レスポンスがJSONであることを確認
+	contentType := response.Header().Get("Content-Type")
+	assert.True(t, strings.Contains(contentType, "application/json"), "レスポンスはJSON形式であるべき")
 
-			// レスポンスがJSONであることを確認
-			contentType := response.Header().Get("Content-Type")
-			assert.True(t, strings.Contains(contentType, "application/json"), "レスポンスはJSON形式であるべき")
+	var body struct {
+		Announcements []Announcement `json:"announcements"`
+		Count         int            `json:"count"`
+		Total         int64          `json:"total"`
+		HasMore       bool           `json:"has_more"`
+	}
+	require.NoError(t, json.Unmarshal(response.Body.Bytes(), &body), "レスポンスのJSONパースに成功するべき")
 
-			// レスポンスの構造を確認
-			var jsonResponse map[string]interface{}
-			err := json.Unmarshal(response.Body.Bytes(), &jsonResponse)
-			assert.NoError(t, err, "レスポンスのJSONパースに成功するべき")
+	// 未ログインの閲覧者には公開中かつ全員向けのお知らせのみ、ピン留めを先頭に返す
+	require.Len(t, body.Announcements, 2)
+	assert.Equal(t, pinned.ID, body.Announcements[0].ID)
+	assert.Equal(t, published.ID, body.Announcements[1].ID)
+	assert.Equal(t, 2, body.Count)
+	assert.EqualValues(t, 2, body.Total)
+	assert.False(t, body.HasMore)
+	assert.Equal(t, AnnouncementStatusPublished, body.Announcements[0].Status)
 
-			// 期待される属性が存在することを確認
-			_, hasAnnouncements := jsonResponse["announcements"]
-			_, hasCount := jsonResponse["count"]
-			assert.True(t, hasAnnouncements, "announcementsキーが存在するべき")
-			assert.True(t, hasCount, "countキーが存在するべき")
-		})
-	}
+	t.Run("ページング", func(t *testing.T) {
+		first := makeAnnouncementRequest("GET", "/api/announcements?limit=1", nil)
+		var page struct {
+			Announcements []Announcement `json:"announcements"`
+			NextCursor    string         `json:"next_cursor"`
+		}
+		require.NoError(t, json.Unmarshal(first.Body.Bytes(), &page))
+		require.Len(t, page.Announcements, 1)
+		require.NotEmpty(t, page.NextCursor)
+
+		second := makeAnnouncementRequest("GET", "/api/announcements?limit=1&cursor="+page.NextCursor, nil)
+		page.NextCursor = ""
+		require.NoError(t, json.Unmarshal(second.Body.Bytes(), &page))
+		require.Len(t, page.Announcements, 1)
+		assert.Equal(t, published.ID, page.Announcements[0].ID)
+		assert.Empty(t, page.NextCursor)
+	})
 }
 
 // TestGetAnnouncementByIdHandler はお知らせ詳細取得のテストを行う
 func TestGetAnnouncementByIdHandler(t *testing.T) {
+	published, _, draft, teachers := testAnnouncementsFixture(time.Now())
+	useMemoryAnnouncements(t, published, draft, teachers)
+
 	tests := []struct {
 		name               string
 		announcementID     string
 		expectedStatusCode int
 		description        string
 	}{
+		{
+			name:               "公開中のお知らせ",
+			announcementID:     published.ID.Hex(),
+			expectedStatusCode: http.StatusOK,
+			description:        "公開中のお知らせが取得できること",
+		},
 		{
 			name:               "無効なお知らせID形式",
 			announcementID:     "invalid-id",
@@ -103,6 +145,18 @@ func TestGetAnnouncementByIdHandler(t *testing.T) {
 			expectedStatusCode: http.StatusNotFound,
 			description:        "存在しないお知らせIDで404エラーが返されること",
 		},
+		{
+			name:               "下書き",
+			announcementID:     draft.ID.Hex(),
+			expectedStatusCode: http.StatusNotFound,
+			description:        "公開前のお知らせは存在しないものとして扱われること",
+		},
+		{
+			name:               "配信対象外",
+			announcementID:     teachers.ID.Hex(),
+			expectedStatusCode: http.StatusNotFound,
+			description:        "配信対象外のお知らせは存在しないものとして扱われること",
+		},
 	}
 
 	for _, tt := range tests {
@@ -119,3 +173,197 @@ func TestGetAnnouncementByIdHandler(t *testing.T) {
 		})
 	}
 }
+
+// TestMembersOnlyAnnouncement は未ログインの閲覧者に会員限定のお知らせの本文を返さないことをテストする
+func TestMembersOnlyAnnouncement(t *testing.T) {
+	published, _, _, _ := testAnnouncementsFixture(time.Now())
+	members := published
+	members.ID = primitive.NewObjectID()
+	members.Title = "会員限定"
+	members.ContentHTML = "<p>契約者向けの内容</p>"
+	members.MembersOnly = true
+	members.Attachments = []AnnouncementAttachment{{ID: primitive.NewObjectID(), Filename: "menu.pdf"}}
+	useMemoryAnnouncements(t, published, members)
+
+	response := makeAnnouncementRequest("GET", "/api/announcements", nil)
+	require.Equal(t, http.StatusOK, response.Code)
+	var body struct {
+		Announcements []Announcement `json:"announcements"`
+	}
+	require.NoError(t, json.Unmarshal(response.Body.Bytes(), &body))
+	require.Len(t, body.Announcements, 2, "会員限定のお知らせも一覧には表示すること")
+	for _, announcement := range body.Announcements {
+		if announcement.ID == members.ID {
+			assert.True(t, announcement.Locked)
+			assert.Equal(t, "会員限定", announcement.Title)
+			assert.Empty(t, announcement.Content)
+			assert.Empty(t, announcement.ContentHTML)
+			assert.Empty(t, announcement.Attachments)
+		} else {
+			assert.False(t, announcement.Locked)
+			assert.NotEmpty(t, announcement.Content)
+		}
+	}
+
+	response = makeAnnouncementRequest("GET", "/api/announcements/"+members.ID.Hex(), nil)
+	assert.Equal(t, http.StatusUnauthorized, response.Code, "未ログインでは会員限定のお知らせを閲覧できないこと")
+	assert.NotContains(t, response.Body.String(), "契約者向けの内容")
+}
+
+// TestAdminAnnouncementLifecycle は管理者によるお知らせの作成・更新・削除をテストする
+func TestAdminAnnouncementLifecycle(t *testing.T) {
+	repo := useMemoryAnnouncements(t)
+
+	response := makeAnnouncementRequest("POST", "/api/admin/announcements", map[string]interface{}{
+		"title":       "休講のお知らせ",
+		"content":     "**明日**は休講です",
+		"isPublished": false,
+	})
+	require.Equal(t, http.StatusCreated, response.Code)
+
+	var created Announcement
+	require.NoError(t, json.Unmarshal(response.Body.Bytes(), &created))
+	assert.Equal(t, AnnouncementStatusDraft, created.Status)
+	assert.Equal(t, 1, created.Version)
+	assert.Contains(t, created.ContentHTML, "<strong>明日</strong>")
+	id := created.ID.Hex()
+
+	// 下書きは公開一覧に出ず、管理一覧の状態フィルタで取得できる
+	assert.Equal(t, http.StatusNotFound, makeAnnouncementRequest("GET", "/api/announcements/"+id, nil).Code)
+	response = makeAnnouncementRequest("GET", "/api/admin/announcements?status=draft", nil)
+	require.Equal(t, http.StatusOK, response.Code)
+	assert.Contains(t, response.Body.String(), id)
+	assert.Equal(t, http.StatusBadRequest, makeAnnouncementRequest("GET", "/api/admin/announcements?status=unknown", nil).Code)
+
+	// 公開すると版番号が上がり、公開一覧で取得できる
+	response = makeAnnouncementRequest("PUT", "/api/admin/announcements/"+id, map[string]interface{}{"isPublished": true})
+	require.Equal(t, http.StatusOK, response.Code)
+	var updated Announcement
+	require.NoError(t, json.Unmarshal(response.Body.Bytes(), &updated))
+	assert.Equal(t, 2, updated.Version)
+	assert.Equal(t, AnnouncementStatusPublished, updated.Status)
+	assert.Equal(t, "休講のお知らせ", updated.Title, "指定していない項目は変更されないこと")
+	assert.Equal(t, http.StatusOK, makeAnnouncementRequest("GET", "/api/announcements/"+id, nil).Code)
+
+	require.Len(t, repo.revisions, 2, "作成と更新の版が記録されること")
+	assert.Equal(t, RevisionActionCreated, repo.revisions[0].Action)
+	assert.Equal(t, RevisionActionUpdated, repo.revisions[1].Action)
+
+	// 入力エラー
+	response = makeAnnouncementRequest("PUT", "/api/admin/announcements/"+id, map[string]interface{}{"title": " "})
+	assert.Equal(t, http.StatusBadRequest, response.Code)
+
+	// ゴミ箱に移動すると公開・管理のどちらからも取得できない
+	assert.Equal(t, http.StatusOK, makeAnnouncementRequest("DELETE", "/api/admin/announcements/"+id, nil).Code)
+	assert.Equal(t, http.StatusNotFound, makeAnnouncementRequest("GET", "/api/announcements/"+id, nil).Code)
+	assert.Equal(t, http.StatusNotFound, makeAnnouncementRequest("GET", "/api/admin/announcements/"+id, nil).Code)
+	assert.Equal(t, http.StatusNotFound, makeAnnouncementRequest("PUT", "/api/admin/announcements/"+id, map[string]interface{}{"pinned": true}).Code)
+	assert.Equal(t, http.StatusNotFound, makeAnnouncementRequest("DELETE", "/api/admin/announcements/"+id, nil).Code)
+}
+
+// TestAnnouncementServiceUpdateConflict は読み込み後に他の管理者が更新した場合の同時編集をテストする
+func TestAnnouncementServiceUpdateConflict(t *testing.T) {
+	now := time.Now()
+	published, _, _, _ := testAnnouncementsFixture(now)
+	repo := useMemoryAnnouncements(t, published)
+
+	stale, err := repo.FindActive(context.Background(), published.ID)
+	require.NoError(t, err)
+
+	title := "先に保存した変更"
+	_, err = announcementSvc.Update(context.Background(), published.ID, announcementInput{Title: &title}, primitive.NilObjectID, now)
+	require.NoError(t, err)
+
+	stale.Title = "後から保存した変更"
+	err = announcementSvc.saveRevision(context.Background(), stale, RevisionActionUpdated, primitive.NilObjectID, 0, now)
+	assert.ErrorIs(t, err, errAnnouncementConflict)
+
+	current, err := repo.FindActive(context.Background(), published.ID)
+	require.NoError(t, err)
+	assert.Equal(t, title, current.Title)
+	assert.Equal(t, 2, current.Version)
+}
+
+// TestAnnouncementStatusAt は公開状態の判定をテストする
+func TestAnnouncementStatusAt(t *testing.T) {
+	now := time.Date(2026, 4, 1, 9, 0, 0, 0, time.UTC)
+	past := now.Add(-time.Hour)
+	future := now.Add(time.Hour)
+
+	tests := []struct {
+		name         string
+		announcement Announcement
+		expected     string
+	}{
+		{name: "下書き", announcement: Announcement{IsPublished: false, PublishAt: &past}, expected: AnnouncementStatusDraft},
+		{name: "公開予定", announcement: Announcement{IsPublished: true, PublishAt: &future}, expected: AnnouncementStatusScheduled},
+		{name: "公開中", announcement: Announcement{IsPublished: true, PublishAt: &past, ExpiresAt: &future}, expected: AnnouncementStatusPublished},
+		{name: "掲載終了", announcement: Announcement{IsPublished: true, PublishAt: &past, ExpiresAt: &now}, expected: AnnouncementStatusExpired},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, tt.announcement.StatusAt(now))
+		})
+	}
+}
+
+// TestAnnouncementInputApply は作成・更新リクエストの反映と検証をテストする
+func TestAnnouncementInputApply(t *testing.T) {
+	now := time.Date(2026, 4, 1, 9, 0, 0, 0, time.UTC)
+
+	t.Run("公開日時の省略", func(t *testing.T) {
+		var input announcementInput
+		assert.NoError(t, json.Unmarshal([]byte(`{"title":"お知らせ","content":"本文"}`), &input))
+
+		announcement := Announcement{IsPublished: true}
+		assert.Empty(t, input.apply(&announcement, now))
+		assert.Equal(t, now, *announcement.PublishAt, "公開時刻を省略した場合は現在時刻で公開されること")
+	})
+
+	t.Run("掲載終了日時の解除", func(t *testing.T) {
+		expires := now.Add(24 * time.Hour)
+		announcement := Announcement{Title: "お知らせ", Content: "本文", IsPublished: true, PublishAt: &now, ExpiresAt: &expires}
+
+		var input announcementInput
+		assert.NoError(t, json.Unmarshal([]byte(`{"expiresAt":null}`), &input))
+		assert.Empty(t, input.apply(&announcement, now))
+		assert.Nil(t, announcement.ExpiresAt, "null を指定すると掲載終了日時が解除されること")
+		assert.Equal(t, "お知らせ", announcement.Title, "指定していない項目は変更されないこと")
+	})
+
+	t.Run("掲載終了日時が公開日時より前", func(t *testing.T) {
+		var input announcementInput
+		assert.NoError(t, json.Unmarshal([]byte(`{"title":"お知らせ","content":"本文","publishAt":"2026-04-02T00:00:00Z","expiresAt":"2026-04-01T00:00:00Z"}`), &input))
+
+		announcement := Announcement{IsPublished: true}
+		assert.NotEmpty(t, input.apply(&announcement, now))
+	})
+
+	t.Run("タイトルが空", func(t *testing.T) {
+		var input announcementInput
+		assert.NoError(t, json.Unmarshal([]byte(`{"title":"  ","content":"本文"}`), &input))
+		assert.NotEmpty(t, input.apply(&Announcement{}, now))
+	})
+
+	t.Run("本文のHTML変換", func(t *testing.T) {
+		var input announcementInput
+		assert.NoError(t, json.Unmarshal([]byte(`{"title":"お知らせ","content":"**重要** <script>alert(1)</script>"}`), &input))
+
+		announcement := Announcement{IsPublished: true}
+		assert.Empty(t, input.apply(&announcement, now))
+		assert.Equal(t, "**重要** <script>alert(1)</script>", announcement.Content, "Markdown の原文はそのまま保存されること")
+		assert.Equal(t, "<p><strong>重要</strong> &lt;script&gt;alert(1)&lt;/script&gt;</p>\n", announcement.ContentHTML)
+	})
+
+	t.Run("入力サイズの上限", func(t *testing.T) {
+		long := Announcement{Title: strings.Repeat("あ", maxAnnouncementTitleLength+1), Content: "本文"}
+		assert.NotEmpty(t, (&announcementInput{}).apply(&long, now))
+
+		content := strings.Repeat("い", maxAnnouncementContentLength+1)
+		assert.NotEmpty(t, (&announcementInput{Content: &content}).apply(&Announcement{Title: "お知らせ"}, now))
+
+		content = strings.Repeat("い", maxAnnouncementContentLength)
+		assert.Empty(t, (&announcementInput{Content: &content}).apply(&Announcement{Title: "お知らせ"}, now))
+	})
+}
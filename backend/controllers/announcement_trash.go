@@ -0,0 +1,115 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"juice_academy_backend/middleware"
+	"juice_academy_backend/utils"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ListTrashedAnnouncementsHandler はゴミ箱に移動したお知らせを削除日時の新しい順に返すハンドラ（管理者専用）
+// limit / cursor / q / category は通常の一覧と同じ
+func ListTrashedAnnouncementsHandler(c *gin.Context) {
+	params, msg := parseAnnouncementListParams(c)
+	if msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	ctx := c.Request.Context()
+	page, err := listAnnouncementsPage(ctx, bson.M{"deleted_at": bson.M{"$ne": nil}}, "deleted_at", false, params)
+	if err != nil {
+		utils.LogErrorCtx(ctx, "ListTrashedAnnouncements", err, "Failed to list trashed announcements")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "お知らせの取得に失敗しました"})
+		return
+	}
+
+	respondAnnouncementPage(c, page, time.Now())
+}
+
+// RestoreTrashedAnnouncementHandler はゴミ箱のお知らせを元に戻すハンドラ（管理者専用）
+// 公開状態は削除前のまま戻るため、公開中だったお知らせはすぐに一覧に再表示される
+func RestoreTrashedAnnouncementHandler(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "無効なお知らせIDです"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	var announcement Announcement
+	err = announcementCollection.FindOneAndUpdate(ctx,
+		bson.M{"_id": id, "deleted_at": bson.M{"$ne": nil}},
+		bson.M{"$unset": bson.M{"deleted_at": "", "deleted_by": ""}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&announcement)
+	if err == mongo.ErrNoDocuments {
+		c.JSON(http.StatusNotFound, gin.H{"error": "ゴミ箱にお知らせが見つかりません"})
+		return
+	}
+	if err != nil {
+		utils.LogErrorCtx(ctx, "RestoreTrashedAnnouncement", err, "Failed to restore announcement")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "お知らせの復元に失敗しました"})
+		return
+	}
+
+	adminID, _ := middleware.CurrentUserID(c)
+	utils.LogInfoCtx(ctx, "RestoreTrashedAnnouncement", fmt.Sprintf("Announcement %s restored from trash by %s", id.Hex(), adminID.Hex()))
+	now := time.Now()
+	notifyAnnouncementChanged(ctx, &announcement, now)
+	announcement.Status = announcement.StatusAt(now)
+	c.JSON(http.StatusOK, announcement)
+}
+
+// PurgeAnnouncementHandler はゴミ箱のお知らせを完全に削除するハンドラ（管理者専用）
+// 添付ファイル・既読記録・編集履歴もあわせて削除し、元に戻すことはできない
+func PurgeAnnouncementHandler(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "無効なお知らせIDです"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	var deleted Announcement
+	err = announcementCollection.FindOneAndDelete(ctx,
+		bson.M{"_id": id, "deleted_at": bson.M{"$ne": nil}},
+		options.FindOneAndDelete().SetProjection(bson.M{"attachments": 1}),
+	).Decode(&deleted)
+	if err == mongo.ErrNoDocuments {
+		c.JSON(http.StatusNotFound, gin.H{"error": "ゴミ箱にお知らせが見つかりません"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "お知らせの削除に失敗しました"})
+		return
+	}
+
+	deleteAttachmentObjects(c, deleted.Attachments)
+
+	if err := deleteAnnouncementReads(ctx, id); err != nil {
+		utils.LogErrorCtx(ctx, "PurgeAnnouncement", err, "Failed to delete read receipts")
+	}
+	if err := deleteAnnouncementRevisions(ctx, id); err != nil {
+		utils.LogErrorCtx(ctx, "PurgeAnnouncement", err, "Failed to delete revisions")
+	}
+
+	adminID, _ := middleware.CurrentUserID(c)
+	utils.LogInfoCtx(ctx, "PurgeAnnouncement", fmt.Sprintf("Announcement %s permanently deleted by %s", id.Hex(), adminID.Hex()))
+	c.JSON(http.StatusOK, gin.H{"message": "お知らせを完全に削除しました"})
+}
+
+// deleteAnnouncementRevisions はお知らせの編集履歴をすべて削除する
+func deleteAnnouncementRevisions(ctx context.Context, announcementID primitive.ObjectID) error {
+	_, err := announcementRevisionCollection.DeleteMany(ctx, bson.M{"announcement_id": announcementID})
+	return err
+}
@@ -0,0 +1,91 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	"juice_academy_backend/utils"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var auditLogCollection *mongo.Collection
+
+// 監査ログの操作種別
+const (
+	AuditActionPlanChanged         = "subscription.plan_changed"
+	AuditActionPlanChangeScheduled = "subscription.plan_change_scheduled"
+	AuditActionPlanChangeCanceled  = "subscription.plan_change_canceled"
+	AuditActionSubscriptionPaused  = "subscription.paused"
+	AuditActionSubscriptionResumed = "subscription.resumed"
+	AuditActionPlanUpdated         = "plan.updated"
+	AuditActionReceiptIssued       = "receipt.issued"
+	AuditActionReceiptReissued     = "receipt.reissued"
+	AuditActionInvoiceRefunded     = "invoice.refunded"
+	AuditActionCreditNoteIssued    = "invoice.credit_note_issued"
+	// 異議申し立て（チャージバック）への対応
+	AuditActionDisputeEvidenceSubmitted     = "dispute.evidence_submitted"
+	AuditActionDisputeSubscriptionSuspended = "dispute.subscription_suspended"
+	AuditActionDisputeSubscriptionResumed   = "dispute.subscription_resumed"
+	// 一括登録したユーザーへの招待
+	AuditActionInvitationResent = "invitation.resent"
+)
+
+// AuditLog は課金など後から経緯を確認する必要がある操作の記録（audit_logs コレクション）
+type AuditLog struct {
+	ID            primitive.ObjectID     `bson:"_id,omitempty" json:"id"`
+	ActorID       primitive.ObjectID     `bson:"actor_id,omitempty" json:"actor_id,omitempty"`
+	Action        string                 `bson:"action" json:"action"`
+	TargetType    string                 `bson:"target_type" json:"target_type"`
+	TargetID      string                 `bson:"target_id" json:"target_id"`
+	Details       map[string]interface{} `bson:"details,omitempty" json:"details,omitempty"`
+	IPAddress     string                 `bson:"ip_address,omitempty" json:"ip_address,omitempty"`
+	CorrelationID string                 `bson:"correlation_id,omitempty" json:"correlation_id,omitempty"`
+	CreatedAt     time.Time              `bson:"created_at" json:"created_at"`
+}
+
+// InitAuditLogCollection は監査ログコレクションを初期化する
+func InitAuditLogCollection(client *mongo.Client) {
+	auditLogCollection = client.Database("juice_academy").Collection("audit_logs")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, _ = auditLogCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "target_type", Value: 1}, {Key: "target_id", Value: 1}, {Key: "created_at", Value: -1}},
+			Options: options.Index().SetName("target_created_at_idx"),
+		},
+		{
+			Keys:    bson.D{{Key: "actor_id", Value: 1}, {Key: "created_at", Value: -1}},
+			Options: options.Index().SetName("actor_created_at_idx"),
+		},
+	})
+}
+
+// writeAuditLog はリクエストの操作を監査ログに記録する
+// 記録に失敗しても操作自体は完了しているため、エラーはログに残すだけとする
+func writeAuditLog(c *gin.Context, actorID primitive.ObjectID, action, targetType, targetID string, details map[string]interface{}) {
+	ctx := c.Request.Context()
+	entry := AuditLog{
+		ActorID:       actorID,
+		Action:        action,
+		TargetType:    targetType,
+		TargetID:      targetID,
+		Details:       details,
+		IPAddress:     c.ClientIP(),
+		CorrelationID: utils.CorrelationIDFromContext(ctx),
+		CreatedAt:     time.Now(),
+	}
+	if auditLogCollection == nil {
+		utils.LogWarningCtx(ctx, "AuditLog", "Audit log collection is not initialized: "+action)
+		return
+	}
+	if _, err := auditLogCollection.InsertOne(ctx, entry); err != nil {
+		utils.LogErrorCtx(ctx, "AuditLog", err, "Failed to write audit log: "+action)
+	}
+}
@@ -1,6 +1,8 @@
 package controllers
 
 import (
+	"context"
+	"juice_academy_backend/middleware"
 	"juice_academy_backend/services"
 	"net/http"
 	"regexp"
@@ -29,6 +31,32 @@ type User struct {
 	CreatedAt    time.Time          `bson:"created_at" json:"created_at"`
 	UpdatedAt    time.Time          `bson:"updated_at" json:"updated_at"`
 	IsAdmin      bool               `bson:"is_admin" json:"is_admin"`
+	Suspended    bool               `bson:"suspended" json:"suspended"`
+	SuspendedAt  *time.Time         `bson:"suspended_at,omitempty" json:"suspended_at,omitempty"`
+	// OIDCIssuer / OIDCSubject は学校のIdPアカウントとの紐付け（iss と sub の組で一意）
+	OIDCIssuer  string `bson:"oidc_issuer,omitempty" json:"-"`
+	OIDCSubject string `bson:"oidc_subject,omitempty" json:"-"`
+	// Status は一括登録で作成され、パスワード未設定のユーザーの場合 "pending"
+	Status string `bson:"status,omitempty" json:"status,omitempty"`
+	// Campus / EntryYear は登録ポリシーの学籍番号ルールから判定した所属
+	Campus    string `bson:"campus,omitempty" json:"campus,omitempty"`
+	EntryYear int    `bson:"entry_year,omitempty" json:"entry_year,omitempty"`
+	// AnnouncementEmailOptIn はお知らせのメール通知（ダイジェスト）を希望しているか
+	AnnouncementEmailOptIn bool `bson:"announcement_email_opt_in" json:"announcement_email_opt_in"`
+}
+
+// selfRegistrableRoles は利用者自身が登録時に選択できるロール
+// admin は認可判断に使用されるため、管理者APIからのみ付与できる
+var selfRegistrableRoles = map[string]bool{
+	"student": true,
+	"teacher": true,
+}
+
+// assignableRoles は管理者APIから設定できるロール
+var assignableRoles = map[string]bool{
+	"student": true,
+	"teacher": true,
+	"admin":   true,
 }
 
 // InitUserCollection はユーザーコレクションを初期化
@@ -43,6 +71,23 @@ func validateNameKana(nameKana string) bool {
 	return katakanaPattern.MatchString(nameKana)
 }
 
+// userExists はメールアドレスまたは学籍番号が登録済みかどうかを確認する
+func userExists(ctx context.Context, email, studentID string) (bool, error) {
+	err := userCollection.FindOne(ctx, bson.M{
+		"$or": []bson.M{
+			{"email": email},
+			{"student_id": studentID},
+		},
+	}).Err()
+	if err == mongo.ErrNoDocuments {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
 // validatePassword はパスワードのバリデーションを行う
 func validatePassword(password string) bool {
 	// 8文字以上
@@ -82,6 +127,24 @@ func RegisterHandler(c *gin.Context) {
 		return
 	}
 
+	if !selfRegistrableRoles[req.Role] {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "無効なロールです"})
+		return
+	}
+
+	// 登録ポリシー（受付期間・招待制・学籍番号形式・メールドメイン）の確認
+	ctx := c.Request.Context()
+	policy, err := currentRegistrationPolicy(ctx)
+	if err != nil {
+		respondPolicyError(c, err)
+		return
+	}
+	studentIDRule, err := policy.checkSelfRegistration(time.Now(), req.StudentID, req.Email)
+	if err != nil {
+		respondPolicyError(c, err)
+		return
+	}
+
 	// 氏名（カナ）のバリデーション
 	if !validateNameKana(req.NameKana) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "氏名（カナ）はカタカナのみで入力してください"})
@@ -95,14 +158,12 @@ func RegisterHandler(c *gin.Context) {
 	}
 
 	// メールアドレスとstudent_idの重複チェック
-	ctx := c.Request.Context()
-	existingUser := userCollection.FindOne(ctx, bson.M{
-		"$or": []bson.M{
-			{"email": req.Email},
-			{"student_id": req.StudentID},
-		},
-	})
-	if existingUser.Err() == nil {
+	exists, err := userExists(ctx, req.Email, req.StudentID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "ユーザー登録に失敗しました"})
+		return
+	}
+	if exists {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "既に登録済みのメールアドレスまたは学籍番号です"})
 		return
 	}
@@ -125,6 +186,10 @@ func RegisterHandler(c *gin.Context) {
 		UpdatedAt:    now,
 		IsAdmin:      false,
 	}
+	if studentIDRule != nil {
+		user.Campus = studentIDRule.Campus
+		user.EntryYear = studentIDRule.EntryYear
+	}
 
 	result, err := userCollection.InsertOne(ctx, user)
 	if err != nil {
@@ -165,6 +230,11 @@ func LoginHandler(c *gin.Context) {
 		return
 	}
 
+	if user.Suspended {
+		c.JSON(http.StatusForbidden, gin.H{"error": "アカウントが停止されています"})
+		return
+	}
+
 	// パスワード認証成功 - 2FA画面への遷移を指示
 	c.JSON(http.StatusOK, gin.H{
 		"message":     "パスワード認証が完了しました。2段階認証を開始してください。",
@@ -175,20 +245,13 @@ func LoginHandler(c *gin.Context) {
 
 // LogoutHandler はログアウト処理とJWTの無効化を行うハンドラ
 func LogoutHandler(c *gin.Context) {
-	if jtiValue, exists := c.Get("jti"); exists {
-		if jtiStr, ok := jtiValue.(string); ok && jtiStr != "" {
-			expiration := 72 * time.Hour // デフォルト値
-			if expClaim, expExists := c.Get("exp"); expExists {
-				if expUnix, ok := expClaim.(float64); ok {
-					expTime := time.Unix(int64(expUnix), 0)
-					if expTime.After(time.Now()) {
-						expiration = time.Until(expTime)
-					}
-				}
-			}
-			if err := services.BlacklistToken(jtiStr, expiration); err != nil {
-				// 失敗してもユーザー体験を優先して継続
-			}
+	if jti, expiresAt, ok := middleware.CurrentTokenID(c); ok {
+		expiration := 72 * time.Hour // デフォルト値
+		if expiresAt.After(time.Now()) {
+			expiration = time.Until(expiresAt)
+		}
+		if err := services.BlacklistToken(jti, expiration); err != nil {
+			// 失敗してもユーザー体験を優先して継続
 		}
 	}
 
@@ -238,6 +301,13 @@ func RefreshTokenHandler(c *gin.Context) {
 		return
 	}
 
+	if user.Suspended {
+		_ = revokeRefreshToken(ctx, refreshToken)
+		clearRefreshCookie(c)
+		c.JSON(http.StatusForbidden, gin.H{"error": "アカウントが停止されています"})
+		return
+	}
+
 	accessToken, err := generateAccessToken(user)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "アクセストークンの生成に失敗しました"})
@@ -2,10 +2,16 @@ package controllers
 
 import (
 	"context"
+	"errors"
+	"net/http"
+	"net/url"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
+	"juice_academy_backend/services"
+
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
 	"go.mongodb.org/mongo-driver/bson"
@@ -52,6 +58,7 @@ func (suite *AuthIntegrationSuite) SetupSuite() {
 	// テスト用のコレクションを初期化
 	// userCollectionをテストデータベースに設定
 	userCollection = suite.database.Collection("users")
+	invitationCollection = suite.database.Collection("invitations")
 
 	// クリーンアップ関数を設定
 	suite.cleanup = func() {
@@ -75,6 +82,7 @@ func (suite *AuthIntegrationSuite) SetupTest() {
 	}
 	// テスト用データをクリア
 	suite.database.Collection("users").Drop(context.Background())
+	suite.database.Collection("invitations").Drop(context.Background())
 }
 
 // TestAuthIntegrationSuite はテストスイートを実行
@@ -184,3 +192,173 @@ func (suite *AuthIntegrationSuite) TestAdminUserIntegration() {
 	assert.NoError(suite.T(), err)
 	assert.Equal(suite.T(), int64(1), count, "管理者ユーザーは1人だけ存在するべき")
 }
+
+// TestRegisterAndLoginHandlers は本番の登録・ログインハンドラでユーザーの照合が必要なケースを確認する
+func (suite *AuthIntegrationSuite) TestRegisterAndLoginHandlers() {
+	tests := []struct {
+		name               string
+		method             string
+		url                string
+		requestBody        map[string]interface{}
+		expectedStatusCode int
+		description        string
+	}{
+		{
+			name:   "正常な登録",
+			method: "POST",
+			url:    "/api/register",
+			requestBody: map[string]interface{}{
+				"role":       "student",
+				"student_id": "test001",
+				"name_kana":  "テストユーザー",
+				"email":      "test@example.com",
+				"password":   "Password123",
+			},
+			expectedStatusCode: http.StatusCreated,
+			description:        "有効なデータでユーザー登録が成功すること",
+		},
+		{
+			name:   "登録済みのメールアドレス",
+			method: "POST",
+			url:    "/api/register",
+			requestBody: map[string]interface{}{
+				"role":       "student",
+				"student_id": "test002",
+				"name_kana":  "テストユーザー",
+				"email":      "test@example.com",
+				"password":   "Password123",
+			},
+			expectedStatusCode: http.StatusBadRequest,
+			description:        "同じメールアドレスで二重に登録できないこと",
+		},
+		{
+			name:   "存在しないユーザー",
+			method: "POST",
+			url:    "/api/login",
+			requestBody: map[string]interface{}{
+				"email":    "nonexistent@example.com",
+				"password": "Password123",
+			},
+			expectedStatusCode: http.StatusUnauthorized,
+			description:        "存在しないユーザーでログインが失敗すること",
+		},
+		{
+			name:   "誤ったパスワード",
+			method: "POST",
+			url:    "/api/login",
+			requestBody: map[string]interface{}{
+				"email":    "test@example.com",
+				"password": "WrongPassword123",
+			},
+			expectedStatusCode: http.StatusUnauthorized,
+			description:        "パスワードが一致しない場合にログインが失敗すること",
+		},
+	}
+
+	// テーブルの順に実行する（登録したユーザーを後のケースで使う）
+	for _, tt := range tests {
+		w := makeRequest(tt.method, tt.url, tt.requestBody)
+		assert.Equal(suite.T(), tt.expectedStatusCode, w.Code, tt.name+": "+tt.description)
+	}
+
+	var registered User
+	err := suite.database.Collection("users").FindOne(context.Background(), bson.M{"email": "test@example.com"}).Decode(&registered)
+	assert.NoError(suite.T(), err, "登録したユーザーが保存されているべき")
+	assert.NotEqual(suite.T(), "Password123", registered.PasswordHash, "パスワードはハッシュ化して保存するべき")
+}
+
+// TestLinkOIDCUserIntegration は招待中のユーザーが学校IdPのログインで招待を経ずに利用開始できないことを確認する
+func (suite *AuthIntegrationSuite) TestLinkOIDCUserIntegration() {
+	ctx := context.Background()
+	collection := suite.database.Collection("users")
+	_, err := collection.InsertMany(ctx, []interface{}{
+		User{Role: "student", StudentID: "oidc_001", NameKana: "ショウタイ", Email: "pending@example.ac.jp", Status: "pending", CreatedAt: time.Now(), UpdatedAt: time.Now()},
+		User{Role: "student", StudentID: "oidc_002", NameKana: "トウロクズミ", Email: "active@example.ac.jp", PasswordHash: "hash", CreatedAt: time.Now(), UpdatedAt: time.Now()},
+	})
+	assert.NoError(suite.T(), err)
+
+	identity := &services.OIDCIdentity{Issuer: "https://idp.example.ac.jp", Subject: "sub-pending", StudentID: "oidc_001", Email: "pending@example.ac.jp", EmailVerified: true}
+	_, err = linkOIDCUser(ctx, identity)
+	assert.Equal(suite.T(), errOIDCAccountPending, err)
+	var pending User
+	assert.NoError(suite.T(), collection.FindOne(ctx, bson.M{"student_id": "oidc_001"}).Decode(&pending))
+	assert.Empty(suite.T(), pending.OIDCSubject, "招待中のユーザーには紐付けないこと")
+
+	identity = &services.OIDCIdentity{Issuer: "https://idp.example.ac.jp", Subject: "sub-active", StudentID: "oidc_002"}
+	user, err := linkOIDCUser(ctx, identity)
+	assert.NoError(suite.T(), err)
+	if assert.NotNil(suite.T(), user) {
+		assert.Equal(suite.T(), "sub-active", user.OIDCSubject)
+	}
+}
+
+// TestRosterInvitationEmails は一括登録の招待メールを取り込み後にワーカーが送信し、失敗した招待を再送できることを確認する
+func (suite *AuthIntegrationSuite) TestRosterInvitationEmails() {
+	suite.T().Setenv("INVITATION_ACCEPT_URL", "https://app.example.com/invitations/accept")
+	ctx := context.Background()
+
+	setupURLs := map[string]string{}
+	failFor := "fail@example.com"
+	original := sendInvitationEmail
+	sendInvitationEmail = func(to, userName, studentID, setupURL string, expiryDays int) error {
+		if to == failFor {
+			return errors.New("smtp unavailable")
+		}
+		setupURLs[to] = setupURL
+		return nil
+	}
+	defer func() { sendInvitationEmail = original }()
+
+	csv := "student_id,name_kana,email\nroster_001,ショウタイ,ok@example.com\nroster_002,サイソウ,fail@example.com\n"
+	report, err := ImportRoster(ctx, strings.NewReader(csv), RosterImportOptions{})
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), 2, report.Created)
+	for _, row := range report.Rows {
+		assert.Equal(suite.T(), RosterRowCreated, row.Status)
+		assert.Equal(suite.T(), InvitationEmailQueued, row.EmailStatus, "取り込み中にはメールを送信せず送信待ちにすること")
+	}
+	assert.Empty(suite.T(), setupURLs)
+
+	config := DefaultInvitationEmailConfig
+	config.MaxAttempts = 1
+	assert.NoError(suite.T(), runInvitationEmails(ctx, config))
+
+	var failedUser User
+	assert.NoError(suite.T(), userCollection.FindOne(ctx, bson.M{"email": failFor}).Decode(&failedUser))
+	var failed Invitation
+	assert.NoError(suite.T(), invitationCollection.FindOne(ctx, bson.M{"user_id": failedUser.ID}).Decode(&failed))
+	assert.Equal(suite.T(), InvitationEmailFailed, failed.EmailStatus)
+	assert.Equal(suite.T(), "smtp unavailable", failed.EmailError)
+
+	// 送信できた招待のリンクでパスワードを設定できる
+	setupURL, err := url.Parse(setupURLs["ok@example.com"])
+	if assert.NoError(suite.T(), err) {
+		w := makeRequest("POST", "/api/invitations/accept", map[string]interface{}{
+			"token":    setupURL.Query().Get("token"),
+			"password": "Password123",
+		})
+		assert.Equal(suite.T(), http.StatusOK, w.Code)
+	}
+
+	// 有効化済みのユーザーには再送しない
+	var activated User
+	assert.NoError(suite.T(), userCollection.FindOne(ctx, bson.M{"email": "ok@example.com"}).Decode(&activated))
+	w := makeRequest("POST", "/api/admin/users/"+activated.ID.Hex()+"/invitation", nil)
+	assert.Equal(suite.T(), http.StatusConflict, w.Code)
+
+	// 送信に失敗した招待は再送でき、以前の招待は無効になる
+	failFor = ""
+	w = makeRequest("POST", "/api/admin/users/"+failedUser.ID.Hex()+"/invitation", nil)
+	assert.Equal(suite.T(), http.StatusAccepted, w.Code)
+	assert.NoError(suite.T(), runInvitationEmails(ctx, config))
+	assert.Contains(suite.T(), setupURLs, "fail@example.com")
+
+	var resent Invitation
+	assert.NoError(suite.T(), invitationCollection.FindOne(ctx,
+		bson.M{"user_id": failedUser.ID, "expires_at": bson.M{"$gt": time.Now()}},
+	).Decode(&resent))
+	assert.Equal(suite.T(), InvitationEmailSent, resent.EmailStatus)
+	count, err := invitationCollection.CountDocuments(ctx, bson.M{"user_id": failedUser.ID, "expires_at": bson.M{"$gt": time.Now()}})
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), int64(1), count, "以前の招待は無効にすること")
+}
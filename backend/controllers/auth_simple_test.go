@@ -13,15 +13,18 @@ import (
 )
 
 // setupTestRouter はテスト用のGinルーターを作成する
+// データベースに接続しないため、リクエストの検証で失敗するケースのみを扱う
+// 登録・ログインの成功やユーザーの照合は auth_integration_test.go で検証する
 func setupTestRouter() *gin.Engine {
 	gin.SetMode(gin.TestMode)
 	router := gin.New()
 
-	// テスト用のルートを設定
 	api := router.Group("/api")
 	{
-		api.POST("/register", TestBasicRegisterHandler)
-		api.POST("/login", TestBasicLoginHandler)
+		api.POST("/register", RegisterHandler)
+		api.POST("/login", LoginHandler)
+		api.POST("/invitations/accept", AcceptInvitationHandler)
+		api.POST("/admin/users/:id/invitation", ResendInvitationHandler)
 	}
 
 	return router
@@ -52,18 +55,6 @@ func TestRegisterHandler(t *testing.T) {
 		expectedStatusCode int
 		description        string
 	}{
-		{
-			name: "正常な登録",
-			requestBody: map[string]interface{}{
-				"role":       "student",
-				"student_id": "test001",
-				"name_kana":  "テストユーザー",
-				"email":      "test@example.com",
-				"password":   "password123",
-			},
-			expectedStatusCode: http.StatusCreated,
-			description:        "有効なデータでユーザー登録が成功すること",
-		},
 		{
 			name: "無効なメールアドレス",
 			requestBody: map[string]interface{}{
@@ -120,15 +111,6 @@ func TestLoginHandler(t *testing.T) {
 			expectedStatusCode: http.StatusBadRequest,
 			description:        "無効なメールアドレス形式でログインが失敗すること",
 		},
-		{
-			name: "存在しないユーザー",
-			requestBody: map[string]interface{}{
-				"email":    "nonexistent@example.com",
-				"password": "password123",
-			},
-			expectedStatusCode: http.StatusUnauthorized,
-			description:        "存在しないユーザーでログインが失敗すること",
-		},
 		{
 			name: "空のメールアドレス",
 			requestBody: map[string]interface{}{
@@ -128,6 +128,20 @@ func revokeRefreshToken(ctx context.Context, refreshToken string) error {
 	return err
 }
 
+// revokeAllRefreshTokens はユーザーの有効なリフレッシュトークンをすべて無効化する
+func revokeAllRefreshTokens(ctx context.Context, userID primitive.ObjectID) error {
+	if refreshTokenCollection == nil {
+		return errors.New("refresh token collection is not initialized")
+	}
+
+	_, err := refreshTokenCollection.UpdateMany(
+		ctx,
+		bson.M{"user_id": userID, "revoked": false},
+		bson.M{"$set": bson.M{"revoked": true, "updated_at": time.Now()}},
+	)
+	return err
+}
+
 func findActiveRefreshToken(ctx context.Context, refreshToken string) (*RefreshTokenDoc, error) {
 	if refreshTokenCollection == nil {
 		return nil, errors.New("refresh token collection is not initialized")
@@ -0,0 +1,547 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"juice_academy_backend/middleware"
+	"juice_academy_backend/services"
+	"juice_academy_backend/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stripe/stripe-go/v81"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// maxDisputeEvidenceFilesSize は証拠ファイルの合計サイズの上限（Stripe の制限に合わせる）
+	maxDisputeEvidenceFilesSize = 4500 << 10
+	// maxDisputeEvidenceTextLength は証拠のテキスト1項目の最大文字数（Stripe の制限に合わせる）
+	maxDisputeEvidenceTextLength = 20000
+	// maxDisputeListSize は一覧で返す異議申し立ての最大件数
+	maxDisputeListSize = 200
+)
+
+var disputeCollection *mongo.Collection
+
+// InitDisputeCollection は異議申し立て（チャージバック）コレクションを初期化する
+func InitDisputeCollection(client *mongo.Client) {
+	disputeCollection = client.Database("juice_academy").Collection("disputes")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_ = createDisputeIndexes(ctx, disputeCollection)
+}
+
+// createDisputeIndexes は異議申し立てコレクションのインデックスを作成する
+// stripe_dispute_id の一意制約は古いイベントによる上書きの防止にも使う（services.ApplyStripeDispute）
+func createDisputeIndexes(ctx context.Context, collection *mongo.Collection) error {
+	_, err := collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "stripe_dispute_id", Value: 1}},
+			Options: options.Index().SetUnique(true).SetName("stripe_dispute_id_unique"),
+		},
+		{
+			// 管理画面の一覧（状態ごとに提出期限の近い順）
+			Keys:    bson.D{{Key: "status", Value: 1}, {Key: "evidence_due_by", Value: 1}},
+			Options: options.Index().SetName("status_evidence_due_by"),
+		},
+	})
+	return err
+}
+
+// processDispute はcharge.dispute.*の異議申し立てを disputes コレクションに反映し、
+// 新しい申し立てと結果の確定を管理者に通知する
+func processDispute(ctx context.Context, event stripe.Event) {
+	var dispute stripe.Dispute
+	if err := json.Unmarshal(event.Data.Raw, &dispute); err != nil {
+		utils.LogErrorCtx(ctx, "StripeWebhook", err, "Failed to parse dispute data")
+		return
+	}
+
+	utils.LogInfoCtx(ctx, "StripeWebhook",
+		fmt.Sprintf("Dispute %s: %s, Amount: %d, Reason: %s, Status: %s",
+			event.Type, utils.MaskStripeID(dispute.ID), dispute.Amount, dispute.Reason, dispute.Status))
+
+	update, err := services.ApplyStripeDispute(ctx, disputeCollection, &dispute, time.Unix(event.Created, 0))
+	if err != nil {
+		utils.LogErrorCtx(ctx, "StripeWebhook", err, "Failed to save dispute: "+utils.MaskStripeID(dispute.ID))
+		return
+	}
+	if update != nil {
+		services.NotifyDispute(ctx, disputeCollection.Database(), *update)
+	}
+}
+
+// disputeEvidenceField は管理画面から登録できる証拠の項目
+// File が true の項目はファイルを Stripe にアップロードし、そのファイルIDを登録する
+type disputeEvidenceField struct {
+	Key   string `json:"key"`
+	Label string `json:"label"`
+	File  bool   `json:"file"`
+	set   func(evidence *stripe.DisputeEvidenceParams, value *string)
+}
+
+// disputeEvidenceFields は登録できる証拠（表示順。キーは Stripe の evidence のフィールド名）
+// 物販向けの配送に関する項目など、オンラインの授業に関係しないものは扱わない
+var disputeEvidenceFields = []disputeEvidenceField{
+	{Key: "product_description", Label: "サービスの内容", set: func(e *stripe.DisputeEvidenceParams, v *string) { e.ProductDescription = v }},
+	{Key: "customer_name", Label: "利用者の氏名", set: func(e *stripe.DisputeEvidenceParams, v *string) { e.CustomerName = v }},
+	{Key: "customer_email_address", Label: "利用者のメールアドレス", set: func(e *stripe.DisputeEvidenceParams, v *string) { e.CustomerEmailAddress = v }},
+	{Key: "service_date", Label: "サービスの提供日", set: func(e *stripe.DisputeEvidenceParams, v *string) { e.ServiceDate = v }},
+	{Key: "access_activity_log", Label: "利用履歴（ログイン・受講の記録）", set: func(e *stripe.DisputeEvidenceParams, v *string) { e.AccessActivityLog = v }},
+	{Key: "cancellation_policy_disclosure", Label: "解約規定の提示方法", set: func(e *stripe.DisputeEvidenceParams, v *string) { e.CancellationPolicyDisclosure = v }},
+	{Key: "cancellation_rebuttal", Label: "解約に応じなかった理由", set: func(e *stripe.DisputeEvidenceParams, v *string) { e.CancellationRebuttal = v }},
+	{Key: "refund_policy_disclosure", Label: "返金規定の提示方法", set: func(e *stripe.DisputeEvidenceParams, v *string) { e.RefundPolicyDisclosure = v }},
+	{Key: "refund_refusal_explanation", Label: "返金に応じなかった理由", set: func(e *stripe.DisputeEvidenceParams, v *string) { e.RefundRefusalExplanation = v }},
+	{Key: "uncategorized_text", Label: "その他の説明", set: func(e *stripe.DisputeEvidenceParams, v *string) { e.UncategorizedText = v }},
+	{Key: "service_documentation", Label: "サービス提供の記録", File: true, set: func(e *stripe.DisputeEvidenceParams, v *string) { e.ServiceDocumentation = v }},
+	{Key: "customer_communication", Label: "利用者とのやり取り", File: true, set: func(e *stripe.DisputeEvidenceParams, v *string) { e.CustomerCommunication = v }},
+	{Key: "cancellation_policy", Label: "解約規定", File: true, set: func(e *stripe.DisputeEvidenceParams, v *string) { e.CancellationPolicy = v }},
+	{Key: "refund_policy", Label: "返金規定", File: true, set: func(e *stripe.DisputeEvidenceParams, v *string) { e.RefundPolicy = v }},
+	{Key: "receipt", Label: "領収書", File: true, set: func(e *stripe.DisputeEvidenceParams, v *string) { e.Receipt = v }},
+	{Key: "uncategorized_file", Label: "その他の資料", File: true, set: func(e *stripe.DisputeEvidenceParams, v *string) { e.UncategorizedFile = v }},
+}
+
+// disputeEvidenceFileTypes は Stripe が証拠として受け付けるファイル形式
+var disputeEvidenceFileTypes = map[string]bool{
+	"application/pdf": true,
+	"image/png":       true,
+	"image/jpeg":      true,
+}
+
+// disputeEvidenceFile は証拠としてアップロードするファイル
+type disputeEvidenceFile struct {
+	field    disputeEvidenceField
+	filename string
+	data     []byte
+}
+
+// disputeEvidenceInput は管理画面から送信された証拠
+type disputeEvidenceInput struct {
+	texts map[string]string
+	files []disputeEvidenceFile
+}
+
+// parseDisputeEvidence はフォームの証拠を検証する
+// テキストは前後の空白を取り除き、空の項目は送らない（Stripe に登録済みの内容はそのまま残る）
+func parseDisputeEvidence(form *multipart.Form) (*disputeEvidenceInput, error) {
+	input := &disputeEvidenceInput{texts: make(map[string]string)}
+	var totalSize int64
+	for _, field := range disputeEvidenceFields {
+		if !field.File {
+			var value string
+			if values := form.Value[field.Key]; len(values) > 0 {
+				value = strings.TrimSpace(values[0])
+			}
+			if value == "" {
+				continue
+			}
+			if len([]rune(value)) > maxDisputeEvidenceTextLength {
+				return nil, fmt.Errorf("%sは%d文字以内で入力してください", field.Label, maxDisputeEvidenceTextLength)
+			}
+			input.texts[field.Key] = value
+			continue
+		}
+
+		headers := form.File[field.Key]
+		if len(headers) == 0 {
+			continue
+		}
+		if len(headers) > 1 {
+			return nil, fmt.Errorf("%sに指定できるファイルは1つです", field.Label)
+		}
+		totalSize += headers[0].Size
+		if totalSize > maxDisputeEvidenceFilesSize {
+			return nil, fmt.Errorf("ファイルの合計サイズは%dKBまでです", maxDisputeEvidenceFilesSize>>10)
+		}
+		file, err := headers[0].Open()
+		if err != nil {
+			return nil, fmt.Errorf("%sのファイルを読み込めません", field.Label)
+		}
+		data, err := io.ReadAll(io.LimitReader(file, maxDisputeEvidenceFilesSize+1))
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%sのファイルを読み込めません", field.Label)
+		}
+		if len(data) == 0 {
+			return nil, fmt.Errorf("%sのファイルが空です", field.Label)
+		}
+		contentType := sniffAttachmentType(data)
+		if !disputeEvidenceFileTypes[contentType] {
+			return nil, fmt.Errorf("%sに指定できるのはPDF・PNG・JPEGのみです", field.Label)
+		}
+		input.files = append(input.files, disputeEvidenceFile{
+			field:    field,
+			filename: sanitizeAttachmentFilename(headers[0].Filename, contentType),
+			data:     data,
+		})
+	}
+	if len(input.texts) == 0 && len(input.files) == 0 {
+		return nil, errors.New("証拠を1つ以上入力してください")
+	}
+	return input, nil
+}
+
+// loadAdminDispute は管理画面の操作対象の異議申し立てを取得する
+func loadAdminDispute(c *gin.Context) (*services.DisputeRecord, bool) {
+	ctx := c.Request.Context()
+	var record services.DisputeRecord
+	err := disputeCollection.FindOne(ctx, bson.M{"stripe_dispute_id": c.Param("id")}).Decode(&record)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "異議申し立てが見つかりません"})
+		return nil, false
+	}
+	if err != nil {
+		utils.LogErrorCtx(ctx, "AdminDispute", err, "Failed to fetch dispute")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "異議申し立ての取得に失敗しました"})
+		return nil, false
+	}
+	return &record, true
+}
+
+// disputeUsers は異議申し立てに紐付く利用者を取得する
+func disputeUsers(ctx context.Context, records []services.DisputeRecord) (map[primitive.ObjectID]User, error) {
+	var ids []primitive.ObjectID
+	for _, record := range records {
+		if record.UserID != nil {
+			ids = append(ids, *record.UserID)
+		}
+	}
+	users := make(map[primitive.ObjectID]User, len(ids))
+	if len(ids) == 0 {
+		return users, nil
+	}
+	cursor, err := userCollection.Find(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		return nil, err
+	}
+	var found []User
+	if err := cursor.All(ctx, &found); err != nil {
+		return nil, err
+	}
+	for _, user := range found {
+		users[user.ID] = user
+	}
+	return users, nil
+}
+
+// disputeEntry は管理画面に返す異議申し立て1件
+func disputeEntry(record services.DisputeRecord, users map[primitive.ObjectID]User) gin.H {
+	var user gin.H
+	if record.UserID != nil {
+		if u, ok := users[*record.UserID]; ok {
+			user = gin.H{"id": u.ID.Hex(), "name_kana": u.NameKana, "email": u.Email, "student_id": u.StudentID}
+		}
+	}
+	return gin.H{
+		"dispute":           record,
+		"status_label":      services.DisputeStatusLabel(record.Status),
+		"awaiting_response": services.DisputeAwaitingResponse(record.Status),
+		"user":              user,
+	}
+}
+
+// AdminListDisputesHandler は異議申し立てを証拠の提出期限の近い順に返すハンドラ
+// status=open（既定）は結果が確定していないもの、closed は確定したもの、all はすべてを返す
+func AdminListDisputesHandler(c *gin.Context) {
+	closedStatuses := []string{
+		string(stripe.DisputeStatusWon), string(stripe.DisputeStatusLost), string(stripe.DisputeStatusWarningClosed),
+	}
+	filter := bson.M{}
+	switch c.DefaultQuery("status", "open") {
+	case "open":
+		filter["status"] = bson.M{"$nin": closedStatuses}
+	case "closed":
+		filter["status"] = bson.M{"$in": closedStatuses}
+	case "all":
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status は open・closed・all のいずれかを指定してください"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	// 提出期限のないもの（カード会社が回答を受け付けない照会など）は最後に並べる
+	opts := options.Find().SetSort(bson.D{{Key: "evidence_due_by", Value: 1}, {Key: "created_at", Value: -1}}).SetLimit(maxDisputeListSize)
+	cursor, err := disputeCollection.Find(ctx, filter, opts)
+	records := []services.DisputeRecord{}
+	if err == nil {
+		err = cursor.All(ctx, &records)
+	}
+	if err != nil {
+		utils.LogErrorCtx(ctx, "AdminDispute", err, "Failed to fetch disputes")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "異議申し立ての取得に失敗しました"})
+		return
+	}
+	users, err := disputeUsers(ctx, records)
+	if err != nil {
+		utils.LogErrorCtx(ctx, "AdminDispute", err, "Failed to fetch dispute users")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "異議申し立ての取得に失敗しました"})
+		return
+	}
+
+	withDeadline := make([]gin.H, 0, len(records))
+	var withoutDeadline []gin.H
+	for _, record := range records {
+		if record.EvidenceDueBy == nil {
+			withoutDeadline = append(withoutDeadline, disputeEntry(record, users))
+			continue
+		}
+		withDeadline = append(withDeadline, disputeEntry(record, users))
+	}
+	c.JSON(http.StatusOK, gin.H{"disputes": append(withDeadline, withoutDeadline...)})
+}
+
+// AdminGetDisputeHandler は異議申し立て1件と登録できる証拠の項目を返すハンドラ
+func AdminGetDisputeHandler(c *gin.Context) {
+	record, ok := loadAdminDispute(c)
+	if !ok {
+		return
+	}
+	users, err := disputeUsers(c.Request.Context(), []services.DisputeRecord{*record})
+	if err != nil {
+		utils.LogErrorCtx(c.Request.Context(), "AdminDispute", err, "Failed to fetch dispute user")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "異議申し立ての取得に失敗しました"})
+		return
+	}
+	entry := disputeEntry(*record, users)
+	entry["evidence_fields"] = disputeEvidenceFields
+	c.JSON(http.StatusOK, entry)
+}
+
+// AdminSubmitDisputeEvidenceHandler は異議申し立てに証拠を登録するハンドラ
+// multipart/form-data で証拠の項目（disputeEvidenceFields のキー）を送信する
+//   - submit=true: カード会社に提出する（false の場合は Stripe に保存するだけで、後から追加・提出できる）
+//   - suspend_subscription=true: 証拠の登録の前に利用者のサブスクリプションを停止する
+//
+// 停止は繰り返しても同じ結果になるため、証拠の登録に失敗した場合もそのまま再送できる
+func AdminSubmitDisputeEvidenceHandler(c *gin.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxDisputeEvidenceFilesSize+1<<20)
+	form, err := c.MultipartForm()
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("ファイルの合計サイズは%dKBまでです", maxDisputeEvidenceFilesSize>>10)})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "不正な入力データです"})
+		return
+	}
+	input, err := parseDisputeEvidence(form)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	submit := c.PostForm("submit") == "true"
+	suspend := c.PostForm("suspend_subscription") == "true"
+
+	record, ok := loadAdminDispute(c)
+	if !ok {
+		return
+	}
+	if !services.DisputeAwaitingResponse(record.Status) {
+		c.JSON(http.StatusConflict, gin.H{"error": "この異議申し立てには証拠を登録できません（" + services.DisputeStatusLabel(record.Status) + "）"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	adminID, _ := middleware.CurrentUserID(c)
+	if suspend {
+		if status, err := suspendSubscriptionForDispute(c, record, adminID); err != nil {
+			if status == http.StatusInternalServerError {
+				utils.LogErrorCtx(ctx, "AdminDispute", err, "Failed to suspend subscription")
+				errMsg := "サブスクリプションの停止に失敗しました"
+				if os.Getenv("APP_ENV") != "production" {
+					errMsg += ": " + err.Error()
+				}
+				c.JSON(status, gin.H{"error": errMsg})
+				return
+			}
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	evidence := &stripe.DisputeEvidenceParams{}
+	submission := services.DisputeSubmission{Submitted: submit, CreatedBy: adminID, CreatedAt: time.Now()}
+	for _, field := range disputeEvidenceFields {
+		if value, ok := input.texts[field.Key]; ok {
+			field.set(evidence, stripe.String(value))
+			submission.Fields = append(submission.Fields, field.Key)
+		}
+	}
+	for _, file := range input.files {
+		params := &stripe.FileParams{
+			FileReader: bytes.NewReader(file.data),
+			Filename:   stripe.String(file.filename),
+			Purpose:    stripe.String(string(stripe.FilePurposeDisputeEvidence)),
+		}
+		uploaded, err := billing.UploadFile(ctx, params)
+		if err != nil {
+			adjustmentErrorResponse(c, err, "証拠ファイルのアップロードに失敗しました")
+			return
+		}
+		file.field.set(evidence, stripe.String(uploaded.ID))
+		submission.Fields = append(submission.Fields, file.field.Key)
+		submission.Files = append(submission.Files, services.DisputeEvidenceFile{
+			Field:        file.field.Key,
+			StripeFileID: uploaded.ID,
+			Filename:     file.filename,
+			Size:         int64(len(file.data)),
+		})
+	}
+
+	// Stripe は submit を省略すると提出するため、保存のみの場合も明示する
+	params := &stripe.DisputeParams{Evidence: evidence, Submit: stripe.Bool(submit)}
+	params.AddMetadata("evidence_updated_by", adminID.Hex())
+	updated, err := billing.UpdateDispute(ctx, record.StripeDisputeID, params)
+	if err != nil {
+		adjustmentErrorResponse(c, err, "証拠の登録に失敗しました")
+		return
+	}
+
+	// 反映済みの時刻のまま保存し、この後に届く Webhook（時刻のずれを含む）による更新を妨げない
+	if _, err := services.ApplyStripeDispute(ctx, disputeCollection, updated, record.StripeObservedAt); err != nil {
+		// Stripe 側は登録済みのため、charge.dispute.updated の Webhook で反映される
+		utils.LogErrorCtx(ctx, "AdminDispute", err, "Failed to save dispute, but Stripe was updated")
+	}
+	if _, err := disputeCollection.UpdateOne(ctx, bson.M{"stripe_dispute_id": record.StripeDisputeID},
+		bson.M{"$push": bson.M{"submissions": submission}}); err != nil {
+		utils.LogErrorCtx(ctx, "AdminDispute", err, "Failed to save evidence submission")
+	}
+
+	writeAuditLog(c, adminID, AuditActionDisputeEvidenceSubmitted, "dispute", record.StripeDisputeID, map[string]interface{}{
+		"fields":    submission.Fields,
+		"submitted": submit,
+	})
+	utils.LogInfoCtx(ctx, "AdminDispute", fmt.Sprintf("Evidence for dispute %s saved by %s (submitted: %t)", utils.MaskStripeID(record.StripeDisputeID), adminID.Hex(), submit))
+
+	message := "証拠を保存しました"
+	if submit {
+		message = "証拠を提出しました"
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message":                message,
+		"status":                 updated.Status,
+		"submission":             submission,
+		"subscription_suspended": suspend,
+	})
+}
+
+// suspendSubscriptionForDispute は異議申し立てに紐付く利用者のサブスクリプションを停止する
+// 休止と同じく Stripe の pause_collection で支払いを止めるが、再開日時は設けず、利用者自身では再開できない
+// 利用者による休止中の場合は、休止の履歴を今日で締めて停止に切り替える
+// 失敗した場合はレスポンスのステータスコードとエラーを返す
+func suspendSubscriptionForDispute(c *gin.Context, record *services.DisputeRecord, adminID primitive.ObjectID) (int, error) {
+	if record.UserID == nil {
+		return http.StatusBadRequest, errors.New("利用者が特定できないため、サブスクリプションを停止できません")
+	}
+
+	ctx := c.Request.Context()
+	var sub Subscription
+	if err := subscriptionCollection.FindOne(ctx, bson.M{"user_id": *record.UserID}).Decode(&sub); err != nil || sub.StripeSubscriptionID == "" || sub.Status == "canceled" {
+		return http.StatusBadRequest, errors.New("停止できるサブスクリプションがありません")
+	}
+	if sub.SuspendedByDisputeID != "" {
+		return 0, nil
+	}
+
+	params := &stripe.SubscriptionParams{
+		PauseCollection: &stripe.SubscriptionPauseCollectionParams{
+			Behavior: stripe.String(string(stripe.SubscriptionPauseCollectionBehaviorVoid)),
+		},
+	}
+	if _, err := billing.UpdateSubscription(ctx, sub.StripeSubscriptionID, params); err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	now := time.Now()
+	set := bson.M{"paused_at": now, "suspended_by_dispute_id": record.StripeDisputeID, "updated_at": now}
+	opts := options.Update()
+	if sub.PausedAt != nil {
+		set["paused_at"] = *sub.PausedAt
+		set["pause_history.$[open].ended_at"] = now
+		opts.SetArrayFilters(options.ArrayFilters{
+			Filters: []interface{}{bson.M{"open.ended_at": bson.M{"$gt": now}}},
+		})
+	}
+	update := bson.M{"$set": set, "$unset": bson.M{"pause_resumes_at": ""}}
+	if _, err := subscriptionCollection.UpdateByID(ctx, sub.ID, update, opts); err != nil {
+		// Stripe 側は停止済みのため、DB は Webhook やステータス取得時の同期で追従する
+		utils.LogErrorCtx(ctx, "AdminDispute", err, "Failed to save suspension state")
+	}
+	if _, err := disputeCollection.UpdateOne(ctx, bson.M{"stripe_dispute_id": record.StripeDisputeID},
+		bson.M{"$set": bson.M{"subscription_suspended_at": now}}); err != nil {
+		utils.LogErrorCtx(ctx, "AdminDispute", err, "Failed to save suspension on dispute")
+	}
+	invalidateEntitlements(ctx, *record.UserID)
+
+	writeAuditLog(c, adminID, AuditActionDisputeSubscriptionSuspended, "subscription", sub.StripeSubscriptionID, map[string]interface{}{
+		"dispute_id": record.StripeDisputeID,
+		"user_id":    record.UserID.Hex(),
+	})
+	utils.LogInfoCtx(ctx, "AdminDispute", fmt.Sprintf("Suspended subscription %s for dispute %s", sub.StripeSubscriptionID, utils.MaskStripeID(record.StripeDisputeID)))
+	return 0, nil
+}
+
+// AdminResumeDisputeSubscriptionHandler は異議申し立てのために停止したサブスクリプションを再開するハンドラ
+func AdminResumeDisputeSubscriptionHandler(c *gin.Context) {
+	record, ok := loadAdminDispute(c)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	var sub Subscription
+	if err := subscriptionCollection.FindOne(ctx, bson.M{"suspended_by_dispute_id": record.StripeDisputeID}).Decode(&sub); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "この異議申し立てで停止したサブスクリプションはありません"})
+		return
+	}
+
+	// pause_collection に空文字列を指定すると一時停止を解除する
+	params := &stripe.SubscriptionParams{}
+	params.AddExtra("pause_collection", "")
+	if _, err := billing.UpdateSubscription(ctx, sub.StripeSubscriptionID, params); err != nil {
+		utils.LogErrorCtx(ctx, "AdminDispute", err, "Failed to resume subscription in Stripe")
+		errMsg := "サブスクリプションの再開に失敗しました"
+		if os.Getenv("APP_ENV") != "production" {
+			errMsg += ": " + err.Error()
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": errMsg})
+		return
+	}
+
+	now := time.Now()
+	update := bson.M{
+		"$set":   bson.M{"updated_at": now},
+		"$unset": bson.M{"paused_at": "", "pause_resumes_at": "", "suspended_by_dispute_id": ""},
+	}
+	if _, err := subscriptionCollection.UpdateByID(ctx, sub.ID, update); err != nil {
+		utils.LogErrorCtx(ctx, "AdminDispute", err, "Failed to save resume state")
+	}
+	if _, err := disputeCollection.UpdateOne(ctx, bson.M{"stripe_dispute_id": record.StripeDisputeID},
+		bson.M{"$unset": bson.M{"subscription_suspended_at": ""}}); err != nil {
+		utils.LogErrorCtx(ctx, "AdminDispute", err, "Failed to clear suspension on dispute")
+	}
+	invalidateEntitlements(ctx, sub.UserID)
+
+	adminID, _ := middleware.CurrentUserID(c)
+	writeAuditLog(c, adminID, AuditActionDisputeSubscriptionResumed, "subscription", sub.StripeSubscriptionID, map[string]interface{}{
+		"dispute_id": record.StripeDisputeID,
+		"user_id":    sub.UserID.Hex(),
+	})
+	utils.LogInfoCtx(ctx, "AdminDispute", fmt.Sprintf("Resumed subscription %s suspended for dispute %s", sub.StripeSubscriptionID, utils.MaskStripeID(record.StripeDisputeID)))
+	c.JSON(http.StatusOK, gin.H{"message": "サブスクリプションを再開しました"})
+}
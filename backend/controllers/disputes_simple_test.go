@@ -0,0 +1,74 @@
+package controllers
+
+import (
+	"bytes"
+	"mime/multipart"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/stripe-go/v81"
+)
+
+// newDisputeEvidenceForm はテキストとファイルの証拠を含むフォームを作成する
+func newDisputeEvidenceForm(t *testing.T, texts map[string]string, files map[string][]byte) *multipart.Form {
+	t.Helper()
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for key, value := range texts {
+		require.NoError(t, writer.WriteField(key, value))
+	}
+	for key, data := range files {
+		part, err := writer.CreateFormFile(key, "../"+key+".pdf")
+		require.NoError(t, err)
+		_, err = part.Write(data)
+		require.NoError(t, err)
+	}
+	require.NoError(t, writer.Close())
+	form, err := multipart.NewReader(&body, writer.Boundary()).ReadForm(32 << 20)
+	require.NoError(t, err)
+	return form
+}
+
+// TestParseDisputeEvidence は管理画面から送信された証拠の検証をテストする
+func TestParseDisputeEvidence(t *testing.T) {
+	pdf := []byte("%PDF-1.4\n%test\n")
+	input, err := parseDisputeEvidence(newDisputeEvidenceForm(t,
+		map[string]string{"product_description": "  オンライン授業の月額利用料  ", "uncategorized_text": "   ", "unknown": "x"},
+		map[string][]byte{"receipt": pdf},
+	))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"product_description": "オンライン授業の月額利用料"}, input.texts)
+	require.Len(t, input.files, 1)
+	assert.Equal(t, "receipt", input.files[0].field.Key)
+	assert.Equal(t, "receipt.pdf", input.files[0].filename, "パスを取り除く")
+
+	_, err = parseDisputeEvidence(newDisputeEvidenceForm(t, map[string]string{"uncategorized_text": " "}, nil))
+	assert.Error(t, err, "証拠が空")
+	_, err = parseDisputeEvidence(newDisputeEvidenceForm(t,
+		map[string]string{"uncategorized_text": strings.Repeat("あ", maxDisputeEvidenceTextLength+1)}, nil))
+	assert.Error(t, err)
+	_, err = parseDisputeEvidence(newDisputeEvidenceForm(t, nil, map[string][]byte{"receipt": []byte("GIF89a")}))
+	assert.Error(t, err, "Stripe が受け付けない形式")
+	_, err = parseDisputeEvidence(newDisputeEvidenceForm(t, nil, map[string][]byte{"receipt": append(pdf, make([]byte, maxDisputeEvidenceFilesSize)...)}))
+	assert.Error(t, err, "合計サイズの上限")
+}
+
+// TestDisputeEvidenceFields は証拠の項目のキーが Stripe のフィールド名と一致することをテストする
+func TestDisputeEvidenceFields(t *testing.T) {
+	for _, field := range disputeEvidenceFields {
+		evidence := &stripe.DisputeEvidenceParams{}
+		field.set(evidence, stripe.String("value"))
+
+		var tags []string
+		v := reflect.ValueOf(evidence).Elem()
+		for i := 0; i < v.NumField(); i++ {
+			if value, ok := v.Field(i).Interface().(*string); ok && value != nil {
+				tags = append(tags, v.Type().Field(i).Tag.Get("form"))
+			}
+		}
+		assert.Equal(t, []string{field.Key}, tags, field.Key)
+	}
+}
@@ -0,0 +1,131 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"juice_academy_backend/middleware"
+	"juice_academy_backend/services"
+	"juice_academy_backend/utils"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// subscriptionGracePeriod は支払い遅延になってから利用を続けられる期間（SUBSCRIPTION_GRACE_DAYS で変更できる）
+// Stripe が支払いを再試行している間にカードを更新できるよう、すぐには利用を止めない
+var subscriptionGracePeriod = 7 * 24 * time.Hour
+
+// subscriberEntitlements はサブスクリプションで利用できる機能（現在はすべてのプランで共通）
+var subscriberEntitlements = []string{middleware.EntitlementPremiumContent}
+
+func init() {
+	if days := os.Getenv("SUBSCRIPTION_GRACE_DAYS"); days != "" {
+		if parsed, err := strconv.Atoi(days); err == nil && parsed >= 0 {
+			subscriptionGracePeriod = time.Duration(parsed) * 24 * time.Hour
+		}
+	}
+}
+
+// subscriptionGraceUntil は支払い遅延中のサブスクリプションを利用できる期限を返す（支払い遅延でない場合は nil）
+func subscriptionGraceUntil(sub Subscription) *time.Time {
+	if sub.Status != "past_due" || sub.PastDueAt == nil {
+		return nil
+	}
+	graceUntil := sub.PastDueAt.Add(subscriptionGracePeriod)
+	return &graceUntil
+}
+
+// resolveEntitlements はサブスクリプションのプラン・状態・猶予期間から利用権を導出する
+// sub が nil の場合は契約なしとして扱う
+func resolveEntitlements(userID primitive.ObjectID, sub *Subscription, now time.Time) *middleware.EntitlementSnapshot {
+	snapshot := &middleware.EntitlementSnapshot{
+		UserID:       userID.Hex(),
+		Status:       subscriptionStatusNone,
+		Entitlements: []string{},
+		LoadedAt:     now,
+	}
+	if sub == nil {
+		return snapshot
+	}
+	snapshot.PriceID = sub.PriceID
+	snapshot.Status = sub.Status
+	if !subscriptionGrantsAccess(*sub, now) {
+		return snapshot
+	}
+
+	snapshot.Entitlements = append(snapshot.Entitlements, subscriberEntitlements...)
+	// 猶予期間や解約予約の期間が終わる時点で再計算させる
+	if graceUntil := subscriptionGraceUntil(*sub); graceUntil != nil {
+		snapshot.GraceUntil = graceUntil
+		snapshot.ValidUntil = graceUntil
+	} else if sub.CancelAtPeriodEnd {
+		periodEnd := sub.CurrentPeriodEnd
+		snapshot.ValidUntil = &periodEnd
+	}
+	return snapshot
+}
+
+// LoadEntitlementSnapshot はRedisキャッシュを優先し、なければMongoDBのサブスクリプションから利用権を計算してキャッシュする
+// middleware.SetEntitlementSource に渡して RequireEntitlement の判定に使う
+func LoadEntitlementSnapshot(ctx context.Context, userID primitive.ObjectID) (*middleware.EntitlementSnapshot, error) {
+	now := time.Now()
+	var cached middleware.EntitlementSnapshot
+	if found, err := services.GetEntitlementSnapshot(ctx, userID.Hex(), &cached); err == nil && found && !cached.Expired(now) {
+		return &cached, nil
+	}
+
+	var sub Subscription
+	err := subscriptionCollection.FindOne(ctx, bson.M{"user_id": userID}).Decode(&sub)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+	var snapshot *middleware.EntitlementSnapshot
+	if err == mongo.ErrNoDocuments {
+		snapshot = resolveEntitlements(userID, nil, now)
+	} else {
+		snapshot = resolveEntitlements(userID, &sub, now)
+	}
+
+	if err := services.SetEntitlementSnapshot(ctx, userID.Hex(), snapshot); err != nil {
+		// キャッシュ保存の失敗は判定に影響しないため継続
+		utils.LogWarningCtx(ctx, "Entitlement", "Failed to cache entitlements: "+err.Error())
+	}
+	return snapshot, nil
+}
+
+// invalidateEntitlements はユーザーの利用権のキャッシュを破棄する
+// Webhook による変更は services.PublishSubscriptionChange で破棄されるため、ここではAPIでの操作直後に使う
+func invalidateEntitlements(ctx context.Context, userID primitive.ObjectID) {
+	if err := services.InvalidateEntitlementSnapshot(ctx, userID.Hex()); err != nil {
+		// キャッシュはTTLで失効するため、失敗しても処理は継続する
+		utils.LogWarningCtx(ctx, "Entitlement", "Failed to invalidate entitlements: "+err.Error())
+	}
+}
+
+// GetEntitlementsHandler はログイン中のユーザーが利用できる機能を返すハンドラ
+// フロントエンドはこれを使って機能の表示やプラン選択画面への誘導を切り替える
+func GetEntitlementsHandler(c *gin.Context) {
+	snapshot, err := middleware.EntitlementsFromContext(c)
+	if err == mongo.ErrNoDocuments {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "認証が必要です"})
+		return
+	}
+	if err != nil {
+		utils.LogErrorCtx(c.Request.Context(), "Entitlement", err, "Failed to load entitlements")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "契約情報の取得に失敗しました"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":       snapshot.Status,
+		"price_id":     snapshot.PriceID,
+		"entitlements": snapshot.Entitlements,
+		"grace_until":  snapshot.GraceUntil,
+	})
+}
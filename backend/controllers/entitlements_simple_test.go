@@ -0,0 +1,46 @@
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	"juice_academy_backend/middleware"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TestResolveEntitlements はサブスクリプションの状態から利用権を導出する処理をテストする
+func TestResolveEntitlements(t *testing.T) {
+	now := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	userID := primitive.NewObjectID()
+	periodEnd := now.AddDate(0, 0, 10)
+	pastDueAt := now.AddDate(0, 0, -2)
+	pausedAt := now.AddDate(0, 0, -1)
+
+	none := resolveEntitlements(userID, nil, now)
+	assert.Equal(t, subscriptionStatusNone, none.Status)
+	assert.Empty(t, none.Entitlements)
+
+	active := resolveEntitlements(userID, &Subscription{Status: "active", PriceID: "price_monthly", CurrentPeriodEnd: periodEnd}, now)
+	assert.True(t, active.Has(middleware.EntitlementPremiumContent))
+	assert.Equal(t, "price_monthly", active.PriceID)
+	assert.Nil(t, active.ValidUntil, "期限のない契約は変更時の無効化まで有効")
+
+	canceling := resolveEntitlements(userID, &Subscription{Status: "active", CurrentPeriodEnd: periodEnd, CancelAtPeriodEnd: true}, now)
+	assert.True(t, canceling.Has(middleware.EntitlementPremiumContent))
+	assert.Equal(t, &periodEnd, canceling.ValidUntil, "解約予約中は契約期間の終了時に再計算すること")
+
+	grace := resolveEntitlements(userID, &Subscription{Status: "past_due", CurrentPeriodEnd: periodEnd, PastDueAt: &pastDueAt}, now)
+	assert.True(t, grace.Has(middleware.EntitlementPremiumContent), "猶予期間中は利用できること")
+	graceUntil := pastDueAt.Add(subscriptionGracePeriod)
+	assert.Equal(t, &graceUntil, grace.GraceUntil)
+	assert.Equal(t, &graceUntil, grace.ValidUntil)
+
+	expired := resolveEntitlements(userID, &Subscription{Status: "past_due", CurrentPeriodEnd: periodEnd, PastDueAt: &pastDueAt}, graceUntil)
+	assert.Empty(t, expired.Entitlements, "猶予期間を過ぎたら利用できないこと")
+	assert.Equal(t, "past_due", expired.Status)
+
+	paused := resolveEntitlements(userID, &Subscription{Status: "active", CurrentPeriodEnd: periodEnd, PausedAt: &pausedAt}, now)
+	assert.Empty(t, paused.Entitlements)
+}
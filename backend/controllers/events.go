@@ -0,0 +1,279 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"juice_academy_backend/middleware"
+	"juice_academy_backend/services"
+	"juice_academy_backend/utils"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// イベントストリームの送信設定
+const (
+	// eventStreamHeartbeat はプロキシにアイドル切断されないよう送るコメント行の間隔
+	eventStreamHeartbeat = 25 * time.Second
+	// eventStreamWriteTimeout は1回の送信に許す時間（サーバー全体の WriteTimeout の代わり）
+	eventStreamWriteTimeout = 10 * time.Second
+	// eventStreamRetryMillis は切断時にブラウザが再接続するまでの待ち時間
+	eventStreamRetryMillis = 5000
+)
+
+// announcementAnnouncedTTL は公開通知済みの記録を保持する期間
+const announcementAnnouncedTTL = 30 * 24 * time.Hour
+
+// announcementSchedulerWindow は予約公開を通知対象とする公開日時の範囲（サーバー停止中の取りこぼし対策）
+const announcementSchedulerWindow = 15 * time.Minute
+
+// AnnouncementEventData はお知らせのイベントでクライアントに送る内容
+// 本文は含めず、クライアントは必要に応じて一覧・詳細を再取得する
+type AnnouncementEventData struct {
+	ID        string     `json:"id"`
+	Title     string     `json:"title,omitempty"`
+	Category  string     `json:"category,omitempty"`
+	Pinned    bool       `json:"pinned,omitempty"`
+	Important bool       `json:"important,omitempty"`
+	PublishAt *time.Time `json:"publishAt,omitempty"`
+}
+
+var (
+	announcementEventStopChan chan struct{}
+	announcementEventWg       sync.WaitGroup
+	announcementEventOnce     sync.Once
+)
+
+// publishAnnouncementEvent はお知らせのイベントを配信する（失敗しても呼び出し元の処理は成功として扱う）
+func publishAnnouncementEvent(ctx context.Context, eventType string, a *Announcement) {
+	data := AnnouncementEventData{ID: a.ID.Hex()}
+	if eventType != services.EventAnnouncementDeleted {
+		data = AnnouncementEventData{
+			ID:        a.ID.Hex(),
+			Title:     a.Title,
+			Category:  a.Category,
+			Pinned:    a.Pinned,
+			Important: a.Important,
+			PublishAt: a.PublishAt,
+		}
+	}
+	event, err := services.NewStreamEvent(eventType, "", data)
+	if err == nil && a.Audience != nil {
+		event.Audience, err = json.Marshal(a.Audience)
+	}
+	if err == nil {
+		err = services.PublishEvent(ctx, event)
+	}
+	if err != nil {
+		utils.LogErrorCtx(ctx, "AnnouncementEvent", err, "Failed to publish announcement event")
+	}
+}
+
+// claimAnnouncementPublished は公開の通知をまだ送っていなければ送信済みとして記録し、true を返す
+// 予約公開のスケジューラは全サーバーで動くため、Redis で1回だけ通知されるようにする
+func claimAnnouncementPublished(ctx context.Context, a *Announcement) bool {
+	if services.RedisClient == nil {
+		return true
+	}
+	claimed, err := services.RedisClient.SetNX(ctx, "events:announced:"+a.ID.Hex(), 1, announcementAnnouncedTTL).Result()
+	if err != nil {
+		utils.LogErrorCtx(ctx, "AnnouncementEvent", err, "Failed to record announced state")
+		return true
+	}
+	return claimed
+}
+
+// notifyAnnouncementChanged はお知らせの作成・更新後の状態に応じてイベントを配信する
+// 初めて公開中になった場合は published、公開中の更新は updated、非公開になった場合は deleted を送る
+func notifyAnnouncementChanged(ctx context.Context, a *Announcement, now time.Time) {
+	if a.DeletedAt != nil || a.StatusAt(now) != AnnouncementStatusPublished {
+		publishAnnouncementEvent(ctx, services.EventAnnouncementDeleted, a)
+		return
+	}
+	if claimAnnouncementPublished(ctx, a) {
+		publishAnnouncementEvent(ctx, services.EventAnnouncementPublished, a)
+		return
+	}
+	publishAnnouncementEvent(ctx, services.EventAnnouncementUpdated, a)
+}
+
+// StartAnnouncementEventScheduler は予約公開のお知らせが公開時刻を迎えたときにイベントを配信する
+func StartAnnouncementEventScheduler(interval time.Duration) {
+	announcementEventOnce.Do(func() {
+		announcementEventStopChan = make(chan struct{})
+		announcementEventWg.Add(1)
+		go func() {
+			defer announcementEventWg.Done()
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-announcementEventStopChan:
+					return
+				case <-ticker.C:
+					if err := publishScheduledAnnouncements(context.Background(), time.Now()); err != nil {
+						utils.LogError("AnnouncementEvent", err, "scheduled announcement check failed")
+					}
+				}
+			}
+		}()
+		utils.LogInfo("AnnouncementEvent", fmt.Sprintf("Started announcement event scheduler (interval=%s)", interval))
+	})
+}
+
+// ShutdownAnnouncementEventScheduler は予約公開のスケジューラを停止する
+func ShutdownAnnouncementEventScheduler() {
+	if announcementEventStopChan != nil {
+		close(announcementEventStopChan)
+		announcementEventWg.Wait()
+	}
+}
+
+// publishScheduledAnnouncements は直近に公開時刻を迎えたお知らせのうち、未通知のものを配信する
+func publishScheduledAnnouncements(ctx context.Context, now time.Time) error {
+	filter := liveAnnouncementFilter(now)
+	filter["publish_at"] = bson.M{"$gt": now.Add(-announcementSchedulerWindow), "$lte": now}
+	announcements, err := findAnnouncements(ctx, filter,
+		options.Find().SetProjection(bson.M{"content": 0, "content_html": 0, "attachments": 0}))
+	if err != nil {
+		return err
+	}
+	for i := range announcements {
+		if claimAnnouncementPublished(ctx, &announcements[i]) {
+			publishAnnouncementEvent(ctx, services.EventAnnouncementPublished, &announcements[i])
+		}
+	}
+	return nil
+}
+
+// streamEventVisible は閲覧者にイベントを送ってよいかを判定する
+func streamEventVisible(event services.StreamEvent, viewer announcementViewer) bool {
+	if len(event.Audience) == 0 {
+		return true
+	}
+	var audience AnnouncementAudience
+	if err := json.Unmarshal(event.Audience, &audience); err != nil {
+		return false
+	}
+	return audienceMatches(&audience, viewer)
+}
+
+// writeStreamEvent は Server-Sent Events の形式でイベントを1件書き込む
+// データに改行が含まれる場合は行ごとに data フィールドを分ける
+func writeStreamEvent(w io.Writer, eventType string, data []byte) error {
+	var buf bytes.Buffer
+	if eventType != "" {
+		buf.WriteString("event: " + eventType + "\n")
+	}
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		buf.WriteString("data: ")
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	buf.WriteByte('\n')
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// EventStreamHandler はログイン中のユーザーにお知らせとサブスクリプションの変更を
+// Server-Sent Events で配信するハンドラ
+// アクセストークンの有効期限で接続を閉じるため、クライアントはトークンを更新して再接続する
+func EventStreamHandler(c *gin.Context) {
+	viewer, err := resolveAnnouncementViewer(c)
+	if err != nil {
+		utils.LogErrorCtx(c.Request.Context(), "EventStream", err, "Failed to resolve viewer")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "ユーザー情報の取得に失敗しました"})
+		return
+	}
+	if viewer.Anonymous {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "認証が必要です"})
+		return
+	}
+
+	var expiry <-chan time.Time
+	if claims, ok := middleware.ClaimsFromContext(c); ok && claims.ExpiresAt != nil {
+		timer := time.NewTimer(time.Until(claims.ExpiresAt.Time))
+		defer timer.Stop()
+		expiry = timer.C
+	}
+
+	subscriber := services.DefaultEventHub.Subscribe(viewer.UserID.Hex())
+	defer services.DefaultEventHub.Unsubscribe(subscriber)
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	// nginx などのリバースプロキシでバッファリングさせない
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	controller := http.NewResponseController(c.Writer)
+	send := func(write func() error) bool {
+		// サーバー全体の WriteTimeout では長時間の接続が切れるため、送信ごとに期限を延ばす
+		if err := controller.SetWriteDeadline(time.Now().Add(eventStreamWriteTimeout)); err != nil && !errors.Is(err, http.ErrNotSupported) {
+			return false
+		}
+		if err := write(); err != nil {
+			return false
+		}
+		return controller.Flush() == nil
+	}
+
+	ready := func() error {
+		if _, err := fmt.Fprintf(c.Writer, "retry: %d\n\n", eventStreamRetryMillis); err != nil {
+			return err
+		}
+		return writeStreamEvent(c.Writer, "ready", []byte(`{}`))
+	}
+	if !send(ready) {
+		return
+	}
+
+	heartbeat := time.NewTicker(eventStreamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-subscriber.Done():
+			return
+		case <-expiry:
+			return
+		case <-heartbeat.C:
+			if !send(func() error {
+				_, err := io.WriteString(c.Writer, ": ping\n\n")
+				return err
+			}) {
+				return
+			}
+		case event := <-subscriber.Events():
+			if event.Type == services.EventSubscriptionUpdated {
+				// 以降のお知らせの配信対象の判定に、変更後の契約状態を使う
+				var status services.SubscriptionStatusEvent
+				if json.Unmarshal(event.Data, &status) == nil {
+					sub := Subscription{Status: status.Status, CancelAtPeriodEnd: status.CancelAtPeriodEnd, PausedAt: status.PausedAt}
+					if status.CurrentPeriodEnd != nil {
+						sub.CurrentPeriodEnd = *status.CurrentPeriodEnd
+					}
+					viewer.SubscriptionStatus = audienceSubscriptionStatus(sub, time.Now())
+				}
+			}
+			if !streamEventVisible(event, viewer) {
+				continue
+			}
+			if !send(func() error { return writeStreamEvent(c.Writer, event.Type, event.Data) }) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"juice_academy_backend/services"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteStreamEvent(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, writeStreamEvent(&buf, services.EventAnnouncementPublished, []byte(`{"id":"1"}`)))
+	assert.Equal(t, "event: announcement.published\ndata: {\"id\":\"1\"}\n\n", buf.String())
+
+	buf.Reset()
+	require.NoError(t, writeStreamEvent(&buf, "", []byte("a\nb")))
+	assert.Equal(t, "data: a\ndata: b\n\n", buf.String())
+}
+
+func TestStreamEventVisible(t *testing.T) {
+	audience, err := json.Marshal(AnnouncementAudience{Roles: []string{"teacher"}})
+	require.NoError(t, err)
+	restricted := services.StreamEvent{Type: services.EventAnnouncementPublished, Audience: audience}
+	everyone := services.StreamEvent{Type: services.EventAnnouncementPublished}
+
+	student := announcementViewer{Role: "student"}
+	teacher := announcementViewer{Role: "teacher"}
+	admin := announcementViewer{Admin: true}
+
+	assert.True(t, streamEventVisible(everyone, student))
+	assert.False(t, streamEventVisible(restricted, student))
+	assert.True(t, streamEventVisible(restricted, teacher))
+	assert.True(t, streamEventVisible(restricted, admin))
+
+	broken := services.StreamEvent{Audience: json.RawMessage(`{`)}
+	assert.False(t, streamEventVisible(broken, teacher))
+}
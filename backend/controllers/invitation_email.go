@@ -0,0 +1,224 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"juice_academy_backend/services"
+	"juice_academy_backend/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// 招待メールの送信状態
+const (
+	InvitationEmailQueued  = "queued"
+	InvitationEmailSending = "sending"
+	InvitationEmailSent    = "sent"
+	InvitationEmailFailed  = "failed"
+)
+
+// InvitationEmailConfig は招待メールの送信設定
+type InvitationEmailConfig struct {
+	// Interval は送信待ちの招待を確認する間隔（招待の作成時にはすぐに送信を始める）
+	Interval time.Duration
+	// BatchSize / BatchPause はSMTPサーバーの送信制限に合わせて、BatchSize 通ごとに BatchPause だけ待つ
+	BatchSize  int
+	BatchPause time.Duration
+	// MaxAttempts は送信に失敗した招待を再送する上限回数
+	MaxAttempts int
+	// ClaimTimeout を過ぎても送信中のままの招待は、サーバーが停止したものとして再送する
+	ClaimTimeout time.Duration
+}
+
+// DefaultInvitationEmailConfig はデフォルトの招待メール送信設定
+var DefaultInvitationEmailConfig = InvitationEmailConfig{
+	Interval:     time.Minute,
+	BatchSize:    50,
+	BatchPause:   5 * time.Second,
+	MaxAttempts:  3,
+	ClaimTimeout: 10 * time.Minute,
+}
+
+var (
+	invitationEmailStopChan chan struct{}
+	invitationEmailWakeChan chan struct{}
+	invitationEmailWg       sync.WaitGroup
+	invitationEmailOnce     sync.Once
+
+	// sendInvitationEmail は招待メールの送信処理（テストで差し替える）
+	sendInvitationEmail = services.SendInvitationEmail
+)
+
+// invitationRecipient は招待メールの送信先ユーザー
+type invitationRecipient struct {
+	Email     string `bson:"email"`
+	NameKana  string `bson:"name_kana"`
+	StudentID string `bson:"student_id"`
+	Status    string `bson:"status"`
+}
+
+// StartInvitationEmailWorker は送信待ちの招待メールを送信するワーカーを起動する
+// SMTP設定がない環境では起動せず、招待は送信待ちのまま残る
+func StartInvitationEmailWorker(config InvitationEmailConfig) {
+	if !services.EmailConfigured() {
+		utils.LogWarning("InvitationEmail", "SMTP is not configured, invitation emails will stay queued")
+		return
+	}
+
+	invitationEmailOnce.Do(func() {
+		invitationEmailStopChan = make(chan struct{})
+		invitationEmailWakeChan = make(chan struct{}, 1)
+		invitationEmailWg.Add(1)
+		go func() {
+			defer invitationEmailWg.Done()
+			ticker := time.NewTicker(config.Interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-invitationEmailStopChan:
+					return
+				case <-ticker.C:
+				case <-invitationEmailWakeChan:
+				}
+				if err := runInvitationEmails(context.Background(), config); err != nil {
+					utils.LogError("InvitationEmail", err, "invitation email run failed")
+				}
+			}
+		}()
+		utils.LogInfo("InvitationEmail", fmt.Sprintf("Started invitation email worker (interval=%s)", config.Interval))
+	})
+}
+
+// ShutdownInvitationEmailWorker は招待メールのワーカーを停止する
+func ShutdownInvitationEmailWorker() {
+	if invitationEmailStopChan != nil {
+		close(invitationEmailStopChan)
+		invitationEmailWg.Wait()
+	}
+}
+
+// wakeInvitationEmailWorker は次の確認を待たずに送信を始めるようワーカーに通知する
+func wakeInvitationEmailWorker() {
+	if invitationEmailWakeChan == nil {
+		return
+	}
+	select {
+	case invitationEmailWakeChan <- struct{}{}:
+	default:
+		// 既に通知済みの場合は、その実行で今回の招待も送信される
+	}
+}
+
+// runInvitationEmails は送信待ちの招待がなくなるまで招待メールを送信する
+// 招待を1件ずつ確保してから送信するため、複数のサーバーで実行しても二重送信しない
+func runInvitationEmails(ctx context.Context, config InvitationEmailConfig) error {
+	acceptURL := os.Getenv("INVITATION_ACCEPT_URL")
+	if acceptURL == "" {
+		return errors.New("INVITATION_ACCEPT_URL is not set")
+	}
+
+	sent, failed, inBatch := 0, 0, 0
+	for {
+		invitation, token, err := claimInvitationEmail(ctx, config, time.Now())
+		if err != nil {
+			return err
+		}
+		if invitation == nil {
+			break
+		}
+
+		if inBatch == config.BatchSize {
+			time.Sleep(config.BatchPause)
+			inBatch = 0
+		}
+		inBatch++
+
+		if err := deliverInvitationEmail(ctx, config, invitation, acceptURL+"?token="+url.QueryEscape(token)); err != nil {
+			failed++
+			utils.LogError("InvitationEmail", err, "invitation_id="+invitation.ID.Hex())
+			continue
+		}
+		sent++
+	}
+
+	if sent+failed > 0 {
+		utils.LogInfo("InvitationEmail", fmt.Sprintf("Sent invitation emails: sent=%d failed=%d", sent, failed))
+	}
+	return nil
+}
+
+// claimInvitationEmail は送信待ちの招待を1件確保し、メールに載せるトークンを発行する
+// トークンは送信のたびに発行し直すため、再送すると以前のメールのリンクは使えなくなる
+func claimInvitationEmail(ctx context.Context, config InvitationEmailConfig, now time.Time) (*Invitation, string, error) {
+	token, err := generateSecureToken(32)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var invitation Invitation
+	err = invitationCollection.FindOneAndUpdate(ctx,
+		bson.M{
+			"used_at":    bson.M{"$exists": false},
+			"expires_at": bson.M{"$gt": now},
+			"$or": bson.A{
+				bson.M{"email_status": InvitationEmailQueued, "email_retry_at": bson.M{"$not": bson.M{"$gt": now}}},
+				bson.M{"email_status": InvitationEmailSending, "email_claimed_at": bson.M{"$lt": now.Add(-config.ClaimTimeout)}},
+			},
+		},
+		bson.M{
+			"$set": bson.M{"email_status": InvitationEmailSending, "email_claimed_at": now, "token_hash": hashToken(token)},
+			"$inc": bson.M{"email_attempts": 1},
+		},
+		options.FindOneAndUpdate().
+			SetSort(bson.D{{Key: "created_at", Value: 1}}).
+			SetReturnDocument(options.After),
+	).Decode(&invitation)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return &invitation, token, nil
+}
+
+// deliverInvitationEmail は確保した招待のメールを送信し、結果を招待に記録する
+// 送信に失敗した場合は MaxAttempts 回まで、次の確認の間隔をあけて送信待ちに戻す
+func deliverInvitationEmail(ctx context.Context, config InvitationEmailConfig, invitation *Invitation, setupURL string) error {
+	var recipient invitationRecipient
+	sendErr := userCollection.FindOne(ctx, bson.M{"_id": invitation.UserID}).Decode(&recipient)
+	if sendErr == nil && recipient.Status != "pending" {
+		sendErr = errors.New("user is no longer pending")
+	}
+	if sendErr == nil {
+		sendErr = sendInvitationEmail(recipient.Email, recipient.NameKana, recipient.StudentID, setupURL, int(invitationDuration.Hours()/24))
+	}
+
+	update := bson.M{
+		"$set":   bson.M{"email_status": InvitationEmailSent, "email_sent_at": time.Now()},
+		"$unset": bson.M{"email_claimed_at": "", "email_error": "", "email_retry_at": ""},
+	}
+	if sendErr != nil {
+		status := InvitationEmailQueued
+		if invitation.EmailAttempts >= config.MaxAttempts {
+			status = InvitationEmailFailed
+		}
+		update = bson.M{
+			"$set":   bson.M{"email_status": status, "email_error": sendErr.Error(), "email_retry_at": time.Now().Add(config.Interval)},
+			"$unset": bson.M{"email_claimed_at": ""},
+		}
+	}
+
+	if _, err := invitationCollection.UpdateOne(ctx, bson.M{"_id": invitation.ID}, update); err != nil {
+		return err
+	}
+	return sendErr
+}
@@ -0,0 +1,337 @@
+package controllers
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"juice_academy_backend/services"
+	"juice_academy_backend/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stripe/stripe-go/v81"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	defaultPaymentHistoryPageSize = 20
+	maxPaymentHistoryPageSize     = 100
+)
+
+// invoiceCollection は Stripe の請求書の写し（invoice.* の Webhook とバックフィルで更新する）
+var invoiceCollection *mongo.Collection
+
+// InitInvoiceCollection は請求書コレクションを初期化する
+func InitInvoiceCollection(client *mongo.Client) {
+	invoiceCollection = client.Database("juice_academy").Collection("invoices")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_ = createInvoiceIndexes(ctx, invoiceCollection)
+}
+
+// createInvoiceIndexes は請求書コレクションのインデックスを作成する
+// stripe_invoice_id の一意制約は古いイベントによる上書きの防止にも使う（services.ApplyStripeInvoice）
+func createInvoiceIndexes(ctx context.Context, collection *mongo.Collection) error {
+	_, err := collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			// Webhook の重複・順不同の到着でも1件にまとめる
+			Keys:    bson.D{{Key: "stripe_invoice_id", Value: 1}},
+			Options: options.Index().SetUnique(true).SetName("stripe_invoice_id_unique"),
+		},
+		{
+			// 支払い履歴（顧客ごとに新しい順）
+			Keys:    bson.D{{Key: "stripe_customer_id", Value: 1}, {Key: "created_at", Value: -1}, {Key: "_id", Value: -1}},
+			Options: options.Index().SetName("customer_created_at"),
+		},
+	})
+	return err
+}
+
+// processInvoiceLedger はinvoice.*イベントの請求書を invoices コレクションに反映する
+func processInvoiceLedger(ctx context.Context, event stripe.Event) {
+	var inv stripe.Invoice
+	if err := json.Unmarshal(event.Data.Raw, &inv); err != nil {
+		utils.LogErrorCtx(ctx, "StripeWebhook", err, "Failed to parse invoice data")
+		return
+	}
+
+	if err := services.ApplyStripeInvoice(ctx, invoiceCollection, &inv, time.Unix(event.Created, 0)); err != nil {
+		utils.LogErrorCtx(ctx, "StripeWebhook", err, "Failed to save invoice: "+utils.MaskStripeID(inv.ID))
+	}
+}
+
+// paymentHistoryParams は支払い履歴のクエリパラメータ
+type paymentHistoryParams struct {
+	Limit  int
+	Cursor *invoiceCursor
+	From   *time.Time
+	To     *time.Time
+}
+
+// invoiceCursor は請求日時と _id によるキーセットページング用のカーソル
+type invoiceCursor struct {
+	Time time.Time          `json:"t"`
+	ID   primitive.ObjectID `json:"id"`
+}
+
+func (c invoiceCursor) encode() string {
+	raw, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeInvoiceCursor(value string) (*invoiceCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	var cursor invoiceCursor
+	if err := json.Unmarshal(raw, &cursor); err != nil {
+		return nil, err
+	}
+	if cursor.ID.IsZero() || cursor.Time.IsZero() {
+		return nil, errors.New("incomplete cursor")
+	}
+	return &cursor, nil
+}
+
+// parsePaymentHistoryParams は limit / cursor / from / to を読み取る
+// 日付はお知らせ一覧と同じく RFC3339 または YYYY-MM-DD（日本時間）で指定する
+func parsePaymentHistoryParams(c *gin.Context) (paymentHistoryParams, string) {
+	params := paymentHistoryParams{Limit: defaultPaymentHistoryPageSize}
+
+	if limit := c.Query("limit"); limit != "" {
+		parsed, err := strconv.Atoi(limit)
+		if err != nil || parsed < 1 {
+			return params, "limit は1以上の整数で指定してください"
+		}
+		if parsed > maxPaymentHistoryPageSize {
+			parsed = maxPaymentHistoryPageSize
+		}
+		params.Limit = parsed
+	}
+
+	if cursor := c.Query("cursor"); cursor != "" {
+		decoded, err := decodeInvoiceCursor(cursor)
+		if err != nil {
+			return params, "無効なカーソルです"
+		}
+		params.Cursor = decoded
+	}
+
+	if from := c.Query("from"); from != "" {
+		t, err := parseAnnouncementDate(from, false)
+		if err != nil {
+			return params, "from の日付形式が正しくありません"
+		}
+		params.From = t
+	}
+	if to := c.Query("to"); to != "" {
+		t, err := parseAnnouncementDate(to, true)
+		if err != nil {
+			return params, "to の日付形式が正しくありません"
+		}
+		params.To = t
+	}
+	if params.From != nil && params.To != nil && !params.From.Before(*params.To) {
+		return params, "from は to より前の日付を指定してください"
+	}
+
+	return params, ""
+}
+
+// invoicePage は支払い履歴の1ページ分の請求書
+type invoicePage struct {
+	Invoices   []services.InvoiceRecord
+	Total      int64
+	NextCursor string
+}
+
+// listInvoicePage は顧客の請求書を請求日時の新しい順に1ページ分取得する
+func listInvoicePage(ctx context.Context, customerID string, params paymentHistoryParams) (*invoicePage, error) {
+	conditions := []bson.M{{"stripe_customer_id": customerID}}
+	if params.From != nil || params.To != nil {
+		dateRange := bson.M{}
+		if params.From != nil {
+			dateRange["$gte"] = *params.From
+		}
+		if params.To != nil {
+			dateRange["$lt"] = *params.To
+		}
+		conditions = append(conditions, bson.M{"created_at": dateRange})
+	}
+
+	total, err := invoiceCollection.CountDocuments(ctx, bson.M{"$and": conditions})
+	if err != nil {
+		return nil, err
+	}
+
+	if params.Cursor != nil {
+		conditions = append(conditions, bson.M{"$or": []bson.M{
+			{"created_at": bson.M{"$lt": params.Cursor.Time}},
+			{"created_at": params.Cursor.Time, "_id": bson.M{"$lt": params.Cursor.ID}},
+		}})
+	}
+
+	// 次ページの有無を判定するため1件多く取得する
+	findOptions := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}).
+		SetLimit(int64(params.Limit + 1))
+	cursor, err := invoiceCollection.Find(ctx, bson.M{"$and": conditions}, findOptions)
+	if err != nil {
+		return nil, err
+	}
+	invoices := []services.InvoiceRecord{}
+	if err := cursor.All(ctx, &invoices); err != nil {
+		return nil, err
+	}
+
+	page := &invoicePage{Invoices: invoices, Total: total}
+	if len(invoices) > params.Limit {
+		page.Invoices = invoices[:params.Limit]
+		last := page.Invoices[params.Limit-1]
+		page.NextCursor = invoiceCursor{Time: last.CreatedAt, ID: last.ID}.encode()
+	}
+	return page, nil
+}
+
+// paymentHistoryStatus は請求書の状態を支払い履歴の表示用の状態に変換する
+func paymentHistoryStatus(status string) string {
+	switch stripe.InvoiceStatus(status) {
+	case stripe.InvoiceStatusPaid:
+		return "success"
+	case stripe.InvoiceStatusOpen:
+		return "pending"
+	case stripe.InvoiceStatusDraft:
+		return "draft"
+	case stripe.InvoiceStatusUncollectible:
+		return "failed"
+	case stripe.InvoiceStatusVoid:
+		return "voided"
+	default:
+		return status
+	}
+}
+
+// invoiceDescription は請求書の説明を返す
+// 請求書の説明、プラン名、明細の説明の順に使う
+func invoiceDescription(inv services.InvoiceRecord, planNames map[string]string) string {
+	if inv.Description != "" {
+		return inv.Description
+	}
+	if len(inv.Lines) > 0 {
+		line := inv.Lines[0]
+		if planNames[line.PriceID] != "" {
+			return planInvoiceDescription(planNames[line.PriceID])
+		}
+		if line.Description != "" {
+			return line.Description
+		}
+	}
+	return planInvoiceDescription("")
+}
+
+// paymentHistoryEntry は請求書を支払い履歴の1行に変換する
+func paymentHistoryEntry(inv services.InvoiceRecord, planNames map[string]string) gin.H {
+	// 金額（Stripeは最小単位で保存しているため、JPYの場合はそのまま）
+	amount := inv.AmountPaid
+	if amount == 0 {
+		amount = inv.AmountDue
+	}
+
+	status := paymentHistoryStatus(inv.Status)
+	if inv.AmountRefunded > 0 {
+		status = "partially_refunded"
+		if inv.AmountRefunded >= inv.AmountPaid {
+			status = "refunded"
+		}
+	}
+
+	// クレジットノートは利用者向けの内容（番号・金額・PDF）のみ返し、管理者のメモは含めない
+	creditNotes := make([]gin.H, 0, len(inv.CreditNotes))
+	for _, cn := range inv.CreditNotes {
+		if cn.Status == string(stripe.CreditNoteStatusVoid) {
+			continue
+		}
+		creditNotes = append(creditNotes, gin.H{
+			"number":     cn.Number,
+			"amount":     cn.Amount,
+			"pdf":        cn.PDF,
+			"created_at": cn.CreatedAt,
+		})
+	}
+
+	return gin.H{
+		"id":                 inv.StripeInvoiceID,
+		"amount":             amount,
+		"amount_refunded":    inv.AmountRefunded,
+		"credit_notes":       creditNotes,
+		"tax":                inv.Tax,
+		"status":             status,
+		"type":               "subscription",
+		"created_at":         inv.CreatedAt,
+		"description":        invoiceDescription(inv, planNames),
+		"stripe_status":      inv.Status,
+		"invoice_number":     inv.Number,
+		"hosted_invoice_url": inv.HostedInvoiceURL,
+		"invoice_pdf":        inv.InvoicePDF,
+	}
+}
+
+// InvoiceBackfillOptions は請求書のバックフィルの対象
+type InvoiceBackfillOptions struct {
+	// CustomerID を指定した場合はその顧客のみを対象にする
+	CustomerID string
+}
+
+// InvoiceBackfillReport はバックフィルの結果
+type InvoiceBackfillReport struct {
+	Customers int
+	Invoices  int
+	Failures  []string
+}
+
+// BackfillInvoices は Stripe 顧客を持つ利用者の請求書を Stripe から取得して invoices コレクションに保存する
+// invoices コレクションの導入前の履歴を取り込むためのもので、何度実行しても結果は同じになる
+func BackfillInvoices(ctx context.Context, opts InvoiceBackfillOptions) (*InvoiceBackfillReport, error) {
+	filter := bson.M{"stripe_customer_id": bson.M{"$nin": bson.A{"", nil}}}
+	if opts.CustomerID != "" {
+		filter = bson.M{"stripe_customer_id": opts.CustomerID}
+	}
+	cursor, err := paymentCollection.Find(ctx, filter, options.Find().SetProjection(bson.M{"stripe_customer_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	report := &InvoiceBackfillReport{}
+	for cursor.Next(ctx) {
+		var payment Payment
+		if err := cursor.Decode(&payment); err != nil {
+			return nil, err
+		}
+		report.Customers++
+
+		observedAt := time.Now()
+		invoices, err := billing.ListInvoices(ctx, payment.StripeCustomerID)
+		if err != nil {
+			report.Failures = append(report.Failures, fmt.Sprintf("%s: %v", payment.StripeCustomerID, err))
+			continue
+		}
+		for _, inv := range invoices {
+			if err := services.ApplyStripeInvoice(ctx, invoiceCollection, inv, observedAt); err != nil {
+				report.Failures = append(report.Failures, fmt.Sprintf("%s: %v", inv.ID, err))
+				continue
+			}
+			report.Invoices++
+		}
+	}
+	return report, cursor.Err()
+}
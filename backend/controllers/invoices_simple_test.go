@@ -0,0 +1,80 @@
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"juice_academy_backend/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func parsePaymentHistoryParamsFromURL(t *testing.T, url string) (paymentHistoryParams, string) {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	req, err := http.NewRequest("GET", url, nil)
+	require.NoError(t, err)
+	c.Request = req
+	return parsePaymentHistoryParams(c)
+}
+
+// TestParsePaymentHistoryParams は支払い履歴のクエリパラメータの解釈をテストする
+func TestParsePaymentHistoryParams(t *testing.T) {
+	params, msg := parsePaymentHistoryParamsFromURL(t, "/api/payment/history")
+	assert.Empty(t, msg)
+	assert.Equal(t, defaultPaymentHistoryPageSize, params.Limit)
+	assert.Nil(t, params.Cursor)
+
+	params, msg = parsePaymentHistoryParamsFromURL(t, "/api/payment/history?limit=1000")
+	assert.Empty(t, msg)
+	assert.Equal(t, maxPaymentHistoryPageSize, params.Limit)
+
+	cursor := invoiceCursor{Time: time.Date(2026, 4, 1, 9, 0, 0, 0, time.UTC), ID: primitive.NewObjectID()}
+	params, msg = parsePaymentHistoryParamsFromURL(t, "/api/payment/history?from=2026-04-01&to=2026-04-30&cursor="+cursor.encode())
+	require.Empty(t, msg)
+	require.NotNil(t, params.Cursor)
+	assert.Equal(t, cursor.ID, params.Cursor.ID)
+	assert.Equal(t, time.Date(2026, 3, 31, 15, 0, 0, 0, time.UTC), params.From.UTC())
+	assert.Equal(t, time.Date(2026, 4, 30, 15, 0, 0, 0, time.UTC), params.To.UTC(), "to は指定日を含むこと")
+
+	for _, query := range []string{"limit=0", "limit=abc", "cursor=broken", "from=2026/04/01", "from=2026-05-01&to=2026-04-01"} {
+		_, msg := parsePaymentHistoryParamsFromURL(t, "/api/payment/history?"+query)
+		assert.NotEmpty(t, msg, query)
+	}
+}
+
+// TestPaymentHistoryEntry は保存済みの請求書から支払い履歴の1行への変換をテストする
+func TestPaymentHistoryEntry(t *testing.T) {
+	planNames := map[string]string{"price_monthly": "月額プラン"}
+	paid := services.InvoiceRecord{
+		StripeInvoiceID:  "in_paid",
+		Status:           "paid",
+		Tax:              89,
+		AmountDue:        980,
+		AmountPaid:       980,
+		HostedInvoiceURL: "https://invoice.stripe.test/in_paid",
+		Lines:            []services.InvoiceLineRecord{{PriceID: "price_monthly", Description: "1 × 月額プラン"}},
+	}
+	entry := paymentHistoryEntry(paid, planNames)
+	assert.Equal(t, "success", entry["status"])
+	assert.Equal(t, int64(980), entry["amount"])
+	assert.Equal(t, int64(89), entry["tax"])
+	assert.Equal(t, planInvoiceDescription("月額プラン"), entry["description"])
+	assert.Equal(t, paid.HostedInvoiceURL, entry["hosted_invoice_url"])
+
+	failed := services.InvoiceRecord{
+		StripeInvoiceID: "in_open",
+		Status:          "open",
+		AmountDue:       1200,
+		Lines:           []services.InvoiceLineRecord{{PriceID: "price_retired", Description: "旧プラン"}},
+	}
+	entry = paymentHistoryEntry(failed, planNames)
+	assert.Equal(t, "pending", entry["status"])
+	assert.Equal(t, int64(1200), entry["amount"], "未払いの場合は請求額を表示すること")
+	assert.Equal(t, "旧プラン", entry["description"], "カタログにない価格は明細の説明を使うこと")
+}
@@ -0,0 +1,246 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"juice_academy_backend/services"
+	"juice_academy_backend/utils"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	oidcProvider *services.OIDCProvider
+	oidcConfig   services.OIDCConfig
+)
+
+const oidcStateCookie = "oidc_state"
+
+var (
+	errOIDCAccountNotFound = errors.New("no user matches the identity")
+	errOIDCAccountConflict = errors.New("identity matches a different linked account")
+	errOIDCAccountPending  = errors.New("identity matches an invited user who has not accepted the invitation")
+)
+
+// InitOIDC は環境変数から学校IdPとのOIDCログインを構成する
+// OIDC_ENABLED が true でない場合は false を返し、ルートは登録しない
+func InitOIDC() (bool, error) {
+	config, enabled := services.OIDCConfigFromEnv()
+	if !enabled {
+		return false, nil
+	}
+	if err := config.Validate(); err != nil {
+		return false, err
+	}
+
+	oidcConfig = config
+	oidcProvider = services.NewOIDCProvider(config, nil)
+	return true, nil
+}
+
+// OIDCLoginHandler は state・nonce・PKCE検証子を生成し、IdPの認可エンドポイントへリダイレクトする
+func OIDCLoginHandler(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	state, loginState, err := services.NewOIDCLoginState()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "ログイン処理の開始に失敗しました"})
+		return
+	}
+
+	if err := services.SaveOIDCLoginState(ctx, state, loginState); err != nil {
+		utils.LogErrorCtx(ctx, "OIDC", err, "Failed to save login state")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "ログイン処理の開始に失敗しました"})
+		return
+	}
+
+	authURL, err := oidcProvider.AuthCodeURL(ctx, state, loginState.Nonce, services.PKCEChallenge(loginState.CodeVerifier))
+	if err != nil {
+		utils.LogErrorCtx(ctx, "OIDC", err, "Failed to build authorization URL")
+		c.JSON(http.StatusBadGateway, gin.H{"error": "認証サーバーに接続できません"})
+		return
+	}
+
+	// state をブラウザにも結び付け、他人が開始したログインを完了させられないようにする
+	setOIDCStateCookie(c, state, int((10 * time.Minute).Seconds()))
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// OIDCCallbackHandler はIdPからのコールバックを処理し、既存ユーザーに紐付けてトークンを発行する
+// 結果はフロントエンドのURLフラグメントで返す（アクセストークンはURLに含めず、csrfToken で /auth/refresh を呼び出す）
+func OIDCCallbackHandler(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	cookieState, _ := c.Cookie(oidcStateCookie)
+	setOIDCStateCookie(c, "", -1)
+
+	if idpError := c.Query("error"); idpError != "" {
+		utils.LogWarningCtx(ctx, "OIDC", "Identity provider returned error: "+idpError)
+		redirectOIDCResult(c, url.Values{"error": {"idp_error"}})
+		return
+	}
+
+	state := c.Query("state")
+	code := c.Query("code")
+	if state == "" || code == "" || state != cookieState {
+		redirectOIDCResult(c, url.Values{"error": {"invalid_state"}})
+		return
+	}
+
+	loginState, err := services.ConsumeOIDCLoginState(ctx, state)
+	if err != nil {
+		redirectOIDCResult(c, url.Values{"error": {"invalid_state"}})
+		return
+	}
+
+	identity, err := oidcProvider.Exchange(ctx, code, loginState.CodeVerifier, loginState.Nonce)
+	if err != nil {
+		utils.LogErrorCtx(ctx, "OIDC", err, "Failed to exchange authorization code")
+		redirectOIDCResult(c, url.Values{"error": {"token_exchange_failed"}})
+		return
+	}
+
+	user, err := linkOIDCUser(ctx, identity)
+	if err != nil {
+		switch err {
+		case errOIDCAccountNotFound:
+			redirectOIDCResult(c, url.Values{"error": {"account_not_found"}})
+		case errOIDCAccountConflict:
+			utils.LogWarningCtx(ctx, "OIDC", "Identity conflicts with linked account: sub="+identity.Subject)
+			redirectOIDCResult(c, url.Values{"error": {"account_conflict"}})
+		case errOIDCAccountPending:
+			redirectOIDCResult(c, url.Values{"error": {"account_pending"}})
+		default:
+			utils.LogErrorCtx(ctx, "OIDC", err, "Failed to link user")
+			redirectOIDCResult(c, url.Values{"error": {"server_error"}})
+		}
+		return
+	}
+
+	if user.Suspended {
+		redirectOIDCResult(c, url.Values{"error": {"account_suspended"}})
+		return
+	}
+
+	_, csrfToken, expiresIn, err := issueTokens(c, *user)
+	if err != nil {
+		utils.LogErrorCtx(ctx, "OIDC", err, "Failed to issue tokens")
+		redirectOIDCResult(c, url.Values{"error": {"server_error"}})
+		return
+	}
+
+	utils.LogInfoCtx(ctx, "OIDC", "User logged in via OIDC: "+user.ID.Hex())
+	redirectOIDCResult(c, url.Values{
+		"csrfToken": {csrfToken},
+		"expiresIn": {strconv.Itoa(expiresIn)},
+	})
+}
+
+// linkOIDCUser はIdPの利用者を既存ユーザーに対応付ける
+// 紐付け済み（iss+sub）→ 学籍番号 → 確認済みメールアドレスの順で探し、初回は iss+sub を保存する
+// 一括登録で招待中（パスワード未設定）のユーザーは紐付けず、招待メールからの登録を完了してもらう
+func linkOIDCUser(ctx context.Context, identity *services.OIDCIdentity) (*User, error) {
+	var user User
+	err := userCollection.FindOne(ctx, bson.M{
+		"oidc_issuer":  identity.Issuer,
+		"oidc_subject": identity.Subject,
+	}).Decode(&user)
+	if err == nil {
+		if user.Status == "pending" {
+			return nil, errOIDCAccountPending
+		}
+		return &user, nil
+	}
+	if err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+
+	var byStudentID, byEmail *User
+	if identity.StudentID != "" {
+		var found User
+		if err := userCollection.FindOne(ctx, bson.M{"student_id": identity.StudentID}).Decode(&found); err == nil {
+			byStudentID = &found
+		} else if err != mongo.ErrNoDocuments {
+			return nil, err
+		}
+	}
+	// 未確認のメールアドレスでは紐付けない（他人のアカウントの乗っ取りを防ぐ）
+	if identity.Email != "" && identity.EmailVerified {
+		var found User
+		if err := userCollection.FindOne(ctx, bson.M{"email": identity.Email}).Decode(&found); err == nil {
+			byEmail = &found
+		} else if err != mongo.ErrNoDocuments {
+			return nil, err
+		}
+	}
+
+	matched, err := matchOIDCUser(byStudentID, byEmail)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	result, err := userCollection.UpdateOne(ctx,
+		bson.M{"_id": matched.ID, "oidc_subject": bson.M{"$exists": false}, "status": bson.M{"$ne": "pending"}},
+		bson.M{"$set": bson.M{
+			"oidc_issuer":  identity.Issuer,
+			"oidc_subject": identity.Subject,
+			"updated_at":   now,
+		}},
+	)
+	if err != nil {
+		return nil, err
+	}
+	if result.MatchedCount == 0 {
+		return nil, errOIDCAccountConflict
+	}
+
+	matched.OIDCIssuer = identity.Issuer
+	matched.OIDCSubject = identity.Subject
+	matched.UpdatedAt = now
+	utils.LogInfoCtx(ctx, "OIDC", "Linked identity provider account to user: "+matched.ID.Hex())
+	return matched, nil
+}
+
+// matchOIDCUser は学籍番号とメールアドレスで見つかったユーザーから紐付け先を決める
+// 学籍番号を優先し、両方で別のユーザーが見つかった場合や紐付け済みの場合は紐付けない
+func matchOIDCUser(byStudentID, byEmail *User) (*User, error) {
+	matched := byStudentID
+	if matched == nil {
+		matched = byEmail
+	}
+	if matched == nil {
+		return nil, errOIDCAccountNotFound
+	}
+	if byStudentID != nil && byEmail != nil && byStudentID.ID != byEmail.ID {
+		return nil, errOIDCAccountConflict
+	}
+	if matched.OIDCSubject != "" {
+		// 既に別のIdPアカウントと紐付いている
+		return nil, errOIDCAccountConflict
+	}
+	if matched.Status == "pending" {
+		return nil, errOIDCAccountPending
+	}
+	return matched, nil
+}
+
+// redirectOIDCResult はログイン結果をURLフラグメントに載せてフロントエンドへリダイレクトする
+func redirectOIDCResult(c *gin.Context, values url.Values) {
+	c.Redirect(http.StatusFound, oidcConfig.FrontendRedirectURL+"#"+values.Encode())
+}
+
+// setOIDCStateCookie はIdPからのトップレベル遷移でも送信されるよう SameSite=Lax で設定する
+func setOIDCStateCookie(c *gin.Context, state string, maxAge int) {
+	c.SetSameSite(http.SameSiteLaxMode)
+	secure := os.Getenv("APP_ENV") != "development" && os.Getenv("APP_ENV") != "test"
+	c.SetCookie(oidcStateCookie, state, maxAge, "/api/auth/oidc", os.Getenv("SESSION_COOKIE_DOMAIN"), secure, true)
+}
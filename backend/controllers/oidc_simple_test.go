@@ -0,0 +1,41 @@
+package controllers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TestMatchOIDCUser は学校IdPの利用者を紐付けるユーザーの決定をテストする
+func TestMatchOIDCUser(t *testing.T) {
+	active := &User{ID: primitive.NewObjectID(), StudentID: "s001", Email: "s001@example.ac.jp"}
+	other := &User{ID: primitive.NewObjectID(), StudentID: "s002", Email: "s002@example.ac.jp"}
+	linked := &User{ID: primitive.NewObjectID(), OIDCSubject: "sub-1"}
+	pending := &User{ID: primitive.NewObjectID(), StudentID: "s003", Status: "pending"}
+
+	tests := []struct {
+		name        string
+		byStudentID *User
+		byEmail     *User
+		expected    *User
+		expectedErr error
+	}{
+		{"学籍番号で一致", active, nil, active, nil},
+		{"メールアドレスで一致", nil, active, active, nil},
+		{"学籍番号とメールアドレスが同じユーザー", active, active, active, nil},
+		{"一致なし", nil, nil, nil, errOIDCAccountNotFound},
+		{"別のユーザーに一致", active, other, nil, errOIDCAccountConflict},
+		{"紐付け済み", linked, nil, nil, errOIDCAccountConflict},
+		{"招待中のユーザー", pending, nil, nil, errOIDCAccountPending},
+		{"招待中のユーザー（メールアドレス）", nil, pending, nil, errOIDCAccountPending},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matched, err := matchOIDCUser(tt.byStudentID, tt.byEmail)
+			assert.Equal(t, tt.expectedErr, err)
+			assert.Equal(t, tt.expected, matched)
+		})
+	}
+}
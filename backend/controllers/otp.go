@@ -5,15 +5,13 @@ import (
 	"crypto/rand"
 	"crypto/sha256"
 	"fmt"
+	"juice_academy_backend/middleware"
 	"juice_academy_backend/services"
 	"math/big"
 	"net/http"
-	"os"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	jwt "github.com/golang-jwt/jwt/v5"
-	"github.com/google/uuid"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -258,6 +256,11 @@ func VerifyOTPHandler(c *gin.Context) {
 	// 目的に応じた処理
 	switch req.Purpose {
 	case "login":
+		if user.Suspended {
+			c.JSON(http.StatusForbidden, gin.H{"error": "アカウントが停止されています"})
+			return
+		}
+
 		accessToken, csrfToken, expiresIn, err := issueTokens(c, user)
 		if err != nil {
 			// トークン生成に失敗した場合、OTPの使用済みマークを取り消す
@@ -336,32 +339,8 @@ func ResendOTPHandler(c *gin.Context) {
 }
 
 // generateAccessToken はユーザー用のJWTトークンを生成
+// クレームの構造は middleware.AccessClaims に集約し、検証側と同じ定義を使用する
 func generateAccessToken(user User) (string, error) {
-	// 環境変数からJWTシークレットを取得
-	secret := os.Getenv("JWT_SECRET")
-	if secret == "" {
-		return "", fmt.Errorf("JWT_SECRET environment variable is not set")
-	}
-	jwtSecret := []byte(secret)
-
-	// 一意のJWT IDを生成
-	jti := uuid.New().String()
-	expiry := time.Now().Add(accessTokenDuration)
-
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"jti":     jti, // JWT ID（一意識別子）
-		"user_id": user.ID.Hex(),
-		"email":   user.Email,
-		"role":    user.Role,
-		"isAdmin": user.IsAdmin,
-		"iat":     time.Now().Unix(), // 発行時刻
-		"exp":     expiry.Unix(),     // 有効期限
-	})
-
-	tokenString, err := token.SignedString(jwtSecret)
-	if err != nil {
-		return "", err
-	}
-
-	return tokenString, nil
+	claims := middleware.NewAccessClaims(user.ID, user.Email, user.Role, user.IsAdmin, accessTokenDuration)
+	return middleware.SignAccessToken(claims)
 }
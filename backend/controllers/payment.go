@@ -3,27 +3,22 @@ package controllers
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"juice_academy_backend/middleware"
 	"juice_academy_backend/services"
 	"juice_academy_backend/utils"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath"
-	"sort"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
 	"github.com/stripe/stripe-go/v81"
-	"github.com/stripe/stripe-go/v81/customer"
-	"github.com/stripe/stripe-go/v81/invoice"
-	"github.com/stripe/stripe-go/v81/paymentmethod"
-	"github.com/stripe/stripe-go/v81/promotioncode"
-	"github.com/stripe/stripe-go/v81/setupintent"
-	subscriptionapi "github.com/stripe/stripe-go/v81/subscription"
 	"github.com/stripe/stripe-go/v81/webhook"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -36,6 +31,9 @@ var (
 	stripeEventCollection  *mongo.Collection
 )
 
+// billing は Stripe への操作に使う決済サービス（テストではメモリ上の実装に差し替える）
+var billing services.BillingProvider
+
 // Payment はMongoDBのpaymentsコレクションのドキュメント構造
 type Payment struct {
 	ID               primitive.ObjectID `bson:"_id,omitempty" json:"id"`
@@ -56,8 +54,23 @@ type Subscription struct {
 	PriceID              string             `bson:"price_id" json:"price_id"`
 	CurrentPeriodEnd     time.Time          `bson:"current_period_end" json:"current_period_end"`
 	CancelAtPeriodEnd    bool               `bson:"cancel_at_period_end" json:"cancel_at_period_end"`
-	CreatedAt            time.Time          `bson:"created_at" json:"created_at"`
-	UpdatedAt            time.Time          `bson:"updated_at" json:"updated_at"`
+	// 予約中のプラン変更（ダウングレードは契約期間の終了時に Stripe の Subscription Schedule で切り替える）
+	ScheduleID        string     `bson:"schedule_id,omitempty" json:"schedule_id,omitempty"`
+	ScheduledPriceID  string     `bson:"scheduled_price_id,omitempty" json:"scheduled_price_id,omitempty"`
+	ScheduledChangeAt *time.Time `bson:"scheduled_change_at,omitempty" json:"scheduled_change_at,omitempty"`
+	// 休止（Stripe の pause_collection で支払いを一時停止している間は利用できない）
+	PausedAt       *time.Time          `bson:"paused_at,omitempty" json:"paused_at,omitempty"`
+	PauseResumesAt *time.Time          `bson:"pause_resumes_at,omitempty" json:"pause_resumes_at,omitempty"`
+	PauseHistory   []SubscriptionPause `bson:"pause_history,omitempty" json:"pause_history,omitempty"`
+	// SuspendedByDisputeID は異議申し立て（チャージバック）の対応中に管理者が停止した場合の Stripe の異議申し立てID
+	// 停止中は利用者自身では再開できない
+	SuspendedByDisputeID string `bson:"suspended_by_dispute_id,omitempty" json:"suspended_by_dispute_id,omitempty"`
+	// TrialEnd はトライアル期間の終了日時（トライアルを付けた場合のみ）
+	TrialEnd *time.Time `bson:"trial_end,omitempty" json:"trial_end,omitempty"`
+	// PastDueAt は支払い遅延になった日時（猶予期間の起点。支払いが回復すると消える）
+	PastDueAt *time.Time `bson:"past_due_at,omitempty" json:"past_due_at,omitempty"`
+	CreatedAt time.Time  `bson:"created_at" json:"created_at"`
+	UpdatedAt time.Time  `bson:"updated_at" json:"updated_at"`
 }
 
 // StripeEvent はWebhook冪等性管理用のドキュメント構造
@@ -89,7 +102,7 @@ func InitPaymentCollection(client *mongo.Client) {
 
 	// Stripe APIキーの設定（秘密鍵を環境変数から取得）
 	// 環境変数名は .env.example / docker-compose と揃える
-	stripe.Key = os.Getenv("STRIPE_SECRET_KEY")
+	billing = services.NewStripeBillingProvider(os.Getenv("STRIPE_SECRET_KEY"))
 }
 
 // InitSubscriptionCollection はサブスクリプションコレクションを初期化
@@ -104,24 +117,16 @@ func InitStripeEventCollection(client *mongo.Client) {
 
 // CreateStripeCustomerHandler はユーザー登録時にStripe顧客を作成するハンドラ
 func CreateStripeCustomerHandler(c *gin.Context) {
-	// JWTなどからユーザーIDを取得（認証ミドルウェア経由で取得する想定）
-	userIDStr := c.GetString("user_id")
-	if userIDStr == "" {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "認証が必要です"})
 		return
 	}
 
-	// ユーザーIDをObjectIDに変換
-	userID, err := primitive.ObjectIDFromHex(userIDStr)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "不正なユーザーIDです"})
-		return
-	}
-
 	// ユーザー情報を取得
 	var user User
 	ctx := c.Request.Context()
-	err = userCollection.FindOne(ctx, bson.M{"_id": userID}).Decode(&user)
+	err := userCollection.FindOne(ctx, bson.M{"_id": userID}).Decode(&user)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "ユーザー情報の取得に失敗しました"})
 		return
@@ -141,17 +146,17 @@ func CreateStripeCustomerHandler(c *gin.Context) {
 
 	// Stripe側で既存の顧客を検索（メールアドレスで検索）
 	var stripeCustomer *stripe.Customer
-	customerListParams := &stripe.CustomerListParams{}
-	customerListParams.Email = stripe.String(user.Email)
-	customerListParams.Limit = stripe.Int64(10) // 複数の顧客が存在する可能性を考慮
-
-	customerIter := customer.List(customerListParams)
+	existingCustomers, err := billing.ListCustomersByEmail(ctx, user.Email)
+	if err != nil {
+		// Stripe APIの一時的なエラーなどを検出
+		utils.LogErrorCtx(c.Request.Context(), "CreateStripeCustomer", err, "Failed to search Stripe customers")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Stripe顧客の検索に失敗しました"})
+		return
+	}
 	foundValidCustomer := false
 
 	// メールアドレスが一致する顧客の中から、このユーザーに紐づいている顧客を探す
-	for customerIter.Next() {
-		existingCustomer := customerIter.Customer()
-
+	for _, existingCustomer := range existingCustomers {
 		// メタデータにuser_idが含まれている場合、それが現在のユーザーと一致するかチェック
 		if metaUserID, exists := existingCustomer.Metadata["user_id"]; exists {
 			if metaUserID == userID.Hex() {
@@ -170,7 +175,7 @@ func CreateStripeCustomerHandler(c *gin.Context) {
 				}
 
 				if updateParams.Name != nil || len(updateParams.Metadata) > 0 {
-					_, err = customer.Update(stripeCustomer.ID, updateParams)
+					_, err = billing.UpdateCustomer(ctx, stripeCustomer.ID, updateParams)
 					if err != nil {
 						utils.LogWarningCtx(c.Request.Context(), "CreateStripeCustomer", "Failed to update customer info: "+err.Error())
 					}
@@ -185,13 +190,6 @@ func CreateStripeCustomerHandler(c *gin.Context) {
 		// メタデータにuser_idがない場合は、古いデータの可能性があるのでスキップ
 	}
 
-	// イテレータのエラーをチェック（Stripe APIの一時的なエラーなどを検出）
-	if err := customerIter.Err(); err != nil {
-		utils.LogErrorCtx(c.Request.Context(), "CreateStripeCustomer", err, "Failed to iterate over Stripe customers")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Stripe顧客の検索に失敗しました"})
-		return
-	}
-
 	if !foundValidCustomer {
 		// 既存の顧客が見つからない場合、新規作成
 		params := &stripe.CustomerParams{
@@ -207,7 +205,7 @@ func CreateStripeCustomerHandler(c *gin.Context) {
 		idempotencyKey := "customer-create:" + userID.Hex()
 		params.SetIdempotencyKey(idempotencyKey)
 
-		stripeCustomer, err = customer.New(params)
+		stripeCustomer, err = billing.CreateCustomer(ctx, params)
 		if err != nil {
 			utils.LogErrorCtx(c.Request.Context(), "CreateStripeCustomer", err, "Failed to create Stripe customer")
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Stripe顧客の作成に失敗しました"})
@@ -273,24 +271,16 @@ func CreateStripeCustomerHandler(c *gin.Context) {
 
 // SetupIntentHandler はカード登録用のSetupIntentを作成するハンドラ
 func SetupIntentHandler(c *gin.Context) {
-	// 認証済みユーザーのIDをJWTから取得
-	userIDStr := c.GetString("user_id")
-	if userIDStr == "" {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "認証が必要です"})
 		return
 	}
 
-	// ユーザーIDをObjectIDに変換
-	userID, err := primitive.ObjectIDFromHex(userIDStr)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "不正なユーザーIDです"})
-		return
-	}
-
 	// 支払い情報を取得
 	var payment Payment
 	ctx := c.Request.Context()
-	err = paymentCollection.FindOne(ctx, bson.M{"user_id": userID}).Decode(&payment)
+	err := paymentCollection.FindOne(ctx, bson.M{"user_id": userID}).Decode(&payment)
 	if err != nil {
 		// 支払い情報が見つからない場合はStripe顧客を作成するよう促す
 		c.JSON(http.StatusNotFound, gin.H{"error": "Stripe顧客情報が見つかりません"})
@@ -305,7 +295,7 @@ func SetupIntentHandler(c *gin.Context) {
 		PaymentMethodTypes: stripe.StringSlice([]string{"card"}),
 	}
 
-	si, err := setupintent.New(params)
+	si, err := billing.CreateSetupIntent(ctx, params)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "SetupIntent作成に失敗しました"})
 		return
@@ -318,9 +308,8 @@ func SetupIntentHandler(c *gin.Context) {
 
 // ConfirmSetupHandler はカード登録の確認と支払い方法の紐付けを行うハンドラ
 func ConfirmSetupHandler(c *gin.Context) {
-	// 認証済みユーザーのIDをJWTから取得
-	userIDStr := c.GetString("user_id")
-	if userIDStr == "" {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "認証が必要です"})
 		return
 	}
@@ -334,17 +323,10 @@ func ConfirmSetupHandler(c *gin.Context) {
 		return
 	}
 
-	// ユーザーIDをObjectIDに変換
-	userID, err := primitive.ObjectIDFromHex(userIDStr)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "不正なユーザーIDです"})
-		return
-	}
-
 	// 支払い情報を取得
 	var payment Payment
 	ctx := c.Request.Context()
-	err = paymentCollection.FindOne(ctx, bson.M{"user_id": userID}).Decode(&payment)
+	err := paymentCollection.FindOne(ctx, bson.M{"user_id": userID}).Decode(&payment)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "支払い情報が見つかりません"})
 		return
@@ -352,10 +334,7 @@ func ConfirmSetupHandler(c *gin.Context) {
 
 	// Stripe上で支払い方法を顧客に紐づけ
 	// Attachが成功しない限り、デフォルト設定には進まない（アトミック性を保証）
-	attachParams := &stripe.PaymentMethodAttachParams{
-		Customer: stripe.String(payment.StripeCustomerID),
-	}
-	_, err = paymentmethod.Attach(req.PaymentMethodID, attachParams)
+	err = billing.AttachPaymentMethod(ctx, req.PaymentMethodID, payment.StripeCustomerID)
 	if err != nil {
 		// 既にアタッチ済みの場合のみ続行を許可
 		errorMsg := strings.ToLower(err.Error())
@@ -376,7 +355,7 @@ func ConfirmSetupHandler(c *gin.Context) {
 	// Attachが成功した（または既にアタッチ済み）場合のみ、デフォルト支払い方法に設定
 	custParams := &stripe.CustomerParams{}
 	custParams.InvoiceSettings = &stripe.CustomerInvoiceSettingsParams{DefaultPaymentMethod: stripe.String(req.PaymentMethodID)}
-	if _, err := customer.Update(payment.StripeCustomerID, custParams); err != nil {
+	if _, err := billing.UpdateCustomer(ctx, payment.StripeCustomerID, custParams); err != nil {
 		utils.LogErrorCtx(c.Request.Context(), "ConfirmSetup", err, "Failed to update customer default payment method")
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "デフォルト支払い方法の設定に失敗しました"})
 		return
@@ -408,41 +387,27 @@ func CreateSubscriptionHandler(c *gin.Context) {
 		return
 	}
 
-	// ユーザーIDはJWTから取得（クライアントからの入力は信用しない）
-	userIDStr := c.GetString("user_id")
-	if userIDStr == "" {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "認証が必要です"})
 		return
 	}
-	userID, err := primitive.ObjectIDFromHex(userIDStr)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "不正なユーザーIDです"})
-		return
-	}
-
-	// 許可された価格IDの検証
-	allowedPriceIDs := []string{
-		os.Getenv("VITE_STRIPE_PRICE_ID_MONTHLY"),
-		os.Getenv("VITE_STRIPE_PRICE_ID_YEARLY"),
-		os.Getenv("VITE_STRIPE_PRICE_ID_2YEARS"),
-	}
 
-	isAllowed := false
-	for _, id := range allowedPriceIDs {
-		if id != "" && req.PriceID == id {
-			isAllowed = true
-			break
+	// 価格IDはプラン一覧で公開中のものに限る
+	ctx := c.Request.Context()
+	plan, err := findPurchasablePlan(ctx, req.PriceID)
+	if err != nil {
+		if errors.Is(err, ErrPlanNotFound) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "無効な価格IDです"})
+			return
 		}
-	}
-
-	if !isAllowed {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "無効な価格IDです"})
+		utils.LogErrorCtx(ctx, "CreateSubscription", err, "Failed to look up plan")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "プランの確認に失敗しました"})
 		return
 	}
 
 	// 既存サブスクリプションを確認
 	var existingSub Subscription
-	ctx := c.Request.Context()
 	err = subscriptionCollection.FindOne(ctx, bson.M{"user_id": userID}).Decode(&existingSub)
 	if err == nil {
 		// アクティブまたは試用期間中のサブスクリプションがある場合
@@ -466,7 +431,7 @@ func CreateSubscriptionHandler(c *gin.Context) {
 			//   状態をクリーンにするためにキャンセル処理を試みる
 			if existingSub.StripeSubscriptionID != "" {
 				// 既にキャンセル済みの場合はエラーになる可能性があるため、エラーログのみ出力して続行
-				_, cancelErr := subscriptionapi.Cancel(existingSub.StripeSubscriptionID, nil)
+				_, cancelErr := billing.CancelSubscription(ctx, existingSub.StripeSubscriptionID)
 				if cancelErr != nil {
 					utils.LogWarningCtx(c.Request.Context(), "CreateSubscription", "Failed to cancel old subscription on Stripe (might already be canceled): "+cancelErr.Error())
 				} else {
@@ -494,17 +459,26 @@ func CreateSubscriptionHandler(c *gin.Context) {
 	}
 
 	// Stripe上の支払い方法確認（最低1件必要）
-	pmList := paymentmethod.List(&stripe.PaymentMethodListParams{Customer: stripe.String(payment.StripeCustomerID), Type: stripe.String("card")})
-	hasPM := pmList.Next()
-
-	// イテレータのエラーをチェック
-	if err := pmList.Err(); err != nil {
+	paymentMethods, err := billing.ListCardPaymentMethods(ctx, payment.StripeCustomerID)
+	if err != nil {
 		utils.LogErrorCtx(c.Request.Context(), "CreateSubscription", err, "Failed to list payment methods")
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "支払い方法の確認に失敗しました"})
 		return
 	}
 
-	if !hasPM {
+	// トライアル期間のあるプランは、初めての利用者に限りトライアルを付ける（カード未登録でも契約できる）
+	var trialClaimID primitive.ObjectID
+	if plan.TrialDays > 0 {
+		trialClaimID, err = claimTrialIfEligible(ctx, userID, payment.StripeCustomerID, plan)
+		if err != nil {
+			utils.LogErrorCtx(c.Request.Context(), "CreateSubscription", err, "Failed to check trial eligibility")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "トライアルの確認に失敗しました"})
+			return
+		}
+	}
+	useTrial := !trialClaimID.IsZero()
+
+	if len(paymentMethods) == 0 && !useTrial {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "登録された支払い方法がありません"})
 		return
 	}
@@ -522,10 +496,19 @@ func CreateSubscriptionHandler(c *gin.Context) {
 
 	// Idempotency key (user + customer + price) for safe retries
 	idempotencyKey := fmt.Sprintf("sub-create:%s:%s:%s", userID.Hex(), payment.StripeCustomerID, req.PriceID)
+	if useTrial {
+		trialSubscriptionParams(sparams, plan.TrialDays)
+		idempotencyKey += ":trial"
+	}
 	sparams.SetIdempotencyKey(idempotencyKey)
 
-	subRes, err := subscriptionapi.New(sparams)
+	subRes, err := billing.CreateSubscription(ctx, sparams)
 	if err != nil {
+		if useTrial {
+			if releaseErr := releaseTrialClaim(ctx, trialClaimID); releaseErr != nil {
+				utils.LogWarningCtx(c.Request.Context(), "CreateSubscription", "Failed to release trial claim: "+releaseErr.Error())
+			}
+		}
 		utils.LogErrorCtx(c.Request.Context(), "CreateSubscription", err, "Failed to create subscription in Stripe")
 		// セキュリティ: 本番環境では詳細エラーメッセージを隠す
 		errMsg := "サブスクリプションの作成に失敗しました"
@@ -580,6 +563,16 @@ func CreateSubscriptionHandler(c *gin.Context) {
 		CreatedAt:            now,
 		UpdatedAt:            now,
 	}
+	if subRes.TrialEnd > 0 {
+		trialEnd := time.Unix(subRes.TrialEnd, 0)
+		newSub.TrialEnd = &trialEnd
+	}
+	if useTrial {
+		_, err := trialRedemptionCollection.UpdateByID(ctx, trialClaimID, bson.M{"$set": bson.M{"stripe_subscription_id": subRes.ID}})
+		if err != nil {
+			utils.LogWarningCtx(c.Request.Context(), "CreateSubscription", "Failed to link trial redemption: "+err.Error())
+		}
+	}
 	if _, err := subscriptionCollection.InsertOne(ctx, newSub); err != nil {
 		utils.LogErrorCtx(c.Request.Context(), "CreateSubscription", err, "Failed to save subscription to database")
 		// セキュリティ: 本番環境では詳細エラーメッセージを隠す
@@ -590,6 +583,7 @@ func CreateSubscriptionHandler(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": errMsg})
 		return
 	}
+	invalidateEntitlements(ctx, userID)
 
 	utils.LogInfoCtx(c.Request.Context(), "CreateSubscription", "Successfully created subscription for user: "+userID.Hex()+" with status: "+string(subRes.Status))
 
@@ -601,6 +595,7 @@ func CreateSubscriptionHandler(c *gin.Context) {
 			"status":               newSub.Status,
 			"current_period_end":   newSub.CurrentPeriodEnd,
 			"cancel_at_period_end": newSub.CancelAtPeriodEnd,
+			"trial_end":            newSub.TrialEnd,
 		},
 		"redirect": "/subscription/success",
 	}
@@ -611,26 +606,24 @@ func CreateSubscriptionHandler(c *gin.Context) {
 }
 
 // PaymentHistoryHandler は決済履歴を取得するハンドラ
-// 重要: Stripeの実際のInvoice（請求書）データから履歴を取得する
+// 履歴は Webhook で保存した Stripe の請求書の写しから、請求日時の新しい順に cursor で1ページずつ返す
 func PaymentHistoryHandler(c *gin.Context) {
-	// JWTなどからユーザーIDを取得（認証ミドルウェア経由で取得する想定）
-	userIDStr := c.GetString("user_id")
-	if userIDStr == "" {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "認証が必要です"})
 		return
 	}
 
-	// ユーザーIDをObjectIDに変換
-	userID, err := primitive.ObjectIDFromHex(userIDStr)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "不正なユーザーIDです"})
+	params, errMsg := parsePaymentHistoryParams(c)
+	if errMsg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errMsg})
 		return
 	}
 
 	// 支払い情報を取得
 	var payment Payment
 	ctx := c.Request.Context()
-	err = paymentCollection.FindOne(ctx, bson.M{"user_id": userID}).Decode(&payment)
+	err := paymentCollection.FindOne(ctx, bson.M{"user_id": userID}).Decode(&payment)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "支払い情報が見つかりません"})
 		return
@@ -640,165 +633,85 @@ func PaymentHistoryHandler(c *gin.Context) {
 	if payment.StripeCustomerID == "" {
 		c.JSON(http.StatusOK, gin.H{
 			"payment_history": []gin.H{},
+			"total":           0,
 		})
 		return
 	}
 
-	// 決済履歴の配列
-	var paymentHistory []gin.H
-
 	// =================================================================
-	// Stripeの実際のInvoice（請求書）を取得して表示
-	// これが実際に課金された/課金予定の正確なデータ
+	// 請求書は Webhook で保存した invoices コレクションから取得する
+	// （導入前の履歴は scripts/backfill_invoices で取り込む）
 	// =================================================================
-	invoiceParams := &stripe.InvoiceListParams{
-		Customer: stripe.String(payment.StripeCustomerID),
-	}
-	invoiceParams.Limit = stripe.Int64(100) // 最大100件取得
-
-	invoiceIter := invoice.List(invoiceParams)
-	for invoiceIter.Next() {
-		inv := invoiceIter.Invoice()
-
-		// Invoice のステータスを判定
-		var status string
-		switch inv.Status {
-		case stripe.InvoiceStatusPaid:
-			status = "success"
-		case stripe.InvoiceStatusOpen:
-			status = "pending"
-		case stripe.InvoiceStatusDraft:
-			status = "draft"
-		case stripe.InvoiceStatusUncollectible:
-			status = "failed"
-		case stripe.InvoiceStatusVoid:
-			status = "voided"
-		default:
-			status = string(inv.Status)
-		}
-
-		// 金額（Stripeは最小単位で保存しているため、JPYの場合はそのまま）
-		amount := inv.AmountPaid
-		if amount == 0 {
-			amount = inv.AmountDue
-		}
-
-		// 請求書の説明を生成
-		description := "juice学園 月額サブスクリプション"
-		if inv.Description != "" {
-			description = inv.Description
-		} else if len(inv.Lines.Data) > 0 && inv.Lines.Data[0].Description != "" {
-			description = inv.Lines.Data[0].Description
-		}
-
-		// 請求日の取得（Created または PeriodStart）
-		var createdAt time.Time
-		if inv.Created > 0 {
-			createdAt = time.Unix(inv.Created, 0)
-		} else {
-			createdAt = time.Now()
-		}
-
-		paymentHistory = append(paymentHistory, gin.H{
-			"id":          inv.ID,
-			"amount":      amount,
-			"status":      status,
-			"type":        "subscription",
-			"created_at":  createdAt,
-			"description": description,
-			// 追加情報（デバッグ/確認用）
-			"stripe_status":  string(inv.Status),
-			"invoice_number": inv.Number,
-		})
-	}
-
-	// イテレータのエラーをチェック
-	if err := invoiceIter.Err(); err != nil {
-		utils.LogErrorCtx(c.Request.Context(), "PaymentHistory", err, "Failed to fetch invoices from Stripe")
+	page, err := listInvoicePage(ctx, payment.StripeCustomerID, params)
+	if err != nil {
+		utils.LogErrorCtx(c.Request.Context(), "PaymentHistory", err, "Failed to fetch invoices")
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "決済履歴の取得に失敗しました"})
 		return
 	}
+	// 請求の説明にはプラン名を使う（取得に失敗しても履歴は表示する）
+	planNames, err := planNamesByPriceID(ctx)
+	if err != nil {
+		utils.LogWarningCtx(c.Request.Context(), "PaymentHistory", "Failed to fetch plan names: "+err.Error())
+	}
+
+	paymentHistory := make([]gin.H, 0, len(page.Invoices)+1)
 
 	// =================================================================
-	// 次回請求予定の取得（キャンセルされていないサブスクリプションの場合）
-	// Stripe API から実際の次回請求額を取得
+	// 次回請求予定（最初のページで、期間の終わりを指定していない場合のみ）
+	// 金額は割引などを反映するため Stripe から取得する
 	// =================================================================
-	var subscription Subscription
-	err = subscriptionCollection.FindOne(ctx, bson.M{"user_id": userID}).Decode(&subscription)
-	if err == nil && (subscription.Status == "active" || subscription.Status == "trialing") && !subscription.CancelAtPeriodEnd {
-		// Stripeから最新のサブスクリプション情報を取得して確認
-		if subscription.StripeSubscriptionID != "" {
-			stripeSub, stripeErr := subscriptionapi.Get(subscription.StripeSubscriptionID, nil)
-			if stripeErr == nil && !stripeSub.CancelAtPeriodEnd && stripeSub.Status == stripe.SubscriptionStatusActive {
-				// Stripe API から次回請求額を取得
-				upcomingParams := &stripe.InvoiceUpcomingParams{
-					Customer:     stripe.String(payment.StripeCustomerID),
-					Subscription: stripe.String(subscription.StripeSubscriptionID),
-				}
-				upcomingInv, upcomingErr := invoice.Upcoming(upcomingParams)
-
-				if upcomingErr == nil {
-					// 実際の次回請求額を使用
-					paymentHistory = append(paymentHistory, gin.H{
-						"id":          "upcoming",
-						"amount":      upcomingInv.AmountDue,
-						"status":      "upcoming",
-						"type":        "subscription",
-						"created_at":  time.Unix(stripeSub.CurrentPeriodEnd, 0),
-						"description": "juice学園 月額サブスクリプション（次回請求予定）",
-					})
-				} else {
-					// APIエラー時は警告ログを出力（次回請求予定はスキップ）
-					utils.LogWarningCtx(c.Request.Context(), "PaymentHistory",
-						"Failed to fetch upcoming invoice: "+upcomingErr.Error())
-				}
+	if params.Cursor == nil && params.To == nil {
+		var subscription Subscription
+		err = subscriptionCollection.FindOne(ctx, bson.M{"user_id": userID}).Decode(&subscription)
+		if err == nil && subscription.Status == "active" && !subscription.CancelAtPeriodEnd &&
+			subscription.PausedAt == nil && subscription.StripeSubscriptionID != "" {
+			upcomingParams := &stripe.InvoiceUpcomingParams{
+				Customer:     stripe.String(payment.StripeCustomerID),
+				Subscription: stripe.String(subscription.StripeSubscriptionID),
+			}
+			upcomingInv, upcomingErr := billing.UpcomingInvoice(ctx, upcomingParams)
+			if upcomingErr == nil {
+				paymentHistory = append(paymentHistory, gin.H{
+					"id":          "upcoming",
+					"amount":      upcomingInv.AmountDue,
+					"tax":         upcomingInv.Tax,
+					"status":      "upcoming",
+					"type":        "subscription",
+					"created_at":  subscription.CurrentPeriodEnd,
+					"description": planInvoiceDescription(planNames[subscription.PriceID]) + "（次回請求予定）",
+				})
+			} else {
+				// APIエラー時は警告ログを出力（次回請求予定はスキップ）
+				utils.LogWarningCtx(c.Request.Context(), "PaymentHistory",
+					"Failed to fetch upcoming invoice: "+upcomingErr.Error())
 			}
 		}
 	}
 
-	// 決済履歴がない場合
-	if len(paymentHistory) == 0 {
-		c.JSON(http.StatusOK, gin.H{
-			"payment_history": []gin.H{},
-		})
-		return
+	for _, inv := range page.Invoices {
+		paymentHistory = append(paymentHistory, paymentHistoryEntry(inv, planNames))
 	}
 
-	// 日付の降順でソート
-	sort.Slice(paymentHistory, func(i, j int) bool {
-		dateI, okI := paymentHistory[i]["created_at"].(time.Time)
-		dateJ, okJ := paymentHistory[j]["created_at"].(time.Time)
-		if !okI || !okJ {
-			return false
-		}
-		return dateI.After(dateJ)
-	})
-
 	c.JSON(http.StatusOK, gin.H{
-		"payment_history": paymentHistory,
+		"payment_history":             paymentHistory,
+		"total":                       page.Total,
+		"next_cursor":                 page.NextCursor,
+		"qualified_invoice_available": qualifiedInvoiceAvailable(),
 	})
 }
 
 // GetPaymentMethodsHandler は支払い方法一覧を取得するハンドラ
 func GetPaymentMethodsHandler(c *gin.Context) {
-	// JWTなどからユーザーIDを取得（認証ミドルウェア経由で取得する想定）
-	userIDStr := c.GetString("user_id")
-	if userIDStr == "" {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "認証が必要です"})
 		return
 	}
 
-	// ユーザーIDをObjectIDに変換
-	userID, err := primitive.ObjectIDFromHex(userIDStr)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "不正なユーザーIDです"})
-		return
-	}
-
 	// 支払い情報を取得
 	var payment Payment
 	ctx := c.Request.Context()
-	err = paymentCollection.FindOne(ctx, bson.M{"user_id": userID}).Decode(&payment)
+	err := paymentCollection.FindOne(ctx, bson.M{"user_id": userID}).Decode(&payment)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "支払い情報が見つかりません"})
 		return
@@ -811,18 +724,16 @@ func GetPaymentMethodsHandler(c *gin.Context) {
 	}
 
 	// Stripeから支払い方法を取得
-	params := &stripe.PaymentMethodListParams{
-		Customer: stripe.String(payment.StripeCustomerID),
-		Type:     stripe.String("card"),
+	cards, err := billing.ListCardPaymentMethods(ctx, payment.StripeCustomerID)
+	if err != nil {
+		utils.LogErrorCtx(c.Request.Context(), "GetPaymentMethods", err, "Failed to list payment methods")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "支払い方法の取得に失敗しました"})
+		return
 	}
 
 	// 支払い方法一覧を取得
 	paymentMethods := []gin.H{}
-
-	i := paymentmethod.List(params)
-	for i.Next() {
-		pm := i.PaymentMethod()
-
+	for _, pm := range cards {
 		// カード情報を整形
 		paymentMethod := gin.H{
 			"id": pm.ID,
@@ -838,32 +749,17 @@ func GetPaymentMethodsHandler(c *gin.Context) {
 		paymentMethods = append(paymentMethods, paymentMethod)
 	}
 
-	// イテレータのエラーをチェック
-	if err := i.Err(); err != nil {
-		utils.LogErrorCtx(c.Request.Context(), "GetPaymentMethods", err, "Failed to iterate over payment methods")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "支払い方法の取得に失敗しました"})
-		return
-	}
-
 	c.JSON(http.StatusOK, gin.H{"paymentMethods": paymentMethods})
 }
 
 // DeletePaymentMethodHandler は支払い方法を削除するハンドラ
 func DeletePaymentMethodHandler(c *gin.Context) {
-	// JWTなどからユーザーIDを取得（認証ミドルウェア経由で取得する想定）
-	userIDStr := c.GetString("user_id")
-	if userIDStr == "" {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "認証が必要です"})
 		return
 	}
 
-	// ユーザーIDをObjectIDに変換
-	userID, err := primitive.ObjectIDFromHex(userIDStr)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "不正なユーザーIDです"})
-		return
-	}
-
 	// 支払い方法IDをパスパラメータから取得
 	paymentMethodID := c.Param("id")
 	if paymentMethodID == "" {
@@ -874,7 +770,7 @@ func DeletePaymentMethodHandler(c *gin.Context) {
 	// 支払い情報を取得
 	var payment Payment
 	ctx := c.Request.Context()
-	err = paymentCollection.FindOne(ctx, bson.M{"user_id": userID}).Decode(&payment)
+	err := paymentCollection.FindOne(ctx, bson.M{"user_id": userID}).Decode(&payment)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "支払い情報が見つかりません"})
 		return
@@ -887,22 +783,19 @@ func DeletePaymentMethodHandler(c *gin.Context) {
 	}
 
 	// Stripeから支払い方法を取得
-	params := &stripe.PaymentMethodListParams{
-		Customer: stripe.String(payment.StripeCustomerID),
-		Type:     stripe.String("card"),
+	cards, err := billing.ListCardPaymentMethods(ctx, payment.StripeCustomerID)
+	if err != nil {
+		utils.LogErrorCtx(c.Request.Context(), "DeletePaymentMethod", err, "Failed to list payment methods")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "支払い方法の検索に失敗しました"})
+		return
 	}
 
-	// 支払い方法一覧を取得
+	// 顧客に紐づいている支払い方法のみ削除できる
 	found := false
-
-	i := paymentmethod.List(params)
-	for i.Next() {
-		pm := i.PaymentMethod()
+	for _, pm := range cards {
 		if pm.ID == paymentMethodID {
 			// 支払い方法をデタッチ（削除）
-			detachParams := &stripe.PaymentMethodDetachParams{}
-			_, err := paymentmethod.Detach(paymentMethodID, detachParams)
-			if err != nil {
+			if err := billing.DetachPaymentMethod(ctx, paymentMethodID); err != nil {
 				// セキュリティ: 本番環境では詳細エラーメッセージを隠す
 				errMsg := "支払い方法の削除に失敗しました"
 				if os.Getenv("APP_ENV") != "production" {
@@ -916,13 +809,6 @@ func DeletePaymentMethodHandler(c *gin.Context) {
 		}
 	}
 
-	// イテレータのエラーをチェック
-	if err := i.Err(); err != nil {
-		utils.LogErrorCtx(c.Request.Context(), "DeletePaymentMethod", err, "Failed to iterate over payment methods")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "支払い方法の検索に失敗しました"})
-		return
-	}
-
 	if !found {
 		c.JSON(http.StatusNotFound, gin.H{"error": "指定された支払い方法が見つかりません"})
 		return
@@ -930,23 +816,15 @@ func DeletePaymentMethodHandler(c *gin.Context) {
 
 	// 支払い方法が削除されたら、ユーザーの支払い方法フラグを更新
 	// 残りの支払い方法があるかチェック
-	remainingParams := &stripe.PaymentMethodListParams{
-		Customer: stripe.String(payment.StripeCustomerID),
-		Type:     stripe.String("card"),
-	}
-
-	remainingIter := paymentmethod.List(remainingParams)
-	hasRemainingPaymentMethods := remainingIter.Next()
-
-	// イテレータのエラーをチェック
-	if err := remainingIter.Err(); err != nil {
+	remaining, err := billing.ListCardPaymentMethods(ctx, payment.StripeCustomerID)
+	if err != nil {
 		utils.LogErrorCtx(c.Request.Context(), "DeletePaymentMethod", err, "Failed to check remaining payment methods")
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "支払い方法の確認に失敗しました"})
 		return
 	}
 
 	// 支払い方法がなくなった場合のみフラグを更新
-	if !hasRemainingPaymentMethods {
+	if len(remaining) == 0 {
 		update := bson.M{
 			"$set": bson.M{
 				"has_payment_method": false,
@@ -1057,17 +935,29 @@ func processWebhookEventSync(event stripe.Event, correlationID string) {
 	case "customer.subscription.trial_will_end":
 		processTrialWillEnd(ctx, event)
 	case "invoice.paid":
+		processInvoiceLedger(ctx, event)
 		processInvoicePaid(ctx, event)
 	case "invoice.payment_failed":
+		processInvoiceLedger(ctx, event)
 		processInvoicePaymentFailed(ctx, event)
+	case "invoice.created", "invoice.finalized", "invoice.updated", "invoice.payment_succeeded",
+		"invoice.voided", "invoice.marked_uncollectible":
+		processInvoiceLedger(ctx, event)
 	case "invoice.upcoming":
 		processInvoiceUpcoming(ctx, event)
 	case "payment_intent.succeeded":
 		processPaymentIntentSucceeded(ctx, event)
 	case "payment_intent.payment_failed":
 		processPaymentIntentFailed(ctx, event)
-	case "charge.dispute.created":
-		processDisputeCreated(ctx, event)
+	case "charge.refunded":
+		processChargeRefunded(ctx, event)
+	case "refund.created", "refund.updated":
+		processRefundLedger(ctx, event)
+	case "credit_note.created", "credit_note.updated", "credit_note.voided":
+		processCreditNoteLedger(ctx, event)
+	case "charge.dispute.created", "charge.dispute.updated", "charge.dispute.closed",
+		"charge.dispute.funds_withdrawn", "charge.dispute.funds_reinstated":
+		processDispute(ctx, event)
 	default:
 		utils.LogInfoCtx(ctx, "StripeWebhook", fmt.Sprintf("Unhandled event type: %s", event.Type))
 	}
@@ -1124,7 +1014,9 @@ func processCheckoutSessionCompleted(ctx context.Context, event stripe.Event) {
 
 	if err != nil {
 		utils.LogErrorCtx(ctx, "StripeWebhook", err, "Failed to save subscription info")
+		return
 	}
+	services.PublishSubscriptionChange(ctx, subscriptionCollection, bson.M{"user_id": userID})
 }
 
 // processSubscriptionUpdated はcustomer.subscription.updatedを処理
@@ -1135,18 +1027,11 @@ func processSubscriptionUpdated(ctx context.Context, event stripe.Event) {
 		return
 	}
 
-	update := bson.M{
-		"$set": bson.M{
-			"status":               string(sub.Status),
-			"current_period_end":   time.Unix(sub.CurrentPeriodEnd, 0),
-			"cancel_at_period_end": sub.CancelAtPeriodEnd,
-			"updated_at":           time.Now(),
-		},
-	}
-	_, err := subscriptionCollection.UpdateOne(ctx, bson.M{"stripe_subscription_id": sub.ID}, update)
-	if err != nil {
+	if err := services.ApplyStripeSubscriptionUpdate(ctx, subscriptionCollection, &sub); err != nil {
 		utils.LogErrorCtx(ctx, "StripeWebhook", err, "Failed to update subscription")
+		return
 	}
+	services.PublishSubscriptionChange(ctx, subscriptionCollection, bson.M{"stripe_subscription_id": sub.ID})
 }
 
 // processSubscriptionDeleted はcustomer.subscription.deletedを処理
@@ -1169,7 +1054,9 @@ func processSubscriptionDeleted(ctx context.Context, event stripe.Event) {
 	_, err := subscriptionCollection.UpdateOne(ctx, bson.M{"stripe_subscription_id": sub.ID}, update)
 	if err != nil {
 		utils.LogErrorCtx(ctx, "StripeWebhook", err, "Failed to update subscription status")
+		return
 	}
+	services.PublishSubscriptionChange(ctx, subscriptionCollection, bson.M{"stripe_subscription_id": sub.ID})
 }
 
 // processInvoicePaid はinvoice.paidを処理
@@ -1211,16 +1098,11 @@ func processInvoicePaymentFailed(ctx context.Context, event stripe.Event) {
 		return
 	}
 
-	update := bson.M{
-		"$set": bson.M{
-			"status":     "past_due",
-			"updated_at": time.Now(),
-		},
-	}
-	_, err := subscriptionCollection.UpdateOne(ctx, bson.M{"stripe_subscription_id": inv.Subscription.ID}, update)
-	if err != nil {
+	if err := services.MarkSubscriptionPastDue(ctx, subscriptionCollection, inv.Subscription.ID); err != nil {
 		utils.LogErrorCtx(ctx, "StripeWebhook", err, "Failed to update subscription status after payment failure")
+		return
 	}
+	services.PublishSubscriptionChange(ctx, subscriptionCollection, bson.M{"stripe_subscription_id": inv.Subscription.ID})
 }
 
 // processInvoiceUpcoming はinvoice.upcomingを処理
@@ -1294,42 +1176,19 @@ func processPaymentIntentFailed(ctx context.Context, event stripe.Event) {
 			utils.MaskStripeID(pi.ID), pi.Amount, errorMsg))
 }
 
-// processDisputeCreated はcharge.dispute.createdを処理
-func processDisputeCreated(ctx context.Context, event stripe.Event) {
-	var dispute stripe.Dispute
-	if err := json.Unmarshal(event.Data.Raw, &dispute); err != nil {
-		utils.LogErrorCtx(ctx, "StripeWebhook", err, "Failed to parse dispute data")
-		return
-	}
-
-	utils.LogErrorCtx(ctx, "StripeWebhook", nil,
-		fmt.Sprintf("ALERT: Dispute created! ID: %s, Amount: %d, Reason: %s",
-			utils.MaskStripeID(dispute.ID), dispute.Amount, dispute.Reason))
-
-	// TODO: 管理者にアラート通知を送信
-}
-
 // CancelSubscriptionHandler はサブスクリプションをキャンセルするハンドラ
 // 重要: キャンセル処理は二重確認を行い、確実に実行される
 func CancelSubscriptionHandler(c *gin.Context) {
-	// JWTなどからユーザーIDを取得（認証ミドルウェア経由で取得する想定）
-	userIDStr := c.GetString("user_id")
-	if userIDStr == "" {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "認証が必要です"})
 		return
 	}
 
-	// ユーザーIDをObjectIDに変換
-	userID, err := primitive.ObjectIDFromHex(userIDStr)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "不正なユーザーIDです"})
-		return
-	}
-
 	// サブスクリプション情報を取得
 	var sub Subscription
 	ctx := c.Request.Context()
-	err = subscriptionCollection.FindOne(ctx, bson.M{"user_id": userID}).Decode(&sub)
+	err := subscriptionCollection.FindOne(ctx, bson.M{"user_id": userID}).Decode(&sub)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "サブスクリプション情報が見つかりません"})
 		return
@@ -1342,6 +1201,15 @@ func CancelSubscriptionHandler(c *gin.Context) {
 		return
 	}
 
+	// 予約中のプラン変更があると解約予約を設定できないため、先に予約を取り消す
+	if sub.ScheduleID != "" {
+		if err := releasePlanChangeSchedule(ctx, sub); err != nil {
+			utils.LogErrorCtx(c.Request.Context(), "CancelSubscription", err, "Failed to release subscription schedule")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "予約中のプラン変更の取り消しに失敗しました"})
+			return
+		}
+	}
+
 	// =================================================================
 	// ステップ1: Stripeでキャンセルを設定
 	// =================================================================
@@ -1355,7 +1223,7 @@ func CancelSubscriptionHandler(c *gin.Context) {
 		fmt.Sprintf("Initiating cancellation for subscription: %s, user: %s", sub.StripeSubscriptionID, userID.Hex()))
 
 	// Stripe APIを呼び出してキャンセルを設定
-	updatedSub, err := subscriptionapi.Update(sub.StripeSubscriptionID, params)
+	updatedSub, err := billing.UpdateSubscription(ctx, sub.StripeSubscriptionID, params)
 	if err != nil {
 		utils.LogErrorCtx(c.Request.Context(), "CancelSubscription", err, "Failed to cancel subscription in Stripe")
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "サブスクリプションのキャンセルに失敗しました"})
@@ -1371,8 +1239,7 @@ func CancelSubscriptionHandler(c *gin.Context) {
 			"Cancel flag not set after update, retrying...")
 
 		// 再度取得して確認
-		verifyParams := &stripe.SubscriptionParams{}
-		verifySub, verifyErr := subscriptionapi.Get(sub.StripeSubscriptionID, verifyParams)
+		verifySub, verifyErr := billing.GetSubscription(ctx, sub.StripeSubscriptionID)
 		if verifyErr != nil {
 			utils.LogErrorCtx(c.Request.Context(), "CancelSubscription", verifyErr, "Failed to verify cancellation status")
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "キャンセル状態の確認に失敗しました"})
@@ -1408,6 +1275,7 @@ func CancelSubscriptionHandler(c *gin.Context) {
 		utils.LogErrorCtx(c.Request.Context(), "CancelSubscription", err,
 			"Failed to update subscription in DB, but Stripe cancellation was successful")
 	}
+	invalidateEntitlements(ctx, userID)
 
 	// =================================================================
 	// ステップ4: 成功をログに記録
@@ -1430,28 +1298,21 @@ func CancelSubscriptionHandler(c *gin.Context) {
 // GetSubscriptionStatusHandler はサブスクリプションの状態を取得するハンドラ
 // 重要: 常にStripeの最新状態を取得して、MongoDBと同期する
 func GetSubscriptionStatusHandler(c *gin.Context) {
-	// JWTなどからユーザーIDを取得（認証ミドルウェア経由で取得する想定）
-	userIDStr := c.GetString("user_id")
-	if userIDStr == "" {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "認証が必要です"})
 		return
 	}
 
-	// ユーザーIDをObjectIDに変換
-	userID, err := primitive.ObjectIDFromHex(userIDStr)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "不正なユーザーIDです"})
-		return
-	}
-
 	// サブスクリプション情報を取得
 	var sub Subscription
 	ctx := c.Request.Context()
-	err = subscriptionCollection.FindOne(ctx, bson.M{"user_id": userID}).Decode(&sub)
+	err := subscriptionCollection.FindOne(ctx, bson.M{"user_id": userID}).Decode(&sub)
 	if err != nil {
 		// サブスクリプションが見つからない場合は、hasActiveSubscription: false を返す
 		c.JSON(http.StatusOK, gin.H{
 			"hasActiveSubscription": false,
+			"trialEligible":         userTrialEligible(ctx, userID),
 			"subscription":          nil,
 		})
 		return
@@ -1462,7 +1323,7 @@ func GetSubscriptionStatusHandler(c *gin.Context) {
 	// これにより、Webhookの遅延や欠落があっても正確な状態を表示できる
 	// =================================================================
 	if sub.StripeSubscriptionID != "" {
-		stripeSub, stripeErr := subscriptionapi.Get(sub.StripeSubscriptionID, nil)
+		stripeSub, stripeErr := billing.GetSubscription(ctx, sub.StripeSubscriptionID)
 		if stripeErr != nil {
 			// Stripeからの取得に失敗した場合
 			// サブスクリプションが存在しない（削除された）場合
@@ -1477,6 +1338,7 @@ func GetSubscriptionStatusHandler(c *gin.Context) {
 					},
 				}
 				_, _ = subscriptionCollection.UpdateOne(ctx, bson.M{"user_id": userID}, update)
+				invalidateEntitlements(ctx, userID)
 
 				c.JSON(http.StatusOK, gin.H{
 					"hasActiveSubscription": false,
@@ -1518,21 +1380,19 @@ func GetSubscriptionStatusHandler(c *gin.Context) {
 				needsUpdate = true
 			}
 
-			// MongoDBを更新
+			// 休止状態が異なる場合も同期する
+			if (stripeSub.PauseCollection != nil) != (sub.PausedAt != nil) {
+				needsUpdate = true
+			}
+
+			// MongoDBを更新（Webhook と同じ処理で、休止・支払い遅延の日時もあわせて反映する）
 			if needsUpdate {
-				update := bson.M{
-					"$set": bson.M{
-						"status":               sub.Status,
-						"current_period_end":   sub.CurrentPeriodEnd,
-						"cancel_at_period_end": sub.CancelAtPeriodEnd,
-						"updated_at":           time.Now(),
-					},
-				}
-				_, updateErr := subscriptionCollection.UpdateOne(ctx, bson.M{"user_id": userID}, update)
-				if updateErr != nil {
+				if updateErr := services.ApplyStripeSubscriptionUpdate(ctx, subscriptionCollection, stripeSub); updateErr != nil {
 					utils.LogWarningCtx(c.Request.Context(), "GetSubscriptionStatus", "Failed to sync subscription to DB: "+updateErr.Error())
 				} else {
 					utils.LogInfoCtx(c.Request.Context(), "GetSubscriptionStatus", "Successfully synced subscription status from Stripe")
+					_ = subscriptionCollection.FindOne(ctx, bson.M{"user_id": userID}).Decode(&sub)
+					invalidateEntitlements(ctx, userID)
 				}
 			}
 
@@ -1553,31 +1413,57 @@ func GetSubscriptionStatusHandler(c *gin.Context) {
 	// =================================================================
 	// サブスクリプションがアクティブかどうかの判定
 	// =================================================================
-	hasActiveSubscription := sub.Status == "active" || sub.Status == "trialing"
-
-	// キャンセル予約済みで期間終了後の場合は非アクティブ
-	if sub.CancelAtPeriodEnd && time.Now().After(sub.CurrentPeriodEnd) {
-		hasActiveSubscription = false
-	}
+	hasActiveSubscription := subscriptionGrantsAccess(sub, time.Now())
 
 	// サブスクリプション情報を返す（JSONタグに合わせてsnake_caseを使用）
 	c.JSON(http.StatusOK, gin.H{
 		"hasActiveSubscription": hasActiveSubscription,
+		"trialEligible":         userTrialEligible(ctx, userID),
 		"subscription": gin.H{
 			"id":                   sub.StripeSubscriptionID,
 			"status":               sub.Status,
 			"price_id":             sub.PriceID,
 			"current_period_end":   sub.CurrentPeriodEnd,
 			"cancel_at_period_end": sub.CancelAtPeriodEnd,
+			"scheduled_price_id":   sub.ScheduledPriceID,
+			"scheduled_change_at":  sub.ScheduledChangeAt,
+			"paused":               sub.PausedAt != nil,
+			"paused_at":            sub.PausedAt,
+			"pause_resumes_at":     sub.PauseResumesAt,
+			"suspended":            sub.SuspendedByDisputeID != "",
+			"pause_days_remaining": int(subscriptionPauseAllowance(sub.PauseHistory, time.Now()) / (24 * time.Hour)),
+			"is_trial":             sub.Status == "trialing",
+			"trial_end":            sub.TrialEnd,
+			"grace_until":          subscriptionGraceUntil(sub),
 		},
 	})
 }
 
+// subscriptionGrantsAccess はサブスクリプションでサービスを利用できるかどうかを返す
+// 休止中や、解約予約済みで期間が終了している場合は利用できない
+// 支払い遅延中は猶予期間（subscriptionGracePeriod）の間だけ利用できる
+func subscriptionGrantsAccess(sub Subscription, now time.Time) bool {
+	if sub.Status == "past_due" {
+		graceUntil := subscriptionGraceUntil(sub)
+		return graceUntil != nil && now.Before(*graceUntil)
+	}
+	if sub.Status != "active" && sub.Status != "trialing" {
+		return false
+	}
+	if sub.PausedAt != nil {
+		return false
+	}
+	if sub.CancelAtPeriodEnd && now.After(sub.CurrentPeriodEnd) {
+		return false
+	}
+	return true
+}
+
 // SyncStripeSubscriptionsHandler はStripe側のサブスクリプション状態をMongoDBに同期する（管理者専用）
 // handleSubscriptionResumeOrUpdate は解約予約中のサブスクリプションを再開または変更する
 func handleSubscriptionResumeOrUpdate(c *gin.Context, ctx context.Context, sub Subscription, newPriceID string) {
 	// Stripeから最新のサブスクリプション情報を取得
-	stripeSub, err := subscriptionapi.Get(sub.StripeSubscriptionID, nil)
+	stripeSub, err := billing.GetSubscription(ctx, sub.StripeSubscriptionID)
 	if err != nil {
 		utils.LogErrorCtx(c.Request.Context(), "ResumeSubscription", err, "Failed to fetch subscription from Stripe")
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "サブスクリプション情報の取得に失敗しました"})
@@ -1601,7 +1487,7 @@ func handleSubscriptionResumeOrUpdate(c *gin.Context, ctx context.Context, sub S
 		}
 	}
 
-	updatedSub, err := subscriptionapi.Update(sub.StripeSubscriptionID, params)
+	updatedSub, err := billing.UpdateSubscription(ctx, sub.StripeSubscriptionID, params)
 	if err != nil {
 		utils.LogErrorCtx(c.Request.Context(), "ResumeSubscription", err, "Failed to update subscription in Stripe")
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "サブスクリプションの更新に失敗しました"})
@@ -1623,6 +1509,7 @@ func handleSubscriptionResumeOrUpdate(c *gin.Context, ctx context.Context, sub S
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "データベースの更新に失敗しました"})
 		return
 	}
+	invalidateEntitlements(ctx, sub.UserID)
 
 	c.JSON(http.StatusOK, gin.H{
 		"message": "サブスクリプションが正常に更新されました",
@@ -1660,15 +1547,17 @@ func SyncStripeSubscriptionsHandler(c *gin.Context) {
 		if doc.StripeSubscriptionID == "" {
 			// 不整合データは削除
 			_, _ = subscriptionCollection.DeleteOne(ctx, bson.M{"_id": doc.ID})
+			invalidateEntitlements(ctx, doc.UserID)
 			removed++
 			continue
 		}
 
-		stripeSub, err := subscriptionapi.Get(doc.StripeSubscriptionID, nil)
+		stripeSub, err := billing.GetSubscription(ctx, doc.StripeSubscriptionID)
 		if err != nil {
 			utils.LogErrorCtx(c.Request.Context(), "SyncStripeSubscriptions", err, "Failed to fetch subscription from Stripe", doc.StripeSubscriptionID)
 			if apiErr, ok := err.(*stripe.Error); ok && apiErr.Code == stripe.ErrorCodeResourceMissing {
 				_, _ = subscriptionCollection.DeleteOne(ctx, bson.M{"_id": doc.ID})
+				invalidateEntitlements(ctx, doc.UserID)
 				removed++
 			}
 			continue
@@ -1693,6 +1582,7 @@ func SyncStripeSubscriptionsHandler(c *gin.Context) {
 			utils.LogErrorCtx(c.Request.Context(), "SyncStripeSubscriptions", err, "Failed to update subscription document")
 			continue
 		}
+		invalidateEntitlements(ctx, doc.UserID)
 
 		synced++
 	}
@@ -1719,23 +1609,16 @@ func ApplyPromotionCodeHandler(c *gin.Context) {
 		return
 	}
 
-	// ユーザーIDの取得
-	userIDStr := c.GetString("user_id")
-	if userIDStr == "" {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "認証が必要です"})
 		return
 	}
 
-	userID, err := primitive.ObjectIDFromHex(userIDStr)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "不正なユーザーIDです"})
-		return
-	}
-
 	// サブスクリプション情報をDBから取得
 	var sub Subscription
 	ctx := c.Request.Context()
-	err = subscriptionCollection.FindOne(ctx, bson.M{"user_id": userID}).Decode(&sub)
+	err := subscriptionCollection.FindOne(ctx, bson.M{"user_id": userID}).Decode(&sub)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
 			c.JSON(http.StatusNotFound, gin.H{"error": "有効なサブスクリプションが見つかりません"})
@@ -1753,20 +1636,18 @@ func ApplyPromotionCodeHandler(c *gin.Context) {
 
 	// Stripe APIでプロモーションコードを検索
 	// アクティブなプロモーションコードのみを検索
-	params := &stripe.PromotionCodeListParams{
-		Code:   stripe.String(req.Code),
-		Active: stripe.Bool(true),
-	}
-
 	utils.LogInfoCtx(c.Request.Context(), "ApplyPromotionCode", "Searching for promotion code: "+req.Code)
 
-	iter := promotioncode.List(params)
+	promoCodes, err := billing.ListActivePromotionCodes(ctx, req.Code)
+	if err != nil {
+		utils.LogErrorCtx(c.Request.Context(), "ApplyPromotionCode", err, "Failed to list promotion codes")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "プロモーションコードの検索に失敗しました"})
+		return
+	}
 	var targetPromoCode *stripe.PromotionCode
 
 	// 最初にヒットした有効なコードを使用
-	for iter.Next() {
-		pc := iter.PromotionCode()
-
+	for _, pc := range promoCodes {
 		// 顧客限定クーポンかどうかチェック
 		if pc.Customer != nil {
 			if pc.Customer.ID != sub.StripeCustomerID {
@@ -1780,12 +1661,6 @@ func ApplyPromotionCodeHandler(c *gin.Context) {
 		break
 	}
 
-	if err := iter.Err(); err != nil {
-		utils.LogErrorCtx(c.Request.Context(), "ApplyPromotionCode", err, "Failed to list promotion codes")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "プロモーションコードの検索に失敗しました"})
-		return
-	}
-
 	if targetPromoCode == nil {
 		utils.LogWarningCtx(c.Request.Context(), "ApplyPromotionCode", "No valid promotion code found for code: "+req.Code)
 		c.JSON(http.StatusBadRequest, gin.H{"error": "無効なプロモーションコードです"})
@@ -1797,7 +1672,7 @@ func ApplyPromotionCodeHandler(c *gin.Context) {
 		PromotionCode: stripe.String(targetPromoCode.ID),
 	}
 
-	updatedSub, err := subscriptionapi.Update(sub.StripeSubscriptionID, subParams)
+	updatedSub, err := billing.UpdateSubscription(ctx, sub.StripeSubscriptionID, subParams)
 	if err != nil {
 		utils.LogErrorCtx(c.Request.Context(), "ApplyPromotionCode", err, "Failed to apply promotion code")
 
@@ -0,0 +1,1035 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"juice_academy_backend/middleware"
+	"juice_academy_backend/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"github.com/stripe/stripe-go/v81"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// PaymentIntegrationSuite は決済ハンドラを FakeBillingProvider と MongoDB で通しで確認する統合テストスイート
+type PaymentIntegrationSuite struct {
+	suite.Suite
+	client   *mongo.Client
+	database *mongo.Database
+	fake     *services.FakeBillingProvider
+	router   *gin.Engine
+	original services.BillingProvider
+}
+
+// SetupSuite はテストスイートの初期化を行う
+func (suite *PaymentIntegrationSuite) SetupSuite() {
+	mongoURI := os.Getenv("MONGODB_TEST_URI")
+	if mongoURI == "" {
+		mongoURI = "mongodb://localhost:27017"
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(mongoURI))
+	if err != nil {
+		suite.T().Skip("MongoDBに接続できません。統合テストをスキップします: " + err.Error())
+		return
+	}
+	if err := client.Ping(ctx, nil); err != nil {
+		suite.T().Skip("MongoDBに接続できません。統合テストをスキップします: " + err.Error())
+		return
+	}
+
+	suite.client = client
+	suite.database = client.Database("juice_academy_test")
+	userCollection = suite.database.Collection("users")
+	paymentCollection = suite.database.Collection("payments")
+	subscriptionCollection = suite.database.Collection("subscriptions")
+	planCollection = suite.database.Collection("plans")
+	auditLogCollection = suite.database.Collection("audit_logs")
+	trialRedemptionCollection = suite.database.Collection("trial_redemptions")
+	invoiceCollection = suite.database.Collection("invoices")
+	receiptCollection = suite.database.Collection("receipts")
+	receiptSequenceCollection = suite.database.Collection("receipt_sequences")
+	disputeCollection = suite.database.Collection("disputes")
+	announcementCollection = suite.database.Collection("announcements")
+	announcementReadCollection = suite.database.Collection("announcement_reads")
+	middleware.InitUserCollection(suite.database)
+	suite.original = billing
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/api/plans", GetPlansHandler)
+	router.GET("/api/announcements/:id", middleware.OptionalJWTAuth(), GetAnnouncementByIdHandler)
+	protected := router.Group("/api", middleware.JWTAuthMiddleware())
+	protected.POST("/payment/customer", CreateStripeCustomerHandler)
+	protected.POST("/payment/confirm-setup", ConfirmSetupHandler)
+	protected.POST("/payment/subscription", CreateSubscriptionHandler)
+	protected.GET("/payment/history", PaymentHistoryHandler)
+	protected.GET("/payment/invoices/:id/receipt", DownloadReceiptHandler)
+	protected.POST("/payment/invoices/:id/receipt/reissue", ReissueReceiptHandler)
+	protected.GET("/admin/billing/users/:id/invoices", AdminListUserInvoicesHandler)
+	protected.POST("/admin/billing/invoices/:id/refunds", AdminRefundInvoiceHandler)
+	protected.POST("/admin/billing/invoices/:id/credit-notes", AdminCreateCreditNoteHandler)
+	protected.GET("/admin/billing/disputes", AdminListDisputesHandler)
+	protected.GET("/admin/billing/disputes/:id", AdminGetDisputeHandler)
+	protected.POST("/admin/billing/disputes/:id/evidence", AdminSubmitDisputeEvidenceHandler)
+	protected.POST("/admin/billing/disputes/:id/resume-subscription", AdminResumeDisputeSubscriptionHandler)
+	protected.GET("/subscription/status", GetSubscriptionStatusHandler)
+	protected.POST("/subscription/cancel", CancelSubscriptionHandler)
+	protected.GET("/subscription/change-plan/preview", PreviewPlanChangeHandler)
+	protected.POST("/subscription/change-plan", ChangePlanHandler)
+	protected.DELETE("/subscription/change-plan", CancelScheduledPlanChangeHandler)
+	protected.POST("/subscription/pause", PauseSubscriptionHandler)
+	protected.POST("/subscription/resume", ResumeSubscriptionHandler)
+	protected.GET("/entitlements", GetEntitlementsHandler)
+	protected.GET("/announcements/unread-count", GetUnreadAnnouncementCountHandler)
+	middleware.SetEntitlementSource(LoadEntitlementSnapshot)
+	suite.router = router
+}
+
+// TearDownSuite はテストスイートの終了処理を行う
+func (suite *PaymentIntegrationSuite) TearDownSuite() {
+	billing = suite.original
+	if suite.client != nil {
+		suite.database.Drop(context.Background())
+		suite.client.Disconnect(context.Background())
+	}
+}
+
+// SetupTest は各テストの前にデータと Stripe の状態を初期化する
+// 擬似 Webhook は Worker Pool を通さず、発生した順に同期処理する
+func (suite *PaymentIntegrationSuite) SetupTest() {
+	if suite.client == nil {
+		suite.T().Skip("MongoDBに接続されていません")
+		return
+	}
+	for _, name := range []string{"users", "payments", "subscriptions", "plans", "audit_logs", "trial_redemptions", "invoices", "receipts", "receipt_sequences", "disputes", "announcements", "announcement_reads"} {
+		suite.database.Collection(name).Drop(context.Background())
+	}
+	require.NoError(suite.T(), createTrialRedemptionIndexes(context.Background(), trialRedemptionCollection))
+	require.NoError(suite.T(), createInvoiceIndexes(context.Background(), invoiceCollection))
+	require.NoError(suite.T(), createReceiptIndexes(context.Background(), receiptCollection))
+	require.NoError(suite.T(), createDisputeIndexes(context.Background(), disputeCollection))
+
+	suite.fake = services.NewFakeBillingProvider(time.Now())
+	suite.fake.AddPrice("price_monthly", "月額プラン", 980, stripe.PriceRecurringIntervalMonth, 1)
+	suite.fake.OnEvent(func(event stripe.Event) { processWebhookEventSync(event, "") })
+	billing = suite.fake
+
+	// 月額プランを同期して公開する
+	_, err := syncPlansFromStripe(context.Background())
+	require.NoError(suite.T(), err)
+	_, err = planCollection.UpdateOne(context.Background(), bson.M{"price_id": "price_monthly"}, bson.M{"$set": bson.M{"active": true}})
+	require.NoError(suite.T(), err)
+}
+
+// TestPaymentIntegrationSuite はテストスイートを実行
+func TestPaymentIntegrationSuite(t *testing.T) {
+	suite.Run(t, new(PaymentIntegrationSuite))
+}
+
+// request は認証済みのユーザーとして API を呼び出し、レスポンスの JSON を返す
+func (suite *PaymentIntegrationSuite) request(user User, method, path string, body interface{}) (int, map[string]interface{}) {
+	w := suite.serve(user, method, path, body)
+	var response map[string]interface{}
+	_ = json.Unmarshal(w.Body.Bytes(), &response)
+	return w.Code, response
+}
+
+// serve は認証済みのユーザーとして API を呼び出し、レスポンスをそのまま返す（PDF などの JSON 以外のレスポンス用）
+func (suite *PaymentIntegrationSuite) serve(user User, method, path string, body interface{}) *httptest.ResponseRecorder {
+	token, err := generateAccessToken(user)
+	require.NoError(suite.T(), err)
+
+	var reader *bytes.Reader
+	if body != nil {
+		data, _ := json.Marshal(body)
+		reader = bytes.NewReader(data)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+	req, _ := http.NewRequest(method, path, reader)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	suite.router.ServeHTTP(w, req)
+	return w
+}
+
+func (suite *PaymentIntegrationSuite) storedSubscription(user User) Subscription {
+	var sub Subscription
+	err := subscriptionCollection.FindOne(context.Background(), bson.M{"user_id": user.ID}).Decode(&sub)
+	require.NoError(suite.T(), err)
+	return sub
+}
+
+// TestSubscriptionLifecycle はカード登録から契約・自動更新・解約予約・期間終了までを通しで確認する
+func (suite *PaymentIntegrationSuite) TestSubscriptionLifecycle() {
+	t := suite.T()
+	user := User{Role: "student", StudentID: "pay_001", NameKana: "ケッサイ タロウ", Email: "billing@example.com", CreatedAt: time.Now(), UpdatedAt: time.Now()}
+	result, err := userCollection.InsertOne(context.Background(), user)
+	require.NoError(t, err)
+	user.ID = result.InsertedID.(primitive.ObjectID)
+
+	code, _ := suite.request(user, "POST", "/api/payment/customer", nil)
+	require.Equal(t, http.StatusCreated, code)
+	customers, err := suite.fake.ListCustomersByEmail(context.Background(), user.Email)
+	require.NoError(t, err)
+	require.Len(t, customers, 1)
+	assert.Equal(t, user.ID.Hex(), customers[0].Metadata["user_id"])
+
+	// 既存の顧客がある場合は新たに作成しない
+	code, _ = suite.request(user, "POST", "/api/payment/customer", nil)
+	assert.Equal(t, http.StatusOK, code)
+
+	// カード未登録では契約できない
+	code, _ = suite.request(user, "POST", "/api/payment/subscription", gin.H{"priceId": "price_monthly"})
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	pmID := suite.fake.CreateCardPaymentMethod(stripe.PaymentMethodCardBrandVisa, "4242")
+	code, _ = suite.request(user, "POST", "/api/payment/confirm-setup", gin.H{"paymentMethodId": pmID})
+	require.Equal(t, http.StatusOK, code)
+
+	code, _ = suite.request(user, "POST", "/api/payment/subscription", gin.H{"priceId": "price_unknown"})
+	assert.Equal(t, http.StatusBadRequest, code, "許可されていない価格では契約できないこと")
+
+	code, body := suite.request(user, "POST", "/api/payment/subscription", gin.H{"priceId": "price_monthly"})
+	require.Equal(t, http.StatusOK, code, body)
+	sub := suite.storedSubscription(user)
+	assert.Equal(t, "active", sub.Status)
+	firstPeriodEnd := sub.CurrentPeriodEnd
+
+	// 期間終了で自動更新され、Webhook で DB の契約期間が延びる
+	suite.fake.AdvanceClockTo(firstPeriodEnd)
+	sub = suite.storedSubscription(user)
+	assert.Equal(t, "active", sub.Status)
+	assert.True(t, sub.CurrentPeriodEnd.After(firstPeriodEnd), "更新後の契約期間が反映されること")
+
+	code, body = suite.request(user, "GET", "/api/payment/history", nil)
+	require.Equal(t, http.StatusOK, code)
+	history, _ := body["payment_history"].([]interface{})
+	assert.Len(t, history, 3, "支払い済み2件と次回請求予定1件")
+
+	// 解約予約すると期間終了時に解約される
+	code, body = suite.request(user, "POST", "/api/subscription/cancel", nil)
+	require.Equal(t, http.StatusOK, code, body)
+	assert.True(t, suite.storedSubscription(user).CancelAtPeriodEnd)
+
+	suite.fake.AdvanceClockTo(sub.CurrentPeriodEnd)
+	assert.Equal(t, "canceled", suite.storedSubscription(user).Status)
+
+	code, body = suite.request(user, "GET", "/api/subscription/status", nil)
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, false, body["hasActiveSubscription"])
+}
+
+// TestPlanCatalogSync は Stripe の商品・価格の同期とプランの公開状態による契約の制限を確認する
+func (suite *PaymentIntegrationSuite) TestPlanCatalogSync() {
+	t := suite.T()
+	ctx := context.Background()
+	t.Setenv("VITE_STRIPE_PRICE_ID_YEARLY", "price_yearly")
+
+	suite.fake.AddPrice("price_yearly", "", 9800, stripe.PriceRecurringIntervalYear, 1)
+	suite.fake.AddPrice("price_2years", "2年プラン", 18000, stripe.PriceRecurringIntervalYear, 2)
+	suite.fake.AddProduct(stripe.Product{
+		Name:              "年額プラン",
+		Description:       "1年分をまとめてお得に",
+		MarketingFeatures: []*stripe.ProductMarketingFeature{{Name: "ドリンクサーバーの利用が可能"}, {Name: " "}},
+	}, "price_yearly", "price_2years")
+
+	// 管理者が編集した表示項目は同期で上書きしない
+	_, err := planCollection.UpdateOne(ctx, bson.M{"price_id": "price_monthly"}, bson.M{"$set": bson.M{"name": "スタンダード"}})
+	require.NoError(t, err)
+
+	result, err := syncPlansFromStripe(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, PlanSyncResult{Created: 2, Updated: 1}, *result)
+
+	plans, err := listPlans(ctx, bson.M{})
+	require.NoError(t, err)
+	require.Len(t, plans, 3)
+	assert.Equal(t, []string{"price_monthly", "price_yearly", "price_2years"}, []string{plans[0].PriceID, plans[1].PriceID, plans[2].PriceID}, "請求間隔の短い順に並ぶこと")
+	assert.Equal(t, "スタンダード", plans[0].Name)
+	assert.Equal(t, "年額プラン", plans[1].Name, "ニックネームがなければ商品名を使うこと")
+	assert.Equal(t, []string{"ドリンクサーバーの利用が可能"}, plans[1].Features)
+	assert.True(t, plans[1].Active, "旧来の環境変数で許可していた価格は公開すること")
+	assert.Equal(t, "2年プラン", plans[2].Name)
+	assert.False(t, plans[2].Active, "新しい価格は非公開で追加すること")
+	assert.Equal(t, int64(2), plans[2].IntervalCount)
+
+	req, _ := http.NewRequest("GET", "/api/plans", nil)
+	w := httptest.NewRecorder()
+	suite.router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	var body struct {
+		Plans []Plan `json:"plans"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	require.Len(t, body.Plans, 2, "公開中のプランのみ返すこと")
+
+	// Stripe でアーカイブされた価格では契約できない
+	suite.fake.ArchivePrice("price_yearly")
+	result, err = syncPlansFromStripe(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, result.Archived)
+	_, err = findPurchasablePlan(ctx, "price_yearly")
+	assert.ErrorIs(t, err, ErrPlanNotFound)
+	_, err = findPurchasablePlan(ctx, "price_2years")
+	assert.ErrorIs(t, err, ErrPlanNotFound, "非公開のプランでは契約できないこと")
+	plan, err := findPurchasablePlan(ctx, "price_monthly")
+	require.NoError(t, err)
+	assert.Equal(t, int64(980), plan.Amount)
+}
+
+// subscribe はカードを登録して指定の価格で契約したユーザーを作成する
+func (suite *PaymentIntegrationSuite) subscribe(studentID, email, priceID string) User {
+	t := suite.T()
+	user := User{Role: "student", StudentID: studentID, NameKana: "ケッサイ ハナコ", Email: email, CreatedAt: time.Now(), UpdatedAt: time.Now()}
+	result, err := userCollection.InsertOne(context.Background(), user)
+	require.NoError(t, err)
+	user.ID = result.InsertedID.(primitive.ObjectID)
+
+	code, _ := suite.request(user, "POST", "/api/payment/customer", nil)
+	require.Equal(t, http.StatusCreated, code)
+	pmID := suite.fake.CreateCardPaymentMethod(stripe.PaymentMethodCardBrandVisa, "4242")
+	code, _ = suite.request(user, "POST", "/api/payment/confirm-setup", gin.H{"paymentMethodId": pmID})
+	require.Equal(t, http.StatusOK, code)
+	code, body := suite.request(user, "POST", "/api/payment/subscription", gin.H{"priceId": priceID})
+	require.Equal(t, http.StatusOK, code, body)
+	return user
+}
+
+// TestPlanChange はアップグレードの日割り請求とダウングレードの予約・切り替えを確認する
+func (suite *PaymentIntegrationSuite) TestPlanChange() {
+	t := suite.T()
+	ctx := context.Background()
+	suite.fake.AddPrice("price_yearly", "年額プラン", 9800, stripe.PriceRecurringIntervalYear, 1)
+	_, err := syncPlansFromStripe(ctx)
+	require.NoError(t, err)
+	_, err = planCollection.UpdateOne(ctx, bson.M{"price_id": "price_yearly"}, bson.M{"$set": bson.M{"active": true}})
+	require.NoError(t, err)
+
+	user := suite.subscribe("pay_002", "upgrade@example.com", "price_monthly")
+
+	code, _ := suite.request(user, "GET", "/api/subscription/change-plan/preview?priceId=price_monthly", nil)
+	assert.Equal(t, http.StatusBadRequest, code, "現在と同じプランには変更できないこと")
+
+	// アップグレードは差額を日割りで即時請求する
+	suite.fake.AdvanceClock(10 * 24 * time.Hour)
+	code, preview := suite.request(user, "GET", "/api/subscription/change-plan/preview?priceId=price_yearly", nil)
+	require.Equal(t, http.StatusOK, code, preview)
+	assert.Equal(t, PlanChangeUpgrade, preview["change"])
+	amountDue := preview["amount_due"].(float64)
+	assert.Greater(t, amountDue, float64(0))
+	assert.Less(t, amountDue, float64(9800), "未使用分を差し引くこと")
+
+	code, body := suite.request(user, "POST", "/api/subscription/change-plan", gin.H{
+		"priceId":        "price_yearly",
+		"prorationToken": preview["proration_token"],
+	})
+	require.Equal(t, http.StatusOK, code, body)
+	assert.Equal(t, amountDue, body["amount_due"], "プレビューと同じ金額を請求すること")
+	sub := suite.storedSubscription(user)
+	assert.Equal(t, "price_yearly", sub.PriceID)
+	invoices, err := suite.fake.ListInvoices(ctx, sub.StripeCustomerID)
+	require.NoError(t, err)
+	assert.Equal(t, stripe.InvoiceBillingReasonSubscriptionUpdate, invoices[0].BillingReason)
+
+	// ダウングレードは契約期間の終了時に切り替える
+	code, body = suite.request(user, "POST", "/api/subscription/change-plan", gin.H{"priceId": "price_monthly"})
+	require.Equal(t, http.StatusOK, code, body)
+	assert.Equal(t, PlanChangePeriodEnd, body["effective"])
+	sub = suite.storedSubscription(user)
+	assert.Equal(t, "price_yearly", sub.PriceID)
+	assert.Equal(t, "price_monthly", sub.ScheduledPriceID)
+	assert.NotEmpty(t, sub.ScheduleID)
+
+	code, body = suite.request(user, "GET", "/api/subscription/status", nil)
+	require.Equal(t, http.StatusOK, code)
+	status, _ := body["subscription"].(map[string]interface{})
+	assert.Equal(t, "price_monthly", status["scheduled_price_id"])
+
+	// 予約を取り消して、もう一度予約する
+	code, body = suite.request(user, "DELETE", "/api/subscription/change-plan", nil)
+	require.Equal(t, http.StatusOK, code, body)
+	assert.Empty(t, suite.storedSubscription(user).ScheduledPriceID)
+	code, body = suite.request(user, "POST", "/api/subscription/change-plan", gin.H{"priceId": "price_monthly"})
+	require.Equal(t, http.StatusOK, code, body)
+
+	suite.fake.AdvanceClockTo(sub.CurrentPeriodEnd)
+	sub = suite.storedSubscription(user)
+	assert.Equal(t, "price_monthly", sub.PriceID, "切り替え時の Webhook で反映されること")
+	assert.Empty(t, sub.ScheduleID)
+	assert.Empty(t, sub.ScheduledPriceID)
+	assert.Nil(t, sub.ScheduledChangeAt)
+
+	count, err := auditLogCollection.CountDocuments(ctx, bson.M{"actor_id": user.ID})
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), count, "変更・予約2回・予約取り消しを記録すること")
+}
+
+// TestPlanChangeWithScheduledDowngrade はダウングレードの予約中にアップグレードした場合の予約の扱いを確認する
+func (suite *PaymentIntegrationSuite) TestPlanChangeWithScheduledDowngrade() {
+	t := suite.T()
+	ctx := context.Background()
+	suite.fake.AddPrice("price_standard", "スタンダードプラン", 1980, stripe.PriceRecurringIntervalMonth, 1)
+	suite.fake.AddPrice("price_yearly", "年額プラン", 9800, stripe.PriceRecurringIntervalYear, 1)
+	_, err := syncPlansFromStripe(ctx)
+	require.NoError(t, err)
+	_, err = planCollection.UpdateMany(ctx, bson.M{}, bson.M{"$set": bson.M{"active": true}})
+	require.NoError(t, err)
+
+	user := suite.subscribe("pay_004", "scheduled@example.com", "price_standard")
+	code, body := suite.request(user, "POST", "/api/subscription/change-plan", gin.H{"priceId": "price_monthly"})
+	require.Equal(t, http.StatusOK, code, body)
+	sub := suite.storedSubscription(user)
+	scheduleID := sub.ScheduleID
+	require.NotEmpty(t, scheduleID)
+
+	// 差額の支払いに失敗した場合は予約したダウングレードを残す
+	suite.fake.DeclinePayments(sub.StripeCustomerID, true)
+	code, body = suite.request(user, "POST", "/api/subscription/change-plan", gin.H{"priceId": "price_yearly"})
+	require.Equal(t, http.StatusPaymentRequired, code, body)
+	sub = suite.storedSubscription(user)
+	assert.Equal(t, "price_standard", sub.PriceID)
+	assert.Equal(t, scheduleID, sub.ScheduleID)
+	assert.Equal(t, "price_monthly", sub.ScheduledPriceID)
+	stripeSub, err := suite.fake.GetSubscription(ctx, sub.StripeSubscriptionID)
+	require.NoError(t, err)
+	require.NotNil(t, stripeSub.Schedule, "Stripe の予約も残ること")
+	assert.Equal(t, scheduleID, stripeSub.Schedule.ID)
+
+	// 署名のない基準日時や別のプランのトークンは使わず、変更時の日時で精算する
+	suite.fake.DeclinePayments(sub.StripeCustomerID, false)
+	code, preview := suite.request(user, "GET", "/api/subscription/change-plan/preview?priceId=price_yearly", nil)
+	require.Equal(t, http.StatusOK, code, preview)
+	forged := fmt.Sprintf("%d.%s", sub.CurrentPeriodEnd.Unix(), strings.SplitN(preview["proration_token"].(string), ".", 2)[1])
+	code, body = suite.request(user, "POST", "/api/subscription/change-plan", gin.H{"priceId": "price_yearly", "prorationToken": forged})
+	require.Equal(t, http.StatusOK, code, body)
+	assert.Equal(t, preview["amount_due"], body["amount_due"])
+
+	// アップグレードに成功すると予約を取り消す
+	sub = suite.storedSubscription(user)
+	assert.Equal(t, "price_yearly", sub.PriceID)
+	assert.Empty(t, sub.ScheduleID)
+	assert.Empty(t, sub.ScheduledPriceID)
+	stripeSub, err = suite.fake.GetSubscription(ctx, sub.StripeSubscriptionID)
+	require.NoError(t, err)
+	assert.Nil(t, stripeSub.Schedule)
+
+	var entry AuditLog
+	require.NoError(t, auditLogCollection.FindOne(ctx, bson.M{"action": AuditActionPlanChanged}).Decode(&entry))
+	assert.InDelta(t, time.Now().Unix(), entry.Details["proration_date"], 60, "改ざんした基準日時で精算しないこと")
+}
+
+// TestSubscriptionPause は休止・再開と休止中の利用制限、1年間の休止日数の上限を確認する
+func (suite *PaymentIntegrationSuite) TestSubscriptionPause() {
+	t := suite.T()
+	user := suite.subscribe("pay_003", "pause@example.com", "price_monthly")
+
+	code, _ := suite.request(user, "POST", "/api/subscription/pause", gin.H{"resumesAt": time.Now().Add(3 * 24 * time.Hour)})
+	assert.Equal(t, http.StatusBadRequest, code, "最短期間より短い休止はできないこと")
+	code, _ = suite.request(user, "POST", "/api/subscription/pause", gin.H{"resumesAt": time.Now().Add(120 * 24 * time.Hour)})
+	assert.Equal(t, http.StatusBadRequest, code, "上限を超える休止はできないこと")
+
+	code, body := suite.request(user, "POST", "/api/subscription/pause", gin.H{"resumesAt": time.Now().Add(30 * 24 * time.Hour)})
+	require.Equal(t, http.StatusOK, code, body)
+	sub := suite.storedSubscription(user)
+	require.NotNil(t, sub.PausedAt)
+	require.Len(t, sub.PauseHistory, 1)
+	stripeSub, err := suite.fake.GetSubscription(context.Background(), sub.StripeSubscriptionID)
+	require.NoError(t, err)
+	require.NotNil(t, stripeSub.PauseCollection)
+	assert.Equal(t, stripe.SubscriptionPauseCollectionBehaviorVoid, stripeSub.PauseCollection.Behavior)
+
+	code, body = suite.request(user, "GET", "/api/subscription/status", nil)
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, false, body["hasActiveSubscription"], "休止中は利用できないこと")
+	status, _ := body["subscription"].(map[string]interface{})
+	assert.Equal(t, true, status["paused"])
+	code, body = suite.request(user, "GET", suite.membersOnlyAnnouncement(), nil)
+	assert.Equal(t, http.StatusForbidden, code, "休止中は会員限定のお知らせを閲覧できないこと")
+	assert.Equal(t, middleware.EntitlementErrorCode, body["code"])
+	code, _ = suite.request(user, "GET", "/api/announcements/unread-count", nil)
+	assert.Equal(t, http.StatusOK, code, "既読管理は契約にかかわらず利用できること")
+
+	assert.Equal(t, 0, suite.audienceSize("active"), "休止中の契約者を有効な契約者として配信対象に含めないこと")
+	assert.Equal(t, 1, suite.audienceSize("paused"))
+
+	code, _ = suite.request(user, "POST", "/api/subscription/pause", nil)
+	assert.Equal(t, http.StatusConflict, code)
+
+	// 予定より早く再開すると、使わなかった日数は次の休止に使える
+	code, body = suite.request(user, "POST", "/api/subscription/resume", nil)
+	require.Equal(t, http.StatusOK, code, body)
+	sub = suite.storedSubscription(user)
+	assert.Nil(t, sub.PausedAt)
+	assert.WithinDuration(t, time.Now(), sub.PauseHistory[0].EndedAt, time.Minute)
+	code, body = suite.request(user, "GET", "/api/subscription/status", nil)
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, true, body["hasActiveSubscription"])
+
+	// 再開日時を省略すると上限まで休止し、再開日時に Stripe が自動で再開する
+	code, body = suite.request(user, "POST", "/api/subscription/pause", nil)
+	require.Equal(t, http.StatusOK, code, body)
+	sub = suite.storedSubscription(user)
+	require.NotNil(t, sub.PauseResumesAt)
+	assert.WithinDuration(t, time.Now().Add(subscriptionPauseMaxDuration), *sub.PauseResumesAt, time.Hour)
+
+	suite.fake.AdvanceClockTo(sub.PauseResumesAt.Add(time.Minute))
+	sub = suite.storedSubscription(user)
+	assert.Nil(t, sub.PausedAt, "自動再開の Webhook で休止が解除されること")
+	assert.Nil(t, sub.PauseResumesAt)
+	assert.Equal(t, "active", sub.Status)
+}
+
+// audienceSize は指定したサブスクリプション状態を配信対象とするお知らせの対象ユーザー数を返す
+func (suite *PaymentIntegrationSuite) audienceSize(status string) int {
+	pipeline := append(audienceUserStages(&AnnouncementAudience{SubscriptionStatuses: []string{status}}),
+		bson.D{{Key: "$count", Value: "count"}})
+	cursor, err := userCollection.Aggregate(context.Background(), pipeline)
+	require.NoError(suite.T(), err)
+	var result []struct {
+		Count int `bson:"count"`
+	}
+	require.NoError(suite.T(), cursor.All(context.Background(), &result))
+	if len(result) == 0 {
+		return 0
+	}
+	return result[0].Count
+}
+
+// membersOnlyAnnouncement は公開中の会員限定のお知らせを作成し、詳細のパスを返す
+func (suite *PaymentIntegrationSuite) membersOnlyAnnouncement() string {
+	publishAt := time.Now().Add(-time.Hour)
+	result, err := announcementCollection.InsertOne(context.Background(), Announcement{
+		Title:       "会員限定のお知らせ",
+		Content:     "契約者向けの内容",
+		ContentHTML: "<p>契約者向けの内容</p>",
+		Category:    AnnouncementCategoryGeneral,
+		MembersOnly: true,
+		IsPublished: true,
+		PublishAt:   &publishAt,
+		CreatedAt:   publishAt,
+		UpdatedAt:   publishAt,
+	})
+	require.NoError(suite.T(), err)
+	return "/api/announcements/" + result.InsertedID.(primitive.ObjectID).Hex()
+}
+
+// TestSubscriptionPauseConcurrent は同時に受けた休止リクエストのうち1件だけが休止を記録することと、
+// Stripe での休止に失敗した場合に記録を取り消すことを確認する
+func (suite *PaymentIntegrationSuite) TestSubscriptionPauseConcurrent() {
+	t := suite.T()
+	user := suite.subscribe("pay_013", "pause-race@example.com", "price_monthly")
+
+	const attempts = 5
+	codes := make(chan int, attempts)
+	var wg sync.WaitGroup
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes <- suite.serve(user, "POST", "/api/subscription/pause", gin.H{"resumesAt": time.Now().Add(30 * 24 * time.Hour)}).Code
+		}()
+	}
+	wg.Wait()
+	close(codes)
+
+	counts := map[int]int{}
+	for code := range codes {
+		counts[code]++
+	}
+	assert.Equal(t, 1, counts[http.StatusOK])
+	assert.Equal(t, attempts-1, counts[http.StatusConflict])
+	sub := suite.storedSubscription(user)
+	assert.Len(t, sub.PauseHistory, 1, "休止の履歴は1件だけ記録すること")
+
+	// Stripe での休止に失敗した場合は、休止の記録を残さない
+	other := suite.subscribe("pay_014", "pause-fail@example.com", "price_monthly")
+	_, err := subscriptionCollection.UpdateOne(context.Background(),
+		bson.M{"user_id": other.ID},
+		bson.M{"$set": bson.M{"stripe_subscription_id": "sub_missing"}},
+	)
+	require.NoError(t, err)
+	code, _ := suite.request(other, "POST", "/api/subscription/pause", nil)
+	assert.Equal(t, http.StatusInternalServerError, code)
+	sub = suite.storedSubscription(other)
+	assert.Nil(t, sub.PausedAt)
+	assert.Nil(t, sub.PauseResumesAt)
+	assert.Empty(t, sub.PauseHistory)
+}
+
+// TestSubscriptionTrial はカード未登録でのトライアル開始と、利用者・学籍番号ごとに1回までの制限を確認する
+func (suite *PaymentIntegrationSuite) TestSubscriptionTrial() {
+	t := suite.T()
+	ctx := context.Background()
+	_, err := planCollection.UpdateOne(ctx, bson.M{"price_id": "price_monthly"}, bson.M{"$set": bson.M{"trial_days": 14}})
+	require.NoError(t, err)
+
+	newUser := func(email string) User {
+		user := User{Role: "student", StudentID: "trial_001", NameKana: "トライアル タロウ", Email: email, CreatedAt: time.Now(), UpdatedAt: time.Now()}
+		result, err := userCollection.InsertOne(ctx, user)
+		require.NoError(t, err)
+		user.ID = result.InsertedID.(primitive.ObjectID)
+		code, _ := suite.request(user, "POST", "/api/payment/customer", nil)
+		require.Equal(t, http.StatusCreated, code)
+		return user
+	}
+
+	user := newUser("trial@example.com")
+	code, body := suite.request(user, "GET", "/api/subscription/status", nil)
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, true, body["trialEligible"])
+
+	// カード未登録でもトライアルを開始できる
+	code, body = suite.request(user, "POST", "/api/payment/subscription", gin.H{"priceId": "price_monthly"})
+	require.Equal(t, http.StatusOK, code, body)
+	sub := suite.storedSubscription(user)
+	assert.Equal(t, "trialing", sub.Status)
+	require.NotNil(t, sub.TrialEnd)
+
+	code, body = suite.request(user, "GET", "/api/subscription/status", nil)
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, true, body["hasActiveSubscription"], "トライアル中は利用できること")
+	assert.Equal(t, false, body["trialEligible"])
+	status, _ := body["subscription"].(map[string]interface{})
+	assert.Equal(t, true, status["is_trial"])
+
+	// カードを登録しないままトライアルが終わると解約される
+	suite.fake.AdvanceClockTo(*sub.TrialEnd)
+	assert.Equal(t, "canceled", suite.storedSubscription(user).Status)
+
+	// 同じ利用者が再び契約する場合はトライアルを付けない（カードが必要）
+	code, _ = suite.request(user, "POST", "/api/payment/subscription", gin.H{"priceId": "price_monthly"})
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	// 同じ学籍番号で作り直したアカウントもトライアルの対象外
+	again := newUser("trial-again@example.com")
+	code, body = suite.request(again, "GET", "/api/subscription/status", nil)
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, false, body["trialEligible"])
+	pmID := suite.fake.CreateCardPaymentMethod(stripe.PaymentMethodCardBrandVisa, "4242")
+	code, _ = suite.request(again, "POST", "/api/payment/confirm-setup", gin.H{"paymentMethodId": pmID})
+	require.Equal(t, http.StatusOK, code)
+	code, body = suite.request(again, "POST", "/api/payment/subscription", gin.H{"priceId": "price_monthly"})
+	require.Equal(t, http.StatusOK, code, body)
+	assert.Equal(t, "active", suite.storedSubscription(again).Status, "トライアルなしで請求すること")
+
+	count, err := trialRedemptionCollection.CountDocuments(ctx, bson.M{})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+}
+
+// TestEntitlements は契約状態と支払い遅延の猶予期間による利用権の変化を確認する
+func (suite *PaymentIntegrationSuite) TestEntitlements() {
+	t := suite.T()
+	ctx := context.Background()
+
+	free := User{Role: "student", StudentID: "ent_000", NameKana: "ミケイヤク タロウ", Email: "ent-free@example.com", CreatedAt: time.Now(), UpdatedAt: time.Now()}
+	result, err := userCollection.InsertOne(ctx, free)
+	require.NoError(t, err)
+	free.ID = result.InsertedID.(primitive.ObjectID)
+	members := suite.membersOnlyAnnouncement()
+	code, body := suite.request(free, "GET", members, nil)
+	assert.Equal(t, http.StatusForbidden, code)
+	assert.Equal(t, middleware.EntitlementErrorCode, body["code"])
+	code, _ = suite.request(free, "GET", "/api/announcements/unread-count", nil)
+	assert.Equal(t, http.StatusOK, code, "既読管理は契約がなくても利用できること")
+
+	user := suite.subscribe("ent_001", "ent@example.com", "price_monthly")
+	code, body = suite.request(user, "GET", "/api/entitlements", nil)
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "active", body["status"])
+	assert.Contains(t, body["entitlements"], middleware.EntitlementPremiumContent)
+	code, _ = suite.request(user, "GET", members, nil)
+	assert.Equal(t, http.StatusOK, code)
+
+	// 更新時の支払いに失敗しても猶予期間中は利用できる
+	sub := suite.storedSubscription(user)
+	suite.fake.DeclinePayments(sub.StripeCustomerID, true)
+	suite.fake.AdvanceClockTo(sub.CurrentPeriodEnd)
+	sub = suite.storedSubscription(user)
+	assert.Equal(t, "past_due", sub.Status)
+	require.NotNil(t, sub.PastDueAt)
+
+	code, body = suite.request(user, "GET", "/api/entitlements", nil)
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "past_due", body["status"])
+	assert.NotNil(t, body["grace_until"])
+	code, _ = suite.request(user, "GET", members, nil)
+	assert.Equal(t, http.StatusOK, code)
+
+	// 猶予期間を過ぎると利用できない
+	_, err = subscriptionCollection.UpdateByID(ctx, sub.ID, bson.M{"$set": bson.M{"past_due_at": time.Now().Add(-subscriptionGracePeriod - time.Hour)}})
+	require.NoError(t, err)
+	code, body = suite.request(user, "GET", members, nil)
+	assert.Equal(t, http.StatusForbidden, code)
+	assert.Equal(t, middleware.EntitlementErrorCode, body["code"])
+}
+
+// TestInvoiceLedger は Webhook で保存した請求書からの支払い履歴の表示・ページング・バックフィルを確認する
+func (suite *PaymentIntegrationSuite) TestInvoiceLedger() {
+	t := suite.T()
+	ctx := context.Background()
+	user := suite.subscribe("inv_001", "ledger@example.com", "price_monthly")
+	sub := suite.storedSubscription(user)
+	firstPeriodEnd := sub.CurrentPeriodEnd
+	suite.fake.AdvanceClockTo(firstPeriodEnd)
+
+	count, err := invoiceCollection.CountDocuments(ctx, bson.M{"stripe_customer_id": sub.StripeCustomerID})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), count, "Webhook で請求書が保存されること")
+
+	code, body := suite.request(user, "GET", "/api/payment/history?limit=1", nil)
+	require.Equal(t, http.StatusOK, code, body)
+	history, _ := body["payment_history"].([]interface{})
+	require.Len(t, history, 2, "最新の1件と次回請求予定")
+	assert.Equal(t, "upcoming", history[0].(map[string]interface{})["status"])
+	latest := history[1].(map[string]interface{})
+	assert.Equal(t, "success", latest["status"])
+	assert.NotEmpty(t, latest["hosted_invoice_url"])
+	assert.Equal(t, float64(2), body["total"])
+	nextCursor, _ := body["next_cursor"].(string)
+	require.NotEmpty(t, nextCursor)
+
+	// 2ページ目には次回請求予定を含めない
+	code, body = suite.request(user, "GET", "/api/payment/history?limit=1&cursor="+nextCursor, nil)
+	require.Equal(t, http.StatusOK, code, body)
+	history, _ = body["payment_history"].([]interface{})
+	require.Len(t, history, 1)
+	assert.NotEqual(t, latest["id"], history[0].(map[string]interface{})["id"])
+	assert.Empty(t, body["next_cursor"])
+
+	code, _ = suite.request(user, "GET", "/api/payment/history?cursor=broken", nil)
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	// 期間で絞り込める（終了日を指定した場合は次回請求予定を含めない）
+	to := firstPeriodEnd.Add(-time.Hour).Format(time.RFC3339)
+	code, body = suite.request(user, "GET", "/api/payment/history?to="+url.QueryEscape(to), nil)
+	require.Equal(t, http.StatusOK, code, body)
+	history, _ = body["payment_history"].([]interface{})
+	assert.Len(t, history, 1, "初回の請求のみ")
+
+	// 遅れて届いた古いイベントでは上書きしない
+	var stored services.InvoiceRecord
+	require.NoError(t, invoiceCollection.FindOne(ctx, bson.M{"stripe_customer_id": sub.StripeCustomerID}).Decode(&stored))
+	stale := &stripe.Invoice{ID: stored.StripeInvoiceID, Status: stripe.InvoiceStatusOpen, Customer: &stripe.Customer{ID: sub.StripeCustomerID}}
+	require.NoError(t, services.ApplyStripeInvoice(ctx, invoiceCollection, stale, stored.StripeObservedAt.Add(-time.Minute)))
+	require.NoError(t, invoiceCollection.FindOne(ctx, bson.M{"stripe_invoice_id": stored.StripeInvoiceID}).Decode(&stored))
+	assert.Equal(t, "paid", stored.Status)
+
+	// 保存済みの請求書がなくてもバックフィルで Stripe から取り込める
+	_, err = invoiceCollection.DeleteMany(ctx, bson.M{})
+	require.NoError(t, err)
+	report, err := BackfillInvoices(ctx, InvoiceBackfillOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, 1, report.Customers)
+	assert.Equal(t, 2, report.Invoices)
+	assert.Empty(t, report.Failures)
+	count, err = invoiceCollection.CountDocuments(ctx, bson.M{"stripe_customer_id": sub.StripeCustomerID})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), count)
+}
+
+// TestReceipts は領収書の発行・再ダウンロード・宛名を変えた再発行を確認する
+func (suite *PaymentIntegrationSuite) TestReceipts() {
+	t := suite.T()
+	ctx := context.Background()
+	user := suite.subscribe("rcpt_001", "receipt@example.com", "price_monthly")
+	sub := suite.storedSubscription(user)
+
+	var inv services.InvoiceRecord
+	require.NoError(t, invoiceCollection.FindOne(ctx, bson.M{"stripe_customer_id": sub.StripeCustomerID}).Decode(&inv))
+	assert.Equal(t, user.NameKana, inv.CustomerName, "Stripe の請求先氏名を保存すること")
+	path := "/api/payment/invoices/" + inv.StripeInvoiceID + "/receipt"
+
+	w := suite.serve(user, "GET", path, nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "application/pdf", w.Header().Get("Content-Type"))
+	assert.True(t, bytes.HasPrefix(w.Body.Bytes(), []byte("%PDF-")))
+	year := time.Now().In(receiptLocation).Year()
+	firstNumber := fmt.Sprintf("R-%d-000001", year)
+	assert.Contains(t, w.Header().Get("Content-Disposition"), firstNumber)
+
+	// 再ダウンロードでは採番し直さない
+	w = suite.serve(user, "GET", path, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Header().Get("Content-Disposition"), firstNumber)
+	first, err := findCurrentReceipt(ctx, inv.StripeInvoiceID, ReceiptTypeReceipt)
+	require.NoError(t, err)
+	assert.Equal(t, user.NameKana, first.Addressee)
+
+	// 宛名を変えて再発行すると新しい番号になり、以前の領収書は無効になる
+	code, body := suite.request(user, "POST", path+"/reissue", gin.H{"addressee": "株式会社ジュース"})
+	require.Equal(t, http.StatusCreated, code, body)
+	reissued, err := findCurrentReceipt(ctx, inv.StripeInvoiceID, ReceiptTypeReceipt)
+	require.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("R-%d-000002", year), reissued.Number)
+	assert.Equal(t, "株式会社ジュース", reissued.Addressee)
+	require.NotNil(t, reissued.ReissueOf)
+	assert.Equal(t, first.ID, *reissued.ReissueOf)
+	var previous Receipt
+	require.NoError(t, receiptCollection.FindOne(ctx, bson.M{"_id": first.ID}).Decode(&previous))
+	assert.False(t, previous.Current)
+	assert.NotNil(t, previous.SupersededAt)
+
+	var entry AuditLog
+	require.NoError(t, auditLogCollection.FindOne(ctx, bson.M{"action": AuditActionReceiptReissued}).Decode(&entry))
+	assert.Equal(t, reissued.Number, entry.TargetID)
+	assert.Equal(t, firstNumber, entry.Details["previous_number"])
+	assert.Equal(t, user.NameKana, entry.Details["previous_addressee"])
+
+	code, _ = suite.request(user, "POST", path+"/reissue", gin.H{"addressee": "株式会社ジュース"})
+	assert.Equal(t, http.StatusBadRequest, code, "同じ宛名では再発行しない")
+	code, _ = suite.request(user, "POST", path+"/reissue", gin.H{"addressee": " "})
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	// 他の利用者の請求書は見つからないものとして扱う
+	other := suite.subscribe("rcpt_002", "receipt-other@example.com", "price_monthly")
+	w = suite.serve(other, "GET", path, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// 登録番号が未設定の場合、適格請求書は発行しない
+	original := receiptIssuer
+	defer func() { receiptIssuer = original }()
+	receiptIssuer.RegistrationNumber = ""
+	w = suite.serve(user, "GET", path+"?type=invoice", nil)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	receiptIssuer.RegistrationNumber = "T1234567890123"
+	w = suite.serve(user, "GET", path+"?type=invoice", nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Contains(t, w.Header().Get("Content-Disposition"), fmt.Sprintf("INV-%d-000001", year))
+	invoice, err := findCurrentReceipt(ctx, inv.StripeInvoiceID, ReceiptTypeInvoice)
+	require.NoError(t, err)
+	assert.Equal(t, "T1234567890123", invoice.Issuer.RegistrationNumber)
+}
+
+// TestRefunds は管理者による一部返金・クレジットノートの発行と、charge.refunded による台帳の更新を確認する
+func (suite *PaymentIntegrationSuite) TestRefunds() {
+	t := suite.T()
+	ctx := context.Background()
+	user := suite.subscribe("refund_001", "refund@example.com", "price_monthly")
+	sub := suite.storedSubscription(user)
+
+	admin := User{Role: "admin", StudentID: "admin_001", NameKana: "カンリ タロウ", Email: "admin@example.com", IsAdmin: true, CreatedAt: time.Now(), UpdatedAt: time.Now()}
+	result, err := userCollection.InsertOne(ctx, admin)
+	require.NoError(t, err)
+	admin.ID = result.InsertedID.(primitive.ObjectID)
+
+	loadInvoice := func() services.InvoiceRecord {
+		var inv services.InvoiceRecord
+		require.NoError(t, invoiceCollection.FindOne(ctx, bson.M{"stripe_customer_id": sub.StripeCustomerID}).Decode(&inv))
+		return inv
+	}
+	inv := loadInvoice()
+	require.NotEmpty(t, inv.StripePaymentIntentID)
+	path := "/api/admin/billing/invoices/" + inv.StripeInvoiceID
+
+	code, _ := suite.request(admin, "POST", path+"/refunds", gin.H{"amount": 300, "reason_code": "service_issue"})
+	assert.Equal(t, http.StatusBadRequest, code, "メモは必須")
+	code, _ = suite.request(admin, "POST", path+"/refunds", gin.H{"amount": 1000, "reason_code": "service_issue", "note": "授業の中止"})
+	assert.Equal(t, http.StatusBadRequest, code, "支払額を超えて返金できない")
+
+	// 一部返金すると返金と返金額が台帳に反映される（Webhook と API の結果は1件にまとめる）
+	code, body := suite.request(admin, "POST", path+"/refunds", gin.H{"amount": 300, "reason_code": "service_issue", "note": "授業の中止"})
+	require.Equal(t, http.StatusCreated, code, body)
+	inv = loadInvoice()
+	assert.Equal(t, int64(300), inv.AmountRefunded)
+	require.Len(t, inv.Refunds, 1)
+	assert.Equal(t, "service_issue", inv.Refunds[0].ReasonCode)
+	assert.Equal(t, "授業の中止", inv.Refunds[0].Note)
+	assert.Equal(t, admin.ID.Hex(), inv.Refunds[0].CreatedBy)
+
+	var entry AuditLog
+	require.NoError(t, auditLogCollection.FindOne(ctx, bson.M{"action": AuditActionInvoiceRefunded}).Decode(&entry))
+	assert.Equal(t, inv.StripeInvoiceID, entry.TargetID)
+	assert.Equal(t, admin.ID, entry.ActorID)
+	assert.Equal(t, "授業の中止", entry.Details["note"])
+
+	code, body = suite.request(user, "GET", "/api/payment/history", nil)
+	require.Equal(t, http.StatusOK, code)
+	history, _ := body["payment_history"].([]interface{})
+	require.NotEmpty(t, history)
+	assert.Equal(t, "partially_refunded", history[len(history)-1].(map[string]interface{})["status"])
+
+	// 残額をクレジットノートで返金すると全額返金済みになる
+	code, body = suite.request(admin, "POST", path+"/credit-notes", gin.H{"amount": 681, "reason_code": "withdrawal", "note": "退学", "refund": true})
+	assert.Equal(t, http.StatusBadRequest, code, body)
+	code, body = suite.request(admin, "POST", path+"/credit-notes", gin.H{"amount": 680, "reason_code": "withdrawal", "note": "退学", "refund": true})
+	require.Equal(t, http.StatusCreated, code, body)
+	inv = loadInvoice()
+	assert.Equal(t, int64(980), inv.AmountRefunded)
+	assert.Equal(t, int64(680), inv.PostPaymentCreditNotesAmount)
+	assert.Len(t, inv.Refunds, 2)
+	require.Len(t, inv.CreditNotes, 1)
+	assert.Equal(t, "withdrawal", inv.CreditNotes[0].ReasonCode)
+	assert.NotEmpty(t, inv.CreditNotes[0].StripeRefundID)
+
+	code, _ = suite.request(admin, "POST", path+"/refunds", gin.H{"reason_code": "other", "note": "再度の返金"})
+	assert.Equal(t, http.StatusConflict, code)
+
+	// 遅れて届いた古い charge.refunded で返金額を減らさない
+	for _, event := range suite.fake.Events() {
+		if event.Type == "charge.refunded" {
+			processWebhookEventSync(event, "")
+			break
+		}
+	}
+	assert.Equal(t, int64(980), loadInvoice().AmountRefunded)
+
+	code, body = suite.request(admin, "GET", "/api/admin/billing/users/"+user.ID.Hex()+"/invoices", nil)
+	require.Equal(t, http.StatusOK, code)
+	invoices, _ := body["invoices"].([]interface{})
+	require.Len(t, invoices, 1)
+	assert.Equal(t, float64(0), invoices[0].(map[string]interface{})["refundable_amount"])
+	assert.NotEmpty(t, body["reasons"])
+
+	code, body = suite.request(user, "GET", "/api/payment/history", nil)
+	require.Equal(t, http.StatusOK, code)
+	history, _ = body["payment_history"].([]interface{})
+	assert.Equal(t, "refunded", history[len(history)-1].(map[string]interface{})["status"])
+}
+
+// serveForm は認証済みのユーザーとして multipart/form-data で API を呼び出し、レスポンスの JSON を返す
+func (suite *PaymentIntegrationSuite) serveForm(user User, path string, fields map[string]string, files map[string][]byte) (int, map[string]interface{}) {
+	token, err := generateAccessToken(user)
+	require.NoError(suite.T(), err)
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for key, value := range fields {
+		require.NoError(suite.T(), writer.WriteField(key, value))
+	}
+	for key, data := range files {
+		part, err := writer.CreateFormFile(key, key+".pdf")
+		require.NoError(suite.T(), err)
+		_, err = part.Write(data)
+		require.NoError(suite.T(), err)
+	}
+	require.NoError(suite.T(), writer.Close())
+
+	req, _ := http.NewRequest("POST", path, &body)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	w := httptest.NewRecorder()
+	suite.router.ServeHTTP(w, req)
+	var response map[string]interface{}
+	_ = json.Unmarshal(w.Body.Bytes(), &response)
+	return w.Code, response
+}
+
+// TestDisputes は異議申し立ての記録・証拠の提出・サブスクリプションの停止と再開を確認する
+func (suite *PaymentIntegrationSuite) TestDisputes() {
+	t := suite.T()
+	ctx := context.Background()
+	user := suite.subscribe("dispute_001", "dispute@example.com", "price_monthly")
+	sub := suite.storedSubscription(user)
+
+	admin := User{Role: "admin", StudentID: "admin_001", NameKana: "カンリ タロウ", Email: "admin@example.com", IsAdmin: true, CreatedAt: time.Now(), UpdatedAt: time.Now()}
+	result, err := userCollection.InsertOne(ctx, admin)
+	require.NoError(t, err)
+	admin.ID = result.InsertedID.(primitive.ObjectID)
+
+	var inv services.InvoiceRecord
+	require.NoError(t, invoiceCollection.FindOne(ctx, bson.M{"stripe_customer_id": sub.StripeCustomerID}).Decode(&inv))
+	disputeID, err := suite.fake.OpenDispute(inv.StripePaymentIntentID, stripe.DisputeReasonFraudulent)
+	require.NoError(t, err)
+
+	loadDispute := func() services.DisputeRecord {
+		var record services.DisputeRecord
+		require.NoError(t, disputeCollection.FindOne(ctx, bson.M{"stripe_dispute_id": disputeID}).Decode(&record))
+		return record
+	}
+	record := loadDispute()
+	assert.Equal(t, "needs_response", record.Status)
+	assert.Equal(t, inv.StripeInvoiceID, record.StripeInvoiceID)
+	require.NotNil(t, record.UserID)
+	assert.Equal(t, user.ID, *record.UserID)
+
+	// 結果が確定していない異議申し立ての一覧に利用者とともに表示される
+	code, body := suite.request(admin, "GET", "/api/admin/billing/disputes", nil)
+	require.Equal(t, http.StatusOK, code)
+	disputes, _ := body["disputes"].([]interface{})
+	require.Len(t, disputes, 1)
+	entry := disputes[0].(map[string]interface{})
+	assert.Equal(t, true, entry["awaiting_response"])
+	assert.Equal(t, user.Email, entry["user"].(map[string]interface{})["email"])
+
+	path := "/api/admin/billing/disputes/" + disputeID
+	code, _ = suite.serveForm(admin, path+"/evidence", map[string]string{"submit": "true"}, nil)
+	assert.Equal(t, http.StatusBadRequest, code, "証拠が空")
+
+	// 証拠を提出し、あわせてサブスクリプションを停止する
+	code, body = suite.serveForm(admin, path+"/evidence",
+		map[string]string{"product_description": "オンライン授業の月額利用料", "submit": "true", "suspend_subscription": "true"},
+		map[string][]byte{"receipt": []byte("%PDF-1.4\n%test\n")},
+	)
+	require.Equal(t, http.StatusOK, code, body)
+	assert.Equal(t, "under_review", body["status"])
+	assert.Equal(t, true, body["subscription_suspended"])
+
+	record = loadDispute()
+	assert.Equal(t, int64(1), record.SubmissionCount)
+	require.Len(t, record.Submissions, 1)
+	assert.Equal(t, admin.ID, record.Submissions[0].CreatedBy)
+	require.Len(t, record.Submissions[0].Files, 1)
+	assert.Equal(t, "オンライン授業の月額利用料", suite.fake.DisputeEvidence(disputeID)["product_description"])
+	assert.NotEmpty(t, suite.fake.DisputeEvidence(disputeID)["receipt"])
+	assert.NotNil(t, record.SubscriptionSuspendedAt)
+
+	sub = suite.storedSubscription(user)
+	assert.Equal(t, disputeID, sub.SuspendedByDisputeID)
+	code, body = suite.request(user, "GET", "/api/subscription/status", nil)
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, true, body["suspended"])
+	code, _ = suite.request(user, "POST", "/api/subscription/resume", nil)
+	assert.Equal(t, http.StatusForbidden, code, "利用者自身では再開できない")
+
+	code, _ = suite.serveForm(admin, path+"/evidence", map[string]string{"uncategorized_text": "追加の説明", "submit": "true"}, nil)
+	assert.Equal(t, http.StatusConflict, code, "審査中は証拠を登録できない")
+
+	// 勝訴で確定すると一覧の既定（未確定）から外れる
+	require.NoError(t, suite.fake.CloseDispute(disputeID, true))
+	assert.Equal(t, "won", loadDispute().Status)
+	code, body = suite.request(admin, "GET", "/api/admin/billing/disputes", nil)
+	require.Equal(t, http.StatusOK, code)
+	disputes, _ = body["disputes"].([]interface{})
+	assert.Empty(t, disputes)
+	code, body = suite.request(admin, "GET", "/api/admin/billing/disputes?status=closed", nil)
+	require.Equal(t, http.StatusOK, code)
+	disputes, _ = body["disputes"].([]interface{})
+	assert.Len(t, disputes, 1)
+
+	code, body = suite.request(admin, "POST", path+"/resume-subscription", nil)
+	require.Equal(t, http.StatusOK, code, body)
+	sub = suite.storedSubscription(user)
+	assert.Empty(t, sub.SuspendedByDisputeID)
+	assert.Nil(t, loadDispute().SubscriptionSuspendedAt)
+	code, _ = suite.request(admin, "POST", path+"/resume-subscription", nil)
+	assert.Equal(t, http.StatusNotFound, code)
+
+	var audit AuditLog
+	require.NoError(t, auditLogCollection.FindOne(ctx, bson.M{"action": AuditActionDisputeSubscriptionSuspended}).Decode(&audit))
+	assert.Equal(t, disputeID, audit.TargetID)
+}
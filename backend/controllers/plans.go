@@ -0,0 +1,452 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"juice_academy_backend/middleware"
+	"juice_academy_backend/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stripe/stripe-go/v81"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var planCollection *mongo.Collection
+
+const (
+	planNameMaxLength = 100
+	planMaxFeatures   = 20
+	planMaxTrialDays  = 90
+)
+
+// ErrPlanNotFound は契約できるプランが見つからないことを表す
+var ErrPlanNotFound = errors.New("plan not found")
+
+// Plan はプラン一覧（plans コレクション）の1件。Stripe の価格（Price）1つに対応する
+// 金額・請求間隔・通貨は Stripe から同期し、表示名・説明・特徴・並び順・公開状態は管理者が編集する
+type Plan struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	PriceID       string             `bson:"price_id" json:"price_id"`
+	ProductID     string             `bson:"product_id,omitempty" json:"product_id,omitempty"`
+	Name          string             `bson:"name" json:"name"`
+	Description   string             `bson:"description" json:"description"`
+	Interval      string             `bson:"interval" json:"interval"` // day / week / month / year
+	IntervalCount int64              `bson:"interval_count" json:"interval_count"`
+	Amount        int64              `bson:"amount" json:"amount"` // 通貨の最小単位（JPY はそのまま円）
+	Currency      string             `bson:"currency" json:"currency"`
+	Features      []string           `bson:"features" json:"features"`
+	SortOrder     int                `bson:"sort_order" json:"sort_order"`
+	Recommended   bool               `bson:"recommended" json:"recommended"`
+	// TrialDays は初めて契約する利用者に付ける無料トライアルの日数（0 はトライアルなし）
+	TrialDays int `bson:"trial_days" json:"trial_days"`
+	// Active は管理者による公開状態、StripeActive は Stripe 側で価格が有効かどうか
+	// 契約できるのは両方が true のプランのみ
+	Active       bool       `bson:"active" json:"active"`
+	StripeActive bool       `bson:"stripe_active" json:"stripe_active"`
+	SyncedAt     *time.Time `bson:"synced_at,omitempty" json:"synced_at,omitempty"`
+	CreatedAt    time.Time  `bson:"created_at" json:"created_at"`
+	UpdatedAt    time.Time  `bson:"updated_at" json:"updated_at"`
+}
+
+// planUpdateRequest は管理者が編集できるプランの表示項目
+type planUpdateRequest struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Features    []string `json:"features"`
+	SortOrder   int      `json:"sort_order"`
+	Recommended bool     `json:"recommended"`
+	TrialDays   int      `json:"trial_days"`
+	Active      bool     `json:"active"`
+}
+
+// PlanSyncResult は Stripe からの同期結果
+type PlanSyncResult struct {
+	Created  int `json:"created"`
+	Updated  int `json:"updated"`
+	Archived int `json:"archived"`
+}
+
+// InitPlanCollection はプランコレクションを初期化する
+func InitPlanCollection(client *mongo.Client) {
+	planCollection = client.Database("juice_academy").Collection("plans")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, _ = planCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "price_id", Value: 1}},
+			Options: options.Index().SetUnique(true).SetName("price_id_unique"),
+		},
+		{
+			Keys:    bson.D{{Key: "active", Value: 1}, {Key: "sort_order", Value: 1}},
+			Options: options.Index().SetName("active_sort_order_idx"),
+		},
+	})
+}
+
+// normalize は管理者の入力値を正規化して検証する
+func (r *planUpdateRequest) normalize() error {
+	r.Name = strings.TrimSpace(r.Name)
+	if r.Name == "" {
+		return errors.New("プラン名を入力してください")
+	}
+	if len([]rune(r.Name)) > planNameMaxLength {
+		return fmt.Errorf("プラン名は%d文字以内で入力してください", planNameMaxLength)
+	}
+	r.Description = strings.TrimSpace(r.Description)
+	r.Features = normalizePlanFeatures(r.Features)
+	if len(r.Features) > planMaxFeatures {
+		return fmt.Errorf("特徴は%d件までです", planMaxFeatures)
+	}
+	if r.TrialDays < 0 || r.TrialDays > planMaxTrialDays {
+		return fmt.Errorf("トライアル期間は0〜%d日で指定してください", planMaxTrialDays)
+	}
+	return nil
+}
+
+// normalizePlanFeatures は空の項目を除き、前後の空白を取り除く
+func normalizePlanFeatures(features []string) []string {
+	out := make([]string, 0, len(features))
+	for _, feature := range features {
+		if feature = strings.TrimSpace(feature); feature != "" {
+			out = append(out, feature)
+		}
+	}
+	return out
+}
+
+// purchasablePlanFilter は契約できるプランの条件
+func purchasablePlanFilter() bson.M {
+	return bson.M{"active": true, "stripe_active": true}
+}
+
+// findPurchasablePlan は価格IDから契約できるプランを取得する
+// 非公開・Stripe でアーカイブ済み・未登録の価格は ErrPlanNotFound を返す
+func findPurchasablePlan(ctx context.Context, priceID string) (*Plan, error) {
+	if priceID == "" {
+		return nil, ErrPlanNotFound
+	}
+	filter := purchasablePlanFilter()
+	filter["price_id"] = priceID
+
+	var plan Plan
+	err := planCollection.FindOne(ctx, filter).Decode(&plan)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrPlanNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &plan, nil
+}
+
+// planNamesByPriceID は価格IDからプラン名への対応表を返す（非公開のプランも含む）
+// 決済履歴では過去に契約していたプランの名前も表示するため
+func planNamesByPriceID(ctx context.Context) (map[string]string, error) {
+	cursor, err := planCollection.Find(ctx, bson.M{}, options.Find().SetProjection(bson.M{"price_id": 1, "name": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var plans []Plan
+	if err := cursor.All(ctx, &plans); err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]string, len(plans))
+	for _, plan := range plans {
+		names[plan.PriceID] = plan.Name
+	}
+	return names, nil
+}
+
+// listPlans はプランを並び順で取得する
+func listPlans(ctx context.Context, filter bson.M) ([]Plan, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "sort_order", Value: 1}, {Key: "amount", Value: 1}})
+	cursor, err := planCollection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	plans := []Plan{}
+	if err := cursor.All(ctx, &plans); err != nil {
+		return nil, err
+	}
+	return plans, nil
+}
+
+// GetPlansHandler は契約できるプランの一覧を返すハンドラ（公開API）
+func GetPlansHandler(c *gin.Context) {
+	plans, err := listPlans(c.Request.Context(), purchasablePlanFilter())
+	if err != nil {
+		utils.LogErrorCtx(c.Request.Context(), "GetPlans", err, "Failed to list plans")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "プラン一覧の取得に失敗しました"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"plans": plans})
+}
+
+// AdminListPlansHandler は非公開・アーカイブ済みを含むすべてのプランを返すハンドラ
+func AdminListPlansHandler(c *gin.Context) {
+	plans, err := listPlans(c.Request.Context(), bson.M{})
+	if err != nil {
+		utils.LogErrorCtx(c.Request.Context(), "AdminListPlans", err, "Failed to list plans")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "プラン一覧の取得に失敗しました"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"plans": plans})
+}
+
+// UpdatePlanHandler はプランの表示項目と公開状態を更新するハンドラ
+// 金額や請求間隔は Stripe 側で変更し、同期で反映する
+func UpdatePlanHandler(c *gin.Context) {
+	planID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "無効なプランIDです"})
+		return
+	}
+
+	var req planUpdateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "不正な入力データです"})
+		return
+	}
+	if err := req.normalize(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := c.Request.Context()
+	var plan Plan
+	if err := planCollection.FindOne(ctx, bson.M{"_id": planID}).Decode(&plan); err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "プランが見つかりません"})
+			return
+		}
+		utils.LogErrorCtx(ctx, "UpdatePlan", err, "Failed to fetch plan")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "プランの取得に失敗しました"})
+		return
+	}
+	if req.Active && !plan.StripeActive {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Stripe でアーカイブされた価格のプランは公開できません"})
+		return
+	}
+
+	update := bson.M{"$set": bson.M{
+		"name":        req.Name,
+		"description": req.Description,
+		"features":    req.Features,
+		"sort_order":  req.SortOrder,
+		"recommended": req.Recommended,
+		"trial_days":  req.TrialDays,
+		"active":      req.Active,
+		"updated_at":  time.Now(),
+	}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	if err := planCollection.FindOneAndUpdate(ctx, bson.M{"_id": planID}, update, opts).Decode(&plan); err != nil {
+		utils.LogErrorCtx(ctx, "UpdatePlan", err, "Failed to update plan")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "プランの更新に失敗しました"})
+		return
+	}
+
+	adminID, _ := middleware.CurrentUserID(c)
+	writeAuditLog(c, adminID, AuditActionPlanUpdated, "plan", plan.PriceID, map[string]interface{}{
+		"name":        plan.Name,
+		"sort_order":  plan.SortOrder,
+		"recommended": plan.Recommended,
+		"trial_days":  plan.TrialDays,
+		"active":      plan.Active,
+	})
+	utils.LogInfoCtx(ctx, "UpdatePlan", fmt.Sprintf("Plan %s updated by %s (active=%t)", plan.PriceID, adminID.Hex(), plan.Active))
+	c.JSON(http.StatusOK, plan)
+}
+
+// SyncPlansHandler は Stripe の商品・価格をプラン一覧に同期するハンドラ
+func SyncPlansHandler(c *gin.Context) {
+	ctx := c.Request.Context()
+	result, err := syncPlansFromStripe(ctx)
+	if err != nil {
+		utils.LogErrorCtx(ctx, "SyncPlans", err, "Failed to sync plans from Stripe")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Stripe からのプラン同期に失敗しました"})
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// SeedPlanCatalog はプラン一覧が空の場合に Stripe から初回の同期を行う（起動時に呼び出す）
+// 旧来の環境変数で指定されていた価格はそのまま公開し、移行直後も契約できるようにする
+func SeedPlanCatalog(ctx context.Context) {
+	count, err := planCollection.CountDocuments(ctx, bson.M{})
+	if err != nil {
+		utils.LogError("SeedPlanCatalog", err, "Failed to count plans")
+		return
+	}
+	if count > 0 {
+		return
+	}
+
+	result, err := syncPlansFromStripe(ctx)
+	if err != nil {
+		utils.LogError("SeedPlanCatalog", err, "Failed to sync plans from Stripe")
+		return
+	}
+	utils.LogInfo("SeedPlanCatalog", fmt.Sprintf("Imported %d plans from Stripe", result.Created))
+}
+
+// legacyPlanPriceIDs はプラン一覧の導入前に環境変数で許可していた価格ID
+func legacyPlanPriceIDs() map[string]bool {
+	ids := make(map[string]bool)
+	for _, key := range []string{"VITE_STRIPE_PRICE_ID_MONTHLY", "VITE_STRIPE_PRICE_ID_YEARLY", "VITE_STRIPE_PRICE_ID_2YEARS"} {
+		if id := os.Getenv(key); id != "" {
+			ids[id] = true
+		}
+	}
+	return ids
+}
+
+// syncPlansFromStripe は Stripe の有効な定期課金の価格をプラン一覧に反映する
+// 新しい価格は非公開で追加し、管理者が内容を確認してから公開する
+// Stripe で見つからなくなった価格のプランは契約できないようにする（既存の契約者の表示のため削除はしない）
+func syncPlansFromStripe(ctx context.Context) (*PlanSyncResult, error) {
+	prices, err := billing.ListRecurringPrices(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// 初回の並び順は請求間隔の短い順とする
+	sort.SliceStable(prices, func(i, j int) bool {
+		return planIntervalDays(prices[i].Recurring) < planIntervalDays(prices[j].Recurring)
+	})
+
+	nextSortOrder, err := nextPlanSortOrder(ctx)
+	if err != nil {
+		return nil, err
+	}
+	legacy := legacyPlanPriceIDs()
+
+	now := time.Now()
+	result := &PlanSyncResult{}
+	seen := make([]string, 0, len(prices))
+	for _, price := range prices {
+		if price.Recurring == nil {
+			continue
+		}
+		seen = append(seen, price.ID)
+		name, description, features := planDefaultsFromPrice(price)
+
+		productID := ""
+		if price.Product != nil {
+			productID = price.Product.ID
+		}
+		update := bson.M{
+			"$set": bson.M{
+				"product_id":     productID,
+				"interval":       string(price.Recurring.Interval),
+				"interval_count": price.Recurring.IntervalCount,
+				"amount":         price.UnitAmount,
+				"currency":       string(price.Currency),
+				"stripe_active":  true,
+				"synced_at":      now,
+				"updated_at":     now,
+			},
+			"$setOnInsert": bson.M{
+				"name":        name,
+				"description": description,
+				"features":    features,
+				"sort_order":  nextSortOrder,
+				"recommended": false,
+				"trial_days":  0,
+				"active":      legacy[price.ID],
+				"created_at":  now,
+			},
+		}
+		res, err := planCollection.UpdateOne(ctx, bson.M{"price_id": price.ID}, update, options.Update().SetUpsert(true))
+		if err != nil {
+			return nil, err
+		}
+		if res.UpsertedCount > 0 {
+			result.Created++
+			nextSortOrder++
+		} else {
+			result.Updated++
+		}
+	}
+
+	res, err := planCollection.UpdateMany(ctx,
+		bson.M{"price_id": bson.M{"$nin": seen}, "stripe_active": true},
+		bson.M{"$set": bson.M{"stripe_active": false, "synced_at": now, "updated_at": now}},
+	)
+	if err != nil {
+		return nil, err
+	}
+	result.Archived = int(res.ModifiedCount)
+
+	utils.LogInfo("SyncPlans", fmt.Sprintf("Plans synced from Stripe: created=%d updated=%d archived=%d", result.Created, result.Updated, result.Archived))
+	return result, nil
+}
+
+// nextPlanSortOrder は既存のプランより後ろになる並び順を返す
+func nextPlanSortOrder(ctx context.Context) (int, error) {
+	var last Plan
+	opts := options.FindOne().SetSort(bson.D{{Key: "sort_order", Value: -1}})
+	err := planCollection.FindOne(ctx, bson.M{}, opts).Decode(&last)
+	if err == mongo.ErrNoDocuments {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return last.SortOrder + 1, nil
+}
+
+// planDefaultsFromPrice は新しく追加するプランの表示項目の初期値を Stripe の商品・価格から作る
+// 価格のニックネームがあれば、同じ商品の価格を区別するためにプラン名として使う
+func planDefaultsFromPrice(price *stripe.Price) (name, description string, features []string) {
+	features = []string{}
+	if price.Product != nil {
+		name = price.Product.Name
+		description = price.Product.Description
+		for _, feature := range price.Product.MarketingFeatures {
+			if feature != nil {
+				features = append(features, feature.Name)
+			}
+		}
+	}
+	if price.Nickname != "" {
+		name = price.Nickname
+	}
+	if name == "" {
+		name = price.ID
+	}
+	return name, description, normalizePlanFeatures(features)
+}
+
+// planIntervalDays は並び替え用のおおよその請求間隔（日数）
+func planIntervalDays(recurring *stripe.PriceRecurring) int64 {
+	if recurring == nil {
+		return 0
+	}
+	days := map[stripe.PriceRecurringInterval]int64{
+		stripe.PriceRecurringIntervalDay:   1,
+		stripe.PriceRecurringIntervalWeek:  7,
+		stripe.PriceRecurringIntervalMonth: 30,
+		stripe.PriceRecurringIntervalYear:  365,
+	}[recurring.Interval]
+	return days * recurring.IntervalCount
+}
+
+// planInvoiceDescription は決済履歴に表示する請求の説明
+func planInvoiceDescription(planName string) string {
+	if planName == "" {
+		return "juice学園 サブスクリプション"
+	}
+	return "juice学園 " + planName
+}
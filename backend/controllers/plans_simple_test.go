@@ -0,0 +1,127 @@
+package controllers
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/stripe-go/v81"
+)
+
+// TestPlanUpdateRequestNormalize は管理者によるプラン編集の入力検証をテストする
+func TestPlanUpdateRequestNormalize(t *testing.T) {
+	req := planUpdateRequest{
+		Name:     "  年額プラン ",
+		Features: []string{" いつでも解約可能 ", "", "  "},
+	}
+	require.NoError(t, req.normalize())
+	assert.Equal(t, "年額プラン", req.Name)
+	assert.Equal(t, []string{"いつでも解約可能"}, req.Features)
+
+	empty := planUpdateRequest{Name: "   "}
+	assert.Error(t, empty.normalize(), "プラン名は必須")
+
+	tooMany := planUpdateRequest{Name: "月額プラン", Features: make([]string, planMaxFeatures+1)}
+	for i := range tooMany.Features {
+		tooMany.Features[i] = "特徴"
+	}
+	assert.Error(t, tooMany.normalize())
+
+	trial := planUpdateRequest{Name: "月額プラン", TrialDays: planMaxTrialDays}
+	assert.NoError(t, trial.normalize())
+	trial.TrialDays = planMaxTrialDays + 1
+	assert.Error(t, trial.normalize(), "トライアル期間の上限を超えないこと")
+	trial.TrialDays = -1
+	assert.Error(t, trial.normalize())
+}
+
+// TestPlanDefaultsFromPrice は Stripe の商品・価格からの表示項目の初期値をテストする
+func TestPlanDefaultsFromPrice(t *testing.T) {
+	price := &stripe.Price{
+		ID: "price_yearly",
+		Product: &stripe.Product{
+			Name:              "juice学園",
+			Description:       "ドリンク飲み放題",
+			MarketingFeatures: []*stripe.ProductMarketingFeature{{Name: "いつでも解約可能"}, nil},
+		},
+	}
+	name, description, features := planDefaultsFromPrice(price)
+	assert.Equal(t, "juice学園", name)
+	assert.Equal(t, "ドリンク飲み放題", description)
+	assert.Equal(t, []string{"いつでも解約可能"}, features)
+
+	price.Nickname = "年額プラン"
+	name, _, _ = planDefaultsFromPrice(price)
+	assert.Equal(t, "年額プラン", name, "ニックネームで同じ商品の価格を区別すること")
+
+	name, _, features = planDefaultsFromPrice(&stripe.Price{ID: "price_bare"})
+	assert.Equal(t, "price_bare", name)
+	assert.Empty(t, features)
+}
+
+// TestPlanIntervalDays は並び替えに使う請求間隔をテストする
+func TestPlanIntervalDays(t *testing.T) {
+	monthly := planIntervalDays(&stripe.PriceRecurring{Interval: stripe.PriceRecurringIntervalMonth, IntervalCount: 1})
+	yearly := planIntervalDays(&stripe.PriceRecurring{Interval: stripe.PriceRecurringIntervalYear, IntervalCount: 1})
+	twoYears := planIntervalDays(&stripe.PriceRecurring{Interval: stripe.PriceRecurringIntervalYear, IntervalCount: 2})
+	assert.Less(t, monthly, yearly)
+	assert.Less(t, yearly, twoYears)
+	assert.Equal(t, int64(0), planIntervalDays(nil))
+	assert.Equal(t, "juice学園 年額プラン", planInvoiceDescription("年額プラン"))
+	assert.Equal(t, "juice学園 サブスクリプション", planInvoiceDescription(""))
+}
+
+// TestIsPlanUpgrade はプラン変更がアップグレードかダウングレードかの判定をテストする
+func TestIsPlanUpgrade(t *testing.T) {
+	monthly := &stripe.Price{UnitAmount: 3000, Recurring: &stripe.PriceRecurring{Interval: stripe.PriceRecurringIntervalMonth, IntervalCount: 1}}
+
+	tests := []struct {
+		name     string
+		next     Plan
+		expected bool
+	}{
+		{name: "月額から年額", next: Plan{Interval: "year", IntervalCount: 1, Amount: 9800}, expected: true},
+		{name: "同じ請求間隔で値上げ", next: Plan{Interval: "month", IntervalCount: 1, Amount: 5000}, expected: true},
+		{name: "同じ請求間隔で値下げ", next: Plan{Interval: "month", IntervalCount: 1, Amount: 1000}},
+		{name: "同じ金額", next: Plan{Interval: "month", IntervalCount: 1, Amount: 3000}},
+		{name: "請求間隔が短くなる", next: Plan{Interval: "week", IntervalCount: 1, Amount: 9000}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := tt.next
+			assert.Equal(t, tt.expected, isPlanUpgrade(monthly, &next))
+		})
+	}
+}
+
+// TestPlanChangeQuoteToken はプレビューで発行した精算の基準日時のトークンの検証をテストする
+func TestPlanChangeQuoteToken(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+	now := time.Unix(1_700_000_000, 0)
+	issued := now.Add(-time.Minute).Unix()
+	token := planChangeQuoteToken("sub_1", "price_yearly", issued)
+
+	prorationDate, ok := parsePlanChangeQuoteToken(token, "sub_1", "price_yearly", now)
+	require.True(t, ok)
+	assert.Equal(t, issued, prorationDate)
+
+	_, ok = parsePlanChangeQuoteToken(token, "sub_2", "price_yearly", now)
+	assert.False(t, ok, "別のサブスクリプション")
+	_, ok = parsePlanChangeQuoteToken(token, "sub_1", "price_monthly", now)
+	assert.False(t, ok, "別のプラン")
+	_, ok = parsePlanChangeQuoteToken(token, "sub_1", "price_yearly", now.Add(planChangeQuoteTTL))
+	assert.False(t, ok, "有効期限切れ")
+	_, ok = parsePlanChangeQuoteToken(token, "sub_1", "price_yearly", now.Add(-2*time.Minute))
+	assert.False(t, ok, "未来の日時")
+
+	_, sig, _ := strings.Cut(token, ".")
+	_, ok = parsePlanChangeQuoteToken(fmt.Sprintf("%d.%s", issued+3600, sig), "sub_1", "price_yearly", time.Unix(issued+3600+60, 0))
+	assert.False(t, ok, "日時の改ざん")
+	for _, broken := range []string{"", "1700000000", "abc.def", fmt.Sprintf("%d.!!", issued)} {
+		_, ok = parsePlanChangeQuoteToken(broken, "sub_1", "price_yearly", now)
+		assert.False(t, ok, broken)
+	}
+}
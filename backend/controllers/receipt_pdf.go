@@ -0,0 +1,148 @@
+package controllers
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"juice_academy_backend/utils"
+)
+
+// 帳票のレイアウト（A4 縦、単位は pt）
+const (
+	receiptMarginLeft  = 50.0
+	receiptMarginRight = utils.PDFPageWidth - 50.0
+	receiptIssuerLeft  = 370.0
+	// receiptPageBottom より下は注記のために空けておく
+	receiptPageBottom = 750.0
+)
+
+// receiptTitle は帳票の表題
+func receiptTitle(receipt Receipt) string {
+	if receipt.Type == ReceiptTypeInvoice {
+		return "請求書"
+	}
+	return "領収書"
+}
+
+// truncateReceiptText は幅に収まらない文字列を末尾を省略して切り詰める
+func truncateReceiptText(text string, size, width float64) string {
+	if utils.PDFTextWidth(text, size) <= width {
+		return text
+	}
+	runes := []rune(text)
+	for len(runes) > 0 && utils.PDFTextWidth(string(runes)+"…", size) > width {
+		runes = runes[:len(runes)-1]
+	}
+	return string(runes) + "…"
+}
+
+// renderReceiptPDF は領収書・適格請求書の PDF を作成する
+// 適格請求書の記載事項（発行者と登録番号・取引年月日・取引内容・税率ごとの対象額と税額・宛名）をすべて含める
+func renderReceiptPDF(receipt Receipt) []byte {
+	title := receiptTitle(receipt)
+	formatDate := func(t time.Time) string { return t.Format("2006年1月2日") }
+	issuedAt := receipt.IssuedAt.In(receiptLocation)
+	transactionDate := receipt.TransactionDate.In(receiptLocation)
+
+	doc := utils.NewPDFDocument(fmt.Sprintf("%s %s", title, receipt.Number), receipt.IssuedAt)
+	center := utils.PDFPageWidth / 2
+
+	// 表題
+	heading := strings.Join(strings.Split(title, ""), " ")
+	if receipt.ReissueOf != nil {
+		heading += "（再発行）"
+	}
+	doc.TextCenter(center, 80, 22, heading)
+	if receipt.Type == ReceiptTypeInvoice {
+		doc.TextCenter(center, 100, 10, "（適格請求書）")
+	}
+
+	// 番号と発行日
+	doc.TextRight(receiptMarginRight, 125, 9, "No. "+receipt.Number)
+	doc.TextRight(receiptMarginRight, 139, 9, "発行日 "+formatDate(issuedAt))
+
+	// 宛名
+	doc.Text(receiptMarginLeft, 175, 14, truncateReceiptText(receipt.Addressee, 14, 260)+" 様")
+	doc.Line(receiptMarginLeft, 181, 340, 181, 0.8)
+
+	// 金額
+	amountLabel := "金額"
+	if receipt.Type == ReceiptTypeInvoice {
+		amountLabel = "ご請求金額（税込）"
+	}
+	doc.Rect(receiptMarginLeft, 198, 290, 44, 1)
+	doc.Text(receiptMarginLeft+10, 225, 10, amountLabel)
+	doc.TextRight(330, 229, 20, utils.FormatAmount(receipt.Total, receipt.Currency)+"-")
+
+	if receipt.Type == ReceiptTypeInvoice {
+		doc.Text(receiptMarginLeft, 262, 10, "下記のとおりご請求申し上げます。")
+	} else {
+		doc.Text(receiptMarginLeft, 262, 10, "但し "+truncateReceiptText(receipt.Description, 10, 250)+" として")
+		doc.Text(receiptMarginLeft, 278, 10, "上記正に領収いたしました。")
+	}
+
+	// 発行者
+	y := 198.0
+	doc.Text(receiptIssuerLeft, y, 11, truncateReceiptText(receipt.Issuer.Name, 11, receiptMarginRight-receiptIssuerLeft))
+	if receipt.Issuer.Address != "" {
+		y += 15
+		doc.Text(receiptIssuerLeft, y, 8, truncateReceiptText(receipt.Issuer.Address, 8, receiptMarginRight-receiptIssuerLeft))
+	}
+	if receipt.Issuer.RegistrationNumber != "" {
+		y += 15
+		doc.Text(receiptIssuerLeft, y, 9, "登録番号 "+receipt.Issuer.RegistrationNumber)
+	}
+
+	// 取引の情報
+	y = 312
+	doc.Text(receiptMarginLeft, y, 9, "取引年月日 "+formatDate(transactionDate))
+	if receipt.InvoiceNumber != "" {
+		y += 14
+		doc.Text(receiptMarginLeft, y, 9, "請求書番号 "+receipt.InvoiceNumber)
+	}
+
+	// 明細
+	const rateColumn, amountColumn = 420.0, receiptMarginRight
+	y += 28
+	doc.Line(receiptMarginLeft, y-13, receiptMarginRight, y-13, 0.8)
+	doc.Text(receiptMarginLeft+4, y, 9, "内容")
+	doc.TextRight(rateColumn+20, y, 9, "税率")
+	doc.TextRight(amountColumn-4, y, 9, "金額")
+	doc.Line(receiptMarginLeft, y+6, receiptMarginRight, y+6, 0.8)
+	for _, line := range receipt.Lines {
+		y += 20
+		if y > receiptPageBottom {
+			doc.AddPage()
+			y = 60
+		}
+		doc.Text(receiptMarginLeft+4, y, 9, truncateReceiptText(line.Description, 9, rateColumn-receiptMarginLeft-40))
+		doc.TextRight(rateColumn+20, y, 9, fmt.Sprintf("%d%%", line.TaxRate))
+		doc.TextRight(amountColumn-4, y, 9, utils.FormatAmount(line.Amount, receipt.Currency))
+		doc.Line(receiptMarginLeft, y+6, receiptMarginRight, y+6, 0.3)
+	}
+
+	// 合計と税率ごとの内訳
+	y += 24
+	if y+16*float64(len(receipt.TaxBreakdown)) > receiptPageBottom {
+		doc.AddPage()
+		y = 60
+	}
+	doc.Text(rateColumn-60, y, 10, "合計")
+	doc.TextRight(amountColumn-4, y, 10, utils.FormatAmount(receipt.Total, receipt.Currency))
+	for _, tax := range receipt.TaxBreakdown {
+		y += 16
+		doc.TextRight(amountColumn-4, y, 9, fmt.Sprintf("%d%%対象 %s（内消費税等 %s）",
+			tax.Rate, utils.FormatAmount(tax.Amount, receipt.Currency), utils.FormatAmount(tax.Tax, receipt.Currency)))
+	}
+
+	// 注記
+	y = 780
+	if receipt.ReissueOf != nil {
+		doc.Text(receiptMarginLeft, y, 8, fmt.Sprintf("※本書は再発行した%sです。同じ請求について以前に発行した%sは無効です。", title, title))
+		y += 12
+	}
+	doc.Text(receiptMarginLeft, y, 8, "※本書は電子的に発行したものです。")
+
+	return doc.Bytes()
+}
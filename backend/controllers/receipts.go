@@ -0,0 +1,495 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"juice_academy_backend/middleware"
+	"juice_academy_backend/services"
+	"juice_academy_backend/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stripe/stripe-go/v81"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	receiptCollection         *mongo.Collection
+	receiptSequenceCollection *mongo.Collection
+)
+
+// 発行する帳票の種類
+const (
+	// ReceiptTypeReceipt は領収書（支払い済みの請求書のみ）
+	ReceiptTypeReceipt = "receipt"
+	// ReceiptTypeInvoice は適格請求書（確定済みの請求書）
+	ReceiptTypeInvoice = "invoice"
+)
+
+// receiptAddresseeMaxLength は宛名の最大文字数
+const receiptAddresseeMaxLength = 100
+
+// ReceiptIssuer は帳票の発行者（適格請求書発行事業者）
+type ReceiptIssuer struct {
+	Name string `bson:"name" json:"name"`
+	// RegistrationNumber は適格請求書発行事業者の登録番号（T + 13桁）
+	RegistrationNumber string `bson:"registration_number,omitempty" json:"registration_number,omitempty"`
+	Address            string `bson:"address,omitempty" json:"address,omitempty"`
+}
+
+// receiptIssuer は帳票に記載する発行者（RECEIPT_ISSUER_* で設定する）
+var receiptIssuer = ReceiptIssuer{Name: "juice学園"}
+
+// receiptLocation は帳票の日付と連番の年の基準（日本時間）
+var receiptLocation = time.FixedZone("JST", 9*60*60)
+
+// receiptDefaultTaxRate は Stripe で税を計算していない請求書を税込価格として扱うときの税率（%）
+// RECEIPT_TAX_RATE で変更できる
+var receiptDefaultTaxRate = 10
+
+var registrationNumberPattern = regexp.MustCompile(`^T\d{13}$`)
+
+func init() {
+	if name := os.Getenv("RECEIPT_ISSUER_NAME"); name != "" {
+		receiptIssuer.Name = name
+	}
+	receiptIssuer.RegistrationNumber = os.Getenv("RECEIPT_ISSUER_REGISTRATION_NUMBER")
+	receiptIssuer.Address = os.Getenv("RECEIPT_ISSUER_ADDRESS")
+	if rate := os.Getenv("RECEIPT_TAX_RATE"); rate != "" {
+		if parsed, err := strconv.Atoi(rate); err == nil && parsed >= 0 {
+			receiptDefaultTaxRate = parsed
+		}
+	}
+}
+
+// qualifiedInvoiceAvailable は適格請求書を発行できる設定かどうかを返す
+func qualifiedInvoiceAvailable() bool {
+	return registrationNumberPattern.MatchString(receiptIssuer.RegistrationNumber)
+}
+
+// Receipt は発行した領収書・適格請求書（receipts コレクション）
+// 再表示しても同じ内容になるよう、発行時点の金額・宛名・発行者を保存する
+type Receipt struct {
+	ID     primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Number string             `bson:"number" json:"number"`
+	Type   string             `bson:"type" json:"type"`
+	UserID primitive.ObjectID `bson:"user_id" json:"-"`
+	// Current は請求書・種類ごとに最新の1件のみ true（再発行で以前のものは false になる）
+	Current         bool                  `bson:"current" json:"current"`
+	StripeInvoiceID string                `bson:"stripe_invoice_id" json:"stripe_invoice_id"`
+	InvoiceNumber   string                `bson:"invoice_number,omitempty" json:"invoice_number,omitempty"`
+	Addressee       string                `bson:"addressee" json:"addressee"`
+	Description     string                `bson:"description" json:"description"`
+	Currency        string                `bson:"currency" json:"currency"`
+	Total           int64                 `bson:"total" json:"total"`
+	Lines           []ReceiptLine         `bson:"lines" json:"lines"`
+	TaxBreakdown    []ReceiptTaxBreakdown `bson:"tax_breakdown" json:"tax_breakdown"`
+	// TransactionDate は支払日（未払いの適格請求書では請求日）
+	TransactionDate time.Time     `bson:"transaction_date" json:"transaction_date"`
+	Issuer          ReceiptIssuer `bson:"issuer" json:"issuer"`
+	IssuedAt        time.Time     `bson:"issued_at" json:"issued_at"`
+	// ReissueOf は再発行の場合の元の帳票
+	ReissueOf    *primitive.ObjectID `bson:"reissue_of,omitempty" json:"reissue_of,omitempty"`
+	SupersededAt *time.Time          `bson:"superseded_at,omitempty" json:"superseded_at,omitempty"`
+}
+
+// ReceiptLine は帳票の明細1行（金額は Stripe の明細の金額で、税込価格の場合は税込）
+type ReceiptLine struct {
+	Description string `bson:"description" json:"description"`
+	Amount      int64  `bson:"amount" json:"amount"`
+	TaxRate     int    `bson:"tax_rate" json:"tax_rate"`
+}
+
+// ReceiptTaxBreakdown は税率ごとの対象額（税込）と消費税額
+type ReceiptTaxBreakdown struct {
+	Rate   int   `bson:"rate" json:"rate"`
+	Amount int64 `bson:"amount" json:"amount"`
+	Tax    int64 `bson:"tax" json:"tax"`
+}
+
+// InitReceiptCollection は領収書のコレクションを初期化する
+func InitReceiptCollection(client *mongo.Client) {
+	db := client.Database("juice_academy")
+	receiptCollection = db.Collection("receipts")
+	receiptSequenceCollection = db.Collection("receipt_sequences")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_ = createReceiptIndexes(ctx, receiptCollection)
+}
+
+// createReceiptIndexes は領収書コレクションのインデックスを作成する
+// 同時にダウンロードされても、請求書・種類ごとに有効な帳票は1件にする
+func createReceiptIndexes(ctx context.Context, collection *mongo.Collection) error {
+	_, err := collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "number", Value: 1}},
+			Options: options.Index().SetUnique(true).SetName("number_unique"),
+		},
+		{
+			Keys: bson.D{{Key: "stripe_invoice_id", Value: 1}, {Key: "type", Value: 1}},
+			Options: options.Index().SetUnique(true).SetName("invoice_type_current_unique").
+				SetPartialFilterExpression(bson.M{"current": true}),
+		},
+	})
+	return err
+}
+
+// receiptNumberPrefix は帳票の種類ごとの番号の接頭辞
+func receiptNumberPrefix(receiptType string) string {
+	if receiptType == ReceiptTypeInvoice {
+		return "INV"
+	}
+	return "R"
+}
+
+// nextReceiptNumber は帳票の種類・年ごとの連番を採番する（例: R-2026-000001）
+func nextReceiptNumber(ctx context.Context, receiptType string, now time.Time) (string, error) {
+	year := now.In(receiptLocation).Year()
+	key := fmt.Sprintf("%s:%d", receiptType, year)
+	var sequence struct {
+		Seq int64 `bson:"seq"`
+	}
+	err := receiptSequenceCollection.FindOneAndUpdate(ctx,
+		bson.M{"_id": key},
+		bson.M{"$inc": bson.M{"seq": 1}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&sequence)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s-%d-%06d", receiptNumberPrefix(receiptType), year, sequence.Seq), nil
+}
+
+// receiptTaxBreakdown は税率ごとの対象額と消費税額を求める
+// Stripe で税を計算していない場合は、合計を receiptDefaultTaxRate の税込価格として税額を1回だけ切り捨てで計算する
+func receiptTaxBreakdown(inv services.InvoiceRecord) []ReceiptTaxBreakdown {
+	if len(inv.TaxAmounts) == 0 {
+		tax := inv.Total * int64(receiptDefaultTaxRate) / int64(100+receiptDefaultTaxRate)
+		return []ReceiptTaxBreakdown{{Rate: receiptDefaultTaxRate, Amount: inv.Total, Tax: tax}}
+	}
+
+	breakdown := []ReceiptTaxBreakdown{}
+	index := map[int]int{}
+	for _, tax := range inv.TaxAmounts {
+		i, ok := index[tax.Rate]
+		if !ok {
+			i = len(breakdown)
+			index[tax.Rate] = i
+			breakdown = append(breakdown, ReceiptTaxBreakdown{Rate: tax.Rate})
+		}
+		// Stripe の課税対象額は内税・外税ともに税抜のため、税額を足して税込にする
+		breakdown[i].Amount += tax.TaxableAmount + tax.Amount
+		breakdown[i].Tax += tax.Amount
+	}
+	return breakdown
+}
+
+// newReceipt は保存済みの請求書から帳票を作成する（番号は発行時に採番する）
+func newReceipt(inv services.InvoiceRecord, receiptType, addressee string, planNames map[string]string, now time.Time) Receipt {
+	defaultRate := receiptDefaultTaxRate
+	if len(inv.TaxAmounts) == 1 {
+		defaultRate = inv.TaxAmounts[0].Rate
+	}
+	lines := make([]ReceiptLine, 0, len(inv.Lines))
+	for _, line := range inv.Lines {
+		description := line.Description
+		if name := planNames[line.PriceID]; name != "" && !line.Proration {
+			description = planInvoiceDescription(name)
+		}
+		rate := line.TaxRate
+		if rate == 0 {
+			rate = defaultRate
+		}
+		lines = append(lines, ReceiptLine{Description: description, Amount: line.Amount, TaxRate: rate})
+	}
+
+	transactionDate := inv.CreatedAt
+	if inv.PaidAt != nil {
+		transactionDate = *inv.PaidAt
+	}
+	return Receipt{
+		Type:            receiptType,
+		Current:         true,
+		StripeInvoiceID: inv.StripeInvoiceID,
+		InvoiceNumber:   inv.Number,
+		Addressee:       addressee,
+		Description:     invoiceDescription(inv, planNames),
+		Currency:        inv.Currency,
+		Total:           inv.Total,
+		Lines:           lines,
+		TaxBreakdown:    receiptTaxBreakdown(inv),
+		TransactionDate: transactionDate,
+		Issuer:          receiptIssuer,
+		IssuedAt:        now,
+	}
+}
+
+// normalizeReceiptAddressee は宛名を検証して前後の空白を除く
+func normalizeReceiptAddressee(addressee string) (string, error) {
+	addressee = strings.TrimSpace(addressee)
+	if addressee == "" {
+		return "", errors.New("宛名を入力してください")
+	}
+	if utf8.RuneCountInString(addressee) > receiptAddresseeMaxLength {
+		return "", fmt.Errorf("宛名は%d文字以内で入力してください", receiptAddresseeMaxLength)
+	}
+	if strings.ContainsAny(addressee, "\r\n\t") {
+		return "", errors.New("宛名に改行やタブは使用できません")
+	}
+	return addressee, nil
+}
+
+// receiptTypeAvailable は請求書の状態で帳票を発行できるかどうかを確認し、できない場合は理由を返す
+func receiptTypeAvailable(receiptType string, inv services.InvoiceRecord) (int, string) {
+	switch receiptType {
+	case ReceiptTypeReceipt:
+		if inv.Status != string(stripe.InvoiceStatusPaid) {
+			return http.StatusConflict, "領収書は支払い済みの請求のみ発行できます"
+		}
+	case ReceiptTypeInvoice:
+		if !qualifiedInvoiceAvailable() {
+			return http.StatusServiceUnavailable, "適格請求書は現在発行できません"
+		}
+		if inv.Status != string(stripe.InvoiceStatusPaid) && inv.Status != string(stripe.InvoiceStatusOpen) {
+			return http.StatusConflict, "確定していない請求の適格請求書は発行できません"
+		}
+	default:
+		return http.StatusBadRequest, "type は receipt または invoice を指定してください"
+	}
+	return 0, ""
+}
+
+// findCurrentReceipt は請求書・種類ごとの有効な帳票を返す
+func findCurrentReceipt(ctx context.Context, invoiceID, receiptType string) (*Receipt, error) {
+	var receipt Receipt
+	err := receiptCollection.FindOne(ctx, bson.M{"stripe_invoice_id": invoiceID, "type": receiptType, "current": true}).Decode(&receipt)
+	if err != nil {
+		return nil, err
+	}
+	return &receipt, nil
+}
+
+// issueReceipt は帳票を採番して保存する。previous を指定した場合は再発行として以前の帳票を無効にする
+func issueReceipt(ctx context.Context, receipt Receipt, previous *Receipt) (*Receipt, error) {
+	number, err := nextReceiptNumber(ctx, receipt.Type, receipt.IssuedAt)
+	if err != nil {
+		return nil, err
+	}
+	receipt.Number = number
+
+	if previous != nil {
+		res, err := receiptCollection.UpdateOne(ctx,
+			bson.M{"_id": previous.ID, "current": true},
+			bson.M{"$set": bson.M{"current": false, "superseded_at": receipt.IssuedAt}},
+		)
+		if err != nil {
+			return nil, err
+		}
+		if res.ModifiedCount == 0 {
+			return nil, errReceiptConflict
+		}
+		receipt.ReissueOf = &previous.ID
+	}
+
+	res, err := receiptCollection.InsertOne(ctx, receipt)
+	if err != nil {
+		if previous != nil {
+			_, _ = receiptCollection.UpdateOne(ctx, bson.M{"_id": previous.ID},
+				bson.M{"$set": bson.M{"current": true}, "$unset": bson.M{"superseded_at": ""}})
+		}
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, errReceiptConflict
+		}
+		return nil, err
+	}
+	receipt.ID = res.InsertedID.(primitive.ObjectID)
+	return &receipt, nil
+}
+
+// errReceiptConflict は同じ請求書の帳票が同時に発行されたことを表す
+var errReceiptConflict = errors.New("receipt issued concurrently")
+
+// loadReceiptInvoice はログイン中の利用者の請求書を返す（他人の請求書は見つからないものとして扱う）
+func loadReceiptInvoice(c *gin.Context, userID primitive.ObjectID) (*User, *services.InvoiceRecord, bool) {
+	ctx := c.Request.Context()
+	var user User
+	if err := userCollection.FindOne(ctx, bson.M{"_id": userID}).Decode(&user); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "ユーザーが見つかりません"})
+		return nil, nil, false
+	}
+	var payment Payment
+	if err := paymentCollection.FindOne(ctx, bson.M{"user_id": userID}).Decode(&payment); err != nil || payment.StripeCustomerID == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "請求書が見つかりません"})
+		return nil, nil, false
+	}
+	var inv services.InvoiceRecord
+	err := invoiceCollection.FindOne(ctx, bson.M{
+		"stripe_invoice_id":  c.Param("id"),
+		"stripe_customer_id": payment.StripeCustomerID,
+	}).Decode(&inv)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "請求書が見つかりません"})
+		return nil, nil, false
+	}
+	if err != nil {
+		utils.LogErrorCtx(ctx, "Receipt", err, "Failed to fetch invoice")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "請求書の取得に失敗しました"})
+		return nil, nil, false
+	}
+	return &user, &inv, true
+}
+
+// receiptErrorResponse は帳票の発行に失敗した場合のレスポンスを返す
+func receiptErrorResponse(c *gin.Context, err error) {
+	if errors.Is(err, errReceiptConflict) {
+		c.JSON(http.StatusConflict, gin.H{"error": "同時に発行処理が行われました。もう一度お試しください"})
+		return
+	}
+	utils.LogErrorCtx(c.Request.Context(), "Receipt", err, "Failed to issue receipt")
+	errMsg := "領収書の発行に失敗しました"
+	if os.Getenv("APP_ENV") != "production" {
+		errMsg += ": " + err.Error()
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": errMsg})
+}
+
+// DownloadReceiptHandler は請求書の領収書（type=receipt）または適格請求書（type=invoice）の PDF を返すハンドラ
+// 初回のダウンロード時に採番して発行し、以降は同じ番号・宛名の帳票を返す
+// 宛名は Stripe の請求先氏名、なければ登録時の氏名（カナ）
+func DownloadReceiptHandler(c *gin.Context) {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "認証が必要です"})
+		return
+	}
+	receiptType := c.DefaultQuery("type", ReceiptTypeReceipt)
+
+	user, inv, ok := loadReceiptInvoice(c, userID)
+	if !ok {
+		return
+	}
+	if status, msg := receiptTypeAvailable(receiptType, *inv); msg != "" {
+		c.JSON(status, gin.H{"error": msg})
+		return
+	}
+
+	ctx := c.Request.Context()
+	receipt, err := findCurrentReceipt(ctx, inv.StripeInvoiceID, receiptType)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		addressee := inv.CustomerName
+		if addressee == "" {
+			addressee = user.NameKana
+		}
+		planNames, _ := planNamesByPriceID(ctx)
+		draft := newReceipt(*inv, receiptType, addressee, planNames, time.Now())
+		draft.UserID = userID
+		receipt, err = issueReceipt(ctx, draft, nil)
+		if errors.Is(err, errReceiptConflict) {
+			// 同時に発行された帳票を返す
+			receipt, err = findCurrentReceipt(ctx, inv.StripeInvoiceID, receiptType)
+		} else if err == nil {
+			writeAuditLog(c, userID, AuditActionReceiptIssued, "receipt", receipt.Number, map[string]interface{}{
+				"stripe_invoice_id": receipt.StripeInvoiceID,
+				"type":              receipt.Type,
+			})
+		}
+	}
+	if err != nil {
+		receiptErrorResponse(c, err)
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.pdf"`, receipt.Number))
+	c.Header("Cache-Control", "private, no-store")
+	c.Data(http.StatusOK, "application/pdf", renderReceiptPDF(*receipt))
+}
+
+// ReissueReceiptHandler は宛名を変えて帳票を再発行するハンドラ
+// 新しい番号で発行し、以前の帳票は無効にする。経緯は監査ログに残す
+func ReissueReceiptHandler(c *gin.Context) {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "認証が必要です"})
+		return
+	}
+
+	var req struct {
+		Type      string `json:"type"`
+		Addressee string `json:"addressee"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "不正な入力データです"})
+		return
+	}
+	if req.Type == "" {
+		req.Type = ReceiptTypeReceipt
+	}
+	addressee, err := normalizeReceiptAddressee(req.Addressee)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	_, inv, ok := loadReceiptInvoice(c, userID)
+	if !ok {
+		return
+	}
+	if status, msg := receiptTypeAvailable(req.Type, *inv); msg != "" {
+		c.JSON(status, gin.H{"error": msg})
+		return
+	}
+
+	ctx := c.Request.Context()
+	previous, err := findCurrentReceipt(ctx, inv.StripeInvoiceID, req.Type)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		receiptErrorResponse(c, err)
+		return
+	}
+	if previous != nil && previous.Addressee == addressee {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "現在の宛名と同じです"})
+		return
+	}
+
+	planNames, _ := planNamesByPriceID(ctx)
+	draft := newReceipt(*inv, req.Type, addressee, planNames, time.Now())
+	draft.UserID = userID
+	receipt, err := issueReceipt(ctx, draft, previous)
+	if err != nil {
+		receiptErrorResponse(c, err)
+		return
+	}
+
+	if previous != nil {
+		writeAuditLog(c, userID, AuditActionReceiptReissued, "receipt", receipt.Number, map[string]interface{}{
+			"stripe_invoice_id":  receipt.StripeInvoiceID,
+			"type":               receipt.Type,
+			"previous_number":    previous.Number,
+			"previous_addressee": previous.Addressee,
+			"addressee":          receipt.Addressee,
+		})
+	} else {
+		writeAuditLog(c, userID, AuditActionReceiptIssued, "receipt", receipt.Number, map[string]interface{}{
+			"stripe_invoice_id": receipt.StripeInvoiceID,
+			"type":              receipt.Type,
+			"addressee":         receipt.Addressee,
+		})
+	}
+	utils.LogInfoCtx(ctx, "Receipt", fmt.Sprintf("Issued %s %s for invoice %s", receipt.Type, receipt.Number, utils.MaskStripeID(receipt.StripeInvoiceID)))
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "再発行しました",
+		"receipt": receipt,
+	})
+}
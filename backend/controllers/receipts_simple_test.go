@@ -0,0 +1,110 @@
+package controllers
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"juice_academy_backend/services"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TestReceiptTaxBreakdown は税率ごとの対象額と消費税額の計算をテストする
+func TestReceiptTaxBreakdown(t *testing.T) {
+	// Stripe で税を計算していない場合は合計を10%の税込価格として1回だけ切り捨てる
+	breakdown := receiptTaxBreakdown(services.InvoiceRecord{Total: 980})
+	assert.Equal(t, []ReceiptTaxBreakdown{{Rate: 10, Amount: 980, Tax: 89}}, breakdown)
+
+	// Stripe の税額は税率ごとにまとめ、税抜の課税対象額に税額を足して税込にする
+	breakdown = receiptTaxBreakdown(services.InvoiceRecord{
+		Total: 3080,
+		TaxAmounts: []services.InvoiceTaxAmount{
+			{Rate: 10, Inclusive: true, TaxableAmount: 1000, Amount: 100},
+			{Rate: 8, Inclusive: true, TaxableAmount: 500, Amount: 40},
+			{Rate: 10, Inclusive: true, TaxableAmount: 1310, Amount: 130},
+		},
+	})
+	assert.Equal(t, []ReceiptTaxBreakdown{
+		{Rate: 10, Amount: 2540, Tax: 230},
+		{Rate: 8, Amount: 540, Tax: 40},
+	}, breakdown)
+}
+
+// TestNormalizeReceiptAddressee は宛名の検証をテストする
+func TestNormalizeReceiptAddressee(t *testing.T) {
+	addressee, err := normalizeReceiptAddressee("  株式会社ジュース  ")
+	require.NoError(t, err)
+	assert.Equal(t, "株式会社ジュース", addressee)
+
+	for _, input := range []string{"", "   ", "一行目\n二行目", strings.Repeat("あ", receiptAddresseeMaxLength+1)} {
+		_, err := normalizeReceiptAddressee(input)
+		assert.Error(t, err, input)
+	}
+}
+
+// TestNewReceipt は保存済みの請求書から帳票を作成する処理と PDF の出力をテストする
+func TestNewReceipt(t *testing.T) {
+	paidAt := time.Date(2026, 4, 1, 3, 0, 0, 0, time.UTC)
+	inv := services.InvoiceRecord{
+		StripeInvoiceID: "in_001",
+		Number:          "FAKE-0001",
+		Status:          "paid",
+		Currency:        "jpy",
+		Total:           980,
+		PaidAt:          &paidAt,
+		CreatedAt:       paidAt.Add(-time.Minute),
+		Lines:           []services.InvoiceLineRecord{{PriceID: "price_monthly", Description: "1 × 月額", Amount: 980}},
+	}
+	now := paidAt.Add(time.Hour)
+
+	receipt := newReceipt(inv, ReceiptTypeReceipt, "ケッサイ タロウ", map[string]string{"price_monthly": "月額プラン"}, now)
+	assert.True(t, receipt.Current)
+	assert.Equal(t, paidAt, receipt.TransactionDate, "支払日を取引年月日にすること")
+	assert.Equal(t, planInvoiceDescription("月額プラン"), receipt.Description)
+	require.Len(t, receipt.Lines, 1)
+	assert.Equal(t, ReceiptLine{Description: planInvoiceDescription("月額プラン"), Amount: 980, TaxRate: receiptDefaultTaxRate}, receipt.Lines[0])
+	assert.Equal(t, receiptIssuer, receipt.Issuer)
+
+	receipt.Number = "R-2026-000001"
+	out := renderReceiptPDF(receipt)
+	assert.True(t, bytes.HasPrefix(out, []byte("%PDF-")))
+	assert.Contains(t, string(out), "/Count 1")
+
+	// 明細が多い場合は改ページする
+	for i := 0; i < 40; i++ {
+		receipt.Lines = append(receipt.Lines, receipt.Lines[0])
+	}
+	reissueOf := primitive.NewObjectID()
+	receipt.ReissueOf = &reissueOf
+	assert.Contains(t, string(renderReceiptPDF(receipt)), "/Count 2")
+}
+
+// TestReceiptTypeAvailable は請求書の状態と設定による帳票の発行可否をテストする
+func TestReceiptTypeAvailable(t *testing.T) {
+	original := receiptIssuer
+	t.Cleanup(func() { receiptIssuer = original })
+
+	paid := services.InvoiceRecord{Status: "paid"}
+	open := services.InvoiceRecord{Status: "open"}
+
+	_, msg := receiptTypeAvailable(ReceiptTypeReceipt, paid)
+	assert.Empty(t, msg)
+	_, msg = receiptTypeAvailable(ReceiptTypeReceipt, open)
+	assert.NotEmpty(t, msg, "未払いの請求には領収書を発行しない")
+	_, msg = receiptTypeAvailable("quote", paid)
+	assert.NotEmpty(t, msg)
+
+	receiptIssuer.RegistrationNumber = ""
+	_, msg = receiptTypeAvailable(ReceiptTypeInvoice, paid)
+	assert.NotEmpty(t, msg, "登録番号がなければ適格請求書を発行しない")
+
+	receiptIssuer.RegistrationNumber = "T1234567890123"
+	_, msg = receiptTypeAvailable(ReceiptTypeInvoice, open)
+	assert.Empty(t, msg)
+	_, msg = receiptTypeAvailable(ReceiptTypeInvoice, services.InvoiceRecord{Status: "draft"})
+	assert.NotEmpty(t, msg)
+}
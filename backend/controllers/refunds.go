@@ -0,0 +1,395 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"juice_academy_backend/middleware"
+	"juice_academy_backend/services"
+	"juice_academy_backend/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stripe/stripe-go/v81"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// adjustmentNoteMaxLength は返金・クレジットノートのメモの最大文字数（Stripe のメタデータの上限に合わせる）
+const adjustmentNoteMaxLength = 500
+
+// adjustmentReason は返金・クレジットノートの理由
+// Stripe の理由は種類が少ないため、学内で使う理由コードを対応する Stripe の理由に変換する
+type adjustmentReason struct {
+	Code  string `json:"code"`
+	Label string `json:"label"`
+	// 空の場合は Stripe に理由を送らない
+	refund     stripe.RefundReason
+	creditNote stripe.CreditNoteReason
+}
+
+// adjustmentReasons は管理画面で選べる理由（表示順）
+var adjustmentReasons = []adjustmentReason{
+	{Code: "duplicate", Label: "二重請求", refund: stripe.RefundReasonDuplicate, creditNote: stripe.CreditNoteReasonDuplicate},
+	{Code: "billing_error", Label: "請求金額の誤り", refund: stripe.RefundReasonRequestedByCustomer, creditNote: stripe.CreditNoteReasonOrderChange},
+	{Code: "service_issue", Label: "サービスの不具合", refund: stripe.RefundReasonRequestedByCustomer, creditNote: stripe.CreditNoteReasonProductUnsatisfactory},
+	{Code: "withdrawal", Label: "退学・休学", refund: stripe.RefundReasonRequestedByCustomer, creditNote: stripe.CreditNoteReasonOrderChange},
+	{Code: "fraudulent", Label: "不正利用", refund: stripe.RefundReasonFraudulent, creditNote: stripe.CreditNoteReasonFraudulent},
+	{Code: "other", Label: "その他", refund: stripe.RefundReasonRequestedByCustomer},
+}
+
+// findAdjustmentReason は理由コードに対応する理由を返す
+func findAdjustmentReason(code string) (adjustmentReason, bool) {
+	for _, reason := range adjustmentReasons {
+		if reason.Code == code {
+			return reason, true
+		}
+	}
+	return adjustmentReason{}, false
+}
+
+// normalizeAdjustment は理由コードとメモ（必須）を検証する
+func normalizeAdjustment(code, note string) (adjustmentReason, string, error) {
+	reason, ok := findAdjustmentReason(strings.TrimSpace(code))
+	if !ok {
+		return reason, "", errors.New("理由を選択してください")
+	}
+	note = strings.TrimSpace(note)
+	if note == "" {
+		return reason, "", errors.New("メモを入力してください")
+	}
+	if len([]rune(note)) > adjustmentNoteMaxLength {
+		return reason, "", fmt.Errorf("メモは%d文字以内で入力してください", adjustmentNoteMaxLength)
+	}
+	return reason, note, nil
+}
+
+// adjustmentMetadata は Stripe に保存するメタデータ（Webhook で台帳に反映する際に理由と担当者を引き継ぐ）
+func adjustmentMetadata(reason adjustmentReason, note string, adminID primitive.ObjectID) map[string]string {
+	return map[string]string{
+		services.AdjustmentMetadataReasonCode: reason.Code,
+		services.AdjustmentMetadataNote:       note,
+		services.AdjustmentMetadataCreatedBy:  adminID.Hex(),
+	}
+}
+
+// refundRequest は返金のリクエスト
+type refundRequest struct {
+	// Amount を省略した場合は未返金の全額を返金する
+	Amount     *int64 `json:"amount"`
+	ReasonCode string `json:"reason_code"`
+	Note       string `json:"note"`
+}
+
+// creditNoteRequest はクレジットノート発行のリクエスト
+type creditNoteRequest struct {
+	Amount     int64  `json:"amount"`
+	ReasonCode string `json:"reason_code"`
+	Note       string `json:"note"`
+	// Refund は支払い済みの請求書で減額分を返金する場合に true（false の場合は顧客の残高に充当し、次回以降の請求から差し引く）
+	Refund bool `json:"refund"`
+}
+
+// refundableAmount は請求書の支払いのうち未返金の額
+func refundableAmount(inv services.InvoiceRecord) int64 {
+	if inv.Status != string(stripe.InvoiceStatusPaid) || inv.StripePaymentIntentID == "" {
+		return 0
+	}
+	if remaining := inv.AmountPaid - inv.AmountRefunded; remaining > 0 {
+		return remaining
+	}
+	return 0
+}
+
+// creditableAmount は請求書にクレジットノートで減額できる額
+// 未払いの請求書は残りの請求額、支払い済みの請求書は支払額のうちクレジットノート未発行の額
+func creditableAmount(inv services.InvoiceRecord) int64 {
+	var amount int64
+	switch stripe.InvoiceStatus(inv.Status) {
+	case stripe.InvoiceStatusOpen:
+		amount = inv.AmountRemaining
+	case stripe.InvoiceStatusPaid:
+		amount = inv.AmountPaid - inv.PostPaymentCreditNotesAmount
+	}
+	if amount < 0 {
+		return 0
+	}
+	return amount
+}
+
+// loadAdminInvoice は管理画面の操作対象の請求書を取得する
+func loadAdminInvoice(c *gin.Context) (*services.InvoiceRecord, bool) {
+	ctx := c.Request.Context()
+	var inv services.InvoiceRecord
+	err := invoiceCollection.FindOne(ctx, bson.M{"stripe_invoice_id": c.Param("id")}).Decode(&inv)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "請求書が見つかりません"})
+		return nil, false
+	}
+	if err != nil {
+		utils.LogErrorCtx(ctx, "AdminInvoice", err, "Failed to fetch invoice")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "請求書の取得に失敗しました"})
+		return nil, false
+	}
+	return &inv, true
+}
+
+// adjustmentErrorResponse は Stripe での返金・クレジットノート発行に失敗した場合のレスポンスを返す
+func adjustmentErrorResponse(c *gin.Context, err error, message string) {
+	utils.LogErrorCtx(c.Request.Context(), "AdminInvoice", err, message)
+	status := http.StatusInternalServerError
+	var stripeErr *stripe.Error
+	if errors.As(err, &stripeErr) && stripeErr.Type == stripe.ErrorTypeInvalidRequest {
+		status = http.StatusBadRequest
+	}
+	errMsg := message
+	if os.Getenv("APP_ENV") != "production" {
+		errMsg += ": " + err.Error()
+	}
+	c.JSON(status, gin.H{"error": errMsg})
+}
+
+// AdminListUserInvoicesHandler は利用者の請求書を返金・クレジットノートを含めて新しい順に返すハンドラ
+func AdminListUserInvoicesHandler(c *gin.Context) {
+	userID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "無効なユーザーIDです"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	invoices := []services.InvoiceRecord{}
+	var payment Payment
+	err = paymentCollection.FindOne(ctx, bson.M{"user_id": userID}).Decode(&payment)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		utils.LogErrorCtx(ctx, "AdminInvoice", err, "Failed to fetch payment info")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "請求書の取得に失敗しました"})
+		return
+	}
+	if err == nil && payment.StripeCustomerID != "" {
+		opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}).SetLimit(maxPaymentHistoryPageSize)
+		cursor, err := invoiceCollection.Find(ctx, bson.M{"stripe_customer_id": payment.StripeCustomerID}, opts)
+		if err == nil {
+			err = cursor.All(ctx, &invoices)
+		}
+		if err != nil {
+			utils.LogErrorCtx(ctx, "AdminInvoice", err, "Failed to fetch invoices")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "請求書の取得に失敗しました"})
+			return
+		}
+	}
+
+	entries := make([]gin.H, 0, len(invoices))
+	for _, inv := range invoices {
+		entries = append(entries, gin.H{
+			"invoice":           inv,
+			"refundable_amount": refundableAmount(inv),
+			"creditable_amount": creditableAmount(inv),
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"invoices": entries, "reasons": adjustmentReasons})
+}
+
+// AdminRefundInvoiceHandler は請求書の支払いを全額または一部返金するハンドラ
+func AdminRefundInvoiceHandler(c *gin.Context) {
+	var req refundRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "不正な入力データです"})
+		return
+	}
+	reason, note, err := normalizeAdjustment(req.ReasonCode, req.Note)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	inv, ok := loadAdminInvoice(c)
+	if !ok {
+		return
+	}
+	if inv.Status != string(stripe.InvoiceStatusPaid) || inv.StripePaymentIntentID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "支払い済みの請求書のみ返金できます"})
+		return
+	}
+	refundable := refundableAmount(*inv)
+	if refundable == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "この請求書は全額返金済みです"})
+		return
+	}
+	amount := refundable
+	if req.Amount != nil {
+		amount = *req.Amount
+	}
+	if amount < 1 || amount > refundable {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("返金額は1〜%s の範囲で指定してください", utils.FormatAmount(refundable, inv.Currency))})
+		return
+	}
+
+	ctx := c.Request.Context()
+	adminID, _ := middleware.CurrentUserID(c)
+	params := &stripe.RefundParams{
+		PaymentIntent: stripe.String(inv.StripePaymentIntentID),
+		Amount:        stripe.Int64(amount),
+		Reason:        stripe.String(string(reason.refund)),
+		Metadata:      adjustmentMetadata(reason, note, adminID),
+	}
+	// 二重送信で同じ返金を繰り返さない（保存済みの返金の件数を含め、続けて行う返金とは区別する）
+	params.SetIdempotencyKey(fmt.Sprintf("refund:%s:%d:%d", inv.StripeInvoiceID, len(inv.Refunds), amount))
+
+	refund, err := billing.CreateRefund(ctx, params)
+	if err != nil {
+		adjustmentErrorResponse(c, err, "返金に失敗しました")
+		return
+	}
+	if err := services.ApplyStripeRefund(ctx, invoiceCollection, refund); err != nil {
+		// Stripe 側は返金済みのため、refund.created の Webhook で反映される
+		utils.LogErrorCtx(ctx, "AdminInvoice", err, "Failed to save refund, but Stripe was updated")
+	}
+
+	writeAuditLog(c, adminID, AuditActionInvoiceRefunded, "invoice", inv.StripeInvoiceID, map[string]interface{}{
+		"refund_id":   refund.ID,
+		"amount":      refund.Amount,
+		"currency":    inv.Currency,
+		"full":        amount == refundable,
+		"reason_code": reason.Code,
+		"note":        note,
+	})
+	utils.LogInfoCtx(ctx, "AdminInvoice", fmt.Sprintf("Invoice %s refunded %d by %s", utils.MaskStripeID(inv.StripeInvoiceID), refund.Amount, adminID.Hex()))
+	c.JSON(http.StatusCreated, gin.H{"refund": services.NewInvoiceRefund(refund)})
+}
+
+// AdminCreateCreditNoteHandler は請求書にクレジットノートを発行するハンドラ
+// 未払いの請求書は請求額を減額し、支払い済みの請求書は減額分を返金するか顧客の残高に充当する
+func AdminCreateCreditNoteHandler(c *gin.Context) {
+	var req creditNoteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "不正な入力データです"})
+		return
+	}
+	reason, note, err := normalizeAdjustment(req.ReasonCode, req.Note)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	inv, ok := loadAdminInvoice(c)
+	if !ok {
+		return
+	}
+	paid := inv.Status == string(stripe.InvoiceStatusPaid)
+	if !paid && inv.Status != string(stripe.InvoiceStatusOpen) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "未払いまたは支払い済みの請求書のみクレジットノートを発行できます"})
+		return
+	}
+	if req.Refund && !paid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "未払いの請求書は返金できません（請求額の減額のみ発行できます）"})
+		return
+	}
+	creditable := creditableAmount(*inv)
+	if req.Refund && refundableAmount(*inv) < creditable {
+		creditable = refundableAmount(*inv)
+	}
+	if creditable == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "この請求書にはこれ以上クレジットノートを発行できません"})
+		return
+	}
+	if req.Amount < 1 || req.Amount > creditable {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("金額は1〜%s の範囲で指定してください", utils.FormatAmount(creditable, inv.Currency))})
+		return
+	}
+
+	ctx := c.Request.Context()
+	adminID, _ := middleware.CurrentUserID(c)
+	params := &stripe.CreditNoteParams{
+		Invoice: stripe.String(inv.StripeInvoiceID),
+		Amount:  stripe.Int64(req.Amount),
+		// メモはクレジットノートの PDF に載るため、利用者向けの理由のみを記載する
+		Memo:     stripe.String(reason.Label),
+		Metadata: adjustmentMetadata(reason, note, adminID),
+	}
+	if reason.creditNote != "" {
+		params.Reason = stripe.String(string(reason.creditNote))
+	}
+	switch {
+	case paid && req.Refund:
+		params.RefundAmount = stripe.Int64(req.Amount)
+	case paid:
+		params.CreditAmount = stripe.Int64(req.Amount)
+	}
+	params.SetIdempotencyKey(fmt.Sprintf("credit-note:%s:%d:%d:%t", inv.StripeInvoiceID, len(inv.CreditNotes), req.Amount, req.Refund))
+
+	cn, err := billing.CreateCreditNote(ctx, params)
+	if err != nil {
+		adjustmentErrorResponse(c, err, "クレジットノートの発行に失敗しました")
+		return
+	}
+	if err := services.ApplyStripeCreditNote(ctx, invoiceCollection, cn); err != nil {
+		// Stripe 側は発行済みのため、credit_note.created の Webhook で反映される
+		utils.LogErrorCtx(ctx, "AdminInvoice", err, "Failed to save credit note, but Stripe was updated")
+	}
+
+	writeAuditLog(c, adminID, AuditActionCreditNoteIssued, "invoice", inv.StripeInvoiceID, map[string]interface{}{
+		"credit_note_id": cn.ID,
+		"number":         cn.Number,
+		"amount":         cn.Amount,
+		"currency":       inv.Currency,
+		"refund":         req.Refund,
+		"reason_code":    reason.Code,
+		"note":           note,
+	})
+	utils.LogInfoCtx(ctx, "AdminInvoice", fmt.Sprintf("Credit note %s issued for invoice %s by %s", cn.Number, utils.MaskStripeID(inv.StripeInvoiceID), adminID.Hex()))
+	c.JSON(http.StatusCreated, gin.H{"credit_note": services.NewInvoiceCreditNote(cn)})
+}
+
+// processChargeRefunded はcharge.refundedの返金額を請求書に反映し、利用者に通知する
+func processChargeRefunded(ctx context.Context, event stripe.Event) {
+	var charge stripe.Charge
+	if err := json.Unmarshal(event.Data.Raw, &charge); err != nil {
+		utils.LogErrorCtx(ctx, "StripeWebhook", err, "Failed to parse charge data")
+		return
+	}
+
+	utils.LogInfoCtx(ctx, "StripeWebhook",
+		fmt.Sprintf("Charge refunded: %s, Refunded: %d/%d", utils.MaskStripeID(charge.ID), charge.AmountRefunded, charge.Amount))
+
+	notice, err := services.ApplyStripeChargeRefund(ctx, invoiceCollection, &charge)
+	if err != nil {
+		utils.LogErrorCtx(ctx, "StripeWebhook", err, "Failed to save refunded amount: "+utils.MaskStripeID(charge.ID))
+		return
+	}
+	if notice != nil {
+		services.NotifyRefund(ctx, invoiceCollection.Database(), *notice)
+	}
+}
+
+// processRefundLedger はrefund.*の返金を請求書に反映する
+func processRefundLedger(ctx context.Context, event stripe.Event) {
+	var refund stripe.Refund
+	if err := json.Unmarshal(event.Data.Raw, &refund); err != nil {
+		utils.LogErrorCtx(ctx, "StripeWebhook", err, "Failed to parse refund data")
+		return
+	}
+
+	if err := services.ApplyStripeRefund(ctx, invoiceCollection, &refund); err != nil {
+		utils.LogErrorCtx(ctx, "StripeWebhook", err, "Failed to save refund: "+utils.MaskStripeID(refund.ID))
+	}
+}
+
+// processCreditNoteLedger はcredit_note.*のクレジットノートを請求書に反映する
+func processCreditNoteLedger(ctx context.Context, event stripe.Event) {
+	var cn stripe.CreditNote
+	if err := json.Unmarshal(event.Data.Raw, &cn); err != nil {
+		utils.LogErrorCtx(ctx, "StripeWebhook", err, "Failed to parse credit note data")
+		return
+	}
+
+	if err := services.ApplyStripeCreditNote(ctx, invoiceCollection, &cn); err != nil {
+		utils.LogErrorCtx(ctx, "StripeWebhook", err, "Failed to save credit note: "+utils.MaskStripeID(cn.ID))
+	}
+}
@@ -0,0 +1,72 @@
+package controllers
+
+import (
+	"strings"
+	"testing"
+
+	"juice_academy_backend/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestNormalizeAdjustment は返金・クレジットノートの理由コードとメモの検証をテストする
+func TestNormalizeAdjustment(t *testing.T) {
+	reason, note, err := normalizeAdjustment(" duplicate ", "  二重に決済されたため  ")
+	require.NoError(t, err)
+	assert.Equal(t, "duplicate", reason.Code)
+	assert.Equal(t, "二重に決済されたため", note)
+
+	_, _, err = normalizeAdjustment("unknown", "メモ")
+	assert.Error(t, err, "未定義の理由コードは受け付けない")
+	_, _, err = normalizeAdjustment("other", "   ")
+	assert.Error(t, err, "メモは必須")
+	_, _, err = normalizeAdjustment("other", strings.Repeat("あ", adjustmentNoteMaxLength+1))
+	assert.Error(t, err)
+
+	// すべての理由に Stripe の返金理由を対応させる
+	for _, reason := range adjustmentReasons {
+		assert.NotEmpty(t, reason.refund, reason.Code)
+	}
+}
+
+// TestAdjustableAmount は請求書の状態による返金・クレジットノートの上限額をテストする
+func TestAdjustableAmount(t *testing.T) {
+	paid := services.InvoiceRecord{Status: "paid", StripePaymentIntentID: "pi_001", AmountPaid: 980, AmountRefunded: 300, PostPaymentCreditNotesAmount: 100}
+	assert.Equal(t, int64(680), refundableAmount(paid))
+	assert.Equal(t, int64(880), creditableAmount(paid))
+
+	open := services.InvoiceRecord{Status: "open", AmountDue: 980, AmountRemaining: 980}
+	assert.Equal(t, int64(0), refundableAmount(open), "未払いの請求は返金できない")
+	assert.Equal(t, int64(980), creditableAmount(open))
+
+	free := services.InvoiceRecord{Status: "paid"}
+	assert.Equal(t, int64(0), refundableAmount(free), "支払いのない0円の請求は返金できない")
+	assert.Equal(t, int64(0), creditableAmount(services.InvoiceRecord{Status: "void", AmountDue: 980}))
+}
+
+// TestPaymentHistoryEntryRefunded は返金済みの請求書の支払い履歴での表示をテストする
+func TestPaymentHistoryEntryRefunded(t *testing.T) {
+	inv := services.InvoiceRecord{
+		StripeInvoiceID: "in_refunded",
+		Status:          "paid",
+		AmountPaid:      980,
+		AmountRefunded:  300,
+		CreditNotes: []services.InvoiceCreditNote{
+			{Number: "FAKE-0001-CN-01", Status: "issued", Amount: 300, Note: "管理者のメモ"},
+			{Number: "FAKE-0001-CN-02", Status: "void", Amount: 100},
+		},
+	}
+	entry := paymentHistoryEntry(inv, nil)
+	assert.Equal(t, "partially_refunded", entry["status"])
+	assert.Equal(t, int64(300), entry["amount_refunded"])
+	creditNotes, ok := entry["credit_notes"].([]gin.H)
+	require.True(t, ok)
+	require.Len(t, creditNotes, 1, "無効にしたクレジットノートは表示しない")
+	assert.Equal(t, "FAKE-0001-CN-01", creditNotes[0]["number"])
+	assert.NotContains(t, creditNotes[0], "note", "管理者のメモは利用者に返さない")
+
+	inv.AmountRefunded = 980
+	assert.Equal(t, "refunded", paymentHistoryEntry(inv, nil)["status"])
+}
@@ -0,0 +1,281 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"juice_academy_backend/middleware"
+	"juice_academy_backend/utils"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var settingsCollection *mongo.Collection
+
+const (
+	registrationPolicyID       = "registration_policy"
+	registrationPolicyCacheTTL = 30 * time.Second
+)
+
+// 登録ポリシー違反のエラーコード
+const (
+	PolicyRegistrationClosed = "REGISTRATION_CLOSED"
+	PolicyInviteOnly         = "INVITE_ONLY"
+	PolicyInvalidStudentID   = "INVALID_STUDENT_ID"
+	PolicyEmailDomain        = "EMAIL_DOMAIN_NOT_ALLOWED"
+)
+
+// StudentIDRule はキャンパス・入学年度ごとの学籍番号の形式
+type StudentIDRule struct {
+	Campus    string `bson:"campus" json:"campus"`
+	EntryYear int    `bson:"entry_year,omitempty" json:"entry_year,omitempty"`
+	// Pattern は学籍番号全体に一致する正規表現（^ と $ は自動的に付与される）
+	Pattern string `bson:"pattern" json:"pattern"`
+
+	compiled *regexp.Regexp
+}
+
+// RegistrationPolicy はユーザー登録の条件（settings コレクションに1件だけ保存される）
+// 項目が空の場合はその条件による制限を行わない
+type RegistrationPolicy struct {
+	StudentIDRules      []StudentIDRule    `bson:"student_id_rules" json:"student_id_rules"`
+	AllowedEmailDomains []string           `bson:"allowed_email_domains" json:"allowed_email_domains"`
+	InviteOnly          bool               `bson:"invite_only" json:"invite_only"`
+	OpensAt             *time.Time         `bson:"opens_at,omitempty" json:"opens_at,omitempty"`
+	ClosesAt            *time.Time         `bson:"closes_at,omitempty" json:"closes_at,omitempty"`
+	UpdatedAt           time.Time          `bson:"updated_at" json:"updated_at"`
+	UpdatedBy           primitive.ObjectID `bson:"updated_by,omitempty" json:"updated_by,omitempty"`
+}
+
+// PolicyError は登録ポリシー違反を表す
+type PolicyError struct {
+	Code    string
+	Message string
+}
+
+func (e *PolicyError) Error() string {
+	return e.Message
+}
+
+var (
+	policyCacheMu     sync.Mutex
+	policyCache       *RegistrationPolicy
+	policyCacheLoaded time.Time
+)
+
+// InitSettingsCollection は設定コレクションを初期化する
+func InitSettingsCollection(client *mongo.Client) {
+	settingsCollection = client.Database("juice_academy").Collection("settings")
+}
+
+// normalize は入力値を正規化し、学籍番号の正規表現をコンパイルする
+func (p *RegistrationPolicy) normalize() error {
+	for i := range p.StudentIDRules {
+		rule := &p.StudentIDRules[i]
+		rule.Campus = strings.TrimSpace(rule.Campus)
+		if rule.Campus == "" {
+			return fmt.Errorf("学籍番号ルール%d: キャンパスを指定してください", i+1)
+		}
+		compiled, err := regexp.Compile("^(?:" + rule.Pattern + ")$")
+		if err != nil || rule.Pattern == "" {
+			return fmt.Errorf("学籍番号ルール%d: 正規表現が不正です", i+1)
+		}
+		rule.compiled = compiled
+	}
+
+	domains := make([]string, 0, len(p.AllowedEmailDomains))
+	for _, domain := range p.AllowedEmailDomains {
+		domain = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(domain), "@"))
+		if domain == "" || strings.ContainsAny(domain, "@ ") {
+			return fmt.Errorf("メールドメインが不正です: %q", domain)
+		}
+		domains = append(domains, domain)
+	}
+	p.AllowedEmailDomains = domains
+
+	if p.OpensAt != nil && p.ClosesAt != nil && !p.OpensAt.Before(*p.ClosesAt) {
+		return errors.New("受付開始日時は受付終了日時より前にしてください")
+	}
+	return nil
+}
+
+// matchStudentID は学籍番号に一致するルールを返す。ルールが未設定の場合は nil, true を返す
+func (p *RegistrationPolicy) matchStudentID(studentID string) (*StudentIDRule, bool) {
+	if len(p.StudentIDRules) == 0 {
+		return nil, true
+	}
+	for i := range p.StudentIDRules {
+		if p.StudentIDRules[i].compiled.MatchString(studentID) {
+			return &p.StudentIDRules[i], true
+		}
+	}
+	return nil, false
+}
+
+// emailDomainAllowed はメールアドレスのドメインが許可リストに含まれるかを返す
+func (p *RegistrationPolicy) emailDomainAllowed(email string) bool {
+	if len(p.AllowedEmailDomains) == 0 {
+		return true
+	}
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return false
+	}
+	domain := strings.ToLower(email[at+1:])
+	for _, allowed := range p.AllowedEmailDomains {
+		if domain == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// isOpen は受付期間内かどうかを返す
+func (p *RegistrationPolicy) isOpen(now time.Time) bool {
+	if p.OpensAt != nil && now.Before(*p.OpensAt) {
+		return false
+	}
+	if p.ClosesAt != nil && !now.Before(*p.ClosesAt) {
+		return false
+	}
+	return true
+}
+
+// checkIdentity は学籍番号とメールドメインを検証し、一致した学籍番号ルールを返す
+// 管理者による一括登録でも適用される
+func (p *RegistrationPolicy) checkIdentity(studentID, email string) (*StudentIDRule, error) {
+	rule, ok := p.matchStudentID(studentID)
+	if !ok {
+		return nil, &PolicyError{Code: PolicyInvalidStudentID, Message: "学籍番号の形式が正しくありません"}
+	}
+	if !p.emailDomainAllowed(email) {
+		return nil, &PolicyError{Code: PolicyEmailDomain, Message: "このメールアドレスのドメインでは登録できません"}
+	}
+	return rule, nil
+}
+
+// checkSelfRegistration は利用者自身による登録を検証する（受付期間・招待制を含む）
+func (p *RegistrationPolicy) checkSelfRegistration(now time.Time, studentID, email string) (*StudentIDRule, error) {
+	if p.InviteOnly {
+		return nil, &PolicyError{Code: PolicyInviteOnly, Message: "現在は招待されたユーザーのみ登録できます"}
+	}
+	if !p.isOpen(now) {
+		return nil, &PolicyError{Code: PolicyRegistrationClosed, Message: "現在は登録を受け付けていません"}
+	}
+	return p.checkIdentity(studentID, email)
+}
+
+// currentRegistrationPolicy は登録ポリシーを返す（複数インスタンス間の反映のため短時間だけキャッシュする）
+// 未設定の場合は制限なしのポリシーを返す
+func currentRegistrationPolicy(ctx context.Context) (*RegistrationPolicy, error) {
+	policyCacheMu.Lock()
+	defer policyCacheMu.Unlock()
+
+	if policyCache != nil && time.Since(policyCacheLoaded) < registrationPolicyCacheTTL {
+		return policyCache, nil
+	}
+
+	policy := &RegistrationPolicy{}
+	if settingsCollection != nil {
+		err := settingsCollection.FindOne(ctx, bson.M{"_id": registrationPolicyID}).Decode(policy)
+		if err != nil && err != mongo.ErrNoDocuments {
+			return nil, err
+		}
+	}
+	if err := policy.normalize(); err != nil {
+		return nil, err
+	}
+
+	policyCache = policy
+	policyCacheLoaded = time.Now()
+	return policy, nil
+}
+
+// respondPolicyError はポリシー違反をレスポンスに変換する
+func respondPolicyError(c *gin.Context, err error) {
+	var policyErr *PolicyError
+	if !errors.As(err, &policyErr) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "登録ポリシーの確認に失敗しました"})
+		return
+	}
+
+	status := http.StatusBadRequest
+	if policyErr.Code == PolicyRegistrationClosed || policyErr.Code == PolicyInviteOnly {
+		status = http.StatusForbidden
+	}
+	c.JSON(status, gin.H{"error": policyErr.Message, "code": policyErr.Code})
+}
+
+// GetRegistrationStatusHandler は登録画面向けに受付状況を返すハンドラ
+func GetRegistrationStatusHandler(c *gin.Context) {
+	policy, err := currentRegistrationPolicy(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "登録ポリシーの取得に失敗しました"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"open":                  policy.isOpen(time.Now()) && !policy.InviteOnly,
+		"invite_only":           policy.InviteOnly,
+		"opens_at":              policy.OpensAt,
+		"closes_at":             policy.ClosesAt,
+		"allowed_email_domains": policy.AllowedEmailDomains,
+	})
+}
+
+// GetRegistrationPolicyHandler は管理者向けに登録ポリシー全体を返すハンドラ
+func GetRegistrationPolicyHandler(c *gin.Context) {
+	policy, err := currentRegistrationPolicy(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "登録ポリシーの取得に失敗しました"})
+		return
+	}
+	c.JSON(http.StatusOK, policy)
+}
+
+// UpdateRegistrationPolicyHandler は登録ポリシーを更新するハンドラ（再起動なしで反映される）
+func UpdateRegistrationPolicyHandler(c *gin.Context) {
+	var policy RegistrationPolicy
+	if err := c.ShouldBindJSON(&policy); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "不正な入力データです"})
+		return
+	}
+	if err := policy.normalize(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	adminID, _ := middleware.CurrentUserID(c)
+	policy.UpdatedAt = time.Now()
+	policy.UpdatedBy = adminID
+
+	ctx := c.Request.Context()
+	_, err := settingsCollection.ReplaceOne(ctx,
+		bson.M{"_id": registrationPolicyID},
+		policy,
+		options.Replace().SetUpsert(true),
+	)
+	if err != nil {
+		utils.LogErrorCtx(ctx, "RegistrationPolicy", err, "Failed to save registration policy")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "登録ポリシーの保存に失敗しました"})
+		return
+	}
+
+	policyCacheMu.Lock()
+	policyCache = &policy
+	policyCacheLoaded = time.Now()
+	policyCacheMu.Unlock()
+
+	utils.LogInfoCtx(ctx, "RegistrationPolicy", "Registration policy updated by "+adminID.Hex())
+	c.JSON(http.StatusOK, policy)
+}
@@ -0,0 +1,112 @@
+package controllers
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestPolicy(t *testing.T, policy RegistrationPolicy) *RegistrationPolicy {
+	require.NoError(t, policy.normalize())
+	return &policy
+}
+
+// TestRegistrationPolicyIdentity は学籍番号ルールとメールドメインの判定をテストする
+func TestRegistrationPolicyIdentity(t *testing.T) {
+	policy := newTestPolicy(t, RegistrationPolicy{
+		StudentIDRules: []StudentIDRule{
+			{Campus: "tokyo", EntryYear: 2025, Pattern: `T25\d{4}`},
+			{Campus: "osaka", Pattern: `O\d{2}\d{4}`},
+		},
+		AllowedEmailDomains: []string{"@Example.ac.jp", " st.example.ac.jp "},
+	})
+
+	tests := []struct {
+		name         string
+		studentID    string
+		email        string
+		expectedCode string
+		expectedRule string
+	}{
+		{name: "東京キャンパス", studentID: "T251234", email: "a@example.ac.jp", expectedRule: "tokyo"},
+		{name: "大阪キャンパス", studentID: "O241234", email: "a@ST.example.ac.jp", expectedRule: "osaka"},
+		{name: "部分一致は不可", studentID: "XT251234", email: "a@example.ac.jp", expectedCode: PolicyInvalidStudentID},
+		{name: "桁数不足", studentID: "T25123", email: "a@example.ac.jp", expectedCode: PolicyInvalidStudentID},
+		{name: "許可されていないドメイン", studentID: "T251234", email: "a@gmail.com", expectedCode: PolicyEmailDomain},
+		{name: "サブドメインは個別に許可が必要", studentID: "T251234", email: "a@evil.example.ac.jp", expectedCode: PolicyEmailDomain},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := policy.checkIdentity(tt.studentID, tt.email)
+			if tt.expectedCode != "" {
+				var policyErr *PolicyError
+				require.True(t, errors.As(err, &policyErr))
+				assert.Equal(t, tt.expectedCode, policyErr.Code)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedRule, rule.Campus)
+		})
+	}
+}
+
+// TestRegistrationPolicySelfRegistration は受付期間と招待制の判定をテストする
+func TestRegistrationPolicySelfRegistration(t *testing.T) {
+	now := time.Date(2026, 4, 1, 12, 0, 0, 0, time.UTC)
+	opens := now.Add(-time.Hour)
+	closes := now.Add(time.Hour)
+
+	tests := []struct {
+		name         string
+		policy       RegistrationPolicy
+		now          time.Time
+		expectedCode string
+	}{
+		{name: "制限なし", policy: RegistrationPolicy{}, now: now},
+		{name: "受付期間内", policy: RegistrationPolicy{OpensAt: &opens, ClosesAt: &closes}, now: now},
+		{name: "受付開始前", policy: RegistrationPolicy{OpensAt: &opens}, now: opens.Add(-time.Second), expectedCode: PolicyRegistrationClosed},
+		{name: "受付終了時刻ちょうど", policy: RegistrationPolicy{ClosesAt: &closes}, now: closes, expectedCode: PolicyRegistrationClosed},
+		{name: "招待制", policy: RegistrationPolicy{InviteOnly: true}, now: now, expectedCode: PolicyInviteOnly},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := newTestPolicy(t, tt.policy)
+			_, err := policy.checkSelfRegistration(tt.now, "S0001", "a@example.com")
+			if tt.expectedCode == "" {
+				assert.NoError(t, err)
+				return
+			}
+			var policyErr *PolicyError
+			require.True(t, errors.As(err, &policyErr))
+			assert.Equal(t, tt.expectedCode, policyErr.Code)
+		})
+	}
+}
+
+// TestRegistrationPolicyNormalizeErrors は不正なポリシーが保存前に拒否されることをテストする
+func TestRegistrationPolicyNormalizeErrors(t *testing.T) {
+	opens := time.Now()
+	closes := opens.Add(-time.Hour)
+
+	tests := []struct {
+		name   string
+		policy RegistrationPolicy
+	}{
+		{name: "不正な正規表現", policy: RegistrationPolicy{StudentIDRules: []StudentIDRule{{Campus: "tokyo", Pattern: "T(25"}}}},
+		{name: "空の正規表現", policy: RegistrationPolicy{StudentIDRules: []StudentIDRule{{Campus: "tokyo"}}}},
+		{name: "キャンパス未指定", policy: RegistrationPolicy{StudentIDRules: []StudentIDRule{{Pattern: `T\d+`}}}},
+		{name: "不正なドメイン", policy: RegistrationPolicy{AllowedEmailDomains: []string{"user@example.com"}}},
+		{name: "受付期間の逆転", policy: RegistrationPolicy{OpensAt: &opens, ClosesAt: &closes}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Error(t, tt.policy.normalize())
+		})
+	}
+}
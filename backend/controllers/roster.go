@@ -0,0 +1,480 @@
+package controllers
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/mail"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"juice_academy_backend/middleware"
+	"juice_academy_backend/utils"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var invitationCollection *mongo.Collection
+
+const (
+	invitationDuration = 7 * 24 * time.Hour
+	maxRosterRows      = 5000
+	maxRosterFileSize  = 2 << 20
+)
+
+// 一括登録の行ごとの結果
+const (
+	RosterRowCreated     = "created"
+	RosterRowWouldCreate = "would_create"
+	RosterRowInvalid     = "invalid"
+	RosterRowDuplicate   = "duplicate"
+	RosterRowFailed      = "failed"
+)
+
+// Invitation は一括登録されたユーザーのパスワード設定用招待
+// 招待メールはワーカーが送信し、送信状態を EmailStatus に記録する
+type Invitation struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty"`
+	UserID         primitive.ObjectID `bson:"user_id"`
+	TokenHash      string             `bson:"token_hash"`
+	InvitedBy      primitive.ObjectID `bson:"invited_by,omitempty"`
+	ExpiresAt      time.Time          `bson:"expires_at"`
+	UsedAt         *time.Time         `bson:"used_at,omitempty"`
+	CreatedAt      time.Time          `bson:"created_at"`
+	EmailStatus    string             `bson:"email_status,omitempty"`
+	EmailAttempts  int                `bson:"email_attempts,omitempty"`
+	EmailError     string             `bson:"email_error,omitempty"`
+	EmailClaimedAt *time.Time         `bson:"email_claimed_at,omitempty"`
+	EmailRetryAt   *time.Time         `bson:"email_retry_at,omitempty"`
+	EmailSentAt    *time.Time         `bson:"email_sent_at,omitempty"`
+}
+
+// InitInvitationCollection は招待コレクションを初期化する
+func InitInvitationCollection(client *mongo.Client) {
+	invitationCollection = client.Database("juice_academy").Collection("invitations")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, _ = invitationCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "token_hash", Value: 1}},
+			Options: options.Index().SetUnique(true).SetName("token_hash_unique"),
+		},
+		{
+			Keys:    bson.D{{Key: "user_id", Value: 1}},
+			Options: options.Index().SetName("user_id_idx"),
+		},
+		{
+			Keys:    bson.D{{Key: "email_status", Value: 1}, {Key: "created_at", Value: 1}},
+			Options: options.Index().SetName("email_status_created_at_idx"),
+		},
+	})
+}
+
+// RosterRow は名簿CSVの1行
+type RosterRow struct {
+	Line      int
+	StudentID string
+	NameKana  string
+	Email     string
+}
+
+// RosterRowResult は1行ごとの取り込み結果
+// 招待メールは取り込み後にワーカーが送信するため、EmailStatus は送信待ち（queued）になる
+type RosterRowResult struct {
+	Line        int    `json:"line"`
+	StudentID   string `json:"student_id"`
+	Email       string `json:"email"`
+	Status      string `json:"status"`
+	Error       string `json:"error,omitempty"`
+	UserID      string `json:"user_id,omitempty"`
+	EmailStatus string `json:"email_status,omitempty"`
+}
+
+// RosterImportReport は名簿取り込み全体の結果
+type RosterImportReport struct {
+	DryRun  bool              `json:"dry_run"`
+	Total   int               `json:"total"`
+	Created int               `json:"created"`
+	Skipped int               `json:"skipped"`
+	Rows    []RosterRowResult `json:"rows"`
+}
+
+// RosterImportOptions は名簿取り込みのオプション
+type RosterImportOptions struct {
+	DryRun    bool
+	InvitedBy primitive.ObjectID
+}
+
+// parseRosterCSV は student_id,name_kana,email のヘッダーを持つCSVを読み込む
+// 列の順序は問わない。Excel が付与するBOMは取り除く
+func parseRosterCSV(r io.Reader) ([]RosterRow, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, errors.New("CSVが空です")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("CSVの読み込みに失敗しました: %w", err)
+	}
+
+	columns := map[string]int{}
+	for i, name := range header {
+		name = strings.TrimPrefix(strings.TrimSpace(name), "\ufeff")
+		columns[strings.ToLower(name)] = i
+	}
+	for _, required := range []string{"student_id", "name_kana", "email"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("ヘッダーに %s 列がありません", required)
+		}
+	}
+
+	field := func(record []string, name string) string {
+		if i := columns[name]; i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	var rows []RosterRow
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("CSVの読み込みに失敗しました: %w", err)
+		}
+		if len(record) == 1 && strings.TrimSpace(record[0]) == "" {
+			continue
+		}
+		// 空行は csv.Reader が読み飛ばすため、元ファイルの行番号を取得する
+		line, _ := reader.FieldPos(0)
+		rows = append(rows, RosterRow{
+			Line:      line,
+			StudentID: field(record, "student_id"),
+			NameKana:  field(record, "name_kana"),
+			Email:     strings.ToLower(field(record, "email")),
+		})
+		if len(rows) > maxRosterRows {
+			return nil, fmt.Errorf("一度に取り込めるのは%d行までです", maxRosterRows)
+		}
+	}
+	return rows, nil
+}
+
+// validateRosterRow は1行分の入力を登録ポリシーとあわせて検証し、一致した学籍番号ルールを返す
+func validateRosterRow(row RosterRow, policy *RegistrationPolicy) (*StudentIDRule, string) {
+	if row.StudentID == "" {
+		return nil, "学籍番号が空です"
+	}
+	if !validateNameKana(row.NameKana) {
+		return nil, "氏名（カナ）はカタカナのみで入力してください"
+	}
+	addr, err := mail.ParseAddress(row.Email)
+	if err != nil || addr.Address != row.Email {
+		return nil, "メールアドレスの形式が正しくありません"
+	}
+	rule, err := policy.checkIdentity(row.StudentID, row.Email)
+	if err != nil {
+		return nil, err.Error()
+	}
+	return rule, ""
+}
+
+// ImportRoster は名簿CSVを検証し、仮登録ユーザーの作成と招待メールの送信予約を行う
+// DryRun の場合は検証と重複確認のみを行い、データベースは変更しない
+func ImportRoster(ctx context.Context, r io.Reader, opts RosterImportOptions) (*RosterImportReport, error) {
+	rows, err := parseRosterCSV(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if !opts.DryRun && os.Getenv("INVITATION_ACCEPT_URL") == "" {
+		return nil, errors.New("INVITATION_ACCEPT_URL が設定されていません")
+	}
+
+	// 招待制・受付期間は利用者自身の登録にのみ適用し、学籍番号形式とメールドメインは一括登録にも適用する
+	policy, err := currentRegistrationPolicy(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &RosterImportReport{DryRun: opts.DryRun, Total: len(rows)}
+	seenStudentIDs := map[string]int{}
+	seenEmails := map[string]int{}
+
+	for _, row := range rows {
+		result := RosterRowResult{Line: row.Line, StudentID: row.StudentID, Email: row.Email}
+
+		rule, msg := validateRosterRow(row, policy)
+		if msg != "" {
+			result.Status, result.Error = RosterRowInvalid, msg
+		} else if line, ok := seenStudentIDs[row.StudentID]; ok {
+			result.Status, result.Error = RosterRowDuplicate, fmt.Sprintf("%d行目と学籍番号が重複しています", line)
+		} else if line, ok := seenEmails[row.Email]; ok {
+			result.Status, result.Error = RosterRowDuplicate, fmt.Sprintf("%d行目とメールアドレスが重複しています", line)
+		} else if exists, err := userExists(ctx, row.Email, row.StudentID); err != nil {
+			return nil, err
+		} else if exists {
+			result.Status, result.Error = RosterRowDuplicate, "既に登録済みのメールアドレスまたは学籍番号です"
+		} else if opts.DryRun {
+			result.Status = RosterRowWouldCreate
+		} else {
+			createRosterUser(ctx, row, rule, opts, &result)
+		}
+
+		if result.Status != RosterRowInvalid {
+			seenStudentIDs[row.StudentID] = row.Line
+			seenEmails[row.Email] = row.Line
+		}
+		if result.Status == RosterRowCreated || result.Status == RosterRowWouldCreate {
+			report.Created++
+		} else {
+			report.Skipped++
+		}
+		report.Rows = append(report.Rows, result)
+	}
+
+	if report.Created > 0 && !opts.DryRun {
+		wakeInvitationEmailWorker()
+	}
+	return report, nil
+}
+
+// createRosterUser は仮登録ユーザーと招待を作成する（招待メールはワーカーが送信する）
+func createRosterUser(ctx context.Context, row RosterRow, rule *StudentIDRule, opts RosterImportOptions, result *RosterRowResult) {
+	now := time.Now()
+	user := User{
+		Role:      "student",
+		StudentID: row.StudentID,
+		NameKana:  row.NameKana,
+		Email:     row.Email,
+		CreatedAt: now,
+		UpdatedAt: now,
+		Status:    "pending",
+	}
+	if rule != nil {
+		user.Campus = rule.Campus
+		user.EntryYear = rule.EntryYear
+	}
+
+	inserted, err := userCollection.InsertOne(ctx, user)
+	if err != nil {
+		result.Status = RosterRowFailed
+		if mongo.IsDuplicateKeyError(err) {
+			result.Status = RosterRowDuplicate
+		}
+		result.Error = "ユーザーの作成に失敗しました"
+		return
+	}
+	userID := inserted.InsertedID.(primitive.ObjectID)
+	result.Status = RosterRowCreated
+	result.UserID = userID.Hex()
+
+	if err := queueInvitation(ctx, userID, opts.InvitedBy); err != nil {
+		// ユーザーは作成済みのため、招待メールの再送で招待を作り直せる
+		utils.LogErrorCtx(ctx, "RosterImport", err, "Failed to create invitation for user "+userID.Hex())
+		result.Error = "招待の作成に失敗しました。招待メールを再送してください"
+		return
+	}
+	result.EmailStatus = InvitationEmailQueued
+}
+
+// queueInvitation は招待を作成し、招待メールを送信待ちにする
+// メールに載せるトークンは送信時にワーカーが発行するため、それまでは誰も知らないトークンのハッシュを保存しておく
+func queueInvitation(ctx context.Context, userID, invitedBy primitive.ObjectID) error {
+	if invitationCollection == nil {
+		return errors.New("invitation collection is not initialized")
+	}
+
+	placeholder, err := generateSecureToken(32)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	_, err = invitationCollection.InsertOne(ctx, Invitation{
+		UserID:      userID,
+		TokenHash:   hashToken(placeholder),
+		InvitedBy:   invitedBy,
+		ExpiresAt:   now.Add(invitationDuration),
+		CreatedAt:   now,
+		EmailStatus: InvitationEmailQueued,
+	})
+	return err
+}
+
+// ImportRosterHandler は管理者が名簿CSVをアップロードして学生を一括登録するハンドラ
+// multipart の file フィールドでCSVを受け取り、dry_run=true の場合は検証結果のみを返す
+func ImportRosterHandler(c *gin.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxRosterFileSize+1024)
+
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "CSVファイルを指定してください"})
+		return
+	}
+	if fileHeader.Size > maxRosterFileSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "ファイルサイズが大きすぎます"})
+		return
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ファイルを読み込めません"})
+		return
+	}
+	defer file.Close()
+
+	dryRun, _ := strconv.ParseBool(c.DefaultQuery("dry_run", c.PostForm("dry_run")))
+	adminID, _ := middleware.CurrentUserID(c)
+
+	ctx := c.Request.Context()
+	report, err := ImportRoster(ctx, file, RosterImportOptions{DryRun: dryRun, InvitedBy: adminID})
+	if err != nil {
+		utils.LogErrorCtx(ctx, "RosterImport", err, "Roster import failed")
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !dryRun {
+		utils.LogInfoCtx(ctx, "RosterImport", fmt.Sprintf("Imported roster: admin=%s created=%d skipped=%d", adminID.Hex(), report.Created, report.Skipped))
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+// ResendInvitationHandler は仮登録のままのユーザーに招待メールを再送するハンドラ
+// 未使用の招待は無効にし、新しい有効期限の招待を作成する
+func ResendInvitationHandler(c *gin.Context) {
+	userID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "無効なユーザーIDです"})
+		return
+	}
+	if os.Getenv("INVITATION_ACCEPT_URL") == "" {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "INVITATION_ACCEPT_URL が設定されていません"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	var user User
+	err = userCollection.FindOne(ctx, bson.M{"_id": userID}).Decode(&user)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "ユーザーが見つかりません"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "ユーザー情報の取得に失敗しました"})
+		return
+	}
+	if user.Status != "pending" {
+		c.JSON(http.StatusConflict, gin.H{"error": "このアカウントは既に有効化されています"})
+		return
+	}
+
+	now := time.Now()
+	if _, err := invitationCollection.UpdateMany(ctx,
+		bson.M{"user_id": userID, "used_at": bson.M{"$exists": false}, "expires_at": bson.M{"$gt": now}},
+		bson.M{"$set": bson.M{"expires_at": now}},
+	); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "招待の更新に失敗しました"})
+		return
+	}
+
+	adminID, _ := middleware.CurrentUserID(c)
+	if err := queueInvitation(ctx, userID, adminID); err != nil {
+		utils.LogErrorCtx(ctx, "RosterImport", err, "Failed to create invitation for user "+userID.Hex())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "招待の作成に失敗しました"})
+		return
+	}
+	wakeInvitationEmailWorker()
+
+	writeAuditLog(c, adminID, AuditActionInvitationResent, "user", userID.Hex(), map[string]interface{}{
+		"email": user.Email,
+	})
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message":      "招待メールの再送を受け付けました",
+		"userId":       userID.Hex(),
+		"email_status": InvitationEmailQueued,
+	})
+}
+
+// AcceptInvitationHandler は招待リンクからパスワードを設定し、アカウントを有効化するハンドラ
+func AcceptInvitationHandler(c *gin.Context) {
+	var req struct {
+		Token    string `json:"token" binding:"required"`
+		Password string `json:"password" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "不正な入力データです"})
+		return
+	}
+
+	if !validatePassword(req.Password) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "パスワードは8文字以上で、英字の大文字・小文字・数字をすべて含む必要があります"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	var invitation Invitation
+	err := invitationCollection.FindOne(ctx, bson.M{
+		"token_hash": hashToken(req.Token),
+		"used_at":    bson.M{"$exists": false},
+		"expires_at": bson.M{"$gt": time.Now()},
+	}).Decode(&invitation)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "招待リンクが無効か、有効期限が切れています"})
+		return
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "パスワード処理エラー"})
+		return
+	}
+
+	// 招待を先に使用済みにして、同じリンクでの二重設定を防ぐ
+	now := time.Now()
+	result, err := invitationCollection.UpdateOne(ctx,
+		bson.M{"_id": invitation.ID, "used_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"used_at": now}},
+	)
+	if err != nil || result.MatchedCount == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "招待リンクが無効か、有効期限が切れています"})
+		return
+	}
+
+	updated, err := userCollection.UpdateOne(ctx,
+		bson.M{"_id": invitation.UserID, "status": "pending"},
+		bson.M{
+			"$set":   bson.M{"password_hash": string(hashedPassword), "updated_at": now},
+			"$unset": bson.M{"status": ""},
+		},
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "パスワードの設定に失敗しました"})
+		return
+	}
+	if updated.MatchedCount == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "このアカウントは既に有効化されています"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "パスワードを設定しました。ログインしてください。"})
+}
@@ -0,0 +1,73 @@
+package controllers
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestParseRosterCSV は名簿CSVの読み込みをテストする
+func TestParseRosterCSV(t *testing.T) {
+	csvData := "\ufeffEmail,student_id,name_kana\n" +
+		"Yamada@Example.ac.jp, S0001 ,ヤマダ タロウ\n" +
+		"\n" +
+		"suzuki@example.ac.jp,S0002,スズキ ハナコ\n"
+
+	rows, err := parseRosterCSV(strings.NewReader(csvData))
+	require.NoError(t, err)
+	require.Len(t, rows, 2, "空行は無視されること")
+
+	assert.Equal(t, RosterRow{Line: 2, StudentID: "S0001", NameKana: "ヤマダ タロウ", Email: "yamada@example.ac.jp"}, rows[0],
+		"BOM付きヘッダー・列の並び替え・前後の空白・大文字のメールアドレスを正規化すること")
+	assert.Equal(t, 4, rows[1].Line, "行番号は元のCSVの行番号であること")
+}
+
+// TestParseRosterCSVErrors はヘッダー不備のCSVが拒否されることをテストする
+func TestParseRosterCSVErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		csvData string
+	}{
+		{name: "空のファイル", csvData: ""},
+		{name: "email列がない", csvData: "student_id,name_kana\nS0001,ヤマダ\n"},
+		{name: "引用符の不整合", csvData: "student_id,name_kana,email\n\"S0001,ヤマダ,a@example.com\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseRosterCSV(strings.NewReader(tt.csvData))
+			assert.Error(t, err)
+		})
+	}
+}
+
+// TestValidateRosterRow は行ごとの検証をテストする
+func TestValidateRosterRow(t *testing.T) {
+	tests := []struct {
+		name    string
+		row     RosterRow
+		isValid bool
+	}{
+		{name: "正常な行", row: RosterRow{StudentID: "S0001", NameKana: "ヤマダ タロウ", Email: "yamada@example.ac.jp"}, isValid: true},
+		{name: "学籍番号が空", row: RosterRow{NameKana: "ヤマダ", Email: "yamada@example.ac.jp"}, isValid: false},
+		{name: "氏名が漢字", row: RosterRow{StudentID: "S0001", NameKana: "山田太郎", Email: "yamada@example.ac.jp"}, isValid: false},
+		{name: "メールアドレスの形式不正", row: RosterRow{StudentID: "S0001", NameKana: "ヤマダ", Email: "yamada@"}, isValid: false},
+		{name: "表示名付きのメールアドレス", row: RosterRow{StudentID: "S0001", NameKana: "ヤマダ", Email: "Yamada <yamada@example.ac.jp>"}, isValid: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, msg := validateRosterRow(tt.row, &RegistrationPolicy{})
+			assert.Equal(t, tt.isValid, msg == "")
+		})
+	}
+}
+
+// TestResendInvitationHandlerInvalidID は不正なユーザーIDでの招待の再送を拒否することをテストする
+func TestResendInvitationHandlerInvalidID(t *testing.T) {
+	w := makeRequest("POST", "/api/admin/users/not-an-id/invitation", nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
@@ -0,0 +1,254 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"juice_academy_backend/middleware"
+	"juice_academy_backend/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stripe/stripe-go/v81"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// subscriptionPauseMinDuration は1回の休止の最短期間
+	subscriptionPauseMinDuration = 7 * 24 * time.Hour
+	// subscriptionPauseWindow は休止日数の上限を数える期間（直近1年間）
+	subscriptionPauseWindow = 365 * 24 * time.Hour
+)
+
+// subscriptionPauseMaxDuration は直近1年間に休止できる合計期間（SUBSCRIPTION_PAUSE_MAX_DAYS で変更できる）
+var subscriptionPauseMaxDuration = 90 * 24 * time.Hour
+
+func init() {
+	if days := os.Getenv("SUBSCRIPTION_PAUSE_MAX_DAYS"); days != "" {
+		if parsed, err := strconv.Atoi(days); err == nil && parsed > 0 {
+			subscriptionPauseMaxDuration = time.Duration(parsed) * 24 * time.Hour
+		}
+	}
+}
+
+// SubscriptionPause は休止の履歴1件。EndedAt は再開した日時（休止中は再開予定日時）
+type SubscriptionPause struct {
+	StartedAt time.Time `bson:"started_at" json:"started_at"`
+	EndedAt   time.Time `bson:"ended_at" json:"ended_at"`
+}
+
+// subscriptionPauseAllowance は now の時点で新たに休止できる残りの期間を返す
+// 直近1年間に重なる休止（休止中のものは再開予定日時まで）の合計を上限から差し引く
+func subscriptionPauseAllowance(history []SubscriptionPause, now time.Time) time.Duration {
+	windowStart := now.Add(-subscriptionPauseWindow)
+	var used time.Duration
+	for _, pause := range history {
+		start := pause.StartedAt
+		if start.Before(windowStart) {
+			start = windowStart
+		}
+		if pause.EndedAt.After(start) {
+			used += pause.EndedAt.Sub(start)
+		}
+	}
+	if used >= subscriptionPauseMaxDuration {
+		return 0
+	}
+	return subscriptionPauseMaxDuration - used
+}
+
+// PauseSubscriptionHandler はサブスクリプションを休止するハンドラ（休学中の学生向け）
+// Stripe の pause_collection で支払いを一時停止し、休止中の請求は無効（void）にする
+// resumesAt を省略した場合は、直近1年間に休止できる上限まで休止する
+func PauseSubscriptionHandler(c *gin.Context) {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "認証が必要です"})
+		return
+	}
+
+	var req struct {
+		ResumesAt *time.Time `json:"resumesAt"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "不正な入力データです"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	var sub Subscription
+	if err := subscriptionCollection.FindOne(ctx, bson.M{"user_id": userID}).Decode(&sub); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "サブスクリプション情報が見つかりません"})
+		return
+	}
+	if sub.Status != "active" || sub.StripeSubscriptionID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "有効なサブスクリプションがありません"})
+		return
+	}
+	if sub.PausedAt != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "すでに休止中です"})
+		return
+	}
+	if sub.CancelAtPeriodEnd {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "解約予約中は休止できません"})
+		return
+	}
+
+	now := time.Now()
+	allowance := subscriptionPauseAllowance(sub.PauseHistory, now)
+	if allowance < subscriptionPauseMinDuration {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("1年間に休止できる日数（%d日）を使い切っています", int(subscriptionPauseMaxDuration.Hours()/24)),
+		})
+		return
+	}
+	resumesAt := now.Add(allowance)
+	if req.ResumesAt != nil {
+		resumesAt = *req.ResumesAt
+	}
+	if resumesAt.Sub(now) < subscriptionPauseMinDuration {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("休止期間は%d日以上にしてください", int(subscriptionPauseMinDuration.Hours()/24)),
+		})
+		return
+	}
+	if resumesAt.Sub(now) > allowance {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("休止できるのはあと%d日までです", int(allowance.Hours()/24)),
+		})
+		return
+	}
+
+	// 休止の記録を先に確保し、同時に受けた休止リクエストが両方とも Stripe に送られないようにする
+	pause := SubscriptionPause{StartedAt: now, EndedAt: resumesAt}
+	claimed, err := subscriptionCollection.UpdateOne(ctx,
+		bson.M{"_id": sub.ID, "status": "active", "paused_at": nil, "cancel_at_period_end": bson.M{"$ne": true}},
+		bson.M{
+			"$set": bson.M{
+				"paused_at":        now,
+				"pause_resumes_at": resumesAt,
+				"updated_at":       now,
+			},
+			"$push": bson.M{"pause_history": pause},
+		},
+	)
+	if err != nil {
+		utils.LogErrorCtx(ctx, "PauseSubscription", err, "Failed to save pause state")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "サブスクリプションの休止に失敗しました"})
+		return
+	}
+	if claimed.MatchedCount == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "すでに休止中です"})
+		return
+	}
+
+	params := &stripe.SubscriptionParams{
+		PauseCollection: &stripe.SubscriptionPauseCollectionParams{
+			Behavior:  stripe.String(string(stripe.SubscriptionPauseCollectionBehaviorVoid)),
+			ResumesAt: stripe.Int64(resumesAt.Unix()),
+		},
+	}
+	if _, err := billing.UpdateSubscription(ctx, sub.StripeSubscriptionID, params); err != nil {
+		utils.LogErrorCtx(ctx, "PauseSubscription", err, "Failed to pause subscription in Stripe")
+		// Stripe 側は休止していないため、確保した休止の記録を取り消す
+		if _, rollbackErr := subscriptionCollection.UpdateOne(ctx,
+			bson.M{"_id": sub.ID, "paused_at": now},
+			bson.M{
+				"$unset": bson.M{"paused_at": "", "pause_resumes_at": ""},
+				"$pull":  bson.M{"pause_history": bson.M{"started_at": now}},
+			},
+		); rollbackErr != nil {
+			utils.LogErrorCtx(ctx, "PauseSubscription", rollbackErr, "Failed to roll back pause state")
+		}
+		errMsg := "サブスクリプションの休止に失敗しました"
+		if os.Getenv("APP_ENV") != "production" {
+			errMsg += ": " + err.Error()
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": errMsg})
+		return
+	}
+	invalidateEntitlements(ctx, userID)
+
+	writeAuditLog(c, userID, AuditActionSubscriptionPaused, "subscription", sub.StripeSubscriptionID, map[string]interface{}{
+		"resumes_at": resumesAt,
+	})
+	utils.LogInfoCtx(ctx, "PauseSubscription", fmt.Sprintf("Paused subscription %s until %s", sub.StripeSubscriptionID, resumesAt.Format(time.RFC3339)))
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":              "サブスクリプションを休止しました",
+		"paused_at":            now,
+		"pause_resumes_at":     resumesAt,
+		"pause_days_remaining": int((allowance - resumesAt.Sub(now)).Hours() / 24),
+	})
+}
+
+// ResumeSubscriptionHandler は休止中のサブスクリプションを再開予定日時より前に再開するハンドラ
+// 休止の履歴は再開した日時で締め、残りの日数は次の休止に使える
+func ResumeSubscriptionHandler(c *gin.Context) {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "認証が必要です"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	var sub Subscription
+	if err := subscriptionCollection.FindOne(ctx, bson.M{"user_id": userID}).Decode(&sub); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "サブスクリプション情報が見つかりません"})
+		return
+	}
+	if sub.PausedAt == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "休止中ではありません"})
+		return
+	}
+	if sub.SuspendedByDisputeID != "" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "お支払いに関する確認のため、ご利用を停止しています。お問い合わせください"})
+		return
+	}
+
+	// pause_collection に空文字列を指定すると一時停止を解除する
+	params := &stripe.SubscriptionParams{}
+	params.AddExtra("pause_collection", "")
+	updated, err := billing.UpdateSubscription(ctx, sub.StripeSubscriptionID, params)
+	if err != nil {
+		utils.LogErrorCtx(ctx, "ResumeSubscription", err, "Failed to resume subscription in Stripe")
+		errMsg := "サブスクリプションの再開に失敗しました"
+		if os.Getenv("APP_ENV") != "production" {
+			errMsg += ": " + err.Error()
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": errMsg})
+		return
+	}
+
+	now := time.Now()
+	update := bson.M{
+		"$set":   bson.M{"pause_history.$[open].ended_at": now, "updated_at": now},
+		"$unset": bson.M{"paused_at": "", "pause_resumes_at": ""},
+	}
+	opts := options.Update().SetArrayFilters(options.ArrayFilters{
+		Filters: []interface{}{bson.M{"open.ended_at": bson.M{"$gt": now}}},
+	})
+	if _, err := subscriptionCollection.UpdateByID(ctx, sub.ID, update, opts); err != nil {
+		utils.LogErrorCtx(ctx, "ResumeSubscription", err, "Failed to save resume state")
+	}
+	invalidateEntitlements(ctx, userID)
+
+	writeAuditLog(c, userID, AuditActionSubscriptionResumed, "subscription", sub.StripeSubscriptionID, map[string]interface{}{
+		"paused_at": sub.PausedAt,
+	})
+	utils.LogInfoCtx(ctx, "ResumeSubscription", "Resumed subscription "+sub.StripeSubscriptionID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "サブスクリプションを再開しました",
+		"subscription": gin.H{
+			"id":                 updated.ID,
+			"status":             updated.Status,
+			"current_period_end": time.Unix(updated.CurrentPeriodEnd, 0),
+		},
+	})
+}
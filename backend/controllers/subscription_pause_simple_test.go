@@ -0,0 +1,78 @@
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestSubscriptionPauseAllowance は直近1年間に休止できる残りの期間の計算をテストする
+func TestSubscriptionPauseAllowance(t *testing.T) {
+	now := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+
+	tests := []struct {
+		name     string
+		history  []SubscriptionPause
+		expected time.Duration
+	}{
+		{name: "休止したことがない", expected: subscriptionPauseMaxDuration},
+		{
+			name:     "直近1年間の休止を差し引く",
+			history:  []SubscriptionPause{{StartedAt: now.AddDate(0, -3, 0), EndedAt: now.AddDate(0, -3, 0).Add(30 * day)}},
+			expected: subscriptionPauseMaxDuration - 30*day,
+		},
+		{
+			name:     "1年より前の休止は数えない",
+			history:  []SubscriptionPause{{StartedAt: now.Add(-400 * day), EndedAt: now.Add(-370 * day)}},
+			expected: subscriptionPauseMaxDuration,
+		},
+		{
+			name:     "1年の境界をまたぐ休止は重なる部分だけ数える",
+			history:  []SubscriptionPause{{StartedAt: now.Add(-375 * day), EndedAt: now.Add(-355 * day)}},
+			expected: subscriptionPauseMaxDuration - 10*day,
+		},
+		{
+			name: "上限を超えた場合は0",
+			history: []SubscriptionPause{
+				{StartedAt: now.Add(-200 * day), EndedAt: now.Add(-140 * day)},
+				{StartedAt: now.Add(-100 * day), EndedAt: now.Add(-40 * day)},
+			},
+			expected: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, subscriptionPauseAllowance(tt.history, now))
+		})
+	}
+}
+
+// TestSubscriptionGrantsAccess はサブスクリプションの状態による利用可否の判定をテストする
+func TestSubscriptionGrantsAccess(t *testing.T) {
+	now := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	pausedAt := now.AddDate(0, 0, -3)
+	pastDueAt := now.AddDate(0, 0, -1)
+	overdueAt := now.Add(-subscriptionGracePeriod)
+
+	tests := []struct {
+		name     string
+		sub      Subscription
+		expected bool
+	}{
+		{name: "契約中", sub: Subscription{Status: "active", CurrentPeriodEnd: now.AddDate(0, 1, 0)}, expected: true},
+		{name: "トライアル中", sub: Subscription{Status: "trialing", CurrentPeriodEnd: now.AddDate(0, 0, 7)}, expected: true},
+		{name: "支払い遅延", sub: Subscription{Status: "past_due", CurrentPeriodEnd: now.AddDate(0, 1, 0)}},
+		{name: "支払い遅延で猶予期間内", sub: Subscription{Status: "past_due", CurrentPeriodEnd: now.AddDate(0, 1, 0), PastDueAt: &pastDueAt}, expected: true},
+		{name: "支払い遅延で猶予期間後", sub: Subscription{Status: "past_due", CurrentPeriodEnd: now.AddDate(0, 1, 0), PastDueAt: &overdueAt}},
+		{name: "休止中", sub: Subscription{Status: "active", CurrentPeriodEnd: now.AddDate(0, 1, 0), PausedAt: &pausedAt}},
+		{name: "解約予約中で期間内", sub: Subscription{Status: "active", CurrentPeriodEnd: now.AddDate(0, 0, 1), CancelAtPeriodEnd: true}, expected: true},
+		{name: "解約予約中で期間終了後", sub: Subscription{Status: "active", CurrentPeriodEnd: now.AddDate(0, 0, -1), CancelAtPeriodEnd: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, subscriptionGrantsAccess(tt.sub, now))
+		})
+	}
+}
@@ -0,0 +1,486 @@
+package controllers
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"juice_academy_backend/middleware"
+	"juice_academy_backend/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stripe/stripe-go/v81"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// プラン変更の種類と反映時期
+const (
+	PlanChangeUpgrade   = "upgrade"
+	PlanChangeDowngrade = "downgrade"
+
+	PlanChangeImmediate = "immediate"
+	PlanChangePeriodEnd = "period_end"
+)
+
+// planChangeQuoteTTL はプレビューで返した精算の基準日時を変更のリクエストで使える期間
+// 過ぎた場合は変更時の日時で精算する
+const planChangeQuoteTTL = 10 * time.Minute
+
+// planChangeQuotePurpose は精算の基準日時の署名を他の用途の署名と区別する
+const planChangeQuotePurpose = "plan-change-proration:"
+
+// planChangeContext はプラン変更の対象（検証済みのサブスクリプションと変更先のプラン）
+type planChangeContext struct {
+	userID    primitive.ObjectID
+	sub       Subscription
+	stripeSub *stripe.Subscription
+	current   *stripe.Price
+	plan      *Plan
+	upgrade   bool
+}
+
+// kind はプラン変更の種類を返す
+func (pc *planChangeContext) kind() string {
+	if pc.upgrade {
+		return PlanChangeUpgrade
+	}
+	return PlanChangeDowngrade
+}
+
+// isPlanUpgrade は現在の価格から新しいプランへの変更がアップグレードかどうかを返す
+// 請求間隔が長くなる、または同じ請求間隔で金額が上がる場合をアップグレードとし、すぐに日割りで精算する
+// それ以外（ダウングレード）は支払い済みの期間を使い切ってから切り替える
+func isPlanUpgrade(current *stripe.Price, next *Plan) bool {
+	currentDays := planIntervalDays(current.Recurring)
+	nextDays := planIntervalDays(&stripe.PriceRecurring{
+		Interval:      stripe.PriceRecurringInterval(next.Interval),
+		IntervalCount: next.IntervalCount,
+	})
+	if currentDays != nextDays {
+		return nextDays > currentDays
+	}
+	return next.Amount > current.UnitAmount
+}
+
+// loadPlanChange は認証ユーザーのサブスクリプションと変更先のプランを検証する
+// 変更できない場合はレスポンスを書き込んで nil を返す
+func loadPlanChange(c *gin.Context, priceID string) *planChangeContext {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "認証が必要です"})
+		return nil
+	}
+
+	ctx := c.Request.Context()
+	plan, err := findPurchasablePlan(ctx, priceID)
+	if err != nil {
+		if errors.Is(err, ErrPlanNotFound) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "無効な価格IDです"})
+			return nil
+		}
+		utils.LogErrorCtx(ctx, "ChangePlan", err, "Failed to look up plan")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "プランの確認に失敗しました"})
+		return nil
+	}
+
+	var sub Subscription
+	if err := subscriptionCollection.FindOne(ctx, bson.M{"user_id": userID}).Decode(&sub); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "サブスクリプション情報が見つかりません"})
+		return nil
+	}
+	if sub.Status != "active" && sub.Status != "trialing" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "有効なサブスクリプションがありません"})
+		return nil
+	}
+	if sub.CancelAtPeriodEnd {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "解約予約中はプランを変更できません。契約を再開してから変更してください"})
+		return nil
+	}
+	if sub.PausedAt != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "休止中はプランを変更できません。休止を解除してから変更してください"})
+		return nil
+	}
+	if sub.Status == "trialing" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "トライアル中はプランを変更できません"})
+		return nil
+	}
+
+	stripeSub, err := billing.GetSubscription(ctx, sub.StripeSubscriptionID)
+	if err != nil {
+		utils.LogErrorCtx(ctx, "ChangePlan", err, "Failed to fetch subscription from Stripe")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "サブスクリプション情報の取得に失敗しました"})
+		return nil
+	}
+	if stripeSub.Items == nil || len(stripeSub.Items.Data) == 0 || stripeSub.Items.Data[0].Price == nil {
+		utils.LogErrorCtx(ctx, "ChangePlan", nil, "Subscription has no items: "+sub.StripeSubscriptionID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "サブスクリプション情報が不完全です"})
+		return nil
+	}
+	current := stripeSub.Items.Data[0].Price
+	if current.ID == plan.PriceID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "現在ご契約中のプランです"})
+		return nil
+	}
+
+	return &planChangeContext{
+		userID:    userID,
+		sub:       sub,
+		stripeSub: stripeSub,
+		current:   current,
+		plan:      plan,
+		upgrade:   isPlanUpgrade(current, plan),
+	}
+}
+
+func planChangeQuoteSignature(subscriptionID, priceID string, prorationDate int64) []byte {
+	mac := hmac.New(sha256.New, []byte(os.Getenv("JWT_SECRET")))
+	mac.Write([]byte(fmt.Sprintf("%s%s:%s:%d", planChangeQuotePurpose, subscriptionID, priceID, prorationDate)))
+	return mac.Sum(nil)[:16]
+}
+
+// planChangeQuoteToken はプレビューの精算の基準日時に署名したトークンを返す
+// サブスクリプションと変更先の価格も署名に含め、他の変更には使えないようにする
+func planChangeQuoteToken(subscriptionID, priceID string, prorationDate int64) string {
+	return strconv.FormatInt(prorationDate, 10) + "." + base64.RawURLEncoding.EncodeToString(planChangeQuoteSignature(subscriptionID, priceID, prorationDate))
+}
+
+// parsePlanChangeQuoteToken はプレビューで発行したトークンを検証し、精算の基準日時を返す
+// 署名が一致しない・発行から planChangeQuoteTTL を過ぎた・未来の日時の場合は false を返す
+// （基準日時を遅らせると日割りの差額が減るため、クライアントが指定した日時は信用しない）
+func parsePlanChangeQuoteToken(token, subscriptionID, priceID string, now time.Time) (int64, bool) {
+	datePart, sigPart, ok := strings.Cut(token, ".")
+	if !ok || os.Getenv("JWT_SECRET") == "" {
+		return 0, false
+	}
+	prorationDate, err := strconv.ParseInt(datePart, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(sigPart)
+	if err != nil || !hmac.Equal(signature, planChangeQuoteSignature(subscriptionID, priceID, prorationDate)) {
+		return 0, false
+	}
+	issuedAt := time.Unix(prorationDate, 0)
+	if issuedAt.After(now) || now.Sub(issuedAt) > planChangeQuoteTTL {
+		return 0, false
+	}
+	return prorationDate, true
+}
+
+// PreviewPlanChangeHandler はプラン変更時の請求額を返すハンドラ
+// アップグレードは Stripe の次回請求書のプレビューで日割り精算額を求め、ダウングレードは期間終了時に新しい金額で請求する
+func PreviewPlanChangeHandler(c *gin.Context) {
+	pc := loadPlanChange(c, c.Query("priceId"))
+	if pc == nil {
+		return
+	}
+	ctx := c.Request.Context()
+
+	if !pc.upgrade {
+		effectiveAt := time.Unix(pc.stripeSub.CurrentPeriodEnd, 0)
+		c.JSON(http.StatusOK, gin.H{
+			"change":          PlanChangeDowngrade,
+			"effective":       PlanChangePeriodEnd,
+			"effective_at":    effectiveAt,
+			"amount_due":      0,
+			"currency":        pc.plan.Currency,
+			"lines":           []gin.H{},
+			"next_amount":     pc.plan.Amount,
+			"next_billing_at": effectiveAt,
+			"plan":            pc.plan,
+		})
+		return
+	}
+
+	// 変更時に同じ金額で精算されるよう、プレビューの基準日時に署名したトークンを返して変更のリクエストで指定してもらう
+	prorationDate := time.Now().Unix()
+	params := &stripe.InvoiceUpcomingParams{
+		Customer:                      stripe.String(pc.sub.StripeCustomerID),
+		Subscription:                  stripe.String(pc.sub.StripeSubscriptionID),
+		SubscriptionProrationBehavior: stripe.String("always_invoice"),
+		SubscriptionProrationDate:     stripe.Int64(prorationDate),
+		SubscriptionItems: []*stripe.SubscriptionItemsParams{{
+			ID:    stripe.String(pc.stripeSub.Items.Data[0].ID),
+			Price: stripe.String(pc.plan.PriceID),
+		}},
+	}
+	inv, err := billing.UpcomingInvoice(ctx, params)
+	if err != nil {
+		utils.LogErrorCtx(ctx, "PreviewPlanChange", err, "Failed to preview proration")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "変更後の請求額の取得に失敗しました"})
+		return
+	}
+
+	lines := []gin.H{}
+	if inv.Lines != nil {
+		for _, line := range inv.Lines.Data {
+			entry := gin.H{
+				"description": line.Description,
+				"amount":      line.Amount,
+				"proration":   line.Proration,
+			}
+			if line.Period != nil {
+				entry["period_start"] = time.Unix(line.Period.Start, 0)
+				entry["period_end"] = time.Unix(line.Period.End, 0)
+			}
+			lines = append(lines, entry)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"change":          PlanChangeUpgrade,
+		"effective":       PlanChangeImmediate,
+		"effective_at":    time.Unix(prorationDate, 0),
+		"proration_date":  prorationDate,
+		"proration_token": planChangeQuoteToken(pc.sub.StripeSubscriptionID, pc.plan.PriceID, prorationDate),
+		"amount_due":      inv.AmountDue,
+		"currency":        string(inv.Currency),
+		"lines":           lines,
+		"next_amount":     pc.plan.Amount,
+		"next_billing_at": time.Unix(inv.PeriodEnd, 0),
+		"plan":            pc.plan,
+	})
+}
+
+// ChangePlanHandler は契約中のプランを変更するハンドラ
+// アップグレードはすぐに切り替えて差額を日割りで請求し、ダウングレードは契約期間の終了時に切り替える
+func ChangePlanHandler(c *gin.Context) {
+	var req struct {
+		PriceID string `json:"priceId" binding:"required"`
+		// ProrationToken はプレビューで返した精算の基準日時のトークン（省略時や期限切れの場合は現在時刻で精算する）
+		ProrationToken string `json:"prorationToken"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "不正な入力データです"})
+		return
+	}
+
+	pc := loadPlanChange(c, req.PriceID)
+	if pc == nil {
+		return
+	}
+
+	if pc.upgrade {
+		upgradePlan(c, pc, req.ProrationToken)
+	} else {
+		schedulePlanDowngrade(c, pc)
+	}
+}
+
+// scheduledPlanChange は別のプランへの変更の予約を返す（予約がない場合は空の ScheduleID）
+// ローカルの予約情報が Webhook の遅れで欠けていても、Stripe のサブスクリプションに付いた予約を使う
+func (pc *planChangeContext) scheduledPlanChange() Subscription {
+	sub := pc.sub
+	if pc.stripeSub.Schedule != nil {
+		sub.ScheduleID = pc.stripeSub.Schedule.ID
+	}
+	return sub
+}
+
+// upgradePlan はすぐにプランを切り替え、日割りの差額をその場で請求する
+// 予約中のダウングレードは変更が成功してから取り消す（差額の支払いに失敗した場合は予約を残す）
+func upgradePlan(c *gin.Context, pc *planChangeContext, prorationToken string) {
+	ctx := c.Request.Context()
+	now := time.Now()
+	prorationDate, ok := parsePlanChangeQuoteToken(prorationToken, pc.sub.StripeSubscriptionID, pc.plan.PriceID, now)
+	if !ok || prorationDate < pc.stripeSub.CurrentPeriodStart || prorationDate > pc.stripeSub.CurrentPeriodEnd {
+		prorationDate = now.Unix()
+	}
+
+	params := &stripe.SubscriptionParams{
+		Items: []*stripe.SubscriptionItemsParams{{
+			ID:    stripe.String(pc.stripeSub.Items.Data[0].ID),
+			Price: stripe.String(pc.plan.PriceID),
+		}},
+		ProrationBehavior: stripe.String("always_invoice"),
+		ProrationDate:     stripe.Int64(prorationDate),
+		// 差額の支払いに失敗した場合は変更しない
+		PaymentBehavior: stripe.String("error_if_incomplete"),
+	}
+	params.SetIdempotencyKey(fmt.Sprintf("sub-change:%s:%s:%d", pc.sub.StripeSubscriptionID, pc.plan.PriceID, prorationDate))
+
+	updated, err := billing.UpdateSubscription(ctx, pc.sub.StripeSubscriptionID, params)
+	if err != nil {
+		utils.LogErrorCtx(ctx, "ChangePlan", err, "Failed to upgrade subscription in Stripe")
+		var stripeErr *stripe.Error
+		if errors.As(err, &stripeErr) && stripeErr.Type == stripe.ErrorTypeCard {
+			c.JSON(http.StatusPaymentRequired, gin.H{"error": "差額のお支払いに失敗しました。登録済みのカードをご確認ください"})
+			return
+		}
+		errMsg := "プランの変更に失敗しました"
+		if os.Getenv("APP_ENV") != "production" {
+			errMsg += ": " + err.Error()
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": errMsg})
+		return
+	}
+
+	update := bson.M{
+		"$set": bson.M{
+			"price_id":             pc.plan.PriceID,
+			"status":               string(updated.Status),
+			"current_period_end":   time.Unix(updated.CurrentPeriodEnd, 0),
+			"cancel_at_period_end": updated.CancelAtPeriodEnd,
+			"updated_at":           time.Now(),
+		},
+	}
+	if scheduled := pc.scheduledPlanChange(); scheduled.ScheduleID != "" {
+		// 予約を残すと期間終了時に予約したプランへ戻ってしまう
+		if err := releasePlanChangeSchedule(ctx, scheduled); err != nil {
+			// 予約はローカルにも残るため、利用者が予約の取り消しからやり直せる
+			utils.LogErrorCtx(ctx, "ChangePlan", err, "Failed to release subscription schedule after upgrade: "+scheduled.ScheduleID)
+		}
+	}
+	if _, err := subscriptionCollection.UpdateByID(ctx, pc.sub.ID, update); err != nil {
+		// Stripe 側は変更済みのため、Webhook と状態取得時の同期で反映される
+		utils.LogErrorCtx(ctx, "ChangePlan", err, "Failed to update subscription in DB, but Stripe was updated")
+	}
+
+	var amountDue int64
+	invoiceID := ""
+	if updated.LatestInvoice != nil {
+		amountDue = updated.LatestInvoice.AmountDue
+		invoiceID = updated.LatestInvoice.ID
+	}
+	writeAuditLog(c, pc.userID, AuditActionPlanChanged, "subscription", pc.sub.StripeSubscriptionID, map[string]interface{}{
+		"change":         pc.kind(),
+		"from_price_id":  pc.current.ID,
+		"to_price_id":    pc.plan.PriceID,
+		"proration_date": prorationDate,
+		"amount_due":     amountDue,
+		"invoice_id":     invoiceID,
+	})
+	utils.LogInfoCtx(ctx, "ChangePlan", fmt.Sprintf("Upgraded subscription %s to %s", pc.sub.StripeSubscriptionID, pc.plan.PriceID))
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "プランを変更しました",
+		"change":     PlanChangeUpgrade,
+		"effective":  PlanChangeImmediate,
+		"amount_due": amountDue,
+		"subscription": gin.H{
+			"id":                   updated.ID,
+			"status":               updated.Status,
+			"price_id":             pc.plan.PriceID,
+			"current_period_end":   time.Unix(updated.CurrentPeriodEnd, 0),
+			"cancel_at_period_end": updated.CancelAtPeriodEnd,
+		},
+	})
+}
+
+// schedulePlanDowngrade は契約期間の終了時にプランを切り替える予約を作成する
+// ローカルの price_id は切り替え時の Webhook（customer.subscription.updated）で更新する
+// 別のプランへの変更が予約されている場合は、Stripe が予約を1つしか持てないため先に取り消す
+func schedulePlanDowngrade(c *gin.Context, pc *planChangeContext) {
+	ctx := c.Request.Context()
+	if scheduled := pc.scheduledPlanChange(); scheduled.ScheduleID != "" {
+		if err := releasePlanChangeSchedule(ctx, scheduled); err != nil {
+			utils.LogErrorCtx(ctx, "ChangePlan", err, "Failed to release subscription schedule")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "予約中のプラン変更の取り消しに失敗しました"})
+			return
+		}
+	}
+
+	schedule, err := billing.SchedulePriceChange(ctx, pc.sub.StripeSubscriptionID, pc.plan.PriceID)
+	if err != nil {
+		utils.LogErrorCtx(ctx, "ChangePlan", err, "Failed to schedule plan change in Stripe")
+		errMsg := "プラン変更の予約に失敗しました"
+		if os.Getenv("APP_ENV") != "production" {
+			errMsg += ": " + err.Error()
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": errMsg})
+		return
+	}
+
+	effectiveAt := time.Unix(pc.stripeSub.CurrentPeriodEnd, 0)
+	update := bson.M{"$set": bson.M{
+		"schedule_id":         schedule.ID,
+		"scheduled_price_id":  pc.plan.PriceID,
+		"scheduled_change_at": effectiveAt,
+		"updated_at":          time.Now(),
+	}}
+	if _, err := subscriptionCollection.UpdateByID(ctx, pc.sub.ID, update); err != nil {
+		utils.LogErrorCtx(ctx, "ChangePlan", err, "Failed to save scheduled plan change")
+	}
+
+	writeAuditLog(c, pc.userID, AuditActionPlanChangeScheduled, "subscription", pc.sub.StripeSubscriptionID, map[string]interface{}{
+		"change":        pc.kind(),
+		"from_price_id": pc.current.ID,
+		"to_price_id":   pc.plan.PriceID,
+		"schedule_id":   schedule.ID,
+		"effective_at":  effectiveAt,
+	})
+	utils.LogInfoCtx(ctx, "ChangePlan", fmt.Sprintf("Scheduled subscription %s to change to %s at %s",
+		pc.sub.StripeSubscriptionID, pc.plan.PriceID, effectiveAt.Format("2006-01-02")))
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":      "現在の契約期間の終了後にプランを変更します",
+		"change":       PlanChangeDowngrade,
+		"effective":    PlanChangePeriodEnd,
+		"effective_at": effectiveAt,
+		"subscription": gin.H{
+			"id":                  pc.sub.StripeSubscriptionID,
+			"price_id":            pc.current.ID,
+			"scheduled_price_id":  pc.plan.PriceID,
+			"scheduled_change_at": effectiveAt,
+		},
+	})
+}
+
+// CancelScheduledPlanChangeHandler は予約中のプラン変更を取り消すハンドラ
+func CancelScheduledPlanChangeHandler(c *gin.Context) {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "認証が必要です"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	var sub Subscription
+	if err := subscriptionCollection.FindOne(ctx, bson.M{"user_id": userID}).Decode(&sub); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "サブスクリプション情報が見つかりません"})
+		return
+	}
+	if sub.ScheduleID == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "予約中のプラン変更はありません"})
+		return
+	}
+
+	if err := releasePlanChangeSchedule(ctx, sub); err != nil {
+		utils.LogErrorCtx(ctx, "CancelPlanChange", err, "Failed to release subscription schedule")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "予約中のプラン変更の取り消しに失敗しました"})
+		return
+	}
+
+	writeAuditLog(c, userID, AuditActionPlanChangeCanceled, "subscription", sub.StripeSubscriptionID, map[string]interface{}{
+		"schedule_id":        sub.ScheduleID,
+		"scheduled_price_id": sub.ScheduledPriceID,
+	})
+	c.JSON(http.StatusOK, gin.H{"message": "プラン変更の予約を取り消しました"})
+}
+
+// releasePlanChangeSchedule は Stripe の予約を解除し、ローカルの予約情報を消す
+// 予約がすでに反映・解除されている場合もローカルの情報は消す
+func releasePlanChangeSchedule(ctx context.Context, sub Subscription) error {
+	if err := billing.ReleaseSubscriptionSchedule(ctx, sub.ScheduleID); err != nil {
+		var stripeErr *stripe.Error
+		if !errors.As(err, &stripeErr) || stripeErr.Type != stripe.ErrorTypeInvalidRequest {
+			return err
+		}
+		utils.LogWarningCtx(ctx, "CancelPlanChange", "Subscription schedule was already released: "+stripeErr.Msg)
+	}
+
+	_, err := subscriptionCollection.UpdateByID(ctx, sub.ID, bson.M{
+		"$unset": bson.M{"schedule_id": "", "scheduled_price_id": "", "scheduled_change_at": ""},
+		"$set":   bson.M{"updated_at": time.Now()},
+	})
+	return err
+}
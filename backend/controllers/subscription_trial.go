@@ -0,0 +1,171 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"juice_academy_backend/utils"
+
+	"github.com/stripe/stripe-go/v81"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var trialRedemptionCollection *mongo.Collection
+
+// errTrialAlreadyUsed はトライアルを利用済みであることを表す
+var errTrialAlreadyUsed = errors.New("trial already used")
+
+// TrialRedemption はトライアルの利用記録。利用者・学籍番号ごとに1回までとするため、
+// アカウントを削除しても残す（学籍番号は復元できないようハッシュ化して保存する）
+type TrialRedemption struct {
+	ID                   primitive.ObjectID `bson:"_id,omitempty"`
+	UserID               primitive.ObjectID `bson:"user_id"`
+	StudentIDHash        string             `bson:"student_id_hash,omitempty"`
+	PriceID              string             `bson:"price_id"`
+	TrialDays            int                `bson:"trial_days"`
+	StripeSubscriptionID string             `bson:"stripe_subscription_id,omitempty"`
+	CreatedAt            time.Time          `bson:"created_at"`
+}
+
+// InitTrialRedemptionCollection はトライアル利用記録のコレクションを初期化する
+func InitTrialRedemptionCollection(client *mongo.Client) {
+	trialRedemptionCollection = client.Database("juice_academy").Collection("trial_redemptions")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_ = createTrialRedemptionIndexes(ctx, trialRedemptionCollection)
+}
+
+// createTrialRedemptionIndexes はトライアル利用記録のインデックスを作成する
+// 一意制約で同時リクエストでも2回目のトライアルを防ぐ
+func createTrialRedemptionIndexes(ctx context.Context, collection *mongo.Collection) error {
+	_, err := collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "user_id", Value: 1}},
+			Options: options.Index().SetUnique(true).SetName("user_id_unique"),
+		},
+		{
+			Keys: bson.D{{Key: "student_id_hash", Value: 1}},
+			Options: options.Index().SetUnique(true).SetName("student_id_hash_unique").
+				SetPartialFilterExpression(bson.M{"student_id_hash": bson.M{"$type": "string"}}),
+		},
+	})
+	return err
+}
+
+// studentIDHash は学籍番号を照合用にハッシュ化する（未設定の場合は空文字列）
+func studentIDHash(studentID string) string {
+	studentID = strings.ToUpper(strings.TrimSpace(studentID))
+	if studentID == "" {
+		return ""
+	}
+	return hashToken("student_id:" + studentID)
+}
+
+// trialRedemptionFilter は利用者または学籍番号に一致するトライアル利用記録の条件
+func trialRedemptionFilter(user User) bson.M {
+	conditions := bson.A{bson.M{"user_id": user.ID}}
+	if hash := studentIDHash(user.StudentID); hash != "" {
+		conditions = append(conditions, bson.M{"student_id_hash": hash})
+	}
+	return bson.M{"$or": conditions}
+}
+
+// isTrialEligible は利用者がまだトライアルを利用していないかどうかを返す
+// 同じ学籍番号で作り直したアカウントも利用済みとして扱う
+func isTrialEligible(ctx context.Context, user User) (bool, error) {
+	count, err := trialRedemptionCollection.CountDocuments(ctx, trialRedemptionFilter(user), options.Count().SetLimit(1))
+	if err != nil {
+		return false, err
+	}
+	return count == 0, nil
+}
+
+// hasBillingHistory は Stripe の顧客に過去の請求があるかどうかを返す
+// トライアル機能より前に契約していた利用者にはトライアルを付けない
+func hasBillingHistory(ctx context.Context, customerID string) (bool, error) {
+	invoices, err := billing.ListInvoices(ctx, customerID)
+	if err != nil {
+		return false, err
+	}
+	return len(invoices) > 0, nil
+}
+
+// claimTrial はトライアルの利用を記録する。利用済みの場合は errTrialAlreadyUsed を返す
+// Stripe でのサブスクリプション作成に失敗した場合は releaseTrialClaim で取り消す
+func claimTrial(ctx context.Context, user User, plan *Plan) (primitive.ObjectID, error) {
+	redemption := TrialRedemption{
+		UserID:        user.ID,
+		StudentIDHash: studentIDHash(user.StudentID),
+		PriceID:       plan.PriceID,
+		TrialDays:     plan.TrialDays,
+		CreatedAt:     time.Now(),
+	}
+	res, err := trialRedemptionCollection.InsertOne(ctx, redemption)
+	if mongo.IsDuplicateKeyError(err) {
+		return primitive.NilObjectID, errTrialAlreadyUsed
+	}
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	return res.InsertedID.(primitive.ObjectID), nil
+}
+
+// releaseTrialClaim はトライアルの利用記録を取り消す
+func releaseTrialClaim(ctx context.Context, id primitive.ObjectID) error {
+	_, err := trialRedemptionCollection.DeleteOne(ctx, bson.M{"_id": id})
+	return err
+}
+
+// trialSubscriptionParams はトライアル付きのサブスクリプション作成パラメータを設定する
+// カード未登録のままトライアルが終わった場合は請求せずに解約する
+func trialSubscriptionParams(params *stripe.SubscriptionParams, trialDays int) {
+	params.TrialPeriodDays = stripe.Int64(int64(trialDays))
+	params.TrialSettings = &stripe.SubscriptionTrialSettingsParams{
+		EndBehavior: &stripe.SubscriptionTrialSettingsEndBehaviorParams{
+			MissingPaymentMethod: stripe.String(string(stripe.SubscriptionTrialSettingsEndBehaviorMissingPaymentMethodCancel)),
+		},
+	}
+}
+
+// userTrialEligible は表示用にトライアルを利用できるかどうかを返す（取得に失敗した場合は false）
+// 過去の請求の有無は契約時に確認する
+func userTrialEligible(ctx context.Context, userID primitive.ObjectID) bool {
+	var user User
+	if err := userCollection.FindOne(ctx, bson.M{"_id": userID}).Decode(&user); err != nil {
+		return false
+	}
+	eligible, err := isTrialEligible(ctx, user)
+	if err != nil {
+		utils.LogWarningCtx(ctx, "TrialEligibility", "Failed to check trial eligibility: "+err.Error())
+		return false
+	}
+	return eligible
+}
+
+// claimTrialIfEligible はトライアルの対象であれば利用を記録してその ID を返す（対象外の場合は NilObjectID）
+// 対象は、利用者・学籍番号ともにトライアルを利用しておらず、Stripe の顧客に過去の請求がない場合
+func claimTrialIfEligible(ctx context.Context, userID primitive.ObjectID, customerID string, plan *Plan) (primitive.ObjectID, error) {
+	var user User
+	if err := userCollection.FindOne(ctx, bson.M{"_id": userID}).Decode(&user); err != nil {
+		return primitive.NilObjectID, err
+	}
+	eligible, err := isTrialEligible(ctx, user)
+	if err != nil || !eligible {
+		return primitive.NilObjectID, err
+	}
+	billed, err := hasBillingHistory(ctx, customerID)
+	if err != nil || billed {
+		return primitive.NilObjectID, err
+	}
+	id, err := claimTrial(ctx, user, plan)
+	if errors.Is(err, errTrialAlreadyUsed) {
+		return primitive.NilObjectID, nil
+	}
+	return id, err
+}
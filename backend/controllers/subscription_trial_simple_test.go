@@ -0,0 +1,30 @@
+package controllers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TestStudentIDHash は学籍番号の照合用ハッシュをテストする
+func TestStudentIDHash(t *testing.T) {
+	assert.Equal(t, studentIDHash("ab12345"), studentIDHash(" AB12345 "), "大文字・小文字と前後の空白は区別しないこと")
+	assert.NotEqual(t, studentIDHash("AB12345"), studentIDHash("AB12346"))
+	assert.NotContains(t, studentIDHash("AB12345"), "AB12345", "学籍番号をそのまま保存しないこと")
+	assert.Empty(t, studentIDHash("  "))
+}
+
+// TestTrialRedemptionFilter は利用者と学籍番号のどちらかでトライアルの利用記録を探すことをテストする
+func TestTrialRedemptionFilter(t *testing.T) {
+	userID := primitive.NewObjectID()
+
+	filter := trialRedemptionFilter(User{ID: userID, StudentID: "AB12345"})
+	conditions, ok := filter["$or"].(bson.A)
+	assert.True(t, ok)
+	assert.Equal(t, bson.A{bson.M{"user_id": userID}, bson.M{"student_id_hash": studentIDHash("AB12345")}}, conditions)
+
+	filter = trialRedemptionFilter(User{ID: userID})
+	assert.Equal(t, bson.A{bson.M{"user_id": userID}}, filter["$or"], "学籍番号のない利用者は利用者IDのみで照合すること")
+}
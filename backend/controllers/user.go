@@ -1,13 +1,13 @@
 package controllers
 
 import (
+	"juice_academy_backend/middleware"
+	"juice_academy_backend/services"
 	"juice_academy_backend/utils"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/stripe/stripe-go/v81"
-	"github.com/stripe/stripe-go/v81/customer"
-	subscriptionapi "github.com/stripe/stripe-go/v81/subscription"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
@@ -16,19 +16,12 @@ import (
 // 詳細は backend/ACCOUNT_DELETION.md を参照
 func DeleteAccountHandler(c *gin.Context) {
 	// コンテキストからユーザーIDを取得
-	userIDStr, exists := c.Get("user_id")
-	if !exists {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "認証情報が見つかりません"})
 		return
 	}
 
-	// 文字列をObjectIDに変換
-	userID, err := primitive.ObjectIDFromHex(userIDStr.(string))
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "不正なユーザーIDです"})
-		return
-	}
-
 	ctx := c.Request.Context()
 
 	// === STEP 1: Stripe 側のクリーンアップ ===
@@ -36,11 +29,10 @@ func DeleteAccountHandler(c *gin.Context) {
 
 	// 1.1 アクティブなサブスクリプションの停止
 	var subscription Subscription
-	err = subscriptionCollection.FindOne(ctx, bson.M{"user_id": userID}).Decode(&subscription)
+	err := subscriptionCollection.FindOne(ctx, bson.M{"user_id": userID}).Decode(&subscription)
 	if err == nil && subscription.StripeSubscriptionID != "" && subscription.Status == "active" {
 		// 即時キャンセル
-		params := &stripe.SubscriptionCancelParams{}
-		_, err := subscriptionapi.Cancel(subscription.StripeSubscriptionID, params)
+		_, err := billing.CancelSubscription(ctx, subscription.StripeSubscriptionID)
 		if err != nil {
 			utils.LogErrorCtx(c.Request.Context(), "DeleteAccount", err, "Failed to cancel Stripe subscription")
 			// サブスクリプション停止失敗は致命的エラー（課金継続を防ぐ）
@@ -56,7 +48,7 @@ func DeleteAccountHandler(c *gin.Context) {
 	var payment Payment
 	err = paymentCollection.FindOne(ctx, bson.M{"user_id": userID}).Decode(&payment)
 	if err == nil && payment.StripeCustomerID != "" {
-		_, err := customer.Del(payment.StripeCustomerID, nil)
+		err := billing.DeleteCustomer(ctx, payment.StripeCustomerID)
 		if err != nil {
 			// 顧客削除失敗は警告のみ（継続可能）
 			utils.LogWarningCtx(c.Request.Context(), "DeleteAccount", "Failed to delete Stripe customer (continuing): "+err.Error())
@@ -81,7 +73,16 @@ func DeleteAccountHandler(c *gin.Context) {
 		// 警告のみで継続
 	}
 
-	// 2.3 ユーザー本体の削除（最後）
+	// 2.3 請求書の写しの削除（請求書の原本は Stripe に残る）
+	if payment.StripeCustomerID != "" {
+		_, err = invoiceCollection.DeleteMany(ctx, bson.M{"stripe_customer_id": payment.StripeCustomerID})
+		if err != nil {
+			utils.LogErrorCtx(c.Request.Context(), "DeleteAccount", err, "Failed to delete invoices")
+			// 警告のみで継続
+		}
+	}
+
+	// 2.4 ユーザー本体の削除（最後）
 	result, err := userCollection.DeleteOne(ctx, bson.M{"_id": userID})
 	if err != nil {
 		utils.LogErrorCtx(c.Request.Context(), "DeleteAccount", err, "Failed to delete user")
@@ -121,7 +122,7 @@ func SetAdminStatus(c *gin.Context) {
 	// データベースからユーザーを取得して更新
 	ctx := c.Request.Context()
 	filter := bson.M{"_id": objID}
-	update := bson.M{"$set": bson.M{"is_admin": requestBody.IsAdmin}}
+	update := bson.M{"$set": bson.M{"is_admin": requestBody.IsAdmin, "updated_at": time.Now()}}
 
 	result, err := userCollection.UpdateOne(ctx, filter, update)
 	if err != nil {
@@ -134,9 +135,121 @@ func SetAdminStatus(c *gin.Context) {
 		return
 	}
 
+	// 既存トークンの isAdmin クレームではなく、次のリクエストからDBの値で判断させる
+	invalidateAuthz(c, objID)
+
 	c.JSON(http.StatusOK, gin.H{
 		"message": "管理者権限が更新されました",
 		"userId":  userID,
 		"isAdmin": requestBody.IsAdmin,
 	})
 }
+
+// SetUserRole は特定のユーザーのロールを変更します（管理者専用）
+func SetUserRole(c *gin.Context) {
+	var requestBody struct {
+		Role string `json:"role" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&requestBody); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "無効なリクエスト形式です"})
+		return
+	}
+
+	if !assignableRoles[requestBody.Role] {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "無効なロールです"})
+		return
+	}
+
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "無効なユーザーIDです"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	result, err := userCollection.UpdateOne(ctx, bson.M{"_id": objID}, bson.M{
+		"$set": bson.M{"role": requestBody.Role, "updated_at": time.Now()},
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "ユーザー情報の更新に失敗しました"})
+		return
+	}
+
+	if result.MatchedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "ユーザーが見つかりません"})
+		return
+	}
+
+	invalidateAuthz(c, objID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "ロールが更新されました",
+		"userId":  objID.Hex(),
+		"role":    requestBody.Role,
+	})
+}
+
+// SetUserSuspension は特定のユーザーのアカウントを停止または再開します（管理者専用）
+// 停止時はリフレッシュトークンを無効化し、発行済みアクセストークンも次のリクエストから拒否される
+func SetUserSuspension(c *gin.Context) {
+	// 空のボディで停止が解除されないよう、suspended の指定を必須にする
+	var requestBody struct {
+		Suspended *bool `json:"suspended" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&requestBody); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "無効なリクエスト形式です"})
+		return
+	}
+	suspended := *requestBody.Suspended
+
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "無効なユーザーIDです"})
+		return
+	}
+
+	// 自分自身を停止して管理者が不在になることを防ぐ
+	if currentUserID, ok := middleware.CurrentUserID(c); ok && currentUserID == objID && suspended {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "自分自身のアカウントは停止できません"})
+		return
+	}
+
+	now := time.Now()
+	update := bson.M{"$set": bson.M{"suspended": false, "updated_at": now}, "$unset": bson.M{"suspended_at": ""}}
+	if suspended {
+		update = bson.M{"$set": bson.M{"suspended": true, "suspended_at": now, "updated_at": now}}
+	}
+
+	ctx := c.Request.Context()
+	result, err := userCollection.UpdateOne(ctx, bson.M{"_id": objID}, update)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "ユーザー情報の更新に失敗しました"})
+		return
+	}
+
+	if result.MatchedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "ユーザーが見つかりません"})
+		return
+	}
+
+	if suspended {
+		if err := revokeAllRefreshTokens(ctx, objID); err != nil {
+			utils.LogErrorCtx(ctx, "SetUserSuspension", err, "Failed to revoke refresh tokens")
+		}
+	}
+	invalidateAuthz(c, objID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":   "アカウントの停止状態が更新されました",
+		"userId":    objID.Hex(),
+		"suspended": suspended,
+	})
+}
+
+// invalidateAuthz はユーザーの認可スナップショットのキャッシュを破棄する
+func invalidateAuthz(c *gin.Context, userID primitive.ObjectID) {
+	if err := services.InvalidateAuthzSnapshot(c.Request.Context(), userID.Hex()); err != nil {
+		// キャッシュはTTLで失効するため、失敗しても処理は継続する
+		utils.LogWarningCtx(c.Request.Context(), "Authz", "Failed to invalidate authorization snapshot: "+err.Error())
+	}
+}
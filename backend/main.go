@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"juice_academy_backend/config"
 	"juice_academy_backend/controllers"
 	"juice_academy_backend/middleware"
@@ -40,13 +41,54 @@ func main() {
 	controllers.InitSubscriptionCollection(dbClient)
 	controllers.InitStripeEventCollection(dbClient) // Webhook冪等性管理用
 	controllers.InitAnnouncementCollection(db)      // お知らせコレクションの初期化を追加
-	controllers.InitOTPCollection(db)               // OTPコレクションの初期化を追加
+	controllers.InitAnnouncementReadCollection(db)
+	controllers.InitAnnouncementRevisionCollection(db)
+	controllers.InitOTPCollection(db) // OTPコレクションの初期化を追加
 	controllers.InitRefreshTokenCollection(dbClient)
+	controllers.InitInvitationCollection(dbClient)
+	controllers.InitSettingsCollection(dbClient)
+	controllers.InitPlanCollection(dbClient)
+	controllers.InitAuditLogCollection(dbClient)
+	controllers.InitTrialRedemptionCollection(dbClient)
+	controllers.InitInvoiceCollection(dbClient)
+	controllers.InitDisputeCollection(dbClient)
+	controllers.InitReceiptCollection(dbClient)
 	middleware.InitUserCollection(db)
+	middleware.SetEntitlementSource(controllers.LoadEntitlementSnapshot)
+
+	// 添付ファイルの保存先（STORAGE_BACKEND=local または s3）
+	storage, err := services.StorageFromEnv()
+	if err != nil {
+		log.Fatalf("ストレージの初期化に失敗しました: %v", err)
+	}
+	controllers.InitAttachmentStorage(storage)
+
+	// 学校IdPとのOIDCログイン（OIDC_ENABLED=true の環境のみ）
+	oidcEnabled, err := controllers.InitOIDC()
+	if err != nil {
+		log.Fatalf("OIDC設定が不正です: %v", err)
+	}
 
 	// Webhook Worker Pool の初期化
 	subCollection := db.Collection("subscriptions")
-	services.InitWebhookWorker(services.DefaultWebhookConfig, subCollection)
+	services.InitWebhookWorker(services.DefaultWebhookConfig, subCollection, db.Collection("invoices"))
+
+	// お知らせのメール通知（SMTP設定がある環境のみ）
+	controllers.StartAnnouncementDigestWorker(controllers.DefaultAnnouncementDigestConfig)
+
+	// 一括登録したユーザーへの招待メール（SMTP設定がある環境のみ）
+	controllers.StartInvitationEmailWorker(controllers.DefaultInvitationEmailConfig)
+
+	// リアルタイムイベント配信（Redis Pub/Sub で全サーバーの接続に配る）
+	if err := services.StartEventHub(context.Background()); err != nil {
+		log.Printf("イベント配信はこのサーバーの接続に限られます: %v", err)
+	}
+	controllers.StartAnnouncementEventScheduler(30 * time.Second)
+
+	// プラン一覧が空なら Stripe の価格から作成する（以降は管理画面から同期する）
+	seedCtx, cancelSeed := context.WithTimeout(context.Background(), 30*time.Second)
+	controllers.SeedPlanCatalog(seedCtx)
+	cancelSeed()
 
 	// 管理者ユーザーの作成（環境変数で制御）
 	if os.Getenv("SEED_ADMIN_USER") == "true" {
@@ -97,6 +139,8 @@ func main() {
 
 		c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
 		c.Writer.Header().Set("Access-Control-Allow-Headers", "Origin, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization")
+		// 領収書などのダウンロードでファイル名をフロントエンドから読めるようにする
+		c.Writer.Header().Set("Access-Control-Expose-Headers", "Content-Disposition")
 
 		if c.Request.Method == http.MethodOptions {
 			c.Status(http.StatusNoContent)
@@ -124,11 +168,24 @@ func main() {
 	api := router.Group("/api")
 	{
 		api.POST("/register", controllers.RegisterHandler)
+		api.GET("/registration/status", controllers.GetRegistrationStatusHandler)
 		// ログインは必ず2FAを経由（パスワード認証 → OTP送信 → OTP検証）
 		api.POST("/login", middleware.RateLimit("login", 10, time.Minute), controllers.LoginHandler)
-		api.GET("/announcements", controllers.GetAnnouncementsHandler)
-		api.GET("/announcements/:id", controllers.GetAnnouncementByIdHandler)
+		// お知らせは公開APIだが、トークンがあれば配信対象（ロール・サブスクリプション・入学年度）を評価する
+		api.GET("/announcements", middleware.OptionalJWTAuth(), controllers.GetAnnouncementsHandler)
+		api.GET("/announcements/feed.xml", controllers.AnnouncementRSSFeedHandler)
+		api.GET("/announcements/feed.atom", controllers.AnnouncementAtomFeedHandler)
+		// メールの配信停止リンク（RFC 8058 のワンクリック配信停止のためログイン不要）
+		api.POST("/announcements/unsubscribe", middleware.RateLimit("announcement_unsubscribe", 20, time.Minute), controllers.UnsubscribeAnnouncementEmailHandler)
+		api.GET("/announcements/:id", middleware.OptionalJWTAuth(), controllers.GetAnnouncementByIdHandler)
+		api.GET("/announcements/:id/attachments/:attachmentId", middleware.OptionalJWTAuth(), controllers.DownloadAnnouncementAttachmentHandler)
+		api.GET("/plans", controllers.GetPlansHandler)
 		api.POST("/auth/refresh", controllers.RefreshTokenHandler)
+		api.POST("/invitations/accept", middleware.RateLimit("invitation_accept", 10, time.Minute), controllers.AcceptInvitationHandler)
+		if oidcEnabled {
+			api.GET("/auth/oidc/login", middleware.RateLimit("oidc_login", 20, time.Minute), controllers.OIDCLoginHandler)
+			api.GET("/auth/oidc/callback", middleware.RateLimit("oidc_callback", 20, time.Minute), controllers.OIDCCallbackHandler)
+		}
 
 		// 2FA関連のエンドポイント（ログインに必須）
 		api.POST("/otp/send", middleware.RateLimit("otp_send", 5, time.Minute), controllers.SendOTPHandler)
@@ -145,11 +202,14 @@ func main() {
 	{
 		protected.POST("/logout", controllers.LogoutHandler)
 		protected.DELETE("/account", controllers.DeleteAccountHandler)
+		protected.GET("/account/notifications", controllers.GetNotificationSettingsHandler)
+		protected.GET("/events/stream", middleware.RateLimit("event_stream", 30, time.Minute), controllers.EventStreamHandler)
+		protected.PUT("/account/notifications", controllers.UpdateNotificationSettingsHandler)
 
-		// お知らせ管理（管理者のみ）
-		protected.POST("/announcements", middleware.AdminRequired(), controllers.CreateAnnouncementHandler)
-		protected.PUT("/announcements/:id", middleware.AdminRequired(), controllers.UpdateAnnouncementHandler)
-		protected.DELETE("/announcements/:id", middleware.AdminRequired(), controllers.DeleteAnnouncementHandler)
+		// お知らせの既読管理
+		protected.GET("/announcements/unread-count", controllers.GetUnreadAnnouncementCountHandler)
+		protected.POST("/announcements/read-all", controllers.MarkAllAnnouncementsReadHandler)
+		protected.POST("/announcements/:id/read", controllers.MarkAnnouncementReadHandler)
 
 		// 決済関連（認証必須）
 		// SetupIntent 作成/確認は認証が必要。user_id はJWTから取得し、クライアントからの入力は信用しない
@@ -158,26 +218,72 @@ func main() {
 		protected.POST("/payment/customer", middleware.RateLimit("create_customer", 10, time.Minute), controllers.CreateStripeCustomerHandler)
 		protected.POST("/payment/subscription", middleware.RateLimit("create_subscription", 10, time.Minute), controllers.CreateSubscriptionHandler)
 		protected.GET("/payment/history", controllers.PaymentHistoryHandler)
+		// 領収書・適格請求書（type=receipt|invoice）の PDF と宛名を変えた再発行
+		protected.GET("/payment/invoices/:id/receipt", middleware.RateLimit("receipt_download", 30, time.Minute), controllers.DownloadReceiptHandler)
+		protected.POST("/payment/invoices/:id/receipt/reissue", middleware.RateLimit("receipt_reissue", 5, time.Minute), controllers.ReissueReceiptHandler)
 		protected.GET("/payment/methods", controllers.GetPaymentMethodsHandler)
 		protected.DELETE("/payment/methods/:id", controllers.DeletePaymentMethodHandler)
 
 		// サブスクリプション関連
+		// 契約が必要な機能には middleware.RequireEntitlement を付ける（利用権がない場合は code=subscription_required の 403）
+		protected.GET("/entitlements", controllers.GetEntitlementsHandler)
 		protected.GET("/subscription/status", controllers.GetSubscriptionStatusHandler)
 		protected.POST("/subscription/cancel", controllers.CancelSubscriptionHandler)
 		protected.POST("/subscription/promotion", controllers.ApplyPromotionCodeHandler)
+		protected.GET("/subscription/change-plan/preview", controllers.PreviewPlanChangeHandler)
+		protected.POST("/subscription/change-plan", middleware.RateLimit("change_plan", 10, time.Minute), controllers.ChangePlanHandler)
+		protected.DELETE("/subscription/change-plan", controllers.CancelScheduledPlanChangeHandler)
+		protected.POST("/subscription/pause", middleware.RateLimit("subscription_pause", 10, time.Minute), controllers.PauseSubscriptionHandler)
+		protected.POST("/subscription/resume", controllers.ResumeSubscriptionHandler)
 	}
 
 	// 管理者専用ルート
 	adminRoutes := api.Group("/admin")
 	adminRoutes.Use(middleware.JWTAuthMiddleware(), controllers.CSRFProtection(), middleware.AdminRequired())
 	{
+		adminRoutes.GET("/announcements", controllers.AdminListAnnouncementsHandler)
+		adminRoutes.GET("/announcements/:id", controllers.AdminGetAnnouncementByIdHandler)
+		adminRoutes.GET("/announcements/:id/stats", controllers.GetAnnouncementStatsHandler)
+		adminRoutes.POST("/announcements/:id/attachments", controllers.UploadAnnouncementAttachmentHandler)
+		adminRoutes.DELETE("/announcements/:id/attachments/:attachmentId", controllers.DeleteAnnouncementAttachmentHandler)
+		adminRoutes.GET("/announcements/:id/revisions", controllers.ListAnnouncementRevisionsHandler)
+		adminRoutes.GET("/announcements/:id/revisions/:version", controllers.GetAnnouncementRevisionHandler)
+		adminRoutes.POST("/announcements/:id/revisions/:version/restore", controllers.RestoreAnnouncementRevisionHandler)
+		adminRoutes.GET("/announcements/:id/diff", controllers.DiffAnnouncementRevisionsHandler)
+		adminRoutes.GET("/announcements/trash", controllers.ListTrashedAnnouncementsHandler)
+		adminRoutes.POST("/announcements/trash/:id/restore", controllers.RestoreTrashedAnnouncementHandler)
+		adminRoutes.DELETE("/announcements/trash/:id", controllers.PurgeAnnouncementHandler)
 		adminRoutes.POST("/announcements", controllers.CreateAnnouncementHandler)
 		adminRoutes.PUT("/announcements/:id", controllers.UpdateAnnouncementHandler)
 		adminRoutes.DELETE("/announcements/:id", controllers.DeleteAnnouncementHandler)
 		adminRoutes.POST("/sync/stripe", controllers.SyncStripeSubscriptionsHandler)
 
-		// 管理者権限付与エンドポイント
-		adminRoutes.PUT("/users/:id/admin", controllers.SetAdminStatus)
+		// プラン一覧（金額・請求間隔は Stripe から同期し、表示内容と公開状態をここで管理する）
+		adminRoutes.GET("/plans", controllers.AdminListPlansHandler)
+		adminRoutes.PUT("/plans/:id", controllers.UpdatePlanHandler)
+		adminRoutes.POST("/plans/sync", controllers.SyncPlansHandler)
+
+		// ユーザー権限管理エンドポイント（変更は認可スナップショットの無効化により即時反映）
+		userAdmin := adminRoutes.Group("/users", middleware.RequirePermission(middleware.PermissionManageUsers))
+		userAdmin.PUT("/:id/admin", controllers.SetAdminStatus)
+		userAdmin.PUT("/:id/role", controllers.SetUserRole)
+		userAdmin.PUT("/:id/suspension", controllers.SetUserSuspension)
+		userAdmin.POST("/import", controllers.ImportRosterHandler)
+		userAdmin.POST("/:id/invitation", controllers.ResendInvitationHandler)
+
+		// 請求書の返金・クレジットノート（Stripe のダッシュボードを使わずに行い、台帳と監査ログに残す）
+		billingAdmin := adminRoutes.Group("/billing", middleware.RequirePermission(middleware.PermissionManageBilling))
+		billingAdmin.GET("/users/:id/invoices", controllers.AdminListUserInvoicesHandler)
+		billingAdmin.POST("/invoices/:id/refunds", middleware.RateLimit("admin_refund", 10, time.Minute), controllers.AdminRefundInvoiceHandler)
+		billingAdmin.POST("/invoices/:id/credit-notes", middleware.RateLimit("admin_credit_note", 10, time.Minute), controllers.AdminCreateCreditNoteHandler)
+		billingAdmin.GET("/disputes", controllers.AdminListDisputesHandler)
+		billingAdmin.GET("/disputes/:id", controllers.AdminGetDisputeHandler)
+		billingAdmin.POST("/disputes/:id/evidence", middleware.RateLimit("admin_dispute_evidence", 10, time.Minute), controllers.AdminSubmitDisputeEvidenceHandler)
+		billingAdmin.POST("/disputes/:id/resume-subscription", controllers.AdminResumeDisputeSubscriptionHandler)
+
+		// 登録ポリシー（学籍番号形式・メールドメイン・招待制・受付期間）
+		adminRoutes.GET("/registration-policy", middleware.RequirePermission(middleware.PermissionManageUsers), controllers.GetRegistrationPolicyHandler)
+		adminRoutes.PUT("/registration-policy", middleware.RequirePermission(middleware.PermissionManageUsers), controllers.UpdateRegistrationPolicyHandler)
 	}
 
 	server := &http.Server{
@@ -0,0 +1,162 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"juice_academy_backend/services"
+	"juice_academy_backend/utils"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// 権限名
+const (
+	PermissionManageAnnouncements = "announcements:manage"
+	PermissionManageUsers         = "users:manage"
+	PermissionManageBilling       = "billing:manage"
+)
+
+// rolePermissions はロールごとに付与される権限
+// 管理者（is_admin=true または role=admin）はすべての権限を持つ
+var rolePermissions = map[string][]string{
+	"teacher": {},
+	"student": {},
+}
+
+var allPermissions = []string{
+	PermissionManageAnnouncements,
+	PermissionManageUsers,
+	PermissionManageBilling,
+}
+
+const contextKeyAuthz = "authz_snapshot"
+
+// AuthzSnapshot はデータベース上のユーザー状態から導出した認可情報
+// トークンのクレームは発行時点の情報のため、認可判断には必ずこちらを使用する
+type AuthzSnapshot struct {
+	UserID      string    `json:"user_id"`
+	Role        string    `json:"role"`
+	IsAdmin     bool      `json:"is_admin"`
+	Suspended   bool      `json:"suspended"`
+	Permissions []string  `json:"permissions"`
+	LoadedAt    time.Time `json:"loaded_at"`
+}
+
+// HasPermission は指定した権限を持つかどうかを返す
+func (s *AuthzSnapshot) HasPermission(permission string) bool {
+	for _, p := range s.Permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// authzSource は認可スナップショットの取得元（InitUserCollection で設定、テストで差し替え可能）
+// nil の場合、JWTAuthMiddleware はスナップショットの確認を行わない
+var authzSource func(ctx context.Context, userID primitive.ObjectID) (*AuthzSnapshot, error)
+
+// newAuthzSnapshot はユーザーのロールと管理者フラグから権限一覧を組み立てる
+func newAuthzSnapshot(userID primitive.ObjectID, role string, isAdmin, suspended bool) *AuthzSnapshot {
+	snapshot := &AuthzSnapshot{
+		UserID:    userID.Hex(),
+		Role:      role,
+		IsAdmin:   isAdmin || role == "admin",
+		Suspended: suspended,
+		LoadedAt:  time.Now(),
+	}
+	if snapshot.IsAdmin {
+		snapshot.Permissions = append([]string{}, allPermissions...)
+	} else {
+		snapshot.Permissions = append([]string{}, rolePermissions[role]...)
+	}
+	return snapshot
+}
+
+// loadAuthzSnapshotFromStore はRedisキャッシュを優先し、なければMongoDBから読み込んでキャッシュする
+func loadAuthzSnapshotFromStore(ctx context.Context, userID primitive.ObjectID) (*AuthzSnapshot, error) {
+	var cached AuthzSnapshot
+	if found, err := services.GetAuthzSnapshot(ctx, userID.Hex(), &cached); err == nil && found {
+		return &cached, nil
+	}
+
+	var user struct {
+		IsAdmin   bool   `bson:"is_admin"`
+		Role      string `bson:"role"`
+		Suspended bool   `bson:"suspended"`
+	}
+	if err := userCollection.FindOne(ctx, bson.M{"_id": userID}).Decode(&user); err != nil {
+		return nil, err
+	}
+
+	snapshot := newAuthzSnapshot(userID, user.Role, user.IsAdmin, user.Suspended)
+	if err := services.SetAuthzSnapshot(ctx, userID.Hex(), snapshot); err != nil {
+		// キャッシュ保存の失敗は認可判断に影響しないため継続
+		utils.LogWarningCtx(ctx, "Authz", "Failed to cache authorization snapshot: "+err.Error())
+	}
+	return snapshot, nil
+}
+
+// AuthzSnapshotFromContext はリクエスト中に読み込んだ認可スナップショットを返す
+// 未読込の場合は取得元から読み込み、コンテキストに保存する
+func AuthzSnapshotFromContext(c *gin.Context) (*AuthzSnapshot, error) {
+	if value, exists := c.Get(contextKeyAuthz); exists {
+		if snapshot, ok := value.(*AuthzSnapshot); ok {
+			return snapshot, nil
+		}
+	}
+
+	userID, ok := CurrentUserID(c)
+	if !ok {
+		return nil, mongo.ErrNoDocuments
+	}
+	if authzSource == nil {
+		return nil, errAuthzUnavailable
+	}
+
+	snapshot, err := authzSource(c.Request.Context(), userID)
+	if err != nil {
+		return nil, err
+	}
+	c.Set(contextKeyAuthz, snapshot)
+	return snapshot, nil
+}
+
+// abortWithAuthzError はスナップショット取得エラーを適切なレスポンスに変換する
+func abortWithAuthzError(c *gin.Context, err error) {
+	if err == mongo.ErrNoDocuments {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "ユーザーが見つかりません"})
+		return
+	}
+	utils.LogErrorCtx(c.Request.Context(), "Authz", err, "Failed to load authorization snapshot")
+	c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "ユーザー情報の取得に失敗しました"})
+}
+
+// RequirePermission は指定した権限を持つユーザーのみアクセスを許可するミドルウェア
+// JWTAuthMiddleware の後に使用する
+func RequirePermission(permission string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		snapshot, err := AuthzSnapshotFromContext(c)
+		if err != nil {
+			abortWithAuthzError(c, err)
+			return
+		}
+
+		if snapshot.Suspended {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "アカウントが停止されています"})
+			return
+		}
+
+		if !snapshot.HasPermission(permission) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "この操作を行う権限がありません"})
+			return
+		}
+
+		c.Next()
+	}
+}
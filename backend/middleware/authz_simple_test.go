@@ -0,0 +1,137 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// fakeAuthzUser はテスト用のユーザー状態（DBの代わり）
+type fakeAuthzUser struct {
+	role      string
+	isAdmin   bool
+	suspended bool
+}
+
+// useFakeAuthzSource は認可スナップショットの取得元をテスト用のマップに差し替える
+func useFakeAuthzSource(t *testing.T, users map[primitive.ObjectID]fakeAuthzUser) {
+	original := authzSource
+	authzSource = func(ctx context.Context, userID primitive.ObjectID) (*AuthzSnapshot, error) {
+		user, ok := users[userID]
+		if !ok {
+			return nil, mongo.ErrNoDocuments
+		}
+		return newAuthzSnapshot(userID, user.role, user.isAdmin, user.suspended), nil
+	}
+	t.Cleanup(func() { authzSource = original })
+}
+
+// setupAuthzTestRouter は管理者用・権限用のルートを持つテストルーターを作成する
+func setupAuthzTestRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+
+	protected := router.Group("/")
+	protected.Use(JWTAuthMiddleware())
+	protected.GET("/me", func(c *gin.Context) { c.JSON(http.StatusOK, gin.H{"ok": true}) })
+	protected.GET("/admin", AdminRequired(), func(c *gin.Context) { c.JSON(http.StatusOK, gin.H{"ok": true}) })
+	protected.GET("/users", RequirePermission(PermissionManageUsers), func(c *gin.Context) { c.JSON(http.StatusOK, gin.H{"ok": true}) })
+
+	return router
+}
+
+// TestAuthzDecisionUsesDatabaseState はトークンのクレームではなくDB由来の状態で認可されることをテストする
+func TestAuthzDecisionUsesDatabaseState(t *testing.T) {
+	demoted := primitive.NewObjectID()
+	admin := primitive.NewObjectID()
+	promoted := primitive.NewObjectID()
+	suspended := primitive.NewObjectID()
+	deleted := primitive.NewObjectID()
+
+	useFakeAuthzSource(t, map[primitive.ObjectID]fakeAuthzUser{
+		demoted:   {role: "student", isAdmin: false},
+		admin:     {role: "student", isAdmin: true},
+		promoted:  {role: "admin"},
+		suspended: {role: "admin", isAdmin: true, suspended: true},
+	})
+
+	tests := []struct {
+		name               string
+		userID             primitive.ObjectID
+		tokenIsAdmin       bool
+		path               string
+		expectedStatusCode int
+		description        string
+	}{
+		{
+			name:               "降格済みユーザー",
+			userID:             demoted,
+			tokenIsAdmin:       true,
+			path:               "/admin",
+			expectedStatusCode: http.StatusForbidden,
+			description:        "トークンにisAdmin=trueが残っていても降格済みなら拒否されること",
+		},
+		{
+			name:               "管理者フラグを持つユーザー",
+			userID:             admin,
+			tokenIsAdmin:       false,
+			path:               "/admin",
+			expectedStatusCode: http.StatusOK,
+			description:        "DB上で管理者であれば古いトークンでも許可されること",
+		},
+		{
+			name:               "adminロールのユーザー",
+			userID:             promoted,
+			path:               "/users",
+			expectedStatusCode: http.StatusOK,
+			description:        "adminロールはすべての権限を持つこと",
+		},
+		{
+			name:               "権限を持たないユーザー",
+			userID:             demoted,
+			tokenIsAdmin:       true,
+			path:               "/users",
+			expectedStatusCode: http.StatusForbidden,
+			description:        "権限を持たないユーザーは拒否されること",
+		},
+		{
+			name:               "停止中のユーザー",
+			userID:             suspended,
+			tokenIsAdmin:       true,
+			path:               "/me",
+			expectedStatusCode: http.StatusForbidden,
+			description:        "停止中のアカウントは有効なトークンでも拒否されること",
+		},
+		{
+			name:               "削除済みのユーザー",
+			userID:             deleted,
+			path:               "/me",
+			expectedStatusCode: http.StatusUnauthorized,
+			description:        "存在しないユーザーのトークンは拒否されること",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			role := "student"
+			if tt.tokenIsAdmin {
+				role = "admin"
+			}
+			token := generateTestToken(tt.userID.Hex(), "user@example.com", role, tt.tokenIsAdmin, time.Now().Add(time.Hour))
+
+			req, _ := http.NewRequest("GET", tt.path, nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+			w := httptest.NewRecorder()
+			setupAuthzTestRouter().ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedStatusCode, w.Code, tt.description)
+		})
+	}
+}
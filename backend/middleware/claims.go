@@ -0,0 +1,118 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	defaultTokenIssuer   = "juice-academy"
+	defaultTokenAudience = "juice-academy-api"
+	defaultClockSkew     = 30 * time.Second
+)
+
+// AccessClaims はアクセストークンに含めるクレームの型付き表現
+// トークンの発行（controllers）と検証（JWTAuthMiddleware）の両方でこの構造体を使用する
+type AccessClaims struct {
+	UserID  string `json:"user_id"`
+	Email   string `json:"email"`
+	Role    string `json:"role"`
+	IsAdmin bool   `json:"isAdmin"`
+	jwt.RegisteredClaims
+}
+
+// TokenIssuer はアクセストークンの発行者（iss）を返す
+func TokenIssuer() string {
+	if issuer := os.Getenv("JWT_ISSUER"); issuer != "" {
+		return issuer
+	}
+	return defaultTokenIssuer
+}
+
+// TokenAudience はアクセストークンの対象者（aud）を返す
+func TokenAudience() string {
+	if audience := os.Getenv("JWT_AUDIENCE"); audience != "" {
+		return audience
+	}
+	return defaultTokenAudience
+}
+
+// tokenClockSkew はexp/nbf/iat検証時に許容する時刻ずれを返す
+func tokenClockSkew() time.Duration {
+	if seconds := os.Getenv("JWT_CLOCK_SKEW_SECONDS"); seconds != "" {
+		if parsed, err := strconv.Atoi(seconds); err == nil && parsed >= 0 {
+			return time.Duration(parsed) * time.Second
+		}
+	}
+	return defaultClockSkew
+}
+
+// NewAccessClaims はユーザー情報から有効期限付きのアクセストークン用クレームを生成する
+func NewAccessClaims(userID primitive.ObjectID, email, role string, isAdmin bool, ttl time.Duration) AccessClaims {
+	now := time.Now()
+	return AccessClaims{
+		UserID:  userID.Hex(),
+		Email:   email,
+		Role:    role,
+		IsAdmin: isAdmin,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.New().String(), // JWT ID（一意識別子）
+			Subject:   userID.Hex(),
+			Issuer:    TokenIssuer(),
+			Audience:  jwt.ClaimStrings{TokenAudience()},
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		},
+	}
+}
+
+// SignAccessToken はクレームをHS256で署名してトークン文字列を返す
+func SignAccessToken(claims AccessClaims) (string, error) {
+	secret := os.Getenv("JWT_SECRET")
+	if secret == "" {
+		return "", fmt.Errorf("JWT_SECRET environment variable is not set")
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString([]byte(secret))
+}
+
+// ParseAccessToken はトークンの署名と標準クレーム（iss/aud/exp/nbf/iat）を検証し、型付きクレームを返す
+func ParseAccessToken(tokenString string) (*AccessClaims, error) {
+	claims := &AccessClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		return getJWTSecret(), nil
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithIssuer(TokenIssuer()),
+		jwt.WithAudience(TokenAudience()),
+		jwt.WithLeeway(tokenClockSkew()),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid {
+		return nil, errors.New("token is invalid")
+	}
+
+	// jwtライブラリはnbfの存在を必須にできないため、ここで確認する
+	if claims.NotBefore == nil {
+		return nil, errors.New("token is missing the nbf claim")
+	}
+
+	if _, err := primitive.ObjectIDFromHex(claims.UserID); err != nil {
+		return nil, errors.New("token has an invalid user_id claim")
+	}
+
+	return claims, nil
+}
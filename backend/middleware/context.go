@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// gin.Context に格納する認証情報のキー
+const (
+	contextKeyClaims = "access_claims"
+	contextKeyJTI    = "jti"
+)
+
+// setAuthContext は検証済みクレームをコンテキストに格納する
+func setAuthContext(c *gin.Context, claims *AccessClaims) {
+	c.Set(contextKeyClaims, claims)
+	if claims.ID != "" {
+		c.Set(contextKeyJTI, claims.ID)
+	}
+}
+
+// ClaimsFromContext は JWTAuthMiddleware が検証したクレームを返す
+func ClaimsFromContext(c *gin.Context) (*AccessClaims, bool) {
+	value, exists := c.Get(contextKeyClaims)
+	if !exists {
+		return nil, false
+	}
+	claims, ok := value.(*AccessClaims)
+	return claims, ok && claims != nil
+}
+
+// CurrentUserID は認証済みユーザーのIDをObjectIDとして返す
+// 操作対象のユーザーは必ずこの関数で署名済みのJWTから取得し、リクエストのボディやクエリで受け取ったIDは信用しない
+// JWTAuthMiddleware で形式を検証済みのため、呼び出し側での ObjectIDFromHex は不要
+func CurrentUserID(c *gin.Context) (primitive.ObjectID, bool) {
+	claims, ok := ClaimsFromContext(c)
+	if !ok {
+		return primitive.NilObjectID, false
+	}
+	userID, err := primitive.ObjectIDFromHex(claims.UserID)
+	if err != nil {
+		return primitive.NilObjectID, false
+	}
+	return userID, true
+}
+
+// CurrentTokenID はアクセストークンのJTIと有効期限を返す（ログアウト時のブラックリスト登録用）
+func CurrentTokenID(c *gin.Context) (jti string, expiresAt time.Time, ok bool) {
+	claims, exists := ClaimsFromContext(c)
+	if !exists || claims.ID == "" {
+		return "", time.Time{}, false
+	}
+	if claims.ExpiresAt != nil {
+		expiresAt = claims.ExpiresAt.Time
+	}
+	return claims.ID, expiresAt, true
+}
@@ -0,0 +1,137 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"juice_academy_backend/utils"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// 利用権（サブスクリプションで利用できる機能）の名前
+const (
+	EntitlementPremiumContent = "premium_content"
+)
+
+// EntitlementErrorCode は利用権がない場合のエラーコード
+// フロントエンドはこのコードを受け取るとプラン選択画面へ誘導する
+const EntitlementErrorCode = "subscription_required"
+
+const contextKeyEntitlements = "entitlement_snapshot"
+
+var errEntitlementsUnavailable = errors.New("entitlement source is not initialized")
+
+// EntitlementSnapshot はサブスクリプションのプラン・状態・猶予期間から導出した利用権
+type EntitlementSnapshot struct {
+	UserID  string `json:"user_id"`
+	PriceID string `json:"price_id,omitempty"`
+	// Status はサブスクリプションの状態（契約がない場合は none）
+	Status       string   `json:"status"`
+	Entitlements []string `json:"entitlements"`
+	// GraceUntil は支払い遅延中に利用を続けられる期限
+	GraceUntil *time.Time `json:"grace_until,omitempty"`
+	// ValidUntil は猶予期間や契約期間の終了により利用権が変わる日時（それまでキャッシュを使える）
+	ValidUntil *time.Time `json:"valid_until,omitempty"`
+	LoadedAt   time.Time  `json:"loaded_at"`
+}
+
+// Has は指定した利用権を持つかどうかを返す
+func (s *EntitlementSnapshot) Has(entitlement string) bool {
+	for _, e := range s.Entitlements {
+		if e == entitlement {
+			return true
+		}
+	}
+	return false
+}
+
+// Expired は now の時点でスナップショットを再計算する必要があるかどうかを返す
+func (s *EntitlementSnapshot) Expired(now time.Time) bool {
+	return s.ValidUntil != nil && !now.Before(*s.ValidUntil)
+}
+
+// entitlementSource は利用権スナップショットの取得元（SetEntitlementSource で設定、テストで差し替え可能）
+var entitlementSource func(ctx context.Context, userID primitive.ObjectID) (*EntitlementSnapshot, error)
+
+// SetEntitlementSource は利用権スナップショットの取得元を設定する
+// サブスクリプションの解釈は controllers パッケージが持つため、起動時に注入する
+func SetEntitlementSource(source func(ctx context.Context, userID primitive.ObjectID) (*EntitlementSnapshot, error)) {
+	entitlementSource = source
+}
+
+// EntitlementsFromContext はリクエスト中に読み込んだ利用権スナップショットを返す
+// 未読込の場合は取得元から読み込み、コンテキストに保存する
+func EntitlementsFromContext(c *gin.Context) (*EntitlementSnapshot, error) {
+	if value, exists := c.Get(contextKeyEntitlements); exists {
+		if snapshot, ok := value.(*EntitlementSnapshot); ok {
+			return snapshot, nil
+		}
+	}
+
+	userID, ok := CurrentUserID(c)
+	if !ok {
+		return nil, mongo.ErrNoDocuments
+	}
+	if entitlementSource == nil {
+		return nil, errEntitlementsUnavailable
+	}
+
+	snapshot, err := entitlementSource(c.Request.Context(), userID)
+	if err != nil {
+		return nil, err
+	}
+	c.Set(contextKeyEntitlements, snapshot)
+	return snapshot, nil
+}
+
+// RequireEntitlement は指定した利用権を持つユーザーのみアクセスを許可するミドルウェア
+// 利用権がない場合は 403 と EntitlementErrorCode を返す。管理者は内容の確認のため契約がなくても許可する
+// JWTAuthMiddleware の後に使用する
+func RequireEntitlement(entitlement string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if authzSource != nil {
+			authz, err := AuthzSnapshotFromContext(c)
+			if err != nil {
+				abortWithAuthzError(c, err)
+				return
+			}
+			if authz.IsAdmin && !authz.Suspended {
+				c.Next()
+				return
+			}
+		}
+
+		snapshot, err := EntitlementsFromContext(c)
+		if err == mongo.ErrNoDocuments {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "認証が必要です"})
+			return
+		}
+		if err != nil {
+			utils.LogErrorCtx(c.Request.Context(), "Entitlement", err, "Failed to load entitlements")
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "契約情報の取得に失敗しました"})
+			return
+		}
+
+		if !snapshot.Has(entitlement) {
+			AbortEntitlementRequired(c, entitlement)
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// AbortEntitlementRequired は利用権がないことを表す 403 と EntitlementErrorCode を返す
+// お知らせごとに会員限定かどうかが変わる場合など、ハンドラの中で利用権を確認するときに使用する
+func AbortEntitlementRequired(c *gin.Context, entitlement string) {
+	c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+		"error":       "この機能を利用するには有効なサブスクリプションが必要です",
+		"code":        EntitlementErrorCode,
+		"entitlement": entitlement,
+	})
+}
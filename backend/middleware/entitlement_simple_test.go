@@ -0,0 +1,94 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// useFakeEntitlementSource は利用権スナップショットの取得元をテスト用のマップに差し替える
+func useFakeEntitlementSource(t *testing.T, entitlements map[primitive.ObjectID][]string) {
+	original := entitlementSource
+	entitlementSource = func(ctx context.Context, userID primitive.ObjectID) (*EntitlementSnapshot, error) {
+		granted, ok := entitlements[userID]
+		if !ok {
+			return nil, errors.New("subscription lookup failed")
+		}
+		return &EntitlementSnapshot{UserID: userID.Hex(), Status: "active", Entitlements: granted, LoadedAt: time.Now()}, nil
+	}
+	t.Cleanup(func() { entitlementSource = original })
+}
+
+// TestRequireEntitlement は利用権による機能の制限をテストする
+func TestRequireEntitlement(t *testing.T) {
+	subscriber := primitive.NewObjectID()
+	free := primitive.NewObjectID()
+	admin := primitive.NewObjectID()
+	broken := primitive.NewObjectID()
+
+	useFakeAuthzSource(t, map[primitive.ObjectID]fakeAuthzUser{
+		subscriber: {role: "student"},
+		free:       {role: "student"},
+		admin:      {role: "admin"},
+		broken:     {role: "student"},
+	})
+	useFakeEntitlementSource(t, map[primitive.ObjectID][]string{
+		subscriber: {EntitlementPremiumContent},
+		free:       {},
+		admin:      {},
+	})
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	protected := router.Group("/")
+	protected.Use(JWTAuthMiddleware())
+	protected.GET("/premium", RequireEntitlement(EntitlementPremiumContent), func(c *gin.Context) { c.JSON(http.StatusOK, gin.H{"ok": true}) })
+
+	tests := []struct {
+		name               string
+		userID             primitive.ObjectID
+		expectedStatusCode int
+		expectedCode       string
+	}{
+		{name: "契約中のユーザー", userID: subscriber, expectedStatusCode: http.StatusOK},
+		{name: "未契約のユーザー", userID: free, expectedStatusCode: http.StatusForbidden, expectedCode: EntitlementErrorCode},
+		{name: "管理者は契約がなくても利用できる", userID: admin, expectedStatusCode: http.StatusOK},
+		{name: "契約情報を取得できない", userID: broken, expectedStatusCode: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := generateTestToken(tt.userID.Hex(), "user@example.com", "student", false, time.Now().Add(time.Hour))
+			req, _ := http.NewRequest("GET", "/premium", nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedStatusCode, w.Code)
+			if tt.expectedCode != "" {
+				var body map[string]interface{}
+				assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+				assert.Equal(t, tt.expectedCode, body["code"], "フロントエンドが誘導に使うエラーコードを返すこと")
+				assert.Equal(t, EntitlementPremiumContent, body["entitlement"])
+			}
+		})
+	}
+}
+
+// TestEntitlementSnapshotExpired はキャッシュした利用権の有効期限の判定をテストする
+func TestEntitlementSnapshotExpired(t *testing.T) {
+	now := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	validUntil := now.Add(time.Hour)
+
+	assert.False(t, (&EntitlementSnapshot{}).Expired(now), "期限のないスナップショットはTTLまで使えること")
+	assert.False(t, (&EntitlementSnapshot{ValidUntil: &validUntil}).Expired(now))
+	assert.True(t, (&EntitlementSnapshot{ValidUntil: &validUntil}).Expired(validUntil))
+}
@@ -1,7 +1,6 @@
 package middleware
 
 import (
-	"fmt"
 	"juice_academy_backend/services"
 	"log"
 	"net/http"
@@ -10,7 +9,6 @@ import (
 	"sync"
 
 	"github.com/gin-gonic/gin"
-	jwt "github.com/golang-jwt/jwt/v5"
 )
 
 var (
@@ -32,6 +30,7 @@ func getJWTSecret() []byte {
 }
 
 // JWTAuthMiddleware は JWT トークンの検証を行うミドルウェア。
+// 署名に加えて iss/aud/exp/nbf を検証し、型付きクレームをコンテキストに格納する。
 func JWTAuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
@@ -45,38 +44,17 @@ func JWTAuthMiddleware() gin.HandlerFunc {
 		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
 
 		// トークンの検証
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-			}
-			return getJWTSecret(), nil
-		})
-
+		claims, err := ParseAccessToken(tokenString)
 		if err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "無効なトークンです: " + err.Error()})
 			c.Abort()
 			return
 		}
 
-		if !token.Valid {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "トークンが無効です"})
-			c.Abort()
-			return
-		}
-
-		// クレームの取得
-		claims, ok := token.Claims.(jwt.MapClaims)
-		if !ok {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "無効なトークン形式です"})
-			c.Abort()
-			return
-		}
-
-		// JTI（JWT ID）の取得とブラックリストチェック
-		jti, jtiExists := claims["jti"].(string)
-		if jtiExists {
+		// JTI（JWT ID）のブラックリストチェック
+		if claims.ID != "" {
 			// Redisでブラックリストチェック
-			isBlacklisted, err := services.IsTokenBlacklisted(jti)
+			isBlacklisted, err := services.IsTokenBlacklisted(claims.ID)
 			if err == nil && isBlacklisted {
 				c.JSON(http.StatusUnauthorized, gin.H{"error": "無効化されたトークンです"})
 				c.Abort()
@@ -84,33 +62,36 @@ func JWTAuthMiddleware() gin.HandlerFunc {
 			}
 		}
 
-		// ユーザーIDをコンテキストに設定
-		userID, ok := claims["user_id"].(string)
-		if !ok {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "ユーザーIDが見つかりません"})
-			c.Abort()
-			return
-		}
-
-		c.Set("user_id", userID)
-		if jtiExists {
-			c.Set("jti", jti)
-		}
+		setAuthContext(c, claims)
 
-		// 有効期限もコンテキストに設定
-		if exp, expExists := claims["exp"]; expExists {
-			c.Set("exp", exp)
+		// 停止されたアカウントはトークンの有効期限内でも次のリクエストから拒否する
+		if authzSource != nil {
+			snapshot, err := AuthzSnapshotFromContext(c)
+			if err != nil {
+				abortWithAuthzError(c, err)
+				return
+			}
+			if snapshot.Suspended {
+				c.JSON(http.StatusForbidden, gin.H{"error": "アカウントが停止されています"})
+				c.Abort()
+				return
+			}
 		}
 
-		// 追加のユーザー情報をコンテキストに設定
-		if isAdmin, exists := claims["isAdmin"]; exists {
-			c.Set("is_admin", isAdmin)
-		}
+		c.Next()
+	}
+}
 
-		if role, exists := claims["role"]; exists {
-			c.Set("role", role)
+// OptionalJWTAuth は公開エンドポイント向けのミドルウェア。
+// Authorization ヘッダーがなければ匿名として通し、ある場合は JWTAuthMiddleware と同じ検証を行う
+// （期限切れなどの無効なトークンは匿名扱いにせず 401 を返し、クライアントに再取得を促す）。
+func OptionalJWTAuth() gin.HandlerFunc {
+	auth := JWTAuthMiddleware()
+	return func(c *gin.Context) {
+		if c.GetHeader("Authorization") == "" {
+			c.Next()
+			return
 		}
-
-		c.Next()
+		auth(c)
 	}
 }
@@ -1,6 +1,7 @@
 package middleware
 
 import (
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"os"
@@ -11,6 +12,7 @@ import (
 	"github.com/gin-gonic/gin"
 	jwt "github.com/golang-jwt/jwt/v5"
 	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 // setupJWTTestRouter はJWTテスト用のGinルーターを作成する
@@ -23,15 +25,15 @@ func setupJWTTestRouter() *gin.Engine {
 	protected.Use(JWTAuthMiddleware())
 	{
 		protected.GET("/test", func(c *gin.Context) {
-			userID, exists := c.Get("user_id")
-			if !exists {
+			userID, ok := CurrentUserID(c)
+			if !ok {
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "user_id not found in context"})
 				return
 			}
 
 			c.JSON(http.StatusOK, gin.H{
 				"message": "Success",
-				"user_id": userID,
+				"user_id": userID.Hex(),
 			})
 		})
 	}
@@ -41,14 +43,28 @@ func setupJWTTestRouter() *gin.Engine {
 
 // generateTestToken はテスト用のJWTトークンを生成する
 func generateTestToken(userID, email, role string, isAdmin bool, expiry time.Time) string {
-	claims := jwt.MapClaims{
+	return signTestClaims(testClaims(userID, email, role, isAdmin, expiry))
+}
+
+// testClaims はテスト用の標準的なクレームを生成する
+func testClaims(userID, email, role string, isAdmin bool, expiry time.Time) jwt.MapClaims {
+	now := time.Now()
+	return jwt.MapClaims{
+		"jti":     "test-jti-" + userID,
 		"user_id": userID,
 		"email":   email,
 		"role":    role,
 		"isAdmin": isAdmin,
+		"iss":     TokenIssuer(),
+		"aud":     TokenAudience(),
+		"iat":     now.Unix(),
+		"nbf":     now.Unix(),
 		"exp":     expiry.Unix(),
 	}
+}
 
+// signTestClaims はクレームに署名してトークン文字列を返す
+func signTestClaims(claims jwt.MapClaims) string {
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	// テスト用の固定シークレットを使用（環境変数が設定されていない場合）
 	secret := os.Getenv("JWT_SECRET")
@@ -182,3 +198,158 @@ func TestJWTAuthMiddlewareEdgeCases(t *testing.T) {
 		})
 	}
 }
+
+// TestJWTAuthMiddlewareStandardClaims は iss/aud/nbf など標準クレームの検証をテストする
+func TestJWTAuthMiddlewareStandardClaims(t *testing.T) {
+	const userID = "507f1f77bcf86cd799439011"
+
+	tests := []struct {
+		name               string
+		mutate             func(claims jwt.MapClaims)
+		expectedStatusCode int
+		description        string
+	}{
+		{
+			name:               "発行者が異なる",
+			mutate:             func(claims jwt.MapClaims) { claims["iss"] = "another-issuer" },
+			expectedStatusCode: http.StatusUnauthorized,
+			description:        "issが一致しない場合にアクセスが拒否されること",
+		},
+		{
+			name:               "対象者が異なる",
+			mutate:             func(claims jwt.MapClaims) { claims["aud"] = "another-audience" },
+			expectedStatusCode: http.StatusUnauthorized,
+			description:        "audが一致しない場合にアクセスが拒否されること",
+		},
+		{
+			name:               "nbfがない",
+			mutate:             func(claims jwt.MapClaims) { delete(claims, "nbf") },
+			expectedStatusCode: http.StatusUnauthorized,
+			description:        "nbfが含まれない場合にアクセスが拒否されること",
+		},
+		{
+			name:               "nbfが未来",
+			mutate:             func(claims jwt.MapClaims) { claims["nbf"] = time.Now().Add(10 * time.Minute).Unix() },
+			expectedStatusCode: http.StatusUnauthorized,
+			description:        "nbfが許容範囲を超えて未来の場合にアクセスが拒否されること",
+		},
+		{
+			name:               "時刻ずれの範囲内",
+			mutate:             func(claims jwt.MapClaims) { claims["nbf"] = time.Now().Add(5 * time.Second).Unix() },
+			expectedStatusCode: http.StatusOK,
+			description:        "許容範囲内の時刻ずれは受け入れられること",
+		},
+		{
+			name:               "不正なユーザーID",
+			mutate:             func(claims jwt.MapClaims) { claims["user_id"] = "not-an-object-id" },
+			expectedStatusCode: http.StatusUnauthorized,
+			description:        "user_idがObjectID形式でない場合にアクセスが拒否されること",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := testClaims(userID, "test@example.com", "student", false, time.Now().Add(time.Hour))
+			tt.mutate(claims)
+
+			req, _ := http.NewRequest("GET", "/protected/test", nil)
+			req.Header.Set("Authorization", "Bearer "+signTestClaims(claims))
+
+			w := httptest.NewRecorder()
+			router := setupJWTTestRouter()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedStatusCode, w.Code, tt.description)
+		})
+	}
+}
+
+// TestAccessClaimsContext は型付きコンテキストアクセサのテストを行う
+func TestAccessClaimsContext(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(JWTAuthMiddleware())
+	router.GET("/me", func(c *gin.Context) {
+		userID, ok := CurrentUserID(c)
+		if !ok {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "user id not found"})
+			return
+		}
+		claims, _ := ClaimsFromContext(c)
+		jti, expiresAt, _ := CurrentTokenID(c)
+		c.JSON(http.StatusOK, gin.H{
+			"user_id":    userID.Hex(),
+			"role":       claims.Role,
+			"is_admin":   claims.IsAdmin,
+			"jti":        jti,
+			"expires_at": expiresAt.Unix(),
+		})
+	})
+
+	userID := primitive.NewObjectID()
+	expiry := time.Now().Add(time.Hour)
+	token := signTestClaims(testClaims(userID.Hex(), "admin@example.com", "admin", true, expiry))
+
+	req, _ := http.NewRequest("GET", "/me", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var body map[string]interface{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, userID.Hex(), body["user_id"])
+	assert.Equal(t, "admin", body["role"])
+	assert.Equal(t, true, body["is_admin"])
+	assert.Equal(t, "test-jti-"+userID.Hex(), body["jti"])
+	assert.Equal(t, float64(expiry.Unix()), body["expires_at"])
+}
+
+// TestOptionalJWTAuth はトークンの有無による OptionalJWTAuth の動作をテストする
+func TestOptionalJWTAuth(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/public", OptionalJWTAuth(), func(c *gin.Context) {
+		_, authenticated := CurrentUserID(c)
+		c.JSON(http.StatusOK, gin.H{"authenticated": authenticated})
+	})
+
+	userID := primitive.NewObjectID().Hex()
+	tests := []struct {
+		name                  string
+		authHeader            string
+		expectedStatusCode    int
+		expectedAuthenticated bool
+	}{
+		{name: "ヘッダーなし", expectedStatusCode: http.StatusOK},
+		{
+			name:                  "有効なトークン",
+			authHeader:            "Bearer " + generateTestToken(userID, "user@example.com", "student", false, time.Now().Add(time.Hour)),
+			expectedStatusCode:    http.StatusOK,
+			expectedAuthenticated: true,
+		},
+		{
+			name:               "期限切れのトークン",
+			authHeader:         "Bearer " + generateTestToken(userID, "user@example.com", "student", false, time.Now().Add(-time.Hour)),
+			expectedStatusCode: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", "/public", nil)
+			if tt.authHeader != "" {
+				req.Header.Set("Authorization", tt.authHeader)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedStatusCode, w.Code)
+			if tt.expectedStatusCode == http.StatusOK {
+				var body map[string]bool
+				assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+				assert.Equal(t, tt.expectedAuthenticated, body["authenticated"])
+			}
+		})
+	}
+}
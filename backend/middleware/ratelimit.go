@@ -12,6 +12,15 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
+// rateLimitKey returns the Redis key for the request: IP, plus the user ID when authenticated
+func rateLimitKey(c *gin.Context, keyPrefix string) string {
+	ip := c.ClientIP()
+	if uid, ok := CurrentUserID(c); ok {
+		return fmt.Sprintf("rl:%s:%s:%s", keyPrefix, ip, uid.Hex())
+	}
+	return fmt.Sprintf("rl:%s:%s", keyPrefix, ip)
+}
+
 // RateLimit provides a simple Redis-backed rate limiter middleware.
 // keyPrefix: logical bucket name
 // max: maximum allowed requests within window per key (IP[:user])
@@ -24,11 +33,7 @@ func RateLimit(keyPrefix string, max int, window time.Duration) gin.HandlerFunc
 			return
 		}
 
-		ip := c.ClientIP()
-		key := fmt.Sprintf("rl:%s:%s", keyPrefix, ip)
-		if uid, exists := c.Get("user_id"); exists {
-			key = fmt.Sprintf("rl:%s:%s:%v", keyPrefix, ip, uid)
-		}
+		key := rateLimitKey(c, keyPrefix)
 
 		ctx := context.Background()
 		// Increment counter
@@ -0,0 +1,24 @@
+package middleware
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TestRateLimitKey は認証済みのリクエストをJWTのユーザーIDごとに数えることをテストする
+func TestRateLimitKey(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", "/", nil)
+	c.Request.RemoteAddr = "192.0.2.1:1234"
+
+	assert.Equal(t, "rl:login:192.0.2.1", rateLimitKey(c, "login"))
+
+	userID := primitive.NewObjectID()
+	setAuthContext(c, &AccessClaims{UserID: userID.Hex()})
+	assert.Equal(t, "rl:login:192.0.2.1:"+userID.Hex(), rateLimitKey(c, "login"))
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"juice_academy_backend/controllers"
+
+	"github.com/joho/godotenv"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// 請求書バックフィルスクリプト
+// invoices コレクションの導入前の請求書を Stripe から取り込む（何度実行しても結果は同じ）
+//
+// 使い方:
+//   go run ./scripts/backfill_invoices
+//   go run ./scripts/backfill_invoices -customer cus_xxx
+
+func main() {
+	customerID := flag.String("customer", "", "対象を1人の Stripe 顧客IDに限定する")
+	flag.Parse()
+
+	fmt.Println("=== Juice Academy 請求書バックフィル ===")
+	fmt.Println()
+
+	// 環境変数の読み込み（プロジェクトルートの.envファイルを探す）
+	envPaths := []string{".env", "../.env", "../../.env", "../../../.env"}
+	envLoaded := false
+	for _, envPath := range envPaths {
+		if err := godotenv.Load(envPath); err == nil {
+			envLoaded = true
+			log.Printf("✓ .envファイルを読み込みました: %s", envPath)
+			break
+		}
+	}
+	if !envLoaded {
+		log.Printf("警告: .envファイルが見つかりませんでした。環境変数が直接設定されていることを確認してください。")
+	}
+	if os.Getenv("STRIPE_SECRET_KEY") == "" {
+		log.Fatal("STRIPE_SECRET_KEY が設定されていません")
+	}
+
+	mongoURI := os.Getenv("MONGODB_URI")
+	if mongoURI == "" {
+		mongoURI = "mongodb://localhost:27017/juice_academy"
+	}
+
+	connectCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client, err := mongo.Connect(connectCtx, options.Client().ApplyURI(mongoURI))
+	if err != nil {
+		log.Fatal("MongoDB接続失敗:", err)
+	}
+	defer client.Disconnect(context.Background())
+
+	if err := client.Ping(connectCtx, nil); err != nil {
+		log.Fatal("MongoDB Ping失敗:", err)
+	}
+	fmt.Println("✓ MongoDB接続成功")
+
+	controllers.InitPaymentCollection(client)
+	controllers.InitInvoiceCollection(client)
+
+	// 顧客ごとに Stripe を呼び出すため、接続確認とは別に長めのタイムアウトを設定
+	ctx, cancelBackfill := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancelBackfill()
+
+	report, err := controllers.BackfillInvoices(ctx, controllers.InvoiceBackfillOptions{CustomerID: *customerID})
+	if err != nil {
+		log.Fatal("請求書の取り込みに失敗しました:", err)
+	}
+
+	fmt.Println()
+	for _, failure := range report.Failures {
+		fmt.Println("失敗: " + failure)
+	}
+	fmt.Printf("合計: 顧客 %d件 / 請求書 %d件 / 失敗 %d件\n", report.Customers, report.Invoices, len(report.Failures))
+	if len(report.Failures) > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"juice_academy_backend/controllers"
+
+	"github.com/joho/godotenv"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// 名簿一括登録スクリプト
+// student_id,name_kana,email のCSVから仮登録ユーザーを作成し、招待メールを送信待ちにする
+// 招待メールは稼働中のAPIサーバーのワーカーが送信する
+//
+// 使い方:
+//   go run ./scripts/import_roster -file roster.csv -dry-run
+//   go run ./scripts/import_roster -file roster.csv
+
+func main() {
+	filePath := flag.String("file", "", "取り込む名簿CSVのパス")
+	dryRun := flag.Bool("dry-run", false, "検証のみを行い、ユーザーの作成とメール送信は行わない")
+	flag.Parse()
+
+	if *filePath == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	fmt.Println("=== Juice Academy 名簿一括登録 ===")
+	if *dryRun {
+		fmt.Println("ドライラン: データベースは変更されません")
+	}
+	fmt.Println()
+
+	// 環境変数の読み込み（プロジェクトルートの.envファイルを探す）
+	envPaths := []string{".env", "../.env", "../../.env", "../../../.env"}
+	envLoaded := false
+	for _, envPath := range envPaths {
+		if err := godotenv.Load(envPath); err == nil {
+			envLoaded = true
+			log.Printf("✓ .envファイルを読み込みました: %s", envPath)
+			break
+		}
+	}
+	if !envLoaded {
+		log.Printf("警告: .envファイルが見つかりませんでした。環境変数が直接設定されていることを確認してください。")
+	}
+
+	file, err := os.Open(*filePath)
+	if err != nil {
+		log.Fatal("CSVファイルを開けません:", err)
+	}
+	defer file.Close()
+
+	mongoURI := os.Getenv("MONGODB_URI")
+	if mongoURI == "" {
+		mongoURI = "mongodb://localhost:27017/juice_academy"
+	}
+
+	connectCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client, err := mongo.Connect(connectCtx, options.Client().ApplyURI(mongoURI))
+	if err != nil {
+		log.Fatal("MongoDB接続失敗:", err)
+	}
+	defer client.Disconnect(context.Background())
+
+	if err := client.Ping(connectCtx, nil); err != nil {
+		log.Fatal("MongoDB Ping失敗:", err)
+	}
+	fmt.Println("✓ MongoDB接続成功")
+
+	controllers.InitUserCollection(client)
+	controllers.InitInvitationCollection(client)
+	controllers.InitSettingsCollection(client)
+
+	// 行ごとに重複確認と登録を行うため、接続確認とは別に長めのタイムアウトを設定
+	ctx, cancelImport := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancelImport()
+
+	report, err := controllers.ImportRoster(ctx, file, controllers.RosterImportOptions{DryRun: *dryRun})
+	if err != nil {
+		log.Fatal("名簿の取り込みに失敗しました:", err)
+	}
+
+	fmt.Println()
+	for _, row := range report.Rows {
+		line := fmt.Sprintf("%4d行目 %-12s %-14s %s", row.Line, row.Status, row.StudentID, row.Email)
+		if row.Error != "" {
+			line += "  ← " + row.Error
+		}
+		fmt.Println(line)
+	}
+
+	fmt.Println()
+	fmt.Printf("合計: %d行 / 作成: %d / スキップ: %d\n", report.Total, report.Created, report.Skipped)
+	if !*dryRun && report.Created > 0 {
+		fmt.Println("招待メールはAPIサーバーが順次送信します")
+	}
+}
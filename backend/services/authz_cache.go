@@ -0,0 +1,69 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// AuthzSnapshotTTL は認可スナップショットをキャッシュする期間
+// 権限変更時は明示的に無効化するため、TTLは取りこぼし時の上限として機能する
+const AuthzSnapshotTTL = 5 * time.Minute
+
+func authzSnapshotKey(userID string) string {
+	return fmt.Sprintf("authz:%s", userID)
+}
+
+// GetAuthzSnapshot はキャッシュ済みの認可スナップショットを dest にデコードする
+// キャッシュが存在しない場合は found=false を返す
+func GetAuthzSnapshot(ctx context.Context, userID string, dest interface{}) (bool, error) {
+	if RedisClient == nil {
+		return false, fmt.Errorf("Redisクライアントが初期化されていません")
+	}
+
+	raw, err := RedisClient.Get(ctx, authzSnapshotKey(userID)).Bytes()
+	if err == redis.Nil {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("認可スナップショットの取得に失敗しました: %v", err)
+	}
+
+	if err := json.Unmarshal(raw, dest); err != nil {
+		return false, fmt.Errorf("認可スナップショットのデコードに失敗しました: %v", err)
+	}
+	return true, nil
+}
+
+// SetAuthzSnapshot は認可スナップショットをキャッシュに保存する
+func SetAuthzSnapshot(ctx context.Context, userID string, snapshot interface{}) error {
+	if RedisClient == nil {
+		return fmt.Errorf("Redisクライアントが初期化されていません")
+	}
+
+	raw, err := json.Marshal(snapshot)
+	if err != nil {
+		return fmt.Errorf("認可スナップショットのエンコードに失敗しました: %v", err)
+	}
+
+	if err := RedisClient.Set(ctx, authzSnapshotKey(userID), raw, AuthzSnapshotTTL).Err(); err != nil {
+		return fmt.Errorf("認可スナップショットの保存に失敗しました: %v", err)
+	}
+	return nil
+}
+
+// InvalidateAuthzSnapshot は権限・ロール・停止状態の変更時にキャッシュを破棄する
+// 次のリクエストでデータベースから再読込されるため、変更が即座に反映される
+func InvalidateAuthzSnapshot(ctx context.Context, userID string) error {
+	if RedisClient == nil {
+		return fmt.Errorf("Redisクライアントが初期化されていません")
+	}
+
+	if err := RedisClient.Del(ctx, authzSnapshotKey(userID)).Err(); err != nil {
+		return fmt.Errorf("認可スナップショットの無効化に失敗しました: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,290 @@
+package services
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/stripe/stripe-go/v81"
+	"github.com/stripe/stripe-go/v81/client"
+)
+
+// BillingProvider は決済サービス（Stripe）への操作
+// 本番では StripeBillingProvider を使い、テストでは FakeBillingProvider に差し替える
+// 戻り値は Stripe SDK の型をそのまま使い、Webhook の処理と同じ形で扱えるようにする
+type BillingProvider interface {
+	// ListCustomersByEmail はメールアドレスが一致する顧客を取得する
+	ListCustomersByEmail(ctx context.Context, email string) ([]*stripe.Customer, error)
+	CreateCustomer(ctx context.Context, params *stripe.CustomerParams) (*stripe.Customer, error)
+	UpdateCustomer(ctx context.Context, customerID string, params *stripe.CustomerParams) (*stripe.Customer, error)
+	DeleteCustomer(ctx context.Context, customerID string) error
+
+	// CreateSetupIntent はカード登録用の SetupIntent を作成する
+	CreateSetupIntent(ctx context.Context, params *stripe.SetupIntentParams) (*stripe.SetupIntent, error)
+	// AttachPaymentMethod は支払い方法を顧客に紐付ける
+	AttachPaymentMethod(ctx context.Context, paymentMethodID, customerID string) error
+	// ListCardPaymentMethods は顧客に紐付いたカードを取得する
+	ListCardPaymentMethods(ctx context.Context, customerID string) ([]*stripe.PaymentMethod, error)
+	DetachPaymentMethod(ctx context.Context, paymentMethodID string) error
+
+	CreateSubscription(ctx context.Context, params *stripe.SubscriptionParams) (*stripe.Subscription, error)
+	GetSubscription(ctx context.Context, subscriptionID string) (*stripe.Subscription, error)
+	UpdateSubscription(ctx context.Context, subscriptionID string, params *stripe.SubscriptionParams) (*stripe.Subscription, error)
+	// CancelSubscription はサブスクリプションを即時解約する
+	CancelSubscription(ctx context.Context, subscriptionID string) (*stripe.Subscription, error)
+	// SchedulePriceChange は現在の契約期間の終了時に価格を切り替える予約（Subscription Schedule）を作成する
+	SchedulePriceChange(ctx context.Context, subscriptionID, priceID string) (*stripe.SubscriptionSchedule, error)
+	// ReleaseSubscriptionSchedule は予約を解除し、サブスクリプションを現在の内容のまま継続させる
+	ReleaseSubscriptionSchedule(ctx context.Context, scheduleID string) error
+
+	// ListInvoices は顧客の請求書を新しい順に取得する
+	ListInvoices(ctx context.Context, customerID string) ([]*stripe.Invoice, error)
+	// UpcomingInvoice は次回の請求書（未確定）を取得する
+	UpcomingInvoice(ctx context.Context, params *stripe.InvoiceUpcomingParams) (*stripe.Invoice, error)
+
+	// CreateRefund は支払い（PaymentIntent）の全額または一部を返金する
+	CreateRefund(ctx context.Context, params *stripe.RefundParams) (*stripe.Refund, error)
+	// CreateCreditNote は請求書にクレジットノート（減額・返金・残高への充当）を発行する
+	CreateCreditNote(ctx context.Context, params *stripe.CreditNoteParams) (*stripe.CreditNote, error)
+
+	// UpdateDispute は異議申し立て（チャージバック）に証拠を登録する（Submit を指定するとカード会社に提出する）
+	UpdateDispute(ctx context.Context, disputeID string, params *stripe.DisputeParams) (*stripe.Dispute, error)
+	// UploadFile は証拠書類などのファイルを Stripe にアップロードする
+	UploadFile(ctx context.Context, params *stripe.FileParams) (*stripe.File, error)
+
+	// ListActivePromotionCodes はコードが一致する有効なプロモーションコードを取得する
+	ListActivePromotionCodes(ctx context.Context, code string) ([]*stripe.PromotionCode, error)
+
+	// ListRecurringPrices は有効な定期課金の価格を商品（Product）を展開して取得する
+	ListRecurringPrices(ctx context.Context) ([]*stripe.Price, error)
+}
+
+// StripeBillingProvider は Stripe API を使う BillingProvider
+type StripeBillingProvider struct {
+	api *client.API
+}
+
+// NewStripeBillingProvider は秘密鍵を指定して Stripe のクライアントを作成する
+// グローバルの stripe.Key は使わないため、複数のアカウントやテスト用の鍵を併用できる
+func NewStripeBillingProvider(secretKey string) *StripeBillingProvider {
+	return &StripeBillingProvider{api: client.New(secretKey, nil)}
+}
+
+func (p *StripeBillingProvider) ListCustomersByEmail(ctx context.Context, email string) ([]*stripe.Customer, error) {
+	params := &stripe.CustomerListParams{Email: stripe.String(email)}
+	params.Context = ctx
+	// 同じメールアドレスの顧客が複数存在する可能性を考慮する
+	params.Limit = stripe.Int64(10)
+
+	var customers []*stripe.Customer
+	iter := p.api.Customers.List(params)
+	for iter.Next() {
+		customers = append(customers, iter.Customer())
+	}
+	return customers, iter.Err()
+}
+
+func (p *StripeBillingProvider) CreateCustomer(ctx context.Context, params *stripe.CustomerParams) (*stripe.Customer, error) {
+	params.Context = ctx
+	return p.api.Customers.New(params)
+}
+
+func (p *StripeBillingProvider) UpdateCustomer(ctx context.Context, customerID string, params *stripe.CustomerParams) (*stripe.Customer, error) {
+	params.Context = ctx
+	return p.api.Customers.Update(customerID, params)
+}
+
+func (p *StripeBillingProvider) DeleteCustomer(ctx context.Context, customerID string) error {
+	params := &stripe.CustomerParams{}
+	params.Context = ctx
+	_, err := p.api.Customers.Del(customerID, params)
+	return err
+}
+
+func (p *StripeBillingProvider) CreateSetupIntent(ctx context.Context, params *stripe.SetupIntentParams) (*stripe.SetupIntent, error) {
+	params.Context = ctx
+	return p.api.SetupIntents.New(params)
+}
+
+func (p *StripeBillingProvider) AttachPaymentMethod(ctx context.Context, paymentMethodID, customerID string) error {
+	params := &stripe.PaymentMethodAttachParams{Customer: stripe.String(customerID)}
+	params.Context = ctx
+	_, err := p.api.PaymentMethods.Attach(paymentMethodID, params)
+	return err
+}
+
+func (p *StripeBillingProvider) ListCardPaymentMethods(ctx context.Context, customerID string) ([]*stripe.PaymentMethod, error) {
+	params := &stripe.PaymentMethodListParams{
+		Customer: stripe.String(customerID),
+		Type:     stripe.String("card"),
+	}
+	params.Context = ctx
+
+	var methods []*stripe.PaymentMethod
+	iter := p.api.PaymentMethods.List(params)
+	for iter.Next() {
+		methods = append(methods, iter.PaymentMethod())
+	}
+	return methods, iter.Err()
+}
+
+func (p *StripeBillingProvider) DetachPaymentMethod(ctx context.Context, paymentMethodID string) error {
+	params := &stripe.PaymentMethodDetachParams{}
+	params.Context = ctx
+	_, err := p.api.PaymentMethods.Detach(paymentMethodID, params)
+	return err
+}
+
+func (p *StripeBillingProvider) CreateSubscription(ctx context.Context, params *stripe.SubscriptionParams) (*stripe.Subscription, error) {
+	params.Context = ctx
+	return p.api.Subscriptions.New(params)
+}
+
+func (p *StripeBillingProvider) GetSubscription(ctx context.Context, subscriptionID string) (*stripe.Subscription, error) {
+	params := &stripe.SubscriptionParams{}
+	params.Context = ctx
+	return p.api.Subscriptions.Get(subscriptionID, params)
+}
+
+func (p *StripeBillingProvider) UpdateSubscription(ctx context.Context, subscriptionID string, params *stripe.SubscriptionParams) (*stripe.Subscription, error) {
+	params.Context = ctx
+	return p.api.Subscriptions.Update(subscriptionID, params)
+}
+
+func (p *StripeBillingProvider) CancelSubscription(ctx context.Context, subscriptionID string) (*stripe.Subscription, error) {
+	params := &stripe.SubscriptionCancelParams{}
+	params.Context = ctx
+	return p.api.Subscriptions.Cancel(subscriptionID, params)
+}
+
+func (p *StripeBillingProvider) SchedulePriceChange(ctx context.Context, subscriptionID, priceID string) (*stripe.SubscriptionSchedule, error) {
+	createParams := &stripe.SubscriptionScheduleParams{FromSubscription: stripe.String(subscriptionID)}
+	createParams.Context = ctx
+	schedule, err := p.api.SubscriptionSchedules.New(createParams)
+	if err != nil {
+		return nil, err
+	}
+	if len(schedule.Phases) == 0 {
+		_ = p.ReleaseSubscriptionSchedule(ctx, schedule.ID)
+		return nil, fmt.Errorf("subscription schedule %s has no phases", schedule.ID)
+	}
+
+	// 現在のフェーズは期間終了までそのまま残し、次のフェーズで価格を切り替える
+	// 2つ目のフェーズが終わるとスケジュールは解除され、通常のサブスクリプションとして更新が続く
+	current := schedule.Phases[0]
+	currentItems := make([]*stripe.SubscriptionSchedulePhaseItemParams, 0, len(current.Items))
+	for _, item := range current.Items {
+		currentItems = append(currentItems, &stripe.SubscriptionSchedulePhaseItemParams{
+			Price:    stripe.String(item.Price.ID),
+			Quantity: stripe.Int64(item.Quantity),
+		})
+	}
+	var discounts []*stripe.SubscriptionSchedulePhaseDiscountParams
+	for _, d := range current.Discounts {
+		if d.Discount != nil {
+			discounts = append(discounts, &stripe.SubscriptionSchedulePhaseDiscountParams{Discount: stripe.String(d.Discount.ID)})
+		}
+	}
+
+	params := &stripe.SubscriptionScheduleParams{
+		EndBehavior: stripe.String(string(stripe.SubscriptionScheduleEndBehaviorRelease)),
+		Phases: []*stripe.SubscriptionSchedulePhaseParams{
+			{
+				Items:     currentItems,
+				Discounts: discounts,
+				StartDate: stripe.Int64(current.StartDate),
+				EndDate:   stripe.Int64(current.EndDate),
+			},
+			{
+				Items:             []*stripe.SubscriptionSchedulePhaseItemParams{{Price: stripe.String(priceID), Quantity: stripe.Int64(1)}},
+				Discounts:         discounts,
+				Iterations:        stripe.Int64(1),
+				ProrationBehavior: stripe.String("none"),
+			},
+		},
+	}
+	params.Context = ctx
+	updated, err := p.api.SubscriptionSchedules.Update(schedule.ID, params)
+	if err != nil {
+		// 中途半端なスケジュールが残らないよう解除する
+		_ = p.ReleaseSubscriptionSchedule(ctx, schedule.ID)
+		return nil, err
+	}
+	return updated, nil
+}
+
+func (p *StripeBillingProvider) ReleaseSubscriptionSchedule(ctx context.Context, scheduleID string) error {
+	params := &stripe.SubscriptionScheduleReleaseParams{}
+	params.Context = ctx
+	_, err := p.api.SubscriptionSchedules.Release(scheduleID, params)
+	return err
+}
+
+func (p *StripeBillingProvider) ListInvoices(ctx context.Context, customerID string) ([]*stripe.Invoice, error) {
+	params := &stripe.InvoiceListParams{Customer: stripe.String(customerID)}
+	params.Context = ctx
+	params.Limit = stripe.Int64(100)
+
+	var invoices []*stripe.Invoice
+	iter := p.api.Invoices.List(params)
+	for iter.Next() {
+		invoices = append(invoices, iter.Invoice())
+	}
+	return invoices, iter.Err()
+}
+
+func (p *StripeBillingProvider) UpcomingInvoice(ctx context.Context, params *stripe.InvoiceUpcomingParams) (*stripe.Invoice, error) {
+	params.Context = ctx
+	return p.api.Invoices.Upcoming(params)
+}
+
+func (p *StripeBillingProvider) CreateRefund(ctx context.Context, params *stripe.RefundParams) (*stripe.Refund, error) {
+	params.Context = ctx
+	return p.api.Refunds.New(params)
+}
+
+func (p *StripeBillingProvider) CreateCreditNote(ctx context.Context, params *stripe.CreditNoteParams) (*stripe.CreditNote, error) {
+	params.Context = ctx
+	return p.api.CreditNotes.New(params)
+}
+
+func (p *StripeBillingProvider) UpdateDispute(ctx context.Context, disputeID string, params *stripe.DisputeParams) (*stripe.Dispute, error) {
+	params.Context = ctx
+	return p.api.Disputes.Update(disputeID, params)
+}
+
+func (p *StripeBillingProvider) UploadFile(ctx context.Context, params *stripe.FileParams) (*stripe.File, error) {
+	params.Context = ctx
+	return p.api.Files.New(params)
+}
+
+func (p *StripeBillingProvider) ListActivePromotionCodes(ctx context.Context, code string) ([]*stripe.PromotionCode, error) {
+	params := &stripe.PromotionCodeListParams{
+		Code:   stripe.String(code),
+		Active: stripe.Bool(true),
+	}
+	params.Context = ctx
+
+	var codes []*stripe.PromotionCode
+	iter := p.api.PromotionCodes.List(params)
+	for iter.Next() {
+		codes = append(codes, iter.PromotionCode())
+	}
+	return codes, iter.Err()
+}
+
+func (p *StripeBillingProvider) ListRecurringPrices(ctx context.Context) ([]*stripe.Price, error) {
+	params := &stripe.PriceListParams{
+		Active: stripe.Bool(true),
+		Type:   stripe.String(string(stripe.PriceTypeRecurring)),
+	}
+	params.Context = ctx
+	params.AddExpand("data.product")
+	params.Limit = stripe.Int64(100)
+
+	var prices []*stripe.Price
+	iter := p.api.Prices.List(params)
+	for iter.Next() {
+		prices = append(prices, iter.Price())
+	}
+	return prices, iter.Err()
+}
@@ -0,0 +1,1798 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stripe/stripe-go/v81"
+	"github.com/stripe/stripe-go/v81/webhook"
+)
+
+// FakeBillingProvider はメモリ上で Stripe の動作を再現する BillingProvider
+// Stripe のテストクロックと同じように AdvanceClock で時刻を進めると、期限を迎えた契約期間の更新や
+// 解約予約の確定を行い、Stripe と同じ形式の Webhook イベントを OnEvent で登録した関数に送る
+// 対応していない操作・パラメータは Stripe と同じ形式のエラーを返す
+type FakeBillingProvider struct {
+	mu             sync.Mutex
+	now            time.Time
+	seq            int
+	customers      map[string]*stripe.Customer
+	paymentMethods map[string]*stripe.PaymentMethod
+	subscriptions  map[string]*stripe.Subscription
+	invoices       []*stripe.Invoice
+	prices         map[string]*stripe.Price
+	schedules      map[string]*stripe.SubscriptionSchedule
+	promotionCodes map[string]*stripe.PromotionCode
+	charges        map[string]*stripe.Charge
+	refunds        map[string]*stripe.Refund
+	creditNotes    map[string]*stripe.CreditNote
+	disputes       map[string]*stripe.Dispute
+	files          map[string]*stripe.File
+	// disputeEvidence は異議申し立てごとに登録された証拠（キーは Stripe の evidence のフィールド名）
+	disputeEvidence map[string]map[string]string
+	// idempotencyKeys は冪等キーごとに作成済みのオブジェクトIDを保持する
+	idempotencyKeys map[string]string
+	// declining は支払いを拒否する顧客（カード拒否の再現）
+	declining map[string]bool
+	// trialNotified は customer.subscription.trial_will_end を送ったサブスクリプション
+	trialNotified map[string]bool
+	events        []stripe.Event
+	pending       []stripe.Event
+	handlers      []func(stripe.Event)
+}
+
+// NewFakeBillingProvider は now をテストクロックの開始時刻として FakeBillingProvider を作成する
+func NewFakeBillingProvider(now time.Time) *FakeBillingProvider {
+	return &FakeBillingProvider{
+		now:             now,
+		customers:       make(map[string]*stripe.Customer),
+		trialNotified:   make(map[string]bool),
+		paymentMethods:  make(map[string]*stripe.PaymentMethod),
+		subscriptions:   make(map[string]*stripe.Subscription),
+		prices:          make(map[string]*stripe.Price),
+		schedules:       make(map[string]*stripe.SubscriptionSchedule),
+		promotionCodes:  make(map[string]*stripe.PromotionCode),
+		charges:         make(map[string]*stripe.Charge),
+		refunds:         make(map[string]*stripe.Refund),
+		creditNotes:     make(map[string]*stripe.CreditNote),
+		disputes:        make(map[string]*stripe.Dispute),
+		files:           make(map[string]*stripe.File),
+		disputeEvidence: make(map[string]map[string]string),
+		idempotencyKeys: make(map[string]string),
+		declining:       make(map[string]bool),
+	}
+}
+
+// --- テスト用の操作 ---
+
+// Now はテストクロックの現在時刻を返す
+func (f *FakeBillingProvider) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+// AddPrice は円建ての定期課金の価格を登録する
+func (f *FakeBillingProvider) AddPrice(priceID, nickname string, unitAmount int64, interval stripe.PriceRecurringInterval, intervalCount int64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.prices[priceID] = &stripe.Price{
+		ID:         priceID,
+		Object:     "price",
+		Active:     true,
+		Currency:   stripe.CurrencyJPY,
+		Nickname:   nickname,
+		Type:       stripe.PriceTypeRecurring,
+		UnitAmount: unitAmount,
+		Created:    f.now.Unix(),
+		Recurring: &stripe.PriceRecurring{
+			Interval:      interval,
+			IntervalCount: intervalCount,
+		},
+	}
+}
+
+// AddProduct は商品を登録し、指定した価格をその商品に紐付ける
+func (f *FakeBillingProvider) AddProduct(product stripe.Product, priceIDs ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if product.ID == "" {
+		product.ID = f.newID("prod")
+	}
+	product.Object = "product"
+	product.Created = f.now.Unix()
+	for _, priceID := range priceIDs {
+		if price, ok := f.prices[priceID]; ok {
+			p := product
+			price.Product = &p
+		}
+	}
+}
+
+// ArchivePrice は価格を無効にする（Stripe のダッシュボードでのアーカイブに相当）
+func (f *FakeBillingProvider) ArchivePrice(priceID string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if price, ok := f.prices[priceID]; ok {
+		price.Active = false
+	}
+}
+
+// AddPromotionCode は有効なクーポンに紐付くプロモーションコードを登録し、その ID を返す
+func (f *FakeBillingProvider) AddPromotionCode(code string, coupon stripe.Coupon) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if coupon.ID == "" {
+		coupon.ID = f.newID("coupon")
+	}
+	coupon.Object = "coupon"
+	coupon.Valid = true
+	pc := &stripe.PromotionCode{
+		ID:      f.newID("promo"),
+		Object:  "promotion_code",
+		Active:  true,
+		Code:    code,
+		Coupon:  &coupon,
+		Created: f.now.Unix(),
+	}
+	f.promotionCodes[pc.ID] = pc
+	return pc.ID
+}
+
+// CreateCardPaymentMethod は Stripe.js でカードを入力した直後と同じ、顧客に未紐付けの支払い方法を作成する
+func (f *FakeBillingProvider) CreateCardPaymentMethod(brand stripe.PaymentMethodCardBrand, last4 string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	pm := &stripe.PaymentMethod{
+		ID:      f.newID("pm"),
+		Object:  "payment_method",
+		Type:    stripe.PaymentMethodTypeCard,
+		Created: f.now.Unix(),
+		Card: &stripe.PaymentMethodCard{
+			Brand:    brand,
+			Last4:    last4,
+			ExpMonth: 12,
+			ExpYear:  int64(f.now.Year() + 3),
+		},
+	}
+	f.paymentMethods[pm.ID] = pm
+	return pm.ID
+}
+
+// DeclinePayments は以降のその顧客への請求をカード拒否として失敗させる（false で元に戻す）
+func (f *FakeBillingProvider) DeclinePayments(customerID string, decline bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.declining[customerID] = decline
+}
+
+// OpenDispute は支払い（PaymentIntent）に対して利用者がカード会社に異議を申し立てた状態を作り、その ID を返す
+// 証拠の提出期限は7日後とし、charge.dispute.created と charge.dispute.funds_withdrawn を送る
+func (f *FakeBillingProvider) OpenDispute(paymentIntentID string, reason stripe.DisputeReason) (string, error) {
+	var id string
+	var err error
+	f.mutate(func() {
+		charge := f.chargeOf(paymentIntentID, "")
+		if charge == nil {
+			err = fakeMissing("payment_intent", paymentIntentID)
+			return
+		}
+		dispute := &stripe.Dispute{
+			ID:                 f.newID("dp"),
+			Object:             "dispute",
+			Amount:             charge.Amount,
+			Currency:           charge.Currency,
+			Charge:             &stripe.Charge{ID: charge.ID},
+			PaymentIntent:      &stripe.PaymentIntent{ID: charge.PaymentIntent.ID},
+			Reason:             reason,
+			Status:             stripe.DisputeStatusNeedsResponse,
+			IsChargeRefundable: charge.AmountRefunded < charge.Amount,
+			EvidenceDetails:    &stripe.DisputeEvidenceDetails{DueBy: f.now.Add(7 * 24 * time.Hour).Unix()},
+			Metadata:           map[string]string{},
+			Created:            f.now.Unix(),
+		}
+		f.disputes[dispute.ID] = dispute
+		charge.Disputed = true
+		id = dispute.ID
+
+		f.emit("charge.dispute.created", dispute)
+		f.emit("charge.dispute.funds_withdrawn", dispute)
+	})
+	return id, err
+}
+
+// CloseDispute は異議申し立ての結果を確定し、charge.dispute.closed を送る
+// won の場合は引き落とされた資金が戻り、charge.dispute.funds_reinstated も送る
+func (f *FakeBillingProvider) CloseDispute(disputeID string, won bool) error {
+	var err error
+	f.mutate(func() {
+		dispute, ok := f.disputes[disputeID]
+		if !ok {
+			err = fakeMissing("dispute", disputeID)
+			return
+		}
+		if fakeDisputeClosed(dispute.Status) {
+			err = fakeInvalidRequest(fmt.Sprintf("This dispute is already closed: %s", disputeID))
+			return
+		}
+		dispute.Status = stripe.DisputeStatusLost
+		if won {
+			dispute.Status = stripe.DisputeStatusWon
+		}
+		dispute.IsChargeRefundable = false
+
+		f.emit("charge.dispute.closed", dispute)
+		if won {
+			f.emit("charge.dispute.funds_reinstated", dispute)
+		}
+	})
+	return err
+}
+
+// DisputeEvidence は異議申し立てに登録された証拠を返す（キーは Stripe の evidence のフィールド名）
+func (f *FakeBillingProvider) DisputeEvidence(disputeID string) map[string]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	evidence := make(map[string]string, len(f.disputeEvidence[disputeID]))
+	for k, v := range f.disputeEvidence[disputeID] {
+		evidence[k] = v
+	}
+	return evidence
+}
+
+// OnEvent は Webhook として送るイベントの受け取り先を登録する
+// イベントは操作を行ったゴルーチンで、発生順に同期的に渡される
+func (f *FakeBillingProvider) OnEvent(handler func(stripe.Event)) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.handlers = append(f.handlers, handler)
+}
+
+// Events はこれまでに発生したイベントを発生順に返す
+func (f *FakeBillingProvider) Events() []stripe.Event {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]stripe.Event(nil), f.events...)
+}
+
+// SignedWebhook はイベントを Stripe と同じ形式で署名した Webhook のリクエストボディと
+// Stripe-Signature ヘッダーの値を返す
+func (f *FakeBillingProvider) SignedWebhook(event stripe.Event, secret string) ([]byte, string, error) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return nil, "", err
+	}
+	signed := webhook.GenerateTestSignedPayload(&webhook.UnsignedPayload{
+		Payload:   payload,
+		Secret:    secret,
+		Timestamp: time.Now(),
+	})
+	return signed.Payload, signed.Header, nil
+}
+
+// AdvanceClock はテストクロックを d だけ進める
+func (f *FakeBillingProvider) AdvanceClock(d time.Duration) {
+	f.AdvanceClockTo(f.Now().Add(d))
+}
+
+// AdvanceClockTo はテストクロックを t まで進め、その間に期限を迎えた処理を時刻順に1件ずつ行う
+//   - 契約期間の終了: 解約予約中のサブスクリプションは解約し、それ以外は次の期間の請求を行う
+//   - トライアル終了の3日前: customer.subscription.trial_will_end を送る
+//   - 支払いの一時停止（pause_collection）の再開日時: 一時停止を解除する
+//
+// 現在時刻より前の時刻を指定した場合は何もしない（Stripe のテストクロックは戻せない）
+func (f *FakeBillingProvider) AdvanceClockTo(t time.Time) {
+	f.mutate(func() {
+		if t.Before(f.now) {
+			return
+		}
+		for {
+			at, run := f.nextDeadline(t)
+			if run == nil {
+				break
+			}
+			f.now = time.Unix(at, 0)
+			run()
+		}
+		f.now = t
+	})
+}
+
+// --- 顧客 ---
+
+func (f *FakeBillingProvider) ListCustomersByEmail(_ context.Context, email string) ([]*stripe.Customer, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var customers []*stripe.Customer
+	for _, cust := range f.customers {
+		if cust.Email == email {
+			customers = append(customers, fakeClone(cust))
+		}
+	}
+	sortNewestFirst(customers, func(c *stripe.Customer) string { return c.ID })
+	return customers, nil
+}
+
+func (f *FakeBillingProvider) CreateCustomer(_ context.Context, params *stripe.CustomerParams) (*stripe.Customer, error) {
+	var result *stripe.Customer
+	f.mutate(func() {
+		if id, ok := f.replay(params.IdempotencyKey); ok {
+			result = fakeClone(f.customers[id])
+			return
+		}
+		cust := &stripe.Customer{
+			ID:              f.newID("cus"),
+			Object:          "customer",
+			Email:           stripe.StringValue(params.Email),
+			Name:            stripe.StringValue(params.Name),
+			Metadata:        map[string]string{},
+			Created:         f.now.Unix(),
+			InvoiceSettings: &stripe.CustomerInvoiceSettings{},
+		}
+		for k, v := range params.Metadata {
+			cust.Metadata[k] = v
+		}
+		f.customers[cust.ID] = cust
+		f.remember(params.IdempotencyKey, cust.ID)
+		f.emit("customer.created", cust)
+		result = fakeClone(cust)
+	})
+	return result, nil
+}
+
+func (f *FakeBillingProvider) UpdateCustomer(_ context.Context, customerID string, params *stripe.CustomerParams) (*stripe.Customer, error) {
+	var result *stripe.Customer
+	var err error
+	f.mutate(func() {
+		cust, ok := f.customers[customerID]
+		if !ok {
+			err = fakeMissing("customer", customerID)
+			return
+		}
+		if params.InvoiceSettings != nil && params.InvoiceSettings.DefaultPaymentMethod != nil {
+			pmID := *params.InvoiceSettings.DefaultPaymentMethod
+			pm, ok := f.paymentMethods[pmID]
+			if !ok || pm.Customer == nil || pm.Customer.ID != customerID {
+				err = fakeInvalidRequest(fmt.Sprintf("The customer does not have a payment method with the ID %s.", pmID))
+				return
+			}
+			cust.InvoiceSettings.DefaultPaymentMethod = &stripe.PaymentMethod{ID: pmID}
+		}
+		if params.Name != nil {
+			cust.Name = *params.Name
+		}
+		if params.Email != nil {
+			cust.Email = *params.Email
+		}
+		for k, v := range params.Metadata {
+			cust.Metadata[k] = v
+		}
+		f.emit("customer.updated", cust)
+		result = fakeClone(cust)
+	})
+	return result, err
+}
+
+// DeleteCustomer は顧客を削除する（Stripe と同様に契約中のサブスクリプションは即時解約される）
+func (f *FakeBillingProvider) DeleteCustomer(_ context.Context, customerID string) error {
+	var err error
+	f.mutate(func() {
+		cust, ok := f.customers[customerID]
+		if !ok {
+			err = fakeMissing("customer", customerID)
+			return
+		}
+		for _, sub := range f.subscriptions {
+			if sub.Customer.ID == customerID && sub.Status != stripe.SubscriptionStatusCanceled {
+				f.cancel(sub)
+			}
+		}
+		for _, pm := range f.paymentMethods {
+			if pm.Customer != nil && pm.Customer.ID == customerID {
+				pm.Customer = nil
+			}
+		}
+		delete(f.customers, customerID)
+		f.emit("customer.deleted", &stripe.Customer{ID: cust.ID, Object: "customer", Deleted: true})
+	})
+	return err
+}
+
+// --- 支払い方法 ---
+
+func (f *FakeBillingProvider) CreateSetupIntent(_ context.Context, params *stripe.SetupIntentParams) (*stripe.SetupIntent, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	customerID := stripe.StringValue(params.Customer)
+	if _, ok := f.customers[customerID]; !ok {
+		return nil, fakeMissing("customer", customerID)
+	}
+	id := f.newID("seti")
+	return &stripe.SetupIntent{
+		ID:                 id,
+		Object:             "setup_intent",
+		ClientSecret:       id + "_secret_fake",
+		Customer:           &stripe.Customer{ID: customerID},
+		Status:             stripe.SetupIntentStatusRequiresPaymentMethod,
+		Usage:              stripe.SetupIntentUsage(stripe.StringValue(params.Usage)),
+		PaymentMethodTypes: fakeStringValues(params.PaymentMethodTypes),
+		Created:            f.now.Unix(),
+	}, nil
+}
+
+func (f *FakeBillingProvider) AttachPaymentMethod(_ context.Context, paymentMethodID, customerID string) error {
+	var err error
+	f.mutate(func() {
+		pm, ok := f.paymentMethods[paymentMethodID]
+		if !ok {
+			err = fakeMissing("PaymentMethod", paymentMethodID)
+			return
+		}
+		if _, ok := f.customers[customerID]; !ok {
+			err = fakeMissing("customer", customerID)
+			return
+		}
+		if pm.Customer != nil {
+			if pm.Customer.ID == customerID {
+				return
+			}
+			err = fakeInvalidRequest("The payment method you provided has already been attached to a customer.")
+			return
+		}
+		pm.Customer = &stripe.Customer{ID: customerID}
+		f.emit("payment_method.attached", pm)
+	})
+	return err
+}
+
+func (f *FakeBillingProvider) ListCardPaymentMethods(_ context.Context, customerID string) ([]*stripe.PaymentMethod, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	methods := f.cardsOf(customerID)
+	for i, pm := range methods {
+		methods[i] = fakeClone(pm)
+	}
+	return methods, nil
+}
+
+func (f *FakeBillingProvider) DetachPaymentMethod(_ context.Context, paymentMethodID string) error {
+	var err error
+	f.mutate(func() {
+		pm, ok := f.paymentMethods[paymentMethodID]
+		if !ok {
+			err = fakeMissing("PaymentMethod", paymentMethodID)
+			return
+		}
+		if pm.Customer == nil {
+			err = fakeInvalidRequest("The payment method you provided is not attached to a customer so detachment is impossible.")
+			return
+		}
+		if cust, ok := f.customers[pm.Customer.ID]; ok {
+			if dpm := cust.InvoiceSettings.DefaultPaymentMethod; dpm != nil && dpm.ID == paymentMethodID {
+				cust.InvoiceSettings.DefaultPaymentMethod = nil
+			}
+		}
+		pm.Customer = nil
+		f.emit("payment_method.detached", pm)
+	})
+	return err
+}
+
+// --- サブスクリプション ---
+
+// CreateSubscription は1つの価格で契約を開始し、初回の請求を行う
+// PaymentBehavior が error_if_incomplete の場合、支払いに失敗すると契約せずにカードエラーを返す
+func (f *FakeBillingProvider) CreateSubscription(_ context.Context, params *stripe.SubscriptionParams) (*stripe.Subscription, error) {
+	var result *stripe.Subscription
+	var err error
+	f.mutate(func() {
+		if id, ok := f.replay(params.IdempotencyKey); ok {
+			result = fakeClone(f.subscriptions[id])
+			return
+		}
+		customerID := stripe.StringValue(params.Customer)
+		if _, ok := f.customers[customerID]; !ok {
+			err = fakeMissing("customer", customerID)
+			return
+		}
+		if len(params.Items) != 1 || params.Items[0].Price == nil {
+			err = fakeInvalidRequest("The fake billing provider supports exactly one price per subscription.")
+			return
+		}
+		price, ok := f.prices[*params.Items[0].Price]
+		if !ok {
+			err = fakeMissing("price", *params.Items[0].Price)
+			return
+		}
+		if !price.Active {
+			err = fakeInvalidRequest("The price specified is inactive. This field only accepts active prices.")
+			return
+		}
+		trialDays := stripe.Int64Value(params.TrialPeriodDays)
+		if trialDays < 0 || trialDays > 730 {
+			err = fakeInvalidRequest("trial_period_days must be between 0 and 730.")
+			return
+		}
+		// トライアル中は請求しないため、カード未登録でも作成できる
+		if trialDays == 0 && f.defaultPaymentMethod(customerID) == "" {
+			err = fakeInvalidRequest("This customer has no attached payment source or default payment method.")
+			return
+		}
+		if trialDays == 0 && f.declining[customerID] && stripe.StringValue(params.PaymentBehavior) == "error_if_incomplete" {
+			err = fakeCardDeclined()
+			return
+		}
+
+		sub := &stripe.Subscription{
+			ID:        f.newID("sub"),
+			Object:    "subscription",
+			Customer:  &stripe.Customer{ID: customerID},
+			Currency:  price.Currency,
+			Created:   f.now.Unix(),
+			StartDate: f.now.Unix(),
+			Metadata:  map[string]string{},
+			Items: &stripe.SubscriptionItemList{Data: []*stripe.SubscriptionItem{
+				{ID: f.newID("si"), Object: "subscription_item", Price: price, Quantity: 1, Created: f.now.Unix()},
+			}},
+		}
+		for k, v := range params.Metadata {
+			sub.Metadata[k] = v
+		}
+		if params.PromotionCode != nil {
+			if err = f.applyPromotionCode(sub, *params.PromotionCode); err != nil {
+				return
+			}
+		}
+		sub.CurrentPeriodStart = f.now.Unix()
+		sub.CurrentPeriodEnd = fakeAddInterval(f.now, price.Recurring).Unix()
+
+		var inv *stripe.Invoice
+		if trialDays > 0 {
+			// トライアル期間を最初の契約期間とし、0円の請求書を発行する
+			sub.TrialStart = f.now.Unix()
+			sub.TrialEnd = f.now.AddDate(0, 0, int(trialDays)).Unix()
+			sub.CurrentPeriodEnd = sub.TrialEnd
+			sub.TrialSettings = &stripe.SubscriptionTrialSettings{EndBehavior: &stripe.SubscriptionTrialSettingsEndBehavior{
+				MissingPaymentMethod: stripe.SubscriptionTrialSettingsEndBehaviorMissingPaymentMethodCreateInvoice,
+			}}
+			if params.TrialSettings != nil && params.TrialSettings.EndBehavior != nil && params.TrialSettings.EndBehavior.MissingPaymentMethod != nil {
+				sub.TrialSettings.EndBehavior.MissingPaymentMethod = stripe.SubscriptionTrialSettingsEndBehaviorMissingPaymentMethod(*params.TrialSettings.EndBehavior.MissingPaymentMethod)
+			}
+			start, end := time.Unix(sub.CurrentPeriodStart, 0), time.Unix(sub.CurrentPeriodEnd, 0)
+			lines := fakeInvoiceLines(sub, price, 0, start, end).Data
+			lines[0].Description = "Trial period for " + fakePriceName(price)
+			inv = f.issueInvoice(sub, stripe.InvoiceBillingReasonSubscriptionCreate, lines, start, end)
+			sub.Status = stripe.SubscriptionStatusTrialing
+		} else {
+			inv = f.newInvoice(sub, stripe.InvoiceBillingReasonSubscriptionCreate)
+			sub.Status = stripe.SubscriptionStatusActive
+			if !inv.Paid {
+				sub.Status = stripe.SubscriptionStatusIncomplete
+			}
+		}
+		sub.LatestInvoice = inv
+		f.subscriptions[sub.ID] = sub
+		f.remember(params.IdempotencyKey, sub.ID)
+
+		f.emit("customer.subscription.created", sub)
+		f.emitInvoice(inv)
+		result = fakeClone(sub)
+	})
+	return result, err
+}
+
+func (f *FakeBillingProvider) GetSubscription(_ context.Context, subscriptionID string) (*stripe.Subscription, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	sub, ok := f.subscriptions[subscriptionID]
+	if !ok {
+		return nil, fakeMissing("subscription", subscriptionID)
+	}
+	return fakeClone(sub), nil
+}
+
+// UpdateSubscription は解約予約・価格・プロモーションコード・支払いの一時停止・メタデータの変更に対応する
+// 価格の変更は ProrationBehavior が always_invoice の場合のみ日割り精算してすぐに請求し、
+// それ以外は日割り精算せずに次回の請求から反映する
+// 一時停止の解除は Stripe と同様に pause_collection に空文字列を指定する（params.AddExtra("pause_collection", "")）
+func (f *FakeBillingProvider) UpdateSubscription(_ context.Context, subscriptionID string, params *stripe.SubscriptionParams) (*stripe.Subscription, error) {
+	var result *stripe.Subscription
+	var err error
+	f.mutate(func() {
+		sub, ok := f.subscriptions[subscriptionID]
+		if !ok {
+			err = fakeMissing("subscription", subscriptionID)
+			return
+		}
+		if sub.Status == stripe.SubscriptionStatusCanceled {
+			err = fakeInvalidRequest("A canceled subscription can only update its cancellation_details and metadata.")
+			return
+		}
+
+		if params.CancelAtPeriodEnd != nil && sub.Schedule != nil {
+			err = fakeInvalidRequest(fmt.Sprintf("The subscription is managed by the subscription schedule `%s`, and updating any cancelation behavior directly is not allowed. Please update the schedule instead.", sub.Schedule.ID))
+			return
+		}
+
+		// 検証がすべて終わってから変更を反映する（Stripe と同様に一部だけ反映されることはない）
+		updated := fakeClone(sub)
+		var prorationLines []*stripe.InvoiceLineItem
+		if len(params.Items) > 0 {
+			item := params.Items[0]
+			if item.ID != nil && *item.ID != updated.Items.Data[0].ID {
+				err = fakeMissing("subscription item", *item.ID)
+				return
+			}
+			if item.Price != nil {
+				price, ok := f.prices[*item.Price]
+				if !ok {
+					err = fakeMissing("price", *item.Price)
+					return
+				}
+				if !price.Active {
+					err = fakeInvalidRequest("The price specified is inactive. This field only accepts active prices.")
+					return
+				}
+				if stripe.StringValue(params.ProrationBehavior) == "always_invoice" && price.ID != updated.Items.Data[0].Price.ID {
+					at, prorationErr := f.prorationDate(sub, params.ProrationDate)
+					if prorationErr != nil {
+						err = prorationErr
+						return
+					}
+					var start, end time.Time
+					prorationLines, start, end = f.prorate(sub, price, at)
+					updated.CurrentPeriodStart, updated.CurrentPeriodEnd = start.Unix(), end.Unix()
+					if f.declining[sub.Customer.ID] && stripe.StringValue(params.PaymentBehavior) == "error_if_incomplete" && fakeLinesTotal(prorationLines) > 0 {
+						err = fakeCardDeclined()
+						return
+					}
+				}
+				updated.Items.Data[0].Price = price
+			}
+		}
+		if params.PromotionCode != nil {
+			if err = f.applyPromotionCode(updated, *params.PromotionCode); err != nil {
+				return
+			}
+		}
+		if params.PauseCollection != nil {
+			pause, pauseErr := f.pauseCollection(params.PauseCollection)
+			if pauseErr != nil {
+				err = pauseErr
+				return
+			}
+			updated.PauseCollection = pause
+		} else if params.Extra != nil && params.Extra.Values.Has("pause_collection") {
+			if params.Extra.Values.Get("pause_collection") != "" {
+				err = fakeInvalidRequest("Invalid pause_collection: must be an object or an empty string.")
+				return
+			}
+			updated.PauseCollection = nil
+		}
+		if params.CancelAtPeriodEnd != nil {
+			updated.CancelAtPeriodEnd = *params.CancelAtPeriodEnd
+			updated.CanceledAt = 0
+			if updated.CancelAtPeriodEnd {
+				updated.CanceledAt = f.now.Unix()
+			}
+		}
+		for k, v := range params.Metadata {
+			updated.Metadata[k] = v
+		}
+
+		updated.LatestInvoice = sub.LatestInvoice
+		var inv *stripe.Invoice
+		if prorationLines != nil {
+			inv = f.issueInvoice(updated, stripe.InvoiceBillingReasonSubscriptionUpdate, prorationLines,
+				time.Unix(updated.CurrentPeriodStart, 0), time.Unix(updated.CurrentPeriodEnd, 0))
+			if inv.Status == stripe.InvoiceStatusOpen {
+				updated.Status = stripe.SubscriptionStatusPastDue
+			}
+			updated.LatestInvoice = inv
+		}
+		f.subscriptions[subscriptionID] = updated
+		f.emit("customer.subscription.updated", updated)
+		if inv != nil {
+			f.emitInvoice(inv)
+		}
+		result = fakeClone(updated)
+	})
+	return result, err
+}
+
+func (f *FakeBillingProvider) CancelSubscription(_ context.Context, subscriptionID string) (*stripe.Subscription, error) {
+	var result *stripe.Subscription
+	var err error
+	f.mutate(func() {
+		sub, ok := f.subscriptions[subscriptionID]
+		if !ok {
+			err = fakeMissing("subscription", subscriptionID)
+			return
+		}
+		if sub.Status == stripe.SubscriptionStatusCanceled {
+			err = fakeInvalidRequest("This subscription has already been canceled.")
+			return
+		}
+		f.cancel(sub)
+		result = fakeClone(sub)
+	})
+	return result, err
+}
+
+// SchedulePriceChange は現在の契約期間の終了時に価格を切り替える予約を作成する
+// 実際の Stripe では切り替え後のフェーズが終わるまでスケジュールが残るが、ここでは切り替えた時点で解除する
+func (f *FakeBillingProvider) SchedulePriceChange(_ context.Context, subscriptionID, priceID string) (*stripe.SubscriptionSchedule, error) {
+	var result *stripe.SubscriptionSchedule
+	var err error
+	f.mutate(func() {
+		sub, ok := f.subscriptions[subscriptionID]
+		if !ok {
+			err = fakeMissing("subscription", subscriptionID)
+			return
+		}
+		if sub.Status == stripe.SubscriptionStatusCanceled {
+			err = fakeInvalidRequest("You cannot migrate a subscription that is canceled.")
+			return
+		}
+		if sub.Schedule != nil {
+			err = fakeInvalidRequest(fmt.Sprintf("You cannot migrate a subscription that is already attached to a schedule: `%s`.", sub.Schedule.ID))
+			return
+		}
+		price, ok := f.prices[priceID]
+		if !ok {
+			err = fakeMissing("price", priceID)
+			return
+		}
+		if !price.Active {
+			err = fakeInvalidRequest("The price specified is inactive. This field only accepts active prices.")
+			return
+		}
+
+		current := sub.Items.Data[0].Price
+		periodEnd := time.Unix(sub.CurrentPeriodEnd, 0)
+		schedule := &stripe.SubscriptionSchedule{
+			ID:           f.newID("sub_sched"),
+			Object:       "subscription_schedule",
+			Created:      f.now.Unix(),
+			Customer:     &stripe.Customer{ID: sub.Customer.ID},
+			Subscription: &stripe.Subscription{ID: sub.ID},
+			Status:       stripe.SubscriptionScheduleStatusActive,
+			EndBehavior:  stripe.SubscriptionScheduleEndBehaviorRelease,
+			Phases: []*stripe.SubscriptionSchedulePhase{
+				{
+					StartDate: sub.CurrentPeriodStart,
+					EndDate:   sub.CurrentPeriodEnd,
+					Items:     []*stripe.SubscriptionSchedulePhaseItem{{Price: current, Quantity: 1}},
+				},
+				{
+					StartDate: periodEnd.Unix(),
+					EndDate:   fakeAddInterval(periodEnd, price.Recurring).Unix(),
+					Items:     []*stripe.SubscriptionSchedulePhaseItem{{Price: price, Quantity: 1}},
+				},
+			},
+		}
+		f.schedules[schedule.ID] = schedule
+		sub.Schedule = &stripe.SubscriptionSchedule{ID: schedule.ID}
+
+		f.emit("subscription_schedule.created", schedule)
+		f.emit("customer.subscription.updated", sub)
+		result = fakeClone(schedule)
+	})
+	return result, err
+}
+
+func (f *FakeBillingProvider) ReleaseSubscriptionSchedule(_ context.Context, scheduleID string) error {
+	var err error
+	f.mutate(func() {
+		schedule, ok := f.schedules[scheduleID]
+		if !ok {
+			err = fakeMissing("subscription_schedule", scheduleID)
+			return
+		}
+		if schedule.Status != stripe.SubscriptionScheduleStatusActive {
+			err = fakeInvalidRequest(fmt.Sprintf("You cannot release a subscription schedule that is currently in the `%s` status.", schedule.Status))
+			return
+		}
+		f.release(schedule)
+	})
+	return err
+}
+
+// --- 請求書 ---
+
+func (f *FakeBillingProvider) ListInvoices(_ context.Context, customerID string) ([]*stripe.Invoice, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var invoices []*stripe.Invoice
+	for i := len(f.invoices) - 1; i >= 0; i-- {
+		if f.invoices[i].Customer.ID == customerID {
+			invoices = append(invoices, fakeClone(f.invoices[i]))
+		}
+	}
+	return invoices, nil
+}
+
+// UpcomingInvoice は指定したサブスクリプションの次回の請求額を返す
+func (f *FakeBillingProvider) UpcomingInvoice(_ context.Context, params *stripe.InvoiceUpcomingParams) (*stripe.Invoice, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	subscriptionID := stripe.StringValue(params.Subscription)
+	sub, ok := f.subscriptions[subscriptionID]
+	if !ok || (params.Customer != nil && sub.Customer.ID != *params.Customer) {
+		return nil, fakeMissing("subscription", subscriptionID)
+	}
+	if sub.Status == stripe.SubscriptionStatusCanceled || sub.CancelAtPeriodEnd {
+		return nil, &stripe.Error{
+			Type:           stripe.ErrorTypeInvalidRequest,
+			Code:           stripe.ErrorCodeInvoiceUpcomingNone,
+			HTTPStatusCode: 404,
+			Msg:            "No upcoming invoices for customer: " + sub.Customer.ID,
+		}
+	}
+
+	price := sub.Items.Data[0].Price
+	if len(params.SubscriptionItems) > 0 && params.SubscriptionItems[0].Price != nil {
+		newPrice, ok := f.prices[*params.SubscriptionItems[0].Price]
+		if !ok {
+			return nil, fakeMissing("price", *params.SubscriptionItems[0].Price)
+		}
+		// always_invoice の場合は変更時にすぐ発行される日割り精算の請求書を返す
+		if stripe.StringValue(params.SubscriptionProrationBehavior) == "always_invoice" && newPrice.ID != price.ID {
+			at, err := f.prorationDate(sub, params.SubscriptionProrationDate)
+			if err != nil {
+				return nil, err
+			}
+			lines, start, end := f.prorate(sub, newPrice, at)
+			amount := fakeLinesTotal(lines)
+			return &stripe.Invoice{
+				Object:          "invoice",
+				Customer:        &stripe.Customer{ID: sub.Customer.ID},
+				Subscription:    &stripe.Subscription{ID: sub.ID},
+				Status:          stripe.InvoiceStatusDraft,
+				Currency:        newPrice.Currency,
+				AmountDue:       amount,
+				AmountRemaining: amount,
+				Created:         at.Unix(),
+				PeriodStart:     start.Unix(),
+				PeriodEnd:       end.Unix(),
+				BillingReason:   stripe.InvoiceBillingReasonSubscriptionUpdate,
+				Lines:           &stripe.InvoiceLineItemList{Data: lines},
+			}, nil
+		}
+		price = newPrice
+	}
+	start := time.Unix(sub.CurrentPeriodEnd, 0)
+	end := fakeAddInterval(start, price.Recurring)
+	amount := fakeDiscountedAmount(price.UnitAmount, sub.Discount)
+	return &stripe.Invoice{
+		Object:             "invoice",
+		Customer:           &stripe.Customer{ID: sub.Customer.ID},
+		Subscription:       &stripe.Subscription{ID: sub.ID},
+		Status:             stripe.InvoiceStatusDraft,
+		Currency:           price.Currency,
+		AmountDue:          amount,
+		AmountRemaining:    amount,
+		Created:            start.Unix(),
+		PeriodStart:        start.Unix(),
+		PeriodEnd:          end.Unix(),
+		NextPaymentAttempt: start.Unix(),
+		BillingReason:      stripe.InvoiceBillingReasonSubscriptionCycle,
+		Lines:              fakeInvoiceLines(sub, price, amount, start, end),
+	}, nil
+}
+
+// --- 返金・クレジットノート ---
+
+// CreateRefund は PaymentIntent または Charge の支払いを返金する（金額を省略すると未返金の全額）
+// 返金は即時に成功し、charge.refunded と refund.created を送る
+func (f *FakeBillingProvider) CreateRefund(_ context.Context, params *stripe.RefundParams) (*stripe.Refund, error) {
+	var result *stripe.Refund
+	var err error
+	f.mutate(func() {
+		if id, ok := f.replay(params.IdempotencyKey); ok {
+			result = fakeClone(f.refunds[id])
+			return
+		}
+		charge := f.chargeOf(stripe.StringValue(params.PaymentIntent), stripe.StringValue(params.Charge))
+		if charge == nil {
+			err = fakeMissing("charge", stripe.StringValue(params.PaymentIntent)+stripe.StringValue(params.Charge))
+			return
+		}
+		reason := stripe.StringValue(params.Reason)
+		switch stripe.RefundReason(reason) {
+		case "", stripe.RefundReasonDuplicate, stripe.RefundReasonFraudulent, stripe.RefundReasonRequestedByCustomer:
+		default:
+			err = fakeInvalidRequest("Invalid reason: must be one of duplicate, fraudulent, or requested_by_customer")
+			return
+		}
+		var refund *stripe.Refund
+		if refund, err = f.refund(charge, params.Amount, reason, params.Metadata); err != nil {
+			return
+		}
+		f.remember(params.IdempotencyKey, refund.ID)
+		result = fakeClone(refund)
+	})
+	return result, err
+}
+
+// CreateCreditNote は金額（amount）を指定したクレジットノートを発行する（明細ごとの指定には対応しない）
+// 未払いの請求書は請求額を減額し、支払い済みの請求書は refund_amount・credit_amount・out_of_band_amount の合計が
+// 金額と一致する必要がある。refund_amount は請求書の支払いを返金し、credit_amount は顧客の残高に充当する
+func (f *FakeBillingProvider) CreateCreditNote(_ context.Context, params *stripe.CreditNoteParams) (*stripe.CreditNote, error) {
+	var result *stripe.CreditNote
+	var err error
+	f.mutate(func() {
+		if id, ok := f.replay(params.IdempotencyKey); ok {
+			result = fakeClone(f.creditNotes[id])
+			return
+		}
+		inv := f.invoiceOf(stripe.StringValue(params.Invoice))
+		if inv == nil {
+			err = fakeMissing("invoice", stripe.StringValue(params.Invoice))
+			return
+		}
+		if params.Amount == nil || len(params.Lines) > 0 {
+			err = fakeInvalidRequest("The fake billing provider supports credit notes by amount only.")
+			return
+		}
+		amount := *params.Amount
+		refundAmount := stripe.Int64Value(params.RefundAmount)
+		creditAmount := stripe.Int64Value(params.CreditAmount)
+		outOfBandAmount := stripe.Int64Value(params.OutOfBandAmount)
+		if amount <= 0 || refundAmount < 0 || creditAmount < 0 || outOfBandAmount < 0 {
+			err = fakeInvalidRequest("The credit note amount must be greater than 0.")
+			return
+		}
+
+		cn := &stripe.CreditNote{
+			ID:              f.newID("cn"),
+			Object:          "credit_note",
+			Amount:          amount,
+			Subtotal:        amount,
+			Total:           amount,
+			Currency:        inv.Currency,
+			Customer:        &stripe.Customer{ID: inv.Customer.ID},
+			Invoice:         &stripe.Invoice{ID: inv.ID},
+			Memo:            stripe.StringValue(params.Memo),
+			Metadata:        map[string]string{},
+			Reason:          stripe.CreditNoteReason(stripe.StringValue(params.Reason)),
+			Status:          stripe.CreditNoteStatusIssued,
+			OutOfBandAmount: outOfBandAmount,
+			Created:         f.now.Unix(),
+			EffectiveAt:     f.now.Unix(),
+		}
+		for k, v := range params.Metadata {
+			cn.Metadata[k] = v
+		}
+		issued := 1
+		for _, existing := range f.creditNotes {
+			if existing.Invoice.ID == inv.ID {
+				issued++
+			}
+		}
+		cn.Number = fmt.Sprintf("%s-CN-%02d", inv.Number, issued)
+		cn.PDF = "https://invoice.stripe.test/" + cn.ID + "/pdf"
+
+		switch inv.Status {
+		case stripe.InvoiceStatusOpen:
+			if refundAmount+creditAmount+outOfBandAmount > 0 {
+				err = fakeInvalidRequest("refund_amount, credit_amount and out_of_band_amount can only be specified for paid invoices.")
+				return
+			}
+			if amount > inv.AmountRemaining {
+				err = fakeInvalidRequest(fmt.Sprintf("The credit note amount (%d) exceeds the amount remaining on the invoice (%d).", amount, inv.AmountRemaining))
+				return
+			}
+			cn.Type = stripe.CreditNoteTypePrePayment
+			inv.PrePaymentCreditNotesAmount += amount
+			inv.AmountDue -= amount
+			inv.AmountRemaining -= amount
+			if inv.AmountRemaining == 0 {
+				inv.Status = stripe.InvoiceStatusPaid
+				inv.Paid = true
+				inv.StatusTransitions = &stripe.InvoiceStatusTransitions{PaidAt: f.now.Unix()}
+			}
+		case stripe.InvoiceStatusPaid:
+			if refundAmount+creditAmount+outOfBandAmount != amount {
+				err = fakeInvalidRequest("The sum of refund_amount, credit_amount and out_of_band_amount must equal the credit note amount.")
+				return
+			}
+			if amount > inv.AmountPaid-inv.PostPaymentCreditNotesAmount {
+				err = fakeInvalidRequest(fmt.Sprintf("The credit note amount (%d) exceeds the amount paid that has not been credited (%d).", amount, inv.AmountPaid-inv.PostPaymentCreditNotesAmount))
+				return
+			}
+			if refundAmount > 0 {
+				charge := f.charges[inv.Charge.ID]
+				var refund *stripe.Refund
+				if refund, err = f.refund(charge, &refundAmount, "", nil); err != nil {
+					return
+				}
+				cn.Refund = &stripe.Refund{ID: refund.ID}
+			}
+			if creditAmount > 0 {
+				f.customers[inv.Customer.ID].Balance -= creditAmount
+			}
+			cn.Type = stripe.CreditNoteTypePostPayment
+			inv.PostPaymentCreditNotesAmount += amount
+		default:
+			err = fakeInvalidRequest("You can only create a credit note for an invoice that is open or paid.")
+			return
+		}
+
+		f.creditNotes[cn.ID] = cn
+		f.remember(params.IdempotencyKey, cn.ID)
+		f.emit("credit_note.created", cn)
+		if inv.Status == stripe.InvoiceStatusPaid && cn.Type == stripe.CreditNoteTypePrePayment {
+			f.emit("invoice.paid", inv)
+		} else {
+			f.emit("invoice.updated", inv)
+		}
+		result = fakeClone(cn)
+	})
+	return result, err
+}
+
+// --- 異議申し立て（チャージバック） ---
+
+// UpdateDispute は証拠を登録する。Stripe と同じく Submit を省略するとカード会社に提出し、
+// 回答待ち（needs_response / warning_needs_response）以外の状態では更新できない
+// ファイルの証拠は UploadFile で dispute_evidence としてアップロードしたファイルのみ指定できる
+func (f *FakeBillingProvider) UpdateDispute(_ context.Context, disputeID string, params *stripe.DisputeParams) (*stripe.Dispute, error) {
+	var result *stripe.Dispute
+	var err error
+	f.mutate(func() {
+		dispute, ok := f.disputes[disputeID]
+		if !ok {
+			err = fakeMissing("dispute", disputeID)
+			return
+		}
+		if dispute.Status != stripe.DisputeStatusNeedsResponse && dispute.Status != stripe.DisputeStatusWarningNeedsResponse {
+			err = fakeInvalidRequest(fmt.Sprintf("This dispute cannot be updated because its status is %s.", dispute.Status))
+			return
+		}
+
+		evidence := fakeDisputeEvidenceValues(params.Evidence)
+		for field, value := range evidence {
+			if !fakeDisputeFileEvidence[field] {
+				continue
+			}
+			if file, ok := f.files[value]; !ok || file.Purpose != stripe.FilePurposeDisputeEvidence {
+				err = fakeInvalidRequest(fmt.Sprintf("Invalid file for evidence[%s]: %s", field, value))
+				return
+			}
+		}
+		if f.disputeEvidence[disputeID] == nil {
+			f.disputeEvidence[disputeID] = make(map[string]string)
+		}
+		for field, value := range evidence {
+			f.disputeEvidence[disputeID][field] = value
+		}
+		for k, v := range params.Metadata {
+			dispute.Metadata[k] = v
+		}
+
+		details := dispute.EvidenceDetails
+		details.HasEvidence = len(f.disputeEvidence[disputeID]) > 0
+		if params.Submit == nil || *params.Submit {
+			details.SubmissionCount++
+			details.PastDue = f.now.Unix() > details.DueBy
+			if dispute.Status == stripe.DisputeStatusNeedsResponse {
+				dispute.Status = stripe.DisputeStatusUnderReview
+			} else {
+				dispute.Status = stripe.DisputeStatusWarningUnderReview
+			}
+		}
+
+		f.emit("charge.dispute.updated", dispute)
+		result = fakeClone(dispute)
+	})
+	return result, err
+}
+
+// UploadFile はファイルを保存する（内容は保持せず、サイズのみを記録する）
+func (f *FakeBillingProvider) UploadFile(_ context.Context, params *stripe.FileParams) (*stripe.File, error) {
+	if params.FileReader == nil || params.Purpose == nil {
+		return nil, fakeInvalidRequest("params.Purpose and params.FileReader must be set")
+	}
+	size, err := io.Copy(io.Discard, params.FileReader)
+	if err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	file := &stripe.File{
+		ID:       f.newID("file"),
+		Object:   "file",
+		Filename: stripe.StringValue(params.Filename),
+		Purpose:  stripe.FilePurpose(*params.Purpose),
+		Size:     size,
+		Created:  f.now.Unix(),
+	}
+	f.files[file.ID] = file
+	return fakeClone(file), nil
+}
+
+// --- プロモーションコード ---
+
+func (f *FakeBillingProvider) ListActivePromotionCodes(_ context.Context, code string) ([]*stripe.PromotionCode, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var codes []*stripe.PromotionCode
+	for _, pc := range f.promotionCodes {
+		if pc.Active && strings.EqualFold(pc.Code, code) {
+			codes = append(codes, fakeClone(pc))
+		}
+	}
+	sortNewestFirst(codes, func(pc *stripe.PromotionCode) string { return pc.ID })
+	return codes, nil
+}
+
+// --- 価格 ---
+
+func (f *FakeBillingProvider) ListRecurringPrices(_ context.Context) ([]*stripe.Price, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var prices []*stripe.Price
+	for _, price := range f.prices {
+		if price.Active && price.Type == stripe.PriceTypeRecurring {
+			prices = append(prices, fakeClone(price))
+		}
+	}
+	sort.Slice(prices, func(i, j int) bool { return prices[i].ID < prices[j].ID })
+	return prices, nil
+}
+
+// --- 内部処理（呼び出し元で f.mu を保持していること） ---
+
+// mutate は状態を変更し、その間に発生したイベントをロックの外で配信する
+// 受け取り側の処理から FakeBillingProvider を呼び出してもデッドロックしない
+func (f *FakeBillingProvider) mutate(fn func()) {
+	f.mu.Lock()
+	fn()
+	pending := f.pending
+	f.pending = nil
+	handlers := make([]func(stripe.Event), len(f.handlers))
+	copy(handlers, f.handlers)
+	f.mu.Unlock()
+
+	for _, event := range pending {
+		for _, handler := range handlers {
+			handler(event)
+		}
+	}
+}
+
+func (f *FakeBillingProvider) newID(prefix string) string {
+	f.seq++
+	return fmt.Sprintf("%s_fake%08d", prefix, f.seq)
+}
+
+func (f *FakeBillingProvider) replay(key *string) (string, bool) {
+	if key == nil {
+		return "", false
+	}
+	id, ok := f.idempotencyKeys[*key]
+	return id, ok
+}
+
+func (f *FakeBillingProvider) remember(key *string, id string) {
+	if key != nil {
+		f.idempotencyKeys[*key] = id
+	}
+}
+
+// emit は Webhook と同じ形式のイベントを記録する（配信は mutate が行う）
+func (f *FakeBillingProvider) emit(eventType string, object interface{}) {
+	raw, err := json.Marshal(object)
+	if err != nil {
+		panic(fmt.Sprintf("fake billing: failed to encode %s: %v", eventType, err))
+	}
+	event := stripe.Event{
+		ID:         f.newID("evt"),
+		Object:     "event",
+		Type:       stripe.EventType(eventType),
+		Created:    f.now.Unix(),
+		APIVersion: stripe.APIVersion,
+		Data:       &stripe.EventData{Raw: raw},
+	}
+	f.events = append(f.events, event)
+	f.pending = append(f.pending, event)
+}
+
+func (f *FakeBillingProvider) emitInvoice(inv *stripe.Invoice) {
+	switch inv.Status {
+	case stripe.InvoiceStatusPaid:
+		f.emit("invoice.paid", inv)
+	case stripe.InvoiceStatusVoid:
+		f.emit("invoice.voided", inv)
+	case stripe.InvoiceStatusUncollectible:
+		f.emit("invoice.marked_uncollectible", inv)
+	case stripe.InvoiceStatusDraft:
+		f.emit("invoice.created", inv)
+	default:
+		f.emit("invoice.payment_failed", inv)
+	}
+}
+
+// chargeOf は PaymentIntent または Charge の ID から支払いを探す
+func (f *FakeBillingProvider) chargeOf(paymentIntentID, chargeID string) *stripe.Charge {
+	if chargeID != "" {
+		return f.charges[chargeID]
+	}
+	for _, charge := range f.charges {
+		if paymentIntentID != "" && charge.PaymentIntent.ID == paymentIntentID {
+			return charge
+		}
+	}
+	return nil
+}
+
+func (f *FakeBillingProvider) invoiceOf(invoiceID string) *stripe.Invoice {
+	for _, inv := range f.invoices {
+		if inv.ID == invoiceID {
+			return inv
+		}
+	}
+	return nil
+}
+
+// refund は支払いの未返金額の範囲で返金する（amount が nil の場合は未返金の全額）
+func (f *FakeBillingProvider) refund(charge *stripe.Charge, amount *int64, reason string, metadata map[string]string) (*stripe.Refund, error) {
+	remaining := charge.Amount - charge.AmountRefunded
+	if remaining <= 0 {
+		return nil, &stripe.Error{
+			Type:           stripe.ErrorTypeInvalidRequest,
+			Code:           stripe.ErrorCodeChargeAlreadyRefunded,
+			HTTPStatusCode: 400,
+			Msg:            fmt.Sprintf("Charge %s has already been refunded.", charge.ID),
+		}
+	}
+	value := remaining
+	if amount != nil {
+		value = *amount
+	}
+	if value <= 0 || value > remaining {
+		return nil, fakeInvalidRequest(fmt.Sprintf("Refund amount (%d) is greater than unrefunded amount on charge (%d)", value, remaining))
+	}
+
+	refund := &stripe.Refund{
+		ID:            f.newID("re"),
+		Object:        "refund",
+		Amount:        value,
+		Currency:      charge.Currency,
+		Charge:        &stripe.Charge{ID: charge.ID},
+		PaymentIntent: &stripe.PaymentIntent{ID: charge.PaymentIntent.ID},
+		Reason:        stripe.RefundReason(reason),
+		Status:        stripe.RefundStatusSucceeded,
+		Metadata:      map[string]string{},
+		Created:       f.now.Unix(),
+	}
+	for k, v := range metadata {
+		refund.Metadata[k] = v
+	}
+	f.refunds[refund.ID] = refund
+	charge.AmountRefunded += value
+	charge.Refunded = charge.AmountRefunded == charge.Amount
+
+	// 現在の API バージョンと同じく、charge.refunded の Charge には返金の一覧を含めない
+	f.emit("charge.refunded", charge)
+	f.emit("refund.created", refund)
+	return refund, nil
+}
+
+// cardsOf は顧客に紐付いたカードを新しい順に返す
+func (f *FakeBillingProvider) cardsOf(customerID string) []*stripe.PaymentMethod {
+	var methods []*stripe.PaymentMethod
+	for _, pm := range f.paymentMethods {
+		if pm.Customer != nil && pm.Customer.ID == customerID {
+			methods = append(methods, pm)
+		}
+	}
+	sortNewestFirst(methods, func(pm *stripe.PaymentMethod) string { return pm.ID })
+	return methods
+}
+
+// defaultPaymentMethod は請求に使う支払い方法（既定の支払い方法、なければ最新のカード）を返す
+func (f *FakeBillingProvider) defaultPaymentMethod(customerID string) string {
+	if cust, ok := f.customers[customerID]; ok && cust.InvoiceSettings.DefaultPaymentMethod != nil {
+		return cust.InvoiceSettings.DefaultPaymentMethod.ID
+	}
+	if cards := f.cardsOf(customerID); len(cards) > 0 {
+		return cards[0].ID
+	}
+	return ""
+}
+
+func (f *FakeBillingProvider) applyPromotionCode(sub *stripe.Subscription, promotionCodeID string) error {
+	pc, ok := f.promotionCodes[promotionCodeID]
+	if !ok {
+		return fakeMissing("promotion code", promotionCodeID)
+	}
+	if !pc.Active || !pc.Coupon.Valid {
+		return &stripe.Error{
+			Type:           stripe.ErrorTypeInvalidRequest,
+			Code:           stripe.ErrorCodeCouponExpired,
+			HTTPStatusCode: 400,
+			Msg:            "This promotion code is no longer active.",
+		}
+	}
+	if pc.Customer != nil && pc.Customer.ID != sub.Customer.ID {
+		return fakeInvalidRequest("This promotion code cannot be redeemed by this customer.")
+	}
+	pc.TimesRedeemed++
+	if pc.MaxRedemptions > 0 && pc.TimesRedeemed >= pc.MaxRedemptions {
+		pc.Active = false
+	}
+	sub.Discount = &stripe.Discount{
+		ID:            f.newID("di"),
+		Object:        "discount",
+		Coupon:        pc.Coupon,
+		PromotionCode: &stripe.PromotionCode{ID: pc.ID},
+		Customer:      &stripe.Customer{ID: sub.Customer.ID},
+		Subscription:  sub.ID,
+		Start:         f.now.Unix(),
+	}
+	return nil
+}
+
+// newInvoice は現在の契約期間の請求書を作成して支払いを行う
+func (f *FakeBillingProvider) newInvoice(sub *stripe.Subscription, reason stripe.InvoiceBillingReason) *stripe.Invoice {
+	price := sub.Items.Data[0].Price
+	amount := fakeDiscountedAmount(price.UnitAmount, sub.Discount)
+	start, end := time.Unix(sub.CurrentPeriodStart, 0), time.Unix(sub.CurrentPeriodEnd, 0)
+	inv := f.issueInvoice(sub, reason, fakeInvoiceLines(sub, price, amount, start, end).Data, start, end)
+	if sub.Discount != nil {
+		inv.Discount = sub.Discount
+	}
+
+	// 1回限りのクーポンは最初の請求で使い切る
+	if sub.Discount != nil && sub.Discount.Coupon.Duration == stripe.CouponDurationOnce {
+		sub.Discount = nil
+	}
+	return inv
+}
+
+// issueInvoice は明細から請求書を発行して支払いを行う（合計が負の場合は請求しない）
+// 支払いを一時停止している場合は pause_collection.behavior に従って請求書の状態だけを決め、支払いは行わない
+func (f *FakeBillingProvider) issueInvoice(sub *stripe.Subscription, reason stripe.InvoiceBillingReason, lines []*stripe.InvoiceLineItem, start, end time.Time) *stripe.Invoice {
+	amount := fakeLinesTotal(lines)
+	currency := sub.Items.Data[0].Price.Currency
+	inv := &stripe.Invoice{
+		ID:            f.newID("in"),
+		Object:        "invoice",
+		Customer:      &stripe.Customer{ID: sub.Customer.ID},
+		Subscription:  &stripe.Subscription{ID: sub.ID},
+		Currency:      currency,
+		Created:       f.now.Unix(),
+		PeriodStart:   start.Unix(),
+		PeriodEnd:     end.Unix(),
+		BillingReason: reason,
+		AmountDue:     amount,
+		Subtotal:      amount,
+		Total:         amount,
+		AttemptCount:  1,
+		Attempted:     true,
+		Number:        fmt.Sprintf("FAKE-%04d", len(f.invoices)+1),
+		Lines:         &stripe.InvoiceLineItemList{Data: lines},
+	}
+	if cust, ok := f.customers[sub.Customer.ID]; ok {
+		inv.CustomerName = cust.Name
+	}
+	inv.HostedInvoiceURL = "https://invoice.stripe.test/" + inv.ID
+	inv.InvoicePDF = inv.HostedInvoiceURL + "/pdf"
+	if sub.PauseCollection != nil {
+		inv.Attempted, inv.AttemptCount = false, 0
+		switch sub.PauseCollection.Behavior {
+		case stripe.SubscriptionPauseCollectionBehaviorVoid:
+			inv.Status = stripe.InvoiceStatusVoid
+		case stripe.SubscriptionPauseCollectionBehaviorMarkUncollectible:
+			inv.Status = stripe.InvoiceStatusUncollectible
+			inv.AmountRemaining = amount
+		default:
+			inv.Status = stripe.InvoiceStatusDraft
+			inv.AmountRemaining = amount
+		}
+		f.invoices = append(f.invoices, inv)
+		return inv
+	}
+
+	pi := &stripe.PaymentIntent{
+		ID:       f.newID("pi"),
+		Object:   "payment_intent",
+		Amount:   amount,
+		Currency: currency,
+		Customer: &stripe.Customer{ID: sub.Customer.ID},
+		Created:  f.now.Unix(),
+	}
+	pi.ClientSecret = pi.ID + "_secret_fake"
+	if amount > 0 && (f.declining[sub.Customer.ID] || f.defaultPaymentMethod(sub.Customer.ID) == "") {
+		inv.Status = stripe.InvoiceStatusOpen
+		inv.AmountRemaining = amount
+		pi.Status = stripe.PaymentIntentStatusRequiresPaymentMethod
+		pi.LastPaymentError = fakeCardDeclined()
+	} else {
+		inv.Status = stripe.InvoiceStatusPaid
+		inv.Paid = true
+		inv.AmountPaid = amount
+		inv.StatusTransitions = &stripe.InvoiceStatusTransitions{PaidAt: f.now.Unix()}
+		pi.Status = stripe.PaymentIntentStatusSucceeded
+		if amount > 0 {
+			charge := &stripe.Charge{
+				ID:            f.newID("ch"),
+				Object:        "charge",
+				Amount:        amount,
+				Currency:      currency,
+				Customer:      &stripe.Customer{ID: sub.Customer.ID},
+				Invoice:       &stripe.Invoice{ID: inv.ID},
+				PaymentIntent: &stripe.PaymentIntent{ID: pi.ID},
+				Paid:          true,
+				Captured:      true,
+				Status:        stripe.ChargeStatusSucceeded,
+				Created:       f.now.Unix(),
+			}
+			f.charges[charge.ID] = charge
+			inv.Charge = &stripe.Charge{ID: charge.ID}
+			pi.LatestCharge = &stripe.Charge{ID: charge.ID}
+		}
+	}
+	inv.PaymentIntent = pi
+	f.invoices = append(f.invoices, inv)
+	return inv
+}
+
+// prorationDate は日割り精算の基準日時を返す（指定がなければ現在時刻）
+func (f *FakeBillingProvider) prorationDate(sub *stripe.Subscription, date *int64) (time.Time, error) {
+	if date == nil {
+		return f.now, nil
+	}
+	if *date < sub.CurrentPeriodStart || *date > sub.CurrentPeriodEnd {
+		return time.Time{}, fakeInvalidRequest("The proration date must be within the current billing period.")
+	}
+	return time.Unix(*date, 0), nil
+}
+
+// prorate は at の時点で価格を newPrice に変更した場合の日割り精算の明細と、変更後の契約期間を返す
+// 請求間隔が変わる場合は Stripe と同様に請求サイクルを at から始め直し、新しい価格を全額請求する
+func (f *FakeBillingProvider) prorate(sub *stripe.Subscription, newPrice *stripe.Price, at time.Time) ([]*stripe.InvoiceLineItem, time.Time, time.Time) {
+	oldPrice := sub.Items.Data[0].Price
+	start, end := time.Unix(sub.CurrentPeriodStart, 0), time.Unix(sub.CurrentPeriodEnd, 0)
+	ratio := float64(end.Sub(at)) / float64(end.Sub(start))
+	prorated := func(price *stripe.Price) int64 {
+		return int64(math.Round(float64(price.UnitAmount) * ratio))
+	}
+	line := func(amount int64, price *stripe.Price, description string, proration bool, from, to time.Time) *stripe.InvoiceLineItem {
+		return &stripe.InvoiceLineItem{
+			Object:       "line_item",
+			Type:         stripe.InvoiceLineItemTypeSubscription,
+			Amount:       amount,
+			Currency:     price.Currency,
+			Description:  description,
+			Price:        price,
+			Proration:    proration,
+			Quantity:     1,
+			Subscription: &stripe.Subscription{ID: sub.ID},
+			Period:       &stripe.Period{Start: from.Unix(), End: to.Unix()},
+		}
+	}
+
+	after := at.Format("2006-01-02")
+	lines := []*stripe.InvoiceLineItem{
+		line(-prorated(oldPrice), oldPrice, "Unused time on "+fakePriceName(oldPrice)+" after "+after, true, at, end),
+	}
+	if oldPrice.Recurring.Interval == newPrice.Recurring.Interval && oldPrice.Recurring.IntervalCount == newPrice.Recurring.IntervalCount {
+		lines = append(lines, line(prorated(newPrice), newPrice, "Remaining time on "+fakePriceName(newPrice)+" after "+after, true, at, end))
+		return lines, start, end
+	}
+
+	newEnd := fakeAddInterval(at, newPrice.Recurring)
+	amount := fakeDiscountedAmount(newPrice.UnitAmount, sub.Discount)
+	lines = append(lines, line(amount, newPrice, "1 × "+fakePriceName(newPrice), false, at, newEnd))
+	return lines, at, newEnd
+}
+
+// release はスケジュールを解除し、サブスクリプションとの紐付けを外す
+func (f *FakeBillingProvider) release(schedule *stripe.SubscriptionSchedule) {
+	schedule.Status = stripe.SubscriptionScheduleStatusReleased
+	schedule.ReleasedAt = f.now.Unix()
+	schedule.ReleasedSubscription = schedule.Subscription
+	if sub, ok := f.subscriptions[schedule.Subscription.ID]; ok {
+		sub.Schedule = nil
+		f.emit("subscription_schedule.released", schedule)
+		f.emit("customer.subscription.updated", sub)
+	}
+}
+
+// nextDeadline は t までに期限を迎える処理のうち最も早いものの時刻と処理を返す（なければ run は nil）
+// 同じ時刻の場合はトライアル終了の予告、一時停止の解除、契約期間の終了の順に、サブスクリプションID順で処理する
+func (f *FakeBillingProvider) nextDeadline(t time.Time) (int64, func()) {
+	type deadline struct {
+		at    int64
+		order int
+		subID string
+		run   func()
+	}
+	var next *deadline
+	consider := func(d deadline) {
+		if d.at > t.Unix() {
+			return
+		}
+		if next == nil || d.at < next.at || (d.at == next.at && (d.order < next.order || (d.order == next.order && d.subID < next.subID))) {
+			next = &d
+		}
+	}
+
+	for _, sub := range f.subscriptions {
+		sub := sub
+		switch sub.Status {
+		case stripe.SubscriptionStatusActive, stripe.SubscriptionStatusTrialing, stripe.SubscriptionStatusPastDue:
+		default:
+			continue
+		}
+		if sub.Status == stripe.SubscriptionStatusTrialing && !f.trialNotified[sub.ID] {
+			at := time.Unix(sub.TrialEnd, 0).Add(-fakeTrialWillEndNotice).Unix()
+			if at < sub.TrialStart {
+				at = sub.TrialStart
+			}
+			consider(deadline{at: at, order: 0, subID: sub.ID, run: func() {
+				f.trialNotified[sub.ID] = true
+				f.emit("customer.subscription.trial_will_end", sub)
+			}})
+		}
+		if sub.PauseCollection != nil && sub.PauseCollection.ResumesAt > 0 {
+			consider(deadline{at: sub.PauseCollection.ResumesAt, order: 1, subID: sub.ID, run: func() {
+				sub.PauseCollection = nil
+				f.emit("customer.subscription.updated", sub)
+			}})
+		}
+		consider(deadline{at: sub.CurrentPeriodEnd, order: 2, subID: sub.ID, run: func() { f.endPeriod(sub) }})
+	}
+	if next == nil {
+		return 0, nil
+	}
+	return next.at, next.run
+}
+
+// pauseCollection は pause_collection のパラメータを検証する
+func (f *FakeBillingProvider) pauseCollection(params *stripe.SubscriptionPauseCollectionParams) (*stripe.SubscriptionPauseCollection, error) {
+	behavior := stripe.SubscriptionPauseCollectionBehavior(stripe.StringValue(params.Behavior))
+	switch behavior {
+	case stripe.SubscriptionPauseCollectionBehaviorVoid,
+		stripe.SubscriptionPauseCollectionBehaviorKeepAsDraft,
+		stripe.SubscriptionPauseCollectionBehaviorMarkUncollectible:
+	default:
+		return nil, fakeInvalidRequest("Invalid pause_collection[behavior]: must be one of keep_as_draft, mark_uncollectible, or void")
+	}
+	pause := &stripe.SubscriptionPauseCollection{Behavior: behavior}
+	if params.ResumesAt != nil {
+		if *params.ResumesAt <= f.now.Unix() {
+			return nil, fakeInvalidRequest("pause_collection[resumes_at] must be in the future.")
+		}
+		pause.ResumesAt = *params.ResumesAt
+	}
+	return pause, nil
+}
+
+// endPeriod は契約期間の終了時の処理（解約予約の確定、または次の期間の請求）を行う
+func (f *FakeBillingProvider) endPeriod(sub *stripe.Subscription) {
+	if sub.CancelAtPeriodEnd {
+		f.cancel(sub)
+		return
+	}
+	// カード未登録のままトライアルが終わった場合は trial_settings.end_behavior.missing_payment_method に従う
+	if sub.Status == stripe.SubscriptionStatusTrialing && f.defaultPaymentMethod(sub.Customer.ID) == "" {
+		switch fakeMissingPaymentMethodBehavior(sub) {
+		case stripe.SubscriptionTrialSettingsEndBehaviorMissingPaymentMethodCancel:
+			f.cancel(sub)
+			return
+		case stripe.SubscriptionTrialSettingsEndBehaviorMissingPaymentMethodPause:
+			sub.Status = stripe.SubscriptionStatusPaused
+			f.emit("customer.subscription.paused", sub)
+			f.emit("customer.subscription.updated", sub)
+			return
+		}
+	}
+	// 予約された価格の変更は次の期間から反映する
+	if sub.Schedule != nil {
+		if schedule, ok := f.schedules[sub.Schedule.ID]; ok && len(schedule.Phases) > 1 {
+			sub.Items.Data[0].Price = schedule.Phases[1].Items[0].Price
+			schedule.Status = stripe.SubscriptionScheduleStatusReleased
+			schedule.ReleasedAt = f.now.Unix()
+			schedule.ReleasedSubscription = schedule.Subscription
+		}
+		sub.Schedule = nil
+	}
+	price := sub.Items.Data[0].Price
+	sub.CurrentPeriodStart = sub.CurrentPeriodEnd
+	sub.CurrentPeriodEnd = fakeAddInterval(time.Unix(sub.CurrentPeriodStart, 0), price.Recurring).Unix()
+
+	inv := f.newInvoice(sub, stripe.InvoiceBillingReasonSubscriptionCycle)
+	sub.Status = stripe.SubscriptionStatusActive
+	if inv.Status == stripe.InvoiceStatusOpen {
+		sub.Status = stripe.SubscriptionStatusPastDue
+	}
+	sub.LatestInvoice = inv
+	f.emit("customer.subscription.updated", sub)
+	f.emitInvoice(inv)
+}
+
+func (f *FakeBillingProvider) cancel(sub *stripe.Subscription) {
+	sub.Status = stripe.SubscriptionStatusCanceled
+	if sub.CanceledAt == 0 {
+		sub.CanceledAt = f.now.Unix()
+	}
+	sub.EndedAt = f.now.Unix()
+	f.emit("customer.subscription.deleted", sub)
+}
+
+// fakeTrialWillEndNotice はトライアル終了の何日前に customer.subscription.trial_will_end を送るか（Stripe と同じ3日前）
+const fakeTrialWillEndNotice = 3 * 24 * time.Hour
+
+// fakeMissingPaymentMethodBehavior はカード未登録のままトライアルが終わった場合の動作を返す
+func fakeMissingPaymentMethodBehavior(sub *stripe.Subscription) stripe.SubscriptionTrialSettingsEndBehaviorMissingPaymentMethod {
+	if sub.TrialSettings == nil || sub.TrialSettings.EndBehavior == nil {
+		return stripe.SubscriptionTrialSettingsEndBehaviorMissingPaymentMethodCreateInvoice
+	}
+	return sub.TrialSettings.EndBehavior.MissingPaymentMethod
+}
+
+// fakeInvoiceLines は請求書の明細（サブスクリプション1件分）を作成する
+func fakeInvoiceLines(sub *stripe.Subscription, price *stripe.Price, amount int64, start, end time.Time) *stripe.InvoiceLineItemList {
+	name := fakePriceName(price)
+	return &stripe.InvoiceLineItemList{Data: []*stripe.InvoiceLineItem{{
+		Object:       "line_item",
+		Type:         stripe.InvoiceLineItemTypeSubscription,
+		Amount:       amount,
+		Currency:     price.Currency,
+		Description:  "1 × " + name,
+		Price:        price,
+		Quantity:     1,
+		Subscription: &stripe.Subscription{ID: sub.ID},
+		Period:       &stripe.Period{Start: start.Unix(), End: end.Unix()},
+	}}}
+}
+
+func fakePriceName(price *stripe.Price) string {
+	if price.Nickname != "" {
+		return price.Nickname
+	}
+	return price.ID
+}
+
+// fakeLinesTotal は明細の合計額を返す（負の場合は 0 とし、残額の繰り越しは行わない）
+func fakeLinesTotal(lines []*stripe.InvoiceLineItem) int64 {
+	var total int64
+	for _, line := range lines {
+		total += line.Amount
+	}
+	if total < 0 {
+		return 0
+	}
+	return total
+}
+
+// fakeAddInterval は価格の請求間隔だけ t を進める
+func fakeAddInterval(t time.Time, recurring *stripe.PriceRecurring) time.Time {
+	count := int(recurring.IntervalCount)
+	if count == 0 {
+		count = 1
+	}
+	switch recurring.Interval {
+	case stripe.PriceRecurringIntervalDay:
+		return t.AddDate(0, 0, count)
+	case stripe.PriceRecurringIntervalWeek:
+		return t.AddDate(0, 0, 7*count)
+	case stripe.PriceRecurringIntervalYear:
+		return t.AddDate(count, 0, 0)
+	default:
+		return t.AddDate(0, count, 0)
+	}
+}
+
+// fakeDiscountedAmount はクーポン適用後の金額を返す
+func fakeDiscountedAmount(amount int64, discount *stripe.Discount) int64 {
+	if discount == nil || discount.Coupon == nil {
+		return amount
+	}
+	if discount.Coupon.PercentOff > 0 {
+		amount -= int64(math.Round(float64(amount) * discount.Coupon.PercentOff / 100))
+	}
+	amount -= discount.Coupon.AmountOff
+	if amount < 0 {
+		return 0
+	}
+	return amount
+}
+
+// fakeClone は呼び出し元が内部の状態を書き換えないよう、JSON を経由して複製する
+func fakeClone[T any](v *T) *T {
+	data, err := json.Marshal(v)
+	if err != nil {
+		panic(fmt.Sprintf("fake billing: failed to clone: %v", err))
+	}
+	var out T
+	if err := json.Unmarshal(data, &out); err != nil {
+		panic(fmt.Sprintf("fake billing: failed to clone: %v", err))
+	}
+	return &out
+}
+
+// sortNewestFirst は Stripe の一覧と同じく作成の新しい順に並べる（ID は作成順に採番している）
+func sortNewestFirst[T any](items []T, id func(T) string) {
+	sort.Slice(items, func(i, j int) bool { return id(items[i]) > id(items[j]) })
+}
+
+func fakeStringValues(values []*string) []string {
+	out := make([]string, 0, len(values))
+	for _, v := range values {
+		out = append(out, stripe.StringValue(v))
+	}
+	return out
+}
+
+// fakeDisputeFileEvidence はファイルの ID を指定する証拠のフィールド
+var fakeDisputeFileEvidence = map[string]bool{
+	"cancellation_policy":            true,
+	"customer_communication":         true,
+	"customer_signature":             true,
+	"duplicate_charge_documentation": true,
+	"receipt":                        true,
+	"refund_policy":                  true,
+	"service_documentation":          true,
+	"shipping_documentation":         true,
+	"uncategorized_file":             true,
+}
+
+// fakeDisputeEvidenceValues は指定された証拠をフィールド名（form タグ）ごとの値にする
+func fakeDisputeEvidenceValues(params *stripe.DisputeEvidenceParams) map[string]string {
+	values := make(map[string]string)
+	if params == nil {
+		return values
+	}
+	v := reflect.ValueOf(params).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		value, ok := v.Field(i).Interface().(*string)
+		if !ok || value == nil {
+			continue
+		}
+		values[v.Type().Field(i).Tag.Get("form")] = *value
+	}
+	return values
+}
+
+func fakeDisputeClosed(status stripe.DisputeStatus) bool {
+	return status == stripe.DisputeStatusWon || status == stripe.DisputeStatusLost || status == stripe.DisputeStatusWarningClosed
+}
+
+func fakeMissing(resource, id string) error {
+	return &stripe.Error{
+		Type:           stripe.ErrorTypeInvalidRequest,
+		Code:           stripe.ErrorCodeResourceMissing,
+		HTTPStatusCode: 404,
+		Msg:            fmt.Sprintf("No such %s: '%s'", resource, id),
+	}
+}
+
+func fakeInvalidRequest(msg string) error {
+	return &stripe.Error{Type: stripe.ErrorTypeInvalidRequest, HTTPStatusCode: 400, Msg: msg}
+}
+
+func fakeCardDeclined() *stripe.Error {
+	return &stripe.Error{
+		Type:           stripe.ErrorTypeCard,
+		Code:           stripe.ErrorCodeCardDeclined,
+		DeclineCode:    stripe.DeclineCodeGenericDecline,
+		HTTPStatusCode: 402,
+		Msg:            "Your card was declined.",
+	}
+}
+
+var _ BillingProvider = (*FakeBillingProvider)(nil)
+var _ BillingProvider = (*StripeBillingProvider)(nil)
@@ -0,0 +1,592 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/stripe-go/v81"
+	"github.com/stripe/stripe-go/v81/webhook"
+)
+
+// newFakeBillingCustomer はカードを登録済みの顧客を作成する
+func newFakeBillingCustomer(t *testing.T, fake *FakeBillingProvider) string {
+	t.Helper()
+	ctx := context.Background()
+	cust, err := fake.CreateCustomer(ctx, &stripe.CustomerParams{Email: stripe.String("student@example.com")})
+	require.NoError(t, err)
+
+	pmID := fake.CreateCardPaymentMethod(stripe.PaymentMethodCardBrandVisa, "4242")
+	require.NoError(t, fake.AttachPaymentMethod(ctx, pmID, cust.ID))
+	_, err = fake.UpdateCustomer(ctx, cust.ID, &stripe.CustomerParams{
+		InvoiceSettings: &stripe.CustomerInvoiceSettingsParams{DefaultPaymentMethod: stripe.String(pmID)},
+	})
+	require.NoError(t, err)
+	return cust.ID
+}
+
+func fakeEventTypes(events []stripe.Event) []string {
+	types := make([]string, 0, len(events))
+	for _, e := range events {
+		types = append(types, string(e.Type))
+	}
+	return types
+}
+
+// TestFakeBillingSubscriptionLifecycle は契約・更新・解約予約・期間終了による解約の一連の流れをテストする
+func TestFakeBillingSubscriptionLifecycle(t *testing.T) {
+	start := time.Date(2026, 1, 31, 9, 0, 0, 0, time.UTC)
+	fake := NewFakeBillingProvider(start)
+	fake.AddPrice("price_monthly", "月額プラン", 980, stripe.PriceRecurringIntervalMonth, 1)
+	customerID := newFakeBillingCustomer(t, fake)
+	ctx := context.Background()
+
+	var received []stripe.Event
+	fake.OnEvent(func(e stripe.Event) { received = append(received, e) })
+
+	sub, err := fake.CreateSubscription(ctx, &stripe.SubscriptionParams{
+		Customer: stripe.String(customerID),
+		Items:    []*stripe.SubscriptionItemsParams{{Price: stripe.String("price_monthly")}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, stripe.SubscriptionStatusActive, sub.Status)
+	assert.Equal(t, time.Date(2026, 3, 3, 9, 0, 0, 0, time.UTC).Unix(), sub.CurrentPeriodEnd, "月末の契約は time.AddDate と同じく翌月に繰り越すこと")
+	require.NotNil(t, sub.LatestInvoice)
+	assert.Equal(t, stripe.InvoiceStatusPaid, sub.LatestInvoice.Status)
+	assert.Equal(t, []string{"customer.subscription.created", "invoice.paid"}, fakeEventTypes(received))
+
+	upcoming, err := fake.UpcomingInvoice(ctx, &stripe.InvoiceUpcomingParams{Subscription: stripe.String(sub.ID)})
+	require.NoError(t, err)
+	assert.Equal(t, int64(980), upcoming.AmountDue)
+	assert.Equal(t, sub.CurrentPeriodEnd, upcoming.PeriodStart)
+
+	// 2期間分進めると2回更新される
+	received = nil
+	fake.AdvanceClock(65 * 24 * time.Hour)
+	assert.Equal(t, []string{
+		"customer.subscription.updated", "invoice.paid",
+		"customer.subscription.updated", "invoice.paid",
+	}, fakeEventTypes(received))
+	renewed, err := fake.GetSubscription(ctx, sub.ID)
+	require.NoError(t, err)
+	assert.Equal(t, stripe.SubscriptionStatusActive, renewed.Status)
+	assert.Equal(t, time.Unix(sub.CurrentPeriodEnd, 0).AddDate(0, 1, 0).Unix(), renewed.CurrentPeriodStart)
+
+	invoices, err := fake.ListInvoices(ctx, customerID)
+	require.NoError(t, err)
+	require.Len(t, invoices, 3)
+	assert.Equal(t, stripe.InvoiceBillingReasonSubscriptionCycle, invoices[0].BillingReason, "新しい順に返すこと")
+	assert.Equal(t, stripe.InvoiceBillingReasonSubscriptionCreate, invoices[2].BillingReason)
+
+	// 解約予約後は次回の請求がなく、期間終了時に解約される
+	canceling, err := fake.UpdateSubscription(ctx, sub.ID, &stripe.SubscriptionParams{CancelAtPeriodEnd: stripe.Bool(true)})
+	require.NoError(t, err)
+	assert.True(t, canceling.CancelAtPeriodEnd)
+	assert.Equal(t, stripe.SubscriptionStatusActive, canceling.Status)
+	_, err = fake.UpcomingInvoice(ctx, &stripe.InvoiceUpcomingParams{Subscription: stripe.String(sub.ID)})
+	var stripeErr *stripe.Error
+	require.ErrorAs(t, err, &stripeErr)
+	assert.Equal(t, stripe.ErrorCodeInvoiceUpcomingNone, stripeErr.Code)
+
+	received = nil
+	fake.AdvanceClockTo(time.Unix(canceling.CurrentPeriodEnd, 0))
+	assert.Equal(t, []string{"customer.subscription.deleted"}, fakeEventTypes(received))
+
+	var deleted stripe.Subscription
+	require.NoError(t, json.Unmarshal(received[0].Data.Raw, &deleted))
+	assert.Equal(t, sub.ID, deleted.ID)
+	assert.Equal(t, stripe.SubscriptionStatusCanceled, deleted.Status)
+	assert.Equal(t, customerID, deleted.Customer.ID)
+
+	invoices, err = fake.ListInvoices(ctx, customerID)
+	require.NoError(t, err)
+	assert.Len(t, invoices, 3, "解約後は請求しないこと")
+}
+
+// TestFakeBillingDeclinedPayment はカード拒否時の契約作成と更新の失敗をテストする
+func TestFakeBillingDeclinedPayment(t *testing.T) {
+	fake := NewFakeBillingProvider(time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC))
+	fake.AddPrice("price_monthly", "月額プラン", 980, stripe.PriceRecurringIntervalMonth, 1)
+	customerID := newFakeBillingCustomer(t, fake)
+	ctx := context.Background()
+	params := func() *stripe.SubscriptionParams {
+		return &stripe.SubscriptionParams{
+			Customer:        stripe.String(customerID),
+			Items:           []*stripe.SubscriptionItemsParams{{Price: stripe.String("price_monthly")}},
+			PaymentBehavior: stripe.String("error_if_incomplete"),
+		}
+	}
+
+	fake.DeclinePayments(customerID, true)
+	_, err := fake.CreateSubscription(ctx, params())
+	var stripeErr *stripe.Error
+	require.ErrorAs(t, err, &stripeErr)
+	assert.Equal(t, stripe.ErrorCodeCardDeclined, stripeErr.Code)
+	assert.Empty(t, fake.Events()[3:], "失敗した契約ではイベントを送らないこと")
+
+	fake.DeclinePayments(customerID, false)
+	sub, err := fake.CreateSubscription(ctx, params())
+	require.NoError(t, err)
+
+	// 更新時の支払いに失敗すると past_due になる
+	fake.DeclinePayments(customerID, true)
+	fake.AdvanceClockTo(time.Unix(sub.CurrentPeriodEnd, 0))
+	pastDue, err := fake.GetSubscription(ctx, sub.ID)
+	require.NoError(t, err)
+	assert.Equal(t, stripe.SubscriptionStatusPastDue, pastDue.Status)
+
+	events := fake.Events()
+	assert.Equal(t, "invoice.payment_failed", string(events[len(events)-1].Type))
+}
+
+// TestFakeBillingIdempotencyAndPromotionCode は冪等キーとプロモーションコードの適用をテストする
+func TestFakeBillingIdempotencyAndPromotionCode(t *testing.T) {
+	fake := NewFakeBillingProvider(time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC))
+	fake.AddPrice("price_monthly", "月額プラン", 1000, stripe.PriceRecurringIntervalMonth, 1)
+	customerID := newFakeBillingCustomer(t, fake)
+	ctx := context.Background()
+
+	first := &stripe.SubscriptionParams{
+		Customer: stripe.String(customerID),
+		Items:    []*stripe.SubscriptionItemsParams{{Price: stripe.String("price_monthly")}},
+	}
+	first.SetIdempotencyKey("sub-create:1")
+	retry := *first
+	sub1, err := fake.CreateSubscription(ctx, first)
+	require.NoError(t, err)
+	sub2, err := fake.CreateSubscription(ctx, &retry)
+	require.NoError(t, err)
+	assert.Equal(t, sub1.ID, sub2.ID, "同じ冪等キーでは同じサブスクリプションを返すこと")
+
+	fake.AddPromotionCode("WELCOME", stripe.Coupon{Name: "初月20%オフ", PercentOff: 20, Duration: stripe.CouponDurationOnce})
+	codes, err := fake.ListActivePromotionCodes(ctx, "welcome")
+	require.NoError(t, err)
+	require.Len(t, codes, 1)
+
+	updated, err := fake.UpdateSubscription(ctx, sub1.ID, &stripe.SubscriptionParams{PromotionCode: stripe.String(codes[0].ID)})
+	require.NoError(t, err)
+	require.NotNil(t, updated.Discount)
+	assert.Equal(t, "初月20%オフ", updated.Discount.Coupon.Name)
+
+	upcoming, err := fake.UpcomingInvoice(ctx, &stripe.InvoiceUpcomingParams{Subscription: stripe.String(sub1.ID)})
+	require.NoError(t, err)
+	assert.Equal(t, int64(800), upcoming.AmountDue)
+
+	// 1回限りのクーポンは次の請求で使い切る
+	fake.AdvanceClockTo(time.Unix(updated.CurrentPeriodEnd, 0))
+	invoices, err := fake.ListInvoices(ctx, customerID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(800), invoices[0].AmountPaid)
+	upcoming, err = fake.UpcomingInvoice(ctx, &stripe.InvoiceUpcomingParams{Subscription: stripe.String(sub1.ID)})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1000), upcoming.AmountDue)
+
+	_, err = fake.GetSubscription(ctx, "sub_missing")
+	var stripeErr *stripe.Error
+	require.ErrorAs(t, err, &stripeErr)
+	assert.Equal(t, stripe.ErrorCodeResourceMissing, stripeErr.Code)
+}
+
+// TestFakeBillingSignedWebhook は擬似的な Webhook が Stripe の署名検証を通ることをテストする
+func TestFakeBillingSignedWebhook(t *testing.T) {
+	fake := NewFakeBillingProvider(time.Now())
+	newFakeBillingCustomer(t, fake)
+	events := fake.Events()
+	require.NotEmpty(t, events)
+
+	payload, header, err := fake.SignedWebhook(events[0], "whsec_test")
+	require.NoError(t, err)
+
+	event, err := webhook.ConstructEvent(payload, header, "whsec_test")
+	require.NoError(t, err)
+	assert.Equal(t, events[0].ID, event.ID)
+	assert.Equal(t, stripe.EventType("customer.created"), event.Type)
+
+	var cust stripe.Customer
+	require.NoError(t, json.Unmarshal(event.Data.Raw, &cust))
+	assert.Equal(t, "student@example.com", cust.Email)
+
+	_, err = webhook.ConstructEvent(payload, header, "whsec_other")
+	assert.Error(t, err)
+}
+
+// TestFakeBillingProration は日割り精算のプレビューと即時請求が一致することをテストする
+func TestFakeBillingProration(t *testing.T) {
+	start := time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC)
+	fake := NewFakeBillingProvider(start)
+	fake.AddPrice("price_basic", "ベーシック", 1000, stripe.PriceRecurringIntervalMonth, 1)
+	fake.AddPrice("price_premium", "プレミアム", 3000, stripe.PriceRecurringIntervalMonth, 1)
+	fake.AddPrice("price_yearly", "年額", 9800, stripe.PriceRecurringIntervalYear, 1)
+	customerID := newFakeBillingCustomer(t, fake)
+	ctx := context.Background()
+
+	sub, err := fake.CreateSubscription(ctx, &stripe.SubscriptionParams{
+		Customer: stripe.String(customerID),
+		Items:    []*stripe.SubscriptionItemsParams{{Price: stripe.String("price_basic")}},
+	})
+	require.NoError(t, err)
+	itemID := sub.Items.Data[0].ID
+
+	// 30日の契約期間のちょうど半分で変更する
+	fake.AdvanceClock(15 * 24 * time.Hour)
+	prorationDate := fake.Now().Unix()
+	preview, err := fake.UpcomingInvoice(ctx, &stripe.InvoiceUpcomingParams{
+		Subscription:                  stripe.String(sub.ID),
+		SubscriptionItems:             []*stripe.SubscriptionItemsParams{{ID: stripe.String(itemID), Price: stripe.String("price_premium")}},
+		SubscriptionProrationBehavior: stripe.String("always_invoice"),
+		SubscriptionProrationDate:     stripe.Int64(prorationDate),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1000), preview.AmountDue, "未使用分 -500 と残り期間分 1500 の差額")
+	require.Len(t, preview.Lines.Data, 2)
+	assert.True(t, preview.Lines.Data[0].Proration)
+
+	updated, err := fake.UpdateSubscription(ctx, sub.ID, &stripe.SubscriptionParams{
+		Items:             []*stripe.SubscriptionItemsParams{{ID: stripe.String(itemID), Price: stripe.String("price_premium")}},
+		ProrationBehavior: stripe.String("always_invoice"),
+		ProrationDate:     stripe.Int64(prorationDate),
+		PaymentBehavior:   stripe.String("error_if_incomplete"),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "price_premium", updated.Items.Data[0].Price.ID)
+	assert.Equal(t, sub.CurrentPeriodEnd, updated.CurrentPeriodEnd, "同じ請求間隔では契約期間を変えないこと")
+	require.NotNil(t, updated.LatestInvoice)
+	assert.Equal(t, stripe.InvoiceBillingReasonSubscriptionUpdate, updated.LatestInvoice.BillingReason)
+	assert.Equal(t, preview.AmountDue, updated.LatestInvoice.AmountPaid)
+
+	// 請求間隔が変わる場合は請求サイクルを始め直す
+	fake.DeclinePayments(customerID, true)
+	yearly := &stripe.SubscriptionParams{
+		Items:             []*stripe.SubscriptionItemsParams{{ID: stripe.String(itemID), Price: stripe.String("price_yearly")}},
+		ProrationBehavior: stripe.String("always_invoice"),
+		PaymentBehavior:   stripe.String("error_if_incomplete"),
+	}
+	_, err = fake.UpdateSubscription(ctx, sub.ID, yearly)
+	var stripeErr *stripe.Error
+	require.ErrorAs(t, err, &stripeErr)
+	assert.Equal(t, stripe.ErrorCodeCardDeclined, stripeErr.Code)
+	unchanged, err := fake.GetSubscription(ctx, sub.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "price_premium", unchanged.Items.Data[0].Price.ID, "支払いに失敗した変更は反映しないこと")
+
+	fake.DeclinePayments(customerID, false)
+	switched, err := fake.UpdateSubscription(ctx, sub.ID, yearly)
+	require.NoError(t, err)
+	assert.Equal(t, fake.Now().Unix(), switched.CurrentPeriodStart)
+	assert.Equal(t, fake.Now().AddDate(1, 0, 0).Unix(), switched.CurrentPeriodEnd)
+	assert.Equal(t, int64(9800-1500), switched.LatestInvoice.AmountPaid)
+}
+
+// TestFakeBillingScheduledPriceChange は期間終了時の価格変更の予約と解除をテストする
+func TestFakeBillingScheduledPriceChange(t *testing.T) {
+	fake := NewFakeBillingProvider(time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC))
+	fake.AddPrice("price_monthly", "月額", 3000, stripe.PriceRecurringIntervalMonth, 1)
+	fake.AddPrice("price_yearly", "年額", 9800, stripe.PriceRecurringIntervalYear, 1)
+	customerID := newFakeBillingCustomer(t, fake)
+	ctx := context.Background()
+
+	sub, err := fake.CreateSubscription(ctx, &stripe.SubscriptionParams{
+		Customer: stripe.String(customerID),
+		Items:    []*stripe.SubscriptionItemsParams{{Price: stripe.String("price_yearly")}},
+	})
+	require.NoError(t, err)
+
+	schedule, err := fake.SchedulePriceChange(ctx, sub.ID, "price_monthly")
+	require.NoError(t, err)
+	require.Len(t, schedule.Phases, 2)
+	assert.Equal(t, sub.CurrentPeriodEnd, schedule.Phases[1].StartDate)
+	_, err = fake.SchedulePriceChange(ctx, sub.ID, "price_monthly")
+	assert.Error(t, err, "予約は1件まで")
+
+	// 予約中は解約予約を直接設定できない
+	_, err = fake.UpdateSubscription(ctx, sub.ID, &stripe.SubscriptionParams{CancelAtPeriodEnd: stripe.Bool(true)})
+	assert.Error(t, err)
+
+	fake.AdvanceClockTo(time.Unix(sub.CurrentPeriodEnd, 0))
+	renewed, err := fake.GetSubscription(ctx, sub.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "price_monthly", renewed.Items.Data[0].Price.ID)
+	assert.Nil(t, renewed.Schedule)
+	assert.Equal(t, time.Unix(sub.CurrentPeriodEnd, 0).AddDate(0, 1, 0).Unix(), renewed.CurrentPeriodEnd)
+	invoices, err := fake.ListInvoices(ctx, customerID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3000), invoices[0].AmountPaid, "新しい価格で請求すること")
+
+	// 解除すると現在の価格のまま継続する
+	schedule, err = fake.SchedulePriceChange(ctx, sub.ID, "price_yearly")
+	require.NoError(t, err)
+	require.NoError(t, fake.ReleaseSubscriptionSchedule(ctx, schedule.ID))
+	assert.Error(t, fake.ReleaseSubscriptionSchedule(ctx, schedule.ID))
+	fake.AdvanceClockTo(time.Unix(renewed.CurrentPeriodEnd, 0))
+	kept, err := fake.GetSubscription(ctx, sub.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "price_monthly", kept.Items.Data[0].Price.ID)
+}
+
+// TestFakeBillingPauseCollection は支払いの一時停止中の請求と再開日時での自動再開をテストする
+func TestFakeBillingPauseCollection(t *testing.T) {
+	fake := NewFakeBillingProvider(time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC))
+	fake.AddPrice("price_monthly", "月額", 980, stripe.PriceRecurringIntervalMonth, 1)
+	customerID := newFakeBillingCustomer(t, fake)
+	ctx := context.Background()
+
+	sub, err := fake.CreateSubscription(ctx, &stripe.SubscriptionParams{
+		Customer: stripe.String(customerID),
+		Items:    []*stripe.SubscriptionItemsParams{{Price: stripe.String("price_monthly")}},
+	})
+	require.NoError(t, err)
+
+	_, err = fake.UpdateSubscription(ctx, sub.ID, &stripe.SubscriptionParams{
+		PauseCollection: &stripe.SubscriptionPauseCollectionParams{Behavior: stripe.String("skip")},
+	})
+	assert.Error(t, err, "未知の behavior は受け付けないこと")
+	_, err = fake.UpdateSubscription(ctx, sub.ID, &stripe.SubscriptionParams{
+		PauseCollection: &stripe.SubscriptionPauseCollectionParams{Behavior: stripe.String("void"), ResumesAt: stripe.Int64(fake.Now().Unix())},
+	})
+	assert.Error(t, err, "再開日時は未来であること")
+
+	resumesAt := time.Unix(sub.CurrentPeriodEnd, 0).AddDate(0, 0, 10)
+	paused, err := fake.UpdateSubscription(ctx, sub.ID, &stripe.SubscriptionParams{
+		PauseCollection: &stripe.SubscriptionPauseCollectionParams{Behavior: stripe.String("void"), ResumesAt: stripe.Int64(resumesAt.Unix())},
+	})
+	require.NoError(t, err)
+	require.NotNil(t, paused.PauseCollection)
+	assert.Equal(t, stripe.SubscriptionStatusActive, paused.Status, "一時停止中も status は active のまま")
+
+	// 一時停止中の更新の請求は無効になり、支払いは行わない
+	fake.AdvanceClockTo(time.Unix(sub.CurrentPeriodEnd, 0))
+	invoices, err := fake.ListInvoices(ctx, customerID)
+	require.NoError(t, err)
+	assert.Equal(t, stripe.InvoiceStatusVoid, invoices[0].Status)
+	assert.Zero(t, invoices[0].AmountPaid)
+	current, err := fake.GetSubscription(ctx, sub.ID)
+	require.NoError(t, err)
+	assert.Equal(t, stripe.SubscriptionStatusActive, current.Status)
+
+	// 再開日時を迎えると一時停止を解除し、次の更新から請求する
+	fake.AdvanceClockTo(resumesAt)
+	current, err = fake.GetSubscription(ctx, sub.ID)
+	require.NoError(t, err)
+	assert.Nil(t, current.PauseCollection)
+	events := fake.Events()
+	assert.Equal(t, "customer.subscription.updated", string(events[len(events)-1].Type))
+
+	fake.AdvanceClockTo(time.Unix(current.CurrentPeriodEnd, 0))
+	invoices, err = fake.ListInvoices(ctx, customerID)
+	require.NoError(t, err)
+	assert.Equal(t, stripe.InvoiceStatusPaid, invoices[0].Status)
+
+	// 空文字列の指定で一時停止を解除する
+	_, err = fake.UpdateSubscription(ctx, sub.ID, &stripe.SubscriptionParams{
+		PauseCollection: &stripe.SubscriptionPauseCollectionParams{Behavior: stripe.String("void")},
+	})
+	require.NoError(t, err)
+	params := &stripe.SubscriptionParams{}
+	params.AddExtra("pause_collection", "")
+	resumed, err := fake.UpdateSubscription(ctx, sub.ID, params)
+	require.NoError(t, err)
+	assert.Nil(t, resumed.PauseCollection)
+}
+
+// TestFakeBillingTrial はトライアル付きのサブスクリプションとトライアル終了時の動作をテストする
+func TestFakeBillingTrial(t *testing.T) {
+	fake := NewFakeBillingProvider(time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC))
+	fake.AddPrice("price_monthly", "月額", 980, stripe.PriceRecurringIntervalMonth, 1)
+	ctx := context.Background()
+	trialParams := func(customerID string) *stripe.SubscriptionParams {
+		return &stripe.SubscriptionParams{
+			Customer:        stripe.String(customerID),
+			Items:           []*stripe.SubscriptionItemsParams{{Price: stripe.String("price_monthly")}},
+			TrialPeriodDays: stripe.Int64(14),
+			TrialSettings: &stripe.SubscriptionTrialSettingsParams{
+				EndBehavior: &stripe.SubscriptionTrialSettingsEndBehaviorParams{MissingPaymentMethod: stripe.String("cancel")},
+			},
+		}
+	}
+
+	// カード未登録でもトライアルは開始でき、カードがないまま終わると解約される
+	noCard, err := fake.CreateCustomer(ctx, &stripe.CustomerParams{Email: stripe.String("trial@example.com")})
+	require.NoError(t, err)
+	_, err = fake.CreateSubscription(ctx, &stripe.SubscriptionParams{
+		Customer: stripe.String(noCard.ID),
+		Items:    []*stripe.SubscriptionItemsParams{{Price: stripe.String("price_monthly")}},
+	})
+	assert.Error(t, err, "トライアルなしではカードが必要")
+
+	sub, err := fake.CreateSubscription(ctx, trialParams(noCard.ID))
+	require.NoError(t, err)
+	assert.Equal(t, stripe.SubscriptionStatusTrialing, sub.Status)
+	assert.Equal(t, fake.Now().AddDate(0, 0, 14).Unix(), sub.TrialEnd)
+	assert.Equal(t, sub.TrialEnd, sub.CurrentPeriodEnd)
+	assert.Zero(t, sub.LatestInvoice.AmountDue)
+	assert.True(t, sub.LatestInvoice.Paid)
+
+	fake.AdvanceClockTo(time.Unix(sub.TrialEnd, 0).Add(-4 * 24 * time.Hour))
+	assert.NotContains(t, fakeEventTypes(fake.Events()), "customer.subscription.trial_will_end")
+	fake.AdvanceClock(24 * time.Hour)
+	assert.Contains(t, fakeEventTypes(fake.Events()), "customer.subscription.trial_will_end", "終了の3日前に予告すること")
+
+	fake.AdvanceClockTo(time.Unix(sub.TrialEnd, 0))
+	ended, err := fake.GetSubscription(ctx, sub.ID)
+	require.NoError(t, err)
+	assert.Equal(t, stripe.SubscriptionStatusCanceled, ended.Status)
+
+	// カードを登録していればトライアル終了時に最初の請求を行う
+	withCard := newFakeBillingCustomer(t, fake)
+	sub, err = fake.CreateSubscription(ctx, trialParams(withCard))
+	require.NoError(t, err)
+	fake.AdvanceClockTo(time.Unix(sub.TrialEnd, 0))
+	converted, err := fake.GetSubscription(ctx, sub.ID)
+	require.NoError(t, err)
+	assert.Equal(t, stripe.SubscriptionStatusActive, converted.Status)
+	assert.Equal(t, time.Unix(sub.TrialEnd, 0).AddDate(0, 1, 0).Unix(), converted.CurrentPeriodEnd)
+	invoices, err := fake.ListInvoices(ctx, withCard)
+	require.NoError(t, err)
+	assert.Equal(t, int64(980), invoices[0].AmountPaid)
+}
+
+// TestFakeBillingRefundAndCreditNote は返金とクレジットノートの金額の上限と送られるイベントをテストする
+func TestFakeBillingRefundAndCreditNote(t *testing.T) {
+	fake := NewFakeBillingProvider(time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC))
+	fake.AddPrice("price_monthly", "月額プラン", 980, stripe.PriceRecurringIntervalMonth, 1)
+	customerID := newFakeBillingCustomer(t, fake)
+	ctx := context.Background()
+
+	sub, err := fake.CreateSubscription(ctx, &stripe.SubscriptionParams{
+		Customer: stripe.String(customerID),
+		Items:    []*stripe.SubscriptionItemsParams{{Price: stripe.String("price_monthly")}},
+	})
+	require.NoError(t, err)
+	inv := sub.LatestInvoice
+	require.NotNil(t, inv.Charge)
+
+	var received []stripe.Event
+	fake.OnEvent(func(e stripe.Event) { received = append(received, e) })
+
+	// 一部返金すると charge.refunded に返金の累計が載る
+	params := &stripe.RefundParams{PaymentIntent: stripe.String(inv.PaymentIntent.ID), Amount: stripe.Int64(300)}
+	params.AddMetadata("reason_code", "service_issue")
+	params.SetIdempotencyKey("refund-1")
+	refund, err := fake.CreateRefund(ctx, params)
+	require.NoError(t, err)
+	assert.Equal(t, int64(300), refund.Amount)
+	assert.Equal(t, stripe.RefundStatusSucceeded, refund.Status)
+	assert.Equal(t, "service_issue", refund.Metadata["reason_code"])
+	assert.Equal(t, []string{"charge.refunded", "refund.created"}, fakeEventTypes(received))
+	var charge stripe.Charge
+	require.NoError(t, json.Unmarshal(received[0].Data.Raw, &charge))
+	assert.Equal(t, int64(300), charge.AmountRefunded)
+	assert.Equal(t, inv.ID, charge.Invoice.ID)
+	assert.False(t, charge.Refunded)
+
+	// 同じ冪等キーでは返金を繰り返さない
+	again, err := fake.CreateRefund(ctx, params)
+	require.NoError(t, err)
+	assert.Equal(t, refund.ID, again.ID)
+
+	// 未返金額を超える返金はできない
+	_, err = fake.CreateRefund(ctx, &stripe.RefundParams{PaymentIntent: stripe.String(inv.PaymentIntent.ID), Amount: stripe.Int64(681)})
+	assert.Error(t, err)
+
+	// 支払い済みの請求書のクレジットノートは返金・残高への充当の内訳が金額と一致する必要がある
+	_, err = fake.CreateCreditNote(ctx, &stripe.CreditNoteParams{Invoice: stripe.String(inv.ID), Amount: stripe.Int64(200)})
+	assert.Error(t, err)
+
+	received = nil
+	cn, err := fake.CreateCreditNote(ctx, &stripe.CreditNoteParams{
+		Invoice:      stripe.String(inv.ID),
+		Amount:       stripe.Int64(680),
+		RefundAmount: stripe.Int64(680),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, stripe.CreditNoteTypePostPayment, cn.Type)
+	assert.Equal(t, inv.Number+"-CN-01", cn.Number)
+	require.NotNil(t, cn.Refund)
+	assert.Equal(t, []string{"charge.refunded", "refund.created", "credit_note.created", "invoice.updated"}, fakeEventTypes(received))
+	require.NoError(t, json.Unmarshal(received[0].Data.Raw, &charge))
+	assert.True(t, charge.Refunded, "全額返金済みになること")
+
+	_, err = fake.CreateRefund(ctx, &stripe.RefundParams{PaymentIntent: stripe.String(inv.PaymentIntent.ID)})
+	var stripeErr *stripe.Error
+	require.ErrorAs(t, err, &stripeErr)
+	assert.Equal(t, stripe.ErrorCodeChargeAlreadyRefunded, stripeErr.Code)
+}
+
+func TestFakeBillingDispute(t *testing.T) {
+	fake := NewFakeBillingProvider(time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC))
+	fake.AddPrice("price_monthly", "月額プラン", 980, stripe.PriceRecurringIntervalMonth, 1)
+	customerID := newFakeBillingCustomer(t, fake)
+	ctx := context.Background()
+
+	sub, err := fake.CreateSubscription(ctx, &stripe.SubscriptionParams{
+		Customer: stripe.String(customerID),
+		Items:    []*stripe.SubscriptionItemsParams{{Price: stripe.String("price_monthly")}},
+	})
+	require.NoError(t, err)
+	inv := sub.LatestInvoice
+
+	var received []stripe.Event
+	fake.OnEvent(func(e stripe.Event) { received = append(received, e) })
+
+	disputeID, err := fake.OpenDispute(inv.PaymentIntent.ID, stripe.DisputeReasonFraudulent)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"charge.dispute.created", "charge.dispute.funds_withdrawn"}, fakeEventTypes(received))
+	var dispute stripe.Dispute
+	require.NoError(t, json.Unmarshal(received[0].Data.Raw, &dispute))
+	assert.Equal(t, stripe.DisputeStatusNeedsResponse, dispute.Status)
+	assert.Equal(t, int64(980), dispute.Amount)
+	assert.Equal(t, inv.Charge.ID, dispute.Charge.ID)
+	assert.Equal(t, time.Date(2026, 4, 8, 0, 0, 0, 0, time.UTC).Unix(), dispute.EvidenceDetails.DueBy)
+
+	// 証拠のファイルは dispute_evidence としてアップロードしたものだけを指定できる
+	file, err := fake.UploadFile(ctx, &stripe.FileParams{
+		FileReader: strings.NewReader("%PDF-1.4"),
+		Filename:   stripe.String("log.pdf"),
+		Purpose:    stripe.String(string(stripe.FilePurposeDisputeEvidence)),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int64(8), file.Size)
+	_, err = fake.UpdateDispute(ctx, disputeID, &stripe.DisputeParams{
+		Evidence: &stripe.DisputeEvidenceParams{ServiceDocumentation: stripe.String("file_unknown")},
+		Submit:   stripe.Bool(false),
+	})
+	assert.Error(t, err)
+
+	// submit=false は保存のみで、状態は変わらない
+	received = nil
+	updated, err := fake.UpdateDispute(ctx, disputeID, &stripe.DisputeParams{
+		Evidence: &stripe.DisputeEvidenceParams{
+			ProductDescription:   stripe.String("オンライン授業の月額利用料"),
+			ServiceDocumentation: stripe.String(file.ID),
+		},
+		Submit: stripe.Bool(false),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, stripe.DisputeStatusNeedsResponse, updated.Status)
+	assert.True(t, updated.EvidenceDetails.HasEvidence)
+	assert.Equal(t, int64(0), updated.EvidenceDetails.SubmissionCount)
+	assert.Equal(t, []string{"charge.dispute.updated"}, fakeEventTypes(received))
+	assert.Equal(t, map[string]string{
+		"product_description":   "オンライン授業の月額利用料",
+		"service_documentation": file.ID,
+	}, fake.DisputeEvidence(disputeID))
+
+	// Stripe と同じく submit を省略すると提出し、以降は更新できない
+	updated, err = fake.UpdateDispute(ctx, disputeID, &stripe.DisputeParams{})
+	require.NoError(t, err)
+	assert.Equal(t, stripe.DisputeStatusUnderReview, updated.Status)
+	assert.Equal(t, int64(1), updated.EvidenceDetails.SubmissionCount)
+	_, err = fake.UpdateDispute(ctx, disputeID, &stripe.DisputeParams{})
+	assert.Error(t, err)
+
+	received = nil
+	require.NoError(t, fake.CloseDispute(disputeID, true))
+	assert.Equal(t, []string{"charge.dispute.closed", "charge.dispute.funds_reinstated"}, fakeEventTypes(received))
+	require.NoError(t, json.Unmarshal(received[0].Data.Raw, &dispute))
+	assert.Equal(t, stripe.DisputeStatusWon, dispute.Status)
+	assert.Error(t, fake.CloseDispute(disputeID, false), "確定した結果は変えられない")
+}
@@ -0,0 +1,390 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"juice_academy_backend/utils"
+
+	"github.com/stripe/stripe-go/v81"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// DisputeRecord は disputes コレクションのドキュメント（Stripe の異議申し立て（チャージバック）の写し）
+// Stripe の項目は charge.dispute.* の Webhook で更新し、証拠の提出履歴と利用停止は管理画面の操作で更新する
+type DisputeRecord struct {
+	ID                    primitive.ObjectID `bson:"_id,omitempty" json:"-"`
+	StripeDisputeID       string             `bson:"stripe_dispute_id" json:"id"`
+	StripeChargeID        string             `bson:"stripe_charge_id" json:"-"`
+	StripePaymentIntentID string             `bson:"stripe_payment_intent_id,omitempty" json:"-"`
+	// 請求書と利用者は請求書の台帳（invoices）から紐付ける。台帳にない支払いの場合は空
+	StripeInvoiceID  string              `bson:"stripe_invoice_id,omitempty" json:"invoice_id,omitempty"`
+	InvoiceNumber    string              `bson:"invoice_number,omitempty" json:"invoice_number,omitempty"`
+	StripeCustomerID string              `bson:"stripe_customer_id,omitempty" json:"-"`
+	UserID           *primitive.ObjectID `bson:"user_id,omitempty" json:"user_id,omitempty"`
+	Amount           int64               `bson:"amount" json:"amount"`
+	Currency         string              `bson:"currency" json:"currency"`
+	Reason           string              `bson:"reason" json:"reason"`
+	Status           string              `bson:"status" json:"status"`
+	// EvidenceDueBy は証拠の提出期限（カード会社が回答を受け付けない場合は nil）
+	EvidenceDueBy      *time.Time `bson:"evidence_due_by,omitempty" json:"evidence_due_by,omitempty"`
+	HasEvidence        bool       `bson:"has_evidence" json:"has_evidence"`
+	PastDue            bool       `bson:"past_due" json:"past_due"`
+	SubmissionCount    int64      `bson:"submission_count" json:"submission_count"`
+	IsChargeRefundable bool       `bson:"is_charge_refundable" json:"is_charge_refundable"`
+	Livemode           bool       `bson:"livemode" json:"-"`
+	// CreatedAt は Stripe 上で異議申し立てが作成された日時
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+	// Submissions / SubscriptionSuspendedAt は管理画面の操作で更新し、Webhook では上書きしない
+	Submissions             []DisputeSubmission `bson:"submissions,omitempty" json:"submissions,omitempty"`
+	SubscriptionSuspendedAt *time.Time          `bson:"subscription_suspended_at,omitempty" json:"subscription_suspended_at,omitempty"`
+	// StripeObservedAt は反映済みの Stripe の状態の時刻（Webhook イベントの作成日時）
+	StripeObservedAt time.Time `bson:"stripe_observed_at" json:"-"`
+	UpdatedAt        time.Time `bson:"updated_at" json:"updated_at"`
+}
+
+// DisputeSubmission は管理画面から登録した証拠1回分
+// Submitted が false の場合は Stripe に保存しただけで、カード会社には提出していない
+type DisputeSubmission struct {
+	Fields    []string              `bson:"fields" json:"fields"`
+	Files     []DisputeEvidenceFile `bson:"files,omitempty" json:"files,omitempty"`
+	Submitted bool                  `bson:"submitted" json:"submitted"`
+	CreatedBy primitive.ObjectID    `bson:"created_by" json:"created_by"`
+	CreatedAt time.Time             `bson:"created_at" json:"created_at"`
+}
+
+// DisputeEvidenceFile は証拠としてアップロードしたファイル
+type DisputeEvidenceFile struct {
+	Field        string `bson:"field" json:"field"`
+	StripeFileID string `bson:"stripe_file_id" json:"stripe_file_id"`
+	Filename     string `bson:"filename" json:"filename"`
+	Size         int64  `bson:"size" json:"size"`
+}
+
+// DisputeAwaitingResponse は証拠の提出を待っている状態かを返す
+func DisputeAwaitingResponse(status string) bool {
+	return status == string(stripe.DisputeStatusNeedsResponse) || status == string(stripe.DisputeStatusWarningNeedsResponse)
+}
+
+// DisputeClosed は結果が確定した状態かを返す
+func DisputeClosed(status string) bool {
+	switch stripe.DisputeStatus(status) {
+	case stripe.DisputeStatusWon, stripe.DisputeStatusLost, stripe.DisputeStatusWarningClosed:
+		return true
+	}
+	return false
+}
+
+// NewDisputeRecord は Stripe の異議申し立てから Stripe の項目のみを設定した DisputeRecord を作成する
+func NewDisputeRecord(dispute *stripe.Dispute, observedAt time.Time) DisputeRecord {
+	record := DisputeRecord{
+		StripeDisputeID:    dispute.ID,
+		Amount:             dispute.Amount,
+		Currency:           string(dispute.Currency),
+		Reason:             string(dispute.Reason),
+		Status:             string(dispute.Status),
+		IsChargeRefundable: dispute.IsChargeRefundable,
+		Livemode:           dispute.Livemode,
+		CreatedAt:          time.Unix(dispute.Created, 0),
+		StripeObservedAt:   observedAt,
+		UpdatedAt:          time.Now(),
+	}
+	if dispute.Charge != nil {
+		record.StripeChargeID = dispute.Charge.ID
+	}
+	if dispute.PaymentIntent != nil {
+		record.StripePaymentIntentID = dispute.PaymentIntent.ID
+	}
+	if details := dispute.EvidenceDetails; details != nil {
+		if details.DueBy > 0 {
+			dueBy := time.Unix(details.DueBy, 0)
+			record.EvidenceDueBy = &dueBy
+		}
+		record.HasEvidence = details.HasEvidence
+		record.PastDue = details.PastDue
+		record.SubmissionCount = details.SubmissionCount
+	}
+	return record
+}
+
+// DisputeUpdate は ApplyStripeDispute で反映した内容
+type DisputeUpdate struct {
+	Record DisputeRecord
+	// Created は初めて保存した場合に true、PreviousStatus は反映前の状態
+	Created        bool
+	PreviousStatus string
+}
+
+// Closed は今回の反映で結果が確定したかを返す
+func (u DisputeUpdate) Closed() bool {
+	return DisputeClosed(u.Record.Status) && !DisputeClosed(u.PreviousStatus)
+}
+
+// ApplyStripeDispute は Stripe の異議申し立てを disputes コレクションに保存する（charge.dispute.* の Webhook で使う）
+// 支払いの請求書と利用者は、同じデータベースの invoices と payments から探して紐付ける
+// observedAt より新しい状態を保存済みの場合は何もせず nil を返す
+func ApplyStripeDispute(ctx context.Context, collection *mongo.Collection, dispute *stripe.Dispute, observedAt time.Time) (*DisputeUpdate, error) {
+	record := NewDisputeRecord(dispute, observedAt)
+	linkDisputeToInvoice(ctx, collection.Database(), &record)
+
+	filter := bson.M{
+		"stripe_dispute_id": dispute.ID,
+		"$or": []bson.M{
+			{"stripe_observed_at": bson.M{"$lte": observedAt}},
+			{"stripe_observed_at": bson.M{"$exists": false}},
+		},
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.Before)
+	var before DisputeRecord
+	err := collection.FindOneAndUpdate(ctx, filter, bson.M{"$set": record}, opts).Decode(&before)
+	switch {
+	case err == mongo.ErrNoDocuments:
+		return &DisputeUpdate{Record: record, Created: true}, nil
+	case mongo.IsDuplicateKeyError(err):
+		// 新しい状態が保存済みのため filter に一致せず、upsert が一意制約に当たった
+		return nil, nil
+	case err != nil:
+		return nil, err
+	}
+
+	// 紐付けに失敗した場合も保存済みの紐付けは残る（$set で空の項目は送らない）
+	if record.UserID == nil {
+		record.UserID = before.UserID
+	}
+	if record.StripeInvoiceID == "" {
+		record.StripeInvoiceID = before.StripeInvoiceID
+		record.InvoiceNumber = before.InvoiceNumber
+	}
+	record.ID = before.ID
+	record.Submissions = before.Submissions
+	record.SubscriptionSuspendedAt = before.SubscriptionSuspendedAt
+	return &DisputeUpdate{Record: record, PreviousStatus: before.Status}, nil
+}
+
+// linkDisputeToInvoice は支払い（Charge / PaymentIntent）から請求書と利用者を探して設定する
+func linkDisputeToInvoice(ctx context.Context, database *mongo.Database, record *DisputeRecord) {
+	var conditions []bson.M
+	if record.StripeChargeID != "" {
+		conditions = append(conditions, bson.M{"stripe_charge_id": record.StripeChargeID})
+	}
+	if record.StripePaymentIntentID != "" {
+		conditions = append(conditions, bson.M{"stripe_payment_intent_id": record.StripePaymentIntentID})
+	}
+	if len(conditions) == 0 {
+		return
+	}
+
+	var invoice InvoiceRecord
+	if err := database.Collection("invoices").FindOne(ctx, bson.M{"$or": conditions}).Decode(&invoice); err != nil {
+		if err != mongo.ErrNoDocuments {
+			utils.LogErrorCtx(ctx, "Dispute", err, "Failed to find invoice for dispute: "+utils.MaskStripeID(record.StripeDisputeID))
+		}
+		return
+	}
+	record.StripeInvoiceID = invoice.StripeInvoiceID
+	record.InvoiceNumber = invoice.Number
+	record.StripeCustomerID = invoice.StripeCustomerID
+
+	var payment struct {
+		UserID primitive.ObjectID `bson:"user_id"`
+	}
+	if err := database.Collection("payments").FindOne(ctx, bson.M{"stripe_customer_id": invoice.StripeCustomerID}).Decode(&payment); err == nil {
+		record.UserID = &payment.UserID
+	}
+}
+
+// disputeStatusLabels は管理者への通知に使う状態の表示名
+var disputeStatusLabels = map[string]string{
+	string(stripe.DisputeStatusNeedsResponse):        "回答待ち",
+	string(stripe.DisputeStatusUnderReview):          "審査中",
+	string(stripe.DisputeStatusWon):                  "勝訴（売上を回復）",
+	string(stripe.DisputeStatusLost):                 "敗訴（返金が確定）",
+	string(stripe.DisputeStatusWarningNeedsResponse): "照会（回答待ち）",
+	string(stripe.DisputeStatusWarningUnderReview):   "照会（審査中）",
+	string(stripe.DisputeStatusWarningClosed):        "照会（終了）",
+}
+
+// DisputeStatusLabel は状態の表示名を返す（未知の状態はそのまま返す）
+func DisputeStatusLabel(status string) string {
+	if label, ok := disputeStatusLabels[status]; ok {
+		return label
+	}
+	return status
+}
+
+// DisputeAlert は管理者に送る異議申し立ての通知
+type DisputeAlert struct {
+	Event         string     `json:"event"`
+	DisputeID     string     `json:"dispute_id"`
+	Status        string     `json:"status"`
+	Reason        string     `json:"reason"`
+	Amount        int64      `json:"amount"`
+	Currency      string     `json:"currency"`
+	EvidenceDueBy *time.Time `json:"evidence_due_by,omitempty"`
+	InvoiceNumber string     `json:"invoice_number,omitempty"`
+	UserName      string     `json:"user_name,omitempty"`
+	UserEmail     string     `json:"user_email,omitempty"`
+	StudentID     string     `json:"student_id,omitempty"`
+	DashboardURL  string     `json:"dashboard_url,omitempty"`
+	Text          string     `json:"text"`
+}
+
+// newDisputeAlert は通知の内容を作成する
+// Text は Slack などの受信 Webhook でそのまま表示できる1行の要約
+func newDisputeAlert(update DisputeUpdate) DisputeAlert {
+	record := update.Record
+	alert := DisputeAlert{
+		Event:         "dispute.created",
+		DisputeID:     record.StripeDisputeID,
+		Status:        record.Status,
+		Reason:        record.Reason,
+		Amount:        record.Amount,
+		Currency:      record.Currency,
+		EvidenceDueBy: record.EvidenceDueBy,
+		InvoiceNumber: record.InvoiceNumber,
+	}
+	alert.DashboardURL = "https://dashboard.stripe.com/disputes/" + record.StripeDisputeID
+	if !record.Livemode {
+		alert.DashboardURL = "https://dashboard.stripe.com/test/disputes/" + record.StripeDisputeID
+	}
+
+	amount := utils.FormatAmount(record.Amount, record.Currency)
+	if update.Closed() {
+		alert.Event = "dispute.closed"
+		alert.Text = fmt.Sprintf("[チャージバック] %s の異議申し立ての結果: %s", amount, DisputeStatusLabel(record.Status))
+	} else {
+		alert.Text = fmt.Sprintf("[チャージバック] %s の支払いに異議申し立てがありました（理由: %s）", amount, record.Reason)
+		if record.EvidenceDueBy != nil {
+			alert.Text += "。証拠の提出期限: " + record.EvidenceDueBy.In(disputeAlertLocation).Format("2006-01-02 15:04")
+		}
+	}
+	return alert
+}
+
+// disputeAlertLocation は通知に表示する日時のタイムゾーン
+var disputeAlertLocation = time.FixedZone("JST", 9*60*60)
+
+// NotifyDispute は新しい異議申し立てと結果の確定を管理者に知らせる
+// メールは DISPUTE_ALERT_EMAILS（カンマ区切り。未設定の場合は管理者全員）に送り、
+// DISPUTE_ALERT_WEBHOOK_URL が設定されている場合は同じ内容を JSON で POST する
+// 通知に失敗しても Webhook の処理は成功として扱う
+func NotifyDispute(ctx context.Context, database *mongo.Database, update DisputeUpdate) {
+	if !update.Created && !update.Closed() {
+		return
+	}
+	alert := newDisputeAlert(update)
+
+	if update.Record.UserID != nil {
+		var user struct {
+			Email     string `bson:"email"`
+			NameKana  string `bson:"name_kana"`
+			StudentID string `bson:"student_id"`
+		}
+		if err := database.Collection("users").FindOne(ctx, bson.M{"_id": *update.Record.UserID}).Decode(&user); err == nil {
+			alert.UserName = user.NameKana
+			alert.UserEmail = user.Email
+			alert.StudentID = user.StudentID
+		}
+	}
+
+	utils.LogWarningCtx(ctx, "DisputeAlert", alert.Text+" ID: "+utils.MaskStripeID(alert.DisputeID))
+
+	if EmailConfigured() {
+		for _, to := range disputeAlertRecipients(ctx, database) {
+			if err := SendDisputeAlertEmail(to, alert); err != nil {
+				utils.LogErrorCtx(ctx, "DisputeAlert", err, "Failed to send dispute alert email")
+			}
+		}
+	}
+
+	if url := os.Getenv("DISPUTE_ALERT_WEBHOOK_URL"); url != "" {
+		if err := postDisputeAlert(ctx, url, os.Getenv("DISPUTE_ALERT_WEBHOOK_SECRET"), alert); err != nil {
+			utils.LogErrorCtx(ctx, "DisputeAlert", err, "Failed to post dispute alert webhook")
+		}
+	}
+}
+
+// disputeAlertRecipients は通知メールの宛先を返す
+func disputeAlertRecipients(ctx context.Context, database *mongo.Database) []string {
+	var recipients []string
+	for _, email := range strings.Split(os.Getenv("DISPUTE_ALERT_EMAILS"), ",") {
+		if email = strings.TrimSpace(email); email != "" {
+			recipients = append(recipients, email)
+		}
+	}
+	if len(recipients) > 0 {
+		return recipients
+	}
+
+	filter := bson.M{
+		"$or":       []bson.M{{"is_admin": true}, {"role": "admin"}},
+		"suspended": bson.M{"$ne": true},
+	}
+	cursor, err := database.Collection("users").Find(ctx, filter, options.Find().SetProjection(bson.M{"email": 1}))
+	if err != nil {
+		utils.LogErrorCtx(ctx, "DisputeAlert", err, "Failed to load admin users")
+		return nil
+	}
+	var admins []struct {
+		Email string `bson:"email"`
+	}
+	if err := cursor.All(ctx, &admins); err != nil {
+		utils.LogErrorCtx(ctx, "DisputeAlert", err, "Failed to decode admin users")
+		return nil
+	}
+	for _, admin := range admins {
+		if admin.Email != "" {
+			recipients = append(recipients, admin.Email)
+		}
+	}
+	return recipients
+}
+
+// disputeAlertClient は通知用 Webhook の HTTP クライアント（テストで差し替える）
+var disputeAlertClient = &http.Client{Timeout: 10 * time.Second}
+
+// postDisputeAlert は通知を JSON で POST する
+// secret を設定した場合は本文の HMAC-SHA256 を X-Juice-Signature ヘッダーに付ける
+func postDisputeAlert(ctx context.Context, url, secret string, alert DisputeAlert) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if secret != "" {
+		req.Header.Set("X-Juice-Signature", "sha256="+disputeAlertSignature(secret, body))
+	}
+
+	resp, err := disputeAlertClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("dispute alert webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// disputeAlertSignature は通知の本文の署名（16進数）を返す
+func disputeAlertSignature(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
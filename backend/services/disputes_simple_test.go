@@ -0,0 +1,107 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/stripe-go/v81"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestNewDisputeRecord(t *testing.T) {
+	observedAt := time.Date(2026, 4, 2, 0, 0, 0, 0, time.UTC)
+	dueBy := time.Date(2026, 4, 9, 0, 0, 0, 0, time.UTC)
+	record := NewDisputeRecord(&stripe.Dispute{
+		ID:              "dp_1",
+		Amount:          980,
+		Currency:        stripe.CurrencyJPY,
+		Charge:          &stripe.Charge{ID: "ch_1"},
+		PaymentIntent:   &stripe.PaymentIntent{ID: "pi_1"},
+		Reason:          stripe.DisputeReasonFraudulent,
+		Status:          stripe.DisputeStatusNeedsResponse,
+		EvidenceDetails: &stripe.DisputeEvidenceDetails{DueBy: dueBy.Unix(), SubmissionCount: 1},
+		Created:         observedAt.Unix(),
+	}, observedAt)
+
+	assert.Equal(t, "ch_1", record.StripeChargeID)
+	assert.Equal(t, "pi_1", record.StripePaymentIntentID)
+	assert.Equal(t, "fraudulent", record.Reason)
+	require.NotNil(t, record.EvidenceDueBy)
+	assert.True(t, record.EvidenceDueBy.Equal(dueBy))
+	assert.Equal(t, int64(1), record.SubmissionCount)
+
+	// Webhook の反映で管理画面の操作の記録（証拠の提出履歴・利用停止）と紐付けを上書きしない
+	raw, err := bson.Marshal(record)
+	require.NoError(t, err)
+	var doc bson.M
+	require.NoError(t, bson.Unmarshal(raw, &doc))
+	for _, key := range []string{"_id", "submissions", "subscription_suspended_at", "user_id", "stripe_invoice_id"} {
+		assert.NotContains(t, doc, key)
+	}
+
+	// 回答を受け付けない照会には提出期限がない
+	record = NewDisputeRecord(&stripe.Dispute{ID: "dp_2", Status: stripe.DisputeStatusWarningNeedsResponse, EvidenceDetails: &stripe.DisputeEvidenceDetails{}}, observedAt)
+	assert.Nil(t, record.EvidenceDueBy)
+	assert.True(t, DisputeAwaitingResponse(record.Status))
+}
+
+func TestDisputeUpdateClosed(t *testing.T) {
+	assert.False(t, DisputeUpdate{Record: DisputeRecord{Status: "under_review"}, PreviousStatus: "needs_response"}.Closed())
+	assert.True(t, DisputeUpdate{Record: DisputeRecord{Status: "lost"}, PreviousStatus: "under_review"}.Closed())
+	assert.False(t, DisputeUpdate{Record: DisputeRecord{Status: "lost"}, PreviousStatus: "lost"}.Closed(), "重複したイベントでは通知しない")
+}
+
+func TestNewDisputeAlert(t *testing.T) {
+	dueBy := time.Date(2026, 4, 8, 15, 0, 0, 0, time.UTC)
+	alert := newDisputeAlert(DisputeUpdate{
+		Record:  DisputeRecord{StripeDisputeID: "dp_1", Amount: 980, Currency: "jpy", Reason: "fraudulent", Status: "needs_response", EvidenceDueBy: &dueBy},
+		Created: true,
+	})
+	assert.Equal(t, "dispute.created", alert.Event)
+	assert.Equal(t, "https://dashboard.stripe.com/test/disputes/dp_1", alert.DashboardURL)
+	assert.Contains(t, alert.Text, "理由: fraudulent")
+	assert.Contains(t, alert.Text, "証拠の提出期限: 2026-04-09 00:00")
+
+	alert = newDisputeAlert(DisputeUpdate{
+		Record:         DisputeRecord{StripeDisputeID: "dp_1", Amount: 980, Currency: "jpy", Status: "won", Livemode: true},
+		PreviousStatus: "under_review",
+	})
+	assert.Equal(t, "dispute.closed", alert.Event)
+	assert.Equal(t, "https://dashboard.stripe.com/disputes/dp_1", alert.DashboardURL)
+	assert.Contains(t, alert.Text, "勝訴")
+}
+
+func TestPostDisputeAlert(t *testing.T) {
+	var body []byte
+	var signature string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+		signature = r.Header.Get("X-Juice-Signature")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	alert := DisputeAlert{Event: "dispute.created", DisputeID: "dp_1", Text: "[チャージバック] テスト"}
+	require.NoError(t, postDisputeAlert(context.Background(), server.URL, "secret", alert))
+	var received DisputeAlert
+	require.NoError(t, json.Unmarshal(body, &received))
+	assert.Equal(t, alert.Text, received.Text)
+	assert.Equal(t, "sha256="+disputeAlertSignature("secret", body), signature)
+
+	// 署名鍵が未設定の場合は署名を付けない
+	require.NoError(t, postDisputeAlert(context.Background(), server.URL, "", alert))
+	assert.Empty(t, signature)
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+	assert.Error(t, postDisputeAlert(context.Background(), failing.URL, "", alert))
+}
@@ -6,6 +6,8 @@ import (
 	"html/template"
 	"net/smtp"
 	"os"
+	"sort"
+	"strings"
 
 	"juice_academy_backend/utils"
 )
@@ -41,40 +43,66 @@ type OTPEmailData struct {
 	CompanyName   string
 }
 
+// EmailConfigured はメール送信に必要なSMTP設定がそろっているかを返す
+func EmailConfigured() bool {
+	config := getEmailConfig()
+	return config.Host != "" && config.Port != "" && config.Username != "" && config.Password != "" && config.FromEmail != ""
+}
+
 // sendEmail はSMTP経由でメール送信
 func sendEmail(to, subject, body string) error {
+	return sendEmailWithHeaders(to, subject, body, nil)
+}
+
+// sendEmailWithHeaders は追加のヘッダー（List-Unsubscribe など）を付けてメールを送信する
+func sendEmailWithHeaders(to, subject, body string, headers map[string]string) error {
 	config := getEmailConfig()
 
 	// 設定の検証
-	if config.Host == "" || config.Port == "" || config.Username == "" || config.Password == "" || config.FromEmail == "" {
+	if !EmailConfigured() {
 		return fmt.Errorf("SMTP設定が不完全です")
 	}
 
+	auth := smtp.PlainAuth("", config.Username, config.Password, config.Host)
+	addr := fmt.Sprintf("%s:%s", config.Host, config.Port)
+	if err := smtp.SendMail(addr, auth, config.FromEmail, []string{to}, buildEmailMessage(config, to, subject, body, headers)); err != nil {
+		utils.LogError("SendEmail", err, fmt.Sprintf("smtp_host=%s", config.Host))
+		return fmt.Errorf("SMTP送信エラー: %v", err)
+	}
+
+	return nil
+}
+
+// buildEmailMessage はRFC 2822形式のメッセージを組み立てる
+func buildEmailMessage(config EmailConfig, to, subject, body string, headers map[string]string) []byte {
 	// メールヘッダー
 	from := config.FromEmail
 	if config.FromName != "" {
 		from = fmt.Sprintf("%s <%s>", config.FromName, config.FromEmail)
 	}
 
-	// メールメッセージの作成（RFC 2822形式）
-	message := fmt.Sprintf(
-		"From: %s\r\n"+
-			"To: %s\r\n"+
-			"Subject: %s\r\n"+
-			"MIME-Version: 1.0\r\n"+
-			"Content-Type: text/html; charset=UTF-8\r\n"+
-			"\r\n"+
-			"%s",
-		from, to, subject, body)
+	// 件名はお知らせのタイトルなど入力値を含むため、改行を取り除く
+	subject = strings.NewReplacer("\r", " ", "\n", " ").Replace(subject)
 
-	auth := smtp.PlainAuth("", config.Username, config.Password, config.Host)
-	addr := fmt.Sprintf("%s:%s", config.Host, config.Port)
-	if err := smtp.SendMail(addr, auth, config.FromEmail, []string{to}, []byte(message)); err != nil {
-		utils.LogError("SendOTPEmail", err, fmt.Sprintf("smtp_host=%s", config.Host))
-		return fmt.Errorf("SMTP送信エラー: %v", err)
+	var message strings.Builder
+	fmt.Fprintf(&message, "From: %s\r\nTo: %s\r\nSubject: %s\r\n", from, to, subject)
+	// ヘッダーの順序を固定する
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
 	}
-
-	return nil
+	sort.Strings(names)
+	for _, name := range names {
+		// ヘッダーインジェクション防止のため改行を含む値は送らない
+		value := headers[name]
+		if strings.ContainsAny(name+value, "\r\n") {
+			continue
+		}
+		fmt.Fprintf(&message, "%s: %s\r\n", name, value)
+	}
+	message.WriteString("MIME-Version: 1.0\r\nContent-Type: text/html; charset=UTF-8\r\n\r\n")
+	message.WriteString(body)
+	return []byte(message.String())
 }
 
 // getOTPEmailTemplate はOTPメール用のHTMLテンプレートを返す
@@ -283,3 +311,553 @@ func SendOTPEmail(to, userName, otpCode, purpose string) error {
 	// メール送信
 	return sendEmail(to, subject, body.String())
 }
+
+// InvitationEmailData は招待メールテンプレート用のデータ構造体
+type InvitationEmailData struct {
+	UserName    string
+	StudentID   string
+	SetupURL    string
+	ExpiryDays  int
+	CompanyName string
+}
+
+// getInvitationEmailTemplate は招待メール用のHTMLテンプレートを返す
+func getInvitationEmailTemplate() string {
+	return `
+<!DOCTYPE html>
+<html lang="ja">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>アカウントのご案内 - {{.CompanyName}}</title>
+    <style>
+        body {
+            font-family: 'Helvetica Neue', Arial, sans-serif;
+            line-height: 1.6;
+            color: #333;
+            background-color: #f8f9fa;
+            margin: 0;
+            padding: 20px;
+        }
+        .container {
+            max-width: 600px;
+            margin: 0 auto;
+            background: white;
+            border-radius: 12px;
+            box-shadow: 0 4px 6px rgba(0, 0, 0, 0.1);
+            overflow: hidden;
+        }
+        .header {
+            background: linear-gradient(135deg, #ff6b35, #f7931e);
+            color: white;
+            padding: 30px;
+            text-align: center;
+        }
+        .content {
+            padding: 40px 30px;
+        }
+        .button {
+            display: inline-block;
+            background: #ff6b35;
+            color: white;
+            padding: 14px 32px;
+            border-radius: 8px;
+            text-decoration: none;
+            font-weight: bold;
+        }
+        .expiry-info {
+            background: #fff3cd;
+            border-left: 4px solid #ffc107;
+            padding: 15px;
+            margin: 20px 0;
+            border-radius: 4px;
+        }
+        .footer {
+            background: #f8f9fa;
+            padding: 20px 30px;
+            text-align: center;
+            font-size: 12px;
+            color: #666;
+            border-top: 1px solid #e9ecef;
+        }
+    </style>
+</head>
+<body>
+    <div class="container">
+        <div class="header">
+            <h1>{{.CompanyName}}</h1>
+            <p>アカウントが作成されました</p>
+        </div>
+
+        <div class="content">
+            <p>{{.UserName}} 様（学籍番号: {{.StudentID}}）</p>
+            <p>{{.CompanyName}}のアカウントが管理者によって作成されました。<br>
+               下のボタンからパスワードを設定して、利用を開始してください。</p>
+
+            <p style="text-align: center; margin: 30px 0;">
+                <a class="button" href="{{.SetupURL}}">パスワードを設定する</a>
+            </p>
+
+            <div class="expiry-info">
+                <strong>⏰ 有効期限:</strong> このリンクは {{.ExpiryDays}} 日間有効です。
+            </div>
+
+            <p style="font-size: 14px; color: #666;">
+                ボタンが押せない場合は、次のURLをブラウザに貼り付けてください。<br>
+                {{.SetupURL}}
+            </p>
+        </div>
+
+        <div class="footer">
+            <p>このメールは {{.CompanyName}} から自動送信されています。</p>
+            <p>心当たりがない場合は、このメールを破棄してください。</p>
+        </div>
+    </div>
+</body>
+</html>
+`
+}
+
+// SendInvitationEmail は一括登録されたユーザーにパスワード設定リンクを送信する
+func SendInvitationEmail(to, userName, studentID, setupURL string, expiryDays int) error {
+	data := InvitationEmailData{
+		UserName:    userName,
+		StudentID:   studentID,
+		SetupURL:    setupURL,
+		ExpiryDays:  expiryDays,
+		CompanyName: "Juice Academy",
+	}
+
+	tmpl, err := template.New("invitation").Parse(getInvitationEmailTemplate())
+	if err != nil {
+		return fmt.Errorf("テンプレート解析エラー: %v", err)
+	}
+
+	var body bytes.Buffer
+	if err := tmpl.Execute(&body, data); err != nil {
+		return fmt.Errorf("テンプレート実行エラー: %v", err)
+	}
+
+	return sendEmail(to, "【Juice Academy】アカウント作成のご案内", body.String())
+}
+
+// AnnouncementDigestItem はお知らせダイジェストに載せる1件分の内容
+type AnnouncementDigestItem struct {
+	Title     string
+	Category  string
+	Important bool
+	Excerpt   string
+	URL       string
+}
+
+// AnnouncementDigestEmailData はお知らせダイジェストメールのテンプレート用データ
+type AnnouncementDigestEmailData struct {
+	UserName         string
+	Items            []AnnouncementDigestItem
+	AnnouncementsURL string
+	UnsubscribeURL   string
+	CompanyName      string
+}
+
+// getAnnouncementDigestEmailTemplate はお知らせダイジェストメール用のHTMLテンプレートを返す
+func getAnnouncementDigestEmailTemplate() string {
+	return `
+<!DOCTYPE html>
+<html lang="ja">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>新しいお知らせ - {{.CompanyName}}</title>
+    <style>
+        body {
+            font-family: 'Helvetica Neue', Arial, sans-serif;
+            line-height: 1.6;
+            color: #333;
+            background-color: #f8f9fa;
+            margin: 0;
+            padding: 20px;
+        }
+        .container {
+            max-width: 600px;
+            margin: 0 auto;
+            background: white;
+            border-radius: 12px;
+            box-shadow: 0 4px 6px rgba(0, 0, 0, 0.1);
+            overflow: hidden;
+        }
+        .header {
+            background: linear-gradient(135deg, #ff6b35, #f7931e);
+            color: white;
+            padding: 30px;
+            text-align: center;
+        }
+        .content {
+            padding: 30px;
+        }
+        .item {
+            border-bottom: 1px solid #e9ecef;
+            padding: 16px 0;
+        }
+        .item-title {
+            font-size: 17px;
+            font-weight: bold;
+            color: #2c3e50;
+            text-decoration: none;
+        }
+        .badge {
+            display: inline-block;
+            background: #dc3545;
+            color: white;
+            font-size: 12px;
+            padding: 2px 8px;
+            border-radius: 4px;
+            margin-right: 6px;
+        }
+        .excerpt {
+            font-size: 14px;
+            color: #555;
+            margin: 6px 0 0;
+        }
+        .footer {
+            background: #f8f9fa;
+            padding: 20px 30px;
+            text-align: center;
+            font-size: 12px;
+            color: #666;
+            border-top: 1px solid #e9ecef;
+        }
+    </style>
+</head>
+<body>
+    <div class="container">
+        <div class="header">
+            <h1>{{.CompanyName}}</h1>
+            <p>新しいお知らせが {{len .Items}} 件あります</p>
+        </div>
+
+        <div class="content">
+            <p>{{.UserName}} 様</p>
+            {{range .Items}}
+            <div class="item">
+                {{if .Important}}<span class="badge">重要</span>{{end}}
+                <a class="item-title" href="{{.URL}}">{{.Title}}</a>
+                {{if .Excerpt}}<p class="excerpt">{{.Excerpt}}</p>{{end}}
+            </div>
+            {{end}}
+            <p style="text-align: center; margin-top: 24px;">
+                <a href="{{.AnnouncementsURL}}">お知らせ一覧を見る</a>
+            </p>
+        </div>
+
+        <div class="footer">
+            <p>このメールはお知らせのメール通知を希望された方に {{.CompanyName}} から送信しています。</p>
+            <p><a href="{{.UnsubscribeURL}}">メール通知の配信を停止する</a></p>
+        </div>
+    </div>
+</body>
+</html>
+`
+}
+
+// renderAnnouncementDigestEmail はお知らせダイジェストメールの本文を生成する
+func renderAnnouncementDigestEmail(data AnnouncementDigestEmailData) (string, error) {
+	tmpl, err := template.New("announcement_digest").Parse(getAnnouncementDigestEmailTemplate())
+	if err != nil {
+		return "", fmt.Errorf("テンプレート解析エラー: %v", err)
+	}
+
+	var body bytes.Buffer
+	if err := tmpl.Execute(&body, data); err != nil {
+		return "", fmt.Errorf("テンプレート実行エラー: %v", err)
+	}
+	return body.String(), nil
+}
+
+// SendAnnouncementDigestEmail は新着のお知らせをまとめたメールを送信する
+// oneClickUnsubscribeURL は RFC 8058 のワンクリック配信停止（POST）を受け付けるAPIのURL
+func SendAnnouncementDigestEmail(to string, data AnnouncementDigestEmailData, oneClickUnsubscribeURL string) error {
+	if data.CompanyName == "" {
+		data.CompanyName = "Juice Academy"
+	}
+	body, err := renderAnnouncementDigestEmail(data)
+	if err != nil {
+		return err
+	}
+
+	subject := fmt.Sprintf("【Juice Academy】新しいお知らせ（%d件）", len(data.Items))
+	if len(data.Items) == 1 {
+		subject = "【Juice Academy】" + data.Items[0].Title
+	}
+
+	return sendEmailWithHeaders(to, subject, body, map[string]string{
+		"List-Unsubscribe":      "<" + oneClickUnsubscribeURL + ">",
+		"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+	})
+}
+
+// RefundEmailData は返金のお知らせメールのテンプレート用データ
+type RefundEmailData struct {
+	UserName      string
+	InvoiceNumber string
+	// Amount は今回の返金額、AmountRefunded は同じ請求に対する返金の累計（表示用に整形済み）
+	Amount         string
+	AmountRefunded string
+	Full           bool
+	CompanyName    string
+}
+
+// getRefundEmailTemplate は返金のお知らせメール用のHTMLテンプレートを返す
+func getRefundEmailTemplate() string {
+	return `
+<!DOCTYPE html>
+<html lang="ja">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>返金のお知らせ - {{.CompanyName}}</title>
+    <style>
+        body {
+            font-family: 'Helvetica Neue', Arial, sans-serif;
+            line-height: 1.6;
+            color: #333;
+            background-color: #f8f9fa;
+            margin: 0;
+            padding: 20px;
+        }
+        .container {
+            max-width: 600px;
+            margin: 0 auto;
+            background: white;
+            border-radius: 12px;
+            box-shadow: 0 4px 6px rgba(0, 0, 0, 0.1);
+            overflow: hidden;
+        }
+        .header {
+            background: linear-gradient(135deg, #ff6b35, #f7931e);
+            color: white;
+            padding: 30px;
+            text-align: center;
+        }
+        .content {
+            padding: 30px;
+        }
+        .amount {
+            font-size: 28px;
+            font-weight: bold;
+            color: #2c3e50;
+            text-align: center;
+            margin: 20px 0;
+        }
+        table {
+            width: 100%;
+            border-collapse: collapse;
+            font-size: 14px;
+        }
+        td {
+            border-bottom: 1px solid #e9ecef;
+            padding: 8px 0;
+        }
+        .footer {
+            background: #f8f9fa;
+            padding: 20px 30px;
+            text-align: center;
+            font-size: 12px;
+            color: #666;
+            border-top: 1px solid #e9ecef;
+        }
+    </style>
+</head>
+<body>
+    <div class="container">
+        <div class="header">
+            <h1>{{.CompanyName}}</h1>
+            <p>返金のお知らせ</p>
+        </div>
+
+        <div class="content">
+            <p>{{.UserName}} 様</p>
+            <p>以下のお支払いについて{{if .Full}}全額を{{else}}一部を{{end}}返金いたしました。</p>
+            <div class="amount">{{.Amount}}</div>
+            <table>
+                {{if .InvoiceNumber}}<tr><td>請求書番号</td><td>{{.InvoiceNumber}}</td></tr>{{end}}
+                <tr><td>返金額の合計</td><td>{{.AmountRefunded}}</td></tr>
+            </table>
+            <p>ご登録のカードへの返金は、カード会社の処理により明細に反映されるまで数日から数週間かかる場合があります。</p>
+            <p>返金の内容はマイページの支払い履歴からもご確認いただけます。</p>
+        </div>
+
+        <div class="footer">
+            <p>このメールは {{.CompanyName}} から自動送信されています。</p>
+            <p>お心当たりのない場合は、お手数ですがお問い合わせください。</p>
+        </div>
+    </div>
+</body>
+</html>
+`
+}
+
+// renderRefundEmail は返金のお知らせメールの本文を生成する
+func renderRefundEmail(data RefundEmailData) (string, error) {
+	tmpl, err := template.New("refund").Parse(getRefundEmailTemplate())
+	if err != nil {
+		return "", fmt.Errorf("テンプレート解析エラー: %v", err)
+	}
+
+	var body bytes.Buffer
+	if err := tmpl.Execute(&body, data); err != nil {
+		return "", fmt.Errorf("テンプレート実行エラー: %v", err)
+	}
+	return body.String(), nil
+}
+
+// SendRefundEmail は返金のお知らせメールを送信する
+func SendRefundEmail(to string, data RefundEmailData) error {
+	if data.CompanyName == "" {
+		data.CompanyName = "Juice Academy"
+	}
+	body, err := renderRefundEmail(data)
+	if err != nil {
+		return err
+	}
+	return sendEmail(to, "【Juice Academy】返金のお知らせ", body)
+}
+
+// DisputeAlertEmailData は異議申し立て（チャージバック）の管理者向け通知メール用のデータ構造体
+type DisputeAlertEmailData struct {
+	DisputeAlert
+	Closed      bool
+	StatusLabel string
+	// AmountText / DueByText は表示用に整形した金額と証拠の提出期限
+	AmountText  string
+	DueByText   string
+	CompanyName string
+}
+
+// getDisputeAlertEmailTemplate は異議申し立ての通知メール用のHTMLテンプレートを返す
+func getDisputeAlertEmailTemplate() string {
+	return `
+<!DOCTYPE html>
+<html lang="ja">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>チャージバックのお知らせ - {{.CompanyName}}</title>
+    <style>
+        body {
+            font-family: 'Helvetica Neue', Arial, sans-serif;
+            line-height: 1.6;
+            color: #333;
+            background-color: #f8f9fa;
+            margin: 0;
+            padding: 20px;
+        }
+        .container {
+            max-width: 600px;
+            margin: 0 auto;
+            background: white;
+            border-radius: 12px;
+            box-shadow: 0 4px 6px rgba(0, 0, 0, 0.1);
+            overflow: hidden;
+        }
+        .header {
+            background: #c0392b;
+            color: white;
+            padding: 30px;
+            text-align: center;
+        }
+        .content {
+            padding: 30px;
+        }
+        table {
+            width: 100%;
+            border-collapse: collapse;
+            font-size: 14px;
+        }
+        td {
+            border-bottom: 1px solid #e9ecef;
+            padding: 8px 0;
+        }
+        .deadline {
+            color: #c0392b;
+            font-weight: bold;
+        }
+        .footer {
+            background: #f8f9fa;
+            padding: 20px 30px;
+            text-align: center;
+            font-size: 12px;
+            color: #666;
+            border-top: 1px solid #e9ecef;
+        }
+    </style>
+</head>
+<body>
+    <div class="container">
+        <div class="header">
+            <h1>{{.CompanyName}}</h1>
+            <p>{{if .Closed}}チャージバックの結果のお知らせ{{else}}チャージバック（異議申し立て）が発生しました{{end}}</p>
+        </div>
+
+        <div class="content">
+            {{if .Closed}}
+            <p>以下の異議申し立ての結果が確定しました。</p>
+            {{else}}
+            <p>利用者がカード会社に支払いへの異議を申し立てました。期限までに管理画面から証拠を提出してください。</p>
+            {{end}}
+            <table>
+                <tr><td>状態</td><td>{{.StatusLabel}}</td></tr>
+                <tr><td>金額</td><td>{{.AmountText}}</td></tr>
+                <tr><td>理由</td><td>{{.Reason}}</td></tr>
+                {{if .DueByText}}<tr><td>証拠の提出期限</td><td class="deadline">{{.DueByText}}</td></tr>{{end}}
+                {{if .InvoiceNumber}}<tr><td>請求書番号</td><td>{{.InvoiceNumber}}</td></tr>{{end}}
+                {{if .UserName}}<tr><td>利用者</td><td>{{.UserName}}{{if .StudentID}}（{{.StudentID}}）{{end}}</td></tr>{{end}}
+                {{if .UserEmail}}<tr><td>メールアドレス</td><td>{{.UserEmail}}</td></tr>{{end}}
+                <tr><td>Stripe</td><td><a href="{{.DashboardURL}}">{{.DisputeID}}</a></td></tr>
+            </table>
+        </div>
+
+        <div class="footer">
+            <p>このメールは {{.CompanyName}} の決済管理者向けに自動送信されています。</p>
+        </div>
+    </div>
+</body>
+</html>
+`
+}
+
+// renderDisputeAlertEmail は異議申し立ての通知メールの本文を生成する
+func renderDisputeAlertEmail(data DisputeAlertEmailData) (string, error) {
+	tmpl, err := template.New("dispute_alert").Parse(getDisputeAlertEmailTemplate())
+	if err != nil {
+		return "", fmt.Errorf("テンプレート解析エラー: %v", err)
+	}
+
+	var body bytes.Buffer
+	if err := tmpl.Execute(&body, data); err != nil {
+		return "", fmt.Errorf("テンプレート実行エラー: %v", err)
+	}
+	return body.String(), nil
+}
+
+// SendDisputeAlertEmail は異議申し立ての発生・結果を管理者にメールで知らせる
+func SendDisputeAlertEmail(to string, alert DisputeAlert) error {
+	data := DisputeAlertEmailData{
+		DisputeAlert: alert,
+		Closed:       alert.Event == "dispute.closed",
+		StatusLabel:  DisputeStatusLabel(alert.Status),
+		AmountText:   utils.FormatAmount(alert.Amount, alert.Currency),
+		CompanyName:  "Juice Academy",
+	}
+	if alert.EvidenceDueBy != nil && !data.Closed {
+		data.DueByText = alert.EvidenceDueBy.In(disputeAlertLocation).Format("2006年1月2日 15:04")
+	}
+	body, err := renderDisputeAlertEmail(data)
+	if err != nil {
+		return err
+	}
+	subject := "【Juice Academy】チャージバックが発生しました"
+	if data.Closed {
+		subject = "【Juice Academy】チャージバックの結果のお知らせ"
+	}
+	return sendEmail(to, subject, body)
+}
@@ -0,0 +1,95 @@
+package services
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestBuildEmailMessage は追加ヘッダーと件名の改行除去をテストする
+func TestBuildEmailMessage(t *testing.T) {
+	config := EmailConfig{FromEmail: "noreply@example.com", FromName: "Juice Academy"}
+	message := string(buildEmailMessage(config, "student@example.com", "件名\r\nBcc: attacker@example.com", "<p>本文</p>", map[string]string{
+		"List-Unsubscribe":      "<https://example.com/api/announcements/unsubscribe?token=abc>",
+		"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+		"X-Injected":            "a\r\nBcc: attacker@example.com",
+	}))
+
+	headers, body, ok := strings.Cut(message, "\r\n\r\n")
+	require.True(t, ok)
+	assert.Equal(t, "<p>本文</p>", body)
+	assert.Contains(t, headers, "Subject: 件名  Bcc: attacker@example.com\r\n")
+	assert.Contains(t, headers, "List-Unsubscribe: <https://example.com/api/announcements/unsubscribe?token=abc>\r\n")
+	assert.Contains(t, headers, "List-Unsubscribe-Post: List-Unsubscribe=One-Click\r\n")
+	assert.NotContains(t, headers, "X-Injected")
+	assert.NotContains(t, headers, "\r\nBcc:")
+}
+
+// TestRenderAnnouncementDigestEmail はダイジェストメールの本文をテストする
+func TestRenderAnnouncementDigestEmail(t *testing.T) {
+	body, err := renderAnnouncementDigestEmail(AnnouncementDigestEmailData{
+		UserName: "やまだ たろう",
+		Items: []AnnouncementDigestItem{
+			{Title: "<script>alert(1)</script>", Important: true, URL: "https://academy.example.com/announcements/1"},
+			{Title: "時間割の変更", Excerpt: "2限と3限を入れ替えます", URL: "https://academy.example.com/announcements/2"},
+		},
+		AnnouncementsURL: "https://academy.example.com/announcements",
+		UnsubscribeURL:   "https://academy.example.com/announcements/unsubscribe?token=abc",
+		CompanyName:      "Juice Academy",
+	})
+	require.NoError(t, err)
+
+	assert.Contains(t, body, "新しいお知らせが 2 件あります")
+	assert.Contains(t, body, "&lt;script&gt;alert(1)&lt;/script&gt;", "タイトルはエスケープすること")
+	assert.NotContains(t, body, "<script>")
+	assert.Contains(t, body, "2限と3限を入れ替えます")
+	assert.Contains(t, body, `href="https://academy.example.com/announcements/unsubscribe?token=abc"`)
+}
+
+// TestRenderRefundEmail は返金のお知らせメールの本文をテストする
+func TestRenderRefundEmail(t *testing.T) {
+	body, err := renderRefundEmail(RefundEmailData{
+		UserName:       "やまだ たろう",
+		InvoiceNumber:  "FAKE-0001",
+		Amount:         "￥300",
+		AmountRefunded: "￥300",
+		CompanyName:    "Juice Academy",
+	})
+	require.NoError(t, err)
+	assert.Contains(t, body, "やまだ たろう 様")
+	assert.Contains(t, body, "一部を返金いたしました")
+	assert.Contains(t, body, "FAKE-0001")
+
+	body, err = renderRefundEmail(RefundEmailData{Amount: "￥980", AmountRefunded: "￥980", Full: true, CompanyName: "Juice Academy"})
+	require.NoError(t, err)
+	assert.Contains(t, body, "全額を返金いたしました")
+	assert.NotContains(t, body, "請求書番号")
+}
+
+func TestRenderDisputeAlertEmail(t *testing.T) {
+	body, err := renderDisputeAlertEmail(DisputeAlertEmailData{
+		DisputeAlert: DisputeAlert{
+			DisputeID:    "dp_1",
+			Reason:       "fraudulent",
+			UserName:     "やまだ たろう",
+			StudentID:    "S0001",
+			DashboardURL: "https://dashboard.stripe.com/disputes/dp_1",
+		},
+		StatusLabel: "回答待ち",
+		AmountText:  "￥980",
+		DueByText:   "2026年4月9日 00:00",
+		CompanyName: "Juice Academy",
+	})
+	require.NoError(t, err)
+	assert.Contains(t, body, "チャージバック（異議申し立て）が発生しました")
+	assert.Contains(t, body, "2026年4月9日 00:00")
+	assert.Contains(t, body, "やまだ たろう（S0001）")
+	assert.Contains(t, body, "https://dashboard.stripe.com/disputes/dp_1")
+
+	body, err = renderDisputeAlertEmail(DisputeAlertEmailData{Closed: true, StatusLabel: "勝訴（売上を回復）", CompanyName: "Juice Academy"})
+	require.NoError(t, err)
+	assert.Contains(t, body, "結果が確定しました")
+	assert.NotContains(t, body, "証拠の提出期限")
+}
@@ -0,0 +1,69 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// EntitlementSnapshotTTL は利用権スナップショットをキャッシュする期間
+// サブスクリプションの変更時（Webhook・各操作）に明示的に無効化するため、TTLは取りこぼし時の上限として機能する
+const EntitlementSnapshotTTL = 5 * time.Minute
+
+func entitlementSnapshotKey(userID string) string {
+	return fmt.Sprintf("entitlements:%s", userID)
+}
+
+// GetEntitlementSnapshot はキャッシュ済みの利用権スナップショットを dest にデコードする
+// キャッシュが存在しない場合は found=false を返す
+func GetEntitlementSnapshot(ctx context.Context, userID string, dest interface{}) (bool, error) {
+	if RedisClient == nil {
+		return false, fmt.Errorf("Redisクライアントが初期化されていません")
+	}
+
+	raw, err := RedisClient.Get(ctx, entitlementSnapshotKey(userID)).Bytes()
+	if err == redis.Nil {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("利用権スナップショットの取得に失敗しました: %v", err)
+	}
+
+	if err := json.Unmarshal(raw, dest); err != nil {
+		return false, fmt.Errorf("利用権スナップショットのデコードに失敗しました: %v", err)
+	}
+	return true, nil
+}
+
+// SetEntitlementSnapshot は利用権スナップショットをキャッシュに保存する
+func SetEntitlementSnapshot(ctx context.Context, userID string, snapshot interface{}) error {
+	if RedisClient == nil {
+		return fmt.Errorf("Redisクライアントが初期化されていません")
+	}
+
+	raw, err := json.Marshal(snapshot)
+	if err != nil {
+		return fmt.Errorf("利用権スナップショットのエンコードに失敗しました: %v", err)
+	}
+
+	if err := RedisClient.Set(ctx, entitlementSnapshotKey(userID), raw, EntitlementSnapshotTTL).Err(); err != nil {
+		return fmt.Errorf("利用権スナップショットの保存に失敗しました: %v", err)
+	}
+	return nil
+}
+
+// InvalidateEntitlementSnapshot はサブスクリプションの状態が変わったときにキャッシュを破棄する
+// 次のリクエストでデータベースから再計算されるため、契約・休止・解約などが即座に反映される
+func InvalidateEntitlementSnapshot(ctx context.Context, userID string) error {
+	if RedisClient == nil {
+		return fmt.Errorf("Redisクライアントが初期化されていません")
+	}
+
+	if err := RedisClient.Del(ctx, entitlementSnapshotKey(userID)).Err(); err != nil {
+		return fmt.Errorf("利用権スナップショットの無効化に失敗しました: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,217 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"juice_academy_backend/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// リアルタイム配信するイベントの種類
+const (
+	EventAnnouncementPublished = "announcement.published"
+	EventAnnouncementUpdated   = "announcement.updated"
+	EventAnnouncementDeleted   = "announcement.deleted"
+	EventSubscriptionUpdated   = "subscription.updated"
+)
+
+// eventChannel はイベントを全サーバーに配るRedisのチャンネル
+const eventChannel = "juice_academy:events"
+
+// eventSubscriberBuffer は接続ごとに保持する未送信イベントの上限
+// 送信が追いつかない接続は切断し、クライアントの再接続に任せる
+const eventSubscriberBuffer = 32
+
+// StreamEvent はイベントストリームで配信するイベント
+type StreamEvent struct {
+	Type string `json:"type"`
+	// UserID は特定のユーザーにのみ配信する場合の宛先（空の場合は全員が対象）
+	UserID string `json:"user_id,omitempty"`
+	// Audience はお知らせの配信対象（受信したサーバーが接続ごとに判定し、クライアントには送らない）
+	Audience json.RawMessage `json:"audience,omitempty"`
+	Data     json.RawMessage `json:"data"`
+}
+
+// NewStreamEvent はデータをJSONに変換してイベントを作成する
+func NewStreamEvent(eventType, userID string, data interface{}) (StreamEvent, error) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return StreamEvent{}, err
+	}
+	return StreamEvent{Type: eventType, UserID: userID, Data: raw}, nil
+}
+
+// EventSubscriber はイベントストリームの1接続分の購読
+type EventSubscriber struct {
+	UserID string
+	events chan StreamEvent
+	// done は購読が終了した（送信が追いつかず切断された場合を含む）ときに閉じられる
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// Events は配信されたイベントを受け取るチャネルを返す
+func (s *EventSubscriber) Events() <-chan StreamEvent {
+	return s.events
+}
+
+// Done は購読が終了したときに閉じられるチャネルを返す
+func (s *EventSubscriber) Done() <-chan struct{} {
+	return s.done
+}
+
+func (s *EventSubscriber) close() {
+	s.closeOnce.Do(func() { close(s.done) })
+}
+
+// EventHub はこのサーバーに接続しているクライアントにイベントを配る
+// Redis から受け取ったイベントを宛先のユーザーの接続にのみ渡す
+type EventHub struct {
+	mu          sync.RWMutex
+	subscribers map[*EventSubscriber]struct{}
+}
+
+// NewEventHub は空の EventHub を作成する
+func NewEventHub() *EventHub {
+	return &EventHub{subscribers: make(map[*EventSubscriber]struct{})}
+}
+
+// DefaultEventHub はサーバー全体で共有する EventHub
+var DefaultEventHub = NewEventHub()
+
+// Subscribe はユーザーの接続を登録する（終了時は Unsubscribe を呼ぶこと）
+func (h *EventHub) Subscribe(userID string) *EventSubscriber {
+	subscriber := &EventSubscriber{
+		UserID: userID,
+		events: make(chan StreamEvent, eventSubscriberBuffer),
+		done:   make(chan struct{}),
+	}
+	h.mu.Lock()
+	h.subscribers[subscriber] = struct{}{}
+	h.mu.Unlock()
+	return subscriber
+}
+
+// Unsubscribe は接続の登録を解除する
+func (h *EventHub) Unsubscribe(subscriber *EventSubscriber) {
+	h.mu.Lock()
+	delete(h.subscribers, subscriber)
+	h.mu.Unlock()
+	subscriber.close()
+}
+
+// Count は接続数を返す
+func (h *EventHub) Count() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.subscribers)
+}
+
+// Dispatch はこのサーバーの接続にイベントを配る
+func (h *EventHub) Dispatch(event StreamEvent) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for subscriber := range h.subscribers {
+		if event.UserID != "" && event.UserID != subscriber.UserID {
+			continue
+		}
+		select {
+		case subscriber.events <- event:
+		default:
+			// 取りこぼしたまま接続を続けると状態がずれるため、切断して再取得させる
+			subscriber.close()
+		}
+	}
+}
+
+// PublishEvent はイベントを全サーバーに配信する
+// Redis が利用できない場合はこのサーバーの接続にのみ配る
+func PublishEvent(ctx context.Context, event StreamEvent) error {
+	if RedisClient == nil {
+		DefaultEventHub.Dispatch(event)
+		return nil
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return RedisClient.Publish(ctx, eventChannel, payload).Err()
+}
+
+// StartEventHub は Redis のイベントチャンネルを購読し、DefaultEventHub に配る
+// 接続が切れた場合は go-redis が自動的に再購読する
+func StartEventHub(ctx context.Context) error {
+	if RedisClient == nil {
+		return fmt.Errorf("Redisクライアントが初期化されていません")
+	}
+
+	pubsub := RedisClient.Subscribe(ctx, eventChannel)
+	// 購読の確立を待ってから戻る（起動直後のイベントを取りこぼさないため）
+	if _, err := pubsub.Receive(ctx); err != nil {
+		_ = pubsub.Close()
+		return fmt.Errorf("イベントチャンネルの購読に失敗しました: %v", err)
+	}
+
+	go func() {
+		defer pubsub.Close()
+		for message := range pubsub.Channel() {
+			var event StreamEvent
+			if err := json.Unmarshal([]byte(message.Payload), &event); err != nil {
+				utils.LogError("EventHub", err, "invalid event payload")
+				continue
+			}
+			DefaultEventHub.Dispatch(event)
+		}
+	}()
+	return nil
+}
+
+// SubscriptionStatusEvent はユーザーに配信するサブスクリプションの状態
+type SubscriptionStatusEvent struct {
+	Status            string     `json:"status" bson:"status"`
+	CurrentPeriodEnd  *time.Time `json:"current_period_end,omitempty" bson:"current_period_end,omitempty"`
+	CancelAtPeriodEnd bool       `json:"cancel_at_period_end" bson:"cancel_at_period_end"`
+	PausedAt          *time.Time `json:"paused_at,omitempty" bson:"paused_at,omitempty"`
+}
+
+// PublishSubscriptionChange は更新後のサブスクリプションを読み込み、所有するユーザーに配信する
+// あわせてユーザーの利用権キャッシュを破棄し、次のリクエストから新しい状態で判定させる
+// 配信に失敗してもWebhookの処理は成功として扱う（クライアントは再接続時に状態を再取得する）
+func PublishSubscriptionChange(ctx context.Context, collection *mongo.Collection, filter bson.M) {
+	if collection == nil {
+		return
+	}
+	var sub struct {
+		UserID                  primitive.ObjectID `bson:"user_id"`
+		SubscriptionStatusEvent `bson:",inline"`
+	}
+	err := collection.FindOne(ctx, filter, options.FindOne().SetProjection(bson.M{
+		"user_id": 1, "status": 1, "current_period_end": 1, "cancel_at_period_end": 1, "paused_at": 1,
+	})).Decode(&sub)
+	if err != nil {
+		if err != mongo.ErrNoDocuments {
+			utils.LogErrorCtx(ctx, "EventHub", err, "Failed to load subscription for event")
+		}
+		return
+	}
+
+	if err := InvalidateEntitlementSnapshot(ctx, sub.UserID.Hex()); err != nil {
+		utils.LogWarningCtx(ctx, "EventHub", "Failed to invalidate entitlements: "+err.Error())
+	}
+
+	event, err := NewStreamEvent(EventSubscriptionUpdated, sub.UserID.Hex(), sub.SubscriptionStatusEvent)
+	if err == nil {
+		err = PublishEvent(ctx, event)
+	}
+	if err != nil {
+		utils.LogErrorCtx(ctx, "EventHub", err, "Failed to publish subscription event")
+	}
+}
@@ -0,0 +1,73 @@
+package services
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEventHubDispatchFiltersByUser(t *testing.T) {
+	hub := NewEventHub()
+	alice := hub.Subscribe("alice")
+	bob := hub.Subscribe("bob")
+	defer hub.Unsubscribe(alice)
+	defer hub.Unsubscribe(bob)
+
+	broadcast, err := NewStreamEvent(EventAnnouncementPublished, "", map[string]string{"id": "a1"})
+	require.NoError(t, err)
+	private, err := NewStreamEvent(EventSubscriptionUpdated, "alice", SubscriptionStatusEvent{Status: "active"})
+	require.NoError(t, err)
+
+	hub.Dispatch(broadcast)
+	hub.Dispatch(private)
+
+	assert.Len(t, alice.Events(), 2)
+	assert.Len(t, bob.Events(), 1)
+	got := <-bob.Events()
+	assert.Equal(t, EventAnnouncementPublished, got.Type)
+	assert.JSONEq(t, `{"id":"a1"}`, string(got.Data))
+}
+
+func TestEventHubClosesSlowSubscriber(t *testing.T) {
+	hub := NewEventHub()
+	slow := hub.Subscribe("slow")
+	defer hub.Unsubscribe(slow)
+
+	event := StreamEvent{Type: EventAnnouncementUpdated, Data: []byte(`{}`)}
+	for i := 0; i < eventSubscriberBuffer+1; i++ {
+		hub.Dispatch(event)
+	}
+
+	select {
+	case <-slow.Done():
+	default:
+		t.Fatal("送信が追いつかない購読は終了するべき")
+	}
+}
+
+func TestEventHubUnsubscribe(t *testing.T) {
+	hub := NewEventHub()
+	subscriber := hub.Subscribe("user")
+	assert.Equal(t, 1, hub.Count())
+
+	hub.Unsubscribe(subscriber)
+	assert.Equal(t, 0, hub.Count())
+	// 二重に解除しても panic しない
+	hub.Unsubscribe(subscriber)
+	<-subscriber.Done()
+}
+
+func TestPublishEventWithoutRedisDispatchesLocally(t *testing.T) {
+	original := RedisClient
+	RedisClient = nil
+	defer func() { RedisClient = original }()
+
+	subscriber := DefaultEventHub.Subscribe("user")
+	defer DefaultEventHub.Unsubscribe(subscriber)
+
+	require.NoError(t, PublishEvent(context.Background(), StreamEvent{Type: EventAnnouncementDeleted, Data: []byte(`{"id":"x"}`)}))
+	got := <-subscriber.Events()
+	assert.Equal(t, EventAnnouncementDeleted, got.Type)
+}
@@ -0,0 +1,411 @@
+package services
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"juice_academy_backend/utils"
+
+	"github.com/stripe/stripe-go/v81"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// InvoiceRecord は invoices コレクションのドキュメント（Stripe の請求書の写し）
+// 支払い履歴は Stripe を呼び出さずにこのコレクションから表示する
+type InvoiceRecord struct {
+	ID                   primitive.ObjectID `bson:"_id,omitempty" json:"-"`
+	StripeInvoiceID      string             `bson:"stripe_invoice_id" json:"id"`
+	StripeCustomerID     string             `bson:"stripe_customer_id" json:"-"`
+	StripeSubscriptionID string             `bson:"stripe_subscription_id,omitempty" json:"-"`
+	// StripePaymentIntentID / StripeChargeID は返金（refund.* / charge.refunded）を請求書に紐付けるために使う
+	StripePaymentIntentID string `bson:"stripe_payment_intent_id,omitempty" json:"-"`
+	StripeChargeID        string `bson:"stripe_charge_id,omitempty" json:"-"`
+	// CustomerName は請求先として Stripe に登録された氏名（領収書の宛名に使う）
+	CustomerName  string `bson:"customer_name,omitempty" json:"-"`
+	Number        string `bson:"number,omitempty" json:"number,omitempty"`
+	Status        string `bson:"status" json:"status"`
+	BillingReason string `bson:"billing_reason,omitempty" json:"billing_reason,omitempty"`
+	Description   string `bson:"description,omitempty" json:"description,omitempty"`
+	Currency      string `bson:"currency" json:"currency"`
+	// 金額は通貨の最小単位（JPY はそのまま円）
+	Subtotal        int64              `bson:"subtotal" json:"subtotal"`
+	Tax             int64              `bson:"tax" json:"tax"`
+	Total           int64              `bson:"total" json:"total"`
+	TaxAmounts      []InvoiceTaxAmount `bson:"tax_amounts,omitempty" json:"tax_amounts,omitempty"`
+	AmountDue       int64              `bson:"amount_due" json:"amount_due"`
+	AmountPaid      int64              `bson:"amount_paid" json:"amount_paid"`
+	AmountRemaining int64              `bson:"amount_remaining" json:"amount_remaining"`
+	// PrePaymentCreditNotesAmount は支払い前のクレジットノートで減額した額、
+	// PostPaymentCreditNotesAmount は支払い後のクレジットノート（返金・残高への充当）の合計
+	PrePaymentCreditNotesAmount  int64 `bson:"pre_payment_credit_notes_amount" json:"pre_payment_credit_notes_amount"`
+	PostPaymentCreditNotesAmount int64 `bson:"post_payment_credit_notes_amount" json:"post_payment_credit_notes_amount"`
+	// AmountRefunded は返金済みの額（charge.refunded で更新する）
+	// Refunds / CreditNotes は refund.* と credit_note.* で更新し、請求書のイベントでは上書きしない
+	AmountRefunded   int64               `bson:"amount_refunded,omitempty" json:"amount_refunded"`
+	Refunds          []InvoiceRefund     `bson:"refunds,omitempty" json:"refunds,omitempty"`
+	CreditNotes      []InvoiceCreditNote `bson:"credit_notes,omitempty" json:"credit_notes,omitempty"`
+	HostedInvoiceURL string              `bson:"hosted_invoice_url,omitempty" json:"hosted_invoice_url,omitempty"`
+	InvoicePDF       string              `bson:"invoice_pdf,omitempty" json:"invoice_pdf,omitempty"`
+	PeriodStart      time.Time           `bson:"period_start" json:"period_start"`
+	PeriodEnd        time.Time           `bson:"period_end" json:"period_end"`
+	PaidAt           *time.Time          `bson:"paid_at,omitempty" json:"paid_at,omitempty"`
+	Lines            []InvoiceLineRecord `bson:"lines" json:"lines"`
+	// CreatedAt は Stripe 上で請求書が作成された日時
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+	// StripeObservedAt は反映済みの Stripe の状態の時刻（Webhook イベントの作成日時、バックフィルでは取得日時）
+	// これより古いイベントが遅れて届いても上書きしない
+	StripeObservedAt time.Time `bson:"stripe_observed_at" json:"-"`
+	UpdatedAt        time.Time `bson:"updated_at" json:"-"`
+}
+
+// InvoiceLineRecord は請求書の明細1件
+type InvoiceLineRecord struct {
+	StripeLineID string    `bson:"stripe_line_id" json:"id"`
+	Description  string    `bson:"description,omitempty" json:"description,omitempty"`
+	PriceID      string    `bson:"price_id,omitempty" json:"price_id,omitempty"`
+	Amount       int64     `bson:"amount" json:"amount"`
+	Quantity     int64     `bson:"quantity" json:"quantity"`
+	Proration    bool      `bson:"proration" json:"proration"`
+	PeriodStart  time.Time `bson:"period_start" json:"period_start"`
+	PeriodEnd    time.Time `bson:"period_end" json:"period_end"`
+	// TaxRate は明細に適用された税率（%）。Stripe で税を計算していない場合は 0
+	TaxRate int `bson:"tax_rate,omitempty" json:"tax_rate,omitempty"`
+}
+
+// InvoiceTaxAmount は請求書の税率ごとの税額
+type InvoiceTaxAmount struct {
+	Rate          int   `bson:"rate" json:"rate"`
+	Inclusive     bool  `bson:"inclusive" json:"inclusive"`
+	TaxableAmount int64 `bson:"taxable_amount" json:"taxable_amount"`
+	Amount        int64 `bson:"amount" json:"amount"`
+}
+
+// 管理画面から返金・クレジットノートを発行する際に Stripe のメタデータに保存するキー
+// Webhook で受け取った場合も理由と担当者を台帳に残せるよう、台帳には Stripe のメタデータから反映する
+const (
+	AdjustmentMetadataReasonCode = "reason_code"
+	AdjustmentMetadataNote       = "note"
+	AdjustmentMetadataCreatedBy  = "created_by"
+)
+
+// InvoiceRefund は請求書の支払いに対する返金1件
+type InvoiceRefund struct {
+	StripeRefundID string `bson:"stripe_refund_id" json:"id"`
+	Amount         int64  `bson:"amount" json:"amount"`
+	Status         string `bson:"status" json:"status"`
+	// ReasonCode / Note / CreatedBy は管理画面から返金した場合のみ（Stripe のダッシュボードからの返金では空）
+	ReasonCode string    `bson:"reason_code,omitempty" json:"reason_code,omitempty"`
+	Note       string    `bson:"note,omitempty" json:"note,omitempty"`
+	CreatedBy  string    `bson:"created_by,omitempty" json:"created_by,omitempty"`
+	CreatedAt  time.Time `bson:"created_at" json:"created_at"`
+}
+
+// InvoiceCreditNote は請求書に発行したクレジットノート1件
+type InvoiceCreditNote struct {
+	StripeCreditNoteID string `bson:"stripe_credit_note_id" json:"id"`
+	Number             string `bson:"number" json:"number"`
+	// Type は pre_payment（支払い前の減額）または post_payment（支払い後の返金・残高への充当）
+	Type   string `bson:"type" json:"type"`
+	Status string `bson:"status" json:"status"`
+	Amount int64  `bson:"amount" json:"amount"`
+	// StripeRefundID はクレジットノートと同時に返金した場合の返金
+	StripeRefundID string     `bson:"stripe_refund_id,omitempty" json:"refund_id,omitempty"`
+	PDF            string     `bson:"pdf,omitempty" json:"pdf,omitempty"`
+	ReasonCode     string     `bson:"reason_code,omitempty" json:"reason_code,omitempty"`
+	Note           string     `bson:"note,omitempty" json:"note,omitempty"`
+	CreatedBy      string     `bson:"created_by,omitempty" json:"created_by,omitempty"`
+	CreatedAt      time.Time  `bson:"created_at" json:"created_at"`
+	VoidedAt       *time.Time `bson:"voided_at,omitempty" json:"voided_at,omitempty"`
+}
+
+// stripeTaxRatePercent は Stripe の税額から税率（%）を求める
+// Webhook の請求書では税率が ID のみで展開されないため、その場合は課税対象額と税額から計算する
+func stripeTaxRatePercent(tax *stripe.InvoiceTotalTaxAmount) int {
+	if tax.TaxRate != nil && tax.TaxRate.Percentage > 0 {
+		return int(math.Round(tax.TaxRate.Percentage))
+	}
+	if tax.TaxableAmount > 0 {
+		return int(math.Round(float64(tax.Amount) * 100 / float64(tax.TaxableAmount)))
+	}
+	return 0
+}
+
+// NewInvoiceRecord は Stripe の請求書から保存用のドキュメントを作成する
+func NewInvoiceRecord(inv *stripe.Invoice, observedAt time.Time) InvoiceRecord {
+	record := InvoiceRecord{
+		StripeInvoiceID:              inv.ID,
+		Number:                       inv.Number,
+		Status:                       string(inv.Status),
+		BillingReason:                string(inv.BillingReason),
+		Description:                  inv.Description,
+		Currency:                     string(inv.Currency),
+		Subtotal:                     inv.Subtotal,
+		Tax:                          inv.Tax,
+		Total:                        inv.Total,
+		AmountDue:                    inv.AmountDue,
+		AmountPaid:                   inv.AmountPaid,
+		AmountRemaining:              inv.AmountRemaining,
+		PrePaymentCreditNotesAmount:  inv.PrePaymentCreditNotesAmount,
+		PostPaymentCreditNotesAmount: inv.PostPaymentCreditNotesAmount,
+		HostedInvoiceURL:             inv.HostedInvoiceURL,
+		InvoicePDF:                   inv.InvoicePDF,
+		PeriodStart:                  time.Unix(inv.PeriodStart, 0),
+		PeriodEnd:                    time.Unix(inv.PeriodEnd, 0),
+		Lines:                        []InvoiceLineRecord{},
+		CreatedAt:                    time.Unix(inv.Created, 0),
+		StripeObservedAt:             observedAt,
+		UpdatedAt:                    time.Now(),
+	}
+	if inv.Customer != nil {
+		record.StripeCustomerID = inv.Customer.ID
+	}
+	record.CustomerName = inv.CustomerName
+	for _, tax := range inv.TotalTaxAmounts {
+		record.TaxAmounts = append(record.TaxAmounts, InvoiceTaxAmount{
+			Rate:          stripeTaxRatePercent(tax),
+			Inclusive:     tax.Inclusive,
+			TaxableAmount: tax.TaxableAmount,
+			Amount:        tax.Amount,
+		})
+	}
+	if inv.Subscription != nil {
+		record.StripeSubscriptionID = inv.Subscription.ID
+	}
+	if inv.PaymentIntent != nil {
+		record.StripePaymentIntentID = inv.PaymentIntent.ID
+	}
+	if inv.Charge != nil {
+		record.StripeChargeID = inv.Charge.ID
+	}
+	if inv.StatusTransitions != nil && inv.StatusTransitions.PaidAt > 0 {
+		paidAt := time.Unix(inv.StatusTransitions.PaidAt, 0)
+		record.PaidAt = &paidAt
+	}
+	if inv.Lines != nil {
+		for _, line := range inv.Lines.Data {
+			item := InvoiceLineRecord{
+				StripeLineID: line.ID,
+				Description:  line.Description,
+				Amount:       line.Amount,
+				Quantity:     line.Quantity,
+				Proration:    line.Proration,
+			}
+			if line.Price != nil {
+				item.PriceID = line.Price.ID
+			}
+			if line.Period != nil {
+				item.PeriodStart = time.Unix(line.Period.Start, 0)
+				item.PeriodEnd = time.Unix(line.Period.End, 0)
+			}
+			if len(line.TaxAmounts) > 0 {
+				item.TaxRate = stripeTaxRatePercent(line.TaxAmounts[0])
+			}
+			record.Lines = append(record.Lines, item)
+		}
+	}
+	return record
+}
+
+// ApplyStripeInvoice は Stripe の請求書を invoices コレクションに保存する（invoice.* の Webhook とバックフィルで使う）
+// observedAt より新しい状態を保存済みの場合は何もしない（Webhook の到着順は保証されないため）
+// 返金とクレジットノートは請求書のイベントに含まれないため、保存済みの内容を残して請求書の項目のみを更新する
+func ApplyStripeInvoice(ctx context.Context, collection *mongo.Collection, inv *stripe.Invoice, observedAt time.Time) error {
+	// invoice.upcoming の請求書は未確定で ID を持たない
+	if inv.ID == "" {
+		return nil
+	}
+
+	record := NewInvoiceRecord(inv, observedAt)
+	filter := bson.M{
+		"stripe_invoice_id": inv.ID,
+		"$or": []bson.M{
+			{"stripe_observed_at": bson.M{"$lte": observedAt}},
+			{"stripe_observed_at": bson.M{"$exists": false}},
+		},
+	}
+	_, err := collection.UpdateOne(ctx, filter, bson.M{"$set": record}, options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		// 新しい状態が保存済みのため filter に一致せず、upsert が一意制約に当たった
+		return nil
+	}
+	return err
+}
+
+// RefundNotice は返金を利用者に知らせるための内容
+type RefundNotice struct {
+	StripeCustomerID string
+	StripeInvoiceID  string
+	InvoiceNumber    string
+	Currency         string
+	// Amount は今回の返金額、AmountRefunded は請求書の支払いに対する返金の累計
+	Amount         int64
+	AmountRefunded int64
+	AmountPaid     int64
+}
+
+// Full は支払いの全額を返金済みかどうか
+func (n RefundNotice) Full() bool {
+	return n.AmountRefunded >= n.AmountPaid
+}
+
+// NewInvoiceRefund は Stripe の返金から台帳の返金を作成する
+func NewInvoiceRefund(refund *stripe.Refund) InvoiceRefund {
+	return InvoiceRefund{
+		StripeRefundID: refund.ID,
+		Amount:         refund.Amount,
+		Status:         string(refund.Status),
+		ReasonCode:     refund.Metadata[AdjustmentMetadataReasonCode],
+		Note:           refund.Metadata[AdjustmentMetadataNote],
+		CreatedBy:      refund.Metadata[AdjustmentMetadataCreatedBy],
+		CreatedAt:      time.Unix(refund.Created, 0),
+	}
+}
+
+// NewInvoiceCreditNote は Stripe のクレジットノートから台帳のクレジットノートを作成する
+func NewInvoiceCreditNote(cn *stripe.CreditNote) InvoiceCreditNote {
+	record := InvoiceCreditNote{
+		StripeCreditNoteID: cn.ID,
+		Number:             cn.Number,
+		Type:               string(cn.Type),
+		Status:             string(cn.Status),
+		Amount:             cn.Amount,
+		PDF:                cn.PDF,
+		ReasonCode:         cn.Metadata[AdjustmentMetadataReasonCode],
+		Note:               cn.Metadata[AdjustmentMetadataNote],
+		CreatedBy:          cn.Metadata[AdjustmentMetadataCreatedBy],
+		CreatedAt:          time.Unix(cn.Created, 0),
+	}
+	if cn.Refund != nil {
+		record.StripeRefundID = cn.Refund.ID
+	}
+	if cn.VoidedAt > 0 {
+		voidedAt := time.Unix(cn.VoidedAt, 0)
+		record.VoidedAt = &voidedAt
+	}
+	return record
+}
+
+// ApplyStripeRefund は返金を支払い元の請求書に保存する（refund.* の Webhook と管理画面からの返金で使う）
+// 請求書が台帳にない場合（請求書を経由しない支払い）は何もしない
+func ApplyStripeRefund(ctx context.Context, collection *mongo.Collection, refund *stripe.Refund) error {
+	var filter bson.M
+	switch {
+	case refund.PaymentIntent != nil && refund.PaymentIntent.ID != "":
+		filter = bson.M{"stripe_payment_intent_id": refund.PaymentIntent.ID}
+	case refund.Charge != nil && refund.Charge.ID != "":
+		filter = bson.M{"stripe_charge_id": refund.Charge.ID}
+	default:
+		return nil
+	}
+	return upsertInvoiceAdjustment(ctx, collection, filter, "refunds", "stripe_refund_id", refund.ID, NewInvoiceRefund(refund))
+}
+
+// ApplyStripeCreditNote はクレジットノートを請求書に保存する（credit_note.* の Webhook と管理画面からの発行で使う）
+func ApplyStripeCreditNote(ctx context.Context, collection *mongo.Collection, cn *stripe.CreditNote) error {
+	if cn.Invoice == nil || cn.Invoice.ID == "" {
+		return nil
+	}
+	filter := bson.M{"stripe_invoice_id": cn.Invoice.ID}
+	return upsertInvoiceAdjustment(ctx, collection, filter, "credit_notes", "stripe_credit_note_id", cn.ID, NewInvoiceCreditNote(cn))
+}
+
+// upsertInvoiceAdjustment は請求書の配列（refunds / credit_notes）の要素を ID で置き換え、なければ追加する
+// 同じ返金が Webhook と管理画面の両方から保存されても1件にまとめる
+func upsertInvoiceAdjustment(ctx context.Context, collection *mongo.Collection, filter bson.M, field, idField, id string, value interface{}) error {
+	match := bson.M{field + "." + idField: id}
+	for k, v := range filter {
+		match[k] = v
+	}
+	result, err := collection.UpdateOne(ctx, match, bson.M{"$set": bson.M{field + ".$": value, "updated_at": time.Now()}})
+	if err != nil || result.MatchedCount > 0 {
+		return err
+	}
+
+	missing := bson.M{field + "." + idField: bson.M{"$ne": id}}
+	for k, v := range filter {
+		missing[k] = v
+	}
+	// 同時に追加された場合は $ne に一致しないため、重複して追加しない
+	_, err = collection.UpdateOne(ctx, missing, bson.M{"$push": bson.M{field: value}, "$set": bson.M{"updated_at": time.Now()}})
+	return err
+}
+
+// ApplyStripeChargeRefund は charge.refunded の返金額の累計を請求書に反映し、新たに返金された額を返す
+// 累計は減らないため、重複や順不同で届いたイベントでは返金額が増えず nil を返す（通知を重複させない）
+func ApplyStripeChargeRefund(ctx context.Context, collection *mongo.Collection, charge *stripe.Charge) (*RefundNotice, error) {
+	var filter bson.M
+	switch {
+	case charge.Invoice != nil && charge.Invoice.ID != "":
+		filter = bson.M{"stripe_invoice_id": charge.Invoice.ID}
+	case charge.PaymentIntent != nil && charge.PaymentIntent.ID != "":
+		filter = bson.M{"stripe_payment_intent_id": charge.PaymentIntent.ID}
+	default:
+		return nil, nil
+	}
+
+	var before InvoiceRecord
+	err := collection.FindOneAndUpdate(ctx, filter, bson.M{
+		"$max": bson.M{"amount_refunded": charge.AmountRefunded},
+		"$set": bson.M{"stripe_charge_id": charge.ID, "updated_at": time.Now()},
+	}).Decode(&before)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if charge.AmountRefunded <= before.AmountRefunded {
+		return nil, nil
+	}
+
+	return &RefundNotice{
+		StripeCustomerID: before.StripeCustomerID,
+		StripeInvoiceID:  before.StripeInvoiceID,
+		InvoiceNumber:    before.Number,
+		Currency:         string(charge.Currency),
+		Amount:           charge.AmountRefunded - before.AmountRefunded,
+		AmountRefunded:   charge.AmountRefunded,
+		AmountPaid:       charge.Amount,
+	}, nil
+}
+
+// NotifyRefund は返金を利用者にメールで知らせる
+// 利用者は payments コレクションの Stripe 顧客IDから探す（Webhook の Worker からも呼べるよう database を受け取る）
+// 通知に失敗しても返金の処理は成功として扱う
+func NotifyRefund(ctx context.Context, database *mongo.Database, notice RefundNotice) {
+	if database == nil || !EmailConfigured() {
+		utils.LogInfoCtx(ctx, "RefundNotice", "Refund notification skipped: "+utils.MaskStripeID(notice.StripeInvoiceID))
+		return
+	}
+
+	var payment struct {
+		UserID primitive.ObjectID `bson:"user_id"`
+	}
+	if err := database.Collection("payments").FindOne(ctx, bson.M{"stripe_customer_id": notice.StripeCustomerID}).Decode(&payment); err != nil {
+		utils.LogErrorCtx(ctx, "RefundNotice", err, "Failed to find user for refunded invoice: "+utils.MaskStripeID(notice.StripeInvoiceID))
+		return
+	}
+	var user struct {
+		Email    string `bson:"email"`
+		NameKana string `bson:"name_kana"`
+	}
+	if err := database.Collection("users").FindOne(ctx, bson.M{"_id": payment.UserID}).Decode(&user); err != nil || user.Email == "" {
+		utils.LogErrorCtx(ctx, "RefundNotice", err, "Failed to load user for refund notification")
+		return
+	}
+
+	err := SendRefundEmail(user.Email, RefundEmailData{
+		UserName:       user.NameKana,
+		InvoiceNumber:  notice.InvoiceNumber,
+		Amount:         utils.FormatAmount(notice.Amount, notice.Currency),
+		AmountRefunded: utils.FormatAmount(notice.AmountRefunded, notice.Currency),
+		Full:           notice.Full(),
+	})
+	if err != nil {
+		utils.LogErrorCtx(ctx, "RefundNotice", err, "Failed to send refund notification")
+	}
+}
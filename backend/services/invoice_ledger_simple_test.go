@@ -0,0 +1,89 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/stripe-go/v81"
+)
+
+// TestNewInvoiceRecord は Stripe の請求書から保存用のドキュメントへの変換をテストする
+func TestNewInvoiceRecord(t *testing.T) {
+	fake := NewFakeBillingProvider(time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC))
+	fake.AddPrice("price_monthly", "月額", 980, stripe.PriceRecurringIntervalMonth, 1)
+	ctx := context.Background()
+	customerID := newFakeBillingCustomer(t, fake)
+	sub, err := fake.CreateSubscription(ctx, &stripe.SubscriptionParams{
+		Customer: stripe.String(customerID),
+		Items:    []*stripe.SubscriptionItemsParams{{Price: stripe.String("price_monthly")}},
+	})
+	require.NoError(t, err)
+
+	observedAt := fake.Now()
+	record := NewInvoiceRecord(sub.LatestInvoice, observedAt)
+	assert.Equal(t, sub.LatestInvoice.ID, record.StripeInvoiceID)
+	assert.Equal(t, customerID, record.StripeCustomerID)
+	assert.Equal(t, sub.ID, record.StripeSubscriptionID)
+	assert.Equal(t, "paid", record.Status)
+	assert.Equal(t, int64(980), record.Total)
+	assert.Equal(t, int64(980), record.AmountPaid)
+	assert.NotEmpty(t, record.HostedInvoiceURL)
+	require.NotNil(t, record.PaidAt)
+	assert.True(t, record.PaidAt.Equal(observedAt))
+	assert.Equal(t, observedAt, record.StripeObservedAt)
+	require.Len(t, record.Lines, 1)
+	assert.Equal(t, "price_monthly", record.Lines[0].PriceID)
+	assert.False(t, record.Lines[0].PeriodEnd.IsZero())
+
+	// 明細のない請求書でも nil ではなく空の配列として保存する
+	empty := NewInvoiceRecord(&stripe.Invoice{ID: "in_empty"}, observedAt)
+	assert.NotNil(t, empty.Lines)
+	assert.Empty(t, empty.StripeCustomerID)
+}
+
+// TestNewInvoiceAdjustments は返金・クレジットノートの台帳への変換（メタデータの引き継ぎ）をテストする
+func TestNewInvoiceAdjustments(t *testing.T) {
+	created := time.Date(2026, 4, 2, 0, 0, 0, 0, time.UTC)
+	refund := NewInvoiceRefund(&stripe.Refund{
+		ID:      "re_001",
+		Amount:  500,
+		Status:  stripe.RefundStatusSucceeded,
+		Created: created.Unix(),
+		Metadata: map[string]string{
+			AdjustmentMetadataReasonCode: "duplicate",
+			AdjustmentMetadataNote:       "二重に決済されたため",
+			AdjustmentMetadataCreatedBy:  "admin_001",
+		},
+	})
+	assert.Equal(t, InvoiceRefund{
+		StripeRefundID: "re_001",
+		Amount:         500,
+		Status:         "succeeded",
+		ReasonCode:     "duplicate",
+		Note:           "二重に決済されたため",
+		CreatedBy:      "admin_001",
+		CreatedAt:      time.Unix(created.Unix(), 0),
+	}, refund)
+
+	// Stripe のダッシュボードで発行した場合はメタデータがない
+	cn := NewInvoiceCreditNote(&stripe.CreditNote{
+		ID:       "cn_001",
+		Number:   "FAKE-0001-CN-01",
+		Type:     stripe.CreditNoteTypePostPayment,
+		Status:   stripe.CreditNoteStatusVoid,
+		Amount:   300,
+		Refund:   &stripe.Refund{ID: "re_002"},
+		Created:  created.Unix(),
+		VoidedAt: created.Add(time.Hour).Unix(),
+	})
+	assert.Equal(t, "re_002", cn.StripeRefundID)
+	assert.Empty(t, cn.ReasonCode)
+	require.NotNil(t, cn.VoidedAt)
+	assert.True(t, cn.VoidedAt.Equal(created.Add(time.Hour)))
+
+	assert.False(t, RefundNotice{Amount: 300, AmountRefunded: 300, AmountPaid: 980}.Full())
+	assert.True(t, RefundNotice{Amount: 680, AmountRefunded: 980, AmountPaid: 980}.Full())
+}
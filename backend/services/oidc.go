@@ -0,0 +1,474 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	jwt "github.com/golang-jwt/jwt/v5"
+)
+
+// OIDCConfig は学校のIdP（OpenID Connect プロバイダ）との連携設定
+type OIDCConfig struct {
+	IssuerURL      string
+	ClientID       string
+	ClientSecret   string
+	RedirectURL    string
+	Scopes         []string
+	StudentIDClaim string
+	// FrontendRedirectURL はログイン完了後（または失敗時）に利用者を戻すフロントエンドのURL
+	FrontendRedirectURL string
+}
+
+// OIDCConfigFromEnv は環境変数から OIDC 設定を読み込む
+// OIDC_ENABLED が true でない場合は ok=false を返す
+func OIDCConfigFromEnv() (config OIDCConfig, ok bool) {
+	if os.Getenv("OIDC_ENABLED") != "true" {
+		return OIDCConfig{}, false
+	}
+
+	scopes := strings.Fields(os.Getenv("OIDC_SCOPES"))
+	if len(scopes) == 0 {
+		scopes = []string{"openid", "email", "profile"}
+	}
+
+	studentIDClaim := os.Getenv("OIDC_STUDENT_ID_CLAIM")
+	if studentIDClaim == "" {
+		studentIDClaim = "student_id"
+	}
+
+	return OIDCConfig{
+		IssuerURL:      strings.TrimSuffix(os.Getenv("OIDC_ISSUER_URL"), "/"),
+		ClientID:       os.Getenv("OIDC_CLIENT_ID"),
+		ClientSecret:   os.Getenv("OIDC_CLIENT_SECRET"),
+		RedirectURL:    os.Getenv("OIDC_REDIRECT_URL"),
+		Scopes:         scopes,
+		StudentIDClaim: studentIDClaim,
+
+		FrontendRedirectURL: os.Getenv("OIDC_FRONTEND_REDIRECT_URL"),
+	}, true
+}
+
+// Validate は必須項目が設定されているか確認する
+func (c OIDCConfig) Validate() error {
+	if c.IssuerURL == "" || c.ClientID == "" || c.RedirectURL == "" || c.FrontendRedirectURL == "" {
+		return errors.New("OIDC_ISSUER_URL, OIDC_CLIENT_ID, OIDC_REDIRECT_URL, OIDC_FRONTEND_REDIRECT_URL は必須です")
+	}
+	return nil
+}
+
+// oidcDiscovery は /.well-known/openid-configuration のうち使用する項目
+type oidcDiscovery struct {
+	Issuer                           string   `json:"issuer"`
+	AuthorizationEndpoint            string   `json:"authorization_endpoint"`
+	TokenEndpoint                    string   `json:"token_endpoint"`
+	JWKSURI                          string   `json:"jwks_uri"`
+	IDTokenSigningAlgValuesSupported []string `json:"id_token_signing_alg_values_supported"`
+	CodeChallengeMethodsSupported    []string `json:"code_challenge_methods_supported"`
+}
+
+// OIDCIdentity は検証済みIDトークンから取り出した利用者情報
+type OIDCIdentity struct {
+	Issuer        string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+	StudentID     string
+}
+
+// OIDCProvider はディスカバリ・認可コード交換・IDトークン検証を行うリライングパーティ
+type OIDCProvider struct {
+	config     OIDCConfig
+	httpClient *http.Client
+	clockSkew  time.Duration
+
+	mu              sync.Mutex
+	discovery       *oidcDiscovery
+	discoveryExpiry time.Time
+	keys            map[string]*rsa.PublicKey
+	keysFetchedAt   time.Time
+}
+
+const (
+	oidcDiscoveryTTL    = time.Hour
+	oidcJWKSMinInterval = time.Minute
+	oidcStateTTL        = 10 * time.Minute
+)
+
+// NewOIDCProvider は OIDC プロバイダのクライアントを生成する
+func NewOIDCProvider(config OIDCConfig, httpClient *http.Client) *OIDCProvider {
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &OIDCProvider{
+		config:     config,
+		httpClient: httpClient,
+		clockSkew:  30 * time.Second,
+	}
+}
+
+// getJSON は指定URLからJSONを取得して dest にデコードする
+func (p *OIDCProvider) getJSON(ctx context.Context, rawURL string, dest interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d from %s", resp.StatusCode, rawURL)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(dest)
+}
+
+// loadDiscovery はディスカバリドキュメントを取得する（1時間キャッシュ）
+func (p *OIDCProvider) loadDiscovery(ctx context.Context) (*oidcDiscovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.discovery != nil && time.Now().Before(p.discoveryExpiry) {
+		return p.discovery, nil
+	}
+
+	var doc oidcDiscovery
+	if err := p.getJSON(ctx, p.config.IssuerURL+"/.well-known/openid-configuration", &doc); err != nil {
+		return nil, fmt.Errorf("ディスカバリドキュメントの取得に失敗しました: %w", err)
+	}
+
+	// OpenID Connect Discovery 1.0 §4.3: issuer は設定値と完全一致しなければならない
+	if doc.Issuer != p.config.IssuerURL {
+		return nil, fmt.Errorf("issuer mismatch: expected %s, got %s", p.config.IssuerURL, doc.Issuer)
+	}
+	if doc.AuthorizationEndpoint == "" || doc.TokenEndpoint == "" || doc.JWKSURI == "" {
+		return nil, errors.New("ディスカバリドキュメントに必要なエンドポイントがありません")
+	}
+	if len(doc.CodeChallengeMethodsSupported) > 0 && !containsString(doc.CodeChallengeMethodsSupported, "S256") {
+		return nil, errors.New("プロバイダが PKCE (S256) に対応していません")
+	}
+
+	p.discovery = &doc
+	p.discoveryExpiry = time.Now().Add(oidcDiscoveryTTL)
+	return p.discovery, nil
+}
+
+// AuthCodeURL は PKCE 付き認可リクエストのURLを生成する
+func (p *OIDCProvider) AuthCodeURL(ctx context.Context, state, nonce, codeChallenge string) (string, error) {
+	discovery, err := p.loadDiscovery(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	authURL, err := url.Parse(discovery.AuthorizationEndpoint)
+	if err != nil {
+		return "", err
+	}
+
+	query := authURL.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", p.config.ClientID)
+	query.Set("redirect_uri", p.config.RedirectURL)
+	query.Set("scope", strings.Join(p.config.Scopes, " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", codeChallenge)
+	query.Set("code_challenge_method", "S256")
+	authURL.RawQuery = query.Encode()
+
+	return authURL.String(), nil
+}
+
+// oidcTokenResponse はトークンエンドポイントのレスポンス
+type oidcTokenResponse struct {
+	IDToken          string `json:"id_token"`
+	AccessToken      string `json:"access_token"`
+	TokenType        string `json:"token_type"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// Exchange は認可コードとPKCE検証子をトークンエンドポイントに送信し、IDトークンを検証して利用者情報を返す
+func (p *OIDCProvider) Exchange(ctx context.Context, code, codeVerifier, nonce string) (*OIDCIdentity, error) {
+	discovery, err := p.loadDiscovery(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.config.RedirectURL)
+	form.Set("client_id", p.config.ClientID)
+	form.Set("code_verifier", codeVerifier)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.config.ClientSecret != "" {
+		// client_secret_basic（RFC 6749 §2.3.1 に従いURLエンコードする）
+		req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+	}
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("トークンエンドポイントへの接続に失敗しました: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var tokenResp oidcTokenResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&tokenResp); err != nil {
+		return nil, fmt.Errorf("トークンレスポンスの解析に失敗しました: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK || tokenResp.Error != "" {
+		return nil, fmt.Errorf("トークン交換に失敗しました: status=%d error=%s", resp.StatusCode, tokenResp.Error)
+	}
+	if tokenResp.IDToken == "" {
+		return nil, errors.New("トークンレスポンスに id_token が含まれていません")
+	}
+
+	return p.VerifyIDToken(ctx, tokenResp.IDToken, nonce)
+}
+
+// VerifyIDToken はIDトークンの署名（JWKS）と iss/aud/exp/iat/nonce を検証する
+func (p *OIDCProvider) VerifyIDToken(ctx context.Context, rawIDToken, expectedNonce string) (*OIDCIdentity, error) {
+	discovery, err := p.loadDiscovery(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := jwt.MapClaims{}
+	_, err = jwt.ParseWithClaims(rawIDToken, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return p.publicKey(ctx, discovery.JWKSURI, kid)
+	},
+		jwt.WithValidMethods([]string{"RS256"}),
+		jwt.WithIssuer(discovery.Issuer),
+		jwt.WithAudience(p.config.ClientID),
+		jwt.WithLeeway(p.clockSkew),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("IDトークンの検証に失敗しました: %w", err)
+	}
+
+	// 複数の aud を含む場合は azp が自分のクライアントIDであることを確認（OIDC Core §3.1.3.7）
+	if aud, _ := claims.GetAudience(); len(aud) > 1 {
+		if azp, _ := claims["azp"].(string); azp != p.config.ClientID {
+			return nil, errors.New("IDトークンの azp が一致しません")
+		}
+	}
+
+	if nonce, _ := claims["nonce"].(string); expectedNonce == "" || nonce != expectedNonce {
+		return nil, errors.New("IDトークンの nonce が一致しません")
+	}
+
+	subject, _ := claims.GetSubject()
+	if subject == "" {
+		return nil, errors.New("IDトークンに sub が含まれていません")
+	}
+
+	identity := &OIDCIdentity{
+		Issuer:        discovery.Issuer,
+		Subject:       subject,
+		Email:         strings.ToLower(stringClaim(claims, "email")),
+		EmailVerified: boolClaim(claims, "email_verified"),
+		Name:          stringClaim(claims, "name"),
+		StudentID:     stringClaim(claims, p.config.StudentIDClaim),
+	}
+	return identity, nil
+}
+
+// publicKey は kid に対応する公開鍵を返す。未知の kid の場合は JWKS を再取得する（鍵ローテーション対応）
+func (p *OIDCProvider) publicKey(ctx context.Context, jwksURI, kid string) (*rsa.PublicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key, ok := p.lookupKey(kid); ok {
+		return key, nil
+	}
+
+	if !p.keysFetchedAt.IsZero() && time.Since(p.keysFetchedAt) < oidcJWKSMinInterval {
+		return nil, fmt.Errorf("unknown key id: %s", kid)
+	}
+
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := p.getJSON(ctx, jwksURI, &jwks); err != nil {
+		return nil, fmt.Errorf("JWKSの取得に失敗しました: %w", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range jwks.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		nBytes, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			continue
+		}
+		eBytes, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(nBytes),
+			E: int(new(big.Int).SetBytes(eBytes).Int64()),
+		}
+	}
+
+	p.keys = keys
+	p.keysFetchedAt = time.Now()
+
+	if key, ok := p.lookupKey(kid); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown key id: %s", kid)
+}
+
+// lookupKey はキャッシュ済みの鍵を探す。kid が空で鍵が1つだけの場合はその鍵を使う
+func (p *OIDCProvider) lookupKey(kid string) (*rsa.PublicKey, bool) {
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key, true
+		}
+	}
+	key, ok := p.keys[kid]
+	return key, ok
+}
+
+// OIDCLoginState は認可リクエストからコールバックまでの間に保持する値
+type OIDCLoginState struct {
+	CodeVerifier string    `json:"code_verifier"`
+	Nonce        string    `json:"nonce"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// NewOIDCLoginState は state・nonce・PKCE検証子を生成する
+func NewOIDCLoginState() (state string, loginState OIDCLoginState, err error) {
+	if state, err = randomURLToken(32); err != nil {
+		return "", OIDCLoginState{}, err
+	}
+	verifier, err := randomURLToken(48) // RFC 7636: 43〜128文字
+	if err != nil {
+		return "", OIDCLoginState{}, err
+	}
+	nonce, err := randomURLToken(32)
+	if err != nil {
+		return "", OIDCLoginState{}, err
+	}
+	return state, OIDCLoginState{CodeVerifier: verifier, Nonce: nonce, CreatedAt: time.Now()}, nil
+}
+
+// PKCEChallenge は検証子から S256 のコードチャレンジを算出する
+func PKCEChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// SaveOIDCLoginState はログイン状態をRedisに保存する（10分で失効）
+func SaveOIDCLoginState(ctx context.Context, state string, loginState OIDCLoginState) error {
+	if RedisClient == nil {
+		return fmt.Errorf("Redisクライアントが初期化されていません")
+	}
+
+	raw, err := json.Marshal(loginState)
+	if err != nil {
+		return err
+	}
+	return RedisClient.Set(ctx, "oidc_state:"+state, raw, oidcStateTTL).Err()
+}
+
+// ConsumeOIDCLoginState はログイン状態を取得して削除する（state の再利用を防ぐ）
+func ConsumeOIDCLoginState(ctx context.Context, state string) (*OIDCLoginState, error) {
+	if RedisClient == nil {
+		return nil, fmt.Errorf("Redisクライアントが初期化されていません")
+	}
+
+	key := "oidc_state:" + state
+	pipe := RedisClient.TxPipeline()
+	getCmd := pipe.Get(ctx, key)
+	pipe.Del(ctx, key)
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	raw, err := getCmd.Bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	var loginState OIDCLoginState
+	if err := json.Unmarshal(raw, &loginState); err != nil {
+		return nil, err
+	}
+	return &loginState, nil
+}
+
+func randomURLToken(bytes int) (string, error) {
+	buf := make([]byte, bytes)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+func stringClaim(claims jwt.MapClaims, name string) string {
+	switch v := claims[name].(type) {
+	case string:
+		return v
+	case float64:
+		return fmt.Sprintf("%.0f", v)
+	}
+	return ""
+}
+
+// boolClaim は真偽値クレームを取り出す（"true" の文字列で返すIdPにも対応）
+func boolClaim(claims jwt.MapClaims, name string) bool {
+	switch v := claims[name].(type) {
+	case bool:
+		return v
+	case string:
+		return v == "true"
+	}
+	return false
+}
+
+func containsString(values []string, target string) bool {
+	for _, v := range values {
+		if v == target {
+			return true
+		}
+	}
+	return false
+}
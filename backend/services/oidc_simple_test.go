@@ -0,0 +1,333 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mockOIDCProvider はテスト用のローカルOIDCプロバイダ
+// ディスカバリ・JWKS・トークンエンドポイントを提供し、発行するIDトークンのクレームを差し替えられる
+type mockOIDCProvider struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+	kid    string
+
+	mu            sync.Mutex
+	codes         map[string]mockAuthCode
+	claimOverride func(claims jwt.MapClaims)
+	jwksRequests  int
+	// discoveryIssuer が設定されている場合、ディスカバリの issuer をこの値にする
+	discoveryIssuer string
+}
+
+type mockAuthCode struct {
+	challenge string
+	nonce     string
+}
+
+func newMockOIDCProvider(t *testing.T) *mockOIDCProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	m := &mockOIDCProvider{key: key, kid: "key-1", codes: map[string]mockAuthCode{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		issuer := m.server.URL
+		if m.discoveryIssuer != "" {
+			issuer = m.discoveryIssuer
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                                issuer,
+			"authorization_endpoint":                m.server.URL + "/authorize",
+			"token_endpoint":                        m.server.URL + "/token",
+			"jwks_uri":                              m.server.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+			"code_challenge_methods_supported":      []string{"S256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		m.mu.Lock()
+		m.jwksRequests++
+		pub := m.key.PublicKey
+		kid := m.kid
+		m.mu.Unlock()
+
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": kid,
+				"use": "sig",
+				"alg": "RS256",
+				"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		clientID, secret, _ := r.BasicAuth()
+
+		m.mu.Lock()
+		code, ok := m.codes[r.PostForm.Get("code")]
+		delete(m.codes, r.PostForm.Get("code"))
+		m.mu.Unlock()
+
+		if clientID != "juice-academy" || secret != "s3cret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client"})
+			return
+		}
+		// PKCE: code_verifier から算出したチャレンジが認可時の値と一致すること
+		if !ok || PKCEChallenge(r.PostForm.Get("code_verifier")) != code.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]string{
+			"access_token": "idp-access-token",
+			"token_type":   "Bearer",
+			"id_token":     m.signIDToken(t, code.nonce),
+		})
+	})
+	m.server = httptest.NewServer(mux)
+	t.Cleanup(m.server.Close)
+	return m
+}
+
+// authorize は利用者がIdPでログインしたとみなし、認可コードを発行する
+func (m *mockOIDCProvider) authorize(t *testing.T, authURL string) string {
+	parsed, err := url.Parse(authURL)
+	require.NoError(t, err)
+	query := parsed.Query()
+	require.Equal(t, "S256", query.Get("code_challenge_method"))
+
+	code := "code-" + query.Get("state")
+	m.mu.Lock()
+	m.codes[code] = mockAuthCode{challenge: query.Get("code_challenge"), nonce: query.Get("nonce")}
+	m.mu.Unlock()
+	return code
+}
+
+func (m *mockOIDCProvider) signIDToken(t *testing.T, nonce string) string {
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":            m.server.URL,
+		"sub":            "idp-user-123",
+		"aud":            "juice-academy",
+		"iat":            now.Unix(),
+		"exp":            now.Add(5 * time.Minute).Unix(),
+		"nonce":          nonce,
+		"email":          "Taro@Example.ac.jp",
+		"email_verified": true,
+		"name":           "Taro",
+		"student_id":     "S1234567",
+	}
+
+	m.mu.Lock()
+	override := m.claimOverride
+	key, kid := m.key, m.kid
+	m.mu.Unlock()
+	if override != nil {
+		override(claims)
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	require.NoError(t, err)
+	return signed
+}
+
+func (m *mockOIDCProvider) config() OIDCConfig {
+	return OIDCConfig{
+		IssuerURL:           m.server.URL,
+		ClientID:            "juice-academy",
+		ClientSecret:        "s3cret",
+		RedirectURL:         "http://localhost:8080/api/auth/oidc/callback",
+		Scopes:              []string{"openid", "email", "profile"},
+		StudentIDClaim:      "student_id",
+		FrontendRedirectURL: "http://localhost:3000/login/callback",
+	}
+}
+
+// runLogin は認可リクエストからコード交換までを一通り実行する
+func runLogin(t *testing.T, m *mockOIDCProvider, provider *OIDCProvider) (*OIDCIdentity, error) {
+	ctx := context.Background()
+	state, loginState, err := NewOIDCLoginState()
+	require.NoError(t, err)
+
+	authURL, err := provider.AuthCodeURL(ctx, state, loginState.Nonce, PKCEChallenge(loginState.CodeVerifier))
+	require.NoError(t, err)
+	code := m.authorize(t, authURL)
+
+	return provider.Exchange(ctx, code, loginState.CodeVerifier, loginState.Nonce)
+}
+
+// TestOIDCLoginFlow はモックプロバイダに対する認可コード+PKCEフローをテストする
+func TestOIDCLoginFlow(t *testing.T) {
+	m := newMockOIDCProvider(t)
+	provider := NewOIDCProvider(m.config(), m.server.Client())
+
+	identity, err := runLogin(t, m, provider)
+	require.NoError(t, err)
+	assert.Equal(t, m.server.URL, identity.Issuer)
+	assert.Equal(t, "idp-user-123", identity.Subject)
+	assert.Equal(t, "taro@example.ac.jp", identity.Email, "メールアドレスは小文字に正規化されること")
+	assert.True(t, identity.EmailVerified)
+	assert.Equal(t, "S1234567", identity.StudentID)
+}
+
+// TestOIDCAuthCodeURL は認可リクエストに必要なパラメータが含まれることをテストする
+func TestOIDCAuthCodeURL(t *testing.T) {
+	m := newMockOIDCProvider(t)
+	provider := NewOIDCProvider(m.config(), m.server.Client())
+
+	authURL, err := provider.AuthCodeURL(context.Background(), "state-1", "nonce-1", "challenge-1")
+	require.NoError(t, err)
+
+	parsed, err := url.Parse(authURL)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(authURL, m.server.URL+"/authorize?"))
+	query := parsed.Query()
+	assert.Equal(t, "code", query.Get("response_type"))
+	assert.Equal(t, "juice-academy", query.Get("client_id"))
+	assert.Equal(t, "openid email profile", query.Get("scope"))
+	assert.Equal(t, "state-1", query.Get("state"))
+	assert.Equal(t, "nonce-1", query.Get("nonce"))
+	assert.Equal(t, "challenge-1", query.Get("code_challenge"))
+}
+
+// TestOIDCRejectsInvalidIDTokens はIDトークンの検証失敗ケースをテストする
+func TestOIDCRejectsInvalidIDTokens(t *testing.T) {
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name        string
+		override    func(claims jwt.MapClaims)
+		description string
+	}{
+		{
+			name:        "別クライアント宛て",
+			override:    func(claims jwt.MapClaims) { claims["aud"] = "another-client" },
+			description: "aud が自分のクライアントIDでないトークンは拒否されること",
+		},
+		{
+			name:        "発行者不一致",
+			override:    func(claims jwt.MapClaims) { claims["iss"] = "https://evil.example.com" },
+			description: "iss がディスカバリの issuer と異なるトークンは拒否されること",
+		},
+		{
+			name:        "期限切れ",
+			override:    func(claims jwt.MapClaims) { claims["exp"] = time.Now().Add(-10 * time.Minute).Unix() },
+			description: "期限切れのトークンは拒否されること",
+		},
+		{
+			name:        "nonce不一致",
+			override:    func(claims jwt.MapClaims) { claims["nonce"] = "replayed-nonce" },
+			description: "nonce が一致しないトークン（リプレイ）は拒否されること",
+		},
+		{
+			name: "azp不一致",
+			override: func(claims jwt.MapClaims) {
+				claims["aud"] = []string{"juice-academy", "another-client"}
+				claims["azp"] = "another-client"
+			},
+			description: "複数 aud で azp が自分でないトークンは拒否されること",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMockOIDCProvider(t)
+			m.claimOverride = tt.override
+			provider := NewOIDCProvider(m.config(), m.server.Client())
+
+			_, err := runLogin(t, m, provider)
+			assert.Error(t, err, tt.description)
+		})
+	}
+
+	t.Run("署名鍵不一致", func(t *testing.T) {
+		m := newMockOIDCProvider(t)
+		provider := NewOIDCProvider(m.config(), m.server.Client())
+
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+			"iss": m.server.URL, "sub": "x", "aud": "juice-academy", "nonce": "n",
+			"iat": time.Now().Unix(), "exp": time.Now().Add(time.Minute).Unix(),
+		})
+		token.Header["kid"] = m.kid
+		signed, err := token.SignedString(otherKey)
+		require.NoError(t, err)
+
+		_, err = provider.VerifyIDToken(context.Background(), signed, "n")
+		assert.Error(t, err, "JWKSにない鍵で署名されたトークンは拒否されること")
+	})
+}
+
+// TestOIDCRejectsWrongCodeVerifier はPKCE検証子が異なる場合にコード交換が失敗することをテストする
+func TestOIDCRejectsWrongCodeVerifier(t *testing.T) {
+	m := newMockOIDCProvider(t)
+	provider := NewOIDCProvider(m.config(), m.server.Client())
+	ctx := context.Background()
+
+	state, loginState, err := NewOIDCLoginState()
+	require.NoError(t, err)
+	authURL, err := provider.AuthCodeURL(ctx, state, loginState.Nonce, PKCEChallenge(loginState.CodeVerifier))
+	require.NoError(t, err)
+	code := m.authorize(t, authURL)
+
+	_, err = provider.Exchange(ctx, code, "attacker-verifier", loginState.Nonce)
+	assert.Error(t, err)
+}
+
+// TestOIDCKeyRotation はIdPの鍵ローテーション後に JWKS を再取得することをテストする
+func TestOIDCKeyRotation(t *testing.T) {
+	m := newMockOIDCProvider(t)
+	provider := NewOIDCProvider(m.config(), m.server.Client())
+
+	_, err := runLogin(t, m, provider)
+	require.NoError(t, err)
+
+	newKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	m.mu.Lock()
+	m.key, m.kid = newKey, "key-2"
+	m.mu.Unlock()
+
+	// 前回の取得から間もない場合でも、キャッシュを無効化すれば新しい鍵で検証できる
+	provider.mu.Lock()
+	provider.keysFetchedAt = time.Now().Add(-2 * oidcJWKSMinInterval)
+	provider.mu.Unlock()
+
+	_, err = runLogin(t, m, provider)
+	require.NoError(t, err)
+	assert.Equal(t, 2, m.jwksRequests)
+}
+
+// TestOIDCDiscoveryIssuerMismatch はディスカバリの issuer が設定値と異なる場合に拒否されることをテストする
+func TestOIDCDiscoveryIssuerMismatch(t *testing.T) {
+	m := newMockOIDCProvider(t)
+	m.discoveryIssuer = "https://evil.example.com"
+	provider := NewOIDCProvider(m.config(), m.server.Client())
+
+	_, err := provider.AuthCodeURL(context.Background(), "s", "n", "c")
+	assert.Error(t, err)
+}
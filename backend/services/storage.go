@@ -0,0 +1,129 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ErrObjectNotFound は指定したキーのオブジェクトが存在しないことを表す
+var ErrObjectNotFound = errors.New("object not found")
+
+// ObjectStorage は添付ファイルなどのバイナリを保存するストレージ
+// キーは "/" 区切りの相対パス（例: announcements/<id>/<attachment_id>）
+type ObjectStorage interface {
+	Put(ctx context.Context, key string, data []byte, contentType string) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// StorageFromEnv は STORAGE_BACKEND（local または s3、既定は local）に応じたストレージを返す
+func StorageFromEnv() (ObjectStorage, error) {
+	switch backend := os.Getenv("STORAGE_BACKEND"); backend {
+	case "", "local":
+		dir := os.Getenv("STORAGE_LOCAL_DIR")
+		if dir == "" {
+			dir = "./data/uploads"
+		}
+		return NewLocalStorage(dir)
+	case "s3":
+		config := S3ConfigFromEnv()
+		if err := config.Validate(); err != nil {
+			return nil, err
+		}
+		return NewS3Storage(config, nil), nil
+	default:
+		return nil, fmt.Errorf("STORAGE_BACKEND の値が不正です: %s", backend)
+	}
+}
+
+// validateObjectKey はキーがストレージの外を指さないことを確認する
+func validateObjectKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") || strings.ContainsRune(key, 0) {
+		return fmt.Errorf("invalid object key: %q", key)
+	}
+	if path.Clean(key) != key || key == ".." || strings.HasPrefix(key, "../") {
+		return fmt.Errorf("invalid object key: %q", key)
+	}
+	return nil
+}
+
+// LocalStorage はローカルファイルシステムに保存するストレージ（開発環境・単一サーバー向け）
+type LocalStorage struct {
+	root string
+}
+
+// NewLocalStorage は root 以下に保存するストレージを作成する（ディレクトリがなければ作成する）
+func NewLocalStorage(root string) (*LocalStorage, error) {
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(abs, 0o750); err != nil {
+		return nil, fmt.Errorf("保存先ディレクトリを作成できません: %w", err)
+	}
+	return &LocalStorage{root: abs}, nil
+}
+
+func (s *LocalStorage) path(key string) (string, error) {
+	if err := validateObjectKey(key); err != nil {
+		return "", err
+	}
+	return filepath.Join(s.root, filepath.FromSlash(key)), nil
+}
+
+// Put はファイルを一時ファイルに書き込んでから置き換える（書き込み途中のファイルを読まれないようにする）
+func (s *LocalStorage) Put(ctx context.Context, key string, data []byte, contentType string) error {
+	target, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0o750); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(target), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), target)
+}
+
+// Get はファイルを開く（存在しない場合は ErrObjectNotFound）
+func (s *LocalStorage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	target, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(target)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrObjectNotFound
+	}
+	return file, err
+}
+
+// Delete はファイルを削除する（存在しない場合も成功として扱う）
+func (s *LocalStorage) Delete(ctx context.Context, key string) error {
+	target, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(target); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,295 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// emptyPayloadHash は空の本文の SHA-256（GET / DELETE の署名に使用する）
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// S3Config は S3 互換ストレージ（AWS S3・MinIO・Cloudflare R2 など）の接続設定
+type S3Config struct {
+	// Endpoint は API のベースURL。空の場合は AWS S3 のリージョンのエンドポイントを使用する
+	Endpoint        string
+	Region          string
+	Bucket          string
+	AccessKeyID     string
+	SecretAccessKey string
+	// ForcePathStyle は https://endpoint/bucket/key 形式で接続する（MinIO など）
+	ForcePathStyle bool
+}
+
+// S3ConfigFromEnv は環境変数から S3 互換ストレージの設定を読み込む
+func S3ConfigFromEnv() S3Config {
+	region := os.Getenv("S3_REGION")
+	if region == "" {
+		region = "us-east-1"
+	}
+	return S3Config{
+		Endpoint:        strings.TrimSuffix(os.Getenv("S3_ENDPOINT"), "/"),
+		Region:          region,
+		Bucket:          os.Getenv("S3_BUCKET"),
+		AccessKeyID:     os.Getenv("S3_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("S3_SECRET_ACCESS_KEY"),
+		ForcePathStyle:  os.Getenv("S3_FORCE_PATH_STYLE") == "true",
+	}
+}
+
+// Validate は必須項目が設定されているかを確認する
+func (c S3Config) Validate() error {
+	var missing []string
+	if c.Bucket == "" {
+		missing = append(missing, "S3_BUCKET")
+	}
+	if c.AccessKeyID == "" {
+		missing = append(missing, "S3_ACCESS_KEY_ID")
+	}
+	if c.SecretAccessKey == "" {
+		missing = append(missing, "S3_SECRET_ACCESS_KEY")
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("S3 ストレージの設定が不足しています: %s", strings.Join(missing, ", "))
+	}
+	if c.Endpoint != "" {
+		if u, err := url.Parse(c.Endpoint); err != nil || u.Host == "" || (u.Scheme != "https" && u.Scheme != "http") {
+			return fmt.Errorf("S3_ENDPOINT が不正です: %s", c.Endpoint)
+		}
+	}
+	return nil
+}
+
+// S3Storage は署名バージョン4（SigV4）で S3 互換 API に接続するストレージ
+type S3Storage struct {
+	config     S3Config
+	httpClient *http.Client
+	now        func() time.Time
+}
+
+// NewS3Storage は S3 互換ストレージのクライアントを作成する（httpClient が nil の場合は既定のクライアントを使用する）
+func NewS3Storage(config S3Config, httpClient *http.Client) *S3Storage {
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 30 * time.Second}
+	}
+	return &S3Storage{config: config, httpClient: httpClient, now: time.Now}
+}
+
+// objectURL はキーに対応するオブジェクトのURLを返す
+func (s *S3Storage) objectURL(key string) (*url.URL, error) {
+	if err := validateObjectKey(key); err != nil {
+		return nil, err
+	}
+	endpoint := s.config.Endpoint
+	if endpoint == "" {
+		endpoint = "https://s3." + s.config.Region + ".amazonaws.com"
+	}
+	base, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	objectPath := "/" + key
+	if s.config.ForcePathStyle {
+		objectPath = "/" + s.config.Bucket + objectPath
+	} else {
+		base.Host = s.config.Bucket + "." + base.Host
+	}
+	base.Path = strings.TrimSuffix(base.Path, "/") + objectPath
+	base.RawPath = ""
+	return base, nil
+}
+
+func (s *S3Storage) newRequest(ctx context.Context, method, key string, body []byte) (*http.Request, error) {
+	objectURL, err := s.objectURL(key)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, objectURL.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body == nil {
+		req.Body = http.NoBody
+		req.ContentLength = 0
+	}
+	return req, nil
+}
+
+// Put はオブジェクトを保存する（本文の SHA-256 を署名に含めるため改ざんされた本文は拒否される）
+func (s *S3Storage) Put(ctx context.Context, key string, data []byte, contentType string) error {
+	req, err := s.newRequest(ctx, http.MethodPut, key, data)
+	if err != nil {
+		return err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	sum := sha256.Sum256(data)
+	signS3Request(req, s.config, hex.EncodeToString(sum[:]), s.now())
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return s3ResponseError(resp)
+}
+
+// Get はオブジェクトを取得する（存在しない場合は ErrObjectNotFound）
+func (s *S3Storage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	req, err := s.newRequest(ctx, http.MethodGet, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	signS3Request(req, s.config, emptyPayloadHash, s.now())
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := s3ResponseError(resp); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Delete はオブジェクトを削除する（S3 は存在しないキーの削除も成功として扱う）
+func (s *S3Storage) Delete(ctx context.Context, key string) error {
+	req, err := s.newRequest(ctx, http.MethodDelete, key, nil)
+	if err != nil {
+		return err
+	}
+	signS3Request(req, s.config, emptyPayloadHash, s.now())
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := s3ResponseError(resp); err != nil && !errors.Is(err, ErrObjectNotFound) {
+		return err
+	}
+	return nil
+}
+
+// s3ResponseError は 2xx 以外の応答をエラーに変換する
+func s3ResponseError(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrObjectNotFound
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("S3 request failed: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+}
+
+// signS3Request は AWS 署名バージョン4 の Authorization ヘッダーを設定する
+// Host とリクエストに設定済みのヘッダーをすべて署名対象にする
+func signS3Request(req *http.Request, config S3Config, payloadHash string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.Join(values, ",")
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + strings.Join(strings.Fields(headers[name]), " ") + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		s3CanonicalURI(req.URL.Path),
+		s3CanonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + config.Region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+config.SecretAccessKey), date)
+	key = hmacSHA256(key, config.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+config.AccessKeyID+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3CanonicalURI はパスの各セグメントを RFC 3986 に従ってエンコードする（S3 では "/" はそのまま）
+func s3CanonicalURI(p string) string {
+	if p == "" {
+		return "/"
+	}
+	segments := strings.Split(p, "/")
+	for i, segment := range segments {
+		segments[i] = s3Escape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+func s3CanonicalQuery(values url.Values) string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var pairs []string
+	for _, key := range keys {
+		vals := append([]string(nil), values[key]...)
+		sort.Strings(vals)
+		for _, v := range vals {
+			pairs = append(pairs, s3Escape(key)+"="+s3Escape(v))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// s3Escape は英数字と "-_.~" 以外をパーセントエンコードする
+func s3Escape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
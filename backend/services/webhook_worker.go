@@ -35,6 +35,7 @@ var (
 
 	// MongoDBコレクションへの参照（外部から設定）
 	webhookSubscriptionCollection *mongo.Collection
+	webhookInvoiceCollection      *mongo.Collection
 )
 
 // DefaultWebhookConfig はデフォルトのWorker設定
@@ -44,9 +45,10 @@ var DefaultWebhookConfig = WebhookWorkerConfig{
 }
 
 // InitWebhookWorker はWebhook Worker Poolを初期化する
-func InitWebhookWorker(config WebhookWorkerConfig, subCollection *mongo.Collection) {
+func InitWebhookWorker(config WebhookWorkerConfig, subCollection, invoiceCollection *mongo.Collection) {
 	webhookOnce.Do(func() {
 		webhookSubscriptionCollection = subCollection
+		webhookInvoiceCollection = invoiceCollection
 		webhookJobQueue = make(chan WebhookJob, config.QueueSize)
 		shutdownChan = make(chan struct{})
 
@@ -149,11 +151,17 @@ func processWebhookJob(job WebhookJob) {
 		handleTrialWillEnd(ctx, event)
 
 	case "invoice.paid":
+		handleInvoiceLedger(ctx, event)
 		handleInvoicePaid(ctx, event)
 
 	case "invoice.payment_failed":
+		handleInvoiceLedger(ctx, event)
 		handleInvoicePaymentFailed(ctx, event)
 
+	case "invoice.created", "invoice.finalized", "invoice.updated", "invoice.payment_succeeded",
+		"invoice.voided", "invoice.marked_uncollectible":
+		handleInvoiceLedger(ctx, event)
+
 	case "invoice.upcoming":
 		handleInvoiceUpcoming(ctx, event)
 
@@ -163,8 +171,15 @@ func processWebhookJob(job WebhookJob) {
 	case "payment_intent.payment_failed":
 		handlePaymentIntentFailed(ctx, event)
 
-	case "charge.dispute.created":
-		handleDisputeCreated(ctx, event)
+	case "charge.refunded":
+		handleChargeRefunded(ctx, event)
+	case "refund.created", "refund.updated":
+		handleRefundLedger(ctx, event)
+	case "credit_note.created", "credit_note.updated", "credit_note.voided":
+		handleCreditNoteLedger(ctx, event)
+	case "charge.dispute.created", "charge.dispute.updated", "charge.dispute.closed",
+		"charge.dispute.funds_withdrawn", "charge.dispute.funds_reinstated":
+		handleDispute(ctx, event)
 
 	default:
 		utils.LogInfoCtx(ctx, "WebhookWorker", fmt.Sprintf("Unhandled event type: %s", event.Type))
@@ -212,7 +227,9 @@ func handleCheckoutSessionCompleted(ctx context.Context, event stripe.Event) {
 		_, err = webhookSubscriptionCollection.UpdateOne(ctx, filter, update)
 		if err != nil {
 			utils.LogErrorCtx(ctx, "WebhookWorker", err, "Failed to update subscription from checkout session")
+			return
 		}
+		PublishSubscriptionChange(ctx, webhookSubscriptionCollection, filter)
 	}
 }
 
@@ -228,18 +245,93 @@ func handleSubscriptionUpdated(ctx context.Context, event stripe.Event) {
 		return
 	}
 
-	update := bson.M{
-		"$set": bson.M{
-			"status":               string(sub.Status),
-			"current_period_end":   time.Unix(sub.CurrentPeriodEnd, 0),
-			"cancel_at_period_end": sub.CancelAtPeriodEnd,
-			"updated_at":           time.Now(),
-		},
-	}
-	_, err := webhookSubscriptionCollection.UpdateOne(ctx, bson.M{"stripe_subscription_id": sub.ID}, update)
-	if err != nil {
+	if err := ApplyStripeSubscriptionUpdate(ctx, webhookSubscriptionCollection, &sub); err != nil {
 		utils.LogErrorCtx(ctx, "WebhookWorker", err, "Failed to update subscription")
+		return
 	}
+	PublishSubscriptionChange(ctx, webhookSubscriptionCollection, bson.M{"stripe_subscription_id": sub.ID})
+}
+
+// ApplyStripeSubscriptionUpdate は Stripe のサブスクリプションの状態を subscriptions コレクションに反映する
+// 予約していたプラン変更が反映された場合や、予約（Subscription Schedule）が解除された場合は予約の情報を消す
+// 支払いの一時停止（pause_collection）は paused_at / pause_resumes_at に反映する
+// 支払い遅延（past_due）になった日時は past_due_at に記録し、猶予期間の起点にする
+func ApplyStripeSubscriptionUpdate(ctx context.Context, collection *mongo.Collection, sub *stripe.Subscription) error {
+	filter := bson.M{"stripe_subscription_id": sub.ID}
+	set := bson.M{
+		"status":               string(sub.Status),
+		"current_period_end":   time.Unix(sub.CurrentPeriodEnd, 0),
+		"cancel_at_period_end": sub.CancelAtPeriodEnd,
+		"updated_at":           time.Now(),
+	}
+	unset := bson.M{}
+	if sub.TrialEnd > 0 {
+		set["trial_end"] = time.Unix(sub.TrialEnd, 0)
+	}
+	priceID := ""
+	if sub.Items != nil && len(sub.Items.Data) > 0 && sub.Items.Data[0].Price != nil {
+		priceID = sub.Items.Data[0].Price.ID
+		set["price_id"] = priceID
+	}
+	switch {
+	case sub.PauseCollection == nil:
+		unset["paused_at"] = ""
+		unset["pause_resumes_at"] = ""
+	case sub.PauseCollection.ResumesAt > 0:
+		set["pause_resumes_at"] = time.Unix(sub.PauseCollection.ResumesAt, 0)
+	default:
+		unset["pause_resumes_at"] = ""
+	}
+	if sub.Status != stripe.SubscriptionStatusPastDue {
+		unset["past_due_at"] = ""
+	}
+	update := bson.M{"$set": set}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+	if _, err := collection.UpdateOne(ctx, filter, update); err != nil {
+		return err
+	}
+	// ダッシュボードなどアプリの外で一時停止された場合も休止中として扱う
+	if sub.PauseCollection != nil {
+		pausedFilter := bson.M{"stripe_subscription_id": sub.ID, "paused_at": bson.M{"$exists": false}}
+		if _, err := collection.UpdateOne(ctx, pausedFilter, bson.M{"$set": bson.M{"paused_at": time.Now()}}); err != nil {
+			return err
+		}
+	}
+	if sub.Status == stripe.SubscriptionStatusPastDue {
+		if err := markPastDueSince(ctx, collection, sub.ID, time.Now()); err != nil {
+			return err
+		}
+	}
+
+	scheduled := bson.M{"$unset": bson.M{"schedule_id": "", "scheduled_price_id": "", "scheduled_change_at": ""}}
+	if sub.Schedule == nil {
+		_, err := collection.UpdateOne(ctx, filter, scheduled)
+		return err
+	}
+	if priceID != "" {
+		_, err := collection.UpdateOne(ctx, bson.M{"stripe_subscription_id": sub.ID, "scheduled_price_id": priceID}, scheduled)
+		return err
+	}
+	return nil
+}
+
+// MarkSubscriptionPastDue は支払いに失敗したサブスクリプションを支払い遅延にする
+// 猶予期間は最初に失敗した日時から数えるため、past_due_at は未設定の場合のみ記録する
+func MarkSubscriptionPastDue(ctx context.Context, collection *mongo.Collection, stripeSubscriptionID string) error {
+	now := time.Now()
+	update := bson.M{"$set": bson.M{"status": "past_due", "updated_at": now}}
+	if _, err := collection.UpdateOne(ctx, bson.M{"stripe_subscription_id": stripeSubscriptionID}, update); err != nil {
+		return err
+	}
+	return markPastDueSince(ctx, collection, stripeSubscriptionID, now)
+}
+
+func markPastDueSince(ctx context.Context, collection *mongo.Collection, stripeSubscriptionID string, now time.Time) error {
+	filter := bson.M{"stripe_subscription_id": stripeSubscriptionID, "past_due_at": bson.M{"$exists": false}}
+	_, err := collection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"past_due_at": now}})
+	return err
 }
 
 // handleSubscriptionDeleted はcustomer.subscription.deletedイベントを処理
@@ -266,7 +358,9 @@ func handleSubscriptionDeleted(ctx context.Context, event stripe.Event) {
 	_, err := webhookSubscriptionCollection.UpdateOne(ctx, bson.M{"stripe_subscription_id": sub.ID}, update)
 	if err != nil {
 		utils.LogErrorCtx(ctx, "WebhookWorker", err, "Failed to update subscription status")
+		return
 	}
+	PublishSubscriptionChange(ctx, webhookSubscriptionCollection, bson.M{"stripe_subscription_id": sub.ID})
 }
 
 // handleTrialWillEnd はcustomer.subscription.trial_will_endイベントを処理
@@ -284,6 +378,82 @@ func handleTrialWillEnd(ctx context.Context, event stripe.Event) {
 	// TODO: ユーザーにメール通知を送信する処理を追加
 }
 
+// handleInvoiceLedger はinvoice.*イベントの請求書を invoices コレクションに反映する
+func handleInvoiceLedger(ctx context.Context, event stripe.Event) {
+	var inv stripe.Invoice
+	if err := json.Unmarshal(event.Data.Raw, &inv); err != nil {
+		utils.LogErrorCtx(ctx, "WebhookWorker", err, "Failed to parse invoice data")
+		return
+	}
+
+	if webhookInvoiceCollection == nil {
+		return
+	}
+
+	if err := ApplyStripeInvoice(ctx, webhookInvoiceCollection, &inv, time.Unix(event.Created, 0)); err != nil {
+		utils.LogErrorCtx(ctx, "WebhookWorker", err, "Failed to save invoice: "+utils.MaskStripeID(inv.ID))
+	}
+}
+
+// handleChargeRefunded はcharge.refundedイベントの返金額を請求書に反映し、利用者に通知する
+func handleChargeRefunded(ctx context.Context, event stripe.Event) {
+	var charge stripe.Charge
+	if err := json.Unmarshal(event.Data.Raw, &charge); err != nil {
+		utils.LogErrorCtx(ctx, "WebhookWorker", err, "Failed to parse charge data")
+		return
+	}
+
+	utils.LogInfoCtx(ctx, "WebhookWorker",
+		fmt.Sprintf("Charge refunded: %s, Refunded: %d/%d", utils.MaskStripeID(charge.ID), charge.AmountRefunded, charge.Amount))
+
+	if webhookInvoiceCollection == nil {
+		return
+	}
+
+	notice, err := ApplyStripeChargeRefund(ctx, webhookInvoiceCollection, &charge)
+	if err != nil {
+		utils.LogErrorCtx(ctx, "WebhookWorker", err, "Failed to save refunded amount: "+utils.MaskStripeID(charge.ID))
+		return
+	}
+	if notice != nil {
+		NotifyRefund(ctx, webhookInvoiceCollection.Database(), *notice)
+	}
+}
+
+// handleRefundLedger はrefund.*イベントの返金を請求書に反映する
+func handleRefundLedger(ctx context.Context, event stripe.Event) {
+	var refund stripe.Refund
+	if err := json.Unmarshal(event.Data.Raw, &refund); err != nil {
+		utils.LogErrorCtx(ctx, "WebhookWorker", err, "Failed to parse refund data")
+		return
+	}
+
+	if webhookInvoiceCollection == nil {
+		return
+	}
+
+	if err := ApplyStripeRefund(ctx, webhookInvoiceCollection, &refund); err != nil {
+		utils.LogErrorCtx(ctx, "WebhookWorker", err, "Failed to save refund: "+utils.MaskStripeID(refund.ID))
+	}
+}
+
+// handleCreditNoteLedger はcredit_note.*イベントのクレジットノートを請求書に反映する
+func handleCreditNoteLedger(ctx context.Context, event stripe.Event) {
+	var cn stripe.CreditNote
+	if err := json.Unmarshal(event.Data.Raw, &cn); err != nil {
+		utils.LogErrorCtx(ctx, "WebhookWorker", err, "Failed to parse credit note data")
+		return
+	}
+
+	if webhookInvoiceCollection == nil {
+		return
+	}
+
+	if err := ApplyStripeCreditNote(ctx, webhookInvoiceCollection, &cn); err != nil {
+		utils.LogErrorCtx(ctx, "WebhookWorker", err, "Failed to save credit note: "+utils.MaskStripeID(cn.ID))
+	}
+}
+
 // handleInvoicePaid はinvoice.paidイベントを処理
 func handleInvoicePaid(ctx context.Context, event stripe.Event) {
 	var inv stripe.Invoice
@@ -326,16 +496,11 @@ func handleInvoicePaymentFailed(ctx context.Context, event stripe.Event) {
 		return
 	}
 
-	update := bson.M{
-		"$set": bson.M{
-			"status":     "past_due",
-			"updated_at": time.Now(),
-		},
-	}
-	_, err := webhookSubscriptionCollection.UpdateOne(ctx, bson.M{"stripe_subscription_id": inv.Subscription.ID}, update)
-	if err != nil {
+	if err := MarkSubscriptionPastDue(ctx, webhookSubscriptionCollection, inv.Subscription.ID); err != nil {
 		utils.LogErrorCtx(ctx, "WebhookWorker", err, "Failed to update subscription status after payment failure")
+		return
 	}
+	PublishSubscriptionChange(ctx, webhookSubscriptionCollection, bson.M{"stripe_subscription_id": inv.Subscription.ID})
 }
 
 // handleInvoiceUpcoming はinvoice.upcomingイベントを処理
@@ -396,17 +561,30 @@ func handlePaymentIntentFailed(ctx context.Context, event stripe.Event) {
 			utils.MaskStripeID(pi.ID), pi.Amount, errorMsg))
 }
 
-// handleDisputeCreated はcharge.dispute.createdイベントを処理
-func handleDisputeCreated(ctx context.Context, event stripe.Event) {
+// handleDispute はcharge.dispute.*イベントの異議申し立てを disputes コレクションに反映し、
+// 新しい申し立てと結果の確定を管理者に通知する
+func handleDispute(ctx context.Context, event stripe.Event) {
 	var dispute stripe.Dispute
 	if err := json.Unmarshal(event.Data.Raw, &dispute); err != nil {
 		utils.LogErrorCtx(ctx, "WebhookWorker", err, "Failed to parse dispute data")
 		return
 	}
 
-	utils.LogErrorCtx(ctx, "WebhookWorker", nil,
-		fmt.Sprintf("ALERT: Dispute created! ID: %s, Amount: %d, Reason: %s",
-			utils.MaskStripeID(dispute.ID), dispute.Amount, dispute.Reason))
+	utils.LogInfoCtx(ctx, "WebhookWorker",
+		fmt.Sprintf("Dispute %s: %s, Amount: %d, Reason: %s, Status: %s",
+			event.Type, utils.MaskStripeID(dispute.ID), dispute.Amount, dispute.Reason, dispute.Status))
 
-	// TODO: 管理者にアラート通知を送信
+	if webhookInvoiceCollection == nil {
+		return
+	}
+
+	database := webhookInvoiceCollection.Database()
+	update, err := ApplyStripeDispute(ctx, database.Collection("disputes"), &dispute, time.Unix(event.Created, 0))
+	if err != nil {
+		utils.LogErrorCtx(ctx, "WebhookWorker", err, "Failed to save dispute: "+utils.MaskStripeID(dispute.ID))
+		return
+	}
+	if update != nil {
+		NotifyDispute(ctx, database, *update)
+	}
 }
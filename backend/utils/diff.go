@@ -0,0 +1,108 @@
+package utils
+
+import "strings"
+
+// 行単位の差分の種類
+const (
+	DiffEqual  = "equal"
+	DiffInsert = "insert"
+	DiffDelete = "delete"
+)
+
+// maxDiffCells は LCS の表の大きさの上限（超える場合は変更部分全体を削除・追加として扱う）
+const maxDiffCells = 4_000_000
+
+// DiffLine は差分の1行
+type DiffLine struct {
+	Op   string `json:"op"`
+	Text string `json:"text"`
+}
+
+// DiffLines は2つのテキストの行単位の差分を最長共通部分列（LCS）で求める
+func DiffLines(before, after string) []DiffLine {
+	a := splitDiffLines(before)
+	b := splitDiffLines(after)
+
+	// 先頭と末尾の共通部分は LCS の計算から除く
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	result := make([]DiffLine, 0, len(a)+len(b))
+	for _, line := range a[:prefix] {
+		result = append(result, DiffLine{Op: DiffEqual, Text: line})
+	}
+	result = append(result, diffMiddle(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+	for _, line := range a[len(a)-suffix:] {
+		result = append(result, DiffLine{Op: DiffEqual, Text: line})
+	}
+	return result
+}
+
+func splitDiffLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+func diffMiddle(a, b []string) []DiffLine {
+	n, m := len(a), len(b)
+	if n == 0 || m == 0 || n*m > maxDiffCells {
+		result := make([]DiffLine, 0, n+m)
+		for _, line := range a {
+			result = append(result, DiffLine{Op: DiffDelete, Text: line})
+		}
+		for _, line := range b {
+			result = append(result, DiffLine{Op: DiffInsert, Text: line})
+		}
+		return result
+	}
+
+	// lcs[i][j] は a[i:] と b[j:] の LCS の長さ
+	lcs := make([][]int32, n+1)
+	for i := range lcs {
+		lcs[i] = make([]int32, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	result := make([]DiffLine, 0, n+m)
+	i, j := 0, 0
+	for i < n && j < m {
+		switch {
+		case a[i] == b[j]:
+			result = append(result, DiffLine{Op: DiffEqual, Text: a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			result = append(result, DiffLine{Op: DiffDelete, Text: a[i]})
+			i++
+		default:
+			result = append(result, DiffLine{Op: DiffInsert, Text: b[j]})
+			j++
+		}
+	}
+	for ; i < n; i++ {
+		result = append(result, DiffLine{Op: DiffDelete, Text: a[i]})
+	}
+	for ; j < m; j++ {
+		result = append(result, DiffLine{Op: DiffInsert, Text: b[j]})
+	}
+	return result
+}
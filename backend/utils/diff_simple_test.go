@@ -0,0 +1,65 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func diffOps(lines []DiffLine) string {
+	var b strings.Builder
+	for _, line := range lines {
+		switch line.Op {
+		case DiffEqual:
+			b.WriteString(" " + line.Text + "\n")
+		case DiffInsert:
+			b.WriteString("+" + line.Text + "\n")
+		case DiffDelete:
+			b.WriteString("-" + line.Text + "\n")
+		}
+	}
+	return b.String()
+}
+
+// TestDiffLines は行単位の差分をテストする
+func TestDiffLines(t *testing.T) {
+	cases := []struct {
+		name     string
+		before   string
+		after    string
+		expected string
+	}{
+		{"変更なし", "a\nb", "a\nb", " a\n b\n"},
+		{"追加", "a\nc", "a\nb\nc", " a\n+b\n c\n"},
+		{"削除", "a\nb\nc", "a\nc", " a\n-b\n c\n"},
+		{"置換", "a\nb\nc", "a\nx\nc", " a\n-b\n+x\n c\n"},
+		{"空から作成", "", "a\nb", "+a\n+b\n"},
+		{"すべて削除", "a\nb", "", "-a\n-b\n"},
+		{"並べ替え", "a\nb\nc\nd", "b\na\nc\nd", "-a\n b\n+a\n c\n d\n"},
+		{"末尾の改行とCRLFは区別しない", "a\r\nb\r\n", "a\nb", " a\n b\n"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, diffOps(DiffLines(tc.before, tc.after)))
+		})
+	}
+}
+
+// TestDiffLinesReconstructs は差分から変更前後のテキストを復元できることをテストする
+func TestDiffLinesReconstructs(t *testing.T) {
+	before := "見出し\n本文1\n本文2\n本文3\n署名"
+	after := "見出し\n本文1（修正）\n本文2\n追記\n本文3"
+
+	var a, b []string
+	for _, line := range DiffLines(before, after) {
+		if line.Op != DiffInsert {
+			a = append(a, line.Text)
+		}
+		if line.Op != DiffDelete {
+			b = append(b, line.Text)
+		}
+	}
+	assert.Equal(t, before, strings.Join(a, "\n"))
+	assert.Equal(t, after, strings.Join(b, "\n"))
+}
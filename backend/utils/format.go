@@ -0,0 +1,23 @@
+package utils
+
+import (
+	"strconv"
+	"strings"
+)
+
+// FormatAmount は通貨の最小単位の金額を表示用に整形する（JPY は ￥1,234）
+// 半角の ¥ はフォントによって幅が異なるため全角の￥を使う
+func FormatAmount(amount int64, currency string) string {
+	digits := strconv.FormatInt(amount, 10)
+	sign := ""
+	if strings.HasPrefix(digits, "-") {
+		sign, digits = "-", digits[1:]
+	}
+	for i := len(digits) - 3; i > 0; i -= 3 {
+		digits = digits[:i] + "," + digits[i:]
+	}
+	if currency == "" || strings.EqualFold(currency, "jpy") {
+		return sign + "￥" + digits
+	}
+	return sign + digits + " " + strings.ToUpper(currency)
+}
@@ -0,0 +1,16 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestFormatAmount は金額の表示をテストする
+func TestFormatAmount(t *testing.T) {
+	assert.Equal(t, "￥0", FormatAmount(0, "jpy"))
+	assert.Equal(t, "￥980", FormatAmount(980, "jpy"))
+	assert.Equal(t, "￥1,234,567", FormatAmount(1234567, "jpy"))
+	assert.Equal(t, "-￥1,000", FormatAmount(-1000, ""))
+	assert.Equal(t, "1,500 USD", FormatAmount(1500, "usd"))
+}
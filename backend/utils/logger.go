@@ -36,7 +36,8 @@ func WithCorrelation(ctx context.Context, correlationID string) context.Context
 	return context.WithValue(ctx, correlationIDKey, correlationID)
 }
 
-func correlationIDFromContext(ctx context.Context) string {
+// CorrelationIDFromContext はcontextに紐付いたCorrelation-IDを返す
+func CorrelationIDFromContext(ctx context.Context) string {
 	if ctx == nil {
 		return ""
 	}
@@ -48,7 +49,7 @@ func correlationIDFromContext(ctx context.Context) string {
 
 func logSafeWithContext(ctx context.Context, format string, v ...interface{}) {
 	message := fmt.Sprintf(format, v...)
-	if cid := correlationIDFromContext(ctx); cid != "" {
+	if cid := CorrelationIDFromContext(ctx); cid != "" {
 		message = fmt.Sprintf("[cid=%s] %s", cid, message)
 	}
 	maskedMessage := MaskPII(message)
@@ -0,0 +1,569 @@
+package utils
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+)
+
+// maxMarkdownDepth は引用・リスト・強調の入れ子の上限（超えた部分はテキストとして扱う）
+const maxMarkdownDepth = 8
+
+var (
+	markdownHeading = regexp.MustCompile(`^ {0,3}(#{1,6})(?:[ \t]+(.*?))?(?:[ \t]+#+)?[ \t]*$`)
+	markdownBullet  = regexp.MustCompile(`^( {0,3})([-*+])([ \t]+|$)`)
+	markdownOrdered = regexp.MustCompile(`^( {0,3})([0-9]{1,9})([.)])([ \t]+|$)`)
+	markdownFence   = regexp.MustCompile("^ {0,3}(`{3,}|~{3,})[ \t]*([^`\\s]*)")
+	markdownQuote   = regexp.MustCompile(`^ {0,3}> ?`)
+)
+
+// RenderMarkdown は Markdown を HTML に変換し、SanitizeHTML で無害化して返す
+// 対応する記法は見出し・段落・改行・強調・打ち消し線・コード・引用・リスト・区切り線・リンク・画像
+// HTML タグの直接記述には対応せず、すべてエスケープして表示する
+func RenderMarkdown(source string) string {
+	source = strings.ReplaceAll(source, "\r\n", "\n")
+	source = strings.ReplaceAll(source, "\r", "\n")
+	source = strings.ReplaceAll(source, "\x00", "\uFFFD")
+
+	var b strings.Builder
+	renderMarkdownBlocks(&b, strings.Split(source, "\n"), 0, false)
+	return SanitizeHTML(b.String())
+}
+
+// renderMarkdownBlocks は行の並びをブロック要素として出力する
+// tight の場合（空行を含まないリスト項目）は段落を <p> で囲まない
+func renderMarkdownBlocks(b *strings.Builder, lines []string, depth int, tight bool) {
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		if isBlankLine(line) {
+			i++
+			continue
+		}
+
+		if m := markdownFence.FindStringSubmatch(line); m != nil {
+			i = renderCodeBlock(b, lines, i, m[1], m[2])
+			continue
+		}
+		if m := markdownHeading.FindStringSubmatch(line); m != nil {
+			level := strconv.Itoa(len(m[1]))
+			b.WriteString("<h" + level + ">" + renderMarkdownInline(m[2], 0) + "</h" + level + ">\n")
+			i++
+			continue
+		}
+		if isThematicBreak(line) {
+			b.WriteString("<hr>\n")
+			i++
+			continue
+		}
+		if depth < maxMarkdownDepth && markdownQuote.MatchString(line) {
+			var inner []string
+			for i < len(lines) && markdownQuote.MatchString(lines[i]) {
+				inner = append(inner, markdownQuote.ReplaceAllString(lines[i], ""))
+				i++
+			}
+			b.WriteString("<blockquote>\n")
+			renderMarkdownBlocks(b, inner, depth+1, false)
+			b.WriteString("</blockquote>\n")
+			continue
+		}
+		if depth < maxMarkdownDepth {
+			if marker, ok := parseListMarker(line); ok {
+				i = renderList(b, lines, i, marker, depth)
+				continue
+			}
+		}
+
+		// 段落: 空行または他のブロックの開始までを1つの段落とし、改行は <br> として残す
+		start := i
+		for i++; i < len(lines) && !isBlankLine(lines[i]) && !startsMarkdownBlock(lines[i]); i++ {
+		}
+		rendered := make([]string, 0, i-start)
+		for _, paragraphLine := range lines[start:i] {
+			rendered = append(rendered, renderMarkdownInline(strings.TrimSpace(paragraphLine), 0))
+		}
+		if tight {
+			b.WriteString(strings.Join(rendered, "<br>\n") + "\n")
+		} else {
+			b.WriteString("<p>" + strings.Join(rendered, "<br>\n") + "</p>\n")
+		}
+	}
+}
+
+func isBlankLine(line string) bool {
+	return strings.TrimSpace(line) == ""
+}
+
+// startsMarkdownBlock は段落を中断する行かどうかを返す
+// "2024. 年度" のような文が番号付きリストにならないよう、段落の途中では 1 から始まる番号のみをリストとみなす
+func startsMarkdownBlock(line string) bool {
+	if markdownFence.MatchString(line) || markdownHeading.MatchString(line) || isThematicBreak(line) || markdownQuote.MatchString(line) {
+		return true
+	}
+	marker, ok := parseListMarker(line)
+	return ok && strings.TrimSpace(marker.content) != "" && (!marker.ordered || marker.start == 1)
+}
+
+// isThematicBreak は "---" "***" "___"（間の空白は可）の区切り線かどうかを返す
+func isThematicBreak(line string) bool {
+	trimmed := strings.TrimLeft(line, " ")
+	if len(line)-len(trimmed) > 3 || trimmed == "" {
+		return false
+	}
+	marker := trimmed[0]
+	if marker != '-' && marker != '*' && marker != '_' {
+		return false
+	}
+	count := 0
+	for i := 0; i < len(trimmed); i++ {
+		switch trimmed[i] {
+		case marker:
+			count++
+		case ' ', '\t':
+		default:
+			return false
+		}
+	}
+	return count >= 3
+}
+
+// renderCodeBlock はフェンスで囲まれたコードブロックを出力し、次の行の位置を返す
+// 閉じるフェンスがない場合は文書の終わりまでをコードとして扱う
+func renderCodeBlock(b *strings.Builder, lines []string, start int, fence, language string) int {
+	var code strings.Builder
+	i := start + 1
+	for ; i < len(lines); i++ {
+		trimmed := strings.TrimSpace(lines[i])
+		if strings.HasPrefix(trimmed, fence) && strings.Trim(trimmed, fence[:1]) == "" {
+			i++
+			break
+		}
+		code.WriteString(lines[i] + "\n")
+	}
+
+	b.WriteString("<pre><code")
+	if language != "" {
+		b.WriteString(` class="language-` + html.EscapeString(language) + `"`)
+	}
+	b.WriteString(">" + html.EscapeString(code.String()) + "</code></pre>\n")
+	return i
+}
+
+// listMarker はリスト項目の記号と本文の開始位置
+type listMarker struct {
+	ordered   bool
+	delimiter string
+	start     int
+	indent    int
+	content   string
+}
+
+func parseListMarker(line string) (listMarker, bool) {
+	if isThematicBreak(line) {
+		return listMarker{}, false
+	}
+	if m := markdownBullet.FindStringSubmatch(line); m != nil {
+		return listMarker{delimiter: m[2], indent: len(m[0]), content: line[len(m[0]):]}, true
+	}
+	if m := markdownOrdered.FindStringSubmatch(line); m != nil {
+		start, _ := strconv.Atoi(m[2])
+		return listMarker{ordered: true, delimiter: m[3], start: start, indent: len(m[0]), content: line[len(m[0]):]}, true
+	}
+	return listMarker{}, false
+}
+
+// renderList は同じ種類の記号が続く範囲をリストとして出力し、次の行の位置を返す
+// 項目の本文より深くインデントされた行は項目の続き（入れ子のリストなど）として扱う
+func renderList(b *strings.Builder, lines []string, start int, first listMarker, depth int) int {
+	var items [][]string
+	loose := false
+	i := start
+	for i < len(lines) {
+		marker, ok := parseListMarker(lines[i])
+		if !ok || marker.ordered != first.ordered || marker.delimiter != first.delimiter {
+			break
+		}
+		item := []string{marker.content}
+		indent := marker.indent
+		i++
+
+		for i < len(lines) {
+			line := lines[i]
+			if isBlankLine(line) {
+				// 空行の後に続きの行（同じ項目）か次の項目があればリストを継続する
+				next := i + 1
+				for next < len(lines) && isBlankLine(lines[next]) {
+					next++
+				}
+				if next >= len(lines) {
+					i = next
+					break
+				}
+				if leadingSpaces(lines[next]) >= indent {
+					loose = true
+					item = append(item, "")
+					i++
+					continue
+				}
+				if nextMarker, ok := parseListMarker(lines[next]); ok && nextMarker.ordered == first.ordered && nextMarker.delimiter == first.delimiter {
+					loose = true
+					i = next
+				}
+				break
+			}
+			if leadingSpaces(line) >= indent {
+				item = append(item, dedent(line, indent))
+				i++
+				continue
+			}
+			if nextMarker, ok := parseListMarker(line); ok && nextMarker.ordered == first.ordered && nextMarker.delimiter == first.delimiter {
+				break
+			}
+			if startsMarkdownBlock(line) {
+				break
+			}
+			// 段落の続き（インデントなし）は同じ項目に含める
+			item = append(item, line)
+			i++
+		}
+		items = append(items, item)
+
+		if i < len(lines) && isBlankLine(lines[i]) {
+			break
+		}
+	}
+
+	if first.ordered {
+		if first.start != 1 {
+			b.WriteString(`<ol start="` + strconv.Itoa(first.start) + `">` + "\n")
+		} else {
+			b.WriteString("<ol>\n")
+		}
+	} else {
+		b.WriteString("<ul>\n")
+	}
+	for _, item := range items {
+		b.WriteString("<li>")
+		renderMarkdownBlocks(b, item, depth+1, !loose)
+		b.WriteString("</li>\n")
+	}
+	if first.ordered {
+		b.WriteString("</ol>\n")
+	} else {
+		b.WriteString("</ul>\n")
+	}
+	return i
+}
+
+// dedent は行頭から最大 n 文字分の空白を取り除く（タブは4文字として数える）
+func dedent(line string, n int) string {
+	width := 0
+	for i, r := range line {
+		if width >= n || (r != ' ' && r != '\t') {
+			return line[i:]
+		}
+		if r == '\t' {
+			width += 4
+		} else {
+			width++
+		}
+	}
+	return ""
+}
+
+// leadingSpaces は行頭の空白の数を返す（タブは4文字として数える）
+func leadingSpaces(line string) int {
+	n := 0
+	for _, r := range line {
+		switch r {
+		case ' ':
+			n++
+		case '\t':
+			n += 4
+		default:
+			return n
+		}
+	}
+	return n
+}
+
+// renderMarkdownInline は1行分のインライン要素を HTML に変換する
+func renderMarkdownInline(text string, depth int) string {
+	return renderInline(text, depth, false)
+}
+
+// renderInline はインライン要素を出力する。inLink の場合（リンクの表示文字列）はリンクを入れ子にしない
+func renderInline(text string, depth int, inLink bool) string {
+	if depth >= maxMarkdownDepth {
+		return html.EscapeString(text)
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(text); {
+		c := text[i]
+		switch {
+		case c == '\\' && i+1 < len(text) && isASCIIPunct(text[i+1]):
+			b.WriteString(html.EscapeString(text[i+1 : i+2]))
+			i += 2
+			continue
+
+		case c == '`':
+			if code, next, ok := renderCodeSpan(text, i); ok {
+				b.WriteString(code)
+				i = next
+				continue
+			}
+
+		case c == '!' && i+1 < len(text) && text[i+1] == '[':
+			if label, dest, title, next, ok := parseInlineLink(text, i+1); ok {
+				if src, safe := SafeURL(dest, imageURLSchemes); safe {
+					b.WriteString(`<img src="` + html.EscapeString(src) + `" alt="` + html.EscapeString(label) + `"`)
+					if title != "" {
+						b.WriteString(` title="` + html.EscapeString(title) + `"`)
+					}
+					b.WriteString(">")
+				} else {
+					b.WriteString(html.EscapeString(label))
+				}
+				i = next
+				continue
+			}
+
+		case c == '[' && !inLink:
+			if label, dest, title, next, ok := parseInlineLink(text, i); ok {
+				inner := renderInline(label, depth+1, true)
+				if href, safe := SafeURL(dest, linkURLSchemes); safe {
+					b.WriteString(`<a href="` + html.EscapeString(href) + `"`)
+					if title != "" {
+						b.WriteString(` title="` + html.EscapeString(title) + `"`)
+					}
+					b.WriteString(">" + inner + "</a>")
+				} else {
+					b.WriteString(inner)
+				}
+				i = next
+				continue
+			}
+
+		case c == '<' && !inLink:
+			if end := strings.IndexByte(text[i:], '>'); end > 0 {
+				candidate := text[i+1 : i+end]
+				if isAutolink(candidate) {
+					b.WriteString(`<a href="` + html.EscapeString(candidate) + `">` + html.EscapeString(strings.TrimPrefix(candidate, "mailto:")) + "</a>")
+					i += end + 1
+					continue
+				}
+			}
+
+		case c == 'h' && !inLink && (strings.HasPrefix(text[i:], "https://") || strings.HasPrefix(text[i:], "http://")) && !precededByWordChar(text, i):
+			end := bareURLEnd(text, i)
+			url := text[i:end]
+			b.WriteString(`<a href="` + html.EscapeString(url) + `">` + html.EscapeString(url) + "</a>")
+			i = end
+			continue
+
+		case c == '*' || c == '_' || c == '~':
+			if rendered, next, ok := renderEmphasis(text, i, depth, inLink); ok {
+				b.WriteString(rendered)
+				i = next
+				continue
+			}
+		}
+
+		_, size := utf8.DecodeRuneInString(text[i:])
+		b.WriteString(html.EscapeString(text[i : i+size]))
+		i += size
+	}
+	return b.String()
+}
+
+func isASCIIPunct(c byte) bool {
+	return strings.IndexByte("!\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~", c) >= 0
+}
+
+// renderCodeSpan は同じ数のバッククォートで囲まれた範囲をコードとして出力する
+func renderCodeSpan(text string, start int) (string, int, bool) {
+	run := 0
+	for start+run < len(text) && text[start+run] == '`' {
+		run++
+	}
+	fence := text[start : start+run]
+	for search := start + run; search < len(text); {
+		idx := strings.Index(text[search:], fence)
+		if idx < 0 {
+			return "", 0, false
+		}
+		end := search + idx
+		// より長いバッククォートの並びは閉じ記号として扱わない
+		after := end + run
+		for after < len(text) && text[after] == '`' {
+			after++
+		}
+		if after-end == run {
+			code := text[start+run : end]
+			if len(code) > 2 && code[0] == ' ' && code[len(code)-1] == ' ' {
+				code = code[1 : len(code)-1]
+			}
+			return "<code>" + html.EscapeString(code) + "</code>", after, true
+		}
+		search = after
+	}
+	return "", 0, false
+}
+
+// parseInlineLink は [label](destination "title") を解釈する（start は '[' の位置）
+func parseInlineLink(text string, start int) (label, dest, title string, next int, ok bool) {
+	depth := 0
+	closeBracket := -1
+	for i := start; i < len(text); i++ {
+		switch text[i] {
+		case '\\':
+			i++
+		case '[':
+			depth++
+		case ']':
+			depth--
+			if depth == 0 {
+				closeBracket = i
+			}
+		}
+		if closeBracket >= 0 {
+			break
+		}
+	}
+	if closeBracket < 0 || closeBracket+1 >= len(text) || text[closeBracket+1] != '(' {
+		return "", "", "", 0, false
+	}
+
+	parens := 0
+	closeParen := -1
+	for i := closeBracket + 1; i < len(text); i++ {
+		switch text[i] {
+		case '\\':
+			i++
+		case '(':
+			parens++
+		case ')':
+			parens--
+			if parens == 0 {
+				closeParen = i
+			}
+		}
+		if closeParen >= 0 {
+			break
+		}
+	}
+	if closeParen < 0 {
+		return "", "", "", 0, false
+	}
+
+	inside := strings.TrimSpace(text[closeBracket+2 : closeParen])
+	dest = inside
+	if idx := strings.IndexAny(inside, " \t"); idx >= 0 {
+		rest := strings.TrimSpace(inside[idx:])
+		if len(rest) >= 2 && (rest[0] == '"' || rest[0] == '\'') && rest[len(rest)-1] == rest[0] {
+			dest = inside[:idx]
+			title = rest[1 : len(rest)-1]
+		}
+	}
+	dest = strings.TrimSuffix(strings.TrimPrefix(dest, "<"), ">")
+	if dest == "" || strings.ContainsAny(dest, " \t") {
+		return "", "", "", 0, false
+	}
+	return text[start+1 : closeBracket], dest, title, closeParen + 1, true
+}
+
+// isAutolink は <https://...> 形式の自動リンクかどうかを返す
+func isAutolink(candidate string) bool {
+	if strings.ContainsAny(candidate, " \t<") {
+		return false
+	}
+	lower := strings.ToLower(candidate)
+	return strings.HasPrefix(lower, "https://") || strings.HasPrefix(lower, "http://") ||
+		(strings.HasPrefix(lower, "mailto:") && strings.Contains(lower, "@"))
+}
+
+func precededByWordChar(text string, i int) bool {
+	if i == 0 {
+		return false
+	}
+	r, _ := utf8.DecodeLastRuneInString(text[:i])
+	return r == '/' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// bareURLEnd は文中の URL の終わりを返す（末尾の句読点と対応しない括弧は含めない）
+func bareURLEnd(text string, start int) int {
+	end := start
+	for end < len(text) {
+		r, size := utf8.DecodeRuneInString(text[end:])
+		if r == utf8.RuneError || unicode.IsSpace(r) || r > unicode.MaxASCII || strings.ContainsRune("<>\"`", r) {
+			break
+		}
+		end += size
+	}
+	for end > start {
+		last := text[end-1]
+		if strings.IndexByte(".,:;!?'*_~", last) >= 0 {
+			end--
+			continue
+		}
+		if last == ')' && strings.Count(text[start:end], "(") < strings.Count(text[start:end], ")") {
+			end--
+			continue
+		}
+		break
+	}
+	return end
+}
+
+// renderEmphasis は **強調** / __強調__ / *斜体* / _斜体_ / ~~打ち消し~~ を出力する
+func renderEmphasis(text string, start int, depth int, inLink bool) (string, int, bool) {
+	c := text[start]
+	delimiter := string(c)
+	tag := "em"
+	if start+1 < len(text) && text[start+1] == c {
+		delimiter = string([]byte{c, c})
+		tag = "strong"
+	}
+	if c == '~' {
+		if delimiter != "~~" {
+			return "", 0, false
+		}
+		tag = "del"
+	}
+	// snake_case のような単語中の _ は強調として扱わない
+	if c == '_' && precededByWordChar(text, start) {
+		return "", 0, false
+	}
+
+	contentStart := start + len(delimiter)
+	if contentStart >= len(text) || text[contentStart] == ' ' || text[contentStart] == c {
+		return "", 0, false
+	}
+	for search := contentStart; search < len(text); {
+		idx := strings.Index(text[search:], delimiter)
+		if idx < 0 {
+			return "", 0, false
+		}
+		end := search + idx
+		// 記号の並びの長さが開き記号と異なる場合（*a **b** c* の ** など）は閉じ記号として扱わない
+		run := 0
+		for end+run < len(text) && text[end+run] == c {
+			run++
+		}
+		closes := run == len(delimiter) && text[end-1] != ' ' && text[end-1] != '\\'
+		if closes && c == '_' && end+run < len(text) {
+			r, _ := utf8.DecodeRuneInString(text[end+run:])
+			closes = !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		}
+		if closes {
+			inner := renderInline(text[contentStart:end], depth+1, inLink)
+			return "<" + tag + ">" + inner + "</" + tag + ">", end + run, true
+		}
+		search = end + run
+	}
+	return "", 0, false
+}
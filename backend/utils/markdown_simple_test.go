@@ -0,0 +1,264 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// TestRenderMarkdownBlocks はブロック要素の変換をテストする
+func TestRenderMarkdownBlocks(t *testing.T) {
+	cases := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"段落と改行", "1行目\n2行目\n\n次の段落", "<p>1行目<br>\n2行目</p>\n<p>次の段落</p>\n"},
+		{"見出し", "## お知らせ ##", "<h2>お知らせ</h2>\n"},
+		{"ハッシュタグは見出しにしない", "#tag", "<p>#tag</p>\n"},
+		{"区切り線", "上\n\n- - -\n\n下", "<p>上</p>\n<hr>\n<p>下</p>\n"},
+		{"箇条書き", "- 一\n- 二", "<ul>\n<li>一\n</li>\n<li>二\n</li>\n</ul>\n"},
+		{"番号付きリスト", "3. 三\n4. 四", "<ol start=\"3\">\n<li>三\n</li>\n<li>四\n</li>\n</ol>\n"},
+		{"入れ子のリスト", "- 親\n  - 子", "<ul>\n<li>親\n<ul>\n<li>子\n</li>\n</ul>\n</li>\n</ul>\n"},
+		{"段落中の年は番号付きリストにしない", "開始は\n2026. 4月です", "<p>開始は<br>\n2026. 4月です</p>\n"},
+		{"引用", "> 注意\n> 事項", "<blockquote>\n<p>注意<br>\n事項</p>\n</blockquote>\n"},
+		{"コードブロック", "```go\nfmt.Println(\"<b>\")\n```", "<pre><code class=\"language-go\">fmt.Println(&#34;&lt;b&gt;&#34;)\n</code></pre>\n"},
+		{"閉じていないコードブロック", "```\na\nb", "<pre><code>a\nb\n</code></pre>\n"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, RenderMarkdown(tc.input))
+		})
+	}
+}
+
+// TestRenderMarkdownInline はインライン要素の変換をテストする
+func TestRenderMarkdownInline(t *testing.T) {
+	cases := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"強調", "**重要** と *補足* と ~~中止~~", "<p><strong>重要</strong> と <em>補足</em> と <del>中止</del></p>\n"},
+		{"入れ子の強調", "*a **b** c*", "<p><em>a <strong>b</strong> c</em></p>\n"},
+		{"単語中の_", "snake_case_name", "<p>snake_case_name</p>\n"},
+		{"コード", "`a < b` と ``x`y``", "<p><code>a &lt; b</code> と <code>x`y</code></p>\n"},
+		{"エスケープ", `\*強調しない\*`, "<p>*強調しない*</p>\n"},
+		{"リンク", `[詳細](https://example.com/a?b=1&c=2 "説明")`, `<p><a href="https://example.com/a?b=1&amp;c=2" title="説明" rel="nofollow noopener noreferrer" target="_blank">詳細</a></p>` + "\n"},
+		{"相対リンク", "[規約](/terms)", `<p><a href="/terms" rel="nofollow noopener noreferrer" target="_blank">規約</a></p>` + "\n"},
+		{"画像", "![図](https://example.com/a.png)", `<p><img src="https://example.com/a.png" alt="図"></p>` + "\n"},
+		{"自動リンク", "詳しくは https://example.com/x). まで", `<p>詳しくは <a href="https://example.com/x" rel="nofollow noopener noreferrer" target="_blank">https://example.com/x</a>). まで</p>` + "\n"},
+		{"メール", "<mailto:office@example.com>", `<p><a href="mailto:office@example.com" rel="nofollow noopener noreferrer" target="_blank">office@example.com</a></p>` + "\n"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, RenderMarkdown(tc.input))
+		})
+	}
+}
+
+// markdownXSSPayloads は XSS を狙った入力（FuzzRenderMarkdown のシードにも使う）
+var markdownXSSPayloads = []string{
+	`<script>alert(1)</script>`,
+	`<img src=x onerror=alert(1)>`,
+	`<a href="javascript:alert(1)">x</a>`,
+	`[x](javascript:alert(1))`,
+	`[x](JaVaScRiPt:alert(1))`,
+	"[x](java\tscript:alert(1))",
+	`[x](data:text/html;base64,PHNjcmlwdD5hbGVydCgxKTwvc2NyaXB0Pg==)`,
+	`[x](vbscript:msgbox(1))`,
+	`![x](javascript:alert(1))`,
+	`![x" onerror="alert(1)](https://example.com/a.png)`,
+	`[x](https://example.com/"onmouseover="alert(1))`,
+	`<javascript:alert(1)>`,
+	"```\"><script>alert(1)</script>\n```",
+	"```js\" onclick=\"alert(1)\nx\n```",
+	`<svg><script>alert(1)</script></svg>`,
+	`<iframe src="https://evil.example"></iframe>`,
+	`**<style>body{display:none}</style>**`,
+	`<<script>script>alert(1)<</script>/script>`,
+}
+
+// TestRenderMarkdownXSS は XSS を狙った入力が無害化されることをテストする
+func TestRenderMarkdownXSS(t *testing.T) {
+	for _, payload := range markdownXSSPayloads {
+		assertNoActiveContent(t, RenderMarkdown(payload), payload)
+	}
+}
+
+// assertNoActiveContent は HTML として解釈したときにスクリプトを実行しうる要素・属性・URL がないことを確認する
+func assertNoActiveContent(t *testing.T, rendered, payload string) {
+	t.Helper()
+	nodes, err := html.ParseFragment(strings.NewReader(rendered), &html.Node{Type: html.ElementNode, Data: "div", DataAtom: atom.Div})
+	assert.NoError(t, err)
+
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			_, allowed := allowedHTMLElements[n.Data]
+			assert.True(t, allowed, "要素 %s が残っている: %s", n.Data, payload)
+			for _, attr := range n.Attr {
+				assert.False(t, strings.HasPrefix(attr.Key, "on"), "属性 %s が残っている: %s", attr.Key, payload)
+				if attr.Key == "href" || attr.Key == "src" {
+					value := strings.ToLower(strings.Join(strings.Fields(attr.Val), ""))
+					for _, scheme := range []string{"javascript:", "vbscript:", "data:"} {
+						assert.False(t, strings.HasPrefix(value, scheme), "URL %s が残っている: %s", attr.Val, payload)
+					}
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	for _, n := range nodes {
+		walk(n)
+	}
+}
+
+// FuzzRenderMarkdown は任意の入力に対して、出力をHTMLとして解釈したときに
+// SanitizeHTML の許可リストにない要素・属性・URL が現れないことを確認する
+func FuzzRenderMarkdown(f *testing.F) {
+	for _, payload := range markdownXSSPayloads {
+		f.Add(payload)
+	}
+	f.Add("# 見出し\n\n**強調**と_斜体_と~~打ち消し~~と`コード`\n\n> 引用\n\n1. 項目\n2. [リンク](https://example.com \"タイトル\")\n\n---\n\n![画像](/a.png)")
+	f.Add("```go\nfmt.Println(\"<b>\")\n```\n\n- [ ] <https://example.com>\n  - 入れ子")
+
+	f.Fuzz(func(t *testing.T, source string) {
+		if msg := disallowedHTML(RenderMarkdown(source)); msg != "" {
+			t.Fatalf("%s\ninput: %q", msg, source)
+		}
+	})
+}
+
+// disallowedHTML は HTML を解釈し、SanitizeHTML の許可リストから外れる要素・属性があればその内容を返す
+func disallowedHTML(rendered string) string {
+	nodes, err := html.ParseFragment(strings.NewReader(rendered), &html.Node{Type: html.ElementNode, Data: "div", DataAtom: atom.Div})
+	if err != nil {
+		return "出力を解析できない: " + err.Error()
+	}
+
+	var check func(*html.Node) string
+	check = func(n *html.Node) string {
+		if n.Type == html.ElementNode {
+			allowedAttrs, ok := allowedHTMLElements[n.Data]
+			if !ok || n.Namespace != "" || droppedHTMLElements[n.Data] {
+				return "許可されていない要素: " + n.Data
+			}
+			for _, attr := range n.Attr {
+				if msg := disallowedAttr(n.Data, allowedAttrs, attr); msg != "" {
+					return msg
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if msg := check(c); msg != "" {
+				return msg
+			}
+		}
+		return ""
+	}
+	for _, n := range nodes {
+		if msg := check(n); msg != "" {
+			return msg
+		}
+	}
+	return ""
+}
+
+// disallowedAttr は属性が許可リストと値の制限（URLのスキーム・class・start）を満たさない場合にその内容を返す
+func disallowedAttr(element string, allowedAttrs map[string]bool, attr html.Attribute) string {
+	switch {
+	case attr.Namespace != "":
+		return "名前空間付きの属性: " + attr.Key
+	case element == "a" && attr.Key == "rel":
+		if attr.Val != "nofollow noopener noreferrer" {
+			return "rel の値: " + attr.Val
+		}
+		return ""
+	case element == "a" && attr.Key == "target":
+		if attr.Val != "_blank" {
+			return "target の値: " + attr.Val
+		}
+		return ""
+	case !allowedAttrs[attr.Key]:
+		return "許可されていない属性: " + element + " " + attr.Key
+	}
+
+	switch attr.Key {
+	case "href":
+		if _, ok := SafeURL(attr.Val, linkURLSchemes); !ok {
+			return "許可されていないリンク先: " + attr.Val
+		}
+	case "src":
+		if _, ok := SafeURL(attr.Val, imageURLSchemes); !ok {
+			return "許可されていない画像URL: " + attr.Val
+		}
+	case "class":
+		if !codeLanguageClass.MatchString(attr.Val) {
+			return "許可されていない class: " + attr.Val
+		}
+	case "start":
+		if !orderedListStart.MatchString(attr.Val) {
+			return "許可されていない start: " + attr.Val
+		}
+	}
+	return ""
+}
+
+// TestSanitizeHTML は許可リストによる無害化をテストする
+func TestSanitizeHTML(t *testing.T) {
+	cases := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"許可された要素", "<p>a<br><strong>b</strong></p>", "<p>a<br><strong>b</strong></p>"},
+		{"許可されていない要素はテキストを残す", `<div class="x"><span>本文</span></div>`, "本文"},
+		{"スクリプトは内容ごと除く", "前<script>alert(1)</script>後", "前後"},
+		{"イベント属性を除く", `<p onclick="alert(1)" style="color:red">a</p>`, "<p>a</p>"},
+		{"危険なURLの属性を除く", `<a href="javascript:alert(1)">a</a>`, "<a>a</a>"},
+		{"文字参照で隠したURL", `<a href="javascript&#58;alert(1)">a</a>`, "<a>a</a>"},
+		{"制御文字で隠したURL", "<a href=\"jav&#x09;ascript:alert(1)\">a</a>", "<a>a</a>"},
+		{"画像のdata URL", `<img src="data:image/svg+xml,<svg onload=alert(1)>">`, "<img>"},
+		{"コメント", "a<!-- <script> -->b", "ab"},
+		{"言語指定以外のclass", `<code class="x onclick">a</code>`, "<code>a</code>"},
+		{"閉じタグのない要素", "<strong>a", "<strong>a</strong>"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, SanitizeHTML(tc.input))
+		})
+	}
+}
+
+// TestPlainTextExcerpt は HTML からの抜粋の生成をテストする
+func TestPlainTextExcerpt(t *testing.T) {
+	source := RenderMarkdown("# 春期講習\n\n**申込期限**は[こちら](https://example.com)を確認\n\n- 1日目\n- 2日目")
+	assert.Equal(t, "春期講習 申込期限はこちらを確認 1日目 2日目", PlainTextExcerpt(source, 0))
+	assert.Equal(t, "春期講習 申込…", PlainTextExcerpt(source, 7))
+	assert.Equal(t, "前後", PlainTextExcerpt("前<script>alert(1)</script>後", 100))
+}
+
+// TestRenderMarkdownPathologicalInput は閉じ記号のない入力や深い入れ子でも短時間で処理できることをテストする
+func TestRenderMarkdownPathologicalInput(t *testing.T) {
+	inputs := []string{
+		strings.Repeat("[", 20000),
+		strings.Repeat("*a", 10000),
+		strings.Repeat("`", 20000),
+		strings.Repeat("> ", 5000) + "深い引用",
+		strings.Repeat("- ", 5000) + "深いリスト",
+		strings.Repeat("**", 10000),
+	}
+	for _, input := range inputs {
+		start := time.Now()
+		rendered := RenderMarkdown(input)
+		assert.NotEmpty(t, rendered)
+		assert.Less(t, time.Since(start), 2*time.Second, input[:10])
+	}
+}
@@ -0,0 +1,147 @@
+package utils
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// A4 の用紙サイズ（pt）
+const (
+	PDFPageWidth  = 595.28
+	PDFPageHeight = 841.89
+)
+
+// pdfFontName は PDF ビューアが標準で持つ日本語フォント（Adobe-Japan1）
+// フォントを埋め込まないため、ファイルサイズを小さく保てる
+const pdfFontName = "HeiseiKakuGo-W5"
+
+// PDFDocument は日本語のテキストと罫線だけで構成する簡易的な PDF を作成する（領収書などの帳票用）
+// 座標は用紙の左上を原点とし、y は下向きに増える（PDF の座標系への変換は内部で行う）
+type PDFDocument struct {
+	pages   []*bytes.Buffer
+	title   string
+	created time.Time
+}
+
+// NewPDFDocument は1ページ目を追加した PDF を作成する
+func NewPDFDocument(title string, created time.Time) *PDFDocument {
+	d := &PDFDocument{title: title, created: created}
+	d.AddPage()
+	return d
+}
+
+// AddPage は新しいページを追加し、以降の描画先にする
+func (d *PDFDocument) AddPage() {
+	d.pages = append(d.pages, &bytes.Buffer{})
+}
+
+func (d *PDFDocument) page() *bytes.Buffer {
+	return d.pages[len(d.pages)-1]
+}
+
+// PDFTextWidth は文字列を指定サイズで描画したときの幅を返す
+// 半角文字は全角の半分の幅で描画される
+func PDFTextWidth(text string, size float64) float64 {
+	var em float64
+	for _, r := range text {
+		if r < 0x80 || (r >= 0xFF61 && r <= 0xFF9F) {
+			em += 0.5
+		} else {
+			em += 1
+		}
+	}
+	return em * size
+}
+
+// Text は (x, y) を左端・ベースラインとして文字列を描画する
+func (d *PDFDocument) Text(x, y, size float64, text string) {
+	fmt.Fprintf(d.page(), "BT /F1 %s Tf %s %s Td <%s> Tj ET\n",
+		pdfNumber(size), pdfNumber(x), pdfNumber(PDFPageHeight-y), pdfHexString(text))
+}
+
+// TextRight は x を右端として文字列を描画する
+func (d *PDFDocument) TextRight(x, y, size float64, text string) {
+	d.Text(x-PDFTextWidth(text, size), y, size, text)
+}
+
+// TextCenter は x を中央として文字列を描画する
+func (d *PDFDocument) TextCenter(x, y, size float64, text string) {
+	d.Text(x-PDFTextWidth(text, size)/2, y, size, text)
+}
+
+// Line は (x1, y1) から (x2, y2) に線を引く
+func (d *PDFDocument) Line(x1, y1, x2, y2, width float64) {
+	fmt.Fprintf(d.page(), "%s w %s %s m %s %s l S\n", pdfNumber(width),
+		pdfNumber(x1), pdfNumber(PDFPageHeight-y1), pdfNumber(x2), pdfNumber(PDFPageHeight-y2))
+}
+
+// Rect は (x, y) を左上とする矩形の枠を描く
+func (d *PDFDocument) Rect(x, y, w, h, width float64) {
+	fmt.Fprintf(d.page(), "%s w %s %s %s %s re S\n", pdfNumber(width),
+		pdfNumber(x), pdfNumber(PDFPageHeight-y-h), pdfNumber(w), pdfNumber(h))
+}
+
+// Bytes は PDF のファイルの内容を返す
+func (d *PDFDocument) Bytes() []byte {
+	var out bytes.Buffer
+	var offsets []int
+	object := func(body string) {
+		offsets = append(offsets, out.Len())
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	// 1: カタログ, 2: ページツリー, 3-5: フォント, 6: 文書情報, 7以降: ページと内容
+	const firstPageObject = 7
+	out.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	kids := make([]string, len(d.pages))
+	for i := range d.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", firstPageObject+i*2)
+	}
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(d.pages)))
+	object(fmt.Sprintf("<< /Type /Font /Subtype /Type0 /BaseFont /%s-UniJIS-UCS2-HW-H /Encoding /UniJIS-UCS2-HW-H /DescendantFonts [4 0 R] >>", pdfFontName))
+	object(fmt.Sprintf("<< /Type /Font /Subtype /CIDFontType0 /BaseFont /%s /CIDSystemInfo << /Registry (Adobe) /Ordering (Japan1) /Supplement 2 >> /FontDescriptor 5 0 R /DW 1000 /W [1 95 500 231 632 500] >>", pdfFontName))
+	object(fmt.Sprintf("<< /Type /FontDescriptor /FontName /%s /Flags 6 /FontBBox [-92 -250 1010 922] /ItalicAngle 0 /Ascent 752 /Descent -221 /CapHeight 737 /StemV 0 >>", pdfFontName))
+	object(fmt.Sprintf("<< /Title <%s> /Producer (Juice Academy) /CreationDate (D:%s) >>",
+		"FEFF"+pdfHexString(d.title), d.created.UTC().Format("20060102150405Z")))
+
+	for i, content := range d.pages {
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %s %s] /Resources << /Font << /F1 3 0 R >> >> /Contents %d 0 R >>",
+			pdfNumber(PDFPageWidth), pdfNumber(PDFPageHeight), firstPageObject+i*2+1))
+
+		var compressed bytes.Buffer
+		w := zlib.NewWriter(&compressed)
+		_, _ = w.Write(content.Bytes())
+		_ = w.Close()
+		object(fmt.Sprintf("<< /Length %d /Filter /FlateDecode >>\nstream\n%s\nendstream", compressed.Len(), compressed.String()))
+	}
+
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R /Info 6 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+	return out.Bytes()
+}
+
+// pdfHexString は文字列を UCS-2（UTF-16BE）の16進数表記にする
+// フォントの CMap が UCS-2 のため、基本多言語面の外の文字は〓に置き換える
+func pdfHexString(text string) string {
+	var b strings.Builder
+	for _, r := range text {
+		if r > 0xFFFF || utf16.IsSurrogate(r) {
+			r = '〓'
+		}
+		fmt.Fprintf(&b, "%04X", r)
+	}
+	return b.String()
+}
+
+func pdfNumber(v float64) string {
+	return strings.TrimRight(strings.TrimRight(fmt.Sprintf("%.2f", v), "0"), ".")
+}
@@ -0,0 +1,69 @@
+package utils
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestPDFDocument は作成した PDF の構造（相互参照表とページの内容）をテストする
+func TestPDFDocument(t *testing.T) {
+	doc := NewPDFDocument("領収書 R-2026-000001", time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC))
+	doc.Text(50, 80, 12, "領収書")
+	doc.Rect(50, 100, 200, 40, 1)
+	doc.AddPage()
+	doc.TextRight(545, 80, 9, "No. 1")
+	out := doc.Bytes()
+
+	require.True(t, bytes.HasPrefix(out, []byte("%PDF-1.4\n")))
+	require.True(t, bytes.HasSuffix(out, []byte("%%EOF\n")))
+	assert.Contains(t, string(out), "/Count 2")
+	assert.Contains(t, string(out), "/CreationDate (D:20260401000000Z)")
+
+	// startxref と相互参照表の各オフセットがオブジェクトの先頭を指していること
+	matches := regexp.MustCompile(`startxref\n(\d+)\n`).FindSubmatch(out)
+	require.NotNil(t, matches)
+	xref, err := strconv.Atoi(string(matches[1]))
+	require.NoError(t, err)
+	require.True(t, bytes.HasPrefix(out[xref:], []byte("xref\n")))
+	entries := regexp.MustCompile(`(\d{10}) 00000 n `).FindAllSubmatch(out[xref:], -1)
+	require.Len(t, entries, 10, "カタログ・ページツリー・フォント3つ・文書情報と2ページ分")
+	for i, entry := range entries {
+		offset, err := strconv.Atoi(string(entry[1]))
+		require.NoError(t, err)
+		assert.True(t, bytes.HasPrefix(out[offset:], []byte(fmt.Sprintf("%d 0 obj\n", i+1))), "object %d", i+1)
+	}
+
+	// 1ページ目の内容を展開すると UCS-2 の文字列と PDF の座標系の位置で描画されていること
+	stream := regexp.MustCompile(`(?s)stream\n(.*?)\nendstream`).FindSubmatch(out)
+	require.NotNil(t, stream)
+	r, err := zlib.NewReader(bytes.NewReader(stream[1]))
+	require.NoError(t, err)
+	content, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Contains(t, string(content), "BT /F1 12 Tf 50 761.89 Td <981853CE66F8> Tj ET")
+	assert.Contains(t, string(content), "1 w 50 701.89 200 40 re S")
+}
+
+// TestPDFText は文字幅の計算と文字列のエンコードをテストする
+func TestPDFText(t *testing.T) {
+	assert.Equal(t, 30.0, PDFTextWidth("領収書", 10))
+	assert.Equal(t, 15.0, PDFTextWidth("No.", 10), "半角は全角の半分の幅")
+	assert.Equal(t, 10.0, PDFTextWidth("ｱｲ", 10), "半角カナも半分の幅")
+
+	assert.Equal(t, "00410042", pdfHexString("AB"))
+	assert.Equal(t, "FFE5", pdfHexString("￥"))
+	assert.Equal(t, "3013", pdfHexString("𠮷"), "UCS-2 で表せない文字は〓にする")
+
+	assert.Equal(t, "0", pdfNumber(0))
+	assert.Equal(t, "100", pdfNumber(100))
+	assert.Equal(t, "595.28", pdfNumber(PDFPageWidth))
+}
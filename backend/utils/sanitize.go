@@ -0,0 +1,213 @@
+package utils
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// allowedHTMLElements は SanitizeHTML が残す要素と、その要素に許可する属性
+var allowedHTMLElements = map[string]map[string]bool{
+	"p":          {},
+	"br":         {},
+	"hr":         {},
+	"h1":         {},
+	"h2":         {},
+	"h3":         {},
+	"h4":         {},
+	"h5":         {},
+	"h6":         {},
+	"strong":     {},
+	"em":         {},
+	"del":        {},
+	"code":       {"class": true},
+	"pre":        {},
+	"blockquote": {},
+	"ul":         {},
+	"ol":         {"start": true},
+	"li":         {},
+	"a":          {"href": true, "title": true},
+	"img":        {"src": true, "alt": true, "title": true},
+}
+
+// droppedHTMLElements は内容ごと取り除く要素（許可されていない要素は通常タグだけを除き、テキストを残す）
+var droppedHTMLElements = map[string]bool{
+	"script":   true,
+	"style":    true,
+	"iframe":   true,
+	"object":   true,
+	"embed":    true,
+	"noscript": true,
+	"template": true,
+	"textarea": true,
+	"title":    true,
+	"xmp":      true,
+	"noembed":  true,
+	"noframes": true,
+	"svg":      true,
+	"math":     true,
+}
+
+var voidHTMLElements = map[string]bool{"br": true, "hr": true, "img": true}
+
+var (
+	linkURLSchemes  = map[string]bool{"http": true, "https": true, "mailto": true}
+	imageURLSchemes = map[string]bool{"http": true, "https": true}
+
+	codeLanguageClass = regexp.MustCompile(`^language-[A-Za-z0-9_+-]{1,32}$`)
+	orderedListStart  = regexp.MustCompile(`^[0-9]{1,9}$`)
+)
+
+// SanitizeHTML は許可リストにない要素・属性・URL を取り除いた HTML を返す
+// 解析できない入力はすべてエスケープしたテキストとして返す
+func SanitizeHTML(input string) string {
+	container := &html.Node{Type: html.ElementNode, Data: "div", DataAtom: atom.Div}
+	nodes, err := html.ParseFragment(strings.NewReader(input), container)
+	if err != nil {
+		return html.EscapeString(input)
+	}
+
+	var b strings.Builder
+	for _, node := range nodes {
+		sanitizeNode(&b, node)
+	}
+	return b.String()
+}
+
+func sanitizeNode(b *strings.Builder, node *html.Node) {
+	switch node.Type {
+	case html.TextNode:
+		b.WriteString(html.EscapeString(node.Data))
+		return
+	case html.CommentNode, html.DoctypeNode:
+		return
+	case html.ElementNode:
+		// 他の名前空間（SVG・MathML）の要素は HTML と解釈が異なるため内容ごと除く
+		if node.Namespace != "" || droppedHTMLElements[node.Data] {
+			return
+		}
+		if allowedAttrs, ok := allowedHTMLElements[node.Data]; ok {
+			b.WriteString("<" + node.Data)
+			writeSanitizedAttrs(b, node, allowedAttrs)
+			b.WriteString(">")
+			if voidHTMLElements[node.Data] {
+				return
+			}
+			sanitizeChildren(b, node)
+			b.WriteString("</" + node.Data + ">")
+			return
+		}
+	}
+	sanitizeChildren(b, node)
+}
+
+func sanitizeChildren(b *strings.Builder, node *html.Node) {
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		sanitizeNode(b, child)
+	}
+}
+
+func writeSanitizedAttrs(b *strings.Builder, node *html.Node, allowedAttrs map[string]bool) {
+	hasHref := false
+	for _, attr := range node.Attr {
+		if attr.Namespace != "" || !allowedAttrs[attr.Key] {
+			continue
+		}
+		value := attr.Val
+		switch attr.Key {
+		case "href":
+			safe, ok := SafeURL(value, linkURLSchemes)
+			if !ok {
+				continue
+			}
+			value = safe
+			hasHref = true
+		case "src":
+			safe, ok := SafeURL(value, imageURLSchemes)
+			if !ok {
+				continue
+			}
+			value = safe
+		case "class":
+			if !codeLanguageClass.MatchString(value) {
+				continue
+			}
+		case "start":
+			if !orderedListStart.MatchString(value) {
+				continue
+			}
+		}
+		b.WriteString(" " + attr.Key + `="` + html.EscapeString(value) + `"`)
+	}
+	// 外部リンクから閲覧中のページを操作されないようにする
+	if node.Data == "a" && hasHref {
+		b.WriteString(` rel="nofollow noopener noreferrer" target="_blank"`)
+	}
+}
+
+// SafeURL は URL が相対 URL または許可されたスキームであれば正規化した値を返す
+// ブラウザが無視する制御文字（タブ・改行など）を取り除いてから判定するため、"java\tscript:" のような表記も拒否される
+func SafeURL(raw string, schemes map[string]bool) (string, bool) {
+	cleaned := strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f {
+			return -1
+		}
+		return r
+	}, strings.TrimSpace(raw))
+	if cleaned == "" {
+		return "", false
+	}
+
+	u, err := url.Parse(cleaned)
+	if err != nil {
+		return "", false
+	}
+	if u.Scheme != "" && !schemes[strings.ToLower(u.Scheme)] {
+		return "", false
+	}
+	return cleaned, true
+}
+
+// inlineTextElements は PlainTextExcerpt で前後に空白を入れない要素
+var inlineTextElements = map[string]bool{"a": true, "strong": true, "em": true, "del": true, "code": true}
+
+// PlainTextExcerpt は HTML からテキストのみを取り出し、maxRunes 文字までの抜粋を返す
+// 連続する空白・改行は1つの空白にまとめ、切り詰めた場合は末尾に「…」を付ける
+func PlainTextExcerpt(input string, maxRunes int) string {
+	container := &html.Node{Type: html.ElementNode, Data: "div", DataAtom: atom.Div}
+	nodes, err := html.ParseFragment(strings.NewReader(input), container)
+	if err != nil {
+		return ""
+	}
+
+	var b strings.Builder
+	var walk func(node *html.Node)
+	walk = func(node *html.Node) {
+		if node.Type == html.ElementNode && (node.Namespace != "" || droppedHTMLElements[node.Data]) {
+			return
+		}
+		if node.Type == html.TextNode {
+			b.WriteString(node.Data)
+		}
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+		// ブロック要素と改行の区切りは空白にする
+		if node.Type == html.ElementNode && !inlineTextElements[node.Data] {
+			b.WriteString(" ")
+		}
+	}
+	for _, node := range nodes {
+		walk(node)
+	}
+
+	text := strings.Join(strings.Fields(b.String()), " ")
+	runes := []rune(text)
+	if maxRunes <= 0 || len(runes) <= maxRunes {
+		return text
+	}
+	return strings.TrimSpace(string(runes[:maxRunes])) + "…"
+}
@@ -4,13 +4,16 @@ import Footer from "./components/Footer";
 import Navbar from "./components/Navbar";
 import ProtectedRoute from "./components/ProtectedRoute";
 import { AuthProvider } from "./contexts/AuthContext";
+import AcceptInvitation from "./pages/AcceptInvitation";
 import AdminAnnouncementCreate from "./pages/AdminAnnouncementCreate";
 import AdminAnnouncementEdit from "./pages/AdminAnnouncementEdit";
 import AdminAnnouncementList from "./pages/AdminAnnouncementList";
 import AnnouncementDetail from "./pages/AnnouncementDetail";
 import AnnouncementList from "./pages/AnnouncementList";
+import AnnouncementUnsubscribe from "./pages/AnnouncementUnsubscribe";
 import Dashboard from "./pages/Dashboard";
 import Login from "./pages/Login";
+import LoginCallback from "./pages/LoginCallback";
 import MyPage from "./pages/MyPage";
 import PaymentConfirmation from "./pages/PaymentConfirmation";
 import PaymentHistory from "./pages/PaymentHistory";
@@ -35,8 +38,16 @@ function App() {
             <Routes>
               {/* 公開ルート */}
               <Route path="/login" element={<Login />} />
+              {/* 学校アカウント（OIDC）でのログイン後の戻り先（OIDC_FRONTEND_REDIRECT_URL） */}
+              <Route path="/login/callback" element={<LoginCallback />} />
               <Route path="/register" element={<Register />} />
+              {/* 一括登録の招待メールのリンク先（INVITATION_ACCEPT_URL） */}
+              <Route path="/invitations/accept" element={<AcceptInvitation />} />
               <Route path="/two-factor-auth" element={<TwoFactorAuth />} />
+              <Route
+                path="/announcements/unsubscribe"
+                element={<AnnouncementUnsubscribe />}
+              />
 
               {/* 保護されたルート */}
               <Route element={<ProtectedRoute redirectPath="/login" />}>
@@ -39,6 +39,11 @@ const AnnouncementCard: React.FC<AnnouncementCardProps> = ({
             >
               {formattedDate}
             </time>
+            {announcement.membersOnly && (
+              <span className="inline-flex items-center px-2 py-0.5 rounded text-xs font-medium bg-amber-100 text-amber-800">
+                会員限定
+              </span>
+            )}
             {isNew && (
               <span className="inline-flex items-center px-2 py-0.5 rounded text-xs font-medium bg-red-100 text-red-800">
                 新着情報
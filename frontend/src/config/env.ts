@@ -25,6 +25,9 @@ export const config = {
 
   stripePublishableKey: import.meta.env.VITE_STRIPE_PUBLISHABLE_KEY || "",
 
+  // 学校アカウント（OIDC）でのログインを表示するか（バックエンドの OIDC_ENABLED と合わせる）
+  oidcEnabled: import.meta.env.VITE_OIDC_ENABLED === "true",
+
   isDevelopment: import.meta.env.MODE === "development",
   isProduction: import.meta.env.MODE === "production",
 
@@ -0,0 +1,18 @@
+import { useEffect, useRef } from "react";
+import { StreamEvent, subscribeEvents } from "../services/eventStream";
+
+// ログイン中にサーバーからのリアルタイムイベントを受け取るためのフック
+export const useEventStream = (
+  onEvent: (event: StreamEvent) => void,
+  enabled = true,
+) => {
+  const handlerRef = useRef(onEvent);
+  handlerRef.current = onEvent;
+
+  useEffect(() => {
+    if (!enabled) {
+      return;
+    }
+    return subscribeEvents((event) => handlerRef.current(event));
+  }, [enabled]);
+};
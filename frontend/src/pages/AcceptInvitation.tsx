@@ -0,0 +1,183 @@
+import React, { useState } from "react";
+import { Link, useSearchParams } from "react-router-dom";
+import Card from "../components/Card";
+import ErrorAlert from "../components/ErrorAlert";
+import SuccessAlert from "../components/SuccessAlert";
+import { authAPI } from "../services/api";
+
+interface ApiError {
+  response?: {
+    data?: {
+      error?: string;
+    };
+  };
+}
+
+const validatePassword = (password: string): boolean => {
+  if (password.length < 8) return false;
+  const hasUpper = /[A-Z]/.test(password);
+  const hasLower = /[a-z]/.test(password);
+  const hasDigit = /[0-9]/.test(password);
+  return hasUpper && hasLower && hasDigit;
+};
+
+// 一括登録の招待メールのリンクから開き、パスワードを設定してアカウントを有効化するページ
+const AcceptInvitation: React.FC = () => {
+  const [searchParams] = useSearchParams();
+  const token = searchParams.get("token") ?? "";
+  const [password, setPassword] = useState("");
+  const [confirmPassword, setConfirmPassword] = useState("");
+  const [error, setError] = useState<string | null>(
+    token
+      ? null
+      : "招待リンクが正しくありません。メールのリンクをもう一度開いてください"
+  );
+  const [isSubmitting, setIsSubmitting] = useState(false);
+  const [success, setSuccess] = useState(false);
+
+  const handleSubmit = async (e: React.FormEvent) => {
+    e.preventDefault();
+    setError(null);
+
+    if (!validatePassword(password)) {
+      setError(
+        "パスワードは8文字以上で、英字の大文字・小文字・数字をすべて含む必要があります"
+      );
+      return;
+    }
+    if (password !== confirmPassword) {
+      setError("パスワードが一致しません");
+      return;
+    }
+
+    setIsSubmitting(true);
+    try {
+      await authAPI.acceptInvitation(token, password);
+      setSuccess(true);
+    } catch (err: unknown) {
+      const apiError = err as ApiError;
+      setError(
+        apiError.response?.data?.error ||
+          "パスワードの設定に失敗しました。時間をおいて再度お試しください"
+      );
+    } finally {
+      setIsSubmitting(false);
+    }
+  };
+
+  const focusStyles =
+    "focus-visible:outline-none focus-visible:ring-2 focus-visible:ring-juice-orange-500 focus-visible:ring-offset-2";
+
+  const inputStyles = `appearance-none block w-full px-3 sm:px-4 py-2 sm:py-3 border border-gray-300 rounded-lg shadow-sm placeholder-gray-400 text-sm transition-colors duration-150 ${focusStyles}`;
+
+  return (
+    <div className="min-h-dvh flex items-center justify-center bg-gray-50 py-6 sm:py-12 px-3 sm:px-6 lg:px-8">
+      <div className="max-w-md w-full">
+        <div className="text-center mb-6 sm:mb-10">
+          <h1 className="text-2xl sm:text-3xl md:text-4xl font-bold text-juice-orange-500 text-balance">
+            Juice Academy
+          </h1>
+        </div>
+
+        <Card className="overflow-hidden">
+          <div className="p-4 sm:p-6 md:p-8">
+            <h2 className="text-xl sm:text-2xl font-bold text-center text-gray-800 mb-4 sm:mb-6 text-balance">
+              パスワードの設定
+            </h2>
+
+            {error && <ErrorAlert message={error} className="mb-6" />}
+
+            {success ? (
+              <div className="text-center">
+                <SuccessAlert message="パスワードを設定しました。ログインしてください。" />
+                <div className="mt-6">
+                  <Link
+                    to="/login"
+                    className={`inline-flex items-center px-4 py-2 border border-transparent text-sm font-medium rounded-lg text-white bg-juice-orange-500 hover:bg-juice-orange-600 transition-colors duration-150 ${focusStyles}`}
+                  >
+                    ログインページへ
+                  </Link>
+                </div>
+              </div>
+            ) : (
+              <form className="space-y-4 sm:space-y-5" onSubmit={handleSubmit}>
+                <p className="text-sm text-gray-600">
+                  アカウントを有効化するため、ログインに使うパスワードを設定してください。
+                </p>
+
+                <div>
+                  <label
+                    htmlFor="password"
+                    className="block text-xs sm:text-sm font-medium text-gray-700 mb-1"
+                  >
+                    パスワード
+                  </label>
+                  <input
+                    id="password"
+                    name="password"
+                    type="password"
+                    autoComplete="new-password"
+                    required
+                    className={inputStyles}
+                    placeholder="8文字以上の英数字…"
+                    value={password}
+                    onChange={(e) => setPassword(e.target.value)}
+                    aria-describedby="password-hint"
+                  />
+                  <p
+                    id="password-hint"
+                    className="mt-1 text-xs text-gray-500 leading-relaxed"
+                  >
+                    8文字以上、大文字・小文字・数字を含む
+                  </p>
+                </div>
+
+                <div>
+                  <label
+                    htmlFor="confirmPassword"
+                    className="block text-xs sm:text-sm font-medium text-gray-700 mb-1"
+                  >
+                    パスワード（確認）
+                  </label>
+                  <input
+                    id="confirmPassword"
+                    name="confirmPassword"
+                    type="password"
+                    autoComplete="new-password"
+                    required
+                    className={inputStyles}
+                    placeholder="パスワードを再入力…"
+                    value={confirmPassword}
+                    onChange={(e) => setConfirmPassword(e.target.value)}
+                  />
+                </div>
+
+                <div className="pt-3 sm:pt-4">
+                  <button
+                    type="submit"
+                    disabled={isSubmitting || !token}
+                    className={`w-full flex justify-center py-2 sm:py-3 px-4 border border-transparent rounded-lg shadow-sm text-sm font-medium text-white transition-colors duration-150 ${
+                      isSubmitting || !token
+                        ? "bg-juice-orange-400 cursor-not-allowed"
+                        : "bg-juice-orange-500 hover:bg-juice-orange-600"
+                    } ${focusStyles}`}
+                  >
+                    {isSubmitting ? "処理中…" : "パスワードを設定する"}
+                  </button>
+                </div>
+              </form>
+            )}
+
+            {!success && (
+              <p className="mt-6 text-xs text-gray-500 text-center">
+                リンクの有効期限が切れている場合は、管理者に招待メールの再送を依頼してください。
+              </p>
+            )}
+          </div>
+        </Card>
+      </div>
+    </div>
+  );
+};
+
+export default AcceptInvitation;
@@ -20,6 +20,8 @@ const AdminAnnouncementCreate: React.FC = () => {
   const { user } = useAuth();
   const [title, setTitle] = useState<string>("");
   const [content, setContent] = useState<string>("");
+  const [notifyEmail, setNotifyEmail] = useState<boolean>(false);
+  const [membersOnly, setMembersOnly] = useState<boolean>(false);
   const [loading, setLoading] = useState<boolean>(false);
   const [error, setError] = useState<string | null>(null);
   const [success, setSuccess] = useState<boolean>(false);
@@ -64,7 +66,7 @@ const AdminAnnouncementCreate: React.FC = () => {
         throw new Error("認証情報が見つかりません");
       }
 
-      await createAnnouncement({ title, content });
+      await createAnnouncement({ title, content, notifyEmail, membersOnly });
       setSuccess(true);
       setLoading(false);
 
@@ -146,6 +148,36 @@ const AdminAnnouncementCreate: React.FC = () => {
               />
             </div>
 
+            <div className="mb-6">
+              <label className="inline-flex items-center text-sm text-gray-700">
+                <input
+                  type="checkbox"
+                  checked={notifyEmail}
+                  onChange={(e) => setNotifyEmail(e.target.checked)}
+                  className="rounded border-gray-300 text-blue-600 focus:ring-blue-500"
+                  disabled={loading || success}
+                />
+                <span className="ml-2">
+                  公開後にメール通知を希望しているユーザーへメールで知らせる
+                </span>
+              </label>
+            </div>
+
+            <div className="mb-6">
+              <label className="inline-flex items-center text-sm text-gray-700">
+                <input
+                  type="checkbox"
+                  checked={membersOnly}
+                  onChange={(e) => setMembersOnly(e.target.checked)}
+                  className="rounded border-gray-300 text-blue-600 focus:ring-blue-500"
+                  disabled={loading || success}
+                />
+                <span className="ml-2">
+                  会員限定（サブスクリプション契約者のみ本文を閲覧できる）
+                </span>
+              </label>
+            </div>
+
             <div className="flex justify-end space-x-3">
               <Button
                 onClick={handleCancel}
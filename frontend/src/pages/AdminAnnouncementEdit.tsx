@@ -8,7 +8,7 @@ import { useAuth } from "../hooks/useAuth";
 import {
   Announcement,
   deleteAnnouncement,
-  getAnnouncementById,
+  getAdminAnnouncementById,
   updateAnnouncement,
 } from "../services/announcementService";
 
@@ -21,6 +21,7 @@ const AdminAnnouncementEdit: React.FC = () => {
   const [announcement, setAnnouncement] = useState<Announcement | null>(null);
   const [title, setTitle] = useState<string>("");
   const [content, setContent] = useState<string>("");
+  const [membersOnly, setMembersOnly] = useState<boolean>(false);
   const [loading, setLoading] = useState<boolean>(true);
   const [submitting, setSubmitting] = useState<boolean>(false);
   const [error, setError] = useState<string | null>(null);
@@ -43,10 +44,11 @@ const AdminAnnouncementEdit: React.FC = () => {
       }
 
       try {
-        const data = await getAnnouncementById(id);
+        const data = await getAdminAnnouncementById(id);
         setAnnouncement(data);
         setTitle(data.title);
         setContent(data.content);
+        setMembersOnly(data.membersOnly ?? false);
         setLoading(false);
       } catch {
         setError("お知らせの取得に失敗しました");
@@ -86,7 +88,7 @@ const AdminAnnouncementEdit: React.FC = () => {
         throw new Error("お知らせIDが不正です");
       }
 
-      await updateAnnouncement(id, { title, content });
+      await updateAnnouncement(id, { title, content, membersOnly });
       setSuccess(true);
       setCompletedAction("update");
       setSubmitting(false);
@@ -192,7 +194,7 @@ const AdminAnnouncementEdit: React.FC = () => {
             message={
               completedAction === "update"
                 ? "お知らせを更新しました。リダイレクトします..."
-                : "お知らせを削除しました。リダイレクトします..."
+                : "お知らせをゴミ箱に移動しました。リダイレクトします..."
             }
             className="mb-4"
           />
@@ -203,7 +205,7 @@ const AdminAnnouncementEdit: React.FC = () => {
             <h2 className="text-lg font-medium text-red-800 mb-2">
               お知らせを削除しますか？
             </h2>
-            <p className="text-red-700 mb-4">この操作は取り消せません。</p>
+            <p className="text-red-700 mb-4">お知らせはゴミ箱に移動し、あとから元に戻せます。</p>
             <div className="flex justify-end space-x-3">
               <Button
                 onClick={() => setShowDeleteConfirm(false)}
@@ -264,7 +266,26 @@ const AdminAnnouncementEdit: React.FC = () => {
                   className="mt-1 block w-full rounded-md border-gray-300 shadow-sm focus:border-blue-500 focus:ring-blue-500"
                   placeholder="お知らせの内容を入力してください"
                   disabled={submitting || showDeleteConfirm}
+                  maxLength={20000}
                 />
+                <p className="mt-1 text-xs text-gray-500">
+                  Markdown（見出し・太字・リスト・リンクなど）で記述できます。HTMLタグは使用できません。
+                </p>
+              </div>
+
+              <div className="mb-6">
+                <label className="inline-flex items-center text-sm text-gray-700">
+                  <input
+                    type="checkbox"
+                    checked={membersOnly}
+                    onChange={(e) => setMembersOnly(e.target.checked)}
+                    className="rounded border-gray-300 text-blue-600 focus:ring-blue-500"
+                    disabled={submitting || showDeleteConfirm}
+                  />
+                  <span className="ml-2">
+                    会員限定（サブスクリプション契約者のみ本文を閲覧できる）
+                  </span>
+                </label>
               </div>
 
               <div className="flex justify-end space-x-3">
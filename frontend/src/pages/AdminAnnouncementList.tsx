@@ -7,7 +7,7 @@ import LoadingSpinner from "../components/LoadingSpinner";
 import { useAuth } from "../hooks/useAuth";
 import {
   Announcement,
-  getAllAnnouncements,
+  getAdminAnnouncements,
 } from "../services/announcementService";
 
 const AdminAnnouncementList: React.FC = () => {
@@ -26,7 +26,7 @@ const AdminAnnouncementList: React.FC = () => {
 
     const fetchAnnouncements = async () => {
       try {
-        const data = await getAllAnnouncements();
+        const data = await getAdminAnnouncements();
         setAnnouncements(data);
         setLoading(false);
       } catch {
@@ -94,12 +94,20 @@ const AnnouncementDetail: React.FC = () => {
             </p>
           </div>
           <div className="border-t border-gray-200 px-4 py-5 sm:px-6">
-            <div className="prose max-w-none">
-              {/* コンテンツを段落に分けて表示 */}
-              {announcement.content.split("\n").map((paragraph, index) => (
-                <p key={index}>{paragraph}</p>
-              ))}
-            </div>
+            {announcement.contentHtml ? (
+              // contentHtml はサーバー側で許可リストにより無害化済み
+              <div
+                className="prose max-w-none"
+                dangerouslySetInnerHTML={{ __html: announcement.contentHtml }}
+              />
+            ) : (
+              <div className="prose max-w-none">
+                {/* コンテンツを段落に分けて表示 */}
+                {announcement.content.split("\n").map((paragraph, index) => (
+                  <p key={index}>{paragraph}</p>
+                ))}
+              </div>
+            )}
           </div>
         </div>
       </div>
@@ -4,6 +4,7 @@ import AnnouncementCard from "../components/AnnouncementCard";
 import Button from "../components/Button";
 import ErrorAlert from "../components/ErrorAlert";
 import LoadingSpinner from "../components/LoadingSpinner";
+import { useEventStream } from "../hooks/useEventStream";
 import {
   Announcement,
   getAllAnnouncements,
@@ -24,21 +25,33 @@ const AnnouncementList: React.FC = () => {
     return diffInHours <= 24;
   };
 
-  useEffect(() => {
-    const fetchAnnouncements = async () => {
-      try {
-        const data = await getAllAnnouncements();
-        setAnnouncements(data);
-        setLoading(false);
-      } catch {
-        setError("お知らせの取得に失敗しました");
-        setLoading(false);
-      }
-    };
+  const fetchAnnouncements = async () => {
+    try {
+      const data = await getAllAnnouncements();
+      setAnnouncements(data);
+      setError(null);
+      setLoading(false);
+    } catch {
+      setError("お知らせの取得に失敗しました");
+      setLoading(false);
+    }
+  };
 
+  useEffect(() => {
     fetchAnnouncements();
   }, []);
 
+  // 新着・更新・削除の通知を受けたら一覧を取り直す
+  useEventStream((event) => {
+    if (event.type === "announcement.deleted") {
+      setAnnouncements((current) =>
+        current.filter((announcement) => announcement.id !== event.data.id),
+      );
+    } else if (event.type !== "subscription.updated") {
+      fetchAnnouncements();
+    }
+  });
+
   const handleGoBack = () => {
     navigate("/");
   };
@@ -0,0 +1,72 @@
+import React, { useState } from "react";
+import { Link, useSearchParams } from "react-router-dom";
+import Button from "../components/Button";
+import ErrorAlert from "../components/ErrorAlert";
+import SuccessAlert from "../components/SuccessAlert";
+import { unsubscribeAnnouncementEmail } from "../services/announcementService";
+
+// メールの配信停止リンクから開くページ
+// メールのリンク先を自動で読み込むセキュリティ製品があるため、ボタンを押したときだけ停止する
+const AnnouncementUnsubscribe: React.FC = () => {
+  const [searchParams] = useSearchParams();
+  const token = searchParams.get("token") ?? "";
+  const [submitting, setSubmitting] = useState<boolean>(false);
+  const [error, setError] = useState<string | null>(
+    token ? null : "配信停止リンクが正しくありません"
+  );
+  const [success, setSuccess] = useState<boolean>(false);
+
+  const handleUnsubscribe = async () => {
+    setError(null);
+    setSubmitting(true);
+    try {
+      await unsubscribeAnnouncementEmail(token);
+      setSuccess(true);
+    } catch {
+      setError("配信停止に失敗しました。リンクが正しいか確認してください");
+    } finally {
+      setSubmitting(false);
+    }
+  };
+
+  return (
+    <div className="min-h-screen bg-gray-50 py-12 px-4 sm:px-6 lg:px-8">
+      <div className="max-w-lg mx-auto bg-white shadow sm:rounded-lg p-6 text-left">
+        <h1 className="text-xl font-bold text-gray-900 mb-4">
+          お知らせのメール通知の停止
+        </h1>
+
+        {error && <ErrorAlert message={error} className="mb-4" />}
+        {success ? (
+          <SuccessAlert
+            title="停止しました"
+            message="お知らせのメール通知を停止しました。マイページからいつでも再開できます。"
+          />
+        ) : (
+          <>
+            <p className="text-gray-700 mb-6">
+              新しいお知らせのメール通知を停止します。お知らせはサイト上で引き続き確認できます。
+            </p>
+            <Button
+              onClick={handleUnsubscribe}
+              variant="primary"
+              size="medium"
+              isLoading={submitting}
+              disabled={submitting || !token}
+            >
+              配信を停止する
+            </Button>
+          </>
+        )}
+
+        <div className="mt-6">
+          <Link to="/announcements" className="text-sm text-blue-600 hover:underline">
+            お知らせ一覧へ
+          </Link>
+        </div>
+      </div>
+    </div>
+  );
+};
+
+export default AnnouncementUnsubscribe;
@@ -4,6 +4,7 @@ import AnnouncementCard from "../components/AnnouncementCard";
 import Card from "../components/Card";
 import LoadingSpinner from "../components/LoadingSpinner";
 import { useAuth } from "../hooks/useAuth";
+import { useEventStream } from "../hooks/useEventStream";
 import {
   Announcement,
   getLatestAnnouncements,
@@ -49,36 +50,46 @@ const Dashboard: React.FC = () => {
     return diffInHours <= 24;
   };
 
-  useEffect(() => {
-    const checkSubscriptionStatus = async () => {
-      try {
-        const response = await paymentAPI.getSubscriptionStatus();
-        setHasActiveSubscription(response.data.hasActiveSubscription || false);
-      } catch {
-        setHasActiveSubscription(false);
-      } finally {
-        setCheckingSubscription(false);
-      }
-    };
+  const checkSubscriptionStatus = async () => {
+    try {
+      const response = await paymentAPI.getSubscriptionStatus();
+      setHasActiveSubscription(response.data.hasActiveSubscription || false);
+    } catch {
+      setHasActiveSubscription(false);
+    } finally {
+      setCheckingSubscription(false);
+    }
+  };
+
+  const fetchAnnouncements = async () => {
+    try {
+      const data = await getLatestAnnouncements(5);
+      setAnnouncements(data);
+      setError(null);
+      setLoading(false);
+    } catch {
+      setError("お知らせの取得に失敗しました");
+      setLoading(false);
+    }
+  };
 
+  useEffect(() => {
     checkSubscriptionStatus();
   }, []);
 
   useEffect(() => {
-    const fetchAnnouncements = async () => {
-      try {
-        const data = await getLatestAnnouncements(5);
-        setAnnouncements(data);
-        setLoading(false);
-      } catch {
-        setError("お知らせの取得に失敗しました");
-        setLoading(false);
-      }
-    };
-
     fetchAnnouncements();
   }, []);
 
+  // 決済の完了・解約やお知らせの公開をリアルタイムに反映する
+  useEventStream((event) => {
+    if (event.type === "subscription.updated") {
+      checkSubscriptionStatus();
+    } else {
+      fetchAnnouncements();
+    }
+  });
+
   if (isLoading) {
     return <Loading />;
   }
@@ -2,7 +2,7 @@ import React, { useState } from "react";
 import { Link, useLocation, useNavigate } from "react-router-dom";
 import Card from "../components/Card";
 import JuiceLoadingAnimation from "../components/JuiceLoadingAnimation";
-import { getApiUrl } from "../config/env";
+import { config, getApiUrl } from "../config/env";
 import { useAuth } from "../hooks/useAuth";
 
 const Login: React.FC = () => {
@@ -227,6 +227,17 @@ const Login: React.FC = () => {
               </div>
             </div>
 
+            {config.oidcEnabled && (
+              <div className="mt-6">
+                <a
+                  href={`${getApiUrl()}/auth/oidc/login`}
+                  className={`w-full flex justify-center py-3 px-4 border border-gray-300 rounded-lg text-sm font-semibold text-gray-700 bg-white hover:bg-gray-50 transition-colors duration-150 ${focusStyles}`}
+                >
+                  学校アカウントでログイン
+                </a>
+              </div>
+            )}
+
             <div className="mt-6 text-center">
               <p className="text-sm text-gray-600">
                 アカウントをお持ちでない方は
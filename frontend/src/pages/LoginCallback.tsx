@@ -0,0 +1,85 @@
+import React, { useEffect, useRef, useState } from "react";
+import { Link, useNavigate } from "react-router-dom";
+import Card from "../components/Card";
+import ErrorAlert from "../components/ErrorAlert";
+import JuiceLoadingAnimation from "../components/JuiceLoadingAnimation";
+import { authAPI } from "../services/api";
+
+// バックエンドの OIDCCallbackHandler が返すエラーコードごとのメッセージ
+const errorMessages: Record<string, string> = {
+  idp_error: "学校アカウントでの認証が完了しませんでした。もう一度お試しください。",
+  invalid_state:
+    "ログインの有効期限が切れました。もう一度ログインしてください。",
+  token_exchange_failed:
+    "認証サーバーとの通信に失敗しました。時間をおいて再度お試しください。",
+  account_not_found:
+    "学校アカウントに対応する利用者が見つかりません。招待メールから登録を完了するか、管理者にお問い合わせください。",
+  account_conflict:
+    "この学校アカウントは別の利用者に紐付いています。管理者にお問い合わせください。",
+  account_suspended: "アカウントが停止されています。管理者にお問い合わせください。",
+  account_pending:
+    "登録が完了していません。招待メールのリンクから登録を完了してください。",
+  server_error: "ログインに失敗しました。時間をおいて再度お試しください。",
+};
+
+// 学校アカウント（OIDC）でのログイン後にバックエンドから戻るページ
+// 結果は URL フラグメント（#csrfToken=...&expiresIn=... または #error=...）で受け取る
+const LoginCallback: React.FC = () => {
+  const navigate = useNavigate();
+  const [error, setError] = useState<string | null>(null);
+  const started = useRef(false);
+
+  useEffect(() => {
+    // StrictMode で二重に実行されるとリフレッシュトークンのローテーションが競合するため一度だけ処理する
+    if (started.current) return;
+    started.current = true;
+
+    const params = new URLSearchParams(window.location.hash.slice(1));
+    // トークンを履歴に残さない
+    window.history.replaceState(null, "", window.location.pathname);
+
+    const errorCode = params.get("error");
+    const csrfToken = params.get("csrfToken");
+    if (errorCode || !csrfToken) {
+      setError(errorMessages[errorCode ?? ""] ?? errorMessages.server_error);
+      return;
+    }
+
+    authAPI
+      .completeExternalLogin(csrfToken)
+      .then(() => {
+        window.dispatchEvent(new Event("auth-changed"));
+        navigate("/", { replace: true });
+      })
+      .catch(() => {
+        authAPI.logout();
+        setError(errorMessages.server_error);
+      });
+  }, [navigate]);
+
+  if (!error) {
+    return (
+      <div className="min-h-dvh flex items-center justify-center bg-gray-50">
+        <JuiceLoadingAnimation />
+      </div>
+    );
+  }
+
+  return (
+    <div className="min-h-dvh bg-gray-50 py-12 px-4 sm:px-6 lg:px-8">
+      <div className="max-w-md mx-auto">
+        <Card className="text-left">
+          <h1 className="text-xl font-bold text-gray-900 mb-4">
+            ログインできませんでした
+          </h1>
+          <ErrorAlert message={error} className="mb-6" />
+          <Link to="/login" className="text-sm text-blue-600 hover:underline">
+            ログイン画面へ戻る
+          </Link>
+        </Card>
+      </div>
+    </div>
+  );
+};
+
+export default LoginCallback;
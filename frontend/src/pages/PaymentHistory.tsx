@@ -1,8 +1,12 @@
-import React, { useEffect, useState } from "react";
+import React, { useCallback, useEffect, useState } from "react";
 import ErrorAlert from "../components/ErrorAlert";
 import LoadingSpinner from "../components/LoadingSpinner";
 import { useAuth } from "../hooks/useAuth";
-import { paymentAPI } from "../services/api";
+import {
+  PaymentHistoryParams,
+  ReceiptType,
+  paymentAPI,
+} from "../services/api";
 
 interface PaymentRecord {
   id: string;
@@ -11,22 +15,59 @@ interface PaymentRecord {
   created_at: string;
   description: string;
   type?: string;
+  invoice_number?: string;
+  hosted_invoice_url?: string;
+  invoice_pdf?: string;
+  // 返金済みの額とクレジットノート（管理者が発行したもの）
+  amount_refunded?: number;
+  credit_notes?: {
+    number: string;
+    amount: number;
+    pdf?: string;
+    created_at: string;
+  }[];
 }
 
+// 支払いが完了している（返金済みを含む）状態
+const PAID_STATUSES = ["success", "partially_refunded", "refunded"];
+
 const PaymentHistory: React.FC = () => {
   const { user } = useAuth();
   const [payments, setPayments] = useState<PaymentRecord[]>([]);
   const [loading, setLoading] = useState<boolean>(true);
   const [error, setError] = useState<string | null>(null);
+  const [nextCursor, setNextCursor] = useState<string>("");
+  const [loadingMore, setLoadingMore] = useState<boolean>(false);
+  // 期間の絞り込み（YYYY-MM-DD）
+  const [from, setFrom] = useState<string>("");
+  const [to, setTo] = useState<string>("");
+  const [qualifiedInvoiceAvailable, setQualifiedInvoiceAvailable] =
+    useState<boolean>(false);
+  const [receiptError, setReceiptError] = useState<string | null>(null);
 
-  useEffect(() => {
-    const fetchPaymentHistory = async () => {
+  // cursor を指定した場合は続きを読み込んで末尾に追加する
+  const fetchPaymentHistory = useCallback(
+    async (cursor?: string) => {
       if (!user) return;
 
+      const params: PaymentHistoryParams = {};
+      if (from) params.from = from;
+      if (to) params.to = to;
+      if (cursor) params.cursor = cursor;
+
       try {
-        setLoading(true);
-        const response = await paymentAPI.getPaymentHistory();
-        setPayments(response.data.payment_history || []);
+        if (cursor) {
+          setLoadingMore(true);
+        } else {
+          setLoading(true);
+        }
+        const response = await paymentAPI.getPaymentHistory(params);
+        const records: PaymentRecord[] = response.data.payment_history || [];
+        setPayments((prev) => (cursor ? [...prev, ...records] : records));
+        setNextCursor(response.data.next_cursor || "");
+        setQualifiedInvoiceAvailable(
+          Boolean(response.data.qualified_invoice_available),
+        );
         setError(null);
       } catch {
         setError(
@@ -34,11 +75,15 @@ const PaymentHistory: React.FC = () => {
         );
       } finally {
         setLoading(false);
+        setLoadingMore(false);
       }
-    };
+    },
+    [user, from, to],
+  );
 
+  useEffect(() => {
     fetchPaymentHistory();
-  }, [user]);
+  }, [fetchPaymentHistory]);
 
   // 日付をフォーマットする関数
   const formatDate = (dateStr: string) => {
@@ -65,6 +110,10 @@ const PaymentHistory: React.FC = () => {
       pending: "処理中",
       failed: "失敗",
       upcoming: "予定",
+      draft: "作成中",
+      voided: "無効",
+      partially_refunded: "一部返金",
+      refunded: "返金済み",
     };
     return statusMap[status] || status;
   };
@@ -80,11 +129,147 @@ const PaymentHistory: React.FC = () => {
         return "bg-yellow-100 text-yellow-800";
       case "failed":
         return "bg-red-100 text-red-800";
+      case "partially_refunded":
+      case "refunded":
+        return "bg-purple-100 text-purple-800";
       default:
         return "bg-gray-100 text-gray-800";
     }
   };
 
+  // 領収書・適格請求書の PDF をダウンロードする（ファイル名は発行番号）
+  const downloadReceipt = async (invoiceId: string, type: ReceiptType) => {
+    try {
+      setReceiptError(null);
+      const response = await paymentAPI.downloadReceipt(invoiceId, type);
+      const disposition = String(
+        response.headers["content-disposition"] || "",
+      );
+      const filename =
+        disposition.match(/filename="([^"]+)"/)?.[1] || `${type}.pdf`;
+      const url = URL.createObjectURL(response.data);
+      const link = document.createElement("a");
+      link.href = url;
+      link.download = filename;
+      link.click();
+      URL.revokeObjectURL(url);
+    } catch {
+      setReceiptError(
+        "領収書の発行に失敗しました。後でもう一度お試しください。",
+      );
+    }
+  };
+
+  // 宛名を変えて再発行し、そのままダウンロードする
+  const reissueReceipt = async (invoiceId: string, type: ReceiptType) => {
+    const addressee = window.prompt(
+      "新しい宛名を入力してください（以前に発行したものは無効になります）",
+    );
+    if (!addressee || !addressee.trim()) return;
+    try {
+      setReceiptError(null);
+      await paymentAPI.reissueReceipt(invoiceId, type, addressee.trim());
+      await downloadReceipt(invoiceId, type);
+    } catch (err: unknown) {
+      const message = (err as { response?: { data?: { error?: string } } })
+        ?.response?.data?.error;
+      setReceiptError(message || "再発行に失敗しました。");
+    }
+  };
+
+  // Stripe の請求書ページと PDF へのリンク、領収書・適格請求書のダウンロード
+  const renderInvoiceLinks = (payment: PaymentRecord) => {
+    const receiptAvailable = PAID_STATUSES.includes(payment.status);
+    const invoiceAvailable =
+      qualifiedInvoiceAvailable &&
+      (receiptAvailable || payment.status === "pending");
+    const creditNotes = payment.credit_notes || [];
+    if (
+      !payment.hosted_invoice_url &&
+      !payment.invoice_pdf &&
+      creditNotes.length === 0 &&
+      !receiptAvailable &&
+      !invoiceAvailable
+    ) {
+      return null;
+    }
+    return (
+      <div className="flex flex-wrap gap-3 mt-0.5 text-xs">
+        {receiptAvailable && (
+          <>
+            <button
+              type="button"
+              onClick={() => downloadReceipt(payment.id, "receipt")}
+              className="text-blue-600 hover:underline"
+            >
+              領収書
+            </button>
+            <button
+              type="button"
+              onClick={() => reissueReceipt(payment.id, "receipt")}
+              className="text-gray-500 hover:underline"
+            >
+              宛名を変えて再発行
+            </button>
+          </>
+        )}
+        {invoiceAvailable && (
+          <button
+            type="button"
+            onClick={() => downloadReceipt(payment.id, "invoice")}
+            className="text-blue-600 hover:underline"
+          >
+            適格請求書
+          </button>
+        )}
+        {payment.hosted_invoice_url && (
+          <a
+            href={payment.hosted_invoice_url}
+            target="_blank"
+            rel="noopener noreferrer"
+            className="text-blue-600 hover:underline"
+          >
+            請求書
+          </a>
+        )}
+        {payment.invoice_pdf && (
+          <a
+            href={payment.invoice_pdf}
+            target="_blank"
+            rel="noopener noreferrer"
+            className="text-blue-600 hover:underline"
+          >
+            PDF
+          </a>
+        )}
+        {creditNotes.map(
+          (creditNote) =>
+            creditNote.pdf && (
+              <a
+                key={creditNote.number}
+                href={creditNote.pdf}
+                target="_blank"
+                rel="noopener noreferrer"
+                className="text-blue-600 hover:underline"
+              >
+                クレジットノート {creditNote.number}
+              </a>
+            ),
+        )}
+      </div>
+    );
+  };
+
+  // 返金がある場合は金額の下に返金額を表示する
+  const renderRefundedAmount = (payment: PaymentRecord) => {
+    if (!payment.amount_refunded) return null;
+    return (
+      <p className="text-xs text-purple-700 font-normal">
+        返金 {formatAmount(payment.amount_refunded)}
+      </p>
+    );
+  };
+
   const renderContent = () => {
     if (loading) {
       return <LoadingSpinner message="支払い履歴を読み込み中…" />;
@@ -119,6 +304,8 @@ const PaymentHistory: React.FC = () => {
                     className={`w-2 h-2 rounded-full flex-shrink-0 ${
                       payment.status === "success"
                         ? "bg-green-500"
+                        : PAID_STATUSES.includes(payment.status)
+                          ? "bg-purple-500"
                         : payment.status === "upcoming"
                           ? "bg-blue-500"
                           : payment.status === "pending"
@@ -133,11 +320,13 @@ const PaymentHistory: React.FC = () => {
                 <p className="text-base text-gray-800 mt-0.5">
                   {payment.description || "サブスクリプション"}
                 </p>
+                {renderInvoiceLinks(payment)}
               </div>
               <div className="text-right pl-3">
                 <p className="text-base font-semibold text-gray-900">
                   {formatAmount(payment.amount)}
                 </p>
+                {renderRefundedAmount(payment)}
               </div>
             </div>
           ))}
@@ -191,9 +380,11 @@ const PaymentHistory: React.FC = () => {
                       (payment.type === "subscription"
                         ? "サブスクリプション料金"
                         : "支払い")}
+                    {renderInvoiceLinks(payment)}
                   </td>
                   <td className="px-3 py-3 text-sm text-gray-900 text-right font-medium">
                     {formatAmount(payment.amount)}
+                    {renderRefundedAmount(payment)}
                   </td>
                   <td className="px-3 py-3 text-center">
                     <span
@@ -209,6 +400,19 @@ const PaymentHistory: React.FC = () => {
             </tbody>
           </table>
         </div>
+
+        {nextCursor && (
+          <div className="mt-3 text-center">
+            <button
+              type="button"
+              onClick={() => fetchPaymentHistory(nextCursor)}
+              disabled={loadingMore}
+              className="px-4 py-2 text-sm text-blue-600 hover:bg-blue-50 rounded disabled:opacity-50"
+            >
+              {loadingMore ? "読み込み中…" : "もっと見る"}
+            </button>
+          </div>
+        )}
       </>
     );
   };
@@ -218,6 +422,40 @@ const PaymentHistory: React.FC = () => {
       <h2 className="text-base sm:text-lg font-bold text-gray-800 mb-3">
         支払い履歴
       </h2>
+      <div className="flex flex-wrap items-center gap-2 mb-3 text-sm text-gray-600">
+        <label className="flex items-center gap-1">
+          期間
+          <input
+            type="date"
+            value={from}
+            max={to || undefined}
+            onChange={(e) => setFrom(e.target.value)}
+            className="border border-gray-300 rounded px-2 py-1"
+          />
+        </label>
+        <span>〜</span>
+        <input
+          type="date"
+          value={to}
+          min={from || undefined}
+          onChange={(e) => setTo(e.target.value)}
+          className="border border-gray-300 rounded px-2 py-1"
+          aria-label="期間の終了日"
+        />
+        {(from || to) && (
+          <button
+            type="button"
+            onClick={() => {
+              setFrom("");
+              setTo("");
+            }}
+            className="text-blue-600 hover:underline"
+          >
+            クリア
+          </button>
+        )}
+      </div>
+      {receiptError && <ErrorAlert message={receiptError} />}
       {renderContent()}
     </div>
   );
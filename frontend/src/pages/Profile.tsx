@@ -1,8 +1,37 @@
-import React from "react";
+import React, { useEffect, useState } from "react";
 import { useAuth } from "../hooks/useAuth";
+import {
+  getNotificationSettings,
+  updateNotificationSettings,
+} from "../services/announcementService";
 
 const Profile: React.FC = () => {
   const { user } = useAuth();
+  const [announcementEmail, setAnnouncementEmail] = useState<boolean | null>(
+    null
+  );
+  const [savingNotification, setSavingNotification] = useState(false);
+
+  useEffect(() => {
+    if (!user) return;
+    getNotificationSettings()
+      .then((settings) => setAnnouncementEmail(settings.announcement_email))
+      .catch(() => setAnnouncementEmail(null));
+  }, [user]);
+
+  const handleAnnouncementEmailChange = async (enabled: boolean) => {
+    setSavingNotification(true);
+    try {
+      const settings = await updateNotificationSettings({
+        announcement_email: enabled,
+      });
+      setAnnouncementEmail(settings.announcement_email);
+    } catch {
+      alert("通知設定の変更に失敗しました");
+    } finally {
+      setSavingNotification(false);
+    }
+  };
 
   if (!user) {
     return (
@@ -58,6 +87,23 @@ const Profile: React.FC = () => {
             {user.email}
           </dd>
         </div>
+        {announcementEmail !== null && (
+          <div className="py-3 flex justify-between items-center">
+            <dt className="text-base text-gray-500">
+              <label htmlFor="announcement-email">お知らせのメール通知</label>
+            </dt>
+            <dd>
+              <input
+                id="announcement-email"
+                type="checkbox"
+                className="size-5 rounded border-gray-300 text-juice-orange-600 focus-visible:ring-juice-orange-500"
+                checked={announcementEmail}
+                disabled={savingNotification}
+                onChange={(e) => handleAnnouncementEmailChange(e.target.checked)}
+              />
+            </dd>
+          </div>
+        )}
       </dl>
 
       {/* アクションボタン */}
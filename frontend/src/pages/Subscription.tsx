@@ -5,7 +5,7 @@ import Card from "../components/Card";
 import ErrorAlert from "../components/ErrorAlert";
 import PaymentSummary from "../components/PaymentSummary";
 import { useAuth } from "../hooks/useAuth";
-import { paymentAPI } from "../services/api";
+import { Plan, paymentAPI, planAPI } from "../services/api";
 
 // APIエラー型定義
 interface ApiError {
@@ -19,56 +19,42 @@ interface ApiError {
 // サブスクリプション状態の型定義
 interface SubscriptionStatus {
   hasActiveSubscription: boolean;
+  // 無料トライアルを利用できるか（利用者・学籍番号ごとに1回まで）
+  trialEligible?: boolean;
   subscription?: {
     id: string;
     status: string;
     price_id: string;
     current_period_end: string;
     cancel_at_period_end: boolean;
+    is_trial?: boolean;
+    trial_end?: string;
   };
 }
 
-// サブスクリプションプラン
-const subscriptionPlans = [
-  {
-    id: "plan_monthly",
-    priceId: import.meta.env.VITE_STRIPE_PRICE_ID_MONTHLY,
-    name: "月額プラン",
-    price: 3000,
-    interval: "月",
-    description: "標準的な月額プラン",
-    features: ["ドリンクサーバーの利用が可能", "いつでも解約可能"],
-    color: "blue",
-  },
-  {
-    id: "plan_yearly",
-    priceId: import.meta.env.VITE_STRIPE_PRICE_ID_YEARLY,
-    name: "年額プラン",
-    price: 9800,
-    interval: "年",
-    description: "月額プランより約72%お得",
-    features: [
-      "ドリンクサーバーの利用が可能",
-      "月額プランより約72%お得",
-      "1年ごとの自動更新",
-    ],
-    color: "orange",
-  },
-  {
-    id: "plan_2years",
-    priceId: import.meta.env.VITE_STRIPE_PRICE_ID_2YEARS,
-    name: "2年プラン",
-    price: 18000,
-    interval: "2年",
-    description: "長期利用でさらにお得",
-    features: [
-      "ドリンクサーバーの利用が可能",
-      "年額プランよりさらにお得",
-      "2年ごとの自動更新",
-    ],
-    color: "purple",
-  },
-];
+// プランカードの色（並び順で割り当てる）
+const planColors = ["blue", "orange", "purple"];
+
+// 請求間隔の表示（例: 月 / 年 / 2年 / 3か月）
+const intervalUnits: Record<Plan["interval"], string> = {
+  day: "日",
+  week: "週",
+  month: "か月",
+  year: "年",
+};
+
+const formatPlanInterval = (plan: Plan) => {
+  if (plan.interval_count <= 1) {
+    return plan.interval === "month" ? "月" : intervalUnits[plan.interval];
+  }
+  return `${plan.interval_count}${intervalUnits[plan.interval]}`;
+};
+
+const formatBillingPeriod = (plan: Plan) => {
+  if (plan.interval_count <= 1 && plan.interval === "month") return "月額";
+  if (plan.interval_count <= 1 && plan.interval === "year") return "年額";
+  return `${formatPlanInterval(plan)}一括`;
+};
 
 const Subscription: React.FC = () => {
   const navigate = useNavigate();
@@ -79,6 +65,7 @@ const Subscription: React.FC = () => {
   const [subscriptionStatus, setSubscriptionStatus] =
     useState<SubscriptionStatus | null>(null);
   const [hasPaymentMethod, setHasPaymentMethod] = useState(false);
+  const [plans, setPlans] = useState<Plan[]>([]);
 
   const { user } = useAuth();
 
@@ -92,7 +79,11 @@ const Subscription: React.FC = () => {
 
       try {
         // 並行して取得
-        const [subResponse, pmResponse] = await Promise.all([
+        const [planResponse, subResponse, pmResponse] = await Promise.all([
+          planAPI.getPlans().catch(() => {
+            setError("プラン一覧の取得に失敗しました");
+            return { data: { plans: [] as Plan[] } };
+          }),
           paymentAPI.getSubscriptionStatus().catch(() => ({
             data: {
               hasActiveSubscription: false,
@@ -104,6 +95,7 @@ const Subscription: React.FC = () => {
           })),
         ]);
 
+        setPlans(planResponse.data.plans);
         setSubscriptionStatus(subResponse.data);
 
         const methods = pmResponse.data?.paymentMethods;
@@ -120,18 +112,28 @@ const Subscription: React.FC = () => {
 
   // 選択されたプランの情報を取得
   const getSelectedPlanInfo = () => {
-    return subscriptionPlans.find((plan) => plan.id === selectedPlan);
+    return plans.find((plan) => plan.price_id === selectedPlan);
+  };
+
+  // トライアルを利用できる場合のみプランのトライアル日数を返す
+  const trialDaysFor = (plan?: Plan) => {
+    if (!plan || !subscriptionStatus?.trialEligible) return 0;
+    if (subscriptionStatus.hasActiveSubscription) return 0;
+    return plan.trial_days || 0;
   };
 
   // 次回請求日を計算
-  const getNextBillingDate = (interval: string) => {
+  const getNextBillingDate = (plan: Plan) => {
     const date = new Date();
-    if (interval === "月") {
-      date.setMonth(date.getMonth() + 1);
-    } else if (interval === "年") {
-      date.setFullYear(date.getFullYear() + 1);
-    } else if (interval === "2年") {
-      date.setFullYear(date.getFullYear() + 2);
+    const count = Math.max(plan.interval_count, 1);
+    if (plan.interval === "day") {
+      date.setDate(date.getDate() + count);
+    } else if (plan.interval === "week") {
+      date.setDate(date.getDate() + 7 * count);
+    } else if (plan.interval === "month") {
+      date.setMonth(date.getMonth() + count);
+    } else if (plan.interval === "year") {
+      date.setFullYear(date.getFullYear() + count);
     }
     return date.toLocaleDateString("ja-JP", {
       year: "numeric",
@@ -141,16 +143,17 @@ const Subscription: React.FC = () => {
   };
 
   // プラン選択ハンドラ
-  const handlePlanSelect = (planId: string) => {
-    setSelectedPlan(planId);
+  const handlePlanSelect = (priceId: string) => {
+    setSelectedPlan(priceId);
   };
 
   // サブスクリプション登録ハンドラ
   const handleSubscribe = async () => {
     if (!selectedPlan || !user) return;
 
-    // 支払い方法がない場合は登録ページへ誘導
-    if (!hasPaymentMethod && !hasActiveSubscription) {
+    // 支払い方法がない場合は登録ページへ誘導（無料トライアルはカードなしで開始できる）
+    const startsTrial = trialDaysFor(getSelectedPlanInfo()) > 0;
+    if (!hasPaymentMethod && !hasActiveSubscription && !startsTrial) {
       navigate("/payment-setup");
       return;
     }
@@ -179,9 +182,14 @@ const Subscription: React.FC = () => {
         throw new Error("プラン情報が見つかりません");
       }
 
+      // カード未登録でトライアルを始める場合は Stripe の顧客を先に作成する
+      if (!hasPaymentMethod) {
+        await paymentAPI.createStripeCustomer();
+      }
+
       // サブスクリプションを作成（既存のカード情報を使用）
       const response = await paymentAPI.createSubscription(
-        selectedPlanInfo.priceId,
+        selectedPlanInfo.price_id,
       );
 
       // レスポンスに含まれるリダイレクト先に移動
@@ -202,6 +210,7 @@ const Subscription: React.FC = () => {
   };
 
   const selectedPlanInfo = getSelectedPlanInfo();
+  const selectedTrialDays = trialDaysFor(selectedPlanInfo);
 
   const isCanceled = subscriptionStatus?.subscription?.cancel_at_period_end;
   const currentPeriodEnd = subscriptionStatus?.subscription?.current_period_end;
@@ -310,146 +319,148 @@ const Subscription: React.FC = () => {
         {error && <ErrorAlert message={error} className="animate-slide-up" />}
 
         <div className="mt-6 sm:mt-12 lg:mt-16 space-y-4 md:space-y-0 md:grid md:grid-cols-3 md:gap-4 lg:gap-6 lg:max-w-6xl lg:mx-auto px-1 sm:px-0">
-          {subscriptionPlans.map((plan, index) => (
-            <div key={index} className="subscription-option flex">
-              <Card
-                className={`relative flex flex-col w-full divide-y divide-gray-200 plan-card animate-slide-up overflow-hidden ${
-                  selectedPlan === plan.id
-                    ? "selected ring-2 ring-offset-2 ring-juice-orange-500"
-                    : ""
-                } ${hasActiveSubscription ? "opacity-90" : ""}`}
-                style={{ animationDelay: `${index * 150}ms` }}
-              >
-                {plan.id === "plan_yearly" && !hasActiveSubscription && (
-                  <div className="absolute -top-1 -right-1 z-20">
-                    <div className="relative">
-                      <div className="bg-gradient-to-br from-juice-orange-500 via-juice-orange-600 to-juice-orange-700 text-white text-[11px] sm:text-xs font-bold px-5 sm:px-6 py-2 sm:py-2.5 shadow-xl transform origin-center">
-                        <span className="relative z-10 tracking-wide whitespace-nowrap drop-shadow-sm">
-                          おすすめ
-                        </span>
-                        <div className="absolute top-0 left-0 w-full h-1/2 bg-white/25 rounded-t-sm"></div>
-                      </div>
-                      <div className="absolute -bottom-0.5 -right-0.5 w-3 h-3 bg-juice-orange-800 transform rotate-45 shadow-md"></div>
-                    </div>
-                  </div>
-                )}
-                <div className="p-4 sm:p-6 flex-1 flex flex-col">
-                  <h2
-                    className={`text-base sm:text-lg leading-6 font-bold text-center ${
-                      hasActiveSubscription
-                        ? "text-gray-700"
-                        : `text-${plan.color}-600`
-                    }`}
-                  >
-                    {plan.name}
-                  </h2>
-                  <p className="mt-2 sm:mt-4 text-xs sm:text-sm text-gray-500 text-center min-h-[2rem] sm:min-h-[2.5rem]">
-                    {plan.description}
-                  </p>
-                  <p className="mt-4 sm:mt-8 text-center">
-                    <span className="text-2xl sm:text-3xl lg:text-4xl font-extrabold text-gray-900">
-                      ¥{plan.price.toLocaleString()}
-                    </span>
-                    <span className="text-sm sm:text-base font-medium text-gray-500">
-                      /{plan.interval}
-                    </span>
-                  </p>
-
-                  {hasActiveSubscription && !isCanceled ? (
-                    <div className="mt-auto space-y-3 pt-4 sm:pt-8">
-                      {subscriptionStatus?.subscription?.price_id ===
-                        plan.priceId && (
-                        <div className="w-full px-3 sm:px-4 py-2 sm:py-3 bg-green-100 border-2 border-green-500 text-green-800 font-semibold rounded-md text-center text-sm sm:text-base">
-                          選択中
+          {plans.map((plan, index) => {
+            const color = planColors[index % planColors.length];
+            return (
+              <div key={plan.price_id} className="subscription-option flex">
+                <Card
+                  className={`relative flex flex-col w-full divide-y divide-gray-200 plan-card animate-slide-up overflow-hidden ${
+                    selectedPlan === plan.price_id
+                      ? "selected ring-2 ring-offset-2 ring-juice-orange-500"
+                      : ""
+                  } ${hasActiveSubscription ? "opacity-90" : ""}`}
+                  style={{ animationDelay: `${index * 150}ms` }}
+                >
+                  {plan.recommended && !hasActiveSubscription && (
+                    <div className="absolute -top-1 -right-1 z-20">
+                      <div className="relative">
+                        <div className="bg-gradient-to-br from-juice-orange-500 via-juice-orange-600 to-juice-orange-700 text-white text-[11px] sm:text-xs font-bold px-5 sm:px-6 py-2 sm:py-2.5 shadow-xl transform origin-center">
+                          <span className="relative z-10 tracking-wide whitespace-nowrap drop-shadow-sm">
+                            おすすめ
+                          </span>
+                          <div className="absolute top-0 left-0 w-full h-1/2 bg-white/25 rounded-t-sm"></div>
                         </div>
-                      )}
+                        <div className="absolute -bottom-0.5 -right-0.5 w-3 h-3 bg-juice-orange-800 transform rotate-45 shadow-md"></div>
+                      </div>
                     </div>
-                  ) : (
-                    <div className="mt-auto pt-4 sm:pt-8">
-                      {hasActiveSubscription &&
-                      activePriceId === plan.priceId &&
-                      isCanceled ? (
-                        <div className="space-y-2 sm:space-y-3">
-                          <div className="w-full px-3 sm:px-4 py-2 bg-yellow-100 border border-yellow-300 text-yellow-800 text-xs sm:text-sm font-semibold rounded-md text-center mb-2">
-                            終了予定
+                  )}
+                  <div className="p-4 sm:p-6 flex-1 flex flex-col">
+                    <h2
+                      className={`text-base sm:text-lg leading-6 font-bold text-center ${
+                        hasActiveSubscription
+                          ? "text-gray-700"
+                          : `text-${color}-600`
+                      }`}
+                    >
+                      {plan.name}
+                    </h2>
+                    <p className="mt-2 sm:mt-4 text-xs sm:text-sm text-gray-500 text-center min-h-[2rem] sm:min-h-[2.5rem]">
+                      {plan.description}
+                    </p>
+                    <p className="mt-4 sm:mt-8 text-center">
+                      <span className="text-2xl sm:text-3xl lg:text-4xl font-extrabold text-gray-900">
+                        ¥{plan.amount.toLocaleString()}
+                      </span>
+                      <span className="text-sm sm:text-base font-medium text-gray-500">
+                        /{formatPlanInterval(plan)}
+                      </span>
+                    </p>
+                    {trialDaysFor(plan) > 0 && (
+                      <p className="mt-2 text-center text-xs sm:text-sm font-semibold text-green-700">
+                        {plan.trial_days}日間無料トライアル
+                      </p>
+                    )}
+
+                    {hasActiveSubscription && !isCanceled ? (
+                      <div className="mt-auto space-y-3 pt-4 sm:pt-8">
+                        {subscriptionStatus?.subscription?.price_id ===
+                          plan.price_id && (
+                          <div className="w-full px-3 sm:px-4 py-2 sm:py-3 bg-green-100 border-2 border-green-500 text-green-800 font-semibold rounded-md text-center text-sm sm:text-base">
+                            選択中
+                          </div>
+                        )}
+                      </div>
+                    ) : (
+                      <div className="mt-auto pt-4 sm:pt-8">
+                        {hasActiveSubscription &&
+                        activePriceId === plan.price_id &&
+                        isCanceled ? (
+                          <div className="space-y-2 sm:space-y-3">
+                            <div className="w-full px-3 sm:px-4 py-2 bg-yellow-100 border border-yellow-300 text-yellow-800 text-xs sm:text-sm font-semibold rounded-md text-center mb-2">
+                              終了予定
+                            </div>
+                            <Button
+                              type="button"
+                              onClick={() => handlePlanSelect(plan.price_id)}
+                              variant="primary"
+                              fullWidth
+                              className="bg-green-600 hover:bg-green-700"
+                            >
+                              {selectedPlan === plan.price_id
+                                ? "選択中"
+                                : "契約を再開する"}
+                            </Button>
                           </div>
+                        ) : (
                           <Button
                             type="button"
-                            onClick={() => handlePlanSelect(plan.id)}
-                            variant="primary"
+                            onClick={() => handlePlanSelect(plan.price_id)}
+                            variant={
+                              selectedPlan === plan.price_id ? "primary" : "outline"
+                            }
                             fullWidth
-                            className="bg-green-600 hover:bg-green-700"
+                            className={`btn-hover-effect transition-all duration-200 ${
+                              selectedPlan === plan.price_id
+                                ? `bg-${color}-600 hover:bg-${color}-700 text-white shadow-lg transform scale-105`
+                                : "hover:bg-gray-50"
+                            }`}
                           >
-                            {selectedPlan === plan.id
-                              ? "選択中"
-                              : "契約を再開する"}
+                            {selectedPlan === plan.price_id ? "選択中" : "選択する"}
                           </Button>
-                        </div>
-                      ) : (
-                        <Button
-                          type="button"
-                          onClick={() => handlePlanSelect(plan.id)}
-                          variant={
-                            selectedPlan === plan.id ? "primary" : "outline"
-                          }
-                          fullWidth
-                          className={`btn-hover-effect transition-all duration-200 ${
-                            selectedPlan === plan.id
-                              ? `bg-${plan.color}-600 hover:bg-${plan.color}-700 text-white shadow-lg transform scale-105`
-                              : "hover:bg-gray-50"
-                          }`}
-                        >
-                          {selectedPlan === plan.id ? "選択中" : "選択する"}
-                        </Button>
-                      )}
-                    </div>
-                  )}
-                </div>
-                <div className="pt-4 sm:pt-6 pb-6 sm:pb-8 px-4 sm:px-6 bg-gray-50 flex-1">
-                  <h3 className="text-xs font-medium text-gray-900 tracking-wide uppercase">
-                    含まれる機能
-                  </h3>
-                  <ul className="mt-3 sm:mt-6 space-y-2 sm:space-y-4">
-                    {plan.features.map((feature, index) => (
-                      <li key={index} className="flex items-start">
-                        <svg
-                          className={`flex-shrink-0 h-4 w-4 sm:h-5 sm:w-5 text-${plan.color}-500 mt-0.5`}
-                          xmlns="http://www.w3.org/2000/svg"
-                          viewBox="0 0 20 20"
-                          fill="currentColor"
-                          aria-hidden="true"
-                        >
-                          <path
-                            fillRule="evenodd"
-                            d="M10 18a8 8 0 100-16 8 8 0 000 16zm3.707-9.293a1 1 0 00-1.414-1.414L9 10.586 7.707 9.293a1 1 0 00-1.414 1.414l2 2a1 1 0 001.414 0l4-4z"
-                            clipRule="evenodd"
-                          />
-                        </svg>
-                        <span className="ml-2 sm:ml-3 text-xs sm:text-sm text-gray-500">
-                          {feature}
-                        </span>
-                      </li>
-                    ))}
-                  </ul>
-                </div>
-              </Card>
-            </div>
-          ))}
+                        )}
+                      </div>
+                    )}
+                  </div>
+                  <div className="pt-4 sm:pt-6 pb-6 sm:pb-8 px-4 sm:px-6 bg-gray-50 flex-1">
+                    <h3 className="text-xs font-medium text-gray-900 tracking-wide uppercase">
+                      含まれる機能
+                    </h3>
+                    <ul className="mt-3 sm:mt-6 space-y-2 sm:space-y-4">
+                      {plan.features.map((feature, index) => (
+                        <li key={index} className="flex items-start">
+                          <svg
+                            className={`flex-shrink-0 h-4 w-4 sm:h-5 sm:w-5 text-${color}-500 mt-0.5`}
+                            xmlns="http://www.w3.org/2000/svg"
+                            viewBox="0 0 20 20"
+                            fill="currentColor"
+                            aria-hidden="true"
+                          >
+                            <path
+                              fillRule="evenodd"
+                              d="M10 18a8 8 0 100-16 8 8 0 000 16zm3.707-9.293a1 1 0 00-1.414-1.414L9 10.586 7.707 9.293a1 1 0 00-1.414 1.414l2 2a1 1 0 001.414 0l4-4z"
+                              clipRule="evenodd"
+                            />
+                          </svg>
+                          <span className="ml-2 sm:ml-3 text-xs sm:text-sm text-gray-500">
+                            {feature}
+                          </span>
+                        </li>
+                      ))}
+                    </ul>
+                  </div>
+                </Card>
+              </div>
+            );
+          })}
         </div>
 
         {selectedPlanInfo && (
           <div className="mt-6 sm:mt-12 max-w-lg mx-auto animate-slide-up px-2 sm:px-0">
             <PaymentSummary
               planName={selectedPlanInfo.name}
-              planPrice={selectedPlanInfo.price}
-              billingPeriod={
-                selectedPlanInfo.interval === "月"
-                  ? "月額"
-                  : selectedPlanInfo.interval === "年"
-                    ? "年額"
-                    : "2年一括"
-              }
-              nextBillingDate={getNextBillingDate(selectedPlanInfo.interval)}
+              planPrice={selectedPlanInfo.amount}
+              billingPeriod={formatBillingPeriod(selectedPlanInfo)}
+              nextBillingDate={getNextBillingDate(selectedPlanInfo)}
               tax={10}
             />
           </div>
@@ -467,10 +478,17 @@ const Subscription: React.FC = () => {
             >
               {hasActiveSubscription && isCanceled
                 ? "プランを更新・再開する"
-                : !hasPaymentMethod
-                  ? "支払い方法を登録して次へ"
-                  : "サブスクリプションを開始する"}
+                : selectedTrialDays > 0
+                  ? `${selectedTrialDays}日間の無料トライアルを始める`
+                  : !hasPaymentMethod
+                    ? "支払い方法を登録して次へ"
+                    : "サブスクリプションを開始する"}
             </Button>
+            {selectedTrialDays > 0 && (
+              <p className="mt-3 sm:mt-4 text-xs sm:text-sm text-gray-500">
+                * トライアル終了までにカードを登録しない場合は自動的に解約されます
+              </p>
+            )}
             <p className="mt-3 sm:mt-4 text-xs sm:text-sm text-gray-500">
               * サブスクリプションはいつでもキャンセルできます
             </p>
@@ -3,7 +3,12 @@ import { useLocation, useNavigate } from "react-router-dom";
 import ErrorAlert from "../components/ErrorAlert";
 import LoadingSpinner from "../components/LoadingSpinner";
 import SuccessAlert from "../components/SuccessAlert";
-import { paymentAPI } from "../services/api";
+import {
+  Plan,
+  PlanChangePreview,
+  paymentAPI,
+  planAPI,
+} from "../services/api";
 
 // サブスクリプション情報の型定義
 interface Subscription {
@@ -12,14 +17,40 @@ interface Subscription {
   price_id: string;
   current_period_end: string;
   cancel_at_period_end: boolean;
+  scheduled_price_id?: string;
+  scheduled_change_at?: string;
+  paused?: boolean;
+  pause_resumes_at?: string;
+  // 支払いに関する確認（チャージバック）のため管理者が停止した場合 true
+  suspended?: boolean;
+  pause_days_remaining?: number;
+  is_trial?: boolean;
+  trial_end?: string;
+  grace_until?: string;
 }
 
+// APIエラーからメッセージを取り出す
+const extractErrorMessage = (err: unknown, fallback: string) => {
+  if (typeof err === "object" && err !== null && "response" in err) {
+    const errorWithResponse = err as {
+      response?: { data?: { error?: string } };
+    };
+    return errorWithResponse.response?.data?.error || fallback;
+  }
+  return fallback;
+};
+
 const SubscriptionManagement: React.FC = () => {
   const [subscription, setSubscription] = useState<Subscription | null>(null);
   const [loading, setLoading] = useState(true);
   const [cancelLoading, setCancelLoading] = useState(false);
   const [error, setError] = useState<string | null>(null);
   const [success, setSuccess] = useState<string | null>(null);
+  const [plans, setPlans] = useState<Plan[]>([]);
+  const [preview, setPreview] = useState<PlanChangePreview | null>(null);
+  const [planChangeLoading, setPlanChangeLoading] = useState(false);
+  const [pauseUntil, setPauseUntil] = useState("");
+  const [pauseLoading, setPauseLoading] = useState(false);
 
   const navigate = useNavigate();
   const location = useLocation();
@@ -53,6 +84,148 @@ const SubscriptionManagement: React.FC = () => {
     fetchSubscription();
   }, []);
 
+  // 変更先のプラン一覧を取得（失敗してもサブスクリプション情報は表示する）
+  useEffect(() => {
+    planAPI
+      .getPlans()
+      .then((response) => setPlans(response.data.plans))
+      .catch(() => setPlans([]));
+  }, []);
+
+  const planName = (priceId?: string) =>
+    plans.find((plan) => plan.price_id === priceId)?.name ?? "";
+
+  const formatAmount = (amount: number, currency: string) =>
+    new Intl.NumberFormat("ja-JP", {
+      style: "currency",
+      currency: currency.toUpperCase(),
+    }).format(amount);
+
+  // プラン変更後の請求額を確認
+  const handlePreviewPlanChange = async (priceId: string) => {
+    setPlanChangeLoading(true);
+    setError(null);
+    setSuccess(null);
+    try {
+      const response = await paymentAPI.previewPlanChange(priceId);
+      setPreview(response.data);
+    } catch (err: unknown) {
+      setError(extractErrorMessage(err, "変更後の請求額の取得に失敗しました"));
+    } finally {
+      setPlanChangeLoading(false);
+    }
+  };
+
+  // プランを変更（アップグレードは即時、ダウングレードは期間終了時）
+  const handleChangePlan = async () => {
+    if (!preview || !subscription) return;
+    setPlanChangeLoading(true);
+    setError(null);
+    try {
+      await paymentAPI.changePlan(
+        preview.plan.price_id,
+        preview.proration_token,
+      );
+      if (preview.effective === "immediate") {
+        setSubscription({
+          ...subscription,
+          price_id: preview.plan.price_id,
+          scheduled_price_id: undefined,
+          scheduled_change_at: undefined,
+        });
+        setSuccess(`${preview.plan.name}に変更しました`);
+      } else {
+        setSubscription({
+          ...subscription,
+          scheduled_price_id: preview.plan.price_id,
+          scheduled_change_at: preview.effective_at,
+        });
+        setSuccess(
+          `${formatNextBillingDate(preview.effective_at)}から${preview.plan.name}に変更します`,
+        );
+      }
+      setPreview(null);
+    } catch (err: unknown) {
+      setError(extractErrorMessage(err, "プランの変更に失敗しました"));
+    } finally {
+      setPlanChangeLoading(false);
+    }
+  };
+
+  // サブスクリプションを休止（日付未指定の場合は上限まで）
+  const handlePauseSubscription = async () => {
+    if (!subscription) return;
+    if (
+      !window.confirm(
+        "サブスクリプションを休止しますか？休止中はサービスを利用できず、請求も行われません。",
+      )
+    ) {
+      return;
+    }
+    setPauseLoading(true);
+    setError(null);
+    setSuccess(null);
+    try {
+      const resumesAt = pauseUntil
+        ? new Date(`${pauseUntil}T00:00:00`).toISOString()
+        : undefined;
+      const response = await paymentAPI.pauseSubscription(resumesAt);
+      setSubscription({
+        ...subscription,
+        paused: true,
+        pause_resumes_at: response.data.pause_resumes_at,
+        pause_days_remaining: response.data.pause_days_remaining,
+      });
+      setSuccess(
+        `${formatNextBillingDate(response.data.pause_resumes_at)}まで休止します`,
+      );
+      setPauseUntil("");
+    } catch (err: unknown) {
+      setError(extractErrorMessage(err, "サブスクリプションの休止に失敗しました"));
+    } finally {
+      setPauseLoading(false);
+    }
+  };
+
+  // 休止中のサブスクリプションを再開
+  const handleResumeSubscription = async () => {
+    if (!subscription) return;
+    setPauseLoading(true);
+    setError(null);
+    setSuccess(null);
+    try {
+      await paymentAPI.resumeSubscription();
+      const response = await paymentAPI.getSubscriptionStatus();
+      setSubscription(response.data.subscription);
+      setSuccess("サブスクリプションを再開しました");
+    } catch (err: unknown) {
+      setError(extractErrorMessage(err, "サブスクリプションの再開に失敗しました"));
+    } finally {
+      setPauseLoading(false);
+    }
+  };
+
+  // 予約中のプラン変更を取り消す
+  const handleCancelPlanChange = async () => {
+    if (!subscription) return;
+    setPlanChangeLoading(true);
+    setError(null);
+    setSuccess(null);
+    try {
+      await paymentAPI.cancelPlanChange();
+      setSubscription({
+        ...subscription,
+        scheduled_price_id: undefined,
+        scheduled_change_at: undefined,
+      });
+      setSuccess("プラン変更の予約を取り消しました");
+    } catch (err: unknown) {
+      setError(extractErrorMessage(err, "プラン変更の予約の取り消しに失敗しました"));
+    } finally {
+      setPlanChangeLoading(false);
+    }
+  };
+
   // 次回請求日をフォーマット
   const formatNextBillingDate = (dateString: string) => {
     const date = new Date(dateString);
@@ -131,8 +304,21 @@ const SubscriptionManagement: React.FC = () => {
     );
   }
 
-  // ステータスの視覚的スタイル（3パターン）
-  const getStatusStyle = (status: string, cancelAtPeriodEnd: boolean) => {
+  // ステータスの視覚的スタイル
+  const getStatusStyle = (
+    status: string,
+    cancelAtPeriodEnd: boolean,
+    paused?: boolean,
+  ) => {
+    // 休止中
+    if (paused) {
+      return {
+        border: "border-l-4 border-l-blue-400 bg-blue-50",
+        badge: "bg-blue-100 text-blue-800",
+        icon: "Ⅱ",
+        label: "休止中",
+      };
+    }
     // 解約済み（次回更新日まで利用可）
     if (cancelAtPeriodEnd) {
       return {
@@ -142,6 +328,15 @@ const SubscriptionManagement: React.FC = () => {
         label: "解約予定",
       };
     }
+    // 無料トライアル中
+    if (status === "trialing") {
+      return {
+        border: "border-l-4 border-l-green-400 bg-green-50",
+        badge: "bg-green-100 text-green-800",
+        icon: "✓",
+        label: "無料トライアル中",
+      };
+    }
     // 有効
     if (status === "active") {
       return {
@@ -182,6 +377,7 @@ const SubscriptionManagement: React.FC = () => {
           const style = getStatusStyle(
             subscription.status,
             subscription.cancel_at_period_end,
+            subscription.paused,
           );
           return (
             <div className={isInMyPage ? "" : "bg-white rounded-lg shadow p-5"}>
@@ -203,6 +399,28 @@ const SubscriptionManagement: React.FC = () => {
                           更新日まで利用可能
                         </p>
                       )}
+                      {subscription.paused &&
+                        subscription.pause_resumes_at && (
+                          <p className="text-sm text-blue-700">
+                            {formatNextBillingDate(
+                              subscription.pause_resumes_at,
+                            )}
+                            に再開予定
+                          </p>
+                        )}
+                      {subscription.status === "past_due" &&
+                        subscription.grace_until && (
+                          <p className="text-sm text-red-700">
+                            {formatNextBillingDate(subscription.grace_until)}
+                            までに支払い方法を更新してください
+                          </p>
+                        )}
+                      {subscription.is_trial && subscription.trial_end && (
+                        <p className="text-sm text-green-700">
+                          {formatNextBillingDate(subscription.trial_end)}
+                          まで無料（カード未登録の場合は自動解約）
+                        </p>
+                      )}
                     </div>
                   </div>
                   <div className="text-right">
@@ -214,6 +432,166 @@ const SubscriptionManagement: React.FC = () => {
                 </div>
               </div>
 
+              {/* 予約中のプラン変更 */}
+              {subscription.scheduled_price_id &&
+                subscription.scheduled_change_at && (
+                  <div className="rounded-lg border border-blue-200 bg-blue-50 p-4 mb-4 flex items-center justify-between gap-3">
+                    <p className="text-sm text-blue-900">
+                      {formatNextBillingDate(subscription.scheduled_change_at)}
+                      から
+                      {planName(subscription.scheduled_price_id) ||
+                        "新しいプラン"}
+                      に変更予定です
+                    </p>
+                    <button
+                      onClick={handleCancelPlanChange}
+                      disabled={planChangeLoading}
+                      className="px-4 py-2 text-sm font-medium border border-blue-300 rounded-lg hover:bg-blue-100 disabled:opacity-50 transition-colors"
+                    >
+                      取り消す
+                    </button>
+                  </div>
+                )}
+
+              {/* 休止・再開 */}
+              {subscription.status === "active" &&
+                !subscription.cancel_at_period_end && (
+                  <div className="rounded-lg border border-gray-200 p-4 mb-4">
+                    {subscription.suspended ? (
+                      <p className="text-sm text-gray-700">
+                        お支払いに関する確認のため、ご利用を停止しています。再開についてはお問い合わせください
+                      </p>
+                    ) : subscription.paused ? (
+                      <div className="flex items-center justify-between gap-3">
+                        <p className="text-sm text-gray-700">
+                          休止中はサービスを利用できません
+                        </p>
+                        <button
+                          onClick={handleResumeSubscription}
+                          disabled={pauseLoading}
+                          className="px-4 py-2 text-sm font-medium text-white bg-orange-500 rounded-lg hover:bg-orange-600 disabled:opacity-50 transition-colors"
+                        >
+                          {pauseLoading ? "処理中..." : "再開する"}
+                        </button>
+                      </div>
+                    ) : (
+                      <>
+                        <p className="text-sm text-gray-700 mb-2">
+                          休学などで利用しない期間は休止できます（今年はあと
+                          {subscription.pause_days_remaining ?? 0}日）
+                        </p>
+                        <div className="flex flex-wrap items-center gap-2">
+                          <label className="text-sm text-gray-600">
+                            再開日
+                            <input
+                              type="date"
+                              value={pauseUntil}
+                              onChange={(e) => setPauseUntil(e.target.value)}
+                              className="ml-2 px-2 py-1 border border-gray-300 rounded"
+                            />
+                          </label>
+                          <button
+                            onClick={handlePauseSubscription}
+                            disabled={
+                              pauseLoading ||
+                              !subscription.pause_days_remaining
+                            }
+                            className="px-4 py-2 text-sm font-medium border border-blue-300 text-blue-700 rounded-lg hover:bg-blue-50 disabled:opacity-50 transition-colors"
+                          >
+                            {pauseLoading ? "処理中..." : "休止する"}
+                          </button>
+                        </div>
+                      </>
+                    )}
+                  </div>
+                )}
+
+              {/* プラン変更 */}
+              {subscription.status === "active" &&
+                !subscription.paused &&
+                !subscription.cancel_at_period_end &&
+                plans.length > 1 && (
+                  <div className="mb-4">
+                    <p className="text-sm text-gray-500 mb-2">
+                      現在のプラン：
+                      {planName(subscription.price_id) || "不明なプラン"}
+                    </p>
+                    <div className="flex flex-wrap gap-2">
+                      {plans
+                        .filter(
+                          (plan) => plan.price_id !== subscription.price_id,
+                        )
+                        .map((plan) => (
+                          <button
+                            key={plan.price_id}
+                            onClick={() =>
+                              handlePreviewPlanChange(plan.price_id)
+                            }
+                            disabled={planChangeLoading}
+                            className="px-4 py-2 text-sm font-medium border border-orange-300 text-orange-700 rounded-lg hover:bg-orange-50 disabled:opacity-50 transition-colors"
+                          >
+                            {plan.name}に変更
+                          </button>
+                        ))}
+                    </div>
+
+                    {preview && (
+                      <div className="mt-3 rounded-lg border border-gray-200 p-4">
+                        {preview.effective === "immediate" ? (
+                          <>
+                            <p className="text-sm text-gray-700 mb-2">
+                              すぐに{preview.plan.name}
+                              に切り替わり、残り期間の差額を請求します
+                            </p>
+                            <ul className="text-sm text-gray-600 mb-2">
+                              {preview.lines.map((line, index) => (
+                                <li
+                                  key={index}
+                                  className="flex justify-between gap-3"
+                                >
+                                  <span>{line.description}</span>
+                                  <span>
+                                    {formatAmount(line.amount, preview.currency)}
+                                  </span>
+                                </li>
+                              ))}
+                            </ul>
+                            <p className="text-base font-bold text-gray-900">
+                              今回の請求額：
+                              {formatAmount(
+                                preview.amount_due,
+                                preview.currency,
+                              )}
+                            </p>
+                          </>
+                        ) : (
+                          <p className="text-sm text-gray-700">
+                            現在の契約期間が終わる
+                            {formatNextBillingDate(preview.effective_at)}
+                            に{preview.plan.name}に切り替わります。それまでは現在のプランを利用できます
+                          </p>
+                        )}
+                        <div className="flex gap-2 mt-3">
+                          <button
+                            onClick={handleChangePlan}
+                            disabled={planChangeLoading}
+                            className="px-4 py-2 text-sm font-medium text-white bg-orange-500 rounded-lg hover:bg-orange-600 disabled:opacity-50 transition-colors"
+                          >
+                            {planChangeLoading ? "処理中..." : "変更する"}
+                          </button>
+                          <button
+                            onClick={() => setPreview(null)}
+                            disabled={planChangeLoading}
+                            className="px-4 py-2 text-sm font-medium border border-gray-300 rounded-lg hover:bg-gray-50 transition-colors"
+                          >
+                            やめる
+                          </button>
+                        </div>
+                      </div>
+                    )}
+                  </div>
+                )}
+
               {/* アクションボタン */}
               <div className="flex items-center gap-3">
                 <button
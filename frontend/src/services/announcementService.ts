@@ -1,18 +1,70 @@
+import { getApiUrl } from "../config/env";
 import { api } from "./api";
 
 // お知らせの型定義
+export type AnnouncementStatus = "draft" | "scheduled" | "published" | "expired";
+
+export type AnnouncementCategory =
+  | "general"
+  | "academic"
+  | "event"
+  | "maintenance"
+  | "billing";
+
+export interface AnnouncementAudience {
+  roles?: string[];
+  subscriptionStatuses?: string[];
+  cohorts?: number[];
+}
+
+export interface AnnouncementAttachment {
+  id: string;
+  filename: string;
+  contentType: string;
+  size: number;
+  sha256: string;
+  uploadedAt: string;
+}
+
 export interface Announcement {
   id: string;
   title: string;
   content: string;
+  // content（Markdown）からサーバーで生成した無害化済みのHTML
+  contentHtml?: string;
+  category?: AnnouncementCategory;
+  pinned?: boolean;
+  important?: boolean;
+  audience?: AnnouncementAudience | null;
+  // 契約者（premium_content の利用権を持つユーザー）だけが本文を閲覧できるお知らせ
+  membersOnly?: boolean;
+  // 会員限定のお知らせで、利用権がないため本文が返されていない（公開一覧のみ）
+  locked?: boolean;
+  isPublished?: boolean;
+  publishAt?: string | null;
+  expiresAt?: string | null;
+  status?: AnnouncementStatus;
+  attachments?: AnnouncementAttachment[];
+  // 公開後にメール通知を希望したユーザーへダイジェストで送信するか
+  notifyEmail?: boolean;
+  emailSentAt?: string;
+  // ログイン中のみ設定される既読状態
+  isRead?: boolean;
   createdAt: string;
   updatedAt: string;
+  // 編集のたびに増える版番号
+  version?: number;
+  // ゴミ箱に移動した日時（ゴミ箱一覧のみ）
+  deletedAt?: string;
 }
 
 // バックエンドのレスポンス形式に合わせて修正
 interface AnnouncementsResponse {
   announcements: Announcement[];
   count: number;
+  total?: number;
+  next_cursor?: string;
+  has_more?: boolean;
 }
 
 // APIのベースURLは api.ts で管理されています
@@ -59,6 +111,98 @@ export const getAnnouncementById = async (
   return response.data;
 };
 
+// 下書き・公開予定を含むすべてのお知らせを取得（管理者のみ）
+export const getAdminAnnouncements = async (
+  status?: AnnouncementStatus
+): Promise<Announcement[]> => {
+  const response = await api.get<AnnouncementsResponse>(
+    "/admin/announcements",
+    { params: status ? { status } : undefined }
+  );
+  return response.data?.announcements ?? [];
+};
+
+// 公開状態にかかわらずお知らせを取得（管理者のみ）
+export const getAdminAnnouncementById = async (
+  id: string
+): Promise<Announcement> => {
+  const response = await api.get<Announcement>(`/admin/announcements/${id}`);
+  return response.data;
+};
+
+export interface UnreadAnnouncementCount {
+  unread: number;
+  unread_important: number;
+}
+
+export interface AnnouncementStats {
+  announcement_id: string;
+  read_count: number;
+  audience_size: number;
+  read_rate: number;
+}
+
+// 未読のお知らせ件数を取得
+export const getUnreadAnnouncementCount =
+  async (): Promise<UnreadAnnouncementCount> => {
+    const response = await api.get<UnreadAnnouncementCount>(
+      "/announcements/unread-count"
+    );
+    return response.data;
+  };
+
+// お知らせを既読にする
+export const markAnnouncementRead = async (id: string): Promise<void> => {
+  await api.post(`/announcements/${id}/read`);
+};
+
+// 表示中のお知らせをすべて既読にする
+export const markAllAnnouncementsRead = async (): Promise<void> => {
+  await api.post("/announcements/read-all");
+};
+
+// お知らせの既読率を取得（管理者のみ）
+export const getAnnouncementStats = async (
+  id: string
+): Promise<AnnouncementStats> => {
+  const response = await api.get<AnnouncementStats>(
+    `/admin/announcements/${id}/stats`
+  );
+  return response.data;
+};
+
+// 添付ファイルのURL（画像は本文に埋め込める）
+export const getAttachmentUrl = (
+  announcementId: string,
+  attachmentId: string
+): string =>
+  `${getApiUrl()}/announcements/${announcementId}/attachments/${attachmentId}`;
+
+// お知らせにファイルを添付（管理者のみ）
+export const uploadAnnouncementAttachment = async (
+  announcementId: string,
+  file: File
+): Promise<AnnouncementAttachment> => {
+  const formData = new FormData();
+  formData.append("file", file);
+  const response = await api.post<AnnouncementAttachment>(
+    `/admin/announcements/${announcementId}/attachments`,
+    formData,
+    { headers: { "Content-Type": "multipart/form-data" } }
+  );
+  return response.data;
+};
+
+// 添付ファイルを削除（管理者のみ）
+export const deleteAnnouncementAttachment = async (
+  announcementId: string,
+  attachmentId: string
+): Promise<void> => {
+  await api.delete(
+    `/admin/announcements/${announcementId}/attachments/${attachmentId}`
+  );
+};
+
 // 新しいお知らせを作成（管理者のみ）- 通常のAPI方式
 export const createAnnouncement = async (
   announcement: Omit<Announcement, "id" | "createdAt" | "updatedAt">
@@ -82,7 +226,150 @@ export const updateAnnouncement = async (
   return response.data;
 };
 
-// お知らせを削除（管理者のみ）
+// お知らせをゴミ箱に移動（管理者のみ）
 export const deleteAnnouncement = async (id: string): Promise<void> => {
   await api.delete(`/admin/announcements/${id}`);
 };
+
+export type AnnouncementRevisionAction =
+  | "created"
+  | "updated"
+  | "restored"
+  | "legacy";
+
+export type AnnouncementSnapshot = Pick<
+  Announcement,
+  | "title"
+  | "content"
+  | "contentHtml"
+  | "category"
+  | "pinned"
+  | "important"
+  | "audience"
+  | "isPublished"
+  | "publishAt"
+  | "expiresAt"
+>;
+
+export interface AnnouncementRevision {
+  id: string;
+  announcementId: string;
+  version: number;
+  action: AnnouncementRevisionAction;
+  restoredFrom?: number;
+  authorId?: string;
+  createdAt: string;
+  // 一覧では本文（content / contentHtml）は省略される
+  snapshot: AnnouncementSnapshot;
+}
+
+export interface AnnouncementDiffLine {
+  op: "equal" | "insert" | "delete";
+  text: string;
+}
+
+export interface AnnouncementRevisionDiff {
+  from: number;
+  to: number;
+  changes: Record<string, { from: unknown; to: unknown }>;
+  content: AnnouncementDiffLine[];
+}
+
+// 編集履歴を新しい順に取得（管理者のみ）
+export const getAnnouncementRevisions = async (
+  id: string
+): Promise<AnnouncementRevision[]> => {
+  const response = await api.get<{ revisions: AnnouncementRevision[] }>(
+    `/admin/announcements/${id}/revisions`
+  );
+  return response.data?.revisions ?? [];
+};
+
+// 指定した版の内容を取得（管理者のみ）
+export const getAnnouncementRevision = async (
+  id: string,
+  version: number
+): Promise<AnnouncementRevision> => {
+  const response = await api.get<AnnouncementRevision>(
+    `/admin/announcements/${id}/revisions/${version}`
+  );
+  return response.data;
+};
+
+// 2つの版の差分を取得（to を省略すると最新の版と比較）（管理者のみ）
+export const diffAnnouncementRevisions = async (
+  id: string,
+  from: number,
+  to?: number
+): Promise<AnnouncementRevisionDiff> => {
+  const response = await api.get<AnnouncementRevisionDiff>(
+    `/admin/announcements/${id}/diff`,
+    { params: to ? { from, to } : { from } }
+  );
+  return response.data;
+};
+
+// 過去の版の内容に戻す（管理者のみ）
+export const restoreAnnouncementRevision = async (
+  id: string,
+  version: number
+): Promise<Announcement> => {
+  const response = await api.post<Announcement>(
+    `/admin/announcements/${id}/revisions/${version}/restore`
+  );
+  return response.data;
+};
+
+// ゴミ箱のお知らせを取得（管理者のみ）
+export const getTrashedAnnouncements = async (): Promise<Announcement[]> => {
+  const response = await api.get<AnnouncementsResponse>(
+    "/admin/announcements/trash"
+  );
+  return response.data?.announcements ?? [];
+};
+
+// ゴミ箱のお知らせを元に戻す（管理者のみ）
+export const restoreTrashedAnnouncement = async (
+  id: string
+): Promise<Announcement> => {
+  const response = await api.post<Announcement>(
+    `/admin/announcements/trash/${id}/restore`
+  );
+  return response.data;
+};
+
+// ゴミ箱のお知らせを完全に削除（管理者のみ）
+export const purgeAnnouncement = async (id: string): Promise<void> => {
+  await api.delete(`/admin/announcements/trash/${id}`);
+};
+
+export interface NotificationSettings {
+  announcement_email: boolean;
+}
+
+// お知らせのメール通知の設定を取得
+export const getNotificationSettings =
+  async (): Promise<NotificationSettings> => {
+    const response = await api.get<NotificationSettings>(
+      "/account/notifications"
+    );
+    return response.data;
+  };
+
+// お知らせのメール通知の設定を変更
+export const updateNotificationSettings = async (
+  settings: NotificationSettings
+): Promise<NotificationSettings> => {
+  const response = await api.put<NotificationSettings>(
+    "/account/notifications",
+    settings
+  );
+  return response.data;
+};
+
+// メールの配信停止リンクからメール通知を停止（ログイン不要）
+export const unsubscribeAnnouncementEmail = async (
+  token: string
+): Promise<void> => {
+  await api.post("/announcements/unsubscribe", null, { params: { token } });
+};
@@ -60,6 +60,9 @@ api.interceptors.request.use(
   (error) => Promise.reject(error)
 );
 
+// 利用権がない場合にバックエンドが返すエラーコード
+export const SUBSCRIPTION_REQUIRED_CODE = "subscription_required";
+
 let refreshPromise: Promise<{ accessToken: string; csrfToken: string }> | null =
   null;
 
@@ -158,6 +161,16 @@ api.interceptors.response.use(
       window.location.href = "/login";
     }
 
+    // 契約が必要な機能（バックエンドの RequireEntitlement）はプラン選択画面へ誘導する
+    const errorData = error.response?.data as { code?: string } | undefined;
+    if (
+      error.response?.status === 403 &&
+      errorData?.code === SUBSCRIPTION_REQUIRED_CODE &&
+      window.location.pathname !== "/subscription"
+    ) {
+      window.location.href = "/subscription";
+    }
+
     return Promise.reject(error);
   }
 );
@@ -218,6 +231,88 @@ export const authAPI = {
   saveSession,
   getAccessToken,
   getCsrfToken,
+
+  // アクセストークンの更新（axios を使わない通信で期限切れになった場合に使う）
+  refreshSession: performTokenRefresh,
+
+  // 学校アカウント（OIDC）でのログイン完了後、受け取った CSRF トークンでアクセストークンを取得する
+  // リフレッシュトークンはコールバック時に Cookie で設定済み
+  completeExternalLogin: async (csrfToken: string) => {
+    localStorage.setItem(CSRF_TOKEN_KEY, csrfToken);
+    return performTokenRefresh();
+  },
+
+  // 一括登録の招待メールのリンクからパスワードを設定する
+  acceptInvitation: async (token: string, password: string) => {
+    return api.post("/invitations/accept", { token, password });
+  },
+};
+
+// プラン（価格・請求間隔は Stripe から同期される）
+export interface Plan {
+  id: string;
+  price_id: string;
+  name: string;
+  description: string;
+  interval: "day" | "week" | "month" | "year";
+  interval_count: number;
+  amount: number;
+  currency: string;
+  features: string[];
+  sort_order: number;
+  recommended: boolean;
+  // 無料トライアルの日数（0 はトライアルなし）
+  trial_days: number;
+}
+
+// 利用権（サブスクリプションで利用できる機能）
+export interface Entitlements {
+  status: string;
+  price_id: string;
+  entitlements: string[];
+  // 支払い遅延中に利用を続けられる期限
+  grace_until?: string;
+}
+
+// 支払い履歴の絞り込みとページング
+export interface PaymentHistoryParams {
+  cursor?: string;
+  from?: string;
+  to?: string;
+  limit?: number;
+}
+
+// 発行する帳票の種類（領収書 / 適格請求書）
+export type ReceiptType = "receipt" | "invoice";
+
+// プラン変更のプレビュー（アップグレードは日割りの差額を即時請求、ダウングレードは期間終了時に切り替え）
+export interface PlanChangePreview {
+  change: "upgrade" | "downgrade";
+  effective: "immediate" | "period_end";
+  effective_at: string;
+  proration_date?: number;
+  // 変更のリクエストに渡す精算の基準日時のトークン（発行から10分間有効）
+  proration_token?: string;
+  amount_due: number;
+  currency: string;
+  lines: {
+    description: string;
+    amount: number;
+    proration: boolean;
+    period_start?: string;
+    period_end?: string;
+  }[];
+  next_amount: number;
+  next_billing_at: string;
+  plan: Plan;
+}
+
+// プラン関連のAPI
+export const planAPI = {
+  // 契約できるプラン一覧を取得（ログイン不要）
+  getPlans: async () => {
+    return api.get<{ plans: Plan[] }>("/plans");
+  },
 };
 
 // 決済関連のAPI
@@ -243,8 +338,29 @@ export const paymentAPI = {
   },
 
   // 決済履歴を取得
-  getPaymentHistory: async () => {
-    return api.get("/payment/history");
+  // cursor には前回のレスポンスの next_cursor を、from / to には YYYY-MM-DD を指定する
+  getPaymentHistory: async (params?: PaymentHistoryParams) => {
+    return api.get("/payment/history", { params });
+  },
+
+  // 領収書（receipt）または適格請求書（invoice）の PDF を取得
+  downloadReceipt: async (invoiceId: string, type: ReceiptType) => {
+    return api.get<Blob>(`/payment/invoices/${invoiceId}/receipt`, {
+      params: { type },
+      responseType: "blob",
+    });
+  },
+
+  // 宛名を変えて領収書・適格請求書を再発行（以前に発行したものは無効になる）
+  reissueReceipt: async (
+    invoiceId: string,
+    type: ReceiptType,
+    addressee: string,
+  ) => {
+    return api.post(`/payment/invoices/${invoiceId}/receipt/reissue`, {
+      type,
+      addressee,
+    });
   },
 
   // 支払い方法一覧を取得
@@ -267,11 +383,43 @@ export const paymentAPI = {
     return api.get("/subscription/status");
   },
 
+  // 利用できる機能（利用権）を取得
+  getEntitlements: async () => {
+    return api.get<Entitlements>("/entitlements");
+  },
+
   // サブスクリプションをキャンセル
   cancelSubscription: async () => {
     return api.post("/subscription/cancel");
   },
 
+  // プラン変更後の請求額をプレビュー
+  previewPlanChange: async (priceId: string) => {
+    return api.get<PlanChangePreview>("/subscription/change-plan/preview", {
+      params: { priceId },
+    });
+  },
+
+  // プランを変更（prorationToken はプレビューで返された基準日時のトークン）
+  changePlan: async (priceId: string, prorationToken?: string) => {
+    return api.post("/subscription/change-plan", { priceId, prorationToken });
+  },
+
+  // 予約中のプラン変更を取り消す
+  cancelPlanChange: async () => {
+    return api.delete("/subscription/change-plan");
+  },
+
+  // サブスクリプションを休止（resumesAt を省略すると休止できる上限まで）
+  pauseSubscription: async (resumesAt?: string) => {
+    return api.post("/subscription/pause", resumesAt ? { resumesAt } : {});
+  },
+
+  // 休止中のサブスクリプションを再開
+  resumeSubscription: async () => {
+    return api.post("/subscription/resume");
+  },
+
   // プロモーションコードを適用
   applyPromotionCode: async (code: string) => {
     return api.post("/subscription/promotion", { code });
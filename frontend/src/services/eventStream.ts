@@ -0,0 +1,182 @@
+import { getApiUrl } from "../config/env";
+import { authAPI } from "./api";
+
+// サーバーから配信されるイベントの種類
+export type StreamEventType =
+  | "announcement.published"
+  | "announcement.updated"
+  | "announcement.deleted"
+  | "subscription.updated";
+
+export interface AnnouncementEventData {
+  id: string;
+  title?: string;
+  category?: string;
+  pinned?: boolean;
+  important?: boolean;
+  publishAt?: string;
+}
+
+export interface SubscriptionEventData {
+  status: string;
+  current_period_end?: string;
+  cancel_at_period_end: boolean;
+}
+
+export type StreamEvent =
+  | {
+      type: Exclude<StreamEventType, "subscription.updated">;
+      data: AnnouncementEventData;
+    }
+  | { type: "subscription.updated"; data: SubscriptionEventData };
+
+type Listener = (event: StreamEvent) => void;
+
+const EVENT_TYPES: StreamEventType[] = [
+  "announcement.published",
+  "announcement.updated",
+  "announcement.deleted",
+  "subscription.updated",
+];
+
+const MAX_RETRY_DELAY = 60000;
+
+const listeners = new Set<Listener>();
+let controller: AbortController | null = null;
+
+// SSE のレスポンスを読み、イベント単位（空行区切り）で通知する
+// EventSource は Authorization ヘッダーを送れないため fetch のストリームで読む
+const readStream = async (
+  body: ReadableStream<Uint8Array>,
+  onRetry: (ms: number) => void,
+) => {
+  const reader = body.getReader();
+  const decoder = new TextDecoder();
+  let buffer = "";
+
+  for (;;) {
+    const { value, done } = await reader.read();
+    if (done) {
+      return;
+    }
+    buffer += decoder.decode(value, { stream: true }).replace(/\r\n?/g, "\n");
+
+    let boundary = buffer.indexOf("\n\n");
+    while (boundary !== -1) {
+      const block = buffer.slice(0, boundary);
+      buffer = buffer.slice(boundary + 2);
+      boundary = buffer.indexOf("\n\n");
+
+      let type = "";
+      const data: string[] = [];
+      for (const line of block.split("\n")) {
+        if (line.startsWith(":")) {
+          continue;
+        }
+        const separator = line.indexOf(":");
+        const field = separator === -1 ? line : line.slice(0, separator);
+        const fieldValue =
+          separator === -1 ? "" : line.slice(separator + 1).replace(/^ /, "");
+        if (field === "event") {
+          type = fieldValue;
+        } else if (field === "data") {
+          data.push(fieldValue);
+        } else if (field === "retry" && /^\d+$/.test(fieldValue)) {
+          onRetry(Number(fieldValue));
+        }
+      }
+
+      if (!EVENT_TYPES.includes(type as StreamEventType) || data.length === 0) {
+        continue;
+      }
+      try {
+        const event = {
+          type,
+          data: JSON.parse(data.join("\n")),
+        } as StreamEvent;
+        listeners.forEach((listener) => listener(event));
+      } catch {
+        // 壊れたイベントは無視する
+      }
+    }
+  }
+};
+
+const wait = (ms: number, signal: AbortSignal) =>
+  new Promise<void>((resolve) => {
+    const timer = setTimeout(resolve, ms);
+    signal.addEventListener("abort", () => {
+      clearTimeout(timer);
+      resolve();
+    });
+  });
+
+// 購読者がいる間、接続が切れても待ち時間を延ばしながら再接続する
+const run = async (signal: AbortSignal) => {
+  let retryDelay = 5000;
+  let failures = 0;
+  let refreshed = false;
+
+  while (!signal.aborted) {
+    try {
+      const token = authAPI.getAccessToken();
+      if (!token) {
+        return;
+      }
+      const response = await fetch(`${getApiUrl()}/events/stream`, {
+        headers: {
+          Authorization: `Bearer ${token}`,
+          Accept: "text/event-stream",
+        },
+        credentials: "include",
+        cache: "no-store",
+        signal,
+      });
+
+      if (response.status === 401 && !refreshed) {
+        // アクセストークンの期限切れ（サーバーも期限で接続を閉じる）
+        refreshed = true;
+        await authAPI.refreshSession();
+        continue;
+      }
+      if (!response.ok || !response.body) {
+        throw new Error(`event stream failed: ${response.status}`);
+      }
+
+      failures = 0;
+      refreshed = false;
+      await readStream(response.body, (ms) => {
+        retryDelay = ms;
+      });
+    } catch (error) {
+      if (signal.aborted) {
+        return;
+      }
+      failures += 1;
+      if (import.meta.env.MODE !== "production") {
+        console.warn("イベントストリームの接続に失敗しました", error);
+      }
+    }
+
+    const delay = Math.min(retryDelay * 2 ** failures, MAX_RETRY_DELAY);
+    await wait(delay, signal);
+  }
+};
+
+// subscribeEvents はイベントを受け取るリスナーを登録し、解除する関数を返す
+// 接続はページ内で1本だけ張り、最後のリスナーが解除されたら閉じる
+export const subscribeEvents = (listener: Listener) => {
+  listeners.add(listener);
+  if (!controller) {
+    controller = new AbortController();
+    void run(controller.signal);
+  }
+
+  return () => {
+    listeners.delete(listener);
+    if (listeners.size === 0 && controller) {
+      controller.abort();
+      controller = null;
+    }
+  };
+};
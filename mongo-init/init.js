@@ -14,11 +14,26 @@ db.users.createIndex({ email: 1 }, { unique: true });
 db.users.createIndex({ student_id: 1 }, { unique: true });
 db.users.createIndex({ created_at: -1 });
 db.users.createIndex({ role: 1 });
+db.users.createIndex({ announcement_email_opt_in: 1 }, { partialFilterExpression: { announcement_email_opt_in: true } }); // お知らせのメール通知
 
 // お知らせコレクション
 db.announcements.createIndex({ created_at: -1 });
 db.announcements.createIndex({ is_published: 1 });
+db.announcements.createIndex({ is_published: 1, publish_at: -1 }); // 公開中一覧（予約公開・掲載終了の判定）
+db.announcements.createIndex({ expires_at: 1 }, { sparse: true });
 db.announcements.createIndex({ title: "text", content: "text" });
+db.announcements.createIndex({ deleted_at: -1 }, { sparse: true }); // ゴミ箱一覧
+db.announcements.createIndex({ notify_email: 1, email_sent_at: 1 }); // メール通知の送信待ち
+
+// お知らせの既読記録
+db.announcement_reads.createIndex({ user_id: 1, announcement_id: 1 }, { unique: true });
+db.announcement_reads.createIndex({ announcement_id: 1 });
+
+// お知らせの編集履歴
+db.announcement_revisions.createIndex(
+  { announcement_id: 1, version: -1 },
+  { unique: true }
+);
 
 // 決済コレクション（セキュリティ強化版）
 db.payments.createIndex({ user_id: 1 }, { unique: true }); // IDOR防止: 1ユーザー1決済情報